@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/Elkeid/agent/agent"
+	"github.com/bytedance/Elkeid/agent/buffer"
+	"github.com/bytedance/Elkeid/agent/proto"
+	"github.com/bytedance/Elkeid/agent/transport/connection"
+	"go.uber.org/zap"
+)
+
+// 上报证书请求及下发证书使用的数据类型
+const certDataType = 1070
+
+// agent_center拒绝连接的原因，需要删除本地证书重新申请
+var certRejectedMsg = []string{
+	"the client certificate has been revoked",
+	"the AgentID does not match the client certificate",
+}
+
+// 定期检查证书，需要时通过共享证书或当前证书的连接申请新证书
+func startCertRenewal(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		requestCert()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func requestCert() {
+	if !connection.CertNeedRenew(agent.ID) {
+		return
+	}
+	csr, err := connection.NewCertRequest(agent.ID)
+	if err != nil {
+		zap.S().Error("create certificate request failed: ", err)
+		return
+	}
+	zap.S().Info("request new certificate")
+	buffer.WriteRecord(&proto.Record{
+		DataType:  certDataType,
+		Timestamp: time.Now().Unix(),
+		Data: &proto.Payload{
+			Fields: map[string]string{"csr": string(csr)},
+		},
+	})
+}
+
+func checkCertRejected(err error) {
+	for _, msg := range certRejectedMsg {
+		if strings.Contains(err.Error(), msg) {
+			zap.S().Warn("certificate is rejected, fallback to the shared certificate: ", err)
+			connection.ResetAgentCert()
+			requestCert()
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// 由manager签发的agent证书，CN为agent id，未签发前使用内置的共享证书
+const (
+	agentCertFile = "agent.crt"
+	agentKeyFile  = "agent.key"
+)
+
+var (
+	sharedCert atomic.Value //*tls.Certificate
+	agentCert  atomic.Value //*tls.Certificate
+	pendingKey atomic.Value //*ecdsa.PrivateKey
+)
+
+func init() {
+	keyPEM, err := os.ReadFile(agentKeyFile)
+	if err != nil {
+		return
+	}
+	certPEM, err := os.ReadFile(agentCertFile)
+	if err != nil {
+		return
+	}
+	if c, err := tls.X509KeyPair(certPEM, keyPEM); err == nil {
+		agentCert.Store(&c)
+	}
+}
+
+func getAgentCert() *x509.Certificate {
+	c, ok := agentCert.Load().(*tls.Certificate)
+	if !ok || c == nil || len(c.Certificate) == 0 {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// 握手时优先使用未过期的agent证书
+func getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := getAgentCert(); cert != nil && time.Now().Before(cert.NotAfter) {
+		return agentCert.Load().(*tls.Certificate), nil
+	}
+	if c, ok := sharedCert.Load().(*tls.Certificate); ok {
+		return c, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+// CertNeedRenew 没有agent证书、证书与agent id不一致或有效期剩余不足1/3时需要申请新证书
+func CertNeedRenew(agentID string) bool {
+	cert := getAgentCert()
+	if cert == nil || cert.Subject.CommonName != agentID {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return time.Until(cert.NotAfter) < lifetime/3
+}
+
+// NewCertRequest 生成新的密钥及证书请求，私钥在收到证书前只保存在内存中
+func NewCertRequest(agentID string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: agentID},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	pendingKey.Store(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// SetAgentCert 保存manager签发的证书，之后的连接使用该证书
+func SetAgentCert(certPEM []byte) error {
+	key, ok := pendingKey.Load().(*ecdsa.PrivateKey)
+	if !ok || key == nil {
+		return errors.New("no pending certificate request")
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.New("invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(&key.PublicKey) {
+		return errors.New("certificate doesn't match the pending request")
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	c, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(agentKeyFile, keyPEM); err != nil {
+		return err
+	}
+	if err = writeFile(agentCertFile, certPEM); err != nil {
+		return err
+	}
+	agentCert.Store(&c)
+	pendingKey.Store((*ecdsa.PrivateKey)(nil))
+	// 关闭当前连接，重连时使用新证书
+	if c, ok := conn.Load().(*grpc.ClientConn); ok {
+		c.Close()
+	}
+	return nil
+}
+
+// ResetAgentCert 证书被吊销或与agent id不一致时删除，回退到共享证书重新申请
+func ResetAgentCert() {
+	agentCert.Store((*tls.Certificate)(nil))
+	os.Remove(agentCertFile)
+	os.Remove(agentKeyFile)
+}
+
+func writeFile(name string, content []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
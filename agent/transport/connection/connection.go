@@ -83,11 +83,12 @@ func setDialOptions(ca, privkey, cert []byte, svrName string) {
 	certPool := x509.NewCertPool()
 	certPool.AppendCertsFromPEM(ca)
 	keyPair, _ := tls.X509KeyPair(cert, privkey)
+	sharedCert.Store(&keyPair)
 	dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-		Certificates:       []tls.Certificate{keyPair},
-		ClientAuth:         tls.RequireAndVerifyClientCert,
-		RootCAs:            certPool,
-		InsecureSkipVerify: true,
+		GetClientCertificate: getClientCertificate,
+		ClientAuth:           tls.RequireAndVerifyClientCert,
+		RootCAs:              certPool,
+		InsecureSkipVerify:   true,
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			certs := make([]*x509.Certificate, len(rawCerts))
 			for i, asn1Data := range rawCerts {
@@ -135,6 +135,7 @@ func handleReceive(ctx context.Context, wg *sync.WaitGroup, client proto.Transfe
 		cmd, err := client.Recv()
 		if err != nil {
 			zap.S().Error(err)
+			checkCertRejected(err)
 			return
 		}
 		zap.S().Info("received command")
@@ -196,6 +197,13 @@ func handleReceive(ctx context.Context, wg *sync.WaitGroup, client proto.Transfe
 							}
 						}
 					}
+				case certDataType:
+					err = connection.SetAgentCert([]byte(cmd.Task.Data))
+					if err != nil {
+						zap.S().Error("set agent certificate failed: ", err)
+					} else {
+						zap.S().Info("set agent certificate successfully")
+					}
 				case 1060:
 					zap.S().Info("will shutdown agent")
 					agent.Cancel()
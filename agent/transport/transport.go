@@ -15,8 +15,9 @@ func Startup(ctx context.Context, wg *sync.WaitGroup) {
 	defer cancel()
 	subWg := &sync.WaitGroup{}
 	defer subWg.Wait()
-	subWg.Add(2)
+	subWg.Add(3)
 	go startFileExt(subCtx, subWg)
+	go startCertRenewal(subCtx, subWg)
 	go func() {
 		startTransfer(subCtx, subWg)
 		cancel()
@@ -12,14 +12,28 @@ import (
 )
 
 var (
-	BaseLineDataType           = 8000
-	BaseLineTaskStatusDataType = 8010
-	TaskStatusSuccess          = "succeed"
-	TaskStatusFailed           = "failed"
-	CentosDefaultList          = []int{1200}
-	DebianDefaultList          = []int{1300}
-	UbuntuDefaultList          = []int{1400}
-	pluginClient               *plugins.Client
+	BaseLineDataType            = 8000
+	BaseLineTaskStatusDataType  = 8010
+	BaseLineRemediationDataType = 8020
+	TaskStatusSuccess           = "succeed"
+	TaskStatusFailed            = "failed"
+	// default baselines of distro families
+	FamilyDefaultList = map[string][]int{
+		linux.FamilyCentos:    {1200},
+		linux.FamilyDebian:    {1300},
+		linux.FamilyUbuntu:    {1400},
+		linux.FamilyRhel:      {1500},
+		linux.FamilyAmzn:      {1600},
+		linux.FamilySuse:      {1700},
+		linux.FamilyOpenEuler: {1800},
+	}
+	// default baselines of container runtimes, they're checked besides the
+	// baselines of the distro family
+	ContainerDefaultList = map[string][]int{
+		linux.RuntimeKubelet: {6000},
+		linux.RuntimeDocker:  {6100},
+	}
+	pluginClient *plugins.Client
 )
 
 func init() {
@@ -72,6 +86,34 @@ func TaskStatusSendServer(status string, token string, msg string) {
 	_ = pluginClient.SendRecord(&record)
 }
 
+// TaskDataSendServer send the data of a task with the task status, e.g. the
+// result of a dry run, it's not a baseline result of the host
+func TaskDataSendServer(taskData interface{}, token string, taskErr error) {
+	record := plugins.Record{}
+	record.DataType = int32(BaseLineTaskStatusDataType)
+	record.Timestamp = time.Now().Unix()
+
+	payload := plugins.Payload{}
+	field := make(map[string]string, 0)
+	field["status"] = TaskStatusSuccess
+	field["msg"] = ""
+	if taskErr != nil {
+		field["status"] = TaskStatusFailed
+		field["msg"] = taskErr.Error()
+	}
+	if token != "" {
+		field["token"] = token
+	}
+	dataInfo, err := json.Marshal(taskData)
+	if err == nil {
+		field["data"] = string(dataInfo)
+	}
+	payload.Fields = field
+	record.Data = &payload
+
+	_ = pluginClient.SendRecord(&record)
+}
+
 func main() {
 	go func() {
 		for {
@@ -82,6 +124,30 @@ func main() {
 				break
 			}
 			go func() {
+				// remediation of failed checks, the checks are run again after it
+				if pluginsTask.DataType == int32(BaseLineRemediationDataType) {
+					taskData, err := check.ParseTask(pluginsTask.Data)
+					if err != nil {
+						TaskStatusSendServer(TaskStatusFailed, pluginsTask.Token, err.Error())
+						return
+					}
+					remediationInfo, retBaselineInfo, remediationErr := check.Remediate(taskData)
+					if len(retBaselineInfo.CheckList) != 0 {
+						if err := SendServer(retBaselineInfo, ""); err != nil {
+							infra.Loger.Println("sendServer error:", err)
+						}
+					}
+					TaskDataSendServer(remediationInfo, pluginsTask.Token, remediationErr)
+					return
+				}
+
+				// dry run of a draft baseline
+				if taskData, err := check.ParseTask(pluginsTask.Data); err == nil && taskData.DryRun {
+					dryRunInfo, dryRunErr := check.DryRun(taskData)
+					TaskDataSendServer(dryRunInfo, pluginsTask.Token, dryRunErr)
+					return
+				}
+
 				// start baseline analysis
 				retBaselineInfo, analysisErr := check.Analysis(pluginsTask.Data)
 
@@ -117,17 +183,17 @@ func main() {
 				init = false
 			}
 
-			var baselineIdList []int
 			// start analysis by system
-			switch linux.GetSystemType() {
-			case "centos":
-				baselineIdList = CentosDefaultList
-			case "debian":
-				baselineIdList = DebianDefaultList
-			case "ubuntu":
-				baselineIdList = UbuntuDefaultList
-			default:
-				return
+			systemType := linux.GetSystemType()
+			baselineIdList, ok := FamilyDefaultList[systemType]
+			if !ok {
+				infra.Loger.Println("no baseline for system:", systemType)
+			}
+			for _, containerRuntime := range linux.GetContainerRuntimes() {
+				baselineIdList = append(baselineIdList, ContainerDefaultList[containerRuntime]...)
+			}
+			if len(baselineIdList) == 0 {
+				continue
 			}
 
 			// start analysis
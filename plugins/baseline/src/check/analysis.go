@@ -2,8 +2,10 @@ package check
 
 import (
 	"baseline/infra"
+	"baseline/src/pack"
 	"encoding/json"
 	"fmt"
+	"gopkg.in/yaml.v2"
 	"strconv"
 )
 
@@ -29,11 +31,22 @@ type RetCheckInfo struct {
 	SolutionCn    string `json:"solution_cn" bson:"solution_cn"`
 	Result        int    `json:"result" bson:"result"`
 	Msg           string `json:"msg" bson:"msg"`
+	// what the evaluated rules observed
+	Evidence []Evidence `json:"evidence" bson:"evidence"`
 }
 
 type TaskData struct {
 	BaselineId  int   `json:"baseline_id"`
 	CheckIdList []int `json:"check_id_list"`
+	// pack version to run, the pushed pack or the cached one
+	BaselineVersion string     `json:"baseline_version"`
+	Pack            *pack.Pack `json:"pack"`
+	PackUrl         string     `json:"pack_url"`
+	// run a draft pack and return the raw values of the rules, the pack isn't cached
+	DryRun bool `json:"dry_run"`
+	// remediation task: run the remediation of the checks, or roll it back
+	RemediationId string `json:"remediation_id"`
+	Rollback      bool   `json:"rollback"`
 }
 
 var (
@@ -41,7 +54,7 @@ var (
 	BaselineStatusSuccess = "success"
 )
 
-// get baselin config info
+// get baselin config info from the file shipped with the plugin
 func getBaselineConfigData(baselineId int) (baselineInfo BaselineInfo, err error) {
 
 	// bind config file
@@ -58,6 +71,51 @@ func getBaselineConfigData(baselineId int) (baselineInfo BaselineInfo, err error
 	return
 }
 
+// get the pack of a task: the pushed one, the downloaded one or the cached one
+func getBaselinePack(taskData TaskData) (*pack.Pack, error) {
+	p := taskData.Pack
+	if p == nil && taskData.PackUrl != "" {
+		var err error
+		if p, err = pack.Download(taskData.PackUrl); err != nil {
+			return nil, err
+		}
+	}
+	if p != nil {
+		if p.BaselineId != taskData.BaselineId {
+			return nil, fmt.Errorf("pack of baseline %d is pushed for %d", p.BaselineId, taskData.BaselineId)
+		}
+		if err := pack.Store(p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return pack.Load(taskData.BaselineId, taskData.BaselineVersion)
+}
+
+// get baseline config info, the packs pushed by the manager take precedence
+// over the files shipped with the plugin. A pushed pack which can't be
+// verified or is older than the cached one fails the task rather than
+// falling back, so that a missing public key or a replayed pack is reported.
+func getBaselineConfig(taskData TaskData) (baselineInfo BaselineInfo, err error) {
+	p, err := getBaselinePack(taskData)
+	if err != nil {
+		if taskData.Pack != nil || taskData.PackUrl != "" {
+			infra.Loger.Println("ERROR: baseline pack is rejected:", taskData.BaselineId, taskData.BaselineVersion, err)
+			return baselineInfo, fmt.Errorf("baseline pack %d %s is rejected: %w", taskData.BaselineId, taskData.BaselineVersion, err)
+		}
+		if err != pack.ErrNotCached {
+			infra.Loger.Println("ERROR: cached baseline pack is invalid, use the shipped config:", taskData.BaselineId, taskData.BaselineVersion, err)
+		}
+		return getBaselineConfigData(taskData.BaselineId)
+	}
+	err = yaml.Unmarshal([]byte(p.Content), &baselineInfo)
+	if err != nil {
+		return
+	}
+	baselineInfo.BaselineVersion = p.Version
+	return
+}
+
 // AnalysisBaseline start baseline task
 func AnalysisBaseline(taskData TaskData) (retBaselineInfo RetBaselineInfo, err error) {
 
@@ -66,9 +124,9 @@ func AnalysisBaseline(taskData TaskData) (retBaselineInfo RetBaselineInfo, err e
 	checkIdList := taskData.CheckIdList
 	retBaselineInfo.BaselineId = baselineId
 
-	baselineInfo, err := getBaselineConfigData(baselineId)
+	baselineInfo, err := getBaselineConfig(taskData)
 	if err != nil {
-		infra.Loger.Println("getBaselineConfigData error:", err)
+		infra.Loger.Println("getBaselineConfig error:", err)
 		return retBaselineInfo, err
 	}
 
@@ -85,58 +143,67 @@ func AnalysisBaseline(taskData TaskData) (retBaselineInfo RetBaselineInfo, err e
 				continue
 			}
 		}
-		var retcheckInfo RetCheckInfo
-		retcheckInfo.CheckId = checkInfo.CheckId
-		retcheckInfo.Security = checkInfo.Security
-		retcheckInfo.TypeCn = checkInfo.TypeCn
-		retcheckInfo.TitleCn = checkInfo.TitleCn
-		retcheckInfo.DescriptionCn = checkInfo.DescriptionCn
-		retcheckInfo.SolutionCn = checkInfo.SolutionCn
-		retcheckInfo.Type = checkInfo.Type
-		retcheckInfo.Title = checkInfo.Title
-		retcheckInfo.Description = checkInfo.Description
-		retcheckInfo.Solution = checkInfo.Solution
-		ifPass, err := AnalysisRule(checkInfo.Check)
-		if err != nil {
-			retcheckInfo.Result = ErrorCode
-			errCode, _ := strconv.Atoi(err.Error()[:2])
-			switch errCode {
-			case ErrorFile:
-				retcheckInfo.Result = ErrorFile
-				retcheckInfo.Msg = err.Error()[3:]
-			case ErrorConfigWrite:
-				retcheckInfo.Result = ErrorConfigWrite
-				retcheckInfo.Msg = err.Error()[3:]
-			default:
-				retcheckInfo.Result = ErrorCode
-				retcheckInfo.Msg = err.Error()
-			}
-		} else {
-			if ifPass {
-				retcheckInfo.Result = SuccessCode
-			} else {
-				retcheckInfo.Result = FailCode
-			}
-		}
-		retBaselineInfo.CheckList = append(retBaselineInfo.CheckList, retcheckInfo)
+		retBaselineInfo.CheckList = append(retBaselineInfo.CheckList, analysisCheck(checkInfo))
 	}
 	return retBaselineInfo, err
 }
 
-// Analysis config file
-func Analysis(data interface{}) (retBaselineInfo RetBaselineInfo, err error) {
-	var taskData TaskData
+// analysisCheck run the rules of a check
+func analysisCheck(checkInfo CheckInfo) (retcheckInfo RetCheckInfo) {
+	retcheckInfo.CheckId = checkInfo.CheckId
+	retcheckInfo.Security = checkInfo.Security
+	retcheckInfo.TypeCn = checkInfo.TypeCn
+	retcheckInfo.TitleCn = checkInfo.TitleCn
+	retcheckInfo.DescriptionCn = checkInfo.DescriptionCn
+	retcheckInfo.SolutionCn = checkInfo.SolutionCn
+	retcheckInfo.Type = checkInfo.Type
+	retcheckInfo.Title = checkInfo.Title
+	retcheckInfo.Description = checkInfo.Description
+	retcheckInfo.Solution = checkInfo.Solution
+	ifPass, evidenceList, err := AnalysisRuleEvidence(checkInfo.Check)
+	retcheckInfo.Evidence = evidenceList
+	retcheckInfo.Result, retcheckInfo.Msg = resultCode(ifPass, err)
+	return retcheckInfo
+}
+
+// result of a check and the error message by the error code
+func resultCode(ifPass bool, err error) (result int, msg string) {
+	if err != nil {
+		errCode, _ := strconv.Atoi(err.Error()[:2])
+		switch errCode {
+		case ErrorFile:
+			return ErrorFile, err.Error()[3:]
+		case ErrorConfigWrite:
+			return ErrorConfigWrite, err.Error()[3:]
+		default:
+			return ErrorCode, err.Error()
+		}
+	}
+	if ifPass {
+		return SuccessCode, ""
+	}
+	return FailCode, ""
+}
+
+// ParseTask parse the data of a task, or the baseline id of the daily task
+func ParseTask(data interface{}) (taskData TaskData, err error) {
 	switch data.(type) {
 	case int:
 		taskData.BaselineId = data.(int)
 	case string:
 		// analysis parameter
 		err = json.Unmarshal([]byte(data.(string)), &taskData)
-		if err != nil {
-			retBaselineInfo.Status = BaselineStatusError
-			retBaselineInfo.Msg = err.Error()
-			return retBaselineInfo, err
-		}
+	}
+	return
+}
+
+// Analysis config file
+func Analysis(data interface{}) (retBaselineInfo RetBaselineInfo, err error) {
+	taskData, err := ParseTask(data)
+	if err != nil {
+		retBaselineInfo.Status = BaselineStatusError
+		retBaselineInfo.Msg = err.Error()
+		return retBaselineInfo, err
 	}
 
 	// start analysis
@@ -0,0 +1,146 @@
+package check
+
+import (
+	"baseline/src/pack"
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const redactedValue = "[redacted]"
+
+// sensitivePaths the content of these files is never returned by dry run,
+// only whether the rule passes
+var sensitivePaths = []string{
+	"/etc/shadow",
+	"/etc/gshadow",
+	"/etc/security/opasswd",
+	"/etc/ssh/ssh_host_",
+	"/.ssh/",
+	"/etc/ssl/private/",
+	"/etc/krb5.keytab",
+}
+
+// contentRuleTypes the rules whose value is the content of a file or the output of a command
+var contentRuleTypes = map[string]bool{
+	"command_check":    true,
+	"file_line_check":  true,
+	"config_key_check": true,
+}
+
+// redactValue hide the value of a rule which reads a sensitive file
+func redactValue(rule RuleStruct, value interface{}) interface{} {
+	if value == nil || !contentRuleTypes[rule.Type] {
+		return value
+	}
+	for _, param := range rule.Param {
+		for _, path := range sensitivePaths {
+			if strings.Contains(param, path) {
+				return redactedValue
+			}
+		}
+	}
+	return value
+}
+
+// DryRunRule the raw value of a rule
+type DryRunRule struct {
+	Type   string      `json:"type"`
+	Param  []string    `json:"param"`
+	Filter string      `json:"filter"`
+	Expect interface{} `json:"expect"`
+	Value  interface{} `json:"value"`
+	Pass   bool        `json:"pass"`
+	Msg    string      `json:"msg"`
+}
+
+// DryRunCheck the result of a check and all of its rules
+type DryRunCheck struct {
+	CheckId int          `json:"check_id"`
+	Title   string       `json:"title"`
+	Result  int          `json:"result"`
+	Msg     string       `json:"msg"`
+	Rules   []DryRunRule `json:"rules"`
+}
+
+type DryRunInfo struct {
+	BaselineId      int           `json:"baseline_id"`
+	BaselineVersion string        `json:"baseline_version"`
+	CheckList       []DryRunCheck `json:"check_list"`
+}
+
+// DryRun runs the draft pack of a task, all of the rules are run rather than
+// stopping by the condition so that each raw value is returned
+func DryRun(taskData TaskData) (dryRunInfo DryRunInfo, err error) {
+	dryRunInfo.BaselineId = taskData.BaselineId
+	p := taskData.Pack
+	if p == nil {
+		return dryRunInfo, errors.New("no pack of dry run")
+	}
+	key, err := pack.PublicKey()
+	if err != nil {
+		return dryRunInfo, err
+	}
+	if err = p.Verify(key); err != nil {
+		return dryRunInfo, err
+	}
+	var baselineInfo BaselineInfo
+	if err = yaml.Unmarshal([]byte(p.Content), &baselineInfo); err != nil {
+		return dryRunInfo, err
+	}
+	dryRunInfo.BaselineVersion = p.Version
+
+	taskCheckIdMap := make(map[int]bool)
+	for _, checkId := range taskData.CheckIdList {
+		taskCheckIdMap[checkId] = true
+	}
+	for _, checkInfo := range baselineInfo.CheckList {
+		if len(taskCheckIdMap) != 0 && !taskCheckIdMap[checkInfo.CheckId] {
+			continue
+		}
+		dryRunCheck := DryRunCheck{CheckId: checkInfo.CheckId, Title: checkInfo.Title}
+		passList := make([]bool, 0, len(checkInfo.Check.Rules))
+		var checkErr error
+		for _, rule := range checkInfo.Check.Rules {
+			ifPass, value, err := CheckRuleValue(rule)
+			dryRunRule := DryRunRule{
+				Type:   rule.Type,
+				Param:  rule.Param,
+				Filter: rule.Filter,
+				Expect: rule.Result,
+				Value:  redactValue(rule, value),
+				Pass:   ifPass,
+			}
+			if err != nil {
+				dryRunRule.Msg = err.Error()
+				if checkErr == nil {
+					checkErr = err
+				}
+			}
+			passList = append(passList, ifPass)
+			dryRunCheck.Rules = append(dryRunCheck.Rules, dryRunRule)
+		}
+		dryRunCheck.Result, dryRunCheck.Msg = resultCode(conditionPass(checkInfo.Check.Condition, passList), checkErr)
+		dryRunInfo.CheckList = append(dryRunInfo.CheckList, dryRunCheck)
+	}
+	return dryRunInfo, nil
+}
+
+// conditionPass the result of a check by the results of all of its rules, like AnalysisRule
+func conditionPass(condition string, passList []bool) bool {
+	passNum := 0
+	for _, ifPass := range passList {
+		if ifPass {
+			passNum++
+		}
+	}
+	switch condition {
+	case "any":
+		return passNum > 0
+	case "none":
+		return passNum == 0
+	default:
+		return passNum == len(passList)
+	}
+}
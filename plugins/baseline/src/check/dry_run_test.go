@@ -0,0 +1,46 @@
+package check
+
+import "testing"
+
+func TestRedactValue(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleStruct
+		want interface{}
+	}{
+		{"shadow line", RuleStruct{Type: "file_line_check", Param: []string{"/etc/shadow", ":"}}, redactedValue},
+		{"shadow command", RuleStruct{Type: "command_check", Param: []string{"grep root /etc/shadow"}}, redactedValue},
+		{"host key", RuleStruct{Type: "config_key_check", Param: []string{"/etc/ssh/ssh_host_rsa_key", "x"}}, redactedValue},
+		{"authorized keys", RuleStruct{Type: "file_line_check", Param: []string{"/root/.ssh/authorized_keys"}}, redactedValue},
+		{"passwd", RuleStruct{Type: "file_line_check", Param: []string{"/etc/passwd", ":"}}, "value"},
+		{"shadow permission", RuleStruct{Type: "file_permission", Param: []string{"/etc/shadow", "640"}}, "value"},
+	}
+	for _, tt := range tests {
+		if got := redactValue(tt.rule, "value"); got != tt.want {
+			t.Errorf("%s: redactValue() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := redactValue(RuleStruct{Type: "command_check", Param: []string{"cat /etc/shadow"}}, nil); got != nil {
+		t.Errorf("nil value: redactValue() = %v, want nil", got)
+	}
+}
+
+func TestEvidenceRedacted(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  RuleStruct
+		value string
+		line  string
+	}{
+		{"shadow line", RuleStruct{Type: "file_line_check", Param: []string{"/etc/shadow", ":"}, Filter: `^root:([^:]*)`}, redactedValue, redactedValue},
+		{"authorized keys", RuleStruct{Type: "file_line_check", Param: []string{"/root/.ssh/authorized_keys"}}, redactedValue, redactedValue},
+		{"shadow command", RuleStruct{Type: "command_check", Param: []string{"grep root /etc/shadow"}}, redactedValue, redactedValue},
+		{"passwd line", RuleStruct{Type: "file_line_check", Param: []string{"/etc/passwd", ":"}, Filter: `^root:([^:]*)`}, "$6$salt$hash", "root:$6$salt$hash:19000:0:99999:7:::"},
+	}
+	for _, tt := range tests {
+		evidence := NewEvidence(tt.rule, false, "root:$6$salt$hash:19000:0:99999:7:::\n")
+		if evidence.Value != tt.value || evidence.Line != tt.line {
+			t.Errorf("%s: unexpected evidence %+v", tt.name, evidence)
+		}
+	}
+}
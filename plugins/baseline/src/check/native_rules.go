@@ -0,0 +1,489 @@
+package check
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+/* Native rules read the state of the host directly, or run a command with
+its own arguments rather than splitting a command line on spaces.
+A missing setting is returned as false or "", so that the rule fails rather
+than it's reported as a wrong configuration.
+*/
+
+// the param which makes a rule pass when its target doesn't exist
+const ignoreMissing = "ignore_missing"
+
+var (
+	procSysDir = "/proc/sys"
+	mountsFile = "/proc/self/mounts"
+	lookPath   = exec.LookPath
+	// runCommand runs a command, err is only returned when it can't be run
+	runCommand = func(name string, arg ...string) (out string, exitCode int, err error) {
+		buf, err := exec.Command(name, arg...).Output()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return string(buf), exitErr.ExitCode(), nil
+		}
+		return string(buf), 0, err
+	}
+	pamTypeList = map[string]bool{"auth": true, "account": true, "password": true, "session": true}
+)
+
+// the rules which return a setting, a filter which doesn't hit means the
+// setting is absent
+var nativeValueRules = map[string]bool{
+	"sysctl_check":       true,
+	"systemd_unit_check": true,
+	"pam_check":          true,
+	"sshd_config_check":  true,
+	"config_key_check":   true,
+}
+
+func paramNumError(ruleType string) error {
+	return fmt.Errorf("%d:%s params num error", ErrorConfigWrite, ruleType)
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// SysctlCheck Get the value of a kernel parameter, the values of a parameter
+// with several values are separated by a space
+// 1. Key, e.g. kernel.randomize_va_space 2. ignore_missing (optional)
+func SysctlCheck(param []string) (result interface{}, err error) {
+	if len(param) < 1 || len(param) > 2 {
+		return "", paramNumError("sysctl_check")
+	}
+	key := param[0]
+	if key == "" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("%d:invalid sysctl key %s", ErrorConfigWrite, key)
+	}
+	// the keys with a dot in a name, e.g. a vlan, are written with /
+	if !strings.Contains(key, "/") {
+		key = strings.Replace(key, ".", "/", -1)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(procSysDir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return len(param) == 2 && param[1] == ignoreMissing, nil
+		}
+		return "", fmt.Errorf("%d:read sysctl %s error %s", ErrorFile, param[0], err.Error())
+	}
+	return strings.Join(strings.Fields(string(content)), " "), nil
+}
+
+// SystemdUnitCheck Get the state of a systemd unit, e.g. enabled, disabled,
+// masked, active, inactive, or not-found if the unit doesn't exist
+// 1. Unit 2. enabled or active
+func SystemdUnitCheck(param []string) (result interface{}, err error) {
+	if len(param) != 2 {
+		return "", paramNumError("systemd_unit_check")
+	}
+	var verb string
+	switch param[1] {
+	case "enabled":
+		verb = "is-enabled"
+	case "active":
+		verb = "is-active"
+	default:
+		return "", fmt.Errorf("%d:systemd_unit_check can only check enabled or active", ErrorConfigWrite)
+	}
+	out, _, err := runCommand("systemctl", verb, param[0])
+	if err != nil {
+		// no systemd
+		return false, nil
+	}
+	state := firstLine(out)
+	if state == "" {
+		state = "not-found"
+	}
+	return state, nil
+}
+
+// PackageCheck Determine whether a package is installed by dpkg or rpm
+// 1. Package name
+// version: the installed version
+func PackageCheck(param []string) (result bool, version string, err error) {
+	if len(param) != 1 {
+		return false, "", paramNumError("package_check")
+	}
+	name := param[0]
+	if _, err := lookPath("dpkg-query"); err == nil {
+		out, exitCode, err := runCommand("dpkg-query", "-W", "-f=${Status} ${Version}\n", name)
+		if err != nil || exitCode != 0 {
+			return false, "", nil
+		}
+		for _, line := range strings.Split(out, "\n") {
+			// install ok installed 1:4.8.1-1
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[2] == "installed" {
+				if len(fields) >= 4 {
+					version = fields[3]
+				}
+				return true, version, nil
+			}
+		}
+		return false, "", nil
+	}
+	if _, err := lookPath("rpm"); err == nil {
+		out, exitCode, err := runCommand("rpm", "-q", "--qf", "%{VERSION}-%{RELEASE}\n", name)
+		if err != nil || exitCode != 0 {
+			return false, "", nil
+		}
+		return true, firstLine(out), nil
+	}
+	return false, "", fmt.Errorf("%d:neither dpkg nor rpm is found", ErrorCode)
+}
+
+// unescape the octal escapes of /proc/self/mounts, e.g. \040 of a space
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			valid := true
+			for _, d := range s[i+1 : i+4] {
+				if d < '0' || d > '7' {
+					valid = false
+					break
+				}
+				c = c*8 + byte(d-'0')
+			}
+			if valid {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// MountOptionCheck Determine whether a mountpoint is mounted with the options
+// 1. Mountpoint 2. Options separated by commas, all of them are needed
+// 3. ignore_missing (optional), pass if the mountpoint isn't mounted
+// options: the real options of the mountpoint, "" if it isn't mounted
+func MountOptionCheck(param []string) (result bool, options string, err error) {
+	if len(param) < 2 || len(param) > 3 {
+		return false, "", paramNumError("mount_option_check")
+	}
+	file, err := os.Open(mountsFile)
+	if err != nil {
+		return false, "", fmt.Errorf("%d:open file %s Error %s", ErrorFile, mountsFile, err.Error())
+	}
+	defer file.Close()
+
+	// the last one is used if a mountpoint is mounted over
+	mounted := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || unescapeMount(fields[1]) != param[0] {
+			continue
+		}
+		mounted = true
+		options = fields[3]
+	}
+	if !mounted {
+		return len(param) == 3 && param[2] == ignoreMissing, "", nil
+	}
+
+	optionSet := make(map[string]bool)
+	for _, option := range strings.Split(options, ",") {
+		optionSet[option] = true
+	}
+	for _, option := range strings.Split(param[1], ",") {
+		if !optionSet[strings.TrimSpace(option)] {
+			return false, options, nil
+		}
+	}
+	return true, options, nil
+}
+
+// the max depth of the includes of a pam stack
+const maxPamDepth = 8
+
+// an entry of a pam stack
+type pamEntry struct {
+	module string
+	// type control module args
+	line string
+}
+
+// pamStack the entries of a type of a pam file, the included files are expanded
+func pamStack(filePath, pamType string, depth int) ([]pamEntry, error) {
+	if depth > maxPamDepth {
+		return nil, fmt.Errorf("%d:pam include of %s is too deep", ErrorConfigWrite, filePath)
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%d:open file %s Error %s", ErrorFile, filePath, err.Error())
+	}
+	dir := filepath.Dir(filePath)
+	includePath := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	var entries []pamEntry
+	text := strings.Replace(string(content), "\\\n", " ", -1)
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "@include" {
+			if len(fields) < 2 {
+				continue
+			}
+			subEntries, err := pamStack(includePath(fields[1]), pamType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, subEntries...)
+			continue
+		}
+		if strings.TrimPrefix(fields[0], "-") != pamType || len(fields) < 3 {
+			continue
+		}
+
+		// a control like [success=1 default=ignore] has spaces
+		control := fields[1]
+		i := 2
+		if strings.HasPrefix(control, "[") {
+			for !strings.HasSuffix(control, "]") && i < len(fields) {
+				control += " " + fields[i]
+				i++
+			}
+		}
+		if i >= len(fields) {
+			continue
+		}
+		module := fields[i]
+		if control == "include" || control == "substack" {
+			subEntries, err := pamStack(includePath(module), pamType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, subEntries...)
+			continue
+		}
+		entries = append(entries, pamEntry{
+			module: module,
+			line:   strings.Join(append([]string{pamType, control}, fields[i:]...), " "),
+		})
+	}
+	return entries, nil
+}
+
+// PamCheck Get the pam stack of a type, one entry per line, the included
+// files are expanded. false if no entry is found
+// 1. The absolute path of the pam file 2. Type: auth, account, password or session
+// 3. Modules separated by | (optional), only the entries of the modules are returned
+func PamCheck(param []string) (result interface{}, err error) {
+	if len(param) < 2 || len(param) > 3 {
+		return "", paramNumError("pam_check")
+	}
+	if !pamTypeList[param[1]] {
+		return "", fmt.Errorf("%d:unknown pam type %s", ErrorConfigWrite, param[1])
+	}
+	entries, err := pamStack(param[0], param[1], 0)
+	if err != nil {
+		return "", err
+	}
+
+	var moduleSet map[string]bool
+	if len(param) == 3 && param[2] != "" {
+		moduleSet = make(map[string]bool)
+		for _, module := range strings.Split(param[2], "|") {
+			moduleSet[strings.TrimSpace(module)] = true
+		}
+	}
+	var lines []string
+	for _, entry := range entries {
+		if moduleSet == nil || moduleSet[filepath.Base(entry.module)] {
+			lines = append(lines, entry.line)
+		}
+	}
+	if len(lines) == 0 {
+		return false, nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// SshdConfigCheck Get an effective setting of sshd by sshd -T, the values of
+// a key which appears several times are separated by lines. false if sshd
+// isn't installed or its configuration is wrong
+// 1. Key, case insensitive 2. Connection spec of -C (optional), e.g. user=root,host=localhost,addr=127.0.0.1
+func SshdConfigCheck(param []string) (result interface{}, err error) {
+	if len(param) < 1 || len(param) > 2 {
+		return "", paramNumError("sshd_config_check")
+	}
+	sshd, err := lookPath("sshd")
+	if err != nil {
+		sshd = "/usr/sbin/sshd"
+		if _, err := os.Stat(sshd); err != nil {
+			return false, nil
+		}
+	}
+	arg := []string{"-T"}
+	if len(param) == 2 && param[1] != "" {
+		arg = append(arg, "-C", param[1])
+	}
+	out, exitCode, err := runCommand(sshd, arg...)
+	if err != nil || exitCode != 0 {
+		return false, nil
+	}
+
+	key := strings.ToLower(param[0])
+	var values []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) == 2 && strings.ToLower(fields[0]) == key {
+			values = append(values, strings.TrimSpace(fields[1]))
+		}
+	}
+	return strings.Join(values, "\n"), nil
+}
+
+// the value of a key of a config file, the items of a list and the keys of a
+// map are separated by lines
+func configValue(value interface{}) string {
+	var lines []string
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		for _, item := range v {
+			lines = append(lines, configValue(item))
+		}
+	case map[string]interface{}:
+		for key := range v {
+			lines = append(lines, key)
+		}
+		sort.Strings(lines)
+	case map[interface{}]interface{}:
+		for key := range v {
+			lines = append(lines, fmt.Sprint(key))
+		}
+		sort.Strings(lines)
+	default:
+		return fmt.Sprint(v)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ConfigKeyCheck Get the value of a key of a json or yaml config file, e.g.
+// daemon.json of dockerd or the config of kubelet. false if the file or the
+// key doesn't exist, or the file can't be parsed
+// 1. The absolute path of the file, it's parsed as json if its name ends with .json
+// 2. Key, the keys of nested maps are separated by dots, e.g. authentication.anonymous.enabled
+// 3. ignore_missing (optional), pass if the file or the key doesn't exist
+func ConfigKeyCheck(param []string) (result interface{}, err error) {
+	if len(param) < 2 || len(param) > 3 {
+		return "", paramNumError("config_key_check")
+	}
+	missing := len(param) == 3 && param[2] == ignoreMissing
+	content, err := ioutil.ReadFile(param[0])
+	if err != nil {
+		if os.IsNotExist(err) {
+			return missing, nil
+		}
+		return "", fmt.Errorf("%d:open file %s Error %s", ErrorFile, param[0], err.Error())
+	}
+
+	var config interface{}
+	if strings.HasSuffix(param[0], ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		// keep the numbers as they are written
+		decoder.UseNumber()
+		err = decoder.Decode(&config)
+	} else {
+		err = yaml.Unmarshal(content, &config)
+	}
+	if err != nil {
+		return false, nil
+	}
+
+	value := config
+	for _, key := range strings.Split(param[1], ".") {
+		ok := false
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value, ok = m[key]
+		case map[interface{}]interface{}:
+			value, ok = m[key]
+		}
+		if !ok {
+			return missing, nil
+		}
+	}
+	return configValue(value), nil
+}
+
+// FileModeCheck Determine whether the files have no permission beyond the mode,
+// i.e. their modes are the mode or more restrictive
+// 1. The absolute path of the file, or a glob pattern of several files, e.g. /etc/kubernetes/pki/*.key
+// 2. Max mode, octal, e.g. 600 3. ignore_missing (optional), pass if no file is found
+// modes: "file mode" of the files beyond the mode one per line, or of all the files if it passes
+func FileModeCheck(param []string) (result bool, modes string, err error) {
+	if len(param) < 2 || len(param) > 3 {
+		return false, "", paramNumError("file_mode_check")
+	}
+	maxMode, err := strconv.ParseUint(param[1], 8, 32)
+	if err != nil || maxMode > 0777 {
+		return false, "", fmt.Errorf("%d:invalid file mode %s", ErrorConfigWrite, param[1])
+	}
+	fileList, err := filepath.Glob(param[0])
+	if err != nil {
+		return false, "", fmt.Errorf("%d:invalid file pattern %s", ErrorConfigWrite, param[0])
+	}
+
+	var allLines, failedLines []string
+	for _, file := range fileList {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		mode := uint64(info.Mode().Perm())
+		line := fmt.Sprintf("%s %o", file, mode)
+		allLines = append(allLines, line)
+		if mode&^maxMode != 0 {
+			failedLines = append(failedLines, line)
+		}
+	}
+	if len(allLines) == 0 {
+		return len(param) == 3 && param[2] == ignoreMissing, "", nil
+	}
+	if len(failedLines) != 0 {
+		return false, strings.Join(failedLines, "\n"), nil
+	}
+	return true, strings.Join(allLines, "\n"), nil
+}
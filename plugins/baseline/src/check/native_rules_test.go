@@ -0,0 +1,325 @@
+package check
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeCommand replaces runCommand and lookPath, outputs are keyed by the command line
+func fakeCommand(t *testing.T, outputs map[string]string, exitCodes map[string]int) {
+	t.Helper()
+	oldRun, oldLook := runCommand, lookPath
+	t.Cleanup(func() {
+		runCommand, lookPath = oldRun, oldLook
+	})
+	runCommand = func(name string, arg ...string) (string, int, error) {
+		cmd := strings.Join(append([]string{filepath.Base(name)}, arg...), " ")
+		out, ok := outputs[cmd]
+		if !ok {
+			return "", 0, errors.New("not found")
+		}
+		return out, exitCodes[cmd], nil
+	}
+	lookPath = func(file string) (string, error) {
+		for cmd := range outputs {
+			if strings.HasPrefix(cmd, file+" ") {
+				return "/usr/bin/" + file, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+}
+
+func checkRule(t *testing.T, name string, rule RuleStruct, want bool) {
+	t.Helper()
+	ifPass, err := CheckRule(rule)
+	if err != nil {
+		t.Errorf("%s: unexpected error %v", name, err)
+	}
+	if ifPass != want {
+		t.Errorf("%s: want %v, get %v", name, want, ifPass)
+	}
+}
+
+func TestSysctlCheck(t *testing.T) {
+	dir := t.TempDir()
+	old := procSysDir
+	procSysDir = dir
+	defer func() { procSysDir = old }()
+	writeFile(t, filepath.Join(dir, "kernel/randomize_va_space"), "2\n")
+	writeFile(t, filepath.Join(dir, "net/ipv4/ip_local_port_range"), "32768\t60999\n")
+	writeFile(t, filepath.Join(dir, "net/ipv4/conf/eth0.100/rp_filter"), "1\n")
+
+	value, err := SysctlCheck([]string{"net.ipv4.ip_local_port_range"})
+	if err != nil || value != "32768 60999" {
+		t.Errorf("want 32768 60999, get %v %v", value, err)
+	}
+	if _, err := SysctlCheck([]string{"../../etc/passwd"}); err == nil {
+		t.Error("want an error of the key with ..")
+	}
+
+	checkRule(t, "int", RuleStruct{Type: "sysctl_check", Param: []string{"kernel.randomize_va_space"}, Result: 2}, true)
+	checkRule(t, "int mismatch", RuleStruct{Type: "sysctl_check", Param: []string{"kernel.randomize_va_space"}, Result: 1}, false)
+	checkRule(t, "expression", RuleStruct{Type: "sysctl_check", Param: []string{"net.ipv4.ip_local_port_range"},
+		Filter: `^(\d+)`, Result: "$(>=)32768"}, true)
+	checkRule(t, "path key", RuleStruct{Type: "sysctl_check", Param: []string{"net/ipv4/conf/eth0.100/rp_filter"}, Result: 1}, true)
+	checkRule(t, "missing", RuleStruct{Type: "sysctl_check", Param: []string{"net.ipv6.conf.all.accept_ra"}, Result: 0}, false)
+	checkRule(t, "ignore missing", RuleStruct{Type: "sysctl_check", Param: []string{"net.ipv6.conf.all.accept_ra", "ignore_missing"}, Result: 0}, true)
+}
+
+func TestSystemdUnitCheck(t *testing.T) {
+	fakeCommand(t, map[string]string{
+		"systemctl is-enabled auditd":              "enabled\n",
+		"systemctl is-active auditd":               "active\n",
+		"systemctl is-enabled ctrl-alt-del.target": "masked\n",
+		"systemctl is-enabled rsyslog":             "disabled\n",
+		"systemctl is-active rsyslog":              "inactive\n",
+		"systemctl is-enabled telnet.socket":       "",
+	}, map[string]int{
+		"systemctl is-enabled ctrl-alt-del.target": 1,
+		"systemctl is-enabled rsyslog":             1,
+		"systemctl is-active rsyslog":              3,
+		"systemctl is-enabled telnet.socket":       1,
+	})
+
+	checkRule(t, "enabled", RuleStruct{Type: "systemd_unit_check", Param: []string{"auditd", "enabled"}, Result: "^enabled$"}, true)
+	checkRule(t, "active", RuleStruct{Type: "systemd_unit_check", Param: []string{"auditd", "active"}, Result: "^active$"}, true)
+	checkRule(t, "masked", RuleStruct{Type: "systemd_unit_check", Param: []string{"ctrl-alt-del.target", "enabled"}, Result: "^masked$"}, true)
+	checkRule(t, "disabled", RuleStruct{Type: "systemd_unit_check", Param: []string{"rsyslog", "enabled"}, Result: "^enabled$"}, false)
+	checkRule(t, "inactive", RuleStruct{Type: "systemd_unit_check", Param: []string{"rsyslog", "active"}, Result: "^active$"}, false)
+	checkRule(t, "not found", RuleStruct{Type: "systemd_unit_check", Param: []string{"telnet.socket", "enabled"}, Result: "^(disabled|masked|not-found)$"}, true)
+	if _, err := SystemdUnitCheck([]string{"auditd", "running"}); err == nil {
+		t.Error("want an error of the unknown state")
+	}
+
+	// no systemd
+	fakeCommand(t, map[string]string{}, nil)
+	checkRule(t, "no systemd", RuleStruct{Type: "systemd_unit_check", Param: []string{"auditd", "enabled"}, Result: "^enabled$"}, false)
+}
+
+func TestPackageCheck(t *testing.T) {
+	fakeCommand(t, map[string]string{
+		"dpkg-query -W -f=${Status} ${Version}\n libpam-pwquality": "install ok installed 1.4.2-1build1\n",
+		"dpkg-query -W -f=${Status} ${Version}\n telnet":           "deinstall ok config-files 0.17-41\n",
+		"dpkg-query -W -f=${Status} ${Version}\n rsh-client":       "",
+	}, map[string]int{
+		"dpkg-query -W -f=${Status} ${Version}\n rsh-client": 1,
+	})
+	ok, version, err := PackageCheck([]string{"libpam-pwquality"})
+	if !ok || version != "1.4.2-1build1" || err != nil {
+		t.Errorf("want installed 1.4.2-1build1, get %v %s %v", ok, version, err)
+	}
+	checkRule(t, "dpkg config files", RuleStruct{Type: "package_check", Param: []string{"telnet"}, Result: false}, true)
+	checkRule(t, "dpkg absent", RuleStruct{Type: "package_check", Param: []string{"rsh-client"}, Result: false}, true)
+
+	fakeCommand(t, map[string]string{
+		"rpm -q --qf %{VERSION}-%{RELEASE}\n audit":  "3.0.7-103.el9\n",
+		"rpm -q --qf %{VERSION}-%{RELEASE}\n telnet": "package telnet is not installed\n",
+	}, map[string]int{
+		"rpm -q --qf %{VERSION}-%{RELEASE}\n telnet": 1,
+	})
+	ok, version, err = PackageCheck([]string{"audit"})
+	if !ok || version != "3.0.7-103.el9" || err != nil {
+		t.Errorf("want installed 3.0.7-103.el9, get %v %s %v", ok, version, err)
+	}
+	checkRule(t, "rpm installed", RuleStruct{Type: "package_check", Param: []string{"audit"}}, true)
+	checkRule(t, "rpm absent", RuleStruct{Type: "package_check", Param: []string{"telnet"}, Result: false}, true)
+
+	fakeCommand(t, map[string]string{}, nil)
+	if _, _, err := PackageCheck([]string{"audit"}); err == nil {
+		t.Error("want an error without a package manager")
+	}
+}
+
+func TestMountOptionCheck(t *testing.T) {
+	dir := t.TempDir()
+	old := mountsFile
+	mountsFile = filepath.Join(dir, "mounts")
+	defer func() { mountsFile = old }()
+	writeFile(t, mountsFile, `/dev/sda1 / ext4 rw,relatime 0 0
+tmpfs /dev/shm tmpfs rw,nosuid,nodev 0 0
+tmpfs /tmp tmpfs rw,nosuid,nodev,noexec 0 0
+tmpfs /tmp tmpfs rw,nosuid 0 0
+/dev/sdb1 /mnt/my\040disk ext4 rw,nodev 0 0
+`)
+
+	ok, options, err := MountOptionCheck([]string{"/dev/shm", "nodev,nosuid"})
+	if !ok || options != "rw,nosuid,nodev" || err != nil {
+		t.Errorf("want rw,nosuid,nodev, get %v %s %v", ok, options, err)
+	}
+	checkRule(t, "missing option", RuleStruct{Type: "mount_option_check", Param: []string{"/dev/shm", "nodev,nosuid,noexec"}}, false)
+	checkRule(t, "mounted over", RuleStruct{Type: "mount_option_check", Param: []string{"/tmp", "nodev"}}, false)
+	checkRule(t, "escaped", RuleStruct{Type: "mount_option_check", Param: []string{"/mnt/my disk", "nodev"}}, true)
+	checkRule(t, "absent option", RuleStruct{Type: "mount_option_check", Param: []string{"/", "noexec"}, Result: false}, true)
+	checkRule(t, "not mounted", RuleStruct{Type: "mount_option_check", Param: []string{"/var/tmp", "nodev"}}, false)
+	checkRule(t, "ignore missing", RuleStruct{Type: "mount_option_check", Param: []string{"/var/tmp", "nodev", "ignore_missing"}}, true)
+}
+
+func TestPamCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "common-password"), `# comment
+password	requisite			pam_pwquality.so retry=3 minlen=14
+password	[success=1 default=ignore]	pam_unix.so obscure use_authtok \
+	try_first_pass yescrypt remember=5
+password	requisite			pam_deny.so
+password	required			pam_permit.so
+`)
+	writeFile(t, filepath.Join(dir, "passwd"), `@include common-auth
+@include common-password
+`)
+	writeFile(t, filepath.Join(dir, "system-auth"), `auth        required      pam_env.so
+-auth       sufficient    pam_sss.so forward_pass
+password    requisite     pam_pwquality.so local_users_only
+password    required      pam_pwhistory.so use_authtok remember=4
+password    sufficient    /usr/lib64/security/pam_unix.so sha512 shadow
+`)
+	writeFile(t, filepath.Join(dir, "sshd"), `auth       substack     system-auth
+auth       include      postlogin
+password   include      system-auth
+`)
+	writeFile(t, filepath.Join(dir, "loop"), "auth include loop\n")
+
+	value, err := PamCheck([]string{filepath.Join(dir, "passwd"), "password", "pam_unix.so"})
+	if err != nil || value != "password [success=1 default=ignore] pam_unix.so obscure use_authtok try_first_pass yescrypt remember=5" {
+		t.Errorf("unexpected entry %v %v", value, err)
+	}
+	value, err = PamCheck([]string{filepath.Join(dir, "sshd"), "auth"})
+	if err != nil || value != "auth required pam_env.so\nauth sufficient pam_sss.so forward_pass" {
+		t.Errorf("unexpected auth stack %q %v", value, err)
+	}
+
+	checkRule(t, "include", RuleStruct{Type: "pam_check", Param: []string{filepath.Join(dir, "passwd"), "password", "pam_pwquality.so|pam_cracklib.so"},
+		Filter: `minlen=(\d+)`, Result: "$(>=)14"}, true)
+	checkRule(t, "include and path", RuleStruct{Type: "pam_check", Param: []string{filepath.Join(dir, "sshd"), "password", "pam_pwhistory.so|pam_unix.so"},
+		Filter: `remember=(\d+)`, Result: "$(>=)5"}, false)
+	checkRule(t, "absent option", RuleStruct{Type: "pam_check", Param: []string{filepath.Join(dir, "system-auth"), "password", "pam_unix.so"},
+		Filter: `remember=(\d+)`, Result: "$(>=)5"}, false)
+	checkRule(t, "order", RuleStruct{Type: "pam_check", Param: []string{filepath.Join(dir, "system-auth"), "password"},
+		Result: `pam_pwhistory\.so(?s:.*)pam_unix\.so`}, true)
+	checkRule(t, "absent module", RuleStruct{Type: "pam_check", Param: []string{filepath.Join(dir, "common-password"), "password", "pam_cracklib.so"},
+		Result: "minlen"}, false)
+	checkRule(t, "absent file", RuleStruct{Type: "pam_check", Param: []string{filepath.Join(dir, "none"), "password"}, Result: "pam_unix"}, false)
+
+	if _, err := PamCheck([]string{filepath.Join(dir, "loop"), "auth"}); err == nil {
+		t.Error("want an error of the include loop")
+	}
+	if _, err := PamCheck([]string{filepath.Join(dir, "passwd"), "login"}); err == nil {
+		t.Error("want an error of the unknown type")
+	}
+}
+
+func TestSshdConfigCheck(t *testing.T) {
+	fakeCommand(t, map[string]string{
+		"sshd -T": `port 22
+permitrootlogin without-password
+maxauthtries 6
+loglevel INFO
+clientaliveinterval 300
+hostkey /etc/ssh/ssh_host_rsa_key
+hostkey /etc/ssh/ssh_host_ed25519_key
+`,
+		"sshd -T -C user=root,host=localhost,addr=127.0.0.1": "permitrootlogin no\n",
+	}, nil)
+
+	value, err := SshdConfigCheck([]string{"HostKey"})
+	if err != nil || value != "/etc/ssh/ssh_host_rsa_key\n/etc/ssh/ssh_host_ed25519_key" {
+		t.Errorf("unexpected hostkey %q %v", value, err)
+	}
+	checkRule(t, "regex", RuleStruct{Type: "sshd_config_check", Param: []string{"LogLevel"}, Result: "^(INFO|VERBOSE)$"}, true)
+	checkRule(t, "number", RuleStruct{Type: "sshd_config_check", Param: []string{"MaxAuthTries"}, Filter: `^(\d+)$`, Result: "$(<=)4"}, false)
+	checkRule(t, "range", RuleStruct{Type: "sshd_config_check", Param: []string{"ClientAliveInterval"}, Filter: `^(\d+)$`, Result: "$(>)0$(&&)$(<=)900"}, true)
+	checkRule(t, "absent", RuleStruct{Type: "sshd_config_check", Param: []string{"ClientAliveCountMax"}, Filter: `^(\d+)$`, Result: "$(<=)3"}, false)
+	checkRule(t, "not", RuleStruct{Type: "sshd_config_check", Param: []string{"PermitRootLogin"}, Result: "$(not)^yes$"}, true)
+	checkRule(t, "match", RuleStruct{Type: "sshd_config_check", Param: []string{"PermitRootLogin", "user=root,host=localhost,addr=127.0.0.1"}, Result: "^no$"}, true)
+
+	// sshd isn't installed
+	fakeCommand(t, map[string]string{}, nil)
+	if _, err := os.Stat("/usr/sbin/sshd"); err != nil {
+		checkRule(t, "no sshd", RuleStruct{Type: "sshd_config_check", Param: []string{"LogLevel"}, Result: "^INFO$"}, false)
+	}
+}
+
+func TestNativeRuleEvidence(t *testing.T) {
+	fakeCommand(t, map[string]string{"sshd -T": "maxauthtries 6\nloglevel INFO\n"}, nil)
+	rule := RuleStruct{Type: "sshd_config_check", Param: []string{"MaxAuthTries"}, Filter: `^(\d+)$`, Result: "$(<=)4"}
+	ifPass, value, err := CheckRuleValue(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evidence := NewEvidence(rule, ifPass, value)
+	if evidence.Value != "6" || evidence.Line != "6" || evidence.Expect != "$(<=)4" || evidence.Pass {
+		t.Errorf("unexpected evidence %+v", evidence)
+	}
+	if expect := expectExpr(RuleStruct{Type: "package_check", Param: []string{"telnet"}, Result: false}); expect != "$(not)installed" {
+		t.Errorf("unexpected expect %s", expect)
+	}
+}
+
+func TestConfigKeyCheck(t *testing.T) {
+	dir := t.TempDir()
+	daemon := filepath.Join(dir, "daemon.json")
+	kubelet := filepath.Join(dir, "config.yaml")
+	writeFile(t, daemon, `{"icc": false, "log-opts": {"max-size": "10m"}, "default-ulimits": {}, "max-concurrent-downloads": 10}`)
+	writeFile(t, kubelet, "authentication:\n  anonymous:\n    enabled: false\n  x509:\n    clientCAFile: /etc/kubernetes/pki/ca.crt\ntlsCipherSuites:\n- TLS_AES_128_GCM_SHA256\n- TLS_AES_256_GCM_SHA384\n")
+
+	value, err := ConfigKeyCheck([]string{kubelet, "tlsCipherSuites"})
+	if err != nil || value != "TLS_AES_128_GCM_SHA256\nTLS_AES_256_GCM_SHA384" {
+		t.Errorf("want the items of the list, get %v %v", value, err)
+	}
+	value, err = ConfigKeyCheck([]string{daemon, "log-opts"})
+	if err != nil || value != "max-size" {
+		t.Errorf("want the keys of the map, get %v %v", value, err)
+	}
+
+	checkRule(t, "json bool", RuleStruct{Type: "config_key_check", Param: []string{daemon, "icc"}, Result: "^false$"}, true)
+	checkRule(t, "json nested", RuleStruct{Type: "config_key_check", Param: []string{daemon, "log-opts.max-size"}, Result: "^10m$"}, true)
+	checkRule(t, "json number", RuleStruct{Type: "config_key_check", Param: []string{daemon, "max-concurrent-downloads"}, Result: "$(<=)10"}, true)
+	checkRule(t, "yaml nested", RuleStruct{Type: "config_key_check", Param: []string{kubelet, "authentication.anonymous.enabled"}, Result: "^false$"}, true)
+	checkRule(t, "yaml string", RuleStruct{Type: "config_key_check", Param: []string{kubelet, "authentication.x509.clientCAFile"}, Result: `\S+`}, true)
+	checkRule(t, "missing key", RuleStruct{Type: "config_key_check", Param: []string{daemon, "userland-proxy"}, Result: "^false$"}, false)
+	checkRule(t, "ignore missing", RuleStruct{Type: "config_key_check", Param: []string{kubelet, "readOnlyPort", "ignore_missing"}, Result: "^0$"}, true)
+	checkRule(t, "missing file", RuleStruct{Type: "config_key_check", Param: []string{filepath.Join(dir, "none.json"), "icc"}, Result: "^false$"}, false)
+	checkRule(t, "key of a scalar", RuleStruct{Type: "config_key_check", Param: []string{daemon, "icc.enabled"}, Result: "^false$"}, false)
+
+	writeFile(t, daemon, `{"icc": false,}`)
+	checkRule(t, "broken file", RuleStruct{Type: "config_key_check", Param: []string{daemon, "icc"}, Result: "^false$"}, false)
+}
+
+func TestFileModeCheck(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"ca.key": 0600, "sa.key": 0400, "ca.crt": 0644, "front-proxy.crt": 0666} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, "")
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ok, modes, err := FileModeCheck([]string{filepath.Join(dir, "*.crt"), "644"})
+	if err != nil || ok || modes != filepath.Join(dir, "front-proxy.crt")+" 666" {
+		t.Errorf("want front-proxy.crt to fail, get %v %q %v", ok, modes, err)
+	}
+	if _, _, err := FileModeCheck([]string{dir, "8"}); err == nil {
+		t.Error("want an error of the invalid mode")
+	}
+
+	checkRule(t, "glob", RuleStruct{Type: "file_mode_check", Param: []string{filepath.Join(dir, "*.key"), "600"}}, true)
+	// 0600 isn't more restrictive than 0444 though it's less than 444
+	checkRule(t, "bits", RuleStruct{Type: "file_mode_check", Param: []string{filepath.Join(dir, "ca.key"), "444"}}, false)
+	checkRule(t, "dir", RuleStruct{Type: "file_mode_check", Param: []string{dir, "755"}}, true)
+	checkRule(t, "missing", RuleStruct{Type: "file_mode_check", Param: []string{filepath.Join(dir, "*.pem"), "600"}}, false)
+	checkRule(t, "ignore missing", RuleStruct{Type: "file_mode_check", Param: []string{filepath.Join(dir, "*.pem"), "600", "ignore_missing"}}, true)
+}
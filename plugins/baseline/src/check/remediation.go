@@ -0,0 +1,134 @@
+package check
+
+import (
+	"baseline/src/pack"
+	"baseline/src/remediate"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RemediationState the result of a check before or after the remediation
+type RemediationState struct {
+	Result   int        `json:"result"`
+	Msg      string     `json:"msg"`
+	Evidence []Evidence `json:"evidence"`
+}
+
+// RemediationCheck a check which is remediated or rolled back
+type RemediationCheck struct {
+	CheckId int                `json:"check_id"`
+	Title   string             `json:"title"`
+	Actions []remediate.Action `json:"actions"`
+	Before  RemediationState   `json:"before"`
+	After   RemediationState   `json:"after"`
+}
+
+type RemediationInfo struct {
+	RemediationId   string             `json:"remediation_id"`
+	BaselineId      int                `json:"baseline_id"`
+	BaselineVersion string             `json:"baseline_version"`
+	Rollback        bool               `json:"rollback"`
+	CheckList       []RemediationCheck `json:"check_list"`
+	// the state of the target of each action before and after
+	Results []remediate.Result `json:"results"`
+}
+
+func remediationState(retCheckInfo RetCheckInfo) RemediationState {
+	return RemediationState{Result: retCheckInfo.Result, Msg: retCheckInfo.Msg, Evidence: retCheckInfo.Evidence}
+}
+
+// remediationConfig returns the baseline of the pack approved with the
+// remediation. It is verified but not cached, and there is no fallback to
+// other versions, so the actions run are exactly the approved ones.
+func remediationConfig(taskData TaskData) (baselineInfo BaselineInfo, err error) {
+	p := taskData.Pack
+	if p == nil {
+		return baselineInfo, errors.New("no pack of remediation")
+	}
+	if p.BaselineId != taskData.BaselineId || p.Version != taskData.BaselineVersion {
+		return baselineInfo, fmt.Errorf("pack %d %s doesn't match the remediation of %d %s",
+			p.BaselineId, p.Version, taskData.BaselineId, taskData.BaselineVersion)
+	}
+	key, err := pack.PublicKey()
+	if err != nil {
+		return baselineInfo, err
+	}
+	if err = p.Verify(key); err != nil {
+		return baselineInfo, err
+	}
+	if err = yaml.Unmarshal([]byte(p.Content), &baselineInfo); err != nil {
+		return baselineInfo, err
+	}
+	baselineInfo.BaselineVersion = p.Version
+	return baselineInfo, nil
+}
+
+// Remediate runs the remediation of the failed checks of a task, or rolls
+// back a remediation. The checks are run again after it, and their results
+// are returned as a baseline result too.
+func Remediate(taskData TaskData) (remediationInfo RemediationInfo, retBaselineInfo RetBaselineInfo, err error) {
+	remediationInfo.RemediationId = taskData.RemediationId
+	remediationInfo.BaselineId = taskData.BaselineId
+	remediationInfo.Rollback = taskData.Rollback
+	retBaselineInfo.BaselineId = taskData.BaselineId
+	retBaselineInfo.Status = BaselineStatusSuccess
+	if taskData.RemediationId == "" || len(taskData.CheckIdList) == 0 {
+		return remediationInfo, retBaselineInfo, errors.New("remediation needs remediation_id and check_id_list")
+	}
+
+	baselineInfo, err := remediationConfig(taskData)
+	if err != nil {
+		return remediationInfo, retBaselineInfo, err
+	}
+	remediationInfo.BaselineVersion = baselineInfo.BaselineVersion
+	retBaselineInfo.BaselineVersion = baselineInfo.BaselineVersion
+
+	checkMap := make(map[int]CheckInfo, len(baselineInfo.CheckList))
+	for _, checkInfo := range baselineInfo.CheckList {
+		checkMap[checkInfo.CheckId] = checkInfo
+	}
+	var (
+		checkList []CheckInfo
+		actions   []remediate.Action
+	)
+	for _, checkId := range taskData.CheckIdList {
+		checkInfo, ok := checkMap[checkId]
+		if !ok {
+			return remediationInfo, retBaselineInfo, fmt.Errorf("check %d doesn't exist", checkId)
+		}
+		if len(checkInfo.Remediation) == 0 {
+			return remediationInfo, retBaselineInfo, fmt.Errorf("check %d has no remediation", checkId)
+		}
+		checkList = append(checkList, checkInfo)
+	}
+
+	// the results before, only the failed checks are remediated
+	for _, checkInfo := range checkList {
+		before := analysisCheck(checkInfo)
+		remediationInfo.CheckList = append(remediationInfo.CheckList, RemediationCheck{
+			CheckId: checkInfo.CheckId,
+			Title:   checkInfo.Title,
+			Actions: checkInfo.Remediation,
+			Before:  remediationState(before),
+		})
+		if before.Result != SuccessCode {
+			actions = append(actions, checkInfo.Remediation...)
+		}
+	}
+
+	if taskData.Rollback {
+		remediationInfo.Results, err = remediate.Rollback(taskData.RemediationId)
+	} else if len(actions) != 0 {
+		remediationInfo.Results, err = remediate.Apply(taskData.RemediationId, actions)
+	}
+
+	// the results after, whether the remediation succeeds or not
+	for i, checkInfo := range checkList {
+		after := analysisCheck(checkInfo)
+		remediationInfo.CheckList[i].After = remediationState(after)
+		retBaselineInfo.CheckList = append(retBaselineInfo.CheckList, after)
+	}
+	return remediationInfo, retBaselineInfo, err
+}
@@ -2,6 +2,7 @@ package check
 
 import (
 	"baseline/infra"
+	"baseline/src/remediate"
 	"errors"
 	"fmt"
 	"reflect"
@@ -35,6 +36,8 @@ type CheckInfo struct {
 	DescriptionCn string        `yaml:"description_cn" bson:"description_cn"`
 	SolutionCn    string        `yaml:"solution_cn" bson:"solution_cn"`
 	Check         BaselineCheck `yaml:"check" bson:"check"`
+	// actions which fix the check, optional
+	Remediation []remediate.Action `yaml:"remediation" bson:"remediation"`
 }
 
 type BaselineInfo struct {
@@ -153,6 +156,8 @@ func ResultMatch(ruleStruct RuleStruct, funcRes interface{}) (ifPass bool, err e
 				return false, errors.New(errStr)
 			}
 		} else if funcType == reflect.Int {
+		} else if funcType == reflect.Bool {
+			return funcRes.(bool), err
 		} else {
 			errStr := fmt.Sprintf("%d:rule is int, but get other type", ErrorConfigWrite)
 			return false, errors.New(errStr)
@@ -247,6 +252,16 @@ func ResultMatch(ruleStruct RuleStruct, funcRes interface{}) (ifPass bool, err e
 
 // CheckRule check rule result
 func CheckRule(ruleStruct RuleStruct) (ifPass bool, err error) {
+	ifPass, _, err = CheckRuleValue(ruleStruct)
+	return
+}
+
+// size of the raw value of a rule at most
+const maxRuleValueSize = 4096
+
+// CheckRuleValue check rule result, and return the raw value which the rule got,
+// e.g. the output of a command or the lines of a file which are matched
+func CheckRuleValue(ruleStruct RuleStruct) (ifPass bool, value interface{}, err error) {
 	var funcRes interface{}
 
 	// Determine if there are rule prerequisites
@@ -266,26 +281,81 @@ func CheckRule(ruleStruct RuleStruct) (ifPass bool, err error) {
 		// Determine if the file exists
 		funcRes, err = IfFileExist(ruleStruct.Param)
 	case "file_permission":
-		// Determine whether file permissions are reasonable
-		funcRes, err = FilePermission(ruleStruct.Param)
+		// Determine whether file permissions are reasonable, the value is the real mode
+		var ok bool
+		ok, value, err = FilePermission(ruleStruct.Param)
+		funcRes = ok
 	case "file_user_group":
-		// Determine if the file user group is reasonable
-		funcRes, err = FileUserGroup(ruleStruct.Param)
+		// Determine if the file user group is reasonable, the value is the real uid:gid
+		var ok bool
+		ok, value, err = FileUserGroup(ruleStruct.Param)
+		funcRes = ok
 	case "file_line_check":
-		funcRes, err = FileLineCheck(ruleStruct, ResultMatch)
+		// the lines which pass or are hit by the filter are the value
+		var lines []string
+		funcRes, err = FileLineCheck(ruleStruct, func(rule RuleStruct, line interface{}) (bool, error) {
+			ifPass, err := ResultMatch(rule, line)
+			if ifPass || (rule.Filter != "" && filterHit(rule.Filter, line)) {
+				lines = append(lines, line.(string))
+			}
+			return ifPass, err
+		})
+		value = truncateValue(strings.Join(lines, "\n"))
 	case "func_check":
 		funcRes, err = FuncCheck(ruleStruct.Param)
 	case "file_md5_check":
-		// Calculate whether the file MD5 is consistent
-		funcRes, err = FileMd5Check(ruleStruct.Param)
+		// Calculate whether the file MD5 is consistent, the value is the real md5
+		var ok bool
+		ok, value, err = FileMd5Check(ruleStruct.Param)
+		funcRes = ok
+	case "sysctl_check":
+		funcRes, err = SysctlCheck(ruleStruct.Param)
+	case "systemd_unit_check":
+		funcRes, err = SystemdUnitCheck(ruleStruct.Param)
+	case "package_check":
+		// Determine whether the package is installed, the value is the installed version
+		var ok bool
+		ok, value, err = PackageCheck(ruleStruct.Param)
+		funcRes = ok
+	case "mount_option_check":
+		// Determine the mount options, the value is the real options
+		var ok bool
+		ok, value, err = MountOptionCheck(ruleStruct.Param)
+		funcRes = ok
+	case "pam_check":
+		funcRes, err = PamCheck(ruleStruct.Param)
+	case "sshd_config_check":
+		funcRes, err = SshdConfigCheck(ruleStruct.Param)
+	case "config_key_check":
+		funcRes, err = ConfigKeyCheck(ruleStruct.Param)
+	case "file_mode_check":
+		// Determine whether the modes of the files are restrictive enough, the value is the modes
+		var ok bool
+		var modes string
+		ok, modes, err = FileModeCheck(ruleStruct.Param)
+		value = truncateEvidence(modes)
+		funcRes = ok
 
 	default:
 		errStr := fmt.Sprintf("%d:unknown rule type:%s", ErrorConfigWrite, ruleStruct.Type)
-		return false, errors.New(errStr)
+		return false, nil, errors.New(errStr)
+	}
+	if value == nil {
+		value = funcRes
+		if s, ok := funcRes.(string); ok {
+			value = truncateValue(s)
+		}
 	}
 	if err != nil {
 		infra.Loger.Println(err)
-		return false, err
+		return false, value, err
+	}
+
+	// the setting which a native rule returns is absent if the filter doesn't hit
+	if nativeValueRules[ruleStruct.Type] && ruleStruct.Filter != "" {
+		if s, ok := funcRes.(string); ok && !filterHit(ruleStruct.Filter, s) {
+			return false, value, nil
+		}
 	}
 
 	// if file_line_check，Match line by line
@@ -297,41 +367,191 @@ func CheckRule(ruleStruct RuleStruct) (ifPass bool, err error) {
 	}
 	if err != nil {
 		infra.Loger.Println(err)
-		return false, err
+		return false, value, err
 	}
 
-	return ifPass, err
+	return ifPass, value, err
+}
+
+func filterHit(filter string, line interface{}) bool {
+	s, ok := line.(string)
+	if !ok {
+		return false
+	}
+	_, ifMatch, _ := StringMatch(s, filter)
+	return ifMatch
+}
+
+func truncateValue(s string) string {
+	if len(s) > maxRuleValueSize {
+		return s[:maxRuleValueSize]
+	}
+	return s
+}
+
+// size of the value and the line of an evidence at most
+const maxEvidenceSize = 256
+
+// Evidence what a rule observed on the host, it's returned with the result
+// of a check so that the real value can be seen when the check fails
+type Evidence struct {
+	Type string `json:"type" bson:"type"`
+	// the file which is checked
+	File string `json:"file" bson:"file"`
+	// the line of the file or of the command output which is matched
+	Line string `json:"line" bson:"line"`
+	// the observed value, e.g. the group of the filter, the file mode or uid:gid
+	Value interface{} `json:"value" bson:"value"`
+	// the expected expression, e.g. $(<=)90
+	Expect string `json:"expect" bson:"expect"`
+	Pass   bool   `json:"pass" bson:"pass"`
+}
+
+// NewEvidence evidence of a rule by the raw value which CheckRuleValue returns
+func NewEvidence(ruleStruct RuleStruct, ifPass bool, value interface{}) Evidence {
+	evidence := Evidence{
+		Type:   ruleStruct.Type,
+		Value:  value,
+		Expect: expectExpr(ruleStruct),
+		Pass:   ifPass,
+	}
+	switch ruleStruct.Type {
+	case "if_file_exist", "file_permission", "file_user_group", "file_line_check", "file_md5_check", "pam_check", "config_key_check", "file_mode_check":
+		if len(ruleStruct.Param) != 0 {
+			evidence.File = ruleStruct.Param[0]
+		}
+	}
+
+	// the output of a command or the lines of a file, keep the line which is matched
+	if s, ok := value.(string); ok && lineValueRules[ruleStruct.Type] {
+		evidence.Value = ""
+		for _, line := range strings.Split(s, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if ruleStruct.Filter == "" {
+				evidence.Line = line
+				evidence.Value = line
+				break
+			}
+			if subStr, ifMatch, _ := StringMatch(line, ruleStruct.Filter); ifMatch {
+				evidence.Line = line
+				evidence.Value = line
+				if subStr != "" {
+					evidence.Value = subStr
+				}
+				break
+			}
+		}
+		evidence.Line = truncateEvidence(evidence.Line)
+		evidence.Value = truncateEvidence(evidence.Value.(string))
+	}
+
+	// the evidence is persisted by the manager, sensitive files are redacted like dry run
+	if redactValue(ruleStruct, evidence.Value) == redactedValue {
+		evidence.Value = redactedValue
+		if evidence.Line != "" {
+			evidence.Line = redactedValue
+		}
+	}
+	return evidence
+}
+
+// the rules whose value is lines
+var lineValueRules = map[string]bool{
+	"command_check":     true,
+	"file_line_check":   true,
+	"pam_check":         true,
+	"sshd_config_check": true,
+	"config_key_check":  true,
+}
+
+// the expected expression of a rule, the rules which return bool are
+// described by their params, $(not) means the result is reversed
+func expectExpr(ruleStruct RuleStruct) string {
+	switch res := ruleStruct.Result.(type) {
+	case string:
+		return res
+	case int:
+		return strconv.Itoa(res)
+	}
+	var expect string
+	switch ruleStruct.Type {
+	case "if_file_exist":
+		expect = "exist"
+	case "file_permission":
+		if len(ruleStruct.Param) >= 2 {
+			expect = "$(<)" + ruleStruct.Param[1]
+		}
+	case "file_user_group", "file_md5_check":
+		if len(ruleStruct.Param) >= 2 {
+			expect = ruleStruct.Param[1]
+		}
+	case "func_check":
+		if len(ruleStruct.Param) >= 1 {
+			expect = ruleStruct.Param[0]
+		}
+	case "package_check":
+		expect = "installed"
+	case "mount_option_check":
+		if len(ruleStruct.Param) >= 2 {
+			expect = ruleStruct.Param[1]
+		}
+	case "file_mode_check":
+		if len(ruleStruct.Param) >= 2 {
+			expect = "$(<=)" + ruleStruct.Param[1]
+		}
+	}
+	if res, ok := ruleStruct.Result.(bool); ok && !res {
+		expect = "$(not)" + expect
+	}
+	return expect
+}
+
+func truncateEvidence(s string) string {
+	if len(s) > maxEvidenceSize {
+		return s[:maxEvidenceSize]
+	}
+	return s
 }
 
 // AnalysisRule Rule parsing engine
 func AnalysisRule(check BaselineCheck) (ifPass bool, err error) {
+	ifPass, _, err = AnalysisRuleEvidence(check)
+	return
+}
+
+// AnalysisRuleEvidence Rule parsing engine, and return the evidence of the
+// rules which are evaluated
+func AnalysisRuleEvidence(check BaselineCheck) (ifPass bool, evidenceList []Evidence, err error) {
 	condition := check.Condition
 	if condition == "" {
 		condition = "all"
 	}
 
 	for _, rule := range check.Rules {
-		ifCheck, err := CheckRule(rule)
+		ifCheck, value, err := CheckRuleValue(rule)
+		evidenceList = append(evidenceList, NewEvidence(rule, ifCheck, value))
 		if err != nil {
-			infra.Loger.Println(err)
-			return false, err
+			return false, evidenceList, err
 		}
 		if ifCheck {
 			if condition == "any" {
-				return true, err
+				return true, evidenceList, err
 			} else if condition == "none" {
-				return false, err
+				return false, evidenceList, err
 			}
 		} else {
 			if condition == "all" {
-				return false, err
+				return false, evidenceList, err
 			}
 		}
 	}
 
 	if condition == "any" {
-		return false, err
+		return false, evidenceList, err
 	} else {
-		return true, err
+		return true, evidenceList, err
 	}
 }
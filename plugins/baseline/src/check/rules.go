@@ -133,9 +133,10 @@ func FileLineCheck(ruleStruct RuleStruct, resultMatch ResultMatchFunc) (result b
 // FilePermission Determine file permissions
 //1. The absolute path of the file
 //2. File permissions (chmod out of base 8)
-func FilePermission(param []string) (result bool, err error) {
+// mode: the real file permissions, "" if the file doesn't exist
+func FilePermission(param []string) (result bool, mode string, err error) {
 	if len(param) < 2 {
-		return false, fmt.Errorf("FilePermission param length need at least 2")
+		return false, "", fmt.Errorf("FilePermission param length need at least 2")
 	}
 	filePath := param[0]
 	fileNeedMode, err := strconv.Atoi(param[1])
@@ -145,30 +146,32 @@ func FilePermission(param []string) (result bool, err error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such file") {
-			return true, nil
+			return true, "", nil
 		}
 		return
 	}
 
-	fileRealMode, err := strconv.Atoi(strconv.FormatInt(int64(fileInfo.Mode()), 8))
+	mode = strconv.FormatInt(int64(fileInfo.Mode()), 8)
+	fileRealMode, err := strconv.Atoi(mode)
 	if err != nil {
 		return
 	}
 
-	return fileRealMode < fileNeedMode, err
+	return fileRealMode < fileNeedMode, mode, err
 }
 
 // FileUserGroup Determine the file user group
 //1. The absolute path of the file
 //2. File user id: groupId
-func FileUserGroup(param []string) (result bool, err error) {
+// owner: the real uid:gid of the file
+func FileUserGroup(param []string) (result bool, owner string, err error) {
 	if len(param) < 2 {
-		return false, fmt.Errorf("FileUserGroup param length need at least 2")
+		return false, "", fmt.Errorf("FileUserGroup param length need at least 2")
 	}
 	filePath := param[0]
 	res := strings.Split(param[1], ":")
 	if len(res) != 2 {
-		return false, fmt.Errorf("file_user_group rule wrong!")
+		return false, "", fmt.Errorf("file_user_group rule wrong!")
 	}
 	userNeedId := res[0]
 	groupNeedId := res[1]
@@ -179,40 +182,39 @@ func FileUserGroup(param []string) (result bool, err error) {
 	}
 	userRealId := strconv.FormatUint(uint64(fileInfo.Sys().(*syscall.Stat_t).Uid), 10)
 	groupRealId := strconv.FormatUint(uint64(fileInfo.Sys().(*syscall.Stat_t).Gid), 10)
+	owner = userRealId + ":" + groupRealId
 
 	if userNeedId == userRealId && groupNeedId == groupRealId {
-		return true, err
+		return true, owner, err
 	}
 
-	return false, err
+	return false, owner, err
 }
 
 // FileMd5Check Determine whether the file MD5 is consistent
 //1. The absolute path of the file
 //2. File MD5
-func FileMd5Check(param []string) (result bool, err error) {
+// fileMd5: the real md5 of the file
+func FileMd5Check(param []string) (result bool, fileMd5 string, err error) {
 	if len(param) < 2 {
-		return false, fmt.Errorf("file_md5_check param length need at least 2")
+		return false, "", fmt.Errorf("file_md5_check param length need at least 2")
 	}
 
 	filePath := param[0]
-	fileMd5 := param[1]
 	file, err := os.Open(filePath)
 	if err != nil {
-		return false, fmt.Errorf("file_md5_check : no file find %s", filePath)
+		return false, "", fmt.Errorf("file_md5_check : no file find %s", filePath)
 	}
+	defer file.Close()
 	fileContentByte, err := ioutil.ReadAll(file)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	hash := md5.New()
 	hash.Write(fileContentByte)
-	if fileMd5 == hex.EncodeToString(hash.Sum(nil)) {
-		return true, nil
-	} else {
-		return false, nil
-	}
+	fileMd5 = hex.EncodeToString(hash.Sum(nil))
+	return param[1] == fileMd5, fileMd5, nil
 }
 
 // FuncCheck Special baseline rules
@@ -0,0 +1,42 @@
+package linux
+
+import (
+	"os"
+)
+
+// container runtimes which have baselines
+const (
+	RuntimeKubelet = "kubelet"
+	RuntimeDocker  = "docker"
+)
+
+// the files which show a runtime is installed on the host, the config
+// files are checked as well as the binaries since either may be missing
+var runtimeFiles = map[string][]string{
+	RuntimeKubelet: {
+		"/var/lib/kubelet/config.yaml",
+		"/etc/kubernetes/kubelet.conf",
+		"/usr/bin/kubelet",
+		"/usr/local/bin/kubelet",
+	},
+	RuntimeDocker: {
+		"/var/run/docker.sock",
+		"/etc/docker",
+		"/usr/bin/dockerd",
+		"/usr/local/bin/dockerd",
+	},
+}
+
+// GetContainerRuntimes get the container runtimes installed on the host
+func GetContainerRuntimes() []string {
+	runtimes := make([]string, 0)
+	for _, runtime := range []string{RuntimeKubelet, RuntimeDocker} {
+		for _, path := range runtimeFiles[runtime] {
+			if _, err := os.Stat(path); err == nil {
+				runtimes = append(runtimes, runtime)
+				break
+			}
+		}
+	}
+	return runtimes
+}
@@ -1,20 +1,109 @@
 package linux
 
 import (
+	"bufio"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
 )
 
-// GetSystemType get system type
+// distro families which have baselines
+const (
+	FamilyCentos    = "centos"
+	FamilyDebian    = "debian"
+	FamilyUbuntu    = "ubuntu"
+	FamilyRhel      = "rhel"
+	FamilyAmzn      = "amzn"
+	FamilySuse      = "suse"
+	FamilyOpenEuler = "openeuler"
+)
+
+// os-release ids of the families, see os-release(5)
+var familyIds = map[string]string{
+	"centos":              FamilyCentos,
+	"debian":              FamilyDebian,
+	"raspbian":            FamilyDebian,
+	"ubuntu":              FamilyUbuntu,
+	"linuxmint":           FamilyUbuntu,
+	"rhel":                FamilyRhel,
+	"rocky":               FamilyRhel,
+	"almalinux":           FamilyRhel,
+	"ol":                  FamilyRhel,
+	"fedora":              FamilyRhel,
+	"amzn":                FamilyAmzn,
+	"sles":                FamilySuse,
+	"sled":                FamilySuse,
+	"suse":                FamilySuse,
+	"opensuse":            FamilySuse,
+	"opensuse-leap":       FamilySuse,
+	"opensuse-tumbleweed": FamilySuse,
+	"openeuler":           FamilyOpenEuler,
+}
+
+// ParseOsRelease parses the KEY=value lines of os-release
+func ParseOsRelease(r io.Reader) map[string]string {
+	ret := map[string]string{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ret[kv[0]] = strings.Trim(kv[1], "\"'")
+	}
+	return ret
+}
+
+// SystemFamily returns the family of an os-release by ID then ID_LIKE,
+// "" if it's unknown
+func SystemFamily(osRelease map[string]string) string {
+	if family, ok := familyIds[strings.ToLower(osRelease["ID"])]; ok {
+		return family
+	}
+	// e.g. "rhel centos fedora"
+	for _, like := range strings.Fields(strings.ToLower(osRelease["ID_LIKE"])) {
+		if family, ok := familyIds[like]; ok {
+			return family
+		}
+	}
+	return ""
+}
+
+// GetSystemType get system type, i.e. the distro family of /etc/os-release,
+// /etc/issue is checked on the old ones without it. The unknown derivatives
+// of redhat fall back to centos as before.
 func GetSystemType() string {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		family := SystemFamily(ParseOsRelease(f))
+		f.Close()
+		if family == "" && isRedhat() {
+			return FamilyCentos
+		}
+		return family
+	}
 	cmd := exec.Command("cat", "/etc/issue")
 	buf, _ := cmd.Output()
 	cmdStr := string(buf)
 	if strings.Contains(cmdStr, "Ubuntu") {
-		return "ubuntu"
+		return FamilyUbuntu
 	} else if strings.Contains(cmdStr, "Debian") {
-		return "debian"
-	} else {
-		return "centos"
+		return FamilyDebian
+	} else if isRedhat() {
+		return FamilyCentos
 	}
+	return ""
+}
+
+func isRedhat() bool {
+	_, err := os.Stat("/etc/redhat-release")
+	return err == nil
 }
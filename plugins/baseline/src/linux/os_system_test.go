@@ -0,0 +1,25 @@
+package linux
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemFamily(t *testing.T) {
+	tests := []struct {
+		osRelease string
+		want      string
+	}{
+		{"ID=ubuntu\nID_LIKE=debian\n", FamilyUbuntu},
+		{"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", FamilyRhel},
+		{"ID=fedora\n", FamilyRhel},
+		{"ID=nobara\nID_LIKE=\"fedora\"\n", FamilyRhel},
+		{"ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n", FamilySuse},
+		{"ID=kylin\n", ""},
+	}
+	for _, tt := range tests {
+		if got := SystemFamily(ParseOsRelease(strings.NewReader(tt.osRelease))); got != tt.want {
+			t.Errorf("SystemFamily(%q) = %q, want %q", tt.osRelease, got, tt.want)
+		}
+	}
+}
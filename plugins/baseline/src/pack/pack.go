@@ -0,0 +1,235 @@
+package pack
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// public key of the manager which signs packs, shipped with the plugin
+	PublicKeyPath = "config/pack.pub"
+	// cache of the pushed packs, <id>/<version>.json
+	CacheDir = "pack_cache"
+	// versions of a baseline which are kept in the cache
+	CacheKeep = 3
+	// size of a downloaded pack at most
+	MaxPackSize = 4 * 1024 * 1024
+	// file of the version which is run by the daily task
+	currentFile = "current"
+)
+
+var (
+	ErrNoPublicKey  = errors.New("no public key of baseline packs")
+	ErrBadSignature = errors.New("baseline pack signature doesn't match")
+	ErrBadVersion   = errors.New("invalid baseline pack version")
+	ErrNotCached    = errors.New("baseline pack isn't cached")
+	ErrOldVersion   = errors.New("baseline pack is older than the cached one")
+)
+
+// versions are used as file names, e.g. 1.0+3fa2b1c9d0e4
+var versionReg = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._+-]{0,63}$`)
+
+// Pack is the yaml of a baseline signed by the manager
+type Pack struct {
+	BaselineId int    `json:"baseline_id"`
+	Version    string `json:"version"`
+	Content    string `json:"content"`
+	// when the manager saves the pack, it's signed so that an old pack can't
+	// replace a newer one in the cache
+	UpdateTime int64 `json:"update_time"`
+	// base64 of the ed25519 signature of message()
+	Signature string `json:"signature"`
+}
+
+// message is what the manager signs, see server/manager/internal/baseline/pack.go
+func (p *Pack) message() []byte {
+	return []byte(fmt.Sprintf("elkeid baseline pack\n%d\n%s\n%d\n%s", p.BaselineId, p.Version, p.UpdateTime, p.Content))
+}
+
+// Verify checks the version and the signature of a pack
+func (p *Pack) Verify(publicKey ed25519.PublicKey) error {
+	if !versionReg.MatchString(p.Version) {
+		return ErrBadVersion
+	}
+	if publicKey == nil {
+		return ErrNoPublicKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil || !ed25519.Verify(publicKey, p.message(), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+var (
+	publicKey     ed25519.PublicKey
+	publicKeyErr  error
+	publicKeyOnce sync.Once
+)
+
+// ParsePublicKey parses a PEM encoded PKIX ed25519 public key
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPublicKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("baseline pack public key is %T, not ed25519", key)
+	}
+	return ret, nil
+}
+
+// PublicKey returns the public key of PublicKeyPath
+func PublicKey() (ed25519.PublicKey, error) {
+	publicKeyOnce.Do(func() {
+		data, err := ioutil.ReadFile(PublicKeyPath)
+		if err != nil {
+			publicKeyErr = ErrNoPublicKey
+			return
+		}
+		publicKey, publicKeyErr = ParsePublicKey(data)
+	})
+	return publicKey, publicKeyErr
+}
+
+// Download gets a pack referenced by a task
+func Download(url string) (*Pack, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download baseline pack: %s", resp.Status)
+	}
+	p := new(Pack)
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxPackSize)).Decode(p)
+	return p, err
+}
+
+func packPath(baselineId int, version string) string {
+	return filepath.Join(CacheDir, strconv.Itoa(baselineId), version+".json")
+}
+
+// Store verifies a pack and caches it as the current version of its
+// baseline, a pack older than the current one is rejected
+func Store(p *Pack) error {
+	key, err := PublicKey()
+	if err != nil {
+		return err
+	}
+	if err = p.Verify(key); err != nil {
+		return err
+	}
+	if cur, err := Load(p.BaselineId, ""); err == nil && cur.Version != p.Version && p.UpdateTime < cur.UpdateTime {
+		return ErrOldVersion
+	}
+	dir := filepath.Join(CacheDir, strconv.Itoa(p.BaselineId))
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(packPath(p.BaselineId, p.Version), data); err != nil {
+		return err
+	}
+	if err = writeFile(filepath.Join(dir, currentFile), []byte(p.Version)); err != nil {
+		return err
+	}
+	prune(dir, p.Version)
+	return nil
+}
+
+// Load returns a cached pack of a version, the current one if version is
+// empty. The signature is checked again since the cache is a plain file.
+func Load(baselineId int, version string) (*Pack, error) {
+	if version == "" {
+		data, err := ioutil.ReadFile(filepath.Join(CacheDir, strconv.Itoa(baselineId), currentFile))
+		if err != nil {
+			return nil, ErrNotCached
+		}
+		version = strings.TrimSpace(string(data))
+	}
+	if !versionReg.MatchString(version) {
+		return nil, ErrBadVersion
+	}
+	data, err := ioutil.ReadFile(packPath(baselineId, version))
+	if err != nil {
+		return nil, ErrNotCached
+	}
+	p := new(Pack)
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if p.BaselineId != baselineId || p.Version != version {
+		return nil, ErrBadVersion
+	}
+	key, err := PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Verify(key); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prune keeps the latest CacheKeep versions of a baseline by modification
+// time, the current version is always kept
+func prune(dir, current string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) <= CacheKeep {
+		return
+	}
+	currentPath := filepath.Join(dir, current+".json")
+	modTime := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			modTime[f] = fi.ModTime()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if (files[i] == currentPath) != (files[j] == currentPath) {
+			return files[i] == currentPath
+		}
+		if !modTime[files[i]].Equal(modTime[files[j]]) {
+			return modTime[files[i]].After(modTime[files[j]])
+		}
+		return files[i] > files[j]
+	})
+	for _, f := range files[CacheKeep:] {
+		_ = os.Remove(f)
+	}
+}
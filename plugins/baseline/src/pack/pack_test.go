@@ -0,0 +1,180 @@
+package pack
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testKey ed25519.PrivateKey
+
+// TestMain runs the tests in a temp directory with a generated public key
+func TestMain(m *testing.M) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	testKey = key
+	publicKeyOnce.Do(func() {
+		publicKey = pub
+	})
+	dir, err := ioutil.TempDir("", "pack")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newPack(baselineId int, version string, updateTime int64) *Pack {
+	p := &Pack{BaselineId: baselineId, Version: version, Content: "check_list: []\n", UpdateTime: updateTime}
+	p.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(testKey, p.message()))
+	return p
+}
+
+func TestVerify(t *testing.T) {
+	pub := testKey.Public().(ed25519.PublicKey)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	tests := []struct {
+		name   string
+		pack   func() *Pack
+		key    ed25519.PublicKey
+		expect error
+	}{
+		{"valid", func() *Pack { return newPack(1200, "1.0+3fa2b1c9d0e4", 1) }, pub, nil},
+		{"no key", func() *Pack { return newPack(1200, "1.0+3fa2b1c9d0e4", 1) }, nil, ErrNoPublicKey},
+		{"other key", func() *Pack { return newPack(1200, "1.0+3fa2b1c9d0e4", 1) }, otherPub, ErrBadSignature},
+		{"bad version", func() *Pack { return newPack(1200, "../1.0", 1) }, pub, ErrBadVersion},
+		{"content changed", func() *Pack {
+			p := newPack(1200, "1.0+3fa2b1c9d0e4", 1)
+			p.Content += "# changed\n"
+			return p
+		}, pub, ErrBadSignature},
+		{"baseline changed", func() *Pack {
+			p := newPack(1200, "1.0+3fa2b1c9d0e4", 1)
+			p.BaselineId = 1300
+			return p
+		}, pub, ErrBadSignature},
+		{"update time changed", func() *Pack {
+			p := newPack(1200, "1.0+3fa2b1c9d0e4", 1)
+			p.UpdateTime = 2
+			return p
+		}, pub, ErrBadSignature},
+		{"bad signature", func() *Pack {
+			p := newPack(1200, "1.0+3fa2b1c9d0e4", 1)
+			p.Signature = "!"
+			return p
+		}, pub, ErrBadSignature},
+	}
+	for _, tt := range tests {
+		if err := tt.pack().Verify(tt.key); err != tt.expect {
+			t.Errorf("%s: Verify() error %v, want %v", tt.name, err, tt.expect)
+		}
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	const baselineId = 1200
+	tests := []struct {
+		name    string
+		pack    *Pack
+		wantErr error
+		// current version after Store
+		current string
+	}{
+		{"first", newPack(baselineId, "1.0+000000000001", 100), nil, "1.0+000000000001"},
+		{"newer", newPack(baselineId, "1.1+000000000002", 200), nil, "1.1+000000000002"},
+		{"same version again", newPack(baselineId, "1.1+000000000002", 200), nil, "1.1+000000000002"},
+		{"older", newPack(baselineId, "1.0+000000000001", 100), ErrOldVersion, "1.1+000000000002"},
+		{"other baseline", newPack(baselineId+1, "1.0+000000000001", 50), nil, "1.1+000000000002"},
+		{"bad signature", func() *Pack {
+			p := newPack(baselineId, "1.2+000000000003", 300)
+			p.Content = "changed"
+			return p
+		}(), ErrBadSignature, "1.1+000000000002"},
+	}
+	for _, tt := range tests {
+		if err := Store(tt.pack); err != tt.wantErr {
+			t.Errorf("%s: Store() error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		p, err := Load(baselineId, "")
+		if err != nil {
+			t.Errorf("%s: Load() error %v", tt.name, err)
+			continue
+		}
+		if p.Version != tt.current {
+			t.Errorf("%s: current version %s, want %s", tt.name, p.Version, tt.current)
+		}
+	}
+
+	if p, err := Load(baselineId, "1.0+000000000001"); err != nil || p.UpdateTime != 100 {
+		t.Errorf("Load() of an old version = %v, error %v", p, err)
+	}
+	if _, err := Load(baselineId, "9.9+000000000009"); err != ErrNotCached {
+		t.Errorf("Load() of a missing version error %v, want %v", err, ErrNotCached)
+	}
+	if _, err := Load(baselineId, "../../etc/passwd"); err != ErrBadVersion {
+		t.Errorf("Load() of a bad version error %v, want %v", err, ErrBadVersion)
+	}
+	if _, err := Load(9999, ""); err != ErrNotCached {
+		t.Errorf("Load() of a missing baseline error %v, want %v", err, ErrNotCached)
+	}
+
+	// the cache is a plain file, a tampered pack isn't loaded
+	path := packPath(baselineId, "1.1+000000000002")
+	tampered := newPack(baselineId, "1.1+000000000002", 200)
+	tampered.Content = "check_list: [{check_id: 1}]\n"
+	data, _ := json.Marshal(tampered)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(baselineId, ""); err != ErrBadSignature {
+		t.Errorf("Load() of a tampered pack error %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	const baselineId = 1400
+	dir := filepath.Join(CacheDir, strconv.Itoa(baselineId))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// v0 is the oldest file but the current version, v4 is the newest
+	for i := 0; i < 5; i++ {
+		path := packPath(baselineId, fmt.Sprintf("v%d", i))
+		if err := ioutil.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prune(dir, "v0")
+
+	want := map[string]bool{"v0": true, "v3": true, "v4": true}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != CacheKeep {
+		t.Errorf("prune() keeps %d files, want %d", len(files), CacheKeep)
+	}
+	for _, f := range files {
+		version := filepath.Base(f)
+		version = version[:len(version)-len(".json")]
+		if !want[version] {
+			t.Errorf("prune() keeps %s", version)
+		}
+	}
+}
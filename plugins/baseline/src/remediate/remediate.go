@@ -0,0 +1,657 @@
+package remediate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/* Remediation of failed checks. Every action is journaled before it's run:
+the touched files are copied to BackupDir/<id>/ and the previous state
+(mode, owner, sysctl value, service state) is written to the journal, so
+that a remediation can be rolled back as a whole. A rollback restores whole
+files, so it's refused if a target is changed afterwards, by a later
+remediation or by hand.
+Actions:
+	1. set_key: file, key, value, separator (optional, " " by default)
+	2. chmod: file, mode (base 8)
+	3. chown: file, uid:gid
+	4. sysctl: key, value, it's persisted in SysctlConf
+	5. service_disable: unit
+*/
+
+const (
+	BackupDir = "remediation_backup"
+	// sysctl values which are set by remediations
+	SysctlConf  = "/etc/sysctl.d/99-elkeid-baseline.conf"
+	journalFile = "journal.json"
+)
+
+var (
+	ErrBadId     = errors.New("invalid remediation id")
+	ErrNoJournal = errors.New("remediation journal doesn't exist")
+	ErrConflict  = errors.New("remediation target is changed afterwards")
+)
+
+// ids are used as directory names
+var idReg = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z_-]{0,63}$`)
+
+// Action is a remediation step of a check
+type Action struct {
+	Type  string   `yaml:"type" json:"type"`
+	Param []string `yaml:"param" json:"param"`
+}
+
+// Entry the state before an action, which is restored by the rollback
+type Entry struct {
+	Action Action `json:"action"`
+	File   string `json:"file,omitempty"`
+	// copy of the file in the backup directory, "" if the file didn't exist
+	Backup  string `json:"backup,omitempty"`
+	Existed bool   `json:"existed"`
+	Mode    uint32 `json:"mode,omitempty"`
+	Uid     int    `json:"uid,omitempty"`
+	Gid     int    `json:"gid,omitempty"`
+	// previous value of the sysctl key
+	SysctlValue string `json:"sysctl_value,omitempty"`
+	// previous state of the service
+	Enabled bool `json:"enabled,omitempty"`
+	Active  bool `json:"active,omitempty"`
+	// the action is run
+	Done bool `json:"done"`
+	// sha256 of the file after the action
+	Sum string `json:"sum,omitempty"`
+}
+
+// Journal all of the actions of a remediation
+type Journal struct {
+	Id         string `json:"id"`
+	CreateTime int64  `json:"create_time"`
+	// nanoseconds of the create time, journals are ordered by it
+	CreateNano int64   `json:"create_nano,omitempty"`
+	RolledBack bool    `json:"rolled_back"`
+	Entries    []Entry `json:"entries"`
+}
+
+// Result of a remediation or a rollback
+type Result struct {
+	Action Action `json:"action"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Msg    string `json:"msg"`
+}
+
+// Validate checks the type and the params of an action
+func (a Action) Validate() error {
+	n := len(a.Param)
+	switch a.Type {
+	case "set_key":
+		if n < 3 || n > 4 {
+			return fmt.Errorf("set_key needs file, key, value and an optional separator")
+		}
+	case "chmod":
+		if n != 2 {
+			return fmt.Errorf("chmod needs file and mode")
+		}
+		if _, err := strconv.ParseUint(a.Param[1], 8, 32); err != nil {
+			return fmt.Errorf("chmod mode %s isn't octal", a.Param[1])
+		}
+	case "chown":
+		if n != 2 {
+			return fmt.Errorf("chown needs file and uid:gid")
+		}
+		if _, _, err := parseOwner(a.Param[1]); err != nil {
+			return err
+		}
+	case "sysctl":
+		if n != 2 {
+			return fmt.Errorf("sysctl needs key and value")
+		}
+		if strings.Contains(a.Param[0], "..") || strings.Contains(a.Param[0], "/") {
+			return fmt.Errorf("invalid sysctl key %s", a.Param[0])
+		}
+	case "service_disable":
+		if n != 1 {
+			return fmt.Errorf("service_disable needs unit")
+		}
+	default:
+		return fmt.Errorf("unknown remediation type %s", a.Type)
+	}
+	if a.Param[0] == "" {
+		return fmt.Errorf("%s: empty param", a.Type)
+	}
+	if a.Type != "sysctl" && a.Type != "service_disable" && !filepath.IsAbs(a.Param[0]) {
+		return fmt.Errorf("%s: %s isn't an absolute path", a.Type, a.Param[0])
+	}
+	return nil
+}
+
+func parseOwner(owner string) (uid, gid int, err error) {
+	res := strings.Split(owner, ":")
+	if len(res) != 2 {
+		return 0, 0, fmt.Errorf("owner %s isn't uid:gid", owner)
+	}
+	if uid, err = strconv.Atoi(res[0]); err != nil {
+		return
+	}
+	gid, err = strconv.Atoi(res[1])
+	return
+}
+
+func journalDir(id string) string {
+	return filepath.Join(BackupDir, id)
+}
+
+func (j *Journal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(journalDir(j.Id), journalFile)
+	if err = ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadJournal returns the journal of a remediation
+func LoadJournal(id string) (*Journal, error) {
+	if !idReg.MatchString(id) {
+		return nil, ErrBadId
+	}
+	data, err := ioutil.ReadFile(filepath.Join(journalDir(id), journalFile))
+	if err != nil {
+		return nil, ErrNoJournal
+	}
+	j := new(Journal)
+	err = json.Unmarshal(data, j)
+	return j, err
+}
+
+// snapshot records the state which an action changes, and copies the file
+func (j *Journal) snapshot(a Action) (entry Entry, err error) {
+	entry.Action = a
+	switch a.Type {
+	case "sysctl":
+		entry.SysctlValue, err = sysctlGet(a.Param[0])
+		if err != nil {
+			return
+		}
+		entry.File = SysctlConf
+	case "service_disable":
+		entry.Enabled = systemctl("is-enabled", a.Param[0]) == nil
+		entry.Active = systemctl("is-active", a.Param[0]) == nil
+		return
+	default:
+		entry.File = a.Param[0]
+	}
+
+	fi, err := os.Stat(entry.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entry, nil
+		}
+		return
+	}
+	entry.Existed = true
+	entry.Mode = unixMode(fi.Mode())
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		entry.Uid, entry.Gid = int(st.Uid), int(st.Gid)
+	}
+	// only the mode and the owner of a directory are changed
+	if fi.IsDir() && (a.Type == "chmod" || a.Type == "chown") {
+		return entry, nil
+	}
+	if !fi.Mode().IsRegular() {
+		return entry, fmt.Errorf("%s isn't a regular file", entry.File)
+	}
+	data, err := ioutil.ReadFile(entry.File)
+	if err != nil {
+		return
+	}
+	entry.Backup = filepath.Join(journalDir(j.Id), fmt.Sprintf("%d%s", len(j.Entries), strings.ReplaceAll(entry.File, "/", "_")))
+	err = ioutil.WriteFile(entry.Backup, data, 0600)
+	return
+}
+
+// Apply runs the actions of a remediation, the actions which are done are
+// rolled back if one of them fails
+func Apply(id string, actions []Action) (results []Result, err error) {
+	if !idReg.MatchString(id) {
+		return nil, ErrBadId
+	}
+	for _, a := range actions {
+		if err = a.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = os.Stat(journalDir(id)); err == nil {
+		return nil, fmt.Errorf("remediation %s has been run", id)
+	}
+	if err = os.MkdirAll(journalDir(id), 0700); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	j := &Journal{Id: id, CreateTime: now.Unix(), CreateNano: now.UnixNano()}
+
+	for _, a := range actions {
+		entry, err := j.snapshot(a)
+		if err != nil {
+			results = append(results, Result{Action: a, Msg: err.Error()})
+			return results, j.fail(err)
+		}
+		// the journal is saved before the action, so a crash can be rolled back
+		j.Entries = append(j.Entries, entry)
+		if err = j.save(); err != nil {
+			return results, j.fail(err)
+		}
+		res := Result{Action: a, Before: entry.describe()}
+		if err = run(a); err != nil {
+			res.Msg = err.Error()
+			results = append(results, res)
+			return results, j.fail(err)
+		}
+		j.Entries[len(j.Entries)-1].Done = true
+		if entry.File != "" {
+			j.Entries[len(j.Entries)-1].Sum = fileSum(entry.File)
+		}
+		if err = j.save(); err != nil {
+			return results, err
+		}
+		res.After = current(a)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (j *Journal) fail(err error) error {
+	if rollbackErr := j.rollback(); rollbackErr != nil {
+		return fmt.Errorf("%s, and rollback error: %s", err.Error(), rollbackErr.Error())
+	}
+	return err
+}
+
+// Rollback restores the state before a remediation. A journal is only rolled
+// back once, the retry of a rollback does nothing.
+func Rollback(id string) (results []Result, err error) {
+	j, err := LoadJournal(id)
+	if err != nil {
+		return nil, err
+	}
+	if j.RolledBack {
+		return nil, nil
+	}
+	if err = j.checkConflict(); err != nil {
+		return nil, err
+	}
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		results = append(results, Result{Action: j.Entries[i].Action, Before: current(j.Entries[i].Action)})
+	}
+	err = j.rollback()
+	for i := range results {
+		results[i].After = current(results[i].Action)
+	}
+	return results, err
+}
+
+// order of the journals, the journals without CreateNano are in seconds
+func (j *Journal) order() int64 {
+	if j.CreateNano != 0 {
+		return j.CreateNano
+	}
+	return j.CreateTime * int64(time.Second)
+}
+
+// targets which are changed by the entries: files, sysctl keys and services
+func (j *Journal) targets() map[string]bool {
+	targets := make(map[string]bool)
+	for _, entry := range j.Entries {
+		switch entry.Action.Type {
+		case "sysctl":
+			targets["sysctl:"+entry.Action.Param[0]] = true
+		case "service_disable":
+			targets["service:"+entry.Action.Param[0]] = true
+		}
+		if entry.File != "" {
+			targets[entry.File] = true
+		}
+	}
+	return targets
+}
+
+// checkConflict returns ErrConflict if a target of the journal is changed by
+// a later remediation which isn't rolled back, or a file is changed after
+// the last action on it
+func (j *Journal) checkConflict() error {
+	targets := j.targets()
+	dirs, _ := ioutil.ReadDir(BackupDir)
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == j.Id {
+			continue
+		}
+		other, err := LoadJournal(dir.Name())
+		if err != nil || other.RolledBack || other.order() <= j.order() {
+			continue
+		}
+		for target := range other.targets() {
+			if targets[target] {
+				return fmt.Errorf("%w: %s is changed by remediation %s", ErrConflict, target, other.Id)
+			}
+		}
+	}
+
+	// the sum after the last action of each file, empty if it isn't done
+	sums := make(map[string]string)
+	for _, entry := range j.Entries {
+		if entry.File == "" {
+			continue
+		}
+		sums[entry.File] = ""
+		if entry.Done {
+			sums[entry.File] = entry.Sum
+		}
+	}
+	for file, sum := range sums {
+		if sum != "" && fileSum(file) != sum {
+			return fmt.Errorf("%w: %s is modified after the remediation", ErrConflict, file)
+		}
+	}
+	return nil
+}
+
+// fileSum the sha256 of a regular file, "-" for others, "" if it doesn't exist
+func fileSum(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if !fi.Mode().IsRegular() {
+		return "-"
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// rollback restores the entries in reverse order. An entry which isn't done
+// may be run partly before a crash, restoring the snapshot is harmless anyway.
+func (j *Journal) rollback() error {
+	var errList []string
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := &j.Entries[i]
+		if err := entry.restore(); err != nil {
+			errList = append(errList, err.Error())
+			continue
+		}
+		entry.Done = false
+	}
+	j.RolledBack = len(errList) == 0
+	if err := j.save(); err != nil {
+		errList = append(errList, err.Error())
+	}
+	if len(errList) != 0 {
+		return errors.New(strings.Join(errList, "; "))
+	}
+	return nil
+}
+
+func (entry *Entry) restore() error {
+	switch entry.Action.Type {
+	case "service_disable":
+		unit := entry.Action.Param[0]
+		if entry.Enabled {
+			if err := systemctl("enable", unit); err != nil {
+				return err
+			}
+		}
+		if entry.Active {
+			return systemctl("start", unit)
+		}
+		return nil
+	case "sysctl":
+		if err := sysctlSet(entry.Action.Param[0], entry.SysctlValue); err != nil {
+			return err
+		}
+	}
+	if !entry.Existed {
+		if err := os.Remove(entry.File); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if entry.Backup != "" {
+		data, err := ioutil.ReadFile(entry.Backup)
+		if err != nil {
+			return err
+		}
+		if err = writeFile(entry.File, data, fileMode(entry.Mode)); err != nil {
+			return err
+		}
+	}
+	if err := os.Chown(entry.File, entry.Uid, entry.Gid); err != nil {
+		return err
+	}
+	// chown clears setuid and setgid, so chmod is the last one
+	return os.Chmod(entry.File, fileMode(entry.Mode))
+}
+
+// describe the state of an entry for the report
+func (entry *Entry) describe() string {
+	switch entry.Action.Type {
+	case "sysctl":
+		return entry.SysctlValue
+	case "service_disable":
+		return serviceState(entry.Enabled, entry.Active)
+	case "chmod":
+		if entry.Existed {
+			return fmt.Sprintf("%o", entry.Mode)
+		}
+	case "chown":
+		if entry.Existed {
+			return fmt.Sprintf("%d:%d", entry.Uid, entry.Gid)
+		}
+	case "set_key":
+		if entry.Existed {
+			line, _ := getKey(entry.File, entry.Action.Param[1])
+			return line
+		}
+	}
+	return ""
+}
+
+// current state of the target of an action
+func current(a Action) string {
+	entry := Entry{Action: a, File: a.Param[0], Existed: true}
+	switch a.Type {
+	case "sysctl":
+		entry.SysctlValue, _ = sysctlGet(a.Param[0])
+	case "service_disable":
+		entry.Enabled = systemctl("is-enabled", a.Param[0]) == nil
+		entry.Active = systemctl("is-active", a.Param[0]) == nil
+	default:
+		fi, err := os.Stat(a.Param[0])
+		if err != nil {
+			return ""
+		}
+		entry.Mode = unixMode(fi.Mode())
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			entry.Uid, entry.Gid = int(st.Uid), int(st.Gid)
+		}
+	}
+	return entry.describe()
+}
+
+// unixMode the permission bits of a file like chmod, e.g. 04755
+func unixMode(mode os.FileMode) uint32 {
+	ret := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		ret |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		ret |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		ret |= syscall.S_ISVTX
+	}
+	return ret
+}
+
+// fileMode converts the permission bits of chmod to os.FileMode
+func fileMode(mode uint32) os.FileMode {
+	ret := os.FileMode(mode).Perm()
+	if mode&syscall.S_ISUID != 0 {
+		ret |= os.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		ret |= os.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		ret |= os.ModeSticky
+	}
+	return ret
+}
+
+func serviceState(enabled, active bool) string {
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	if active {
+		return state + ",active"
+	}
+	return state + ",inactive"
+}
+
+// run an action
+func run(a Action) error {
+	switch a.Type {
+	case "set_key":
+		sep := " "
+		if len(a.Param) == 4 {
+			sep = a.Param[3]
+		}
+		return setKey(a.Param[0], a.Param[1], a.Param[2], sep)
+	case "chmod":
+		mode, _ := strconv.ParseUint(a.Param[1], 8, 32)
+		return os.Chmod(a.Param[0], fileMode(uint32(mode)))
+	case "chown":
+		uid, gid, _ := parseOwner(a.Param[1])
+		return os.Chown(a.Param[0], uid, gid)
+	case "sysctl":
+		if err := sysctlSet(a.Param[0], a.Param[1]); err != nil {
+			return err
+		}
+		return setKey(SysctlConf, a.Param[0], a.Param[1], " = ")
+	case "service_disable":
+		return systemctl("disable", "--now", a.Param[0])
+	}
+	return fmt.Errorf("unknown remediation type %s", a.Type)
+}
+
+// getKey returns the first line of a file which sets a key, comments are skipped
+func getKey(path, key string) (string, int) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", -1
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if keyOfLine(line) == key {
+			return strings.TrimSpace(line), i
+		}
+	}
+	return "", -1
+}
+
+func keyOfLine(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	if i := strings.IndexAny(line, " \t="); i > 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// setKey sets the first line of a key, the other lines of the key are
+// commented, and the key is appended if there is no line of it
+func setKey(path, key, value, sep string) error {
+	var lines []string
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	newLine := key + sep + value
+	found := false
+	for i, line := range lines {
+		if keyOfLine(line) != key {
+			continue
+		}
+		if found {
+			lines[i] = "# " + line
+			continue
+		}
+		lines[i] = newLine
+		found = true
+	}
+	if !found {
+		lines = append(lines, newLine)
+	}
+	return writeFile(path, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
+
+// writeFile replaces a file by rename, the mode and the owner are kept
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".elkeid.tmp"
+	if err := ioutil.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			_ = os.Chown(tmp, int(st.Uid), int(st.Gid))
+		}
+		_ = os.Chmod(tmp, fi.Mode().Perm())
+	}
+	return os.Rename(tmp, path)
+}
+
+func sysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+}
+
+func sysctlGet(key string) (string, error) {
+	data, err := ioutil.ReadFile(sysctlPath(key))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func sysctlSet(key, value string) error {
+	return ioutil.WriteFile(sysctlPath(key), []byte(value), 0644)
+}
+
+func systemctl(args ...string) error {
+	return exec.Command("systemctl", args...).Run()
+}
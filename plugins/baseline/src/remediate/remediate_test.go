@@ -0,0 +1,298 @@
+package remediate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempRoot runs a test in a temp directory, so the journals are written
+// under it, and returns the directory
+func tempRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	return root
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSetKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		exist   bool
+		key     string
+		value   string
+		sep     string
+		want    string
+	}{
+		{
+			name:    "replace",
+			content: "# PASS_MAX_DAYS 0\nPASS_MAX_DAYS 99999\nPASS_MIN_DAYS 0\n",
+			exist:   true,
+			key:     "PASS_MAX_DAYS", value: "90", sep: " ",
+			want: "# PASS_MAX_DAYS 0\nPASS_MAX_DAYS 90\nPASS_MIN_DAYS 0\n",
+		},
+		{
+			name:    "replace with separator",
+			content: "net.ipv4.ip_forward=1\n",
+			exist:   true,
+			key:     "net.ipv4.ip_forward", value: "0", sep: " = ",
+			want: "net.ipv4.ip_forward = 0\n",
+		},
+		{
+			name:    "append",
+			content: "PASS_MIN_DAYS 0",
+			exist:   true,
+			key:     "PASS_MAX_DAYS", value: "90", sep: " ",
+			want: "PASS_MIN_DAYS 0\nPASS_MAX_DAYS 90\n",
+		},
+		{
+			name:  "create",
+			exist: false,
+			key:   "PermitRootLogin", value: "no", sep: " ",
+			want: "PermitRootLogin no\n",
+		},
+		{
+			name:    "comment duplicates",
+			content: "PermitRootLogin yes\nPort 22\n\tPermitRootLogin without-password\nPermitRootLogin=yes\n",
+			exist:   true,
+			key:     "PermitRootLogin", value: "no", sep: " ",
+			want: "PermitRootLogin no\nPort 22\n# \tPermitRootLogin without-password\n# PermitRootLogin=yes\n",
+		},
+		{
+			name:    "prefix isn't the key",
+			content: "PermitRootLoginX yes\n",
+			exist:   true,
+			key:     "PermitRootLogin", value: "no", sep: " ",
+			want: "PermitRootLoginX yes\nPermitRootLogin no\n",
+		},
+	}
+	root := tempRoot(t)
+	for _, tt := range tests {
+		path := filepath.Join(root, tt.name)
+		if tt.exist {
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0640); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := setKey(path, tt.key, tt.value, tt.sep); err != nil {
+			t.Errorf("%s: setKey() error %v", tt.name, err)
+			continue
+		}
+		if got := readFile(t, path); got != tt.want {
+			t.Errorf("%s: setKey() = %q, want %q", tt.name, got, tt.want)
+		}
+		if fi, err := os.Stat(path); err == nil && tt.exist && fi.Mode().Perm() != 0640 {
+			t.Errorf("%s: mode = %o, want 640", tt.name, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions func(root string) []Action
+		wantErr bool
+		// content of login.defs after Apply
+		want string
+		// mode of login.defs after Apply
+		wantMode os.FileMode
+	}{
+		{
+			name: "success",
+			actions: func(root string) []Action {
+				return []Action{
+					{Type: "set_key", Param: []string{filepath.Join(root, "login.defs"), "PASS_MAX_DAYS", "90"}},
+					{Type: "chmod", Param: []string{filepath.Join(root, "login.defs"), "600"}},
+				}
+			},
+			want:     "PASS_MAX_DAYS 90\nPASS_MIN_DAYS 0\n",
+			wantMode: 0600,
+		},
+		{
+			name: "failure rolls back earlier actions",
+			actions: func(root string) []Action {
+				return []Action{
+					{Type: "set_key", Param: []string{filepath.Join(root, "login.defs"), "PASS_MAX_DAYS", "90"}},
+					{Type: "chmod", Param: []string{filepath.Join(root, "login.defs"), "600"}},
+					{Type: "chmod", Param: []string{filepath.Join(root, "missing"), "600"}},
+				}
+			},
+			wantErr:  true,
+			want:     "PASS_MAX_DAYS 99999\nPASS_MIN_DAYS 0\n",
+			wantMode: 0644,
+		},
+		{
+			name: "invalid action runs nothing",
+			actions: func(root string) []Action {
+				return []Action{
+					{Type: "set_key", Param: []string{filepath.Join(root, "login.defs"), "PASS_MAX_DAYS", "90"}},
+					{Type: "chmod", Param: []string{"relative", "600"}},
+				}
+			},
+			wantErr:  true,
+			want:     "PASS_MAX_DAYS 99999\nPASS_MIN_DAYS 0\n",
+			wantMode: 0644,
+		},
+	}
+	for i, tt := range tests {
+		root := tempRoot(t)
+		path := filepath.Join(root, "login.defs")
+		if err := ioutil.WriteFile(path, []byte("PASS_MAX_DAYS 99999\nPASS_MIN_DAYS 0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		id := "apply-" + string(rune('a'+i))
+		_, err := Apply(id, tt.actions(root))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Apply() error %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if got := readFile(t, path); got != tt.want {
+			t.Errorf("%s: content = %q, want %q", tt.name, got, tt.want)
+		}
+		if fi, _ := os.Stat(path); fi.Mode().Perm() != tt.wantMode {
+			t.Errorf("%s: mode = %o, want %o", tt.name, fi.Mode().Perm(), tt.wantMode)
+		}
+	}
+}
+
+func TestApplyTwice(t *testing.T) {
+	root := tempRoot(t)
+	path := filepath.Join(root, "login.defs")
+	actions := []Action{{Type: "set_key", Param: []string{path, "PASS_MAX_DAYS", "90"}}}
+	if _, err := Apply("twice", actions); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply("twice", actions); err == nil {
+		t.Error("Apply() of the same id twice, want error")
+	}
+	if _, err := Apply("../twice", actions); err != ErrBadId {
+		t.Errorf("Apply() of a bad id error %v, want %v", err, ErrBadId)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	root := tempRoot(t)
+	path := filepath.Join(root, "login.defs")
+	created := filepath.Join(root, "created.conf")
+	origin := "PASS_MAX_DAYS 99999\n"
+	if err := ioutil.WriteFile(path, []byte(origin), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Apply("rollback", []Action{
+		{Type: "set_key", Param: []string{path, "PASS_MAX_DAYS", "90"}},
+		{Type: "chmod", Param: []string{path, "600"}},
+		{Type: "set_key", Param: []string{created, "key", "value"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		results, err := Rollback("rollback")
+		if err != nil {
+			t.Fatalf("Rollback() #%d error %v", i, err)
+		}
+		if i == 0 && len(results) != 3 {
+			t.Errorf("Rollback() results %d, want 3", len(results))
+		}
+		if i == 1 && len(results) != 0 {
+			t.Errorf("Rollback() again results %d, want 0", len(results))
+		}
+		if got := readFile(t, path); got != origin {
+			t.Errorf("Rollback() #%d content = %q, want %q", i, got, origin)
+		}
+		if fi, _ := os.Stat(path); fi.Mode().Perm() != 0644 {
+			t.Errorf("Rollback() #%d mode = %o, want 644", i, fi.Mode().Perm())
+		}
+		if _, err := os.Stat(created); !os.IsNotExist(err) {
+			t.Errorf("Rollback() #%d created file isn't removed", i)
+		}
+	}
+	j, err := LoadJournal("rollback")
+	if err != nil || !j.RolledBack {
+		t.Errorf("journal rolled back = %v, error %v", j != nil && j.RolledBack, err)
+	}
+	if _, err := Rollback("missing"); err != ErrNoJournal {
+		t.Errorf("Rollback() of a missing journal error %v, want %v", err, ErrNoJournal)
+	}
+}
+
+func TestRollbackConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		// change after the first remediation
+		change  func(path string) error
+		wantErr error
+	}{
+		{
+			name:   "no change",
+			change: func(string) error { return nil },
+		},
+		{
+			name: "later remediation",
+			change: func(path string) error {
+				_, err := Apply("later", []Action{{Type: "set_key", Param: []string{path, "PASS_MIN_DAYS", "7"}}})
+				return err
+			},
+			wantErr: ErrConflict,
+		},
+		{
+			name: "later remediation rolled back",
+			change: func(path string) error {
+				if _, err := Apply("later", []Action{{Type: "chmod", Param: []string{path, "640"}}}); err != nil {
+					return err
+				}
+				_, err := Rollback("later")
+				return err
+			},
+		},
+		{
+			name: "changed by hand",
+			change: func(path string) error {
+				return ioutil.WriteFile(path, []byte("PASS_MAX_DAYS 90\nPASS_WARN_AGE 7\n"), 0644)
+			},
+			wantErr: ErrConflict,
+		},
+	}
+	for _, tt := range tests {
+		root := tempRoot(t)
+		path := filepath.Join(root, "login.defs")
+		if err := ioutil.WriteFile(path, []byte("PASS_MAX_DAYS 99999\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Apply("first", []Action{{Type: "set_key", Param: []string{path, "PASS_MAX_DAYS", "90"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.change(path); err != nil {
+			t.Fatalf("%s: change error %v", tt.name, err)
+		}
+		before := readFile(t, path)
+		_, err := Rollback("first")
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: Rollback() error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr != nil && readFile(t, path) != before {
+			t.Errorf("%s: refused rollback changes the file", tt.name)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/bytedance/Elkeid/plugins/collector/engine"
 	"github.com/bytedance/Elkeid/plugins/collector/process"
 	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
 	"go.uber.org/zap"
 )
 
@@ -47,6 +49,7 @@ var (
 	}
 	nginxRule = &AppRule{
 		name:              "nginx",
+		riskFunc:          nginxRisks,
 		_type:             "web_service",
 		versionRegex:      regexp.MustCompile(`nginx\/(\d+\.)+\d+`),
 		versionTrimPrefix: "nginx/",
@@ -67,6 +70,7 @@ var (
 		},
 		sub: &AppRule{
 			name:              "tegine",
+			riskFunc:          nginxRisks,
 			_type:             "web_service",
 			versionRegex:      regexp.MustCompile(`Tengine\/(\d+\.)+\d+`),
 			versionTrimPrefix: `Tengine/`,
@@ -87,6 +91,7 @@ var (
 			},
 			sub: &AppRule{
 				name:              "openresty",
+				riskFunc:          nginxRisks,
 				_type:             "web_service",
 				versionRegex:      regexp.MustCompile(`openresty\/(\d+\.)+\d+`),
 				versionTrimPrefix: `openresty/`,
@@ -110,6 +115,7 @@ var (
 	}
 	redisRule = &AppRule{
 		name:              "redis",
+		riskFunc:          redisRisks,
 		_type:             "database",
 		versionRegex:      regexp.MustCompile(`v=(\d+\.)+\d+`),
 		versionArgs:       []string{"-v"},
@@ -155,6 +161,7 @@ var (
 	}
 	mysqlRule = &AppRule{
 		name:              "mysql",
+		riskFunc:          mysqlRisks,
 		_type:             "database",
 		versionRegex:      regexp.MustCompile(`Ver\s(\d+\.)+\d+\S+`),
 		versionArgs:       []string{"-V"},
@@ -305,6 +312,7 @@ var (
 	}
 	dockerRule = &AppRule{
 		name:              "docker",
+		riskFunc:          dockerRisks,
 		_type:             "container_component",
 		versionRegex:      regexp.MustCompile(`Docker\sversion\s(\d+\.)+\d+`),
 		versionTrimPrefix: "Docker version ",
@@ -396,6 +404,7 @@ type App struct {
 	Type    string
 	Conf    string
 	Matched bool
+	Risks   []*AppRisk
 }
 type AppRule struct {
 	name              string
@@ -405,6 +414,7 @@ type AppRule struct {
 	versionTrimPrefix string
 	versionTrimSuffix string
 	confFunc          func(RuleContext) string
+	riskFunc          func(RuleContext, string) []*AppRisk
 	sub               *AppRule
 }
 type RuleContext struct {
@@ -419,6 +429,8 @@ type RuleContext struct {
 	ppid           string
 	proc           process.Process
 	appVersion     string
+	// log in to the local mysqld to check the empty root password
+	mysqlProbe bool
 }
 
 func (r *AppRule) GenerateApp(rc RuleContext) ([]byte, *App) {
@@ -472,6 +484,9 @@ func (r *AppRule) GenerateApp(rc RuleContext) ([]byte, *App) {
 	if r.confFunc != nil {
 		app.Conf = r.confFunc(rc)
 	}
+	if r.riskFunc != nil {
+		app.Risks = r.riskFunc(rc, app.Conf)
+	}
 	return output, app
 }
 
@@ -509,7 +524,34 @@ func ExecAs(enterContainer bool, uid, gid uint32, dir string, containerID, name
 	}
 }
 
-type AppHandler struct{}
+// options of the schedule policy
+const appOptionMysqlProbe = "mysql_login_probe"
+
+type AppHandler struct {
+	// 1 if the login probe of mysql is enabled by policy
+	mysqlProbe int32
+}
+
+func (h *AppHandler) CheckOptions(options map[string]string) error {
+	for k, v := range options {
+		if k != appOptionMysqlProbe {
+			return fmt.Errorf("unknown option %s", k)
+		}
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// the probe is off unless enabled by policy
+func (h *AppHandler) SetOptions(options map[string]string) {
+	var probe int32
+	if ok, _ := strconv.ParseBool(options[appOptionMysqlProbe]); ok {
+		probe = 1
+	}
+	atomic.StoreInt32(&h.mysqlProbe, probe)
+}
 
 func (h *AppHandler) Name() string {
 	return "app"
@@ -517,6 +559,9 @@ func (h *AppHandler) Name() string {
 func (h *AppHandler) DataType() int {
 	return 5060
 }
+func (h *AppHandler) DataTypes() []int {
+	return []int{h.DataType(), appRiskDataType}
+}
 
 func (h *AppHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
 	procs, err := process.Processes(false)
@@ -582,6 +627,7 @@ func (h *AppHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string)
 				appVersion:     version,
 				comm:           comm,
 				dir:            dir,
+				mysqlProbe:     atomic.LoadInt32(&h.mysqlProbe) == 1,
 			})
 			if app != nil {
 				versionCache[pns+exe] = version
@@ -603,6 +649,25 @@ func (h *AppHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string)
 						},
 					},
 				})
+				for _, risk := range app.Risks {
+					rec := &plugins.Record{
+						DataType:  appRiskDataType,
+						Timestamp: time.Now().Unix(),
+						Data: &plugins.Payload{
+							Fields: make(map[string]string, 15),
+						},
+					}
+					mapstructure.Decode(risk, &rec.Data.Fields)
+					rec.Data.Fields["name"] = app.Name
+					rec.Data.Fields["type"] = app.Type
+					rec.Data.Fields["conf"] = app.Conf
+					rec.Data.Fields["container_id"] = containerID
+					rec.Data.Fields["container_name"] = containerName
+					rec.Data.Fields["pid"] = proc.Pid()
+					rec.Data.Fields["exe"] = exe
+					rec.Data.Fields["package_seq"] = seq
+					c.SendRecord(rec)
+				}
 			}
 		}
 	}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// app config risks are reported with their own data type, joined with 5060 by agent_id+pid
+const appRiskDataType = 5061
+
+const (
+	maxConfSize  = 1024 * 1024
+	maxConfDepth = 8
+)
+
+// AppRisk is a single failed configuration check, shaped like a baseline check item.
+type AppRisk struct {
+	CheckID     string `mapstructure:"check_id"`
+	CheckName   string `mapstructure:"check_name"`
+	Security    string `mapstructure:"security"`
+	Description string `mapstructure:"description"`
+	Solution    string `mapstructure:"solution"`
+	Evidence    string `mapstructure:"evidence"`
+}
+
+var (
+	redisNoAuth = AppRisk{
+		CheckID:     "1",
+		CheckName:   "Redis access without password",
+		Security:    "high",
+		Description: "Neither requirepass nor an ACL password for the default user is configured, any client that can reach the port can execute commands.",
+		Solution:    "Set requirepass or configure an ACL password for the default user.",
+	}
+	redisProtectedModeOff = AppRisk{
+		CheckID:     "2",
+		CheckName:   "Redis protected mode disabled",
+		Security:    "mid",
+		Description: "protected-mode is disabled, redis accepts connections from any interface when no bind address or password is set.",
+		Solution:    "Set protected-mode yes, or bind redis to trusted interfaces only.",
+	}
+	nginxAutoindex = AppRisk{
+		CheckID:     "3",
+		CheckName:   "Nginx directory listing enabled",
+		Security:    "mid",
+		Description: "autoindex is on, the content of directories without an index file is listed to clients.",
+		Solution:    "Set autoindex off.",
+	}
+	mysqlEmptyRootPassword = AppRisk{
+		CheckID:     "4",
+		CheckName:   "MySQL listening on all interfaces with an empty root password",
+		Security:    "high",
+		Description: "mysqld is bound to all interfaces and root can log in without a password.",
+		Solution:    "Set a password for root, and set bind-address to a trusted interface.",
+	}
+	mysqlSkipGrantTables = AppRisk{
+		CheckID:     "5",
+		CheckName:   "MySQL privilege system disabled",
+		Security:    "high",
+		Description: "skip-grant-tables is enabled, every client can connect without a password and has all privileges.",
+		Solution:    "Remove skip-grant-tables from the configuration and restart mysqld.",
+	}
+	dockerRemoteAPI = AppRisk{
+		CheckID:     "6",
+		CheckName:   "Docker remote API without TLS authentication",
+		Security:    "high",
+		Description: "dockerd listens on a TCP socket without tlsverify, anyone who can reach it controls the host.",
+		Solution:    "Remove the TCP host, or enable tlsverify with client certificates.",
+	}
+	dockerSocketWritable = AppRisk{
+		CheckID:     "7",
+		CheckName:   "Docker socket is world writable",
+		Security:    "high",
+		Description: "The docker unix socket can be written by every local user, which is equivalent to root access.",
+		Solution:    "Restore the permission of the docker socket to 0660 root:docker.",
+	}
+)
+
+func newRisk(tmpl AppRisk, evidence string) *AppRisk {
+	tmpl.Evidence = evidence
+	return &tmpl
+}
+
+// host path of a file seen by the app, conf is relative to cwd if not absolute
+func appPath(rc RuleContext, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rc.dir, path)
+	}
+	if rc.enterContainer {
+		return filepath.Join("/proc", rc.proc.Pid(), "root", path)
+	}
+	return path
+}
+
+// globs a path inside the app root, returning app-visible paths
+func appGlob(rc RuleContext, pattern string) (ret []string) {
+	root := appPath(rc, "/")
+	matches, _ := filepath.Glob(appPath(rc, pattern))
+	for _, m := range matches {
+		if rel, err := filepath.Rel(root, m); err == nil {
+			ret = append(ret, "/"+rel)
+		}
+	}
+	return
+}
+
+func readConfLines(path string, f func(lineno int, line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s := bufio.NewScanner(io.LimitReader(file, maxConfSize))
+	s.Buffer(make([]byte, 64*1024), maxConfSize)
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		f(lineno, s.Text())
+	}
+	return s.Err()
+}
+
+// redis
+
+type redisConf struct {
+	values map[string]string
+	// where each value was set
+	source map[string]string
+	users  []string
+}
+
+func (c *redisConf) set(key, value, source string) {
+	c.values[key] = value
+	c.source[key] = source
+}
+
+func (c *redisConf) load(rc RuleContext, path string, depth int) {
+	if depth > maxConfDepth {
+		return
+	}
+	readConfLines(appPath(rc, path), func(lineno int, line string) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			return
+		}
+		key := strings.ToLower(fields[0])
+		value := strings.Trim(strings.Join(fields[1:], " "), `"'`)
+		source := path + ":" + strconv.Itoa(lineno)
+		switch key {
+		case "include":
+			for _, p := range appGlob(rc, value) {
+				c.load(rc, p, depth+1)
+			}
+		case "user":
+			c.users = append(c.users, line)
+		default:
+			c.set(key, value, source)
+		}
+	})
+}
+
+func redisRisks(rc RuleContext, conf string) (ret []*AppRisk) {
+	if conf == "" {
+		return
+	}
+	c := &redisConf{values: map[string]string{}, source: map[string]string{}}
+	c.load(rc, conf, 0)
+	// command line options override the config file
+	args := strings.Fields(rc.cmdline)
+	for i := 0; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "--") && i+1 < len(args) {
+			c.set(strings.ToLower(strings.TrimPrefix(args[i], "--")), args[i+1], "cmdline")
+			i++
+		}
+	}
+	if aclfile := c.values["aclfile"]; aclfile != "" {
+		readConfLines(appPath(rc, aclfile), func(_ int, line string) {
+			c.users = append(c.users, line)
+		})
+	}
+	if c.values["requirepass"] == "" && !redisDefaultUserHasPassword(c.users) {
+		ret = append(ret, newRisk(redisNoAuth, "requirepass is not set in "+conf))
+	}
+	if strings.EqualFold(c.values["protected-mode"], "no") {
+		ret = append(ret, newRisk(redisProtectedModeOff, "protected-mode no in "+c.source["protected-mode"]))
+	}
+	return
+}
+
+// user default on >password ~* +@all
+func redisDefaultUserHasPassword(users []string) bool {
+	for _, u := range users {
+		fields := strings.Fields(u)
+		if len(fields) < 2 || fields[0] != "user" || fields[1] != "default" {
+			continue
+		}
+		for _, rule := range fields[2:] {
+			if strings.HasPrefix(rule, ">") || strings.HasPrefix(rule, "#") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nginx
+
+type nginxDirective struct {
+	name   string
+	args   []string
+	source string
+}
+
+// flattens nginx conf into directives, following include
+func loadNginxConf(rc RuleContext, path string, depth int) (ret []nginxDirective) {
+	if depth > maxConfDepth {
+		return
+	}
+	f, err := os.Open(appPath(rc, path))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, maxConfSize))
+	if err != nil {
+		return
+	}
+	var (
+		words []string
+		word  strings.Builder
+		quote byte
+		line  = 1
+		start = 1
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			if len(words) == 0 {
+				start = line
+			}
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		if ch == '\n' {
+			line++
+		}
+		switch {
+		case quote != 0:
+			if ch == '\\' && i+1 < len(content) {
+				i++
+				word.WriteByte(content[i])
+			} else if ch == quote {
+				quote = 0
+			} else {
+				word.WriteByte(ch)
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			i--
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			flush()
+		case ch == ';' || ch == '{' || ch == '}':
+			flush()
+			if len(words) > 0 {
+				d := nginxDirective{name: words[0], args: words[1:], source: path + ":" + strconv.Itoa(start)}
+				if d.name == "include" && len(d.args) > 0 {
+					pattern := d.args[0]
+					if !filepath.IsAbs(pattern) {
+						pattern = filepath.Join(filepath.Dir(path), pattern)
+					}
+					for _, p := range appGlob(rc, pattern) {
+						ret = append(ret, loadNginxConf(rc, p, depth+1)...)
+					}
+				} else {
+					ret = append(ret, d)
+				}
+			}
+			words = nil
+		default:
+			word.WriteByte(ch)
+		}
+	}
+	return
+}
+
+func nginxRisks(rc RuleContext, conf string) (ret []*AppRisk) {
+	if conf == "" {
+		return
+	}
+	for _, d := range loadNginxConf(rc, conf, 0) {
+		if d.name == "autoindex" && len(d.args) > 0 && d.args[0] == "on" {
+			ret = append(ret, newRisk(nginxAutoindex, "autoindex on in "+d.source))
+		}
+	}
+	return
+}
+
+// mysql
+
+var mysqlSections = regexp.MustCompile(`^(mysqld|server|mariadb|mysqld-[\d.]+|mariadb-[\d.]+)$`)
+
+func loadMysqlConf(rc RuleContext, path string, depth int, values map[string]string) {
+	if depth > maxConfDepth {
+		return
+	}
+	inSection := false
+	readConfLines(appPath(rc, path), func(_ int, line string) {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "!includedir"):
+			dir := strings.TrimSpace(strings.TrimPrefix(line, "!includedir"))
+			for _, ext := range []string{"*.cnf", "*.ini"} {
+				for _, p := range appGlob(rc, filepath.Join(dir, ext)) {
+					loadMysqlConf(rc, p, depth+1, values)
+				}
+			}
+		case strings.HasPrefix(line, "!include"):
+			loadMysqlConf(rc, strings.TrimSpace(strings.TrimPrefix(line, "!include")), depth+1, values)
+		case strings.HasPrefix(line, "["):
+			inSection = mysqlSections.MatchString(strings.Trim(line, "[] "))
+		case inSection:
+			kv := strings.SplitN(line, "=", 2)
+			// options are case insensitive and '-' is the same as '_'
+			key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(kv[0])), "_", "-")
+			value := ""
+			if len(kv) == 2 {
+				value = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+			}
+			values[key] = value
+		}
+	})
+}
+
+func mysqlRisks(rc RuleContext, conf string) (ret []*AppRisk) {
+	values := map[string]string{}
+	if conf != "" {
+		loadMysqlConf(rc, conf, 0, values)
+	}
+	for _, arg := range strings.Fields(rc.cmdline) {
+		if strings.HasPrefix(arg, "--") {
+			kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+			key := strings.ReplaceAll(strings.ToLower(kv[0]), "_", "-")
+			if len(kv) == 2 {
+				values[key] = kv[1]
+			} else {
+				values[key] = ""
+			}
+		}
+	}
+	if _, ok := values["skip-grant-tables"]; ok {
+		ret = append(ret, newRisk(mysqlSkipGrantTables, "skip-grant-tables is set"))
+	}
+	if _, ok := values["skip-networking"]; ok {
+		return
+	}
+	// mysql listens on all interfaces by default
+	bind := values["bind-address"]
+	switch bind {
+	case "", "*", "0.0.0.0", "::":
+	default:
+		return
+	}
+	// the probe logs in to mysqld, which is recorded by its logs and counted by
+	// its failed login limits, so it's only run if enabled by policy.
+	// the probe connects through the host network stack, which can't reach a container's loopback
+	if !rc.mysqlProbe || rc.enterContainer {
+		return
+	}
+	port := values["port"]
+	if port == "" {
+		port = "3306"
+	}
+	ok, err := mysqlEmptyPasswordLogin(net.JoinHostPort("127.0.0.1", port), "root")
+	if err == nil && ok {
+		if bind == "" {
+			bind = "default"
+		}
+		ret = append(ret, newRisk(mysqlEmptyRootPassword, "bind-address: "+bind+", root logged in on port "+port+" with an empty password"))
+	}
+	return
+}
+
+func readMysqlPacket(conn net.Conn) (seq byte, payload []byte, err error) {
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload = make([]byte, length)
+	_, err = io.ReadFull(conn, payload)
+	return header[3], payload, err
+}
+
+func writeMysqlPacket(conn net.Conn, seq byte, payload []byte) error {
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+	_, err := conn.Write(append(header, payload...))
+	return err
+}
+
+// performs a protocol 41 handshake with an empty auth response, reporting whether the server accepted it
+func mysqlEmptyPasswordLogin(addr, user string) (bool, error) {
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	seq, handshake, err := readMysqlPacket(conn)
+	if err != nil {
+		return false, err
+	}
+	if len(handshake) == 0 || handshake[0] != 10 {
+		return false, errors.New("unsupported mysql protocol")
+	}
+	const (
+		clientLongPassword     = 0x00000001
+		clientProtocol41       = 0x00000200
+		clientSecureConnection = 0x00008000
+		clientPluginAuth       = 0x00080000
+	)
+	resp := make([]byte, 32, 64)
+	binary.LittleEndian.PutUint32(resp[0:], clientLongPassword|clientProtocol41|clientSecureConnection|clientPluginAuth)
+	binary.LittleEndian.PutUint32(resp[4:], 1<<24)
+	resp[8] = 33 // utf8_general_ci
+	resp = append(resp, user...)
+	// NUL terminator, then zero length auth response
+	resp = append(resp, 0, 0)
+	resp = append(resp, "mysql_native_password"...)
+	resp = append(resp, 0)
+	if err = writeMysqlPacket(conn, seq+1, resp); err != nil {
+		return false, err
+	}
+	for i := 0; i < 4; i++ {
+		var payload []byte
+		seq, payload, err = readMysqlPacket(conn)
+		if err != nil {
+			return false, err
+		}
+		if len(payload) == 0 {
+			return false, errors.New("empty mysql packet")
+		}
+		switch payload[0] {
+		case 0x00:
+			return true, nil
+		case 0xff:
+			return false, nil
+		case 0xfe:
+			// auth switch request, answer with an empty password again
+			if err = writeMysqlPacket(conn, seq+1, nil); err != nil {
+				return false, err
+			}
+		case 0x01:
+			// caching_sha2_password fast auth result, the final OK/ERR follows
+		default:
+			return false, errors.New("unexpected mysql packet")
+		}
+	}
+	return false, errors.New("too many mysql auth rounds")
+}
+
+// docker
+
+func dockerRisks(rc RuleContext, conf string) (ret []*AppRisk) {
+	var (
+		hosts     []string
+		tlsverify bool
+	)
+	if conf != "" {
+		if content, err := os.ReadFile(appPath(rc, conf)); err == nil {
+			daemon := struct {
+				Hosts     []string `json:"hosts"`
+				TLSVerify bool     `json:"tlsverify"`
+			}{}
+			if json.Unmarshal(content, &daemon) == nil {
+				hosts = append(hosts, daemon.Hosts...)
+				tlsverify = daemon.TLSVerify
+			}
+		}
+	}
+	args := strings.Fields(rc.cmdline)
+	for i, arg := range args {
+		switch {
+		case (arg == "-H" || arg == "--host") && i+1 < len(args):
+			hosts = append(hosts, args[i+1])
+		case strings.HasPrefix(arg, "-H="), strings.HasPrefix(arg, "--host="):
+			hosts = append(hosts, arg[strings.Index(arg, "=")+1:])
+		case strings.HasPrefix(arg, "-H") && len(arg) > 2:
+			hosts = append(hosts, arg[2:])
+		case arg == "--tlsverify" || arg == "--tlsverify=true":
+			tlsverify = true
+		}
+	}
+	socket := "/var/run/docker.sock"
+	for _, host := range hosts {
+		switch {
+		case strings.HasPrefix(host, "tcp://"):
+			if tlsverify {
+				continue
+			}
+			h, _, err := net.SplitHostPort(strings.TrimPrefix(host, "tcp://"))
+			if err == nil {
+				if ip := net.ParseIP(h); ip != nil && ip.IsLoopback() || h == "localhost" {
+					continue
+				}
+			}
+			ret = append(ret, newRisk(dockerRemoteAPI, "dockerd listens on "+host+" without tlsverify"))
+		case strings.HasPrefix(host, "unix://"):
+			socket = strings.TrimPrefix(host, "unix://")
+		}
+	}
+	if fi, err := os.Stat(appPath(rc, socket)); err == nil && fi.Mode()&os.ModeSocket != 0 && fi.Mode().Perm()&0o002 != 0 {
+		ret = append(ret, newRisk(dockerSocketWritable, socket+" mode "+fi.Mode().Perm().String()))
+	}
+	return
+}
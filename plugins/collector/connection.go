@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/port"
+	"github.com/bytedance/Elkeid/plugins/collector/utils"
+	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+)
+
+const (
+	// sockets are read by sock_diag every sample, short-lived flows are missed
+	// only if they are opened and closed between two samples
+	defaultConnSampleInterval = time.Second * 10
+	minConnSampleInterval     = time.Second
+	// the owners of sockets are resolved by walking the fds of all processes,
+	// which is paced and slow, so it runs in the background
+	connOwnerInterval = time.Minute * 5
+	// bounds the memory used between two reports
+	maxFlows     = 10000
+	maxFlowConns = 4096
+)
+
+// options of the schedule policy
+const connOptionSampleInterval = "sample_interval"
+
+// flows are aggregated per owner and remote endpoint, the ephemeral port
+// of the client side is dropped: inbound flows are keyed by the local port
+// and outbound ones by the remote port.
+type flowKey struct {
+	direction  string
+	family     string
+	protocol   string
+	exe        string
+	pns        string
+	localPort  string
+	remoteIP   string
+	remotePort string
+	// unix sockets
+	path    string
+	peerExe string
+}
+
+type flow struct {
+	conn          *port.Conn
+	inodes        map[string]struct{}
+	maxConcurrent int
+	samples       int
+	firstSeen     int64
+	lastSeen      int64
+}
+
+type Flow struct {
+	Direction  string `mapstructure:"direction"`
+	Family     string `mapstructure:"family"`
+	Protocol   string `mapstructure:"protocol"`
+	Type       string `mapstructure:"type"`
+	LocalIP    string `mapstructure:"local_ip"`
+	LocalPort  string `mapstructure:"local_port"`
+	RemoteIP   string `mapstructure:"remote_ip"`
+	RemotePort string `mapstructure:"remote_port"`
+	// loopback, private or public
+	RemoteScope   string `mapstructure:"remote_scope"`
+	Path          string `mapstructure:"path"`
+	PeerPid       string `mapstructure:"peer_pid"`
+	PeerExe       string `mapstructure:"peer_exe"`
+	Pid           string `mapstructure:"pid"`
+	Exe           string `mapstructure:"exe"`
+	Comm          string `mapstructure:"comm"`
+	Uid           string `mapstructure:"uid"`
+	Username      string `mapstructure:"username"`
+	ConnCount     string `mapstructure:"conn_count"`
+	MaxConcurrent string `mapstructure:"max_concurrent"`
+	Samples       string `mapstructure:"samples"`
+	FirstSeen     string `mapstructure:"first_seen"`
+	LastSeen      string `mapstructure:"last_seen"`
+}
+
+// ConnectionHandler samples established connections between reports, and
+// reports a summary of the flows seen during the interval.
+type ConnectionHandler struct {
+	once sync.Once
+	// sample interval set by policy in nanoseconds, 0 is the default one
+	interval int64
+	// serializes sampling
+	smu sync.Mutex
+	// guards owners
+	omu    sync.Mutex
+	owners *port.Owners
+	mu     sync.Mutex
+	flows  map[flowKey]*flow
+}
+
+func (h *ConnectionHandler) CheckOptions(options map[string]string) error {
+	for k, v := range options {
+		if k != connOptionSampleInterval {
+			return fmt.Errorf("unknown option %s", k)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if d < minConnSampleInterval {
+			return fmt.Errorf("%s: %s is less than %s", k, d, minConnSampleInterval)
+		}
+	}
+	return nil
+}
+
+func (h *ConnectionHandler) SetOptions(options map[string]string) {
+	d, _ := time.ParseDuration(options[connOptionSampleInterval])
+	atomic.StoreInt64(&h.interval, int64(d))
+}
+
+func (h *ConnectionHandler) sampleInterval() time.Duration {
+	if d := time.Duration(atomic.LoadInt64(&h.interval)); d >= minConnSampleInterval {
+		return d
+	}
+	return defaultConnSampleInterval
+}
+
+func (h *ConnectionHandler) refreshOwners() *port.Owners {
+	o, err := port.SocketOwners()
+	if err != nil {
+		zap.S().Error(err)
+		return nil
+	}
+	h.omu.Lock()
+	h.owners = o
+	h.omu.Unlock()
+	return o
+}
+
+func (h *ConnectionHandler) Name() string {
+	return "connection"
+}
+func (h *ConnectionHandler) DataType() int {
+	return 5065
+}
+
+func ipScope(s string) string {
+	ip := net.ParseIP(s)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsPrivate() || ip.IsLinkLocalUnicast():
+		return "private"
+	default:
+		return "public"
+	}
+}
+
+func (h *ConnectionHandler) sample() {
+	h.smu.Lock()
+	defer h.smu.Unlock()
+	h.omu.Lock()
+	o := h.owners
+	h.omu.Unlock()
+	if o == nil {
+		if o = h.refreshOwners(); o == nil {
+			return
+		}
+	}
+	conns := port.ConnectionsOf(o)
+	now := time.Now().Unix()
+	concurrent := map[flowKey]int{}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range conns {
+		k := flowKey{
+			direction: c.Direction,
+			family:    c.Family,
+			protocol:  c.Protocol,
+			exe:       c.Exe,
+			pns:       c.Pns,
+			path:      c.Path,
+			peerExe:   c.PeerExe,
+		}
+		if c.Direction == port.DirectionInbound {
+			k.localPort = c.Sport
+			k.remoteIP = c.Dip
+		} else if c.Direction == port.DirectionOutbound {
+			k.remoteIP = c.Dip
+			k.remotePort = c.Dport
+		}
+		f, ok := h.flows[k]
+		if !ok {
+			if len(h.flows) >= maxFlows {
+				continue
+			}
+			f = &flow{inodes: map[string]struct{}{}, firstSeen: now}
+			h.flows[k] = f
+		}
+		f.conn = c
+		f.lastSeen = now
+		if len(f.inodes) < maxFlowConns {
+			f.inodes[c.Inode] = struct{}{}
+		}
+		concurrent[k]++
+	}
+	for k, n := range concurrent {
+		f := h.flows[k]
+		f.samples++
+		if n > f.maxConcurrent {
+			f.maxConcurrent = n
+		}
+	}
+}
+
+func (h *ConnectionHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.once.Do(func() {
+		h.flows = map[flowKey]*flow{}
+		h.refreshOwners()
+		go func() {
+			for {
+				time.Sleep(connOwnerInterval)
+				h.refreshOwners()
+			}
+		}()
+		go func() {
+			for {
+				time.Sleep(h.sampleInterval())
+				h.sample()
+			}
+		}()
+	})
+	h.sample()
+	h.mu.Lock()
+	flows := h.flows
+	h.flows = map[flowKey]*flow{}
+	h.mu.Unlock()
+	for k, f := range flows {
+		fl := Flow{
+			Direction:     k.direction,
+			Family:        k.family,
+			Protocol:      k.protocol,
+			Type:          f.conn.Type,
+			LocalPort:     k.localPort,
+			RemoteIP:      k.remoteIP,
+			RemotePort:    k.remotePort,
+			Path:          k.path,
+			PeerPid:       f.conn.PeerPid,
+			PeerExe:       k.peerExe,
+			Pid:           f.conn.Pid,
+			Exe:           k.exe,
+			Comm:          f.conn.Comm,
+			Uid:           f.conn.Uid,
+			ConnCount:     strconv.Itoa(len(f.inodes)),
+			MaxConcurrent: strconv.Itoa(f.maxConcurrent),
+			Samples:       strconv.Itoa(f.samples),
+			FirstSeen:     strconv.FormatInt(f.firstSeen, 10),
+			LastSeen:      strconv.FormatInt(f.lastSeen, 10),
+		}
+		if k.direction != "" {
+			fl.LocalIP = f.conn.Sip
+			fl.RemoteScope = ipScope(k.remoteIP)
+		}
+		if fl.Uid != "" {
+			fl.Username, _ = utils.GetUsername(fl.Uid)
+		}
+		rec := &plugins.Record{
+			DataType:  int32(h.DataType()),
+			Timestamp: time.Now().Unix(),
+			Data: &plugins.Payload{
+				Fields: make(map[string]string, 24),
+			},
+		}
+		mapstructure.Decode(fl, &rec.Data.Fields)
+		m, _ := cache.Get(5056, k.pns)
+		rec.Data.Fields["container_id"] = m["container_id"]
+		rec.Data.Fields["container_name"] = m["container_name"]
+		rec.Data.Fields["package_seq"] = seq
+		c.SendRecord(rec)
+	}
+}
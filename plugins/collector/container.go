@@ -8,6 +8,7 @@ import (
 	"github.com/bytedance/Elkeid/plugins/collector/engine"
 	"github.com/bytedance/Elkeid/plugins/collector/process"
 	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
 )
 
 type ContainerHandler struct{}
@@ -18,6 +19,9 @@ func (h *ContainerHandler) Name() string {
 func (h *ContainerHandler) DataType() int {
 	return 5056
 }
+func (h *ContainerHandler) DataTypes() []int {
+	return []int{h.DataType(), containerRiskDataType}
+}
 
 type Container struct {
 	Id         string `mapstructure:"id"`
@@ -29,17 +33,24 @@ type Container struct {
 	Pns        string `mapstructure:"pns"`
 	Runtime    string `mapstructure:"runtime"`
 	CreateTime string `mapstructure:"create_time"`
+	Namespace  string `mapstructure:"namespace"`
 }
 
 func (h *ContainerHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
 	clients := container.NewClients()
+	// the same container may be reported by several clients, e.g. dockerd and its containerd (moby)
+	seen := map[string]bool{}
 	for _, client := range clients {
 		contaners, err := client.ListContainers(context.Background())
-		client.Close()
 		if err != nil {
+			client.Close()
 			continue
 		}
 		for _, ctr := range contaners {
+			if seen[ctr.ID] {
+				continue
+			}
+			seen[ctr.ID] = true
 			c.SendRecord(&plugins.Record{
 				DataType:  int32(h.DataType()),
 				Timestamp: time.Now().Unix(),
@@ -52,7 +63,8 @@ func (h *ContainerHandler) Handle(c *plugins.Client, cache *engine.Cache, seq st
 						"image_name":  ctr.ImageName,
 						"pid":         ctr.Pid,
 						"pns":         ctr.Pns,
-						"runtime":     ctr.State,
+						"runtime":     ctr.Runtime,
+						"namespace":   ctr.Namespace,
 						"create_time": ctr.CreateTime,
 						"package_seq": seq,
 					},
@@ -64,6 +76,36 @@ func (h *ContainerHandler) Handle(c *plugins.Client, cache *engine.Cache, seq st
 					"container_name": ctr.Name,
 				})
 			}
+			if ctr.State == container.StateName[int32(container.RUNNING)] {
+				h.sendRisks(c, client, ctr, seq)
+			}
+		}
+		client.Close()
+	}
+}
+
+// sendRisks checks the privileges of a running container
+func (h *ContainerHandler) sendRisks(c *plugins.Client, client container.Client, ctr container.Container, seq string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	config, err := client.Security(ctx, ctr)
+	cancel()
+	if err != nil {
+		return
+	}
+	for _, risk := range containerRisks(config) {
+		rec := &plugins.Record{
+			DataType:  containerRiskDataType,
+			Timestamp: time.Now().Unix(),
+			Data: &plugins.Payload{
+				Fields: make(map[string]string, 12),
+			},
 		}
+		mapstructure.Decode(risk, &rec.Data.Fields)
+		rec.Data.Fields["container_id"] = ctr.ID
+		rec.Data.Fields["container_name"] = ctr.Name
+		rec.Data.Fields["image_name"] = ctr.ImageName
+		rec.Data.Fields["runtime"] = ctr.Runtime
+		rec.Data.Fields["package_seq"] = seq
+		c.SendRecord(rec)
 	}
 }
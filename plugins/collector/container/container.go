@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -26,12 +27,18 @@ func IsNotFound(err error) bool {
 	if docker.IsErrNotFound(err) {
 		return true
 	}
+	var podmanErr *PodmanError
+	if errors.As(err, &podmanErr) && podmanErr.StatusCode == http.StatusNotFound {
+		return true
+	}
 	return false
 }
 
 type Client interface {
 	ListContainers(ctx context.Context) ([]Container, error)
 	Exec(ctx context.Context, containerID string, name string, arg ...string) ([]byte, error)
+	ImageLayers(ctx context.Context, ctr Container) (Layers, error)
+	Security(ctx context.Context, ctr Container) (*SecurityConfig, error)
 	Close()
 	Runtime() string
 }
@@ -45,6 +52,8 @@ type Container struct {
 	Pns        string
 	Runtime    string
 	CreateTime string
+	// containerd namespace
+	Namespace string
 }
 type criClient struct {
 	c  cri.RuntimeServiceClient
@@ -198,37 +207,66 @@ func (c *dockerClient) Exec(ctx context.Context, containerID string, name string
 }
 func (c *dockerClient) Close()          { c.c.Close() }
 func (c *dockerClient) Runtime() string { return "docker" }
+func dial(path string) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return grpc.DialContext(ctx, path,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithBlock(),
+		grpc.WithReturnConnectionError(),
+	)
+}
+
 func NewClients() []Client {
 	var clients []Client
-	var err error
-	var cc *grpc.ClientConn
 	for _, path := range []string{
 		"unix:///run/containerd/containerd.sock",
 		"unix:///run/crio/crio.sock",
 		"unix:///var/run/cri-dockerd.sock",
 	} {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		cc, err = grpc.DialContext(ctx, path,
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.FailOnNonTempDialError(true),
-			grpc.WithBlock(),
-			grpc.WithReturnConnectionError(),
-		)
-		if err == nil {
-			c := cri.NewRuntimeServiceClient(cc)
-			_, err := c.Version(context.Background(), &cri.VersionRequest{})
-			if err == nil {
-				clients = append(clients, &criClient{c: c, cc: cc})
-			} else {
-				cc.Close()
-			}
+		cc, err := dial(path)
+		if err != nil {
+			continue
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		c, err := newCRIClient(ctx, cc)
 		cancel()
+		if err == nil {
+			clients = append(clients, c)
+		} else {
+			cc.Close()
+		}
 	}
-	var client *docker.Client
-	client, err = docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	client, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
 	if err == nil {
 		clients = append(clients, &dockerClient{c: client})
 	}
+	// containers outside of kubernetes, e.g. started by nerdctl or dockerd (moby)
+	for _, path := range []string{
+		"unix:///run/containerd/containerd.sock",
+		"unix:///run/docker/containerd/containerd.sock",
+	} {
+		cc, err := dial(path)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		c, err := newContainerdClient(ctx, cc)
+		cancel()
+		if err == nil {
+			clients = append(clients, c)
+			break
+		}
+		cc.Close()
+	}
+	for _, path := range []string{"/run/podman/podman.sock"} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		c, err := newPodmanClient(ctx, path)
+		cancel()
+		if err == nil {
+			clients = append(clients, c)
+		}
+	}
 	return clients
 }
@@ -0,0 +1,464 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bytedance/Elkeid/plugins/collector/process"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// containerd services, messages are encoded by hand to avoid depending on the
+// containerd api module. ref. https://github.com/containerd/containerd/tree/v1.7.19/api
+const (
+	containerdNamespaceKey = "containerd-namespace"
+	// kubernetes containers are listed by the cri client
+	containerdK8sNamespace = "k8s.io"
+
+	methodListNamespaces = "/containerd.services.namespaces.v1.Namespaces/List"
+	methodListContainers = "/containerd.services.containers.v1.Containers/List"
+	methodGetContainer   = "/containerd.services.containers.v1.Containers/Get"
+	methodGetImage       = "/containerd.services.images.v1.Images/Get"
+	methodListTasks      = "/containerd.services.tasks.v1.Tasks/List"
+	methodExec           = "/containerd.services.tasks.v1.Tasks/Exec"
+	methodStart          = "/containerd.services.tasks.v1.Tasks/Start"
+	methodWait           = "/containerd.services.tasks.v1.Tasks/Wait"
+	methodDeleteProcess  = "/containerd.services.tasks.v1.Tasks/DeleteProcess"
+
+	processSpecType = "types.containerd.io/opencontainers/runtime-spec/1/Process"
+)
+
+// containerd.v1.types.Status
+var containerdStatus = map[uint64]string{
+	0: StateName[int32(UNKNOWN)],
+	1: StateName[int32(CREATED)],
+	2: StateName[int32(RUNNING)],
+	3: StateName[int32(EXITED)],
+}
+
+// rawCodec passes pre-encoded protobuf messages through grpc untouched
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *b, nil
+}
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// walkFields calls f for each field of a message, with the content of length
+// delimited fields in b and the value of varint fields in v.
+func walkFields(msg []byte, f func(num protowire.Number, b []byte, v uint64)) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		var (
+			b []byte
+			v uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(msg)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		f(num, b, v)
+	}
+	return nil
+}
+
+// map<string, string> entry
+func decodeMapEntry(b []byte) (key, value string) {
+	walkFields(b, func(num protowire.Number, b []byte, _ uint64) {
+		switch num {
+		case 1:
+			key = string(b)
+		case 2:
+			value = string(b)
+		}
+	})
+	return
+}
+
+type containerdContainer struct {
+	id        string
+	image     string
+	labels    map[string]string
+	spec      []byte
+	createdAt int64
+}
+
+func decodeContainerdContainer(b []byte) *containerdContainer {
+	c := &containerdContainer{labels: map[string]string{}}
+	walkFields(b, func(num protowire.Number, b []byte, _ uint64) {
+		switch num {
+		case 1:
+			c.id = string(b)
+		case 2:
+			k, v := decodeMapEntry(b)
+			c.labels[k] = v
+		case 3:
+			c.image = string(b)
+		case 5:
+			// google.protobuf.Any
+			walkFields(b, func(num protowire.Number, b []byte, _ uint64) {
+				if num == 2 {
+					c.spec = append([]byte(nil), b...)
+				}
+			})
+		case 8:
+			// google.protobuf.Timestamp
+			walkFields(b, func(num protowire.Number, _ []byte, v uint64) {
+				if num == 1 {
+					c.createdAt = int64(v)
+				}
+			})
+		}
+	})
+	return c
+}
+
+type containerdClient struct {
+	cc *grpc.ClientConn
+}
+
+func (c *containerdClient) call(ctx context.Context, ns, method string, req []byte) (resp []byte, err error) {
+	if ns != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, containerdNamespaceKey, ns)
+	}
+	err = c.cc.Invoke(ctx, method, &req, &resp, grpc.ForceCodec(rawCodec{}))
+	return
+}
+
+func (c *containerdClient) namespaces(ctx context.Context) (ret []string, err error) {
+	resp, err := c.call(ctx, "", methodListNamespaces, nil)
+	if err != nil {
+		return
+	}
+	err = walkFields(resp, func(num protowire.Number, b []byte, _ uint64) {
+		if num != 1 {
+			return
+		}
+		walkFields(b, func(num protowire.Number, b []byte, _ uint64) {
+			if num == 1 && string(b) != containerdK8sNamespace {
+				ret = append(ret, string(b))
+			}
+		})
+	})
+	return
+}
+
+type containerdTask struct {
+	pid    uint64
+	status uint64
+}
+
+func (c *containerdClient) tasks(ctx context.Context, ns string) (map[string]containerdTask, error) {
+	ret := map[string]containerdTask{}
+	resp, err := c.call(ctx, ns, methodListTasks, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = walkFields(resp, func(num protowire.Number, b []byte, _ uint64) {
+		if num != 1 {
+			return
+		}
+		var (
+			id string
+			t  containerdTask
+		)
+		walkFields(b, func(num protowire.Number, b []byte, v uint64) {
+			switch num {
+			case 1:
+				id = string(b)
+			case 3:
+				t.pid = v
+			case 4:
+				t.status = v
+			}
+		})
+		ret[id] = t
+	})
+	return ret, err
+}
+
+// image id is the digest of the image target
+func (c *containerdClient) imageID(ctx context.Context, ns, name string) (id string) {
+	if name == "" {
+		return
+	}
+	resp, err := c.call(ctx, ns, methodGetImage, appendString(nil, 1, name))
+	if err != nil {
+		return
+	}
+	walkFields(resp, func(num protowire.Number, b []byte, _ uint64) {
+		if num != 1 {
+			return
+		}
+		walkFields(b, func(num protowire.Number, b []byte, _ uint64) {
+			if num != 3 {
+				return
+			}
+			walkFields(b, func(num protowire.Number, b []byte, _ uint64) {
+				if num == 2 {
+					id = strings.TrimPrefix(string(b), "sha256:")
+				}
+			})
+		})
+	})
+	return
+}
+
+func (c *containerdClient) ListContainers(ctx context.Context) ([]Container, error) {
+	containers := []Container{}
+	nss, err := c.namespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range nss {
+		resp, err := c.call(ctx, ns, methodListContainers, nil)
+		if err != nil {
+			return nil, err
+		}
+		tasks, _ := c.tasks(ctx, ns)
+		images := map[string]string{}
+		walkFields(resp, func(num protowire.Number, b []byte, _ uint64) {
+			if num != 1 {
+				return
+			}
+			ctr := decodeContainerdContainer(b)
+			container := Container{
+				ID:         ctr.id,
+				Name:       ctr.labels["nerdctl/name"],
+				ImageName:  ctr.image,
+				State:      StateName[int32(CREATED)],
+				Namespace:  ns,
+				Runtime:    c.Runtime(),
+				CreateTime: strconv.FormatInt(ctr.createdAt, 10),
+			}
+			if container.Name == "" {
+				container.Name = ctr.id
+			}
+			if id, ok := images[ctr.image]; ok {
+				container.ImageID = id
+			} else {
+				container.ImageID = c.imageID(ctx, ns, ctr.image)
+				images[ctr.image] = container.ImageID
+			}
+			if t, ok := tasks[ctr.id]; ok {
+				if state, ok := containerdStatus[t.status]; ok {
+					container.State = state
+				} else {
+					container.State = StateName[int32(UNKNOWN)]
+				}
+				if container.State == StateName[int32(RUNNING)] && t.pid > 0 {
+					container.Pid = strconv.FormatUint(t.pid, 10)
+					if p, err := process.NewProcess(container.Pid); err == nil {
+						container.Pns, _ = p.Namespace("pid")
+					}
+				}
+			}
+			containers = append(containers, container)
+		})
+	}
+	return containers, nil
+}
+
+// finds the namespace of a container, returning its oci spec
+func (c *containerdClient) lookup(ctx context.Context, containerID string) (ns string, spec []byte, err error) {
+	nss, err := c.namespaces(ctx)
+	if err != nil {
+		return
+	}
+	for _, ns := range nss {
+		resp, err := c.call(ctx, ns, methodGetContainer, appendString(nil, 1, containerID))
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		var ctr *containerdContainer
+		walkFields(resp, func(num protowire.Number, b []byte, _ uint64) {
+			if num == 1 {
+				ctr = decodeContainerdContainer(b)
+			}
+		})
+		if ctr != nil {
+			return ns, ctr.spec, nil
+		}
+	}
+	return "", nil, status.Errorf(codes.NotFound, "container %s not found", containerID)
+}
+
+// reads a fifo until the writer closes it, the open blocks until the shim opens the write side
+func readFifo(path string, buf *bytes.Buffer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	io.Copy(buf, io.LimitReader(f, 16*1024*1024))
+	f.Close()
+}
+
+// unblocks a reader which is still waiting in open
+func releaseFifo(path string) {
+	if f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
+
+func (c *containerdClient) Exec(ctx context.Context, containerID string, name string, arg ...string) ([]byte, error) {
+	ns, spec, err := c.lookup(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	// run with the env, cwd and user of the container's init process
+	oci := struct {
+		Process map[string]interface{} `json:"process"`
+	}{}
+	if err = json.Unmarshal(spec, &oci); err != nil {
+		return nil, err
+	}
+	if oci.Process == nil {
+		oci.Process = map[string]interface{}{"cwd": "/"}
+	}
+	oci.Process["args"] = append([]string{name}, arg...)
+	oci.Process["terminal"] = false
+	delete(oci.Process, "consoleSize")
+	processSpec, err := json.Marshal(oci.Process)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	execID := "collector-" + hex.EncodeToString(id)
+	dir, err := os.MkdirTemp("", "collector-exec-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	stdoutPath, stderrPath := filepath.Join(dir, "stdout"), filepath.Join(dir, "stderr")
+	for _, path := range []string{stdoutPath, stderrPath} {
+		if err = syscall.Mkfifo(path, 0600); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		wg     sync.WaitGroup
+		stdout = bytes.NewBuffer(nil)
+		stderr = bytes.NewBuffer(nil)
+	)
+	wg.Add(2)
+	go readFifo(stdoutPath, stdout, &wg)
+	go readFifo(stderrPath, stderr, &wg)
+	defer func() {
+		releaseFifo(stdoutPath)
+		releaseFifo(stderrPath)
+		wg.Wait()
+	}()
+	specAny := appendString(nil, 1, processSpecType)
+	specAny = appendBytes(specAny, 2, processSpec)
+	req := appendString(nil, 1, containerID)
+	req = appendString(req, 3, stdoutPath)
+	req = appendString(req, 4, stderrPath)
+	req = appendBytes(req, 6, specAny)
+	req = appendString(req, 7, execID)
+	if _, err = c.call(ctx, ns, methodExec, req); err != nil {
+		return nil, err
+	}
+	req = appendString(nil, 1, containerID)
+	req = appendString(req, 2, execID)
+	defer c.call(context.Background(), ns, methodDeleteProcess, req)
+	if _, err = c.call(ctx, ns, methodStart, req); err != nil {
+		return nil, err
+	}
+	resp, err := c.call(ctx, ns, methodWait, req)
+	if err != nil {
+		return nil, err
+	}
+	var exitStatus uint64
+	walkFields(resp, func(num protowire.Number, _ []byte, v uint64) {
+		if num == 1 {
+			exitStatus = v
+		}
+	})
+	// the shim closes the fifos once the process exits
+	releaseFifo(stdoutPath)
+	releaseFifo(stderrPath)
+	wg.Wait()
+	if exitStatus != 0 {
+		if stderr.Len() != 0 {
+			return nil, errors.New(stderr.String())
+		}
+		return nil, fmt.Errorf("exit status %d", exitStatus)
+	}
+	return bytes.Join([][]byte{stdout.Bytes(), stderr.Bytes()}, []byte{'\n'}), nil
+}
+func (c *containerdClient) ImageLayers(ctx context.Context, ctr Container) (Layers, error) {
+	return mountLayers(ctr.Pid)
+}
+func (c *containerdClient) Close() {
+	c.cc.Close()
+}
+func (c *containerdClient) Runtime() string {
+	return "containerd"
+}
+
+func newContainerdClient(ctx context.Context, cc *grpc.ClientConn) (Client, error) {
+	c := &containerdClient{cc: cc}
+	if _, err := c.namespaces(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,177 @@
+package container
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// fakeContainerd serves the containerd services used by the client, with
+// containers keyed by namespace
+type fakeContainerd struct {
+	containers map[string][]*containerdContainer
+	pid        uint64
+	stdout     string
+}
+
+func (f *fakeContainerd) encodeContainer(c *containerdContainer) (b []byte) {
+	b = appendString(b, 1, c.id)
+	for k, v := range c.labels {
+		b = appendBytes(b, 2, appendString(appendString(nil, 1, k), 2, v))
+	}
+	b = appendString(b, 3, c.image)
+	b = appendBytes(b, 5, appendBytes(appendString(nil, 1, "types.containerd.io/opencontainers/runtime-spec/1/Spec"), 2, c.spec))
+	b = appendBytes(b, 8, appendVarint(nil, 1, uint64(c.createdAt)))
+	return
+}
+
+func (f *fakeContainerd) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	var req, resp []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	var ns string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok && len(md.Get(containerdNamespaceKey)) > 0 {
+		ns = md.Get(containerdNamespaceKey)[0]
+	}
+	fields := map[protowire.Number][]byte{}
+	walkFields(req, func(num protowire.Number, b []byte, _ uint64) {
+		fields[num] = b
+	})
+	switch method {
+	case methodListNamespaces:
+		for _, name := range []string{"default", "k8s.io", "moby"} {
+			resp = appendBytes(resp, 1, appendString(nil, 1, name))
+		}
+	case methodListContainers:
+		for _, c := range f.containers[ns] {
+			resp = appendBytes(resp, 1, f.encodeContainer(c))
+		}
+	case methodGetContainer:
+		for _, c := range f.containers[ns] {
+			if c.id == string(fields[1]) {
+				resp = appendBytes(resp, 1, f.encodeContainer(c))
+			}
+		}
+		if resp == nil {
+			return status.Error(codes.NotFound, "container not found")
+		}
+	case methodGetImage:
+		target := appendString(nil, 2, "sha256:"+strings.Repeat("a", 64))
+		resp = appendBytes(resp, 1, appendBytes(appendString(nil, 1, string(fields[1])), 3, target))
+	case methodListTasks:
+		if ns == "default" {
+			task := appendString(nil, 1, "web")
+			task = appendVarint(task, 3, f.pid)
+			task = appendVarint(task, 4, 2)
+			resp = appendBytes(resp, 1, task)
+		}
+	case methodExec:
+		f.stdout = string(fields[3])
+		stderr := string(fields[4])
+		// the shim keeps stderr open until the process exits, close it right away here
+		if w, err := os.OpenFile(stderr, os.O_WRONLY, 0); err == nil {
+			w.Close()
+		}
+	case methodStart:
+		w, err := os.OpenFile(f.stdout, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		w.WriteString("hello\n")
+		w.Close()
+		resp = appendVarint(nil, 1, 1234)
+	case methodWait:
+		resp = appendVarint(nil, 1, 0)
+	case methodDeleteProcess:
+	default:
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+	return stream.SendMsg(&resp)
+}
+
+func TestContainerdClient(t *testing.T) {
+	pns := selfPns(t)
+	f := &fakeContainerd{
+		pid: uint64(os.Getpid()),
+		containers: map[string][]*containerdContainer{
+			"default": {{
+				id:        "web",
+				image:     "docker.io/library/nginx:latest",
+				labels:    map[string]string{"nerdctl/name": "web-1"},
+				spec:      []byte(`{"process":{"cwd":"/","env":["PATH=/bin"],"user":{"uid":0,"gid":0},"capabilities":{"bounding":["CAP_CHOWN","CAP_SYS_ADMIN"]}},"mounts":[{"destination":"/host","type":"bind","source":"/","options":["rbind","ro","rshared"]}],"linux":{"namespaces":[{"type":"pid"},{"type":"ipc"},{"type":"uts"},{"type":"mount"},{"type":"network"}]}}`),
+				createdAt: 1700000000,
+			}},
+			"k8s.io": {{id: "pod"}},
+			"moby":   {{id: "stopped", spec: []byte(`{}`)}},
+		},
+	}
+	s := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(f.handle))
+	c, err := newContainerdClient(context.Background(), serve(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctrs, err := c.ListContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrs) != 2 {
+		t.Fatalf("expected containers of default and moby namespaces, got %+v", ctrs)
+	}
+	want := Container{
+		ID:         "web",
+		Name:       "web-1",
+		ImageID:    strings.Repeat("a", 64),
+		ImageName:  "docker.io/library/nginx:latest",
+		State:      "running",
+		Pid:        strconv.Itoa(os.Getpid()),
+		Pns:        pns,
+		Runtime:    "containerd",
+		CreateTime: "1700000000",
+		Namespace:  "default",
+	}
+	if ctrs[0] != want {
+		t.Errorf("got %+v, want %+v", ctrs[0], want)
+	}
+	if ctrs[1].ID != "stopped" || ctrs[1].Namespace != "moby" || ctrs[1].State != "created" || ctrs[1].Pid != "" {
+		t.Errorf("unexpected container %+v", ctrs[1])
+	}
+	out, err := c.Exec(context.Background(), "web", "echo", "hello")
+	if err != nil || string(out) != "hello\n\n" {
+		t.Errorf("exec got %q, %v", out, err)
+	}
+	if _, err := c.Exec(context.Background(), "pod", "echo"); !IsNotFound(err) {
+		t.Errorf("expected not found for kubernetes container, got %v", err)
+	}
+	config, err := c.Security(context.Background(), ctrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConfig := &SecurityConfig{
+		Privileged: true,
+		CapAdd:     []string{"SYS_ADMIN"},
+		Mounts:     []Mount{{Source: "/", Destination: "/host", ReadOnly: true, Propagation: "rshared"}},
+	}
+	if !reflect.DeepEqual(config, wantConfig) {
+		t.Errorf("security got %+v, want %+v", config, wantConfig)
+	}
+	if _, err := c.Security(context.Background(), ctrs[1]); err != ErrSpecNotFound {
+		t.Errorf("expected spec not found, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/process"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criv1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// criV1Client talks to runtimes which serve runtime.v1, v1alpha2 has been
+// removed since containerd 1.7 / cri-o 1.24
+type criV1Client struct {
+	c  criv1.RuntimeServiceClient
+	cc *grpc.ClientConn
+}
+
+func (c *criV1Client) ListContainers(ctx context.Context) ([]Container, error) {
+	containers := []Container{}
+	resp, err := c.c.ListContainers(ctx, &criv1.ListContainersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	for _, criContainer := range resp.Containers {
+		container := Container{
+			ID:         criContainer.GetId(),
+			Name:       criContainer.GetMetadata().GetName(),
+			ImageID:    strings.TrimPrefix(criContainer.GetImageRef(), "sha256:"),
+			ImageName:  strings.TrimPrefix(criContainer.GetImage().GetImage(), "sha256:"),
+			State:      StateName[int32(criContainer.GetState())],
+			CreateTime: strconv.FormatInt(criContainer.CreatedAt/1000000000, 10),
+			Runtime:    c.Runtime(),
+		}
+		if container.State == StateName[int32(RUNNING)] {
+			if resp, err := c.c.ContainerStatus(ctx, &criv1.ContainerStatusRequest{ContainerId: criContainer.Id, Verbose: true}); err == nil {
+				if info, ok := resp.Info["info"]; ok {
+					p := struct {
+						Pid int `json:"pid"`
+					}{}
+					if err := json.Unmarshal([]byte(info), &p); err == nil {
+						container.Pid = strconv.Itoa(p.Pid)
+						if p.Pid > 0 {
+							if p, err := process.NewProcess(container.Pid); err == nil {
+								container.Pns, _ = p.Namespace("pid")
+							}
+						}
+					}
+				}
+				// real image name
+				if resp.Status.GetImage().GetImage() != "" {
+					container.ImageName = strings.TrimPrefix(resp.Status.GetImage().GetImage(), "sha256:")
+				}
+			}
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+func (c *criV1Client) Exec(ctx context.Context, containerID string, name string, arg ...string) ([]byte, error) {
+	var timeout int64
+	if ddl, ok := ctx.Deadline(); ok {
+		d := time.Until(ddl).Seconds()
+		if d > 0 {
+			timeout = int64(d)
+		}
+	}
+	cmd := make([]string, len(arg)+1)
+	cmd[0] = name
+	copy(cmd[1:], arg)
+	resp, err := c.c.ExecSync(ctx, &criv1.ExecSyncRequest{
+		ContainerId: containerID,
+		Timeout:     timeout,
+		Cmd:         cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ExitCode != 0 {
+		return nil, errors.New(string(resp.Stderr))
+	}
+	return bytes.Join([][]byte{resp.Stdout, resp.Stderr}, []byte{'\n'}), nil
+}
+func (c *criV1Client) ImageLayers(ctx context.Context, ctr Container) (Layers, error) {
+	return mountLayers(ctr.Pid)
+}
+func (c *criV1Client) Close() {
+	c.cc.Close()
+}
+func (c *criV1Client) Runtime() string {
+	return "cri"
+}
+
+// newCRIClient negotiates the cri api version, preferring v1 and falling back
+// to v1alpha2 when the runtime doesn't implement it.
+func newCRIClient(ctx context.Context, cc *grpc.ClientConn) (Client, error) {
+	v1 := criv1.NewRuntimeServiceClient(cc)
+	_, err := v1.Version(ctx, &criv1.VersionRequest{})
+	if err == nil {
+		return &criV1Client{c: v1, cc: cc}, nil
+	}
+	if status.Code(err) != codes.Unimplemented {
+		return nil, err
+	}
+	v1alpha2 := cri.NewRuntimeServiceClient(cc)
+	if _, err = v1alpha2.Version(ctx, &cri.VersionRequest{}); err != nil {
+		return nil, err
+	}
+	return &criClient{c: v1alpha2, cc: cc}, nil
+}
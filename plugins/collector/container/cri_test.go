@@ -0,0 +1,165 @@
+package container
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bytedance/Elkeid/plugins/collector/process"
+	"google.golang.org/grpc"
+	criv1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// serve starts a grpc server on a unix socket, returning a connection to it
+func serve(t *testing.T, s *grpc.Server) *grpc.ClientConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "runtime.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	cc, err := dial("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func selfPns(t *testing.T) string {
+	t.Helper()
+	p, err := process.NewProcess(strconv.Itoa(os.Getpid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pns, err := p.Namespace("pid")
+	if err != nil {
+		t.Skip("pid namespace is not readable: ", err)
+	}
+	return pns
+}
+
+type fakeCRIv1 struct {
+	criv1.UnimplementedRuntimeServiceServer
+}
+
+func (*fakeCRIv1) Version(context.Context, *criv1.VersionRequest) (*criv1.VersionResponse, error) {
+	return &criv1.VersionResponse{RuntimeApiVersion: "v1"}, nil
+}
+func (*fakeCRIv1) ListContainers(context.Context, *criv1.ListContainersRequest) (*criv1.ListContainersResponse, error) {
+	return &criv1.ListContainersResponse{Containers: []*criv1.Container{
+		{
+			Id:        "c1",
+			Metadata:  &criv1.ContainerMetadata{Name: "nginx"},
+			Image:     &criv1.ImageSpec{Image: "sha256:abc"},
+			ImageRef:  "sha256:abc",
+			State:     criv1.ContainerState_CONTAINER_RUNNING,
+			CreatedAt: 1700000000000000000,
+		},
+		{
+			Id:       "c2",
+			Metadata: &criv1.ContainerMetadata{Name: "job"},
+			State:    criv1.ContainerState_CONTAINER_EXITED,
+		},
+	}}, nil
+}
+func (*fakeCRIv1) ContainerStatus(_ context.Context, req *criv1.ContainerStatusRequest) (*criv1.ContainerStatusResponse, error) {
+	return &criv1.ContainerStatusResponse{
+		Status: &criv1.ContainerStatus{Id: req.ContainerId, Image: &criv1.ImageSpec{Image: "nginx:latest"}},
+		Info: map[string]string{"info": `{"pid":` + strconv.Itoa(os.Getpid()) + `,"runtimeSpec":{` +
+			`"process":{"capabilities":{"bounding":["CAP_CHOWN","CAP_KILL","CAP_NET_ADMIN"]}},` +
+			`"mounts":[{"destination":"/var/run/docker.sock","type":"bind","source":"/run/docker.sock","options":["rbind","rprivate","rw"]},` +
+			`{"destination":"/proc","type":"proc","source":"proc"}],` +
+			`"linux":{"namespaces":[{"type":"pid"},{"type":"ipc","path":"/proc/1/ns/ipc"},{"type":"uts"},{"type":"mount"}],"maskedPaths":["/proc/kcore"]}}}`},
+	}, nil
+}
+func (*fakeCRIv1) ExecSync(_ context.Context, req *criv1.ExecSyncRequest) (*criv1.ExecSyncResponse, error) {
+	if req.Cmd[0] == "false" {
+		return &criv1.ExecSyncResponse{ExitCode: 1, Stderr: []byte("failed")}, nil
+	}
+	return &criv1.ExecSyncResponse{Stdout: []byte(req.Cmd[1])}, nil
+}
+
+type fakeCRIv1alpha2 struct {
+	cri.UnimplementedRuntimeServiceServer
+}
+
+func (*fakeCRIv1alpha2) Version(context.Context, *cri.VersionRequest) (*cri.VersionResponse, error) {
+	return &cri.VersionResponse{RuntimeApiVersion: "v1alpha2"}, nil
+}
+
+func TestCRIClient(t *testing.T) {
+	pns := selfPns(t)
+	s := grpc.NewServer()
+	criv1.RegisterRuntimeServiceServer(s, &fakeCRIv1{})
+	c, err := newCRIClient(context.Background(), serve(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.(*criV1Client); !ok {
+		t.Fatalf("expected cri v1 client, got %T", c)
+	}
+	ctrs, err := c.ListContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrs) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(ctrs))
+	}
+	want := Container{
+		ID:         "c1",
+		Name:       "nginx",
+		ImageID:    "abc",
+		ImageName:  "nginx:latest",
+		State:      "running",
+		Pid:        strconv.Itoa(os.Getpid()),
+		Pns:        pns,
+		Runtime:    "cri",
+		CreateTime: "1700000000",
+	}
+	if ctrs[0] != want {
+		t.Errorf("got %+v, want %+v", ctrs[0], want)
+	}
+	if ctrs[1].State != "exited" || ctrs[1].Pid != "" {
+		t.Errorf("unexpected exited container %+v", ctrs[1])
+	}
+	out, err := c.Exec(context.Background(), "c1", "echo", "hello")
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("exec got %q, %v", out, err)
+	}
+	if _, err := c.Exec(context.Background(), "c1", "false"); err == nil || err.Error() != "failed" {
+		t.Errorf("exec expected error, got %v", err)
+	}
+	config, err := c.Security(context.Background(), ctrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConfig := &SecurityConfig{
+		CapAdd:      []string{"NET_ADMIN"},
+		HostNetwork: true,
+		Mounts:      []Mount{{Source: "/run/docker.sock", Destination: "/var/run/docker.sock", Propagation: "rprivate"}},
+	}
+	if !reflect.DeepEqual(config, wantConfig) {
+		t.Errorf("security got %+v, want %+v", config, wantConfig)
+	}
+}
+
+func TestCRIClientFallback(t *testing.T) {
+	s := grpc.NewServer()
+	cri.RegisterRuntimeServiceServer(s, &fakeCRIv1alpha2{})
+	c, err := newCRIClient(context.Background(), serve(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.(*criClient); !ok {
+		t.Fatalf("expected cri v1alpha2 client, got %T", c)
+	}
+}
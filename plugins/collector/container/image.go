@@ -0,0 +1,235 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+var ErrUnsupportedDriver = errors.New("unsupported graph driver")
+
+// Layers is a read-only view of an image rootfs made of overlayfs
+// directories, ordered from the topmost layer to the base layer.
+type Layers []string
+
+// max symlinks followed by a lookup, the same as the kernel
+const maxSymlinks = 40
+
+// Lookup resolves path in the merged view and returns the file of the topmost
+// layer which contains it, honouring overlayfs whiteouts and opaque directories
+// of the upper layers. Symlinks are resolved inside the image root, since
+// absolute links of an image would resolve on the host.
+func (l Layers) Lookup(path string) (string, error) {
+	links := 0
+	rest := strings.Split(strings.Trim(filepath.Clean("/"+path), "/"), "/")
+	// the resolved path of the merged view, and the dirs of the layers making it up
+	cur := "/"
+	dirs := l
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		if name == "" {
+			continue
+		}
+		entry, fi, lower, err := lookupEntry(dirs, name)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if links++; links > maxSymlinks {
+				return "", syscall.ELOOP
+			}
+			target, err := os.Readlink(entry)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(cur, target)
+			}
+			// restart from the image root, .. of the root is the root
+			rest = append(strings.Split(strings.Trim(filepath.Clean("/"+target), "/"), "/"), rest...)
+			cur = "/"
+			dirs = l
+			continue
+		}
+		if len(rest) == 0 {
+			return entry, nil
+		}
+		if !fi.IsDir() {
+			return "", syscall.ENOTDIR
+		}
+		cur = filepath.Join(cur, name)
+		dirs = lower
+	}
+	// the root itself
+	if len(l) == 0 {
+		return "", os.ErrNotExist
+	}
+	return l[0], nil
+}
+
+// lookupEntry finds name in the dirs of a merged directory, ordered from the
+// topmost layer. A directory found is merged with the directories of the same
+// name below it, until a whiteout, an opaque directory or a non-directory.
+func lookupEntry(dirs []string, name string) (entry string, fi os.FileInfo, lower []string, err error) {
+	for _, dir := range dirs {
+		full := filepath.Join(dir, name)
+		if isWhiteout(full) {
+			break
+		}
+		if _, err := os.Lstat(filepath.Join(dir, ".wh."+name)); err == nil {
+			break
+		}
+		info, err := os.Lstat(full)
+		if err != nil {
+			continue
+		}
+		if entry == "" {
+			entry, fi = full, info
+		}
+		if !info.IsDir() || !fi.IsDir() {
+			break
+		}
+		lower = append(lower, full)
+		if isOpaque(full) {
+			break
+		}
+	}
+	if entry == "" {
+		return "", nil, nil, os.ErrNotExist
+	}
+	return entry, fi, lower, nil
+}
+
+// Open opens a regular file in the merged view, the final component isn't
+// followed again and a fifo can't block the read.
+func (l Layers) Open(path string) (*os.File, error) {
+	full, err := l.Lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(full, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, errors.New("not a regular file")
+	}
+	return f, nil
+}
+
+// overlayfs whiteout is a character device with 0/0 device number
+func isWhiteout(path string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+func isOpaque(dir string) bool {
+	if _, err := os.Lstat(filepath.Join(dir, ".wh..wh..opq")); err == nil {
+		return true
+	}
+	buf := make([]byte, 1)
+	for _, attr := range []string{"trusted.overlay.opaque", "user.overlay.opaque"} {
+		if n, err := syscall.Getxattr(dir, attr, buf); err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+func overlayDirs(upper, lower string) (ret Layers) {
+	if upper != "" {
+		ret = append(ret, upper)
+	}
+	for _, dir := range strings.Split(lower, ":") {
+		if dir != "" {
+			ret = append(ret, dir)
+		}
+	}
+	return
+}
+
+// image layers of a running container, read from the lowerdir of its
+// overlay rootfs; the upperdir is the container's own writable layer.
+func mountLayers(pid string) (Layers, error) {
+	if pid == "" || pid == "0" {
+		return nil, errors.New("container is not running")
+	}
+	f, err := os.Open(filepath.Join("/proc", pid, "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(io.LimitReader(f, 1024*1024))
+	for s.Scan() {
+		// 36 35 98:0 / / rw,relatime - overlay overlay rw,lowerdir=...,upperdir=...
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 || fields[4] != "/" {
+			continue
+		}
+		sep := 6
+		for sep < len(fields) && fields[sep] != "-" {
+			sep++
+		}
+		if sep+3 >= len(fields) {
+			continue
+		}
+		if fields[sep+1] != "overlay" {
+			return nil, ErrUnsupportedDriver
+		}
+		var upper, lower string
+		for _, opt := range strings.Split(fields[sep+3], ",") {
+			switch {
+			case strings.HasPrefix(opt, "lowerdir="):
+				lower = strings.TrimPrefix(opt, "lowerdir=")
+			case strings.HasPrefix(opt, "upperdir="):
+				upper = strings.TrimPrefix(opt, "upperdir=")
+			}
+		}
+		if lower == "" {
+			break
+		}
+		layers := overlayDirs("", lower)
+		// dockerd mounts with short links relative to the overlay2 home, e.g. l/ABCDEF
+		for i, dir := range layers {
+			if !filepath.IsAbs(dir) && upper != "" {
+				layers[i] = filepath.Join(filepath.Dir(filepath.Dir(upper)), dir)
+			}
+		}
+		return layers, nil
+	}
+	return nil, errors.New("overlay rootfs not found")
+}
+
+// ImageLayers for cri runtimes come from the mount table of the container,
+// since the snapshotter paths aren't exposed by the cri api.
+func (c *criClient) ImageLayers(ctx context.Context, ctr Container) (Layers, error) {
+	return mountLayers(ctr.Pid)
+}
+
+func (c *dockerClient) ImageLayers(ctx context.Context, ctr Container) (Layers, error) {
+	resp, _, err := c.c.ImageInspectWithRaw(ctx, ctr.ImageID)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.GraphDriver.Name {
+	case "overlay2", "overlay":
+		return overlayDirs(resp.GraphDriver.Data["UpperDir"], resp.GraphDriver.Data["LowerDir"]), nil
+	case "":
+		// images are kept by the containerd snapshotter
+		return mountLayers(ctr.Pid)
+	default:
+		return nil, ErrUnsupportedDriver
+	}
+}
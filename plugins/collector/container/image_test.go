@@ -0,0 +1,89 @@
+package container
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	root := t.TempDir()
+	upper, lower, host := filepath.Join(root, "upper"), filepath.Join(root, "lower"), filepath.Join(root, "host")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, path string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(host, "etc/passwd"), "host")
+	// fedora moved the rpm database, /var/lib/rpm is a relative link
+	write(filepath.Join(lower, "usr/lib/sysimage/rpm/rpmdb.sqlite"), "rpmdb")
+	link("../../usr/lib/sysimage/rpm", filepath.Join(lower, "var/lib/rpm"))
+	write(filepath.Join(lower, "etc/os-release"), "lower")
+	write(filepath.Join(lower, "etc/hidden"), "lower")
+	write(filepath.Join(lower, "opt/app/conf"), "lower")
+	write(filepath.Join(lower, "srv/data"), "lower")
+	write(filepath.Join(upper, "etc/os-release"), "upper")
+	write(filepath.Join(upper, "etc/.wh.hidden"), "")
+	write(filepath.Join(upper, "opt/app/.wh..wh..opq"), "")
+	write(filepath.Join(upper, "opt/app/new"), "upper")
+	write(filepath.Join(upper, "srv"), "upper file hides the lower dir")
+	// absolute links resolve inside the image, not on the host
+	link(filepath.Join(host, "etc/passwd"), filepath.Join(upper, "escape"))
+	link("/../../../../etc/os-release", filepath.Join(upper, "dotdot"))
+	link("/etc", filepath.Join(upper, "etclink"))
+	link("loop", filepath.Join(upper, "loop"))
+	if err := syscall.Mkfifo(filepath.Join(upper, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := Layers{upper, lower}
+	for path, want := range map[string]string{
+		"/var/lib/rpm/rpmdb.sqlite": "rpmdb",
+		"/etc/os-release":           "upper",
+		"/etclink/os-release":       "upper",
+		"/dotdot":                   "upper",
+		"/opt/app/new":              "upper",
+		"/etc/hidden":               "",
+		"/opt/app/conf":             "",
+		"/srv/data":                 "",
+		"/escape":                   "",
+		"/loop":                     "",
+		"/fifo":                     "",
+	} {
+		f, err := l.Open(path)
+		if want == "" {
+			if err == nil {
+				f.Close()
+				t.Errorf("Open(%s) expected error", path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Open(%s) error %v", path, err)
+			continue
+		}
+		got, _ := io.ReadAll(f)
+		f.Close()
+		if string(got) != want {
+			t.Errorf("Open(%s) = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := l.Lookup("/loop"); err != syscall.ELOOP {
+		t.Errorf("Lookup(/loop) error %v, want ELOOP", err)
+	}
+}
@@ -0,0 +1,196 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/process"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// libpod api, supported since podman 2.0
+const podmanAPIVersion = "v2.0.0"
+
+// PodmanError is returned for non-2xx responses of the podman api.
+type PodmanError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *PodmanError) Error() string {
+	return fmt.Sprintf("podman: %d %s", e.StatusCode, e.Message)
+}
+
+type podmanClient struct {
+	c *http.Client
+}
+
+func (c *podmanClient) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	u := url.URL{Scheme: "http", Host: "d", Path: "/" + podmanAPIVersion + "/libpod" + path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		e := &PodmanError{StatusCode: resp.StatusCode}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(e)
+		return nil, e
+	}
+	return resp, nil
+}
+
+func (c *podmanClient) decode(ctx context.Context, method, path string, query url.Values, body, v interface{}) error {
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *podmanClient) ListContainers(ctx context.Context) ([]Container, error) {
+	containers := []Container{}
+	var resp []struct {
+		ID      string    `json:"Id"`
+		Names   []string  `json:"Names"`
+		Image   string    `json:"Image"`
+		ImageID string    `json:"ImageID"`
+		State   string    `json:"State"`
+		Pid     int       `json:"Pid"`
+		Created time.Time `json:"Created"`
+	}
+	if err := c.decode(ctx, http.MethodGet, "/containers/json", url.Values{"all": []string{"true"}}, nil, &resp); err != nil {
+		return nil, err
+	}
+	for _, podmanContainer := range resp {
+		container := Container{
+			ID:         podmanContainer.ID,
+			ImageID:    strings.TrimPrefix(podmanContainer.ImageID, "sha256:"),
+			ImageName:  podmanContainer.Image,
+			State:      podmanContainer.State,
+			CreateTime: strconv.FormatInt(podmanContainer.Created.Unix(), 10),
+			Runtime:    c.Runtime(),
+		}
+		if len(podmanContainer.Names) > 0 {
+			container.Name = podmanContainer.Names[0]
+		}
+		if _, ok := StateValue[container.State]; !ok {
+			container.State = StateName[int32(UNKNOWN)]
+		}
+		if container.State == StateName[int32(RUNNING)] && podmanContainer.Pid > 0 {
+			container.Pid = strconv.Itoa(podmanContainer.Pid)
+			if p, err := process.NewProcess(container.Pid); err == nil {
+				container.Pns, _ = p.Namespace("pid")
+			}
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+func (c *podmanClient) Exec(ctx context.Context, containerID string, name string, arg ...string) ([]byte, error) {
+	cmd := make([]string, len(arg)+1)
+	cmd[0] = name
+	copy(cmd[1:], arg)
+	createResp := struct {
+		ID string `json:"Id"`
+	}{}
+	err := c.decode(ctx, http.MethodPost, "/containers/"+url.PathEscape(containerID)+"/exec", nil, map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+	}, &createResp)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/exec/"+url.PathEscape(createResp.ID)+"/start", nil, map[string]interface{}{
+		"Detach": false,
+		"Tty":    false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	_, err = stdcopy.StdCopy(stdout, stderr, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	inspectResp := struct {
+		ExitCode int `json:"ExitCode"`
+	}{}
+	err = c.decode(ctx, http.MethodGet, "/exec/"+url.PathEscape(createResp.ID)+"/json", nil, nil, &inspectResp)
+	if err == nil && inspectResp.ExitCode != 0 {
+		if len(stderr.Bytes()) != 0 {
+			return nil, errors.New(stderr.String())
+		}
+		if len(stdout.Bytes()) != 0 {
+			return nil, errors.New(stdout.String())
+		}
+		return nil, errors.New("unknown error")
+	}
+	return bytes.Join([][]byte{stdout.Bytes(), stderr.Bytes()}, []byte{'\n'}), nil
+}
+func (c *podmanClient) ImageLayers(ctx context.Context, ctr Container) (Layers, error) {
+	var resp struct {
+		GraphDriver struct {
+			Name string            `json:"Name"`
+			Data map[string]string `json:"Data"`
+		} `json:"GraphDriver"`
+	}
+	if err := c.decode(ctx, http.MethodGet, "/images/"+url.PathEscape(ctr.ImageID)+"/json", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.GraphDriver.Name != "overlay" {
+		return nil, ErrUnsupportedDriver
+	}
+	return overlayDirs(resp.GraphDriver.Data["UpperDir"], resp.GraphDriver.Data["LowerDir"]), nil
+}
+func (c *podmanClient) Close()          { c.c.CloseIdleConnections() }
+func (c *podmanClient) Runtime() string { return "podman" }
+
+func newPodmanClient(ctx context.Context, socket string) (Client, error) {
+	c := &podmanClient{
+		c: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/_ping", nil, nil)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	return c, nil
+}
@@ -0,0 +1,138 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+func fakePodman(t *testing.T) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	prefix := "/" + podmanAPIVersion + "/libpod"
+	mux.HandleFunc(prefix+"/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc(prefix+"/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("all") != "true" {
+			t.Errorf("expected all containers to be listed")
+		}
+		w.Write([]byte(`[
+			{"Id":"p1","Names":["db"],"Image":"docker.io/library/redis:7","ImageID":"bbb","State":"running","Pid":` + strconv.Itoa(os.Getpid()) + `,"Created":"2023-11-14T22:13:20Z"},
+			{"Id":"p2","Names":["old"],"Image":"busybox","ImageID":"ccc","State":"exited","Pid":0,"Created":"2023-11-14T22:13:20Z"}
+		]`))
+	})
+	mux.HandleFunc(prefix+"/containers/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == prefix+"/containers/p1/json" {
+			w.Write([]byte(`{"HostConfig":{"Privileged":false,"CapAdd":["CAP_SYS_PTRACE","CAP_CHOWN"],"NetworkMode":"bridge","PidMode":"host","IpcMode":"private","UTSMode":"private"},
+				"Mounts":[{"Type":"bind","Source":"/etc","Destination":"/host/etc","RW":true,"Propagation":"rprivate"},{"Type":"volume","Source":"/var/lib/containers/storage/volumes/data/_data","Destination":"/data","RW":true}]}`))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != prefix+"/containers/p1/exec" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"cause":"no such container","message":"no container with name or ID found","response":404}`))
+			return
+		}
+		body := struct {
+			Cmd []string
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"` + strings.Join(body.Cmd, "-") + `"}`))
+	})
+	mux.HandleFunc(prefix+"/exec/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Split(strings.TrimPrefix(r.URL.Path, prefix+"/exec/"), "/")[0]
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			if id == "false" {
+				stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte("failed"))
+				return
+			}
+			stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(strings.TrimPrefix(id, "echo-")))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			code := 0
+			if id == "false" {
+				code = 1
+			}
+			w.Write([]byte(`{"ExitCode":` + strconv.Itoa(code) + `}`))
+		}
+	})
+	path := filepath.Join(t.TempDir(), "podman.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(mux)
+	s.Listener = l
+	s.Start()
+	t.Cleanup(s.Close)
+	return path
+}
+
+func TestPodmanClient(t *testing.T) {
+	pns := selfPns(t)
+	c, err := newPodmanClient(context.Background(), fakePodman(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctrs, err := c.ListContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrs) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(ctrs))
+	}
+	want := Container{
+		ID:         "p1",
+		Name:       "db",
+		ImageID:    "bbb",
+		ImageName:  "docker.io/library/redis:7",
+		State:      "running",
+		Pid:        strconv.Itoa(os.Getpid()),
+		Pns:        pns,
+		Runtime:    "podman",
+		CreateTime: "1700000000",
+	}
+	if ctrs[0] != want {
+		t.Errorf("got %+v, want %+v", ctrs[0], want)
+	}
+	if ctrs[1].State != "exited" || ctrs[1].Pid != "" {
+		t.Errorf("unexpected exited container %+v", ctrs[1])
+	}
+	out, err := c.Exec(context.Background(), "p1", "echo", "hello")
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("exec got %q, %v", out, err)
+	}
+	if _, err := c.Exec(context.Background(), "p1", "false"); err == nil || err.Error() != "failed" {
+		t.Errorf("exec expected error, got %v", err)
+	}
+	if _, err := c.Exec(context.Background(), "missing", "echo"); !IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	config, err := c.Security(context.Background(), ctrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConfig := &SecurityConfig{
+		CapAdd:  []string{"SYS_PTRACE"},
+		HostPID: true,
+		Mounts:  []Mount{{Source: "/etc", Destination: "/host/etc", Propagation: "rprivate"}},
+	}
+	if !reflect.DeepEqual(config, wantConfig) {
+		t.Errorf("security got %+v, want %+v", config, wantConfig)
+	}
+	if _, err := c.Security(context.Background(), ctrs[1]); !IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	criv1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// SecurityConfig is the privileges a container is started with.
+type SecurityConfig struct {
+	Privileged bool
+	// capabilities besides the default ones of docker and containerd, without
+	// the CAP_ prefix
+	CapAdd      []string
+	HostNetwork bool
+	HostPID     bool
+	HostIPC     bool
+	HostUTS     bool
+	// bind mounts of host paths
+	Mounts []Mount
+}
+type Mount struct {
+	Source      string
+	Destination string
+	ReadOnly    bool
+	// shared, rshared, slave, rslave, private, rprivate or empty
+	Propagation string
+}
+
+var ErrSpecNotFound = errors.New("runtime spec not found")
+
+// the default capabilities of docker, containerd and cri-o
+var defaultCaps = map[string]bool{
+	"CHOWN":            true,
+	"DAC_OVERRIDE":     true,
+	"FSETID":           true,
+	"FOWNER":           true,
+	"MKNOD":            true,
+	"NET_RAW":          true,
+	"SETGID":           true,
+	"SETUID":           true,
+	"SETFCAP":          true,
+	"SETPCAP":          true,
+	"NET_BIND_SERVICE": true,
+	"SYS_CHROOT":       true,
+	"KILL":             true,
+	"AUDIT_WRITE":      true,
+}
+
+var propagationOptions = map[string]bool{
+	"shared":   true,
+	"rshared":  true,
+	"slave":    true,
+	"rslave":   true,
+	"private":  true,
+	"rprivate": true,
+}
+
+func capName(c string) string {
+	return strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+}
+
+// fromOCISpec reads the privileges of a container from its oci runtime spec,
+// namespaces which aren't listed are shared with the host, and a privileged
+// container gets CAP_SYS_ADMIN without any masked path.
+func fromOCISpec(spec []byte) (*SecurityConfig, error) {
+	s := struct {
+		Process struct {
+			Capabilities struct {
+				Bounding []string `json:"bounding"`
+			} `json:"capabilities"`
+		} `json:"process"`
+		Mounts []struct {
+			Destination string   `json:"destination"`
+			Type        string   `json:"type"`
+			Source      string   `json:"source"`
+			Options     []string `json:"options"`
+		} `json:"mounts"`
+		Linux *struct {
+			Namespaces []struct {
+				Type string `json:"type"`
+			} `json:"namespaces"`
+			MaskedPaths []string `json:"maskedPaths"`
+		} `json:"linux"`
+	}{}
+	if err := json.Unmarshal(spec, &s); err != nil {
+		return nil, err
+	}
+	if s.Linux == nil {
+		return nil, ErrSpecNotFound
+	}
+	config := &SecurityConfig{}
+	namespaces := map[string]bool{}
+	for _, ns := range s.Linux.Namespaces {
+		namespaces[ns.Type] = true
+	}
+	config.HostNetwork = !namespaces["network"]
+	config.HostPID = !namespaces["pid"]
+	config.HostIPC = !namespaces["ipc"]
+	config.HostUTS = !namespaces["uts"]
+	for _, c := range s.Process.Capabilities.Bounding {
+		c = capName(c)
+		if c == "SYS_ADMIN" && len(s.Linux.MaskedPaths) == 0 {
+			config.Privileged = true
+		}
+		if !defaultCaps[c] {
+			config.CapAdd = append(config.CapAdd, c)
+		}
+	}
+	sort.Strings(config.CapAdd)
+	for _, m := range s.Mounts {
+		bind := m.Type == "bind"
+		mount := Mount{Source: m.Source, Destination: m.Destination}
+		for _, o := range m.Options {
+			switch {
+			case o == "bind" || o == "rbind":
+				bind = true
+			case o == "ro":
+				mount.ReadOnly = true
+			case propagationOptions[o]:
+				mount.Propagation = o
+			}
+		}
+		if bind {
+			config.Mounts = append(config.Mounts, mount)
+		}
+	}
+	return config, nil
+}
+
+// the verbose info of cri runtimes carries the oci spec, e.g. containerd and
+// cri-o
+func fromCRIInfo(info map[string]string) (*SecurityConfig, error) {
+	s := struct {
+		RuntimeSpec json.RawMessage `json:"runtimeSpec"`
+	}{}
+	if err := json.Unmarshal([]byte(info["info"]), &s); err != nil {
+		return nil, err
+	}
+	if len(s.RuntimeSpec) == 0 {
+		return nil, ErrSpecNotFound
+	}
+	return fromOCISpec(s.RuntimeSpec)
+}
+
+func (c *criClient) Security(ctx context.Context, ctr Container) (*SecurityConfig, error) {
+	resp, err := c.c.ContainerStatus(ctx, &cri.ContainerStatusRequest{ContainerId: ctr.ID, Verbose: true})
+	if err != nil {
+		return nil, err
+	}
+	return fromCRIInfo(resp.Info)
+}
+
+func (c *criV1Client) Security(ctx context.Context, ctr Container) (*SecurityConfig, error) {
+	resp, err := c.c.ContainerStatus(ctx, &criv1.ContainerStatusRequest{ContainerId: ctr.ID, Verbose: true})
+	if err != nil {
+		return nil, err
+	}
+	return fromCRIInfo(resp.Info)
+}
+
+func (c *containerdClient) Security(ctx context.Context, ctr Container) (*SecurityConfig, error) {
+	_, spec, err := c.lookup(ctx, ctr.ID)
+	if err != nil {
+		return nil, err
+	}
+	return fromOCISpec(spec)
+}
+
+func (c *dockerClient) Security(ctx context.Context, ctr Container) (*SecurityConfig, error) {
+	resp, err := c.c.ContainerInspect(ctx, ctr.ID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContainerJSONBase == nil || resp.HostConfig == nil {
+		return nil, ErrSpecNotFound
+	}
+	config := &SecurityConfig{
+		Privileged:  resp.HostConfig.Privileged,
+		HostNetwork: resp.HostConfig.NetworkMode.IsHost(),
+		HostPID:     resp.HostConfig.PidMode.IsHost(),
+		HostIPC:     resp.HostConfig.IpcMode.IsHost(),
+		HostUTS:     resp.HostConfig.UTSMode.IsHost(),
+	}
+	for _, c := range resp.HostConfig.CapAdd {
+		if c = capName(c); !defaultCaps[c] {
+			config.CapAdd = append(config.CapAdd, c)
+		}
+	}
+	sort.Strings(config.CapAdd)
+	for _, m := range resp.Mounts {
+		if m.Type != "bind" {
+			continue
+		}
+		config.Mounts = append(config.Mounts, Mount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			ReadOnly:    !m.RW,
+			Propagation: string(m.Propagation),
+		})
+	}
+	return config, nil
+}
+
+func (c *podmanClient) Security(ctx context.Context, ctr Container) (*SecurityConfig, error) {
+	var resp struct {
+		HostConfig *struct {
+			Privileged  bool     `json:"Privileged"`
+			CapAdd      []string `json:"CapAdd"`
+			NetworkMode string   `json:"NetworkMode"`
+			PidMode     string   `json:"PidMode"`
+			IpcMode     string   `json:"IpcMode"`
+			UTSMode     string   `json:"UTSMode"`
+		} `json:"HostConfig"`
+		Mounts []struct {
+			Type        string `json:"Type"`
+			Source      string `json:"Source"`
+			Destination string `json:"Destination"`
+			RW          bool   `json:"RW"`
+			Propagation string `json:"Propagation"`
+		} `json:"Mounts"`
+	}
+	if err := c.decode(ctx, http.MethodGet, "/containers/"+url.PathEscape(ctr.ID)+"/json", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.HostConfig == nil {
+		return nil, ErrSpecNotFound
+	}
+	config := &SecurityConfig{
+		Privileged:  resp.HostConfig.Privileged,
+		HostNetwork: resp.HostConfig.NetworkMode == "host",
+		HostPID:     resp.HostConfig.PidMode == "host",
+		HostIPC:     resp.HostConfig.IpcMode == "host",
+		HostUTS:     resp.HostConfig.UTSMode == "host",
+	}
+	for _, c := range resp.HostConfig.CapAdd {
+		if c = capName(c); !defaultCaps[c] {
+			config.CapAdd = append(config.CapAdd, c)
+		}
+	}
+	sort.Strings(config.CapAdd)
+	for _, m := range resp.Mounts {
+		if m.Type != "bind" {
+			continue
+		}
+		config.Mounts = append(config.Mounts, Mount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			ReadOnly:    !m.RW,
+			Propagation: m.Propagation,
+		})
+	}
+	return config, nil
+}
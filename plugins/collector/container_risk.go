@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bytedance/Elkeid/plugins/collector/container"
+)
+
+// privilege risks of running containers are reported with their own data type, joined with 5056 by container_id
+const containerRiskDataType = 5070
+
+var (
+	containerPrivileged = AppRisk{
+		CheckID:     "1",
+		CheckName:   "Privileged container",
+		Security:    "high",
+		Description: "The container runs in privileged mode, it has all capabilities and access to every device of the host, escaping to the host is trivial.",
+		Solution:    "Remove --privileged or privileged: true, and add only the capabilities the container needs.",
+	}
+	containerCapAdd = AppRisk{
+		CheckID:     "2",
+		CheckName:   "Container with dangerous capabilities",
+		Security:    "mid",
+		Description: "The container is granted capabilities besides the default ones, e.g. SYS_ADMIN, SYS_PTRACE or SYS_MODULE may be used to escape to the host.",
+		Solution:    "Remove the capabilities from --cap-add or securityContext.capabilities.add unless they're needed.",
+	}
+	containerHostNetwork = AppRisk{
+		CheckID:     "3",
+		CheckName:   "Container shares the host network namespace",
+		Security:    "mid",
+		Description: "The container uses the network stack of the host, it can reach the services listening on localhost and sniff the traffic of the host.",
+		Solution:    "Remove --network host or hostNetwork: true.",
+	}
+	containerHostPID = AppRisk{
+		CheckID:     "4",
+		CheckName:   "Container shares the host PID namespace",
+		Security:    "mid",
+		Description: "The container can see every process of the host, and may signal or trace them.",
+		Solution:    "Remove --pid host or hostPID: true.",
+	}
+	containerHostIPC = AppRisk{
+		CheckID:     "5",
+		CheckName:   "Container shares the host IPC namespace",
+		Security:    "mid",
+		Description: "The container can access the shared memory and the message queues of the host processes.",
+		Solution:    "Remove --ipc host or hostIPC: true.",
+	}
+	containerHostUTS = AppRisk{
+		CheckID:     "6",
+		CheckName:   "Container shares the host UTS namespace",
+		Security:    "low",
+		Description: "The container can change the hostname of the host.",
+		Solution:    "Remove --uts host.",
+	}
+	containerSensitiveMount = AppRisk{
+		CheckID:     "7",
+		CheckName:   "Container mounts a sensitive host directory",
+		Security:    "high",
+		Description: "A system directory of the host is mounted into the container, it may be used to read secrets or modify the host.",
+		Solution:    "Remove the mount, or mount only the needed files read-only.",
+	}
+	containerRuntimeSocketMount = AppRisk{
+		CheckID:     "8",
+		CheckName:   "Container mounts a container runtime socket",
+		Security:    "high",
+		Description: "The socket of a container runtime is mounted into the container, it can start a privileged container, which is equivalent to root access of the host.",
+		Solution:    "Remove the mount of the runtime socket.",
+	}
+	containerSharedPropagation = AppRisk{
+		CheckID:     "9",
+		CheckName:   "Container mount with shared propagation",
+		Security:    "mid",
+		Description: "The mounts made in the container are propagated to the host, which may be used to cover the directories of the host.",
+		Solution:    "Use private or slave propagation, e.g. mountPropagation: HostToContainer.",
+	}
+)
+
+// the whole directories are sensitive even read-only, the files under them
+// are sensitive if they're writable
+var sensitiveHostDirs = []string{"/boot", "/dev", "/etc", "/lib", "/proc", "/root", "/sys", "/usr"}
+
+var runtimeSockets = map[string]bool{
+	"docker.sock":      true,
+	"containerd.sock":  true,
+	"crio.sock":        true,
+	"cri-dockerd.sock": true,
+	"podman.sock":      true,
+}
+
+func sensitiveMount(m container.Mount) bool {
+	source := filepath.Clean(m.Source)
+	if source == "/" {
+		return true
+	}
+	for _, dir := range sensitiveHostDirs {
+		if source == dir || (!m.ReadOnly && strings.HasPrefix(source, dir+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// the directories of the sockets are also checked, e.g. /var/run
+func runtimeSocketMount(m container.Mount) bool {
+	source := filepath.Clean(m.Source)
+	return runtimeSockets[filepath.Base(source)] || source == "/run" || source == "/var/run"
+}
+
+func containerRisks(config *container.SecurityConfig) (risks []*AppRisk) {
+	if config.Privileged {
+		risks = append(risks, newRisk(containerPrivileged, "privileged"))
+	} else if len(config.CapAdd) != 0 {
+		risks = append(risks, newRisk(containerCapAdd, strings.Join(config.CapAdd, ",")))
+	}
+	if config.HostNetwork {
+		risks = append(risks, newRisk(containerHostNetwork, "network"))
+	}
+	if config.HostPID {
+		risks = append(risks, newRisk(containerHostPID, "pid"))
+	}
+	if config.HostIPC {
+		risks = append(risks, newRisk(containerHostIPC, "ipc"))
+	}
+	if config.HostUTS {
+		risks = append(risks, newRisk(containerHostUTS, "uts"))
+	}
+	var sensitive, socket, shared []string
+	for _, m := range config.Mounts {
+		mount := m.Source + ":" + m.Destination
+		if m.ReadOnly {
+			mount += ":ro"
+		}
+		if runtimeSocketMount(m) {
+			socket = append(socket, mount)
+		} else if sensitiveMount(m) {
+			sensitive = append(sensitive, mount)
+		}
+		if m.Propagation == "shared" || m.Propagation == "rshared" {
+			shared = append(shared, mount+":"+m.Propagation)
+		}
+	}
+	if len(sensitive) != 0 {
+		risks = append(risks, newRisk(containerSensitiveMount, strings.Join(sensitive, "\n")))
+	}
+	if len(socket) != 0 {
+		risks = append(risks, newRisk(containerRuntimeSocketMount, strings.Join(socket, "\n")))
+	}
+	if len(shared) != 0 {
+		risks = append(risks, newRisk(containerSharedPropagation, strings.Join(shared, "\n")))
+	}
+	return
+}
@@ -3,9 +3,11 @@ package engine
 import (
 	"encoding/binary"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"hash/fnv"
 	"math/rand"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -62,11 +64,48 @@ type Handler interface {
 	DataType() int
 }
 
+// Configurable is implemented by handlers accepting configuration from task data,
+// which is applied before the triggered Handle.
+type Configurable interface {
+	Configure(data string) error
+}
+
+// Optional is implemented by handlers accepting options from the schedule policy,
+// SetOptions is called with the resolved options whenever the policy is applied.
+type Optional interface {
+	CheckOptions(options map[string]string) error
+	SetOptions(options map[string]string)
+}
+
+// MultiDataType is implemented by handlers which send records of other data
+// types as well, e.g. risks of the assets, which are ended with the run too.
+type MultiDataType interface {
+	DataTypes() []int
+}
+
+// fields of the record ending a run, the manager removes the records of the
+// earlier runs (or in the scope of a filtered run) when it arrives, so
+// findings are cleared on a clean host.
+const (
+	FieldSeq    = "package_seq"
+	FieldSeqEnd = "package_end"
+)
+
 type handler struct {
 	l *zap.SugaredLogger
 	Handler
 	done     chan struct{}
 	interval time.Duration
+	// guarded by Engine.mu
+	sched *schedule
+	entry cron.EntryID
+	ready bool
+}
+
+func newSeq() string {
+	f := fnv.New32()
+	binary.Write(f, binary.LittleEndian, time.Now().UnixNano())
+	return hex.EncodeToString(f.Sum(nil))
 }
 
 func (h *handler) Handle(c *plugins.Client, cache *Cache) {
@@ -74,12 +113,11 @@ func (h *handler) Handle(c *plugins.Client, cache *Cache) {
 	var t struct{}
 	select {
 	case t = <-h.done:
-		f := fnv.New32()
-		binary.Write(f, binary.LittleEndian, time.Now().UnixNano())
-		seq := hex.EncodeToString(f.Sum(nil))
+		seq := newSeq()
 		h.l.Info("do work")
 		cache.clear(h.DataType())
 		h.Handler.Handle(c, cache, seq)
+		h.end(c, seq, nil)
 	default:
 		h.l.Info("wait work")
 		t = <-h.done
@@ -89,11 +127,71 @@ func (h *handler) Handle(c *plugins.Client, cache *Cache) {
 	h.l.Info("handled")
 }
 
+// end sends the end of a run for each data type of the handler, a filtered
+// run is ended for each scope it covered, so the manager clears the scope
+// even if nothing is found in it.
+func (h *handler) end(c *plugins.Client, seq string, f *Filter) {
+	for _, rec := range h.ends(seq, f) {
+		c.SendRecord(rec)
+	}
+}
+
+func (h *handler) ends(seq string, f *Filter) []*plugins.Record {
+	dataTypes := []int{h.DataType()}
+	if m, ok := h.Handler.(MultiDataType); ok {
+		dataTypes = m.DataTypes()
+	}
+	scopes := []string{""}
+	if f != nil {
+		scopes = scopes[:0]
+		for scope := range f.scopes {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+	}
+	var recs []*plugins.Record
+	for _, scope := range scopes {
+		for _, dt := range dataTypes {
+			fields := map[string]string{FieldSeq: seq, FieldSeqEnd: "true"}
+			if f != nil {
+				f.Tag(fields, scope)
+			}
+			recs = append(recs, &plugins.Record{
+				DataType:  int32(dt),
+				Timestamp: time.Now().Unix(),
+				Data: &plugins.Payload{
+					Fields: fields,
+				},
+			})
+		}
+	}
+	return recs
+}
+
+// HandleFilter runs a filtered handle, which waits for the running one
+// instead of being skipped, and keeps the cache of the handler.
+func (h *handler) HandleFilter(c *plugins.Client, cache *Cache, f *Filter) error {
+	fh := h.Handler.(FilterHandler)
+	h.l.Infof("handling filter %+v", f)
+	t := <-h.done
+	seq := newSeq()
+	f.scopes = map[string]bool{}
+	err := fh.HandleFilter(c, cache, seq, f)
+	if err == nil {
+		h.end(c, seq, f)
+	}
+	h.done <- t
+	h.l.Info("handled filter")
+	return err
+}
+
 type Engine struct {
-	m     map[int]*handler
-	s     *cron.Cron
-	c     *plugins.Client
-	cache *Cache
+	m      map[int]*handler
+	s      *cron.Cron
+	c      *plugins.Client
+	cache  *Cache
+	mu     *sync.Mutex
+	policy *Policy
 }
 
 func BeforeDawn() time.Duration {
@@ -105,33 +203,137 @@ func (e *Engine) AddHandler(interval time.Duration, h Handler) {
 		h,
 		make(chan struct{}, 1),
 		interval,
+		nil,
+		0,
+		false,
 	}
 	e.m[h.DataType()].done <- struct{}{}
 }
 
+// apply resolves the schedule of every handler from the current policy and
+// replaces the scheduled entries of those which have finished the init call.
+func (e *Engine) apply() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, h := range e.m {
+		s, err := e.policy.resolve(h.Name(), h.interval)
+		if err != nil {
+			// policy was validated, fall back to built-in schedule anyway
+			h.l.Warnf("resolve schedule failed: %v", err)
+			s, _ = (*Policy)(nil).resolve(h.Name(), h.interval)
+		}
+		h.sched = s
+		if o, ok := h.Handler.(Optional); ok {
+			o.SetOptions(s.options)
+		}
+		if h.ready {
+			e.schedule(h)
+		}
+	}
+}
+
+// must be called with e.mu held
+func (e *Engine) schedule(h *handler) {
+	if h.entry != 0 {
+		e.s.Remove(h.entry)
+		h.entry = 0
+	}
+	if !h.sched.enabled {
+		h.l.Info("handler is disabled")
+		return
+	}
+	s := h.sched
+	id, err := e.s.AddFunc(s.spec, func() {
+		if s.quietAt(time.Now()) {
+			h.l.Info("skip work in quiet hours")
+			return
+		}
+		if s.jitter > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(s.jitter))))
+		}
+		h.Handle(e.c, e.cache)
+	})
+	if err != nil {
+		h.l.Errorf("add func to scheduler failed: %v", err)
+		return
+	}
+	h.entry = id
+	h.l.Infof("add func to scheduler successfully, spec: %s", s.spec)
+}
+
+func (e *Engine) enabled(h *handler) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return h.sched.enabled
+}
+
+func (e *Engine) status(token string, err error) {
+	fields := map[string]string{
+		"status": "succeed",
+		"msg":    "",
+		"token":  token,
+	}
+	if err != nil {
+		fields["status"] = "failed"
+		fields["msg"] = err.Error()
+	}
+	e.c.SendRecord(
+		&plugins.Record{
+			DataType:  5100,
+			Timestamp: time.Now().Unix(),
+			Data: &plugins.Payload{
+				Fields: fields,
+			}})
+}
+
+// reload parses, persists and applies a policy pushed by task
+func (e *Engine) reload(data string) error {
+	p, err := e.ParsePolicy(data)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	current := e.policy
+	e.mu.Unlock()
+	if current != nil && p.UpdateTime < current.UpdateTime {
+		return errors.New("the policy is older than the current one")
+	}
+	if err = os.WriteFile(policyFile, []byte(data), 0600); err != nil {
+		zap.S().Warnf("persist schedule policy failed: %v", err)
+	}
+	e.mu.Lock()
+	e.policy = p
+	e.mu.Unlock()
+	e.apply()
+	return nil
+}
+
 func (e *Engine) Run() {
 	zap.S().Info("engine running")
+	e.loadPolicy()
+	e.apply()
 	for _, h := range e.m {
 		go func(h *handler) {
-			var spec string
 			var r int
 			minutes := int(h.interval.Minutes())
 			if h.interval == BeforeDawn() {
-				spec = fmt.Sprintf("%d %d * * *", rand.Intn(60), rand.Intn(6))
 				r = rand.Intn(14400) + 7200
 			} else if minutes > 0 {
 				r = rand.Intn(minutes * 60)
-				spec = fmt.Sprintf("@every %dm", int(minutes))
 			} else {
 				panic("unknown interval")
 			}
-			h.l.Infof("init call will after %d secs\n", r)
-			time.Sleep(time.Second * time.Duration(r))
-			h.l.Info("init call")
-			h.Handle(e.c, e.cache)
-			time.Sleep(time.Minute * time.Duration(minutes))
-			e.s.AddFunc(spec, func() { h.Handle(e.c, e.cache) })
-			h.l.Info("add func to scheduler successfully")
+			if e.enabled(h) {
+				h.l.Infof("init call will after %d secs\n", r)
+				time.Sleep(time.Second * time.Duration(r))
+				h.l.Info("init call")
+				h.Handle(e.c, e.cache)
+				time.Sleep(time.Minute * time.Duration(minutes))
+			}
+			e.mu.Lock()
+			h.ready = true
+			e.schedule(h)
+			e.mu.Unlock()
 		}(h)
 	}
 	go func() {
@@ -145,33 +347,41 @@ func (e *Engine) Run() {
 			break
 		}
 		zap.S().Infof("received task %+v", t)
+		if t.DataType == PolicyDataType {
+			e.status(t.Token, e.reload(t.Data))
+			continue
+		}
 		if h, ok := e.m[int(t.DataType)]; ok {
+			if !e.enabled(h) {
+				e.status(t.Token, errors.New("the handler is disabled by schedule policy"))
+				continue
+			}
+			if f, err := ParseFilter(t.Data); err != nil || f != nil {
+				if err == nil {
+					if _, ok := h.Handler.(FilterHandler); !ok {
+						err = errors.New("the handler doesn't support filters")
+					}
+				}
+				if err != nil {
+					e.status(t.Token, err)
+					continue
+				}
+				f.Token = t.Token
+				e.status(t.Token, h.HandleFilter(e.c, e.cache, f))
+				continue
+			}
+			if cfg, ok := h.Handler.(Configurable); ok && t.Data != "" {
+				if err := cfg.Configure(t.Data); err != nil {
+					e.status(t.Token, err)
+					continue
+				}
+			}
 			h.Handle(e.c, e.cache)
 			// send result recored
-			e.c.SendRecord(
-				&plugins.Record{
-					DataType:  5100,
-					Timestamp: time.Now().Unix(),
-					Data: &plugins.Payload{
-						Fields: map[string]string{
-							"status": "succeed",
-							"msg":    "",
-							"token":  t.Token,
-						},
-					}})
+			e.status(t.Token, nil)
 		} else {
 			// can't find handler
-			e.c.SendRecord(
-				&plugins.Record{
-					DataType:  5100,
-					Timestamp: time.Now().Unix(),
-					Data: &plugins.Payload{
-						Fields: map[string]string{
-							"status": "failed",
-							"msg":    "the data_type hasn't been implemented",
-							"token":  t.Token,
-						},
-					}})
+			e.status(t.Token, errors.New("the data_type hasn't been implemented"))
 		}
 	}
 	zap.S().Warn("engine will stop")
@@ -188,5 +398,7 @@ func New(c *plugins.Client, l cron.Logger) *Engine {
 			m:  map[int]Records{},
 			mu: &sync.RWMutex{},
 		},
+		&sync.Mutex{},
+		nil,
 	}
 }
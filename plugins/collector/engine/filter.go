@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	plugins "github.com/bytedance/plugins"
+)
+
+// Filter narrows a task triggered run down to some processes, a container,
+// a path prefix or a package. It's carried by the task data as
+// {"filter": {...}}, criteria which a handler doesn't know are ignored.
+type Filter struct {
+	Pids []string `json:"pids,omitempty"`
+	// include descendants of pids
+	Tree        bool   `json:"tree,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Package     string `json:"package,omitempty"`
+	// token of the task, results are tagged with it
+	Token string `json:"-"`
+	// scopes returned by Scope, which the run is ended with, shared by
+	// copies of the filter
+	scopes map[string]bool
+}
+
+// FilterHandler is implemented by handlers which can run on a part of their
+// data. Records of a filtered run are tagged by Filter.Tag, and don't replace
+// the results of the last full run. The error is reported as the task status.
+type FilterHandler interface {
+	HandleFilter(c *plugins.Client, cache *Cache, seq string, f *Filter) error
+}
+
+// ParseFilter returns the filter carried by task data, or nil if there is none.
+func ParseFilter(data string) (*Filter, error) {
+	if !strings.HasPrefix(strings.TrimSpace(data), "{") {
+		return nil, nil
+	}
+	v := struct {
+		Filter *Filter `json:"filter"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil || v.Filter == nil {
+		return nil, nil
+	}
+	f := v.Filter
+	if len(f.Pids) == 0 && f.ContainerID == "" && f.Path == "" && f.Package == "" {
+		return nil, errors.New("empty filter")
+	}
+	if f.Path != "" {
+		if !filepath.IsAbs(f.Path) {
+			return nil, errors.New("filter path isn't absolute")
+		}
+		f.Path = filepath.Clean(f.Path)
+	}
+	return f, nil
+}
+
+func (f *Filter) MatchPid(pid string) bool {
+	if len(f.Pids) == 0 {
+		return true
+	}
+	for _, p := range f.Pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchContainer matches full or short container ids.
+func (f *Filter) MatchContainer(id string) bool {
+	return f.ContainerID == "" || (id != "" && strings.HasPrefix(id, f.ContainerID))
+}
+
+func (f *Filter) MatchPath(path string) bool {
+	return f.Path == "" || path == f.Path || strings.HasPrefix(path, strings.TrimSuffix(f.Path, "/")+"/")
+}
+
+func (f *Filter) MatchPackage(name string) bool {
+	return f.Package == "" || name == f.Package
+}
+
+// FilterScope names the record fields which the criteria apply to, criteria
+// without a field aren't supported by the handler.
+type FilterScope struct {
+	Pid       string
+	Container string
+	Path      string
+	Package   string
+	// fields which the run is bound to otherwise, e.g. the image of the
+	// filtered container
+	Eq map[string]string
+}
+
+// Check fails if none of the criteria is supported by the handler, the
+// unsupported ones are ignored.
+func (f *Filter) Check(s FilterScope) error {
+	if (s.Pid != "" && len(f.Pids) != 0) || (s.Container != "" && f.ContainerID != "") ||
+		(s.Path != "" && f.Path != "") || (s.Package != "" && f.Package != "") {
+		return nil
+	}
+	return errors.New("none of the filter criteria is supported by the handler")
+}
+
+type scopeCond struct {
+	In     []string `json:"in,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+	// the path itself or under it
+	Dir string `json:"dir,omitempty"`
+	Eq  string `json:"eq,omitempty"`
+}
+
+// Scope encodes the records covered by the filtered run, which the manager
+// replaces by the results: {"<field>": {"in"|"prefix"|"dir"|"eq": ...}}.
+func (f *Filter) Scope(s FilterScope) string {
+	m := map[string]scopeCond{}
+	if s.Pid != "" && len(f.Pids) != 0 {
+		m[s.Pid] = scopeCond{In: f.Pids}
+	}
+	if s.Container != "" && f.ContainerID != "" {
+		m[s.Container] = scopeCond{Prefix: f.ContainerID}
+	}
+	if s.Path != "" && f.Path != "" {
+		m[s.Path] = scopeCond{Dir: f.Path}
+	}
+	if s.Package != "" && f.Package != "" {
+		m[s.Package] = scopeCond{Eq: f.Package}
+	}
+	for k, v := range s.Eq {
+		m[k] = scopeCond{Eq: v}
+	}
+	b, _ := json.Marshal(m)
+	if f.scopes != nil {
+		f.scopes[string(b)] = true
+	}
+	return string(b)
+}
+
+// Tag marks a record as a result of the filtered task.
+func (f *Filter) Tag(fields map[string]string, scope string) {
+	fields["task_token"] = f.Token
+	fields["task_scope"] = scope
+}
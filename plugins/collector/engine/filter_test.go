@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+
+	plugins "github.com/bytedance/plugins"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, data := range []string{"", "1h", `{"rules":[]}`, `{"scopes":["bin"]}`} {
+		if f, err := ParseFilter(data); f != nil || err != nil {
+			t.Errorf("%q: unexpected filter %+v, %v", data, f, err)
+		}
+	}
+	for _, data := range []string{`{"filter":{}}`, `{"filter":{"path":"usr/bin"}}`} {
+		if _, err := ParseFilter(data); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+	f, err := ParseFilter(`{"filter":{"pids":["1","42"],"container_id":"abc","path":"/usr/bin/"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path != "/usr/bin" || !f.MatchPid("42") || f.MatchPid("4") {
+		t.Errorf("unexpected filter %+v", f)
+	}
+	if !f.MatchPath("/usr/bin") || !f.MatchPath("/usr/bin/ls") || f.MatchPath("/usr/binx") {
+		t.Error("unexpected path match")
+	}
+	if !f.MatchContainer("abcdef") || f.MatchContainer("") || f.MatchContainer("xabc") {
+		t.Error("unexpected container match")
+	}
+	if err = f.Check(FilterScope{Package: "name"}); err == nil {
+		t.Error("unsupported criteria: expected error")
+	}
+	scope := map[string]map[string]interface{}{}
+	if err = json.Unmarshal([]byte(f.Scope(FilterScope{Pid: "pid", Path: "exe", Eq: map[string]string{"image_id": "sha256:1"}})), &scope); err != nil {
+		t.Fatal(err)
+	}
+	if len(scope) != 3 || scope["exe"]["dir"] != "/usr/bin" || scope["image_id"]["eq"] != "sha256:1" || len(scope["pid"]["in"].([]interface{})) != 2 {
+		t.Errorf("unexpected scope %v", scope)
+	}
+}
+
+// scopeHandler finds nothing, like a rescan of a process tree which exited
+type scopeHandler struct{ nopHandler }
+
+func (h *scopeHandler) HandleFilter(c *plugins.Client, cache *Cache, seq string, f *Filter) error {
+	expanded := *f
+	expanded.Tree = false
+	expanded.Scope(FilterScope{Pid: "pid"})
+	return nil
+}
+
+func TestFilterEnds(t *testing.T) {
+	h := &handler{Handler: &scopeHandler{}}
+	f := &Filter{Pids: []string{"42"}, Tree: true, Token: "token"}
+	f.scopes = map[string]bool{}
+	if err := h.Handler.(FilterHandler).HandleFilter(nil, nil, "seq", f); err != nil {
+		t.Fatal(err)
+	}
+	recs := h.ends("seq", f)
+	if len(recs) != 1 {
+		t.Fatalf("unexpected ends %v", recs)
+	}
+	fields := recs[0].Data.Fields
+	if fields[FieldSeq] != "seq" || fields[FieldSeqEnd] != "true" || fields["task_token"] != "token" ||
+		fields["task_scope"] != `{"pid":{"in":["42"]}}` {
+		t.Errorf("unexpected end %v", fields)
+	}
+	recs = h.ends("seq", nil)
+	if len(recs) != 1 || recs[0].Data.Fields["task_token"] != "" {
+		t.Errorf("unexpected ends of a full run %v", recs)
+	}
+}
@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// PolicyDataType is the task data type used to push a new schedule policy.
+const PolicyDataType = 5101
+
+// persisted so that the latest pushed policy survives restarts
+const policyFile = "schedule_policy.json"
+
+// HandlerPolicy controls how a handler is scheduled, unset fields keep the
+// value of the default policy, then the built-in one.
+type HandlerPolicy struct {
+	Enabled *bool `json:"enabled,omitempty"`
+	// go duration, e.g. "30m"
+	Interval string `json:"interval,omitempty"`
+	// standard 5 fields cron spec, takes precedence over interval
+	Cron string `json:"cron,omitempty"`
+	// each run is delayed by a random duration within the window
+	Jitter string `json:"jitter,omitempty"`
+	// local time windows in which scheduled runs are skipped, e.g. "09:00-12:00,14:00-18:00"
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// handler specific options, only accepted by handlers implementing Optional
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Policy is read from the DETAIL env of the plugin, or pushed by a task with PolicyDataType.
+type Policy struct {
+	Default  HandlerPolicy            `json:"default"`
+	Handlers map[string]HandlerPolicy `json:"handlers"`
+	// unix time the manager resolved the policy at, the newest of DETAIL and
+	// the pushed one is used
+	UpdateTime int64 `json:"update_time,omitempty"`
+}
+
+type window struct {
+	start, end int
+}
+
+// schedule is the resolved policy of a handler
+type schedule struct {
+	enabled bool
+	spec    string
+	jitter  time.Duration
+	quiet   []window
+	options map[string]string
+}
+
+func parseQuietHours(s string) (ret []window, err error) {
+	for _, w := range strings.Split(s, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		var h1, m1, h2, m2 int
+		if _, err = fmt.Sscanf(w, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q", w)
+		}
+		if h1 < 0 || h1 > 24 || h2 < 0 || h2 > 24 || m1 < 0 || m1 > 59 || m2 < 0 || m2 > 59 {
+			return nil, fmt.Errorf("invalid quiet hours %q", w)
+		}
+		ret = append(ret, window{h1*60 + m1, h2*60 + m2})
+	}
+	return
+}
+
+// quietAt reports whether t falls in one of the windows, which may wrap midnight
+func (s *schedule) quietAt(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	for _, w := range s.quiet {
+		if w.start <= w.end && m >= w.start && m < w.end {
+			return true
+		}
+		if w.start > w.end && (m >= w.start || m < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+func merge(dst *HandlerPolicy, src HandlerPolicy) {
+	if src.Enabled != nil {
+		dst.Enabled = src.Enabled
+	}
+	if src.Interval != "" {
+		dst.Interval = src.Interval
+		dst.Cron = ""
+	}
+	if src.Cron != "" {
+		dst.Cron = src.Cron
+	}
+	if src.Jitter != "" {
+		dst.Jitter = src.Jitter
+	}
+	if src.QuietHours != "" {
+		dst.QuietHours = src.QuietHours
+	}
+	for k, v := range src.Options {
+		if dst.Options == nil {
+			dst.Options = map[string]string{}
+		}
+		dst.Options[k] = v
+	}
+}
+
+// resolve the schedule of a handler, interval is the built-in one passed to AddHandler
+func (p *Policy) resolve(name string, interval time.Duration) (s *schedule, err error) {
+	hp := HandlerPolicy{}
+	if p != nil {
+		merge(&hp, p.Default)
+		merge(&hp, p.Handlers[name])
+	}
+	s = &schedule{enabled: hp.Enabled == nil || *hp.Enabled, options: hp.Options}
+	switch {
+	case hp.Cron != "":
+		if _, err = cron.ParseStandard(hp.Cron); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.spec = hp.Cron
+	case hp.Interval != "":
+		var d time.Duration
+		if d, err = time.ParseDuration(hp.Interval); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("%s: interval %s is less than 1m", name, d)
+		}
+		s.spec = fmt.Sprintf("@every %s", d)
+	case interval == BeforeDawn():
+		s.spec = fmt.Sprintf("%d %d * * *", rand.Intn(60), rand.Intn(6))
+	default:
+		s.spec = fmt.Sprintf("@every %dm", int(interval.Minutes()))
+	}
+	if hp.Jitter != "" {
+		if s.jitter, err = time.ParseDuration(hp.Jitter); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if s.quiet, err = parseQuietHours(hp.QuietHours); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return
+}
+
+// ParsePolicy decodes and validates a policy against the registered handlers.
+func (e *Engine) ParsePolicy(data string) (*Policy, error) {
+	p := &Policy{}
+	if err := json.Unmarshal([]byte(data), p); err != nil {
+		return nil, err
+	}
+	names := map[string]*handler{}
+	for _, h := range e.m {
+		names[h.Name()] = h
+	}
+	if _, err := p.resolve("default", time.Hour); err != nil {
+		return nil, err
+	}
+	if len(p.Default.Options) > 0 {
+		return nil, errors.New("options are only accepted by handlers")
+	}
+	for name := range p.Handlers {
+		h, ok := names[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown handler %s", name)
+		}
+		s, err := p.resolve(name, h.interval)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.Handlers[name].Options) == 0 {
+			continue
+		}
+		o, ok := h.Handler.(Optional)
+		if !ok {
+			return nil, fmt.Errorf("%s: the handler doesn't support options", name)
+		}
+		if err = o.CheckOptions(s.options); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+// initial policy, the newest of the pushed one and DETAIL, the pushed one wins a tie
+func (e *Engine) loadPolicy() {
+	for _, data := range []func() (string, error){
+		func() (string, error) {
+			b, err := os.ReadFile(policyFile)
+			return string(b), err
+		},
+		func() (string, error) {
+			if v, ok := os.LookupEnv("DETAIL"); ok && v != "" {
+				return v, nil
+			}
+			return "", os.ErrNotExist
+		},
+	} {
+		d, err := data()
+		if err != nil {
+			continue
+		}
+		p, err := e.ParsePolicy(d)
+		if err != nil {
+			zap.S().Warnf("invalid schedule policy: %v", err)
+			continue
+		}
+		if e.policy == nil || p.UpdateTime > e.policy.UpdateTime {
+			e.policy = p
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	plugins "github.com/bytedance/plugins"
+	"go.uber.org/zap"
+)
+
+type nopHandler struct{ name string }
+
+func (h *nopHandler) Handle(*plugins.Client, *Cache, string) {}
+func (h *nopHandler) Name() string                           { return h.name }
+func (h *nopHandler) DataType() int                          { return 1 }
+
+func TestParsePolicy(t *testing.T) {
+	e := New(nil, nil)
+	e.AddHandler(time.Hour, &nopHandler{"software"})
+	for _, data := range []string{
+		`{"handlers":{"unknown":{}}}`,
+		`{"handlers":{"software":{"interval":"10s"}}}`,
+		`{"handlers":{"software":{"cron":"* *"}}}`,
+		`{"default":{"jitter":"1x"}}`,
+		`{"default":{"quiet_hours":"25:00-01:00"}}`,
+	} {
+		if _, err := e.ParsePolicy(data); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+	p, err := e.ParsePolicy(`{"default":{"cron":"0 3 * * *","jitter":"10m","quiet_hours":"22:00-06:00"},"handlers":{"software":{"enabled":false,"interval":"2h"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.resolve("software", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.enabled || s.spec != "@every 2h0m0s" || s.jitter != 10*time.Minute {
+		t.Errorf("unexpected schedule %+v", s)
+	}
+	s, _ = p.resolve("port", time.Hour)
+	if !s.enabled || s.spec != "0 3 * * *" {
+		t.Errorf("unexpected schedule %+v", s)
+	}
+	s, _ = (*Policy)(nil).resolve("port", time.Hour)
+	if !s.enabled || s.spec != "@every 60m" || s.jitter != 0 {
+		t.Errorf("unexpected schedule %+v", s)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	quiet, err := parseQuietHours("22:00-06:00, 12:30-13:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &schedule{quiet: quiet}
+	for hm, want := range map[string]bool{
+		"23:59": true,
+		"00:00": true,
+		"05:59": true,
+		"06:00": false,
+		"12:29": false,
+		"12:30": true,
+		"13:00": false,
+		"21:59": false,
+	} {
+		tm, _ := time.Parse("15:04", hm)
+		if got := s.quietAt(tm); got != want {
+			t.Errorf("%s: got %v, want %v", hm, got, want)
+		}
+	}
+}
+
+type optionalHandler struct {
+	nopHandler
+	options map[string]string
+}
+
+func (h *optionalHandler) CheckOptions(options map[string]string) error {
+	for k := range options {
+		if k != "probe" {
+			return fmt.Errorf("unknown option %s", k)
+		}
+	}
+	return nil
+}
+func (h *optionalHandler) SetOptions(options map[string]string) { h.options = options }
+
+func TestPolicyOptions(t *testing.T) {
+	e := New(nil, nil)
+	e.AddHandler(time.Hour, &nopHandler{"software"})
+	h := &optionalHandler{nopHandler: nopHandler{"app"}}
+	e.m[2] = &handler{Handler: h, interval: time.Hour, l: zap.S()}
+	for _, data := range []string{
+		`{"default":{"options":{"probe":"true"}}}`,
+		`{"handlers":{"software":{"options":{"probe":"true"}}}}`,
+		`{"handlers":{"app":{"options":{"unknown":"true"}}}}`,
+	} {
+		if _, err := e.ParsePolicy(data); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+	p, err := e.ParsePolicy(`{"handlers":{"app":{"options":{"probe":"true"}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.policy = p
+	e.apply()
+	if h.options["probe"] != "true" {
+		t.Errorf("unexpected options %v", h.options)
+	}
+	e.policy = nil
+	e.apply()
+	if len(h.options) != 0 {
+		t.Errorf("options %v are kept without policy", h.options)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name   string
+		pushed string
+		detail string
+		// interval of software after loading
+		want string
+	}{
+		{"none", "", "", "@every 60m"},
+		{"detail only", "", `{"handlers":{"software":{"interval":"2h"}}}`, "@every 2h0m0s"},
+		{"pushed wins a tie", `{"handlers":{"software":{"interval":"3h"}}}`, `{"handlers":{"software":{"interval":"2h"}}}`, "@every 3h0m0s"},
+		{"newer detail", `{"handlers":{"software":{"interval":"3h"}},"update_time":100}`, `{"handlers":{"software":{"interval":"2h"}},"update_time":200}`, "@every 2h0m0s"},
+		{"newer pushed", `{"handlers":{"software":{"interval":"3h"}},"update_time":300}`, `{"handlers":{"software":{"interval":"2h"}},"update_time":200}`, "@every 3h0m0s"},
+		{"invalid pushed", `{"handlers":{"unknown":{}},"update_time":300}`, `{"handlers":{"software":{"interval":"2h"}},"update_time":200}`, "@every 2h0m0s"},
+	}
+	for _, tt := range tests {
+		os.Remove(policyFile)
+		if tt.pushed != "" {
+			if err := os.WriteFile(policyFile, []byte(tt.pushed), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Setenv("DETAIL", tt.detail)
+		e := New(nil, nil)
+		e.AddHandler(time.Hour, &nopHandler{"software"})
+		e.loadPolicy()
+		s, err := e.policy.resolve("software", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.spec != tt.want {
+			t.Errorf("%s: spec %s, want %s", tt.name, s.spec, tt.want)
+		}
+	}
+
+	// an older policy pushed late doesn't replace the current one
+	e := New(nil, nil)
+	e.AddHandler(time.Hour, &nopHandler{"software"})
+	e.policy = &Policy{UpdateTime: 200}
+	if err := e.reload(`{"update_time":100}`); err == nil {
+		t.Error("reload() of an older policy, want error")
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/fim"
+	"github.com/bytedance/Elkeid/plugins/collector/utils"
+	plugins "github.com/bytedance/plugins"
+	"github.com/juju/ratelimit"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+)
+
+const (
+	fimConfigPath   = "fim.json"
+	fimBaselinePath = "fim_baseline.json"
+)
+
+const (
+	fimSourceScan    = "scan"
+	fimSourceInotify = "inotify"
+)
+
+// FimConfig is pushed by the manager as the data of a 5067 task, and
+// persisted so it survives plugin restarts.
+type FimConfig struct {
+	Rules []fim.Rule `json:"rules"`
+	// only check on schedule
+	DisableWatch bool `json:"disable_watch"`
+}
+
+var defaultFimConfig = FimConfig{Rules: []fim.Rule{
+	{
+		Name:    "etc",
+		Paths:   []string{"/etc"},
+		Exclude: []string{"/etc/mtab", "/etc/adjtime", "/etc/ld.so.cache", "*.swp", "*~", "*.lock"},
+	},
+	{
+		Name:    "web",
+		Paths:   []string{"/var/www", "/usr/share/nginx/html"},
+		Exclude: []string{"*.log"},
+	},
+}}
+
+func (cfg *FimConfig) validate() error {
+	names := map[string]bool{}
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].Validate(); err != nil {
+			return err
+		}
+		if names[cfg.Rules[i].Name] {
+			return fmt.Errorf("duplicated rule %s", cfg.Rules[i].Name)
+		}
+		names[cfg.Rules[i].Name] = true
+	}
+	return nil
+}
+
+type FimEvent struct {
+	Event        string `mapstructure:"event"`
+	Rule         string `mapstructure:"rule"`
+	Path         string `mapstructure:"path"`
+	Source       string `mapstructure:"source"`
+	Size         string `mapstructure:"size"`
+	OriginSize   string `mapstructure:"origin_size"`
+	Digest       string `mapstructure:"digest"`
+	OriginDigest string `mapstructure:"origin_digest"`
+	Mode         string `mapstructure:"mode"`
+	OriginMode   string `mapstructure:"origin_mode"`
+	Owner        string `mapstructure:"owner"`
+	OriginOwner  string `mapstructure:"origin_owner"`
+	ModifyTime   string `mapstructure:"modify_time"`
+	// unified diff of small text files
+	Diff          string `mapstructure:"diff"`
+	DiffTruncated string `mapstructure:"diff_truncated"`
+}
+
+// FimHandler monitors files matched by rules against a local baseline, on
+// schedule and through inotify. Files of a new rule are baselined silently,
+// changes are reported afterwards.
+type FimHandler struct {
+	once sync.Once
+	// guards all below
+	mu  sync.Mutex
+	cfg FimConfig
+	// rule -> path -> state
+	baseline map[string]map[string]*fim.FileState
+	watcher  *fim.Watcher
+	c        *plugins.Client
+	seq      string
+}
+
+func (h *FimHandler) Name() string {
+	return "fim"
+}
+func (h *FimHandler) DataType() int {
+	return 5067
+}
+
+func (h *FimHandler) Configure(data string) (err error) {
+	cfg := FimConfig{}
+	if err = json.Unmarshal([]byte(data), &cfg); err != nil {
+		return
+	}
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	if len(cfg.Rules) == 0 {
+		cfg.Rules = defaultFimConfig.Rules
+	}
+	h.load()
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+	return os.WriteFile(fimConfigPath, []byte(data), 0600)
+}
+
+func (h *FimHandler) load() {
+	h.once.Do(func() {
+		h.cfg = defaultFimConfig
+		if data, err := os.ReadFile(fimConfigPath); err == nil {
+			cfg := FimConfig{}
+			if err = json.Unmarshal(data, &cfg); err == nil {
+				err = cfg.validate()
+			}
+			if err != nil {
+				zap.S().Warn("invalid fim config: ", err.Error())
+			} else {
+				if len(cfg.Rules) == 0 {
+					cfg.Rules = defaultFimConfig.Rules
+				}
+				h.cfg = cfg
+			}
+		}
+		h.baseline = map[string]map[string]*fim.FileState{}
+		if data, err := os.ReadFile(fimBaselinePath); err == nil {
+			if err = json.Unmarshal(data, &h.baseline); err != nil {
+				zap.S().Warn("invalid fim baseline: ", err.Error())
+				h.baseline = map[string]map[string]*fim.FileState{}
+			}
+		}
+	})
+}
+
+// guarded by mu
+func (h *FimHandler) save() {
+	data, err := json.Marshal(h.baseline)
+	if err == nil {
+		err = os.WriteFile(fimBaselinePath, data, 0600)
+	}
+	if err != nil {
+		zap.S().Warn("failed to save fim baseline: ", err.Error())
+	}
+}
+
+func ownerString(uid, gid uint32) string {
+	u := strconv.FormatUint(uint64(uid), 10)
+	g := strconv.FormatUint(uint64(gid), 10)
+	if name, err := utils.GetUsername(u); err == nil {
+		u = name
+	}
+	if name, err := utils.GetGroupname(g); err == nil {
+		g = name
+	}
+	return u + ":" + g
+}
+
+// guarded by mu
+func (h *FimHandler) send(rule string, c *fim.Change, source string) {
+	if h.c == nil {
+		return
+	}
+	e := &FimEvent{
+		Event:  c.Event,
+		Rule:   rule,
+		Path:   c.Path,
+		Source: source,
+		Diff:   c.Diff,
+	}
+	if c.DiffTruncated {
+		e.DiffTruncated = "true"
+	}
+	if c.New != nil {
+		e.Size = strconv.FormatInt(c.New.Size, 10)
+		e.Digest = c.New.Digest
+		e.Mode = fmt.Sprintf("%04o", c.New.Mode)
+		e.Owner = ownerString(c.New.Uid, c.New.Gid)
+		e.ModifyTime = strconv.FormatInt(c.New.Mtime/int64(time.Second), 10)
+	}
+	if c.Old != nil {
+		e.OriginSize = strconv.FormatInt(c.Old.Size, 10)
+		e.OriginDigest = c.Old.Digest
+		e.OriginMode = fmt.Sprintf("%04o", c.Old.Mode)
+		e.OriginOwner = ownerString(c.Old.Uid, c.Old.Gid)
+	}
+	rec := &plugins.Record{
+		DataType:  int32(h.DataType()),
+		Timestamp: time.Now().Unix(),
+		Data: &plugins.Payload{
+			Fields: make(map[string]string, 18),
+		},
+	}
+	mapstructure.Decode(e, &rec.Data.Fields)
+	rec.Data.Fields["package_seq"] = h.seq
+	h.c.SendRecord(rec)
+}
+
+// stat returns nil state if the path is gone or isn't a regular file any more
+func stat(path string, prev *fim.FileState, diff bool, wrap func(io.Reader) io.Reader) (*fim.FileState, bool) {
+	s, err := fim.Stat(path, prev, diff, wrap)
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ELOOP) {
+		return nil, false
+	}
+	return s, true
+}
+
+func scanWrap(r io.Reader) io.Reader {
+	return ratelimit.Reader(r, ratelimit.NewBucketWithRate(1024*1024, 1024*1024))
+}
+
+// guarded by mu
+func (h *FimHandler) scan(rule *fim.Rule) {
+	old, ok := h.baseline[rule.Name]
+	states := map[string]*fim.FileState{}
+	err := rule.Walk(func(path string) {
+		if s, ok := stat(path, old[path], rule.Diff, scanWrap); ok && s != nil {
+			states[path] = s
+		} else if !ok && old[path] != nil {
+			// unreadable for now, keep the baseline
+			states[path] = old[path]
+		}
+	})
+	if err != nil {
+		zap.S().Warnf("fim rule %s: %s", rule.Name, err.Error())
+	}
+	if ok {
+		for path, s := range states {
+			if c := fim.Compare(path, old[path], s); c != nil {
+				h.send(rule.Name, c, fimSourceScan)
+			}
+		}
+		if err == nil {
+			for path, s := range old {
+				if _, ok := states[path]; !ok {
+					h.send(rule.Name, fim.Compare(path, s, nil), fimSourceScan)
+				}
+			}
+		}
+	}
+	h.baseline[rule.Name] = states
+}
+
+// check is called back by the watcher with changed paths
+func (h *FimHandler) check(paths []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.cfg.Rules {
+		rule := &h.cfg.Rules[i]
+		states, ok := h.baseline[rule.Name]
+		if !ok {
+			continue
+		}
+		candidates := map[string]bool{}
+		for _, path := range paths {
+			if !rule.Covers(path) {
+				continue
+			}
+			if rule.Match(path) {
+				candidates[path] = true
+			}
+			// a directory which is created, removed or moved
+			prefix := strings.TrimSuffix(path, "/") + "/"
+			for p := range states {
+				if strings.HasPrefix(p, prefix) {
+					candidates[p] = true
+				}
+			}
+			if fi, err := os.Lstat(path); err == nil && fi.IsDir() && !rule.Excluded(path) {
+				sub := *rule
+				sub.Paths = []string{path}
+				sub.Walk(func(p string) {
+					candidates[p] = true
+				})
+			}
+		}
+		for path := range candidates {
+			if !rule.Match(path) {
+				continue
+			}
+			s, ok := stat(path, states[path], rule.Diff, nil)
+			if !ok {
+				continue
+			}
+			if c := fim.Compare(path, states[path], s); c != nil {
+				h.send(rule.Name, c, fimSourceInotify)
+			}
+			if s != nil {
+				states[path] = s
+			} else {
+				delete(states, path)
+			}
+		}
+	}
+	h.save()
+}
+
+func (h *FimHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.load()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.c = c
+	h.seq = seq
+	rules := map[string]bool{}
+	for i := range h.cfg.Rules {
+		h.scan(&h.cfg.Rules[i])
+		rules[h.cfg.Rules[i].Name] = true
+	}
+	for name := range h.baseline {
+		if !rules[name] {
+			delete(h.baseline, name)
+		}
+	}
+	h.save()
+	if h.cfg.DisableWatch {
+		if h.watcher != nil {
+			h.watcher.Close()
+			h.watcher = nil
+		}
+		return
+	}
+	if h.watcher == nil {
+		w, err := fim.NewWatcher(h.check)
+		if err != nil {
+			zap.S().Warn("fim watcher: ", err.Error())
+			return
+		}
+		h.watcher = w
+	}
+	h.watcher.SetRules(h.cfg.Rules)
+	if h.watcher.Watches() >= fim.MaxWatches {
+		zap.S().Warnf("fim watches reach the limit %d, the rest are checked on schedule", fim.MaxWatches)
+	}
+}
@@ -0,0 +1,317 @@
+package fim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// events
+const (
+	EventCreate = "create"
+	EventModify = "modify"
+	EventDelete = "delete"
+	// mode or owner changed only
+	EventAttrib = "attrib"
+)
+
+const (
+	// contents of text files up to this size are kept for diffs
+	MaxDiffFileSize = 64 * 1024
+	MaxDiffSize     = 8 * 1024
+	MaxHashFileSize = 100 * 1024 * 1024
+	// bounds the files of a rule
+	MaxRuleFiles = 100000
+)
+
+var ErrTooManyFiles = errors.New("too many files")
+
+// contents of password hashes, sudo rules and private keys are never kept
+// or diffed regardless of rules, only their digests are compared
+var (
+	sensitivePrefixes = []string{
+		"/etc/shadow",
+		"/etc/gshadow",
+		"/etc/sudoers",
+		"/etc/security/opasswd",
+		"/etc/ssh/ssh_host_",
+		"/etc/ssl/private/",
+		"/etc/pki/tls/private/",
+		"/etc/krb5.keytab",
+	}
+	sensitiveContains = []string{"/.ssh/", "/.gnupg/"}
+	sensitiveSuffixes = []string{".key", ".keytab"}
+)
+
+// Sensitive reports whether the content of path is denied to diffs.
+func Sensitive(path string) bool {
+	for _, p := range sensitivePrefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	for _, p := range sensitiveContains {
+		if strings.Contains(path, p) {
+			return true
+		}
+	}
+	for _, p := range sensitiveSuffixes {
+		if strings.HasSuffix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule monitors the files under its paths, include and exclude are glob
+// patterns of filepath.Match, which are matched against both the full path
+// and the base name.
+type Rule struct {
+	Name    string   `json:"name"`
+	Paths   []string `json:"paths"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// capture unified diffs of small text files
+	Diff bool `json:"diff,omitempty"`
+}
+
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("empty rule name")
+	}
+	if len(r.Paths) == 0 {
+		return fmt.Errorf("rule %s: empty paths", r.Name)
+	}
+	for _, p := range r.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("rule %s: path %q isn't absolute", r.Name, p)
+		}
+	}
+	for _, p := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("rule %s: invalid pattern %q: %w", r.Name, p, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, path string) bool {
+	base := filepath.Base(path)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Excluded reports whether a path, file or directory, is excluded.
+func (r *Rule) Excluded(path string) bool {
+	return matchAny(r.Exclude, path)
+}
+
+// Match reports whether a file under the paths of the rule is monitored.
+func (r *Rule) Match(path string) bool {
+	if !r.Covers(path) || r.Excluded(path) {
+		return false
+	}
+	return len(r.Include) == 0 || matchAny(r.Include, path)
+}
+
+// Covers reports whether a path is one of the paths of the rule or under them.
+func (r *Rule) Covers(path string) bool {
+	for _, p := range r.Paths {
+		p = filepath.Clean(p)
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// FileState is the baseline of a monitored file.
+type FileState struct {
+	Size  int64  `json:"size"`
+	Mode  uint32 `json:"mode"`
+	Uid   uint32 `json:"uid"`
+	Gid   uint32 `json:"gid"`
+	Ino   uint64 `json:"ino"`
+	Mtime int64  `json:"mtime"`
+	Ctime int64  `json:"ctime"`
+	// empty if the file is too large
+	Digest string `json:"digest,omitempty"`
+	// kept for diffs if the rule asks for them
+	Content string `json:"content,omitempty"`
+}
+
+func (s *FileState) sameContent(o *FileState) bool {
+	return s.Ino == o.Ino && s.Size == o.Size && s.Mtime == o.Mtime && s.Ctime == o.Ctime
+}
+
+func isText(b []byte) bool {
+	return bytes.IndexByte(b, 0) < 0 && utf8.Valid(b)
+}
+
+// Stat reads the state of a regular file, the digest of prev is reused if
+// the file is unchanged. Other types of files return nil. Contents of
+// sensitive files aren't kept even if diff is set.
+func Stat(path string, prev *FileState, diff bool, wrap func(io.Reader) io.Reader) (*FileState, error) {
+	if diff && Sensitive(path) {
+		diff = false
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+	s := &FileState{
+		Size:  st.Size,
+		Mode:  st.Mode & 07777,
+		Uid:   st.Uid,
+		Gid:   st.Gid,
+		Ino:   st.Ino,
+		Mtime: st.Mtim.Nano(),
+		Ctime: st.Ctim.Nano(),
+	}
+	if prev != nil && prev.sameContent(s) && (!diff || prev.Content != "" || prev.Digest == "") {
+		s.Digest = prev.Digest
+		if diff {
+			s.Content = prev.Content
+		}
+		return s, nil
+	}
+	if st.Size > MaxHashFileSize {
+		return s, nil
+	}
+	var r io.Reader = f
+	if wrap != nil {
+		r = wrap(f)
+	}
+	h := sha256.New()
+	if diff && st.Size <= MaxDiffFileSize {
+		b, err := io.ReadAll(io.LimitReader(r, MaxDiffFileSize))
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+		if isText(b) {
+			s.Content = string(b)
+		}
+	} else if _, err = io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	s.Digest = hex.EncodeToString(h.Sum(nil))
+	return s, nil
+}
+
+// Walk calls f with each monitored file under the paths of the rule,
+// symlinks aren't followed.
+func (r *Rule) Walk(f func(path string)) error {
+	n := 0
+	for _, root := range r.Paths {
+		root = filepath.Clean(root)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && r.Excluded(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !r.Match(path) {
+				return nil
+			}
+			if n++; n > MaxRuleFiles {
+				return ErrTooManyFiles
+			}
+			f(path)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Change is a difference between the baseline and the current state of a file.
+type Change struct {
+	Event string
+	Path  string
+	Old   *FileState
+	New   *FileState
+	// unified diff, only for text files of rules with diff
+	Diff          string
+	DiffTruncated bool
+}
+
+// Compare returns the change of a file, nil if nothing changed.
+func Compare(path string, old, new *FileState) *Change {
+	switch {
+	case old == nil && new == nil:
+		return nil
+	case old == nil:
+		return &Change{Event: EventCreate, Path: path, New: new}
+	case new == nil:
+		return &Change{Event: EventDelete, Path: path, Old: old}
+	// files too large to hash are compared by mtime
+	case old.Digest != new.Digest || old.Size != new.Size ||
+		(old.Digest == "" && old.Mtime != new.Mtime):
+		c := &Change{Event: EventModify, Path: path, Old: old, New: new}
+		// contents kept by an earlier version are dropped instead of diffed
+		if (old.Content != "" || new.Content != "") && !Sensitive(path) {
+			c.Diff, c.DiffTruncated = Diff(path, old.Content, new.Content)
+		}
+		return c
+	case old.Mode != new.Mode || old.Uid != new.Uid || old.Gid != new.Gid:
+		return &Change{Event: EventAttrib, Path: path, Old: old, New: new}
+	}
+	return nil
+}
+
+// Diff returns the unified diff of two contents, truncated to MaxDiffSize.
+func Diff(path, a, b string) (string, bool) {
+	d, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		return "", false
+	}
+	if len(d) > MaxDiffSize {
+		d = d[:MaxDiffSize]
+		// don't cut a rune in half
+		for len(d) > 0 && !utf8.ValidString(d) {
+			d = d[:len(d)-1]
+		}
+		return d, true
+	}
+	return d, false
+}
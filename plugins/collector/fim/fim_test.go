@@ -0,0 +1,130 @@
+package fim
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRuleMatch(t *testing.T) {
+	r := &Rule{
+		Name:    "etc",
+		Paths:   []string{"/etc/"},
+		Include: []string{"*.conf", "/etc/passwd"},
+		Exclude: []string{"*.swp", "/etc/cache"},
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		"/etc/passwd":        true,
+		"/etc/nginx/a.conf":  true,
+		"/etc/shadow":        false,
+		"/etc/.a.conf.swp":   false,
+		"/etcx/a.conf":       false,
+		"/etc/cache":         false,
+		"/usr/etc/foo.conf":  false,
+		"/etc/ssh/sshd.conf": true,
+	} {
+		if got := r.Match(path); got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+	if err := (&Rule{Name: "x", Paths: []string{"etc"}}).Validate(); err == nil {
+		t.Error("relative path: expected error")
+	}
+	if err := (&Rule{Name: "x", Paths: []string{"/etc"}, Exclude: []string{"["}}).Validate(); err == nil {
+		t.Error("bad pattern: expected error")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(path, []byte("a=1\nb=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := Stat(path, nil, true, nil)
+	if err != nil || old == nil || old.Content == "" {
+		t.Fatalf("unexpected state %+v, %v", old, err)
+	}
+	if c := Compare(path, nil, old); c == nil || c.Event != EventCreate {
+		t.Errorf("unexpected change %+v", c)
+	}
+	same, _ := Stat(path, old, true, nil)
+	if c := Compare(path, old, same); c != nil {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if err := os.WriteFile(path, []byte("a=1\nb=3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cur, _ := Stat(path, old, true, nil)
+	c := Compare(path, old, cur)
+	if c == nil || c.Event != EventModify || !strings.Contains(c.Diff, "-b=2\n+b=3\n") || c.DiffTruncated {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	next, _ := Stat(path, cur, true, nil)
+	if c := Compare(path, cur, next); c == nil || c.Event != EventAttrib {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if c := Compare(path, next, nil); c == nil || c.Event != EventDelete {
+		t.Errorf("unexpected change %+v", c)
+	}
+	d, truncated := Diff(path, "", strings.Repeat("line\n", MaxDiffSize))
+	if !truncated || len(d) > MaxDiffSize {
+		t.Errorf("diff isn't truncated: %d", len(d))
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/etc/shadow":                    true,
+		"/etc/gshadow-":                  true,
+		"/etc/sudoers.d/admins":          true,
+		"/etc/ssh/ssh_host_ed25519_key":  true,
+		"/root/.ssh/authorized_keys":     true,
+		"/etc/nginx/ssl/server.key":      true,
+		"/etc/passwd":                    false,
+		"/etc/ssh/sshd_config":           false,
+		"/etc/nginx/conf.d/default.conf": false,
+	} {
+		if got := Sensitive(path); got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+
+	// a sensitive file of a rule with diff is compared by digests only
+	dir := filepath.Join(t.TempDir(), ".ssh")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_rsa")
+	if err := os.WriteFile(path, []byte("secret 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old, err := Stat(path, nil, true, nil)
+	if err != nil || old == nil || old.Digest == "" || old.Content != "" {
+		t.Fatalf("unexpected state %+v, %v", old, err)
+	}
+	if err := os.WriteFile(path, []byte("secret 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cur, _ := Stat(path, old, true, nil)
+	if c := Compare(path, old, cur); c == nil || c.Event != EventModify || c.Diff != "" || cur.Content != "" {
+		t.Errorf("unexpected change %+v", c)
+	}
+
+	// contents kept in the baseline by an earlier version aren't diffed
+	old = &FileState{Size: 12, Digest: "a", Content: "root:$6$old:\n"}
+	cur = &FileState{Size: 12, Digest: "b"}
+	if c := Compare("/etc/shadow", old, cur); c == nil || c.Event != EventModify || c.Diff != "" {
+		t.Errorf("/etc/shadow: unexpected change %+v", c)
+	}
+	if s, err := Stat("/etc/shadow", nil, true, nil); err == nil && (s == nil || s.Digest == "" || s.Content != "") {
+		t.Errorf("/etc/shadow: unexpected state %+v", s)
+	}
+}
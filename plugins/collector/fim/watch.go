@@ -0,0 +1,171 @@
+package fim
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// inotify watches are a system-wide resource, leave most of them to others
+	MaxWatches = 8192
+	// events of the same path are coalesced within the window
+	debounceWindow = 5 * time.Second
+)
+
+// Watcher watches the directories of rules with inotify, and calls back
+// with the paths changed during the debounce window. A path may be a
+// directory, whose whole tree should be checked then.
+type Watcher struct {
+	w       *fsnotify.Watcher
+	mu      sync.Mutex
+	rules   []Rule
+	watched map[string]bool
+	pending map[string]bool
+	timer   *time.Timer
+	f       func(paths []string)
+}
+
+func NewWatcher(f func(paths []string)) (*Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	ret := &Watcher{
+		w:       w,
+		watched: map[string]bool{},
+		pending: map[string]bool{},
+		f:       f,
+	}
+	go ret.run()
+	return ret, nil
+}
+
+func (w *Watcher) Close() error {
+	return w.w.Close()
+}
+
+// SetRules replaces the watched directories by those of rules.
+func (w *Watcher) SetRules(rules []Rule) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir := range w.watched {
+		w.w.Remove(dir)
+	}
+	w.watched = map[string]bool{}
+	w.rules = rules
+	for _, r := range rules {
+		for _, p := range r.Paths {
+			p = filepath.Clean(p)
+			// files are watched through their directories
+			if fi, err := os.Lstat(p); err == nil && !fi.IsDir() {
+				if dir := filepath.Dir(p); !w.watched[dir] && len(w.watched) < MaxWatches {
+					if err = w.w.Add(dir); err == nil {
+						w.watched[dir] = true
+					}
+				}
+				continue
+			}
+			w.addTree(p)
+		}
+	}
+}
+
+func (w *Watcher) excluded(dir string) bool {
+	for _, r := range w.rules {
+		if r.Covers(dir) && !r.Excluded(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// guarded by mu
+func (w *Watcher) addTree(root string) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if w.watched[path] {
+			return nil
+		}
+		if len(w.watched) >= MaxWatches {
+			return filepath.SkipDir
+		}
+		if w.excluded(path) {
+			return filepath.SkipDir
+		}
+		if err := w.w.Add(path); err == nil {
+			w.watched[path] = true
+		}
+		return nil
+	})
+}
+
+// Watches returns the number of watched directories.
+func (w *Watcher) Watches() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.watched)
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case e, ok := <-w.w.Events:
+			if !ok {
+				return
+			}
+			w.mu.Lock()
+			if e.Op&fsnotify.Create != 0 {
+				// watch new directories, files created before the watch is
+				// added are found by the scan of the directory
+				w.addTree(e.Name)
+			}
+			if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && w.watched[e.Name] {
+				delete(w.watched, e.Name)
+			}
+			w.pending[e.Name] = true
+			if w.timer == nil {
+				w.timer = time.AfterFunc(debounceWindow, w.flush)
+			}
+			w.mu.Unlock()
+		case err, ok := <-w.w.Errors:
+			if !ok {
+				return
+			}
+			// events are lost, check the whole trees again
+			if err == fsnotify.ErrEventOverflow {
+				w.mu.Lock()
+				for _, r := range w.rules {
+					for _, p := range r.Paths {
+						w.pending[filepath.Clean(p)] = true
+					}
+				}
+				if w.timer == nil {
+					w.timer = time.AfterFunc(debounceWindow, w.flush)
+				}
+				w.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	paths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		paths = append(paths, p)
+	}
+	w.pending = map[string]bool{}
+	w.timer = nil
+	w.mu.Unlock()
+	w.f(paths)
+}
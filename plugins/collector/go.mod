@@ -11,18 +11,24 @@ require (
 	github.com/coocood/freecache v1.2.3
 	github.com/deckarep/golang-set v1.8.0
 	github.com/docker/docker v20.10.21+incompatible
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-logr/zapr v1.2.2
+	github.com/hashicorp/golang-lru v1.0.2
 	github.com/jellydator/ttlcache/v3 v3.0.0
 	github.com/juju/ratelimit v1.0.2
 	github.com/karrick/godirwalk v1.16.1
+	github.com/klauspost/compress v1.15.11
 	github.com/mitchellh/mapstructure v1.5.0
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/shirou/gopsutil/v3 v3.22.10
 	github.com/tklauser/go-sysconf v0.3.10
 	github.com/vishvananda/netlink v1.2.0-beta
 	go.uber.org/zap v1.20.0
+	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa
 	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f
 	google.golang.org/grpc v1.51.0
+	google.golang.org/protobuf v1.28.0
 	k8s.io/cri-api v0.25.4
 )
 
@@ -35,7 +41,6 @@ require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/hashicorp/golang-lru v1.0.2 // indirect
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
 	github.com/moby/term v0.0.0-20221128092401-c43b287e0e0f // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
@@ -56,7 +61,6 @@ require (
 	golang.org/x/time v0.2.0 // indirect
 	golang.org/x/tools v0.1.12 // indirect
 	google.golang.org/genproto v0.0.0-20220502173005-c8bf987b8c21 // indirect
-	google.golang.org/protobuf v1.28.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gotest.tools/v3 v3.4.0 // indirect
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/process"
+	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+)
+
+const (
+	anomalyHiddenProcess = "hidden_process"
+	anomalyLdSoPreload   = "ld_so_preload"
+	anomalyLdPreloadEnv  = "ld_preload_env"
+)
+
+type ProcessAnomaly struct {
+	Type     string `mapstructure:"type"`
+	Severity string `mapstructure:"severity"`
+	Pid      string `mapstructure:"pid"`
+	Exe      string `mapstructure:"exe"`
+	Comm     string `mapstructure:"comm"`
+	Cmdline  string `mapstructure:"cmdline"`
+	FoundIn  string `mapstructure:"found_in"`
+	Missing  string `mapstructure:"missing"`
+	Library  string `mapstructure:"library"`
+	// md5 of the preloaded library
+	Checksum string `mapstructure:"checksum"`
+	// number of processes sharing the same exe and LD_PRELOAD
+	ProcessCount string `mapstructure:"process_count"`
+}
+
+// options of the schedule policy
+const (
+	hiddenOptionMaxPid   = "max_pid"
+	hiddenOptionScanRate = "scan_rate"
+	// the brute force of pid_max 4194304 takes more than an hour below it
+	minHiddenScanRate = 1024
+)
+
+// HiddenProcessHandler reports processes hidden from the readdir of /proc,
+// and libraries preloaded by /etc/ld.so.preload or LD_PRELOAD, which is how
+// userland rootkits hide processes in the first place.
+type HiddenProcessHandler struct {
+	// highest brute-forced pid and pids per second set by policy, 0 is the
+	// default one
+	maxPid   int64
+	scanRate int64
+}
+
+func (h *HiddenProcessHandler) CheckOptions(options map[string]string) error {
+	for k, v := range options {
+		if k != hiddenOptionMaxPid && k != hiddenOptionScanRate {
+			return fmt.Errorf("unknown option %s", k)
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if k == hiddenOptionMaxPid && n < 1 {
+			return fmt.Errorf("%s: %d is less than 1", k, n)
+		}
+		if k == hiddenOptionScanRate && n < minHiddenScanRate {
+			return fmt.Errorf("%s: %d is less than %d", k, n, minHiddenScanRate)
+		}
+	}
+	return nil
+}
+
+func (h *HiddenProcessHandler) SetOptions(options map[string]string) {
+	n, _ := strconv.Atoi(options[hiddenOptionMaxPid])
+	atomic.StoreInt64(&h.maxPid, int64(n))
+	n, _ = strconv.Atoi(options[hiddenOptionScanRate])
+	atomic.StoreInt64(&h.scanRate, int64(n))
+}
+
+func (h *HiddenProcessHandler) Name() string {
+	return "hidden_process"
+}
+func (h *HiddenProcessHandler) DataType() int {
+	return 5066
+}
+
+func fileMd5(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.LimitReader(f, maxVerifyFileSize)); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (h *HiddenProcessHandler) send(c *plugins.Client, a *ProcessAnomaly, seq string) {
+	rec := &plugins.Record{
+		DataType:  int32(h.DataType()),
+		Timestamp: time.Now().Unix(),
+		Data: &plugins.Payload{
+			Fields: make(map[string]string, 15),
+		},
+	}
+	mapstructure.Decode(a, &rec.Data.Fields)
+	rec.Data.Fields["package_seq"] = seq
+	c.SendRecord(rec)
+}
+
+func (h *HiddenProcessHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	hidden := process.HiddenProcesses(int(atomic.LoadInt64(&h.maxPid)), int(atomic.LoadInt64(&h.scanRate)))
+	for _, hp := range hidden {
+		a := &ProcessAnomaly{
+			Type:     anomalyHiddenProcess,
+			Severity: "high",
+			Pid:      hp.Pid,
+			FoundIn:  strings.Join(hp.FoundIn, ","),
+			Missing:  strings.Join(hp.Missing, ","),
+		}
+		// the pid may still be looked up directly
+		if p, err := process.NewProcess(hp.Pid); err == nil {
+			a.Exe, _ = p.Exe()
+			a.Comm, _ = p.Comm()
+			a.Cmdline, _ = p.Cmdline()
+		}
+		h.send(c, a, seq)
+	}
+	if libs, err := process.PreloadLibraries(); err == nil {
+		for _, lib := range libs {
+			h.send(c, &ProcessAnomaly{
+				Type:     anomalyLdSoPreload,
+				Severity: "high",
+				Library:  lib,
+				Checksum: fileMd5(lib),
+			}, seq)
+		}
+	}
+	procs, err := process.Processes(false)
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+	// exe-LD_PRELOAD -> processes
+	preloads := map[[2]string][]process.Process{}
+	for _, p := range procs {
+		time.Sleep(process.TraversalInterval)
+		envs, err := p.Envs()
+		if err != nil || strings.TrimSpace(envs["LD_PRELOAD"]) == "" {
+			continue
+		}
+		exe, _ := p.Exe()
+		k := [2]string{exe, envs["LD_PRELOAD"]}
+		preloads[k] = append(preloads[k], p)
+	}
+	for k, ps := range preloads {
+		for _, lib := range strings.FieldsFunc(k[1], func(r rune) bool { return r == ':' || r == ' ' }) {
+			a := &ProcessAnomaly{
+				Type:     anomalyLdPreloadEnv,
+				Severity: "high",
+				Pid:      ps[0].Pid(),
+				Exe:      k[0],
+				Library:  lib,
+				// relative to the root of the process, which may be a container
+				Checksum:     fileMd5(filepath.Join("/proc", ps[0].Pid(), "root", lib)),
+				ProcessCount: strconv.Itoa(len(ps)),
+			}
+			a.Comm, _ = ps[0].Comm()
+			a.Cmdline, _ = ps[0].Cmdline()
+			h.send(c, a, seq)
+		}
+	}
+}
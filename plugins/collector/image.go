@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/container"
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/rpm"
+	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+)
+
+// ImageSoftwareHandler reads package databases straight from the overlayfs
+// layers of each image, so workloads are never exec'ed into.
+type ImageSoftwareHandler struct {
+	// images are immutable, parsed results are kept by image id
+	packages map[string][]*Software
+}
+
+func (h *ImageSoftwareHandler) Name() string {
+	return "image_software"
+}
+func (h *ImageSoftwareHandler) DataType() int {
+	return 5064
+}
+
+func imagePackages(layers container.Layers) (ret []*Software) {
+	collect := func(s *Software) {
+		ret = append(ret, s)
+	}
+	if f, err := layers.Open("/var/lib/dpkg/status"); err == nil {
+		walkDpkgStatus(f, collect)
+		f.Close()
+	}
+	if f, err := layers.Open("/lib/apk/db/installed"); err == nil {
+		walkApkInstalled(f, collect)
+		f.Close()
+	}
+	for _, path := range rpm.DatabaseFiles {
+		f, err := layers.Open(path)
+		if err != nil {
+			continue
+		}
+		db, err := rpm.NewDatabase(f)
+		if err != nil {
+			f.Close()
+			continue
+		}
+		db.WalkPackages(func(p rpm.Package) {
+			collect(&Software{
+				Type:    "rpm",
+				Name:    p.Name,
+				Version: p.Version,
+				Source:  p.SourceRpm,
+				Vendor:  p.Vendor,
+			})
+		})
+		db.Close()
+		break
+	}
+	return
+}
+
+func (h *ImageSoftwareHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.handle(c, seq, nil)
+}
+
+// HandleFilter supports container_id, whose image is parsed again, and package names.
+func (h *ImageSoftwareHandler) HandleFilter(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) error {
+	if err := f.Check(engine.FilterScope{Container: "image_id", Package: "name"}); err != nil {
+		return err
+	}
+	h.handle(c, seq, f)
+	return nil
+}
+
+func (h *ImageSoftwareHandler) handle(c *plugins.Client, seq string, f *engine.Filter) {
+	if h.packages == nil {
+		h.packages = map[string][]*Software{}
+	}
+	seen := map[string]bool{}
+	for _, client := range container.NewClients() {
+		ctrs, err := client.ListContainers(context.Background())
+		if err != nil {
+			client.Close()
+			continue
+		}
+		for _, ctr := range ctrs {
+			if ctr.ImageID == "" || seen[ctr.ImageID] {
+				continue
+			}
+			if f != nil && !f.MatchContainer(ctr.ID) {
+				continue
+			}
+			pkgs, ok := h.packages[ctr.ImageID]
+			// the image of a filtered container is parsed again
+			if !ok || (f != nil && f.ContainerID != "") {
+				layers, err := client.ImageLayers(context.Background(), ctr)
+				if err != nil {
+					zap.S().Warnf("get image %s layers failed: %v", ctr.ImageID, err)
+					continue
+				}
+				pkgs = imagePackages(layers)
+				h.packages[ctr.ImageID] = pkgs
+			}
+			seen[ctr.ImageID] = true
+			var scope string
+			if f != nil {
+				s := engine.FilterScope{Package: "name"}
+				if f.ContainerID != "" {
+					s.Eq = map[string]string{"image_id": ctr.ImageID}
+				}
+				scope = f.Scope(s)
+			}
+			for _, s := range pkgs {
+				if f != nil && !f.MatchPackage(s.Name) {
+					continue
+				}
+				rec := &plugins.Record{
+					DataType:  int32(h.DataType()),
+					Timestamp: time.Now().Unix(),
+					Data: &plugins.Payload{
+						Fields: make(map[string]string, 14),
+					},
+				}
+				mapstructure.Decode(s, &rec.Data.Fields)
+				rec.Data.Fields["image_id"] = ctr.ImageID
+				rec.Data.Fields["image_name"] = ctr.ImageName
+				rec.Data.Fields["runtime"] = client.Runtime()
+				rec.Data.Fields["package_seq"] = seq
+				if f != nil {
+					f.Tag(rec.Data.Fields, scope)
+				}
+				c.SendRecord(rec)
+			}
+		}
+		client.Close()
+	}
+	if f != nil {
+		return
+	}
+	// drop images which are no longer used by any container
+	for id := range h.packages {
+		if !seen[id] {
+			delete(h.packages, id)
+		}
+	}
+}
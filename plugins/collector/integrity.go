@@ -7,29 +7,55 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bytedance/Elkeid/plugins/collector/engine"
 	"github.com/bytedance/Elkeid/plugins/collector/rpm"
+	"github.com/bytedance/Elkeid/plugins/collector/utils"
 	plugins "github.com/bytedance/plugins"
 	"github.com/juju/ratelimit"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
 )
 
-const maxVerifyFileSize = 100 * 1024 * 1024
+const (
+	maxVerifyFileSize     = 100 * 1024 * 1024
+	integrityConfigPath   = "integrity.json"
+	integrityBaselinePath = "integrity_baseline.json"
+	dpkgStatOverridePath  = "/var/lib/dpkg/statoverride"
+)
 
-type IntegrityHandler struct{}
+// drift types
+const (
+	driftDigest = "digest"
+	driftMode   = "mode"
+	driftOwner  = "owner"
+	// a verified file is deleted
+	driftMissing = "missing"
+)
 
-func (h *IntegrityHandler) Name() string {
-	return "integrity"
-}
-func (h *IntegrityHandler) DataType() int {
-	return 5057
+const (
+	integrityDrifted  = "drifted"
+	integrityRestored = "restored"
+)
+
+// path scopes which can be enabled by IntegrityConfig
+var integrityScopes = map[string]func(path string) bool{
+	"bin":   isBin,
+	"lib":   isLib,
+	"pam":   isPam,
+	"ld_so": isLdSo,
 }
 
 func isBin(path string) bool {
@@ -40,158 +66,620 @@ func isBin(path string) bool {
 			d == "/usr/local/bin" || d == "/usr/local/sbin")
 }
 
-func (i *IntegrityHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
-	// get dpkg's info
-	if db, err := os.Open("/var/lib/dpkg/status"); err == nil {
-		// name-version mapping
-		nvm := map[string]string{}
-		s := bufio.NewScanner(io.LimitReader(db, 25*1024*1024))
-		s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-			if atEOF && len(data) == 0 {
-				return 0, nil, nil
+func isLibDir(path string) bool {
+	for _, prefix := range []string{"/lib", "/usr/lib", "/usr/local/lib"} {
+		if rest := strings.TrimPrefix(path, prefix); rest != path {
+			switch {
+			case strings.HasPrefix(rest, "/"), strings.HasPrefix(rest, "32/"),
+				strings.HasPrefix(rest, "64/"), strings.HasPrefix(rest, "x32/"):
+				return true
 			}
-			if i := strings.Index(string(data), "\nPackage: "); i >= 0 {
-				return i + 1, data[0:i], nil
+		}
+	}
+	return false
+}
+
+func isSharedObject(name string) bool {
+	return strings.HasSuffix(name, ".so") || strings.Contains(name, ".so.")
+}
+
+func isLib(path string) bool {
+	return isLibDir(path) && isSharedObject(filepath.Base(path))
+}
+
+// pam modules, e.g. /lib/x86_64-linux-gnu/security/pam_unix.so, and their configs
+func isPam(path string) bool {
+	return (isLibDir(path) && filepath.Base(filepath.Dir(path)) == "security" && isSharedObject(filepath.Base(path))) ||
+		strings.HasPrefix(path, "/etc/pam.d/")
+}
+
+// ld.so.conf, ld.so.conf.d/* and ld.so.preload
+func isLdSo(path string) bool {
+	return strings.HasPrefix(path, "/etc/ld.so.")
+}
+
+// IntegrityConfig is pushed by the manager as the data of a 5057 task, and
+// persisted so it survives plugin restarts.
+type IntegrityConfig struct {
+	// built-in scopes: bin, lib, pam and ld_so
+	Scopes []string `json:"scopes"`
+	// extra glob patterns of filepath.Match, e.g. /opt/app/bin/*
+	Paths []string `json:"paths"`
+}
+
+var defaultIntegrityConfig = IntegrityConfig{Scopes: []string{"bin", "lib", "pam", "ld_so"}}
+
+func (cfg *IntegrityConfig) validate() error {
+	for _, s := range cfg.Scopes {
+		if _, ok := integrityScopes[s]; !ok {
+			return fmt.Errorf("unknown integrity scope %q", s)
+		}
+	}
+	for _, p := range cfg.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("integrity path %q isn't absolute", p)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid integrity path %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *IntegrityConfig) match(path string) bool {
+	for _, s := range cfg.Scopes {
+		if integrityScopes[s](path) {
+			return true
+		}
+	}
+	for _, p := range cfg.Paths {
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type Integrity struct {
+	SoftwareName    string `mapstructure:"software_name"`
+	SoftwareVersion string `mapstructure:"software_version"`
+	Exe             string `mapstructure:"exe"`
+	Digest          string `mapstructure:"digest"`
+	OriginDigest    string `mapstructure:"origin_digest"`
+	DigestAlgorithm string `mapstructure:"digest_algorithm"`
+	ModifyTime      string `mapstructure:"modify_time"`
+	Mode            string `mapstructure:"mode"`
+	OriginMode      string `mapstructure:"origin_mode"`
+	Owner           string `mapstructure:"owner"`
+	OriginOwner     string `mapstructure:"origin_owner"`
+	// marked as a config file by the package, which is expected to be edited
+	Config string `mapstructure:"config"`
+	// digest, mode and owner joined by commas
+	DriftType string `mapstructure:"drift_type"`
+	// drifted, or restored if a reported drift is gone
+	Status string `mapstructure:"status"`
+}
+
+// a file owned by a package along with what the package database expects
+type packageFile struct {
+	software string
+	version  string
+	path     string
+	digest   string
+	algo     rpm.DigestAlgorithm
+	// permission bits, checked if set
+	mode uint32
+	// dpkg doesn't record modes, only world-writable files are drifted then
+	noWorldWritable bool
+	user            string
+	// empty if the group isn't checked
+	group  string
+	config bool
+}
+
+// integrityEntry is the baseline of a verified file, files whose stat is
+// unchanged aren't hashed again.
+type integrityEntry struct {
+	Ino    uint64 `json:"ino"`
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+	Ctime  int64  `json:"ctime"`
+	Origin string `json:"origin"`
+	Digest string `json:"digest"`
+	Drift  string `json:"drift,omitempty"`
+	// kept to report the restored record
+	Software string `json:"software,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+// IntegrityHandler verifies files owned by dpkg or rpm packages against the
+// digests, modes and owners of the package database. Results are kept in a
+// local baseline, so only newly drifted (and restored) files are reported.
+type IntegrityHandler struct {
+	once sync.Once
+	mu   sync.Mutex
+	cfg  IntegrityConfig
+}
+
+func (h *IntegrityHandler) Name() string {
+	return "integrity"
+}
+func (h *IntegrityHandler) DataType() int {
+	return 5057
+}
+
+func (h *IntegrityHandler) Configure(data string) (err error) {
+	cfg := IntegrityConfig{}
+	if err = json.Unmarshal([]byte(data), &cfg); err != nil {
+		return
+	}
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	if len(cfg.Scopes) == 0 && len(cfg.Paths) == 0 {
+		cfg = defaultIntegrityConfig
+	}
+	h.load()
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+	return os.WriteFile(integrityConfigPath, []byte(data), 0600)
+}
+
+func (h *IntegrityHandler) load() {
+	h.once.Do(func() {
+		h.cfg = defaultIntegrityConfig
+		if data, err := os.ReadFile(integrityConfigPath); err == nil {
+			cfg := IntegrityConfig{}
+			if err = json.Unmarshal(data, &cfg); err == nil {
+				err = cfg.validate()
 			}
-			if atEOF {
-				return len(data), data, nil
+			if err != nil {
+				zap.S().Warn("invalid integrity config: ", err.Error())
+			} else if len(cfg.Scopes) != 0 || len(cfg.Paths) != 0 {
+				h.cfg = cfg
 			}
-			return
-		})
-		for s.Scan() {
-			lines := strings.Split(s.Text(), "\n")
-			var n, v string
-			for _, line := range lines {
-				fields := strings.SplitN(line, ": ", 2)
-				if len(fields) != 2 {
-					continue
-				}
-				switch fields[0] {
-				case "Package":
-					n = fields[1]
-				case "Version":
-					v = fields[1]
-				}
-				if n != "" && v != "" {
-					break
+		}
+	})
+}
+
+func parseDpkgStatus(r io.Reader, f func(name, version string, conffiles map[string]string)) {
+	s := bufio.NewScanner(io.LimitReader(r, 25*1024*1024))
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := strings.Index(string(data), "\nPackage: "); i >= 0 {
+			return i + 1, data[0:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return
+	})
+	for s.Scan() {
+		var n, v string
+		conffiles := map[string]string{}
+		inConffiles := false
+		for _, line := range strings.Split(s.Text(), "\n") {
+			// continuation lines of Conffiles: " /etc/foo md5 [obsolete]"
+			if strings.HasPrefix(line, " ") {
+				if fields := strings.Fields(line); inConffiles && len(fields) >= 2 &&
+					filepath.IsAbs(fields[0]) && len(fields[1]) == md5.Size*2 {
+					conffiles[fields[0]] = fields[1]
 				}
+				continue
+			}
+			inConffiles = false
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) != 2 {
+				continue
 			}
-			if n != "" && v != "" {
-				nvm[n] = v
+			switch fields[0] {
+			case "Package":
+				n = strings.TrimSpace(fields[1])
+			case "Version":
+				v = strings.TrimSpace(fields[1])
+			case "Conffiles":
+				inConffiles = true
 			}
 		}
-		db.Close()
-		// verify
-		if entries, err := os.ReadDir("/var/lib/dpkg/info"); err == nil {
-			// paths
-			ps := []string{}
-			for _, entry := range entries {
-				if filepath.Ext(entry.Name()) == ".md5sums" {
-					ps = append(ps, filepath.Join("/var/lib/dpkg/info", entry.Name()))
-				}
+		if n != "" && v != "" {
+			f(n, v, conffiles)
+		}
+	}
+}
+
+type statOverride struct {
+	user  string
+	group string
+	mode  uint32
+}
+
+// dpkg-statoverride entries: "user group mode path"
+func dpkgStatOverrides(path string) map[string]statOverride {
+	ret := map[string]statOverride{}
+	f, err := os.Open(path)
+	if err != nil {
+		return ret
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			continue
+		}
+		ret[fields[3]] = statOverride{
+			user:  strings.TrimPrefix(fields[0], "#"),
+			group: strings.TrimPrefix(fields[1], "#"),
+			mode:  uint32(mode),
+		}
+	}
+	return ret
+}
+
+func dpkgFiles(cfg *IntegrityConfig, f func(pf *packageFile)) error {
+	db, err := os.Open("/var/lib/dpkg/status")
+	if err != nil {
+		return err
+	}
+	type pkg struct {
+		version   string
+		conffiles map[string]string
+	}
+	pkgs := map[string]pkg{}
+	parseDpkgStatus(db, func(name, version string, conffiles map[string]string) {
+		pkgs[name] = pkg{version, conffiles}
+	})
+	db.Close()
+	overrides := dpkgStatOverrides(dpkgStatOverridePath)
+	emit := func(name, version, path, digest string, config bool) {
+		if !cfg.match(path) {
+			return
+		}
+		pf := &packageFile{
+			software:        name,
+			version:         version,
+			path:            path,
+			digest:          digest,
+			algo:            rpm.PGPHASHALGO_MD5,
+			noWorldWritable: true,
+			user:            "root",
+			config:          config,
+		}
+		if o, ok := overrides[path]; ok {
+			pf.mode = o.mode
+			pf.noWorldWritable = false
+			pf.user = o.user
+			pf.group = o.group
+		}
+		f(pf)
+	}
+	entries, err := os.ReadDir("/var/lib/dpkg/info")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".md5sums" {
+			continue
+		}
+		// multi-arch packages are named as name:arch
+		pn := strings.SplitN(strings.TrimSuffix(entry.Name(), ".md5sums"), ":", 2)[0]
+		p, ok := pkgs[pn]
+		if !ok {
+			continue
+		}
+		mf, err := os.Open(filepath.Join("/var/lib/dpkg/info", entry.Name()))
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(mf)
+		for s.Scan() {
+			fds := strings.Fields(s.Text())
+			if len(fds) == 2 {
+				emit(pn, p.version, filepath.Join("/", fds[1]), fds[0], false)
 			}
-			h := md5.New()
-			for _, p := range ps {
-				pn := strings.TrimSuffix(filepath.Base(p), ".md5sums")
-				if v, ok := nvm[pn]; ok {
-					fs := map[string]string{}
-					if f, err := os.Open(p); err == nil {
-						s := bufio.NewScanner(f)
-						for s.Scan() {
-							fds := strings.Fields(s.Text())
-							if len(fds) == 2 {
-								p := filepath.Join("/", fds[1])
-								if isBin(p) {
-									fs[p] = fds[0]
-								}
-							}
-						}
-						f.Close()
-						for path, odigest := range fs {
-							if f, err := os.Open(path); err == nil {
-								if st, err := f.Stat(); err == nil && st.Size() < maxVerifyFileSize {
-									r := ratelimit.Reader(f, ratelimit.NewBucketWithRate(1024*1024, 1024*1024))
-									if _, err = io.Copy(h, r); err == nil {
-										digest := hex.EncodeToString(h.Sum(nil))
-										h.Reset()
-										if digest != odigest {
-											c.SendRecord(&plugins.Record{
-												DataType:  int32(i.DataType()),
-												Timestamp: time.Now().Unix(),
-												Data: &plugins.Payload{
-													Fields: map[string]string{
-														"software_name":    pn,
-														"digest":           digest,
-														"origin_digest":    odigest,
-														"digest_algorithm": "md5",
-														"exe":              path,
-														"modify_time":      strconv.FormatInt(st.ModTime().Unix(), 10),
-														"software_version": v,
-														"package_seq":      seq,
-													},
-												},
-											})
-											cache.Put(i.DataType(), path, nil)
-										}
-									}
-								}
-								f.Close()
-							}
-						}
-					}
-				}
+		}
+		mf.Close()
+	}
+	// digests of conffiles are kept in the status instead of md5sums
+	for pn, p := range pkgs {
+		for path, digest := range p.conffiles {
+			emit(pn, p.version, path, digest, true)
+		}
+	}
+	return nil
+}
+
+func rpmFiles(cfg *IntegrityConfig, f func(pf *packageFile)) error {
+	db, err := rpm.OpenDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.WalkPackages(func(p rpm.Package) {
+		for _, file := range p.Files {
+			// directories, symlinks and ghosts have no digest
+			if file.Digest == "" || file.IsGhost() || !cfg.match(file.Path) {
+				continue
+			}
+			f(&packageFile{
+				software: p.Name,
+				version:  p.Version,
+				path:     file.Path,
+				digest:   file.Digest,
+				algo:     p.DigestAlgorithm,
+				mode:     uint32(file.Mode) & 07777,
+				user:     file.User,
+				group:    file.Group,
+				config:   file.IsConfig(),
+			})
+		}
+	})
+}
+
+func newHash(algo rpm.DigestAlgorithm) hash.Hash {
+	switch algo {
+	case rpm.PGPHASHALGO_MD5:
+		return md5.New()
+	case rpm.PGPHASHALGO_SHA1:
+		return sha1.New()
+	case rpm.PGPHASHALGO_SHA256:
+		return sha256.New()
+	case rpm.PGPHASHALGO_SHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+func loadIntegrityBaseline() map[string]*integrityEntry {
+	ret := map[string]*integrityEntry{}
+	if data, err := os.ReadFile(integrityBaselinePath); err == nil {
+		if err = json.Unmarshal(data, &ret); err != nil {
+			zap.S().Warn("invalid integrity baseline: ", err.Error())
+			return map[string]*integrityEntry{}
+		}
+	}
+	return ret
+}
+
+// verify checks a package file, the digest of prev is reused if the file is unchanged
+func verify(pf *packageFile, prev *integrityEntry) (*integrityEntry, *Integrity, error) {
+	h := newHash(pf.algo)
+	if h == nil {
+		return nil, nil, errors.New("unsupported digest algorithm")
+	}
+	f, err := os.Open(pf.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !fi.Mode().IsRegular() {
+		return nil, nil, errors.New("not a regular file")
+	}
+	e := &integrityEntry{
+		Ino:      st.Ino,
+		Size:     st.Size,
+		Mtime:    st.Mtim.Nano(),
+		Ctime:    st.Ctim.Nano(),
+		Origin:   pf.digest,
+		Software: pf.software,
+		Version:  pf.version,
+	}
+	if prev != nil && prev.Ino == e.Ino && prev.Size == e.Size && prev.Mtime == e.Mtime &&
+		prev.Ctime == e.Ctime && prev.Origin == e.Origin {
+		e.Digest = prev.Digest
+	} else if st.Size < maxVerifyFileSize {
+		r := ratelimit.Reader(f, ratelimit.NewBucketWithRate(1024*1024, 1024*1024))
+		if _, err = io.Copy(h, r); err != nil {
+			return nil, nil, err
+		}
+		e.Digest = hex.EncodeToString(h.Sum(nil))
+	}
+	i := &Integrity{
+		SoftwareName:    pf.software,
+		SoftwareVersion: pf.version,
+		Exe:             pf.path,
+		Digest:          e.Digest,
+		OriginDigest:    pf.digest,
+		DigestAlgorithm: pf.algo.String(),
+		ModifyTime:      strconv.FormatInt(fi.ModTime().Unix(), 10),
+		Mode:            fmt.Sprintf("%04o", st.Mode&07777),
+		Owner:           strconv.FormatUint(uint64(st.Uid), 10) + ":" + strconv.FormatUint(uint64(st.Gid), 10),
+		Config:          strconv.FormatBool(pf.config),
+	}
+	user, _ := utils.GetUsername(strconv.FormatUint(uint64(st.Uid), 10))
+	group, _ := utils.GetGroupname(strconv.FormatUint(uint64(st.Gid), 10))
+	if user != "" && group != "" {
+		i.Owner = user + ":" + group
+	}
+	drifts := []string{}
+	// files too large to hash are left unverified
+	if e.Digest != "" && e.Digest != pf.digest {
+		drifts = append(drifts, driftDigest)
+	}
+	if pf.mode != 0 {
+		i.OriginMode = fmt.Sprintf("%04o", pf.mode)
+		if st.Mode&07777 != pf.mode {
+			drifts = append(drifts, driftMode)
+		}
+	} else if pf.noWorldWritable && st.Mode&0002 != 0 {
+		drifts = append(drifts, driftMode)
+	}
+	if pf.user != "" {
+		i.OriginOwner = pf.user
+		if pf.group != "" {
+			i.OriginOwner += ":" + pf.group
+		}
+		if user != pf.user || (pf.group != "" && group != pf.group) {
+			drifts = append(drifts, driftOwner)
+		}
+	}
+	e.Drift = strings.Join(drifts, ",")
+	i.DriftType = e.Drift
+	return e, i, nil
+}
+
+func (h *IntegrityHandler) send(c *plugins.Client, i *Integrity, seq string) {
+	rec := &plugins.Record{
+		DataType:  int32(h.DataType()),
+		Timestamp: time.Now().Unix(),
+		Data: &plugins.Payload{
+			Fields: make(map[string]string, 16),
+		},
+	}
+	mapstructure.Decode(i, &rec.Data.Fields)
+	rec.Data.Fields["package_seq"] = seq
+	c.SendRecord(rec)
+}
+
+func (h *IntegrityHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.handle(c, cache, seq, nil)
+}
+
+// HandleFilter verifies the files under a path or of a package within the
+// configured scopes, their current drifts are all reported.
+func (h *IntegrityHandler) HandleFilter(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) error {
+	if err := f.Check(engine.FilterScope{Path: "exe", Package: "software_name"}); err != nil {
+		return err
+	}
+	h.handle(c, cache, seq, f)
+	return nil
+}
+
+func (h *IntegrityHandler) handle(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) {
+	h.load()
+	h.mu.Lock()
+	cfg := h.cfg
+	h.mu.Unlock()
+	var match func(path, software string) bool
+	if f != nil {
+		match = func(path, software string) bool {
+			return f.MatchPath(path) && f.MatchPackage(software)
+		}
+	}
+	walk := func(cfg *IntegrityConfig, check func(pf *packageFile)) error {
+		err := dpkgFiles(cfg, check)
+		if errors.Is(err, os.ErrNotExist) {
+			err = rpmFiles(cfg, check)
+		}
+		return err
+	}
+	baseline, err := verifyFiles(&cfg, walk, loadIntegrityBaseline(), match, func(i *Integrity) {
+		h.send(c, i, seq)
+	})
+	if err != nil {
+		zap.S().Warn("integrity: ", err.Error())
+		return
+	}
+	for path, e := range baseline {
+		if e.Drift != "" {
+			cache.Put(h.DataType(), path, nil)
+		}
+	}
+	data, err := json.Marshal(baseline)
+	if err == nil {
+		err = os.WriteFile(integrityBaselinePath, data, 0600)
+	}
+	if err != nil {
+		zap.S().Warn("failed to save integrity baseline: ", err.Error())
+	}
+}
+
+// verifyFiles verifies the files walked against the baseline prev, and returns
+// the new one. A nil match is a full run which reports the drifts which are new
+// or changed since prev, a filtered run reports all of the drifts it matches
+// and keeps the baseline of the other files.
+func verifyFiles(cfg *IntegrityConfig, walk func(*IntegrityConfig, func(*packageFile)) error,
+	prev map[string]*integrityEntry, match func(path, software string) bool, report func(*Integrity)) (map[string]*integrityEntry, error) {
+	filtered := match != nil
+	if !filtered {
+		match = func(string, string) bool { return true }
+	}
+	baseline := map[string]*integrityEntry{}
+	if filtered {
+		for path, e := range prev {
+			if !match(path, e.Software) {
+				baseline[path] = e
 			}
 		}
-		// try rpm
-	} else if db, err := rpm.OpenDatabase(); err == nil {
-		db.WalkPackages(func(p rpm.Package) {
-			var h hash.Hash
-			switch p.DigestAlgorithm {
-			case rpm.PGPHASHALGO_MD5:
-				h = md5.New()
-			case rpm.PGPHASHALGO_SHA1:
-				h = sha1.New()
-			case rpm.PGPHASHALGO_SHA256:
-				h = sha256.New()
-			case rpm.PGPHASHALGO_SHA512:
-				h = sha512.New()
-			default:
+	}
+	check := func(pf *packageFile) {
+		if !match(pf.path, pf.software) {
+			return
+		}
+		// a path may be shipped by several packages, e.g. multi-arch ones
+		if _, ok := baseline[pf.path]; ok {
+			return
+		}
+		p, verified := prev[pf.path]
+		e, i, err := verify(pf, p)
+		if err != nil {
+			// files never verified may be excluded from installation, e.g. by
+			// dpkg path-exclude, so only the deletion of a verified one is a drift
+			if !verified {
 				return
 			}
-			for _, f := range p.Files {
-				if isBin(f.Path) && f.Digest != "" {
-					if af, err := os.Open(f.Path); err == nil {
-						if st, err := af.Stat(); err == nil && st.Size() < maxVerifyFileSize {
-							r := ratelimit.Reader(af, ratelimit.NewBucketWithRate(1024*1024, 1024*1024))
-							h.Reset()
-							if _, err = io.Copy(h, r); err == nil {
-								digest := hex.EncodeToString(h.Sum(nil))
-								if digest != f.Digest {
-									c.SendRecord(&plugins.Record{
-										DataType:  int32(i.DataType()),
-										Timestamp: time.Now().Unix(),
-										Data: &plugins.Payload{
-											Fields: map[string]string{
-												"software_name":    p.Name,
-												"digest":           digest,
-												"origin_digest":    f.Digest,
-												"digest_algorithm": p.DigestAlgorithm.String(),
-												"exe":              f.Path,
-												"modify_time":      strconv.FormatInt(st.ModTime().Unix(), 10),
-												"software_version": p.Version,
-												"package_seq":      seq,
-											},
-										},
-									})
-									cache.Put(i.DataType(), f.Path, nil)
-								}
-							}
-						}
-						af.Close()
-					}
-				}
+			if !errors.Is(err, os.ErrNotExist) {
+				// unreadable for now, the last result is kept
+				baseline[pf.path] = p
+				return
 			}
-		})
-		db.Close()
+			e, i = missing(pf)
+		}
+		baseline[pf.path] = e
+		if e.Drift == "" {
+			return
+		}
+		// only drifts which are new or changed since the last run are reported
+		if filtered || !verified || p.Drift != e.Drift || p.Digest != e.Digest {
+			i.Status = integrityDrifted
+			report(i)
+		}
+	}
+	if err := walk(cfg, check); err != nil {
+		return nil, err
+	}
+	for path, p := range prev {
+		if p.Drift == "" || !match(path, p.Software) {
+			continue
+		}
+		if e, ok := baseline[path]; !ok || e.Drift == "" {
+			report(&Integrity{
+				SoftwareName:    p.Software,
+				SoftwareVersion: p.Version,
+				Exe:             path,
+				Status:          integrityRestored,
+			})
+		}
+	}
+	return baseline, nil
+}
+
+func missing(pf *packageFile) (*integrityEntry, *Integrity) {
+	return &integrityEntry{
+		Origin:   pf.digest,
+		Drift:    driftMissing,
+		Software: pf.software,
+		Version:  pf.version,
+	}, &Integrity{
+		SoftwareName:    pf.software,
+		SoftwareVersion: pf.version,
+		Exe:             pf.path,
+		OriginDigest:    pf.digest,
+		DigestAlgorithm: pf.algo.String(),
+		Config:          strconv.FormatBool(pf.config),
+		DriftType:       driftMissing,
 	}
 }
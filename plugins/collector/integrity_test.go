@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/Elkeid/plugins/collector/rpm"
+)
+
+func md5sum(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseDpkgStatus(t *testing.T) {
+	status := `Package: base-files
+Status: install ok installed
+Version: 12ubuntu4
+Conffiles:
+ /etc/debian_version 6b1d7e2a1b5f4c3e2d1c0b9a8f7e6d5c
+ /etc/dpkg/origins/debian 731423fa8ba067262f8ef37882d1e742 obsolete
+ /etc/broken short
+Description: Debian base system miscellaneous files
+ This package contains the basic filesystem hierarchy.
+
+Package: coreutils
+Status: install ok installed
+Version: 8.32-4.1ubuntu1
+
+Package: no-version
+Status: deinstall ok config-files
+
+Package: libpam-runtime
+Version: 1.4.0-11ubuntu2
+Conffiles:
+ /etc/pam.d/common-auth 8a1b2c3d4e5f60718293a4b5c6d7e8f9
+Description: Runtime support for the PAM library
+ /etc/pam.d/not-a-conffile 0123456789abcdef0123456789abcdef
+`
+	type pkg struct {
+		version   string
+		conffiles map[string]string
+	}
+	got := map[string]pkg{}
+	parseDpkgStatus(strings.NewReader(status), func(name, version string, conffiles map[string]string) {
+		got[name] = pkg{version, conffiles}
+	})
+	want := map[string]pkg{
+		"base-files": {"12ubuntu4", map[string]string{
+			"/etc/debian_version":      "6b1d7e2a1b5f4c3e2d1c0b9a8f7e6d5c",
+			"/etc/dpkg/origins/debian": "731423fa8ba067262f8ef37882d1e742",
+		}},
+		"coreutils": {"8.32-4.1ubuntu1", map[string]string{}},
+		"libpam-runtime": {"1.4.0-11ubuntu2", map[string]string{
+			"/etc/pam.d/common-auth": "8a1b2c3d4e5f60718293a4b5c6d7e8f9",
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDpkgStatus() = %v, want %v", got, want)
+	}
+}
+
+func TestDpkgStatOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statoverride")
+	data := `root crontab 2755 /usr/bin/crontab
+#0 #42 4750 /usr/lib/dbus-1.0/dbus-daemon-launch-helper
+root root 0644
+root root 9999 /usr/bin/bad
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]statOverride{
+		"/usr/bin/crontab": {user: "root", group: "crontab", mode: 02755},
+		"/usr/lib/dbus-1.0/dbus-daemon-launch-helper": {user: "0", group: "42", mode: 04750},
+	}
+	if got := dpkgStatOverrides(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("dpkgStatOverrides() = %v, want %v", got, want)
+	}
+	if got := dpkgStatOverrides(path + ".missing"); len(got) != 0 {
+		t.Errorf("dpkgStatOverrides() of a missing file = %v", got)
+	}
+}
+
+func writeFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ls")
+	writeFile(t, path, "origin", 0755)
+	pf := func() *packageFile {
+		return &packageFile{software: "coreutils", version: "8.32", path: path, digest: md5sum("origin"), algo: rpm.PGPHASHALGO_MD5}
+	}
+	tests := []struct {
+		name    string
+		pf      func() *packageFile
+		setup   func()
+		drift   string
+		wantErr error
+	}{
+		{name: "clean", pf: pf},
+		{name: "digest", pf: pf, setup: func() { writeFile(t, path, "changed", 0755) }, drift: driftDigest},
+		{name: "mode", pf: func() *packageFile {
+			p := pf()
+			p.mode = 0755
+			return p
+		}, setup: func() { writeFile(t, path, "origin", 0700) }, drift: driftMode},
+		{name: "world writable", pf: func() *packageFile {
+			p := pf()
+			p.noWorldWritable = true
+			return p
+		}, setup: func() { writeFile(t, path, "changed", 0777) }, drift: driftDigest + "," + driftMode},
+		{name: "missing", pf: pf, setup: func() { os.Remove(path) }, wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		writeFile(t, path, "origin", 0755)
+		if tt.setup != nil {
+			tt.setup()
+		}
+		e, i, err := verify(tt.pf(), nil)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: verify() error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if e.Drift != tt.drift || i.DriftType != tt.drift {
+			t.Errorf("%s: drift %q, want %q", tt.name, e.Drift, tt.drift)
+		}
+	}
+
+	// the digest of an unchanged file isn't computed again
+	writeFile(t, path, "origin", 0755)
+	e, _, err := verify(pf(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := *e
+	prev.Digest = "cached"
+	if e, _, err = verify(pf(), &prev); err != nil || e.Digest != "cached" {
+		t.Errorf("verify() of an unchanged file digest %s, error %v", e.Digest, err)
+	}
+	p := pf()
+	p.algo = 0
+	if _, _, err = verify(p, nil); err == nil {
+		t.Error("verify() of an unsupported algorithm, want error")
+	}
+}
+
+func TestVerifyFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "a", 0755)
+	writeFile(t, b, "drifted", 0755)
+	walk := func(cfg *IntegrityConfig, check func(*packageFile)) error {
+		for _, p := range []string{a, b} {
+			check(&packageFile{software: "pkg-" + filepath.Base(p), version: "1", path: p, digest: md5sum(filepath.Base(p)), algo: rpm.PGPHASHALGO_MD5})
+		}
+		return nil
+	}
+	baseline := map[string]*integrityEntry{}
+	run := func(match func(string, string) bool) []string {
+		var reports []string
+		ret, err := verifyFiles(&defaultIntegrityConfig, walk, baseline, match, func(i *Integrity) {
+			reports = append(reports, filepath.Base(i.Exe)+":"+i.Status+":"+i.DriftType)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseline = ret
+		return reports
+	}
+	steps := []struct {
+		name   string
+		change func()
+		match  func(string, string) bool
+		want   []string
+	}{
+		{name: "first run", want: []string{"b:drifted:digest"}},
+		{name: "unchanged"},
+		{name: "a drifts", change: func() { writeFile(t, a, "changed", 0755) }, want: []string{"a:drifted:digest"}},
+		{name: "drifted b is deleted", change: func() { os.Remove(b) }, want: []string{"b:drifted:missing"}},
+		{name: "b is still missing"},
+		{name: "a is restored", change: func() { writeFile(t, a, "a", 0755) }, want: []string{"a:restored:"}},
+		{name: "filtered run reports all of its drifts", match: func(path, _ string) bool { return path == b }, want: []string{"b:drifted:missing"}},
+		{name: "b is restored", change: func() { writeFile(t, b, "b", 0755) }, want: []string{"b:restored:"}},
+	}
+	for _, s := range steps {
+		if s.change != nil {
+			s.change()
+		}
+		if got := run(s.match); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: reports %v, want %v", s.name, got, s.want)
+		}
+	}
+
+	// an unreadable file keeps its last result instead of being restored
+	writeFile(t, b, "drifted", 0755)
+	run(nil)
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(b, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(nil); len(got) != 0 {
+		t.Errorf("unreadable file: reports %v, want none", got)
+	}
+	if e := baseline[b]; e == nil || e.Drift != driftDigest {
+		t.Errorf("unreadable file: baseline %+v, want the last result", e)
+	}
+}
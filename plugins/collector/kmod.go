@@ -2,15 +2,69 @@ package main
 
 import (
 	"bufio"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/kmod"
+	"github.com/bytedance/Elkeid/plugins/collector/rpm"
 	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
 )
 
-type KmodHandler struct{}
+type Kmod struct {
+	Name     string `mapstructure:"name"`
+	Size     string `mapstructure:"size"`
+	Refcount string `mapstructure:"refcount"`
+	UsedBy   string `mapstructure:"used_by"`
+	State    string `mapstructure:"state"`
+	Addr     string `mapstructure:"addr"`
+	Path     string `mapstructure:"path"`
+	Vermagic string `mapstructure:"vermagic"`
+	// srcversion and version are exported by sysfs
+	SrcVersion string `mapstructure:"srcversion"`
+	Version    string `mapstructure:"version"`
+	Taint      string `mapstructure:"taint"`
+	// signed, unsigned or unknown
+	Signature      string `mapstructure:"signature"`
+	Signer         string `mapstructure:"signer"`
+	SigKey         string `mapstructure:"sig_key"`
+	SigHashAlgo    string `mapstructure:"sig_hashalgo"`
+	PackageName    string `mapstructure:"package_name"`
+	PackageVersion string `mapstructure:"package_version"`
+	// the views the module is missing from: procfs, sysfs
+	Hidden     string `mapstructure:"hidden"`
+	Risk       string `mapstructure:"risk"`
+	RiskReason string `mapstructure:"risk_reason"`
+}
+
+// parsed from the .ko file, which doesn't change unless reinstalled
+type kmodFile struct {
+	modTime  time.Time
+	vermagic string
+	sig      *kmod.Signature
+	signed   bool
+	parsed   bool
+}
+
+// sysfs initstate to the state column of /proc/modules
+var initStates = map[string]string{
+	"live":   "Live",
+	"coming": "Loading",
+	"going":  "Unloading",
+}
+
+type kmodPackage struct {
+	name    string
+	version string
+}
+
+type KmodHandler struct {
+	files map[string]*kmodFile
+}
 
 func (*KmodHandler) Name() string {
 	return "kmod"
@@ -18,32 +72,306 @@ func (*KmodHandler) Name() string {
 func (*KmodHandler) DataType() int {
 	return 5062
 }
-func (h *KmodHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+
+// /usr/lib/modules and /lib/modules are the same after usrmerge
+func normalizeModulePath(path string) string {
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path, "/usr/lib/modules/") {
+		return strings.TrimPrefix(path, "/usr")
+	}
+	return path
+}
+
+// module names use underscores, while file names may use dashes
+func moduleName(path string) string {
+	base := filepath.Base(path)
+	if i := strings.Index(base, ".ko"); i >= 0 {
+		base = base[:i]
+	}
+	return strings.ReplaceAll(base, "-", "_")
+}
+
+func readModuleFile(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// modulePaths maps module names to their .ko paths from modules.dep of the running kernel
+func modulePaths() map[string]string {
+	ret := map[string]string{}
+	release := readModuleFile("/proc/sys/kernel/osrelease")
+	if release == "" {
+		return ret
+	}
+	dir := filepath.Join("/lib/modules", release)
+	f, err := os.Open(filepath.Join(dir, "modules.dep"))
+	if err != nil {
+		return ret
+	}
+	defer f.Close()
+	s := bufio.NewScanner(io.LimitReader(f, 16*1024*1024))
+	for s.Scan() {
+		path, _, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, ok := ret[moduleName(path)]; !ok {
+			ret[moduleName(path)] = path
+		}
+	}
+	return ret
+}
+
+// modulePackages maps normalized .ko paths to the owning dpkg or rpm package
+func modulePackages() map[string]kmodPackage {
+	ret := map[string]kmodPackage{}
+	isModule := func(path string) bool {
+		return strings.Contains(path, "/lib/modules/") && strings.Contains(filepath.Base(path), ".ko")
+	}
+	if f, err := os.Open("/var/lib/dpkg/status"); err == nil {
+		versions := map[string]string{}
+		walkDpkgStatus(f, func(s *Software) {
+			versions[s.Name] = s.Version
+		})
+		f.Close()
+		lists, _ := filepath.Glob("/var/lib/dpkg/info/*.list")
+		for _, list := range lists {
+			name := strings.TrimSuffix(filepath.Base(list), ".list")
+			// multi-arch packages, e.g. libc6:amd64.list
+			name, _, _ = strings.Cut(name, ":")
+			f, err := os.Open(list)
+			if err != nil {
+				continue
+			}
+			s := bufio.NewScanner(io.LimitReader(f, 16*1024*1024))
+			for s.Scan() {
+				if path := s.Text(); isModule(path) {
+					ret[normalizeModulePath(path)] = kmodPackage{name, versions[name]}
+				}
+			}
+			f.Close()
+		}
+	} else if db, err := rpm.OpenDatabase(); err == nil {
+		db.WalkPackages(func(p rpm.Package) {
+			for _, f := range p.Files {
+				if isModule(f.Path) {
+					ret[normalizeModulePath(f.Path)] = kmodPackage{p.Name, p.Version}
+				}
+			}
+		})
+		db.Close()
+	}
+	return ret
+}
+
+// names of loaded modules which own symbols in kallsyms, e.g. "ffffffffc0a01000 t foo_init	[foo]"
+func kallsymsModules() map[string]bool {
+	ret := map[string]bool{}
+	f, err := os.Open("/proc/kallsyms")
+	if err != nil {
+		return ret
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		i := strings.LastIndexByte(line, '[')
+		if i < 0 || !strings.HasSuffix(line, "]") {
+			continue
+		}
+		name := line[i+1 : len(line)-1]
+		// pseudo modules of bpf programs and ftrace/kprobe trampolines
+		if name == "bpf" || strings.HasPrefix(name, "__builtin__") {
+			continue
+		}
+		ret[name] = true
+	}
+	return ret
+}
+
+// loadable modules registered in sysfs, built-in modules have no initstate
+func sysfsModules() map[string]bool {
+	ret := map[string]bool{}
+	entries, err := os.ReadDir("/sys/module")
+	if err != nil {
+		return ret
+	}
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join("/sys/module", e.Name(), "initstate")); err == nil {
+			ret[e.Name()] = true
+		}
+	}
+	return ret
+}
+
+func procModules() (map[string]*Kmod, error) {
+	ret := map[string]*Kmod{}
 	f, err := os.Open("/proc/modules")
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer f.Close()
 	s := bufio.NewScanner(f)
 	for s.Scan() {
 		fields := strings.Fields(s.Text())
 		if len(fields) > 5 {
-			c.SendRecord(&plugins.Record{
-				DataType:  int32(h.DataType()),
-				Timestamp: time.Now().Unix(),
-				Data: &plugins.Payload{
-					Fields: map[string]string{
-						"name":        fields[0],
-						"size":        fields[1],
-						"refcount":    fields[2],
-						"used_by":     fields[3],
-						"state":       fields[4],
-						"addr":        fields[5],
-						"package_seq": seq,
-					},
-				},
-			})
+			ret[fields[0]] = &Kmod{
+				Name:     fields[0],
+				Size:     fields[1],
+				Refcount: fields[2],
+				UsedBy:   fields[3],
+				State:    fields[4],
+				Addr:     fields[5],
+			}
 		}
 	}
+	return ret, s.Err()
+}
 
+func (h *KmodHandler) file(path string) *kmodFile {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if f, ok := h.files[path]; ok && f.modTime.Equal(st.ModTime()) {
+		return f
+	}
+	f := &kmodFile{modTime: st.ModTime()}
+	h.files[path] = f
+	data, err := kmod.Read(path)
+	if err != nil {
+		return f
+	}
+	f.parsed = true
+	if info, err := kmod.ModInfo(data); err == nil {
+		f.vermagic = info["vermagic"]
+	}
+	if sig, err := kmod.ParseSignature(data); err == nil {
+		f.signed = true
+		f.sig = sig
+	} else if err != kmod.ErrNotSigned {
+		// appended but unparsable, e.g. signed with a legacy non pkcs#7 format
+		f.signed = true
+	}
+	return f
+}
+
+func (h *KmodHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	if h.files == nil {
+		h.files = map[string]*kmodFile{}
+	}
+	mods, err := procModules()
+	if err != nil {
+		return
+	}
+	sysfs := sysfsModules()
+	kallsyms := kallsymsModules()
+	hidden := map[string]bool{}
+	for _, view := range []map[string]bool{sysfs, kallsyms} {
+		for name := range view {
+			if _, ok := mods[name]; !ok {
+				hidden[name] = true
+			}
+		}
+	}
+	if len(hidden) != 0 {
+		// modules being loaded or unloaded between the reads aren't hidden
+		latest, _ := procModules()
+		for name := range hidden {
+			if m, ok := latest[name]; ok {
+				mods[name] = m
+				delete(hidden, name)
+			}
+		}
+		for name := range hidden {
+			dir := filepath.Join("/sys/module", name)
+			mods[name] = &Kmod{
+				Name:     name,
+				Size:     readModuleFile(filepath.Join(dir, "coresize")),
+				Refcount: readModuleFile(filepath.Join(dir, "refcnt")),
+				State:    initStates[readModuleFile(filepath.Join(dir, "initstate"))],
+				Addr:     readModuleFile(filepath.Join(dir, "sections", ".text")),
+				Hidden:   "procfs",
+			}
+		}
+	}
+	paths := modulePaths()
+	pkgs := modulePackages()
+	// kernel verifies signatures and taints unsigned ones with E
+	sigCheck := false
+	if _, err := os.Stat("/sys/module/module/parameters/sig_enforce"); err == nil {
+		sigCheck = true
+	}
+	seen := map[string]bool{}
+	for name, m := range mods {
+		dir := filepath.Join("/sys/module", name)
+		if len(sysfs) != 0 && !sysfs[name] && m.Hidden == "" {
+			m.Hidden = "sysfs"
+		}
+		m.SrcVersion = readModuleFile(filepath.Join(dir, "srcversion"))
+		m.Version = readModuleFile(filepath.Join(dir, "version"))
+		m.Taint = readModuleFile(filepath.Join(dir, "taint"))
+		m.Path = paths[name]
+		m.Signature = "unknown"
+		if m.Path != "" {
+			seen[m.Path] = true
+			if f := h.file(m.Path); f != nil && f.parsed {
+				m.Vermagic = f.vermagic
+				m.Signature = "unsigned"
+				if f.signed {
+					m.Signature = "signed"
+				}
+				if f.sig != nil {
+					m.Signer = f.sig.Signer
+					m.SigKey = f.sig.KeyID
+					m.SigHashAlgo = f.sig.HashAlgo
+				}
+			}
+			if p, ok := pkgs[normalizeModulePath(m.Path)]; ok {
+				m.PackageName = p.name
+				m.PackageVersion = p.version
+			}
+		}
+		if strings.Contains(m.Taint, "E") {
+			m.Signature = "unsigned"
+		} else if m.Signature == "unknown" && sigCheck && m.Hidden == "" {
+			m.Signature = "signed"
+		}
+		reasons := []string{}
+		if m.Hidden != "" {
+			reasons = append(reasons, "hidden")
+		}
+		if m.Signature == "unsigned" {
+			reasons = append(reasons, "unsigned")
+		}
+		if m.PackageName == "" {
+			reasons = append(reasons, "unowned")
+		}
+		m.Risk = "false"
+		if len(reasons) != 0 {
+			m.Risk = "true"
+		}
+		m.RiskReason = strings.Join(reasons, ",")
+		rec := &plugins.Record{
+			DataType:  int32(h.DataType()),
+			Timestamp: time.Now().Unix(),
+			Data: &plugins.Payload{
+				Fields: make(map[string]string, 24),
+			},
+		}
+		mapstructure.Decode(m, &rec.Data.Fields)
+		rec.Data.Fields["package_seq"] = seq
+		c.SendRecord(rec)
+	}
+	for path := range h.files {
+		if !seen[path] {
+			delete(h.files, path)
+		}
+	}
 }
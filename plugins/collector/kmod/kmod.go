@@ -0,0 +1,192 @@
+package kmod
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/x509/pkix"
+	"debug/elf"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	maxModuleSize   = 256 * 1024 * 1024
+	signatureMagic  = "~Module signature appended~\n"
+	signatureInfoSz = 12
+	// id_type of struct module_signature
+	pkeyIDPKCS7 = 2
+)
+
+var (
+	ErrNotSigned              = errors.New("module is not signed")
+	ErrUnsupportedCompression = errors.New("unsupported module compression")
+)
+
+var hashAlgos = map[string]string{
+	"1.3.14.3.2.26":           "sha1",
+	"2.16.840.1.101.3.4.2.1":  "sha256",
+	"2.16.840.1.101.3.4.2.2":  "sha384",
+	"2.16.840.1.101.3.4.2.3":  "sha512",
+	"2.16.840.1.101.3.4.2.4":  "sha224",
+	"2.16.840.1.101.3.4.2.8":  "sha3-256",
+	"2.16.840.1.101.3.4.2.9":  "sha3-384",
+	"2.16.840.1.101.3.4.2.10": "sha3-512",
+	"1.2.156.10197.1.401":     "sm3",
+}
+
+// Read returns the content of a .ko file, decompressing .ko.gz and .ko.zst.
+func Read(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(path, ".ko"):
+		r = f
+	case strings.HasSuffix(path, ".ko.gz"):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case strings.HasSuffix(path, ".ko.zst"):
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	return io.ReadAll(io.LimitReader(r, maxModuleSize))
+}
+
+// ModInfo returns the key-value pairs of the .modinfo section, e.g. vermagic and srcversion.
+func ModInfo(data []byte) (map[string]string, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	s := f.Section(".modinfo")
+	if s == nil {
+		return nil, errors.New("no .modinfo section")
+	}
+	b, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	for _, kv := range bytes.Split(b, []byte{0}) {
+		if k, v, ok := strings.Cut(string(kv), "="); ok {
+			// the first one wins, as modinfo -F does
+			if _, ok := ret[k]; !ok {
+				ret[k] = v
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Signature is the appended module signature, fields match those of modinfo.
+type Signature struct {
+	Signer   string
+	KeyID    string
+	HashAlgo string
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version         int
+	SID             asn1.RawValue
+	DigestAlgorithm pkix.AlgorithmIdentifier
+}
+
+type issuerAndSerial struct {
+	Issuer pkix.RDNSequence
+	Serial asn1.RawValue
+}
+
+// ParseSignature parses the PKCS#7 signature appended to a module, see
+// scripts/sign-file.c of the kernel.
+func ParseSignature(data []byte) (*Signature, error) {
+	if !bytes.HasSuffix(data, []byte(signatureMagic)) {
+		return nil, ErrNotSigned
+	}
+	data = data[:len(data)-len(signatureMagic)]
+	if len(data) < signatureInfoSz {
+		return nil, errors.New("truncated module signature")
+	}
+	info := data[len(data)-signatureInfoSz:]
+	if info[2] != pkeyIDPKCS7 {
+		return nil, errors.New("unsupported module signature type")
+	}
+	sigLen := int(binary.BigEndian.Uint32(info[8:]))
+	data = data[:len(data)-signatureInfoSz]
+	if sigLen > len(data) {
+		return nil, errors.New("truncated module signature")
+	}
+	ci := contentInfo{}
+	if _, err := asn1.Unmarshal(data[len(data)-sigLen:], &ci); err != nil {
+		return nil, err
+	}
+	sd := signedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("no signer info")
+	}
+	si := sd.SignerInfos[0]
+	sig := &Signature{HashAlgo: hashAlgos[si.DigestAlgorithm.Algorithm.String()]}
+	if sig.HashAlgo == "" {
+		sig.HashAlgo = si.DigestAlgorithm.Algorithm.String()
+	}
+	if si.SID.Class == asn1.ClassContextSpecific && si.SID.Tag == 0 {
+		// subjectKeyIdentifier
+		sig.KeyID = keyID(si.SID.Bytes)
+		return sig, nil
+	}
+	ias := issuerAndSerial{}
+	if _, err := asn1.Unmarshal(si.SID.FullBytes, &ias); err != nil {
+		return nil, err
+	}
+	name := pkix.Name{}
+	name.FillFromRDNSequence(&ias.Issuer)
+	sig.Signer = name.CommonName
+	sig.KeyID = keyID(ias.Serial.Bytes)
+	return sig, nil
+}
+
+// formatted like modinfo, e.g. 4A:2F:...
+func keyID(b []byte) string {
+	h := strings.ToUpper(hex.EncodeToString(b))
+	parts := make([]string, 0, len(h)/2)
+	for i := 0; i+2 <= len(h); i += 2 {
+		parts = append(parts, h[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
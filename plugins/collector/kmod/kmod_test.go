@@ -0,0 +1,77 @@
+package kmod
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+func sign(t *testing.T, module []byte) []byte {
+	type issuerAndSerialNumber struct {
+		Issuer pkix.RDNSequence
+		Serial *big.Int
+	}
+	type signer struct {
+		Version         int
+		SID             issuerAndSerialNumber
+		DigestAlgorithm pkix.AlgorithmIdentifier
+		SigAlgorithm    pkix.AlgorithmIdentifier
+		Signature       []byte
+	}
+	type signed struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		SignerInfos      []signer `asn1:"set"`
+	}
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	sd := signed{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		SignerInfos: []signer{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer: pkix.Name{CommonName: "Build time autogenerated kernel key"}.ToRDNSequence(),
+				Serial: big.NewInt(0x4a2f01),
+			},
+			DigestAlgorithm: sha256,
+			SigAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+			Signature:       []byte{1, 2, 3},
+		}},
+	}
+	b, err := asn1.Marshal(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := make([]byte, signatureInfoSz)
+	info[2] = pkeyIDPKCS7
+	binary.BigEndian.PutUint32(info[8:], uint32(len(p7)))
+	ret := append([]byte{}, module...)
+	ret = append(ret, p7...)
+	ret = append(ret, info...)
+	return append(ret, signatureMagic...)
+}
+
+func TestParseSignature(t *testing.T) {
+	module := []byte("\x7fELF fake module")
+	if _, err := ParseSignature(module); err != ErrNotSigned {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sig, err := ParseSignature(sign(t, module))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Signer != "Build time autogenerated kernel key" || sig.KeyID != "4A:2F:01" || sig.HashAlgo != "sha256" {
+		t.Errorf("unexpected signature %+v", sig)
+	}
+}
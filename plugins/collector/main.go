@@ -36,14 +36,21 @@ func main() {
 	e := engine.New(c, zapr.NewLogger(l))
 
 	e.AddHandler(time.Minute*20, &ProcessHandler{})
+	e.AddHandler(time.Hour*6, &HiddenProcessHandler{})
 	e.AddHandler(time.Hour, &PortHandler{})
+	e.AddHandler(time.Hour, &ConnectionHandler{})
 	e.AddHandler(time.Hour, &UserHandler{})
+	e.AddHandler(time.Hour, &UserAccessHandler{})
 	e.AddHandler(time.Hour*6, &CronHandler{})
 	e.AddHandler(time.Hour*6, &ServiceHandler{})
+	e.AddHandler(time.Hour*6, &PersistenceHandler{})
 	// e.AddHandler(engine.BeforeDawn(), &SoftwareHandler{})
 	e.AddHandler(time.Hour, &SoftwareHandler{})
+	e.AddHandler(time.Hour*6, &ImageSoftwareHandler{})
 	e.AddHandler(time.Minute*5, &ContainerHandler{})
 	e.AddHandler(engine.BeforeDawn(), &IntegrityHandler{})
+	e.AddHandler(time.Hour, &FimHandler{})
+	e.AddHandler(engine.BeforeDawn(), &SecretHandler{})
 	e.AddHandler(time.Hour*6, &NetInterfaceHandler{})
 	e.AddHandler(time.Hour*6, &VolumeHandler{})
 	e.AddHandler(time.Hour, &KmodHandler{})
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/persistence"
+	"github.com/bytedance/Elkeid/plugins/collector/utils"
+	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
+)
+
+var (
+	userUnitDirs = []string{"/etc/systemd/user", "/usr/local/lib/systemd/user", "/usr/lib/systemd/user"}
+	atSpoolDirs  = []string{"/var/spool/cron/atjobs", "/var/spool/at"}
+	// in the order of precedence
+	udevRuleDirs     = []string{"/etc/udev/rules.d", "/run/udev/rules.d", "/usr/local/lib/udev/rules.d", "/usr/lib/udev/rules.d", "/lib/udev/rules.d"}
+	rcLocalPaths     = []string{"/etc/rc.local", "/etc/rc.d/rc.local"}
+	systemProfiles   = []string{"/etc/profile", "/etc/bash.bashrc", "/etc/bashrc", "/etc/zshrc", "/etc/zsh/zshrc", "/etc/zsh/zprofile", "/etc/zprofile"}
+	userProfiles     = []string{".bashrc", ".bash_profile", ".bash_login", ".bash_logout", ".profile", ".zshrc", ".zprofile", ".zlogin", ".zshenv"}
+	profileTriggers  = map[string]string{".bashrc": "interactive shell", "bash.bashrc": "interactive shell", "bashrc": "interactive shell", ".zshrc": "interactive shell", "zshrc": "interactive shell", ".bash_logout": "logout", ".zshenv": "any shell"}
+	xdgAutostartDirs = []string{"/etc/xdg/autostart"}
+)
+
+type Persistence struct {
+	Mechanism  string `mapstructure:"mechanism"`
+	Name       string `mapstructure:"name"`
+	Trigger    string `mapstructure:"trigger"`
+	Command    string `mapstructure:"command"`
+	Path       string `mapstructure:"path"`
+	Owner      string `mapstructure:"owner"`
+	Checksum   string `mapstructure:"checksum"`
+	ModifyTime string `mapstructure:"modify_time"`
+}
+
+// PersistenceHandler normalizes the persistence spots which aren't covered by
+// cron and service: systemd timers, at jobs, shell profiles, rc.local, udev
+// rules running programs and xdg autostart entries.
+type PersistenceHandler struct{}
+
+func (h *PersistenceHandler) Name() string {
+	return "persistence"
+}
+func (h *PersistenceHandler) DataType() int {
+	return 5069
+}
+
+// persistenceRun sends the entries of a run
+type persistenceRun struct {
+	c   *plugins.Client
+	seq string
+}
+
+// send fills the owner, checksum and modify time from the file, it returns
+// false if the file isn't a regular one.
+func (r *persistenceRun) send(mechanism, path string, e *persistence.Entry) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	p := &Persistence{
+		Mechanism:  mechanism,
+		Name:       e.Name,
+		Trigger:    e.Trigger,
+		Command:    e.Command,
+		Path:       path,
+		ModifyTime: strconv.FormatInt(fi.ModTime().Unix(), 10),
+	}
+	uid := e.Uid
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && uid == "" {
+		uid = strconv.FormatUint(uint64(st.Uid), 10)
+	}
+	p.Owner = uid
+	if name, err := utils.GetUsername(uid); err == nil {
+		p.Owner = name
+	}
+	p.Checksum, _ = utils.GetMd5(path, "")
+	rec := &plugins.Record{
+		DataType:  5069,
+		Timestamp: time.Now().Unix(),
+		Data: &plugins.Payload{
+			Fields: make(map[string]string, 9),
+		},
+	}
+	mapstructure.Decode(p, &rec.Data.Fields)
+	rec.Data.Fields["package_seq"] = r.seq
+	r.c.SendRecord(rec)
+	return true
+}
+
+// files of dirs by name, the first dir wins for names in several dirs
+func dirFiles(dirs []string, suffix string) (names []string, paths map[string]string) {
+	paths = map[string]string{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), suffix) {
+				continue
+			}
+			if _, ok := paths[e.Name()]; !ok {
+				names = append(names, e.Name())
+				paths[e.Name()] = filepath.Join(dir, e.Name())
+			}
+		}
+	}
+	return
+}
+
+// timers reports the timers of dirs, whose services are looked up in lookup as well
+func (r *persistenceRun) timers(dirs, lookup []string) {
+	names, timers := dirFiles(dirs, ".timer")
+	_, services := dirFiles(append(append([]string{}, dirs...), lookup...), ".service")
+	for _, name := range names {
+		path := timers[name]
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		trigger, unit := persistence.ParseTimer(name, f)
+		f.Close()
+		e := &persistence.Entry{Name: name, Trigger: trigger}
+		if sp, ok := services[unit]; ok {
+			if f, err := os.Open(sp); err == nil {
+				e.Command = persistence.ServiceCommand(f)
+				f.Close()
+			}
+		}
+		r.send(persistence.MechanismSystemdTimer, path, e)
+	}
+}
+
+func (r *persistenceRun) atJobs() {
+	for _, dir := range atSpoolDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, de := range entries {
+			// .SEQ, and running jobs prefixed by =
+			if de.IsDir() || strings.HasPrefix(de.Name(), ".") || strings.HasPrefix(de.Name(), "=") {
+				continue
+			}
+			path := filepath.Join(dir, de.Name())
+			if f, err := os.Open(path); err == nil {
+				e := persistence.ParseAtJob(de.Name(), f)
+				f.Close()
+				r.send(persistence.MechanismAtJob, path, e)
+			}
+		}
+	}
+}
+
+func (r *persistenceRun) profile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	e := &persistence.Entry{Name: filepath.Base(path), Trigger: "login shell", Command: persistence.ProfileHooks(f)}
+	f.Close()
+	if t, ok := profileTriggers[e.Name]; ok {
+		e.Trigger = t
+	}
+	r.send(persistence.MechanismShellProfile, path, e)
+}
+
+func (r *persistenceRun) autostart(dirs []string) {
+	names, paths := dirFiles(dirs, ".desktop")
+	for _, name := range names {
+		f, err := os.Open(paths[name])
+		if err != nil {
+			continue
+		}
+		e := persistence.ParseDesktopEntry(f)
+		f.Close()
+		if e != nil {
+			if e.Name == "" {
+				e.Name = name
+			}
+			r.send(persistence.MechanismXdgAutostart, paths[name], e)
+		}
+	}
+}
+
+func (h *PersistenceHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	r := &persistenceRun{c: c, seq: seq}
+	r.timers(SearchDir, nil)
+	r.timers(userUnitDirs, nil)
+	r.atJobs()
+	r.profile(systemProfiles[0])
+	if entries, err := os.ReadDir("/etc/profile.d"); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				r.profile(filepath.Join("/etc/profile.d", e.Name()))
+			}
+		}
+	}
+	for _, path := range systemProfiles[1:] {
+		r.profile(path)
+	}
+	seen := map[string]bool{}
+	for _, path := range rcLocalPaths {
+		// rc.local is usually a link to rc.d/rc.local
+		if real, err := filepath.EvalSymlinks(path); err == nil && !seen[real] {
+			seen[real] = true
+			f, err := os.Open(real)
+			if err != nil {
+				continue
+			}
+			e := &persistence.Entry{Name: "rc.local", Trigger: "boot", Command: persistence.RcLocalCommand(f)}
+			f.Close()
+			r.send(persistence.MechanismRcLocal, real, e)
+		}
+	}
+	names, rules := dirFiles(udevRuleDirs, ".rules")
+	for _, name := range names {
+		f, err := os.Open(rules[name])
+		if err != nil {
+			continue
+		}
+		entries := persistence.ParseUdevRules(f)
+		f.Close()
+		for _, e := range entries {
+			e.Name = name
+			r.send(persistence.MechanismUdevRule, rules[name], e)
+		}
+	}
+	r.autostart(xdgAutostartDirs)
+	homes := map[string]bool{}
+	for _, u := range readPasswd() {
+		if u.home == "" || u.home == "/" || homes[u.home] {
+			continue
+		}
+		homes[u.home] = true
+		if fi, err := os.Stat(u.home); err != nil || !fi.IsDir() {
+			continue
+		}
+		for _, name := range userProfiles {
+			r.profile(filepath.Join(u.home, name))
+		}
+		r.timers([]string{filepath.Join(u.home, ".config/systemd/user")}, userUnitDirs)
+		r.autostart([]string{filepath.Join(u.home, ".config/autostart")})
+	}
+}
@@ -0,0 +1,245 @@
+package persistence
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mechanisms of persistence
+const (
+	MechanismSystemdTimer = "systemd_timer"
+	MechanismAtJob        = "at_job"
+	MechanismShellProfile = "shell_profile"
+	MechanismRcLocal      = "rc_local"
+	MechanismUdevRule     = "udev_rule"
+	MechanismXdgAutostart = "xdg_autostart"
+)
+
+const (
+	// size of a parsed file at most
+	MaxFileSize = 1024 * 1024
+	// length of a reported command at most
+	MaxCommandSize = 4096
+)
+
+// Entry is something which runs a command on a trigger.
+type Entry struct {
+	Name    string
+	Trigger string
+	Command string
+	// user of at jobs
+	Uid string
+}
+
+func truncate(s string) string {
+	if len(s) > MaxCommandSize {
+		return s[:MaxCommandSize]
+	}
+	return s
+}
+
+// ParseUnit reads the keys of an ini style systemd unit or desktop entry by
+// section, later values of a key are appended.
+func ParseUnit(r io.Reader) map[string]map[string][]string {
+	ret := map[string]map[string][]string{}
+	section := ""
+	s := bufio.NewScanner(io.LimitReader(r, MaxFileSize))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if ret[section] == nil {
+			ret[section] = map[string][]string{}
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		// an empty value resets the list
+		if v == "" {
+			delete(ret[section], k)
+			continue
+		}
+		ret[section][k] = append(ret[section][k], v)
+	}
+	return ret
+}
+
+var timerKeys = []string{"OnCalendar", "OnActiveSec", "OnBootSec", "OnStartupSec", "OnUnitActiveSec", "OnUnitInactiveSec"}
+
+// ParseTimer returns the trigger of a timer unit and the unit it activates.
+func ParseTimer(name string, r io.Reader) (trigger, unit string) {
+	timer := ParseUnit(r)["Timer"]
+	triggers := []string{}
+	for _, k := range timerKeys {
+		for _, v := range timer[k] {
+			triggers = append(triggers, k+"="+v)
+		}
+	}
+	unit = strings.TrimSuffix(name, ".timer") + ".service"
+	if v := timer["Unit"]; len(v) != 0 {
+		unit = v[len(v)-1]
+	}
+	return strings.Join(triggers, "; "), unit
+}
+
+// ServiceCommand returns the commands of a service unit.
+func ServiceCommand(r io.Reader) string {
+	service := ParseUnit(r)["Service"]
+	cmds := []string{}
+	for _, k := range []string{"ExecStartPre", "ExecStart", "ExecStartPost"} {
+		cmds = append(cmds, service[k]...)
+	}
+	return truncate(strings.Join(cmds, "\n"))
+}
+
+// ParseDesktopEntry returns the autostart entry of a .desktop file, nil if it's hidden.
+func ParseDesktopEntry(r io.Reader) *Entry {
+	entry := ParseUnit(r)["Desktop Entry"]
+	last := func(k string) string {
+		if v := entry[k]; len(v) != 0 {
+			return v[len(v)-1]
+		}
+		return ""
+	}
+	if last("Hidden") == "true" || last("X-GNOME-Autostart-enabled") == "false" || last("Exec") == "" {
+		return nil
+	}
+	trigger := "desktop login"
+	if v := last("OnlyShowIn"); v != "" {
+		trigger += " (" + strings.TrimSuffix(v, ";") + ")"
+	}
+	return &Entry{Name: last("Name"), Trigger: trigger, Command: truncate(last("Exec"))}
+}
+
+var atrunReg = regexp.MustCompile(`^# atrun uid=(\d+)`)
+
+// ParseAtJob reads an at job spooled by atd. The queue and the time are
+// encoded by the name of a job file on debian, e.g. a0000101b2c3d4.
+func ParseAtJob(name string, r io.Reader) *Entry {
+	e := &Entry{Name: name, Trigger: "at"}
+	if len(name) == 14 {
+		if name[0] == 'b' {
+			e.Trigger = "batch"
+		}
+		if min, err := strconv.ParseInt(name[6:], 16, 64); err == nil {
+			e.Trigger += " " + time.Unix(min*60, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	s := bufio.NewScanner(io.LimitReader(r, MaxFileSize))
+	// the script ends with the commands after changing to the working dir
+	cmds := []string{}
+	body, delimiter := false, ""
+	for s.Scan() {
+		line := s.Text()
+		if m := atrunReg.FindStringSubmatch(line); m != nil {
+			e.Uid = m[1]
+			continue
+		}
+		if !body {
+			if line == "}" {
+				body = true
+			}
+			continue
+		}
+		// newer versions run the commands by a heredoc of the shell
+		if delimiter == "" && len(cmds) == 0 && strings.HasPrefix(line, "${SHELL:-/bin/sh} << ") {
+			delimiter = strings.Trim(strings.TrimPrefix(line, "${SHELL:-/bin/sh} << "), "'\"")
+			continue
+		}
+		if delimiter != "" && line == delimiter {
+			break
+		}
+		if strings.TrimSpace(line) != "" {
+			cmds = append(cmds, line)
+		}
+	}
+	e.Command = truncate(strings.Join(cmds, "\n"))
+	return e
+}
+
+var (
+	udevRunReg   = regexp.MustCompile(`RUN(?:\{[a-z]+\})?\+?=\s*"([^"]*)"`)
+	udevMatchReg = regexp.MustCompile(`^[A-Z]+(?:\{[^}]*\})?\s*(?:==|!=)`)
+)
+
+// ParseUdevRules returns the rules of a udev rules file which run programs,
+// triggered by their match keys.
+func ParseUdevRules(r io.Reader) (ret []*Entry) {
+	s := bufio.NewScanner(io.LimitReader(r, MaxFileSize))
+	rule := ""
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		// continued lines
+		if strings.HasSuffix(line, "\\") {
+			rule += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		rule += line
+		line, rule = rule, ""
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		runs := udevRunReg.FindAllStringSubmatch(line, -1)
+		if len(runs) == 0 {
+			continue
+		}
+		matches := []string{}
+		for _, kv := range strings.Split(line, ",") {
+			if kv = strings.TrimSpace(kv); udevMatchReg.MatchString(kv) {
+				matches = append(matches, kv)
+			}
+		}
+		cmds := []string{}
+		for _, run := range runs {
+			cmds = append(cmds, run[1])
+		}
+		ret = append(ret, &Entry{Trigger: strings.Join(matches, ", "), Command: truncate(strings.Join(cmds, "\n"))})
+	}
+	return
+}
+
+// commands which are common in hooks of backdoors
+var hookReg = regexp.MustCompile(`(?i)\b(?:curl|wget|nc|ncat|socat|telnet|nohup|setsid|python[0-9.]*\s+-c|perl\s+-e|ruby\s+-e|php\s+-r|base64\s+(?:-d|--decode)|openssl\s+enc|crontab|chattr|insmod)\b|/dev/(?:tcp|udp)/|\bbash\s+-i\b|\bexport\s+LD_PRELOAD\b|\bPROMPT_COMMAND=|\balias\s+(?:sudo|su|ssh|scp|passwd)=|\btrap\s+[^\n]*\b(?:DEBUG|EXIT)\b|[^&]&\s*$`)
+
+// ProfileHooks returns the lines of a shell profile which look like hooks
+// rather than environment settings, i.e. run network tools, decode payloads,
+// background processes or hijack commands.
+func ProfileHooks(r io.Reader) string {
+	s := bufio.NewScanner(io.LimitReader(r, MaxFileSize))
+	hooks := []string{}
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if hookReg.MatchString(line) {
+			hooks = append(hooks, line)
+		}
+	}
+	return truncate(strings.Join(hooks, "\n"))
+}
+
+// RcLocalCommand returns the commands of rc.local.
+func RcLocalCommand(r io.Reader) string {
+	s := bufio.NewScanner(io.LimitReader(r, MaxFileSize))
+	cmds := []string{}
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line == "exit 0" {
+			continue
+		}
+		cmds = append(cmds, line)
+	}
+	return truncate(strings.Join(cmds, "\n"))
+}
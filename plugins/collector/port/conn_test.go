@@ -0,0 +1,84 @@
+package port
+
+import (
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestConns(t *testing.T) {
+	nginx := &owner{pid: "100", exe: "/usr/sbin/nginx", comm: "nginx", pns: "4026531836"}
+	curl := &owner{pid: "200", exe: "/usr/bin/curl", comm: "curl", pns: "4026531836"}
+	mysqld := &owner{pid: "300", exe: "/usr/sbin/mysqld", comm: "mysqld", pns: "4026531836"}
+	o := &Owners{
+		sockets: map[string]*owner{"11": nginx, "12": curl, "21": mysqld, "22": nginx},
+		netns:   map[string]string{"4026531840": "1"},
+	}
+	sockets := &netnsSockets{
+		inet: []*Port{
+			{Family: "2", Protocol: "6", Sip: "10.0.0.1", Sport: "443", Dip: "1.2.3.4", Dport: "51234", Uid: "33", Inode: "11"},
+			{Family: "2", Protocol: "6", Sip: "10.0.0.1", Sport: "40000", Dip: "8.8.8.8", Dport: "443", Uid: "0", Inode: "12"},
+			// opened after the owners were walked
+			{Family: "10", Protocol: "6", Sip: "::1", Sport: "40001", Dip: "::1", Dport: "8080", Uid: "1000", Inode: "13"},
+		},
+		unix: []*unixSocket{
+			{typ: unix.SOCK_STREAM, inode: 21, peer: 22, path: "/run/mysqld/mysqld.sock"},
+			{typ: unix.SOCK_STREAM, inode: 22, peer: 21},
+			{typ: unix.SOCK_DGRAM, inode: 23, path: "/dev/log"},
+		},
+		listeners: map[string]bool{listenKey("6", "443"): true},
+	}
+	conns := o.conns("4026531840", sockets)
+	if len(conns) != 5 {
+		t.Fatalf("conns() returns %d connections, want 5", len(conns))
+	}
+	byInode := map[string]*Conn{}
+	for _, c := range conns {
+		if c.Netns != "4026531840" {
+			t.Errorf("%s: netns %s", c.Inode, c.Netns)
+		}
+		byInode[c.Inode] = c
+	}
+	tests := []struct {
+		inode     string
+		direction string
+		pid       string
+		path      string
+		peerExe   string
+	}{
+		{"11", DirectionInbound, "100", "", ""},
+		{"12", DirectionOutbound, "200", "", ""},
+		{"13", DirectionOutbound, "", "", ""},
+		{"21", "", "300", "/run/mysqld/mysqld.sock", "/usr/sbin/nginx"},
+		// the client end takes the name of the server end
+		{"22", "", "100", "/run/mysqld/mysqld.sock", "/usr/sbin/mysqld"},
+	}
+	for _, tt := range tests {
+		c, ok := byInode[tt.inode]
+		if !ok {
+			t.Errorf("%s: missing", tt.inode)
+			continue
+		}
+		if c.Direction != tt.direction || c.Pid != tt.pid || c.Path != tt.path || c.PeerExe != tt.peerExe {
+			t.Errorf("%s: unexpected connection %+v", tt.inode, c)
+		}
+	}
+	if c := byInode["13"]; c.Uid != "1000" || c.Exe != "" {
+		t.Errorf("unowned socket %+v, want the uid only", c)
+	}
+	if c := byInode["21"]; c.Family != strconv.Itoa(unix.AF_UNIX) || c.Type != "stream" || c.PeerInode != "22" || c.PeerPid != "100" {
+		t.Errorf("unexpected unix socket %+v", c)
+	}
+	if _, ok := byInode["23"]; ok {
+		t.Error("unix socket without owner is returned")
+	}
+}
+
+func TestIsEstablished(t *testing.T) {
+	for state, want := range map[string]bool{"1": true, "01": false, "10": false, "": false} {
+		if got := isEstablished(state); got != want {
+			t.Errorf("isEstablished(%q) = %v, want %v", state, got, want)
+		}
+	}
+}
@@ -148,14 +148,8 @@ func (r *inetDiagResp) Deserialize(d []byte) (err error) {
 }
 func (r *inetDiagResp) Len() int { return sizeofinetDiagResp }
 
+// listening sockets, udp ones are in TCP_CLOSE state
 func inetDiag(family, proto uint8) (ret []*inetDiagResp, err error) {
-	var s *nl.NetlinkSocket
-	s, err = nl.Subscribe(unix.NETLINK_INET_DIAG)
-	if err != nil {
-		return
-	}
-	defer s.Close()
-	req := nl.NewNetlinkRequest(nl.SOCK_DIAG_BY_FAMILY, unix.NLM_F_DUMP)
 	var state uint32
 	if proto == unix.IPPROTO_UDP {
 		state = 7
@@ -165,11 +159,22 @@ func inetDiag(family, proto uint8) (ret []*inetDiagResp, err error) {
 		err = fmt.Errorf("unsupported protocol %d", proto)
 		return
 	}
+	return inetDiagStates(family, proto, uint32(1<<state))
+}
+
+func inetDiagStates(family, proto uint8, states uint32) (ret []*inetDiagResp, err error) {
+	var s *nl.NetlinkSocket
+	s, err = nl.Subscribe(unix.NETLINK_INET_DIAG)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	req := nl.NewNetlinkRequest(nl.SOCK_DIAG_BY_FAMILY, unix.NLM_F_DUMP)
 	req.AddData(&inetDiagReq{
 		family:   family,
 		protocol: proto,
 		ext:      (1 << (netlink.INET_DIAG_VEGASINFO - 1)) | (1 << (netlink.INET_DIAG_INFO - 1)),
-		states:   uint32(1 << state),
+		states:   states,
 	})
 	err = s.Send(req)
 	if err != nil {
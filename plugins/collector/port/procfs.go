@@ -39,7 +39,15 @@ func parseIP(h string) (ret string, err error) {
 	}
 }
 
+// listening sockets of the netns of the collector
 func procNet(family, proto uint8) (ret []*Port, err error) {
+	return procNetStates("/proc/net", family, proto, func(state string) bool {
+		return (proto == unix.IPPROTO_UDP && state == "7") || (proto == unix.IPPROTO_TCP && state == "10")
+	})
+}
+
+// procNetStates reads sockets from dir, which is /proc/<pid>/net for the netns of other processes
+func procNetStates(dir string, family, proto uint8, match func(state string) bool) (ret []*Port, err error) {
 	var f *os.File
 	var f1, f2 string
 	if proto == unix.IPPROTO_UDP {
@@ -61,10 +69,11 @@ func procNet(family, proto uint8) (ret []*Port, err error) {
 	if err != nil {
 		return
 	}
-	f, err = os.Open(filepath.Join("/proc/net", f1+f2))
+	f, err = os.Open(filepath.Join(dir, f1+f2))
 	if err != nil {
 		return
 	}
+	defer f.Close()
 	sf := strconv.Itoa(int(family))
 	sp := strconv.Itoa(int(proto))
 	r := bufio.NewScanner(io.LimitReader(f, 1024*1024*2))
@@ -131,7 +140,7 @@ func procNet(family, proto uint8) (ret []*Port, err error) {
 					}
 				}
 			}
-			if err == nil && ((proto == unix.IPPROTO_UDP && p.State == "7") || (proto == unix.IPPROTO_TCP && p.State == "10")) {
+			if err == nil && match(p.State) {
 				p.Protocol = sp
 				p.Family = sf
 				ret = append(ret, p)
@@ -0,0 +1,170 @@
+package port
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// https://man7.org/linux/man-pages/man7/unix.7.html
+const (
+	sizeofunixDiagReq  = 0x18
+	sizeofunixDiagResp = 0x10
+
+	unixDiagName = 0
+	unixDiagPeer = 2
+
+	udiagShowName = 0x1
+	udiagShowPeer = 0x4
+
+	// TCP_ESTABLISHED is reused as the connected state
+	unixConnected = 1
+)
+
+type unixSocket struct {
+	typ   uint8
+	state uint8
+	inode uint32
+	peer  uint32
+	path  string
+}
+
+type unixDiagReq struct {
+	states uint32
+	show   uint32
+}
+
+func (r *unixDiagReq) Serialize() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, sizeofunixDiagReq))
+	buf.WriteByte(unix.AF_UNIX)
+	buf.WriteByte(0)
+	binary.Write(buf, nl.NativeEndian(), uint16(0))
+	binary.Write(buf, nl.NativeEndian(), r.states)
+	// ino, show, cookie
+	binary.Write(buf, nl.NativeEndian(), uint32(0))
+	binary.Write(buf, nl.NativeEndian(), r.show)
+	binary.Write(buf, nl.NativeEndian(), [2]uint32{^uint32(0), ^uint32(0)})
+	return buf.Bytes()
+}
+func (r *unixDiagReq) Len() int { return sizeofunixDiagReq }
+
+func (s *unixSocket) Deserialize(d []byte) error {
+	if len(d) < sizeofunixDiagResp {
+		return fmt.Errorf("socket data short read %d, want %d", len(d), sizeofunixDiagResp)
+	}
+	s.typ = d[1]
+	s.state = d[2]
+	s.inode = nl.NativeEndian().Uint32(d[4:8])
+	attrs, err := nl.ParseRouteAttr(d[sizeofunixDiagResp:])
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unixDiagName:
+			s.path = unixPath(attr.Value)
+		case unixDiagPeer:
+			if len(attr.Value) >= 4 {
+				s.peer = nl.NativeEndian().Uint32(attr.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// abstract names start with a null byte, shown as @ like ss does
+func unixPath(b []byte) string {
+	if len(b) > 0 && b[0] == 0 {
+		return "@" + string(bytes.TrimRight(b[1:], "\x00"))
+	}
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+func unixDiag(states uint32) (ret []*unixSocket, err error) {
+	var s *nl.NetlinkSocket
+	s, err = nl.Subscribe(unix.NETLINK_INET_DIAG)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	req := nl.NewNetlinkRequest(nl.SOCK_DIAG_BY_FAMILY, unix.NLM_F_DUMP)
+	req.AddData(&unixDiagReq{
+		states: states,
+		show:   udiagShowName | udiagShowPeer,
+	})
+	err = s.Send(req)
+	if err != nil {
+		return
+	}
+loop:
+	for {
+		var msgs []syscall.NetlinkMessage
+		var from *unix.SockaddrNetlink
+		msgs, from, err = s.Receive()
+		if err != nil {
+			return
+		}
+		if from.Pid != nl.PidKernel {
+			continue
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				break loop
+			case unix.NLMSG_ERROR:
+				err = errors.New("unknown error")
+				break loop
+			}
+			us := &unixSocket{}
+			if err := us.Deserialize(m.Data); err != nil {
+				continue
+			}
+			if us.inode != 0 {
+				ret = append(ret, us)
+			}
+		}
+	}
+	return
+}
+
+// procNetUnix reads connected sockets from dir/unix, peers aren't available in procfs
+func procNetUnix(dir string) (ret []*unixSocket, err error) {
+	f, err := os.Open(filepath.Join(dir, "unix"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	r := bufio.NewScanner(io.LimitReader(f, 1024*1024*8))
+	for i := 0; r.Scan(); i++ {
+		// Num RefCount Protocol Flags Type St Inode Path
+		fields := strings.Fields(r.Text())
+		if i == 0 || len(fields) < 7 || fields[5] != "03" {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 32)
+		if err != nil || inode == 0 {
+			continue
+		}
+		typ, _ := strconv.ParseUint(fields[4], 16, 8)
+		us := &unixSocket{typ: uint8(typ), state: unixConnected, inode: uint32(inode)}
+		if len(fields) > 7 {
+			us.path = fields[7]
+		}
+		ret = append(ret, us)
+	}
+	return
+}
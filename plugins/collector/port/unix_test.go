@@ -0,0 +1,99 @@
+package port
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestUnixPath(t *testing.T) {
+	for b, want := range map[string]string{
+		"/run/docker.sock":         "/run/docker.sock",
+		"/run/docker.sock\x00\x00": "/run/docker.sock",
+		"\x00/tmp/.X11-unix/X0":    "@/tmp/.X11-unix/X0",
+		"\x00":                     "@",
+		"":                         "",
+	} {
+		if got := unixPath([]byte(b)); got != want {
+			t.Errorf("unixPath(%q) = %q, want %q", b, got, want)
+		}
+	}
+}
+
+// attr encodes a netlink attribute padded to 4 bytes
+func attr(typ uint16, value []byte) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, nl.NativeEndian(), uint16(4+len(value)))
+	binary.Write(buf, nl.NativeEndian(), typ)
+	buf.Write(value)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func TestUnixDeserialize(t *testing.T) {
+	msg := make([]byte, sizeofunixDiagResp)
+	msg[0] = unix.AF_UNIX
+	msg[1] = unix.SOCK_STREAM
+	msg[2] = unixConnected
+	nl.NativeEndian().PutUint32(msg[4:8], 1234)
+	peer := make([]byte, 4)
+	nl.NativeEndian().PutUint32(peer, 5678)
+	msg = append(msg, attr(unixDiagName, []byte("\x00abstract"))...)
+	msg = append(msg, attr(unixDiagPeer, peer)...)
+
+	us := &unixSocket{}
+	if err := us.Deserialize(msg); err != nil {
+		t.Fatal(err)
+	}
+	if us.typ != unix.SOCK_STREAM || us.state != unixConnected || us.inode != 1234 || us.peer != 5678 || us.path != "@abstract" {
+		t.Errorf("unexpected socket %+v", us)
+	}
+	if err := (&unixSocket{}).Deserialize(msg[:sizeofunixDiagResp-1]); err == nil {
+		t.Error("Deserialize() of a short message, want error")
+	}
+	if l := len((&unixDiagReq{}).Serialize()); l != sizeofunixDiagReq {
+		t.Errorf("request length %d, want %d", l, sizeofunixDiagReq)
+	}
+}
+
+func TestProcNetUnix(t *testing.T) {
+	dir := t.TempDir()
+	data := `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 20001 /run/systemd/private
+0000000000000000: 00000003 00000000 00000000 0001 03 20002 /run/dbus/system_bus_socket
+0000000000000000: 00000003 00000000 00000000 0001 03 20003
+0000000000000000: 00000002 00000000 00000000 0002 03 20004 @/org/freedesktop/systemd1/notify
+0000000000000000: 00000002 00000000 00000000 0005 03 0
+bad line
+`
+	if err := os.WriteFile(filepath.Join(dir, "unix"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := procNetUnix(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []unixSocket{
+		{typ: unix.SOCK_STREAM, state: unixConnected, inode: 20002, path: "/run/dbus/system_bus_socket"},
+		{typ: unix.SOCK_STREAM, state: unixConnected, inode: 20003},
+		{typ: unix.SOCK_DGRAM, state: unixConnected, inode: 20004, path: "@/org/freedesktop/systemd1/notify"},
+	}
+	if len(ret) != len(want) {
+		t.Fatalf("procNetUnix() returns %d sockets, want %d", len(ret), len(want))
+	}
+	for i, us := range ret {
+		if *us != want[i] {
+			t.Errorf("socket %d = %+v, want %+v", i, *us, want[i])
+		}
+	}
+	if _, err := procNetUnix(filepath.Join(dir, "missing")); err == nil {
+		t.Error("procNetUnix() of a missing dir, want error")
+	}
+}
@@ -16,6 +16,8 @@ import (
 
 type ProcessHandler struct{}
 
+var processFilterScope = engine.FilterScope{Pid: "pid", Container: "container_id", Path: "exe"}
+
 func (h ProcessHandler) Name() string {
 	return "process"
 }
@@ -24,32 +26,65 @@ func (h ProcessHandler) DataType() int {
 }
 
 func (h *ProcessHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.handle(c, cache, seq, nil)
+}
+
+// HandleFilter supports pids (with tree), container_id and path of exe.
+func (h *ProcessHandler) HandleFilter(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) error {
+	if err := f.Check(processFilterScope); err != nil {
+		return err
+	}
+	h.handle(c, cache, seq, f)
+	return nil
+}
+
+func (h *ProcessHandler) handle(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) {
 	procs, err := process.Processes(false)
 	if err != nil {
 		zap.S().Error(err)
 	} else {
 		currentTime := time.Now().Unix()
 		formattedCurrent := utils.FormatTimestamp(currentTime)
-		rec := &plugins.Record{
-			DataType:  50501,
-			Timestamp: time.Now().Unix(),
-			Data: &plugins.Payload{
-				Fields: make(map[string]string, 3),
-			},
-		}
-		rec.Data.Fields["seq"] = formattedCurrent
-		if cpuPercents, err := cpu.Percent(0, true); err == nil && len(cpuPercents) != 0 {
-			cpuV := 0.0
-			for _, v := range cpuPercents {
-				cpuV += v
+		var scope string
+		if f != nil {
+			if f.Tree && len(f.Pids) != 0 {
+				expanded := *f
+				expanded.Pids = process.Descendants(procs, f.Pids)
+				expanded.Tree = false
+				f = &expanded
 			}
-			rec.Data.Fields["cpu_usage"] = strconv.FormatFloat(cpuV/float64(len(cpuPercents))/100, 'f', 8, 64)
-		}
-		if mem, err := mem.VirtualMemory(); err == nil {
-			rec.Data.Fields["mem_usage"] = strconv.FormatFloat(mem.UsedPercent/100, 'f', 8, 64)
+			scope = f.Scope(processFilterScope)
+		} else {
+			rec := &plugins.Record{
+				DataType:  50501,
+				Timestamp: time.Now().Unix(),
+				Data: &plugins.Payload{
+					Fields: make(map[string]string, 3),
+				},
+			}
+			rec.Data.Fields["seq"] = formattedCurrent
+			if cpuPercents, err := cpu.Percent(0, true); err == nil && len(cpuPercents) != 0 {
+				cpuV := 0.0
+				for _, v := range cpuPercents {
+					cpuV += v
+				}
+				rec.Data.Fields["cpu_usage"] = strconv.FormatFloat(cpuV/float64(len(cpuPercents))/100, 'f', 8, 64)
+			}
+			if mem, err := mem.VirtualMemory(); err == nil {
+				rec.Data.Fields["mem_usage"] = strconv.FormatFloat(mem.UsedPercent/100, 'f', 8, 64)
+			}
+			c.SendRecord(rec)
 		}
-		c.SendRecord(rec)
 		for _, p := range procs {
+			if f != nil && !f.MatchPid(p.Pid()) {
+				continue
+			}
+			// skip hashing the exe of unmatched processes
+			if f != nil && f.Path != "" {
+				if exe, _ := p.Exe(); !f.MatchPath(exe) {
+					continue
+				}
+			}
 			time.Sleep(process.TraversalInterval)
 			cmdline, err := p.Cmdline()
 			if err != nil {
@@ -96,6 +131,12 @@ func (h *ProcessHandler) Handle(c *plugins.Client, cache *engine.Cache, seq stri
 				rec.Data.Fields["integrity"] = "false"
 			}
 			rec.Data.Fields["package_seq"] = seq
+			if f != nil {
+				if !f.MatchPath(rec.Data.Fields["exe"]) || !f.MatchContainer(rec.Data.Fields["container_id"]) {
+					continue
+				}
+				f.Tag(rec.Data.Fields, scope)
+			}
 			c.SendRecord(rec)
 		}
 	}
@@ -0,0 +1,208 @@
+package process
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// views which a pid can be found in, readdir of /proc is the one rootkits
+// usually tamper with
+const (
+	ViewReaddir = "readdir"
+	ViewStat    = "stat"
+	ViewKill    = "kill"
+	ViewCgroup  = "cgroup"
+)
+
+const (
+	defaultPidMax = 32768
+	// brute-forced pids per second unless limited otherwise, it takes about
+	// 4 minutes with the pid_max of 4194304 set by systemd
+	DefaultBruteRate = 16384
+	// the rate is kept every batch of brute-forced pids
+	bruteBatch = 1024
+)
+
+// HiddenProcess is a process which is missing from at least one view.
+type HiddenProcess struct {
+	Pid     string
+	FoundIn []string
+	Missing []string
+}
+
+func pidMax() int {
+	if b, err := os.ReadFile("/proc/sys/kernel/pid_max"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultPidMax
+}
+
+func readdirPids() map[int]bool {
+	ret := map[int]bool{}
+	f, err := os.Open("/proc")
+	if err != nil {
+		return ret
+	}
+	defer f.Close()
+	names, _ := f.Readdirnames(-1)
+	for _, n := range names {
+		if pid, err := strconv.Atoi(n); err == nil {
+			ret[pid] = true
+		}
+	}
+	return ret
+}
+
+// threads can be looked up in /proc and signaled, but aren't listed
+func isThread(pid int) bool {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(io.LimitReader(f, 64*1024))
+	for s.Scan() {
+		if v := strings.TrimPrefix(s.Text(), "Tgid:"); v != s.Text() {
+			return strings.TrimSpace(v) != strconv.Itoa(pid)
+		}
+	}
+	return false
+}
+
+func statAlive(pid int) bool {
+	_, err := os.Lstat(filepath.Join("/proc", strconv.Itoa(pid)))
+	return err == nil
+}
+
+func killAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// cgroupPids collects pids from cgroup.procs of all hierarchies
+func cgroupPids() map[int]bool {
+	ret := map[int]bool{}
+	filepath.WalkDir("/sys/fs/cgroup", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "cgroup.procs" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if pid, err := strconv.Atoi(strings.TrimSpace(s.Text())); err == nil && pid > 0 {
+				ret[pid] = true
+			}
+		}
+		f.Close()
+		return nil
+	})
+	return ret
+}
+
+// HiddenProcesses cross-checks the pids listed by readdir of /proc against
+// direct lookups of /proc/<pid> and kill(pid, 0), and against cgroup.procs.
+// Pids up to maxPid (pid_max if it's 0) are brute-forced at rate pids per
+// second (DefaultBruteRate if it's 0). Candidates are checked again to filter
+// out processes which were created or exited meanwhile.
+func HiddenProcesses(maxPid, rate int) (ret []*HiddenProcess) {
+	listed := readdirPids()
+	candidates := map[int]map[string]bool{}
+	add := func(pid int, view string) {
+		if listed[pid] {
+			return
+		}
+		if candidates[pid] == nil {
+			candidates[pid] = map[string]bool{}
+		}
+		candidates[pid][view] = true
+	}
+	// pids are less than pid_max
+	max := pidMax()
+	if maxPid > 0 && maxPid < max {
+		max = maxPid + 1
+	}
+	if rate <= 0 {
+		rate = DefaultBruteRate
+	}
+	start := time.Now()
+	for pid := 1; pid < max; pid++ {
+		if pid%bruteBatch == 0 {
+			if d := time.Duration(pid)*time.Second/time.Duration(rate) - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if listed[pid] {
+			continue
+		}
+		if statAlive(pid) {
+			add(pid, ViewStat)
+		}
+		if killAlive(pid) {
+			add(pid, ViewKill)
+		}
+	}
+	for pid := range cgroupPids() {
+		add(pid, ViewCgroup)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	time.Sleep(time.Second)
+	listed = readdirPids()
+	cgroup := cgroupPids()
+	for pid, views := range candidates {
+		if listed[pid] || isThread(pid) {
+			continue
+		}
+		// check again, only views which still see the pid count
+		now := map[string]bool{
+			ViewStat:   statAlive(pid),
+			ViewKill:   killAlive(pid),
+			ViewCgroup: cgroup[pid],
+		}
+		h := &HiddenProcess{Pid: strconv.Itoa(pid), Missing: []string{ViewReaddir}}
+		for _, view := range []string{ViewStat, ViewKill, ViewCgroup} {
+			if views[view] && now[view] {
+				h.FoundIn = append(h.FoundIn, view)
+			} else if view != ViewCgroup {
+				h.Missing = append(h.Missing, view)
+			}
+		}
+		// a single cgroup entry of a dead process is stale rather than hidden
+		if len(h.FoundIn) == 0 || (len(h.FoundIn) == 1 && h.FoundIn[0] == ViewCgroup && !now[ViewKill]) {
+			continue
+		}
+		ret = append(ret, h)
+	}
+	return
+}
+
+// PreloadLibraries returns the libraries of /etc/ld.so.preload, which are
+// separated by whitespaces or colons.
+func PreloadLibraries() (ret []string, err error) {
+	b, err := os.ReadFile("/etc/ld.so.preload")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		ret = append(ret, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ':' || r == ' ' || r == '\t'
+		})...)
+	}
+	return
+}
@@ -122,6 +122,30 @@ func Processes(wk bool) (procs []Process, err error) {
 	}
 	return
 }
+
+// Descendants returns pids along with all their descendants among procs.
+func Descendants(procs []Process, pids []string) []string {
+	children := map[string][]string{}
+	for i := range procs {
+		if s, err := procs[i].Stat(); err == nil {
+			children[s.Ppid] = append(children[s.Ppid], procs[i].Pid())
+		}
+	}
+	seen := map[string]bool{}
+	ret := []string{}
+	queue := append([]string{}, pids...)
+	for len(queue) != 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		ret = append(ret, pid)
+		queue = append(queue, children[pid]...)
+	}
+	return ret
+}
 func NewProcess(pid string) (p *Process, err error) {
 	_, err = os.Stat(filepath.Join("/proc", pid))
 	if err != nil {
@@ -37,6 +37,15 @@ const (
 	RPMTAG_DIRNAMES       = 1118
 	RPMTAG_FILEDIGESTS    = 1035
 	RPMTAG_FILEDIGESTALGO = 5011
+	RPMTAG_FILEMODES      = 1030
+	RPMTAG_FILEFLAGS      = 1037
+	RPMTAG_FILEUSERNAME   = 1039
+	RPMTAG_FILEGROUPNAME  = 1040
+
+	// rpmfileAttrs_e
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmfiles.h#L60
+	RPMFILE_CONFIG = 1 << 0
+	RPMFILE_GHOST  = 1 << 6
 
 	// rpmTagType_e
 	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmtag.h#L431
@@ -151,7 +160,22 @@ type RPMEntryInfo struct {
 type FileInfo struct {
 	Path   string
 	Digest string
+	// st_mode including the file type bits
+	Mode  uint16
+	Flags int32
+	User  string
+	Group string
+}
+
+func (f FileInfo) IsConfig() bool {
+	return f.Flags&RPMFILE_CONFIG != 0
 }
+
+// ghost files aren't shipped by the package, so there is nothing to verify
+func (f FileInfo) IsGhost() bool {
+	return f.Flags&RPMFILE_GHOST != 0
+}
+
 type Package struct {
 	Epoch           int32
 	Name            string
@@ -169,10 +193,15 @@ type Package struct {
 type Database struct {
 	metadata HashMetadataPage
 	f        *os.File
+	// set if the database is rpmdb.sqlite
+	sqlite *sqliteFile
 }
 type WalkFunc func(p Package)
 
 func (db *Database) WalkPackages(f WalkFunc) (err error) {
+	if db.sqlite != nil {
+		return db.sqlite.walkPackages(f)
+	}
 	pd := make([]byte, db.metadata.PageSize)
 	for pno := 0; pno < int(db.metadata.LastPageNo); pno++ {
 		_, err = io.ReadFull(db.f, pd)
@@ -251,126 +280,10 @@ func (db *Database) WalkPackages(f WalkFunc) (err error) {
 				}
 				spno = shdr.NextPageNo
 			}
-			var il, dl uint32
-			err = binary.Read(buf, binary.BigEndian, &il)
-			if err != nil {
-				return
-			}
-			err = binary.Read(buf, binary.BigEndian, &dl)
-			if err != nil {
+			var p Package
+			if p, err = parseHeader(buf); err != nil {
 				return
 			}
-			eis := make([]RPMEntryInfo, 0, il)
-			for i := 0; i < int(il); i++ {
-				ei := RPMEntryInfo{}
-				err = binary.Read(buf, binary.BigEndian, &ei)
-				if err != nil {
-					return
-				}
-				eis = append(eis, ei)
-			}
-			eis = eis[1:]
-			dt := buf.Bytes()
-			p := Package{}
-			var dirNames, baseNames, digests []string
-			var dirIndexes []int32
-		out:
-			for i, ei := range eis {
-				var edt []byte
-				if i != len(eis)-1 {
-					edt = dt[ei.Offset:eis[i+1].Offset]
-				} else {
-					edt = dt[ei.Offset:dl]
-				}
-				switch ei.Tag {
-				case RPMTAG_DIRINDEXES:
-					if ei.Type != RPM_INT32_TYPE {
-						break out
-					}
-					dirIndexes = decodeInt32Array(edt)
-				case RPMTAG_DIRNAMES:
-					if ei.Type != RPM_STRING_ARRAY_TYPE {
-						break out
-					}
-					dirNames = decodeStringArray(edt)
-				case RPMTAG_BASENAMES:
-					if ei.Type != RPM_STRING_ARRAY_TYPE {
-						break out
-					}
-					baseNames = decodeStringArray(edt)
-				case RPMTAG_NAME:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.Name = string(bytes.TrimRight(edt, "\x00"))
-				case RPMTAG_EPOCH:
-					if ei.Type != RPM_INT32_TYPE {
-						break out
-					}
-					if err := binary.Read(bytes.NewReader(edt), binary.BigEndian, &p.Epoch); err != nil {
-						break out
-					}
-				case RPMTAG_VERSION:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.Version = string(bytes.TrimRight(edt, "\x00"))
-				case RPMTAG_RELEASE:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.Release = string(bytes.TrimRight(edt, "\x00"))
-				case RPMTAG_ARCH:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.Arch = string(bytes.TrimRight(edt, "\x00"))
-				case RPMTAG_SOURCERPM:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.SourceRpm = string(bytes.TrimRight(edt, "\x00"))
-					if p.SourceRpm == "(none)" {
-						p.SourceRpm = ""
-					}
-				case RPMTAG_LICENSE:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.License = string(bytes.TrimRight(edt, "\x00"))
-					if p.License == "(none)" {
-						p.License = ""
-					}
-				case RPMTAG_VENDOR:
-					if ei.Type != RPM_STRING_TYPE {
-						break out
-					}
-					p.Vendor = string(bytes.TrimRight(edt, "\x00"))
-					if p.Vendor == "(none)" {
-						p.Vendor = ""
-					}
-				case RPMTAG_SIZE:
-					if ei.Type != RPM_INT32_TYPE {
-						break out
-					}
-					if err := binary.Read(bytes.NewReader(edt), binary.BigEndian, &p.Size); err != nil {
-						break out
-					}
-				case RPMTAG_FILEDIGESTALGO:
-					if ei.Type != RPM_INT32_TYPE {
-						break out
-					}
-					if err := binary.Read(bytes.NewReader(edt), binary.BigEndian, &p.DigestAlgorithm); err != nil {
-						break out
-					}
-				case RPMTAG_FILEDIGESTS:
-					if ei.Type != RPM_STRING_ARRAY_TYPE {
-						break out
-					}
-					digests = decodeStringArray(edt)
-				}
-			}
-			p.Files = joinFiles(dirNames, baseNames, digests, dirIndexes)
 			f(p)
 		}
 		_, err = db.f.Seek(current, io.SeekStart)
@@ -380,6 +293,173 @@ func (db *Database) WalkPackages(f WalkFunc) (err error) {
 	}
 	return
 }
+
+// parseHeader decodes a package header blob, which is the same in the
+// berkeley db and the sqlite backends.
+func parseHeader(buf *bytes.Buffer) (p Package, err error) {
+	var il, dl uint32
+	err = binary.Read(buf, binary.BigEndian, &il)
+	if err != nil {
+		return
+	}
+	err = binary.Read(buf, binary.BigEndian, &dl)
+	if err != nil {
+		return
+	}
+	// the blob may come from an image, don't trust the counts
+	if il == 0 || int64(il)*RPM_INDEX_ENTRY_SIZE+int64(dl) > int64(buf.Len()) {
+		err = errors.New("invalid header")
+		return
+	}
+	eis := make([]RPMEntryInfo, 0, il)
+	for i := 0; i < int(il); i++ {
+		ei := RPMEntryInfo{}
+		err = binary.Read(buf, binary.BigEndian, &ei)
+		if err != nil {
+			return
+		}
+		eis = append(eis, ei)
+	}
+	eis = eis[1:]
+	dt := buf.Bytes()
+	var dirNames, baseNames, digests, users, groups []string
+	var dirIndexes, flags []int32
+	var modes []uint16
+out:
+	for i, ei := range eis {
+		end := int32(dl)
+		if i != len(eis)-1 {
+			end = eis[i+1].Offset
+		}
+		if ei.Offset < 0 || ei.Offset > end || end > int32(dl) {
+			break out
+		}
+		edt := dt[ei.Offset:end]
+		switch ei.Tag {
+		case RPMTAG_DIRINDEXES:
+			if ei.Type != RPM_INT32_TYPE {
+				break out
+			}
+			dirIndexes = decodeInt32Array(edt)
+		case RPMTAG_DIRNAMES:
+			if ei.Type != RPM_STRING_ARRAY_TYPE {
+				break out
+			}
+			dirNames = decodeStringArray(edt)
+		case RPMTAG_BASENAMES:
+			if ei.Type != RPM_STRING_ARRAY_TYPE {
+				break out
+			}
+			baseNames = decodeStringArray(edt)
+		case RPMTAG_NAME:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.Name = string(bytes.TrimRight(edt, "\x00"))
+		case RPMTAG_EPOCH:
+			if ei.Type != RPM_INT32_TYPE {
+				break out
+			}
+			if err := binary.Read(bytes.NewReader(edt), binary.BigEndian, &p.Epoch); err != nil {
+				break out
+			}
+		case RPMTAG_VERSION:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.Version = string(bytes.TrimRight(edt, "\x00"))
+		case RPMTAG_RELEASE:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.Release = string(bytes.TrimRight(edt, "\x00"))
+		case RPMTAG_ARCH:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.Arch = string(bytes.TrimRight(edt, "\x00"))
+		case RPMTAG_SOURCERPM:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.SourceRpm = string(bytes.TrimRight(edt, "\x00"))
+			if p.SourceRpm == "(none)" {
+				p.SourceRpm = ""
+			}
+		case RPMTAG_LICENSE:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.License = string(bytes.TrimRight(edt, "\x00"))
+			if p.License == "(none)" {
+				p.License = ""
+			}
+		case RPMTAG_VENDOR:
+			if ei.Type != RPM_STRING_TYPE {
+				break out
+			}
+			p.Vendor = string(bytes.TrimRight(edt, "\x00"))
+			if p.Vendor == "(none)" {
+				p.Vendor = ""
+			}
+		case RPMTAG_SIZE:
+			if ei.Type != RPM_INT32_TYPE {
+				break out
+			}
+			if err := binary.Read(bytes.NewReader(edt), binary.BigEndian, &p.Size); err != nil {
+				break out
+			}
+		case RPMTAG_FILEDIGESTALGO:
+			if ei.Type != RPM_INT32_TYPE {
+				break out
+			}
+			if err := binary.Read(bytes.NewReader(edt), binary.BigEndian, &p.DigestAlgorithm); err != nil {
+				break out
+			}
+		case RPMTAG_FILEDIGESTS:
+			if ei.Type != RPM_STRING_ARRAY_TYPE {
+				break out
+			}
+			digests = decodeStringArray(edt)
+		case RPMTAG_FILEMODES:
+			if ei.Type != RPM_INT16_TYPE {
+				break out
+			}
+			modes = decodeInt16Array(edt)
+		case RPMTAG_FILEFLAGS:
+			if ei.Type != RPM_INT32_TYPE {
+				break out
+			}
+			flags = decodeInt32Array(edt)
+		case RPMTAG_FILEUSERNAME:
+			if ei.Type != RPM_STRING_ARRAY_TYPE {
+				break out
+			}
+			users = decodeStringArray(edt)
+		case RPMTAG_FILEGROUPNAME:
+			if ei.Type != RPM_STRING_ARRAY_TYPE {
+				break out
+			}
+			groups = decodeStringArray(edt)
+		}
+	}
+	p.Files = joinFiles(dirNames, baseNames, digests, dirIndexes)
+	for i := range p.Files {
+		if len(modes) > i {
+			p.Files[i].Mode = modes[i]
+		}
+		if len(flags) > i {
+			p.Files[i].Flags = flags[i]
+		}
+		if len(users) > i {
+			p.Files[i].User = users[i]
+		}
+		if len(groups) > i {
+			p.Files[i].Group = groups[i]
+		}
+	}
+	return
+}
 func (db *Database) Close() {
 	db.f.Close()
 }
@@ -402,6 +482,18 @@ func decodeInt32Array(dt []byte) (ret []int32) {
 	}
 	return
 }
+func decodeInt16Array(dt []byte) (ret []uint16) {
+	r := bytes.NewReader(dt)
+	for {
+		i := uint16(0)
+		err := binary.Read(r, binary.BigEndian, &i)
+		if err != nil {
+			break
+		}
+		ret = append(ret, i)
+	}
+	return
+}
 func joinFiles(dirNames, baseNames, digests []string, dirIndexes []int32) []FileInfo {
 	files := []FileInfo{}
 	if len(dirNames) == 0 || len(baseNames) == 0 || len(dirIndexes) == 0 ||
@@ -419,17 +511,42 @@ func joinFiles(dirNames, baseNames, digests []string, dirIndexes []int32) []File
 	}
 	return files
 }
+
+// database files of the rpm backends, sqlite is preferred since the
+// Packages file may be left behind after the database is converted
+var DatabaseFiles = []string{"/var/lib/rpm/rpmdb.sqlite", "/var/lib/rpm/Packages"}
+
 func OpenDatabase() (db *Database, err error) {
+	for _, path := range DatabaseFiles {
+		if db, err = OpenDatabaseFile(path); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// OpenDatabaseFile opens a berkeley db Packages file or a rpmdb.sqlite file.
+func OpenDatabaseFile(path string) (db *Database, err error) {
 	var f *os.File
-	f, err = os.Open("/var/lib/rpm/Packages")
+	f, err = os.Open(path)
 	if err != nil {
 		return
 	}
-	defer func() {
-		if err != nil {
-			f.Close()
-		}
-	}()
+	if db, err = NewDatabase(f); err != nil {
+		f.Close()
+	}
+	return
+}
+
+// NewDatabase reads the database from an opened file, e.g. inside an image
+// layer, which is closed by Close.
+func NewDatabase(f *os.File) (db *Database, err error) {
+	if s, err := openSqlite(f); err == nil {
+		return &Database{f: f, sqlite: s}, nil
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
 	metadata := HashMetadataPage{}
 	err = binary.Read(f, binary.LittleEndian, &metadata)
 	if err != nil {
@@ -448,8 +565,8 @@ func OpenDatabase() (db *Database, err error) {
 		return
 	}
 	db = &Database{
-		metadata,
-		f,
+		metadata: metadata,
+		f:        f,
 	}
 	return
 }
@@ -0,0 +1,310 @@
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// rpm >= 4.16 keeps the headers in rpmdb.sqlite (RHEL9, UBI9, Fedora, AL2023):
+// CREATE TABLE Packages (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)
+// only the table b-tree of the main file is read, a non-empty wal is ignored.
+// ref. https://www.sqlite.org/fileformat2.html
+
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+	sqlitePackages   = "Packages"
+
+	sqliteInteriorTable uint8 = 5
+	sqliteLeafTable     uint8 = 13
+
+	// limits against a corrupted or crafted file
+	sqliteMaxDepth   = 32
+	sqliteMaxPayload = 256 * 1024 * 1024
+)
+
+var ErrInvalidSqlite = errors.New("invalid sqlite database")
+
+type sqliteFile struct {
+	f        *os.File
+	pageSize int
+	// page size without the reserved bytes
+	usable int
+	pages  int
+}
+
+func openSqlite(f *os.File) (*sqliteFile, error) {
+	hdr := make([]byte, sqliteHeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[:16]) != sqliteMagic {
+		return nil, ErrInvalidSqlite
+	}
+	s := &sqliteFile{f: f, pageSize: int(binary.BigEndian.Uint16(hdr[16:18]))}
+	if s.pageSize == 1 {
+		s.pageSize = 65536
+	}
+	if s.pageSize < 512 || s.pageSize&(s.pageSize-1) != 0 {
+		return nil, ErrInvalidSqlite
+	}
+	s.usable = s.pageSize - int(hdr[20])
+	if s.usable < 480 {
+		return nil, ErrInvalidSqlite
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s.pages = int(fi.Size() / int64(s.pageSize))
+	return s, nil
+}
+
+func (s *sqliteFile) page(no uint32) ([]byte, error) {
+	if no == 0 || int(no) > s.pages {
+		return nil, ErrInvalidSqlite
+	}
+	pd := make([]byte, s.pageSize)
+	if _, err := s.f.ReadAt(pd, int64(no-1)*int64(s.pageSize)); err != nil {
+		return nil, err
+	}
+	return pd, nil
+}
+
+// varint returns the value and the length of a sqlite varint
+func varint(b []byte) (v uint64, n int) {
+	for n < 8 && n < len(b) {
+		v = v<<7 | uint64(b[n]&0x7f)
+		if b[n]&0x80 == 0 {
+			return v, n + 1
+		}
+		n++
+	}
+	if n < len(b) {
+		return v<<8 | uint64(b[n]), n + 1
+	}
+	return 0, 0
+}
+
+// walkTable calls f with the payload of every row of a table b-tree
+func (s *sqliteFile) walkTable(root uint32, f func(payload []byte) error) error {
+	return s.walk(root, 0, map[uint32]bool{}, f)
+}
+
+func (s *sqliteFile) walk(no uint32, depth int, seen map[uint32]bool, f func([]byte) error) error {
+	if depth > sqliteMaxDepth || seen[no] {
+		return ErrInvalidSqlite
+	}
+	seen[no] = true
+	pd, err := s.page(no)
+	if err != nil {
+		return err
+	}
+	// the first page starts with the file header
+	off := 0
+	if no == 1 {
+		off = sqliteHeaderSize
+	}
+	if off+8 > len(pd) {
+		return ErrInvalidSqlite
+	}
+	typ := pd[off]
+	cells := int(binary.BigEndian.Uint16(pd[off+3:]))
+	ptrs := off + 8
+	if typ == sqliteInteriorTable {
+		ptrs = off + 12
+	} else if typ != sqliteLeafTable {
+		return ErrInvalidSqlite
+	}
+	if ptrs+cells*2 > len(pd) {
+		return ErrInvalidSqlite
+	}
+	for i := 0; i < cells; i++ {
+		cell := int(binary.BigEndian.Uint16(pd[ptrs+i*2:]))
+		if cell >= s.usable {
+			return ErrInvalidSqlite
+		}
+		if typ == sqliteInteriorTable {
+			if cell+4 > len(pd) {
+				return ErrInvalidSqlite
+			}
+			if err = s.walk(binary.BigEndian.Uint32(pd[cell:]), depth+1, seen, f); err != nil {
+				return err
+			}
+			continue
+		}
+		payload, err := s.payload(pd[:s.usable], cell)
+		if err != nil {
+			return err
+		}
+		if err = f(payload); err != nil {
+			return err
+		}
+	}
+	if typ == sqliteInteriorTable {
+		return s.walk(binary.BigEndian.Uint32(pd[off+8:]), depth+1, seen, f)
+	}
+	return nil
+}
+
+// payload of a table leaf cell, following the overflow pages
+func (s *sqliteFile) payload(pd []byte, cell int) ([]byte, error) {
+	size, n := varint(pd[cell:])
+	if n == 0 || size > sqliteMaxPayload {
+		return nil, ErrInvalidSqlite
+	}
+	cell += n
+	// rowid
+	if _, n = varint(pd[cell:]); n == 0 {
+		return nil, ErrInvalidSqlite
+	}
+	cell += n
+	total := int(size)
+	local := total
+	if max := s.usable - 35; total > max {
+		min := (s.usable-12)*32/255 - 23
+		local = min + (total-min)%(s.usable-4)
+		if local > max {
+			local = min
+		}
+	}
+	if cell+local > len(pd) {
+		return nil, ErrInvalidSqlite
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, total))
+	buf.Write(pd[cell : cell+local])
+	if local == total {
+		return buf.Bytes(), nil
+	}
+	if cell+local+4 > len(pd) {
+		return nil, ErrInvalidSqlite
+	}
+	next := binary.BigEndian.Uint32(pd[cell+local:])
+	for seen := 0; buf.Len() < total; seen++ {
+		if seen > s.pages {
+			return nil, ErrInvalidSqlite
+		}
+		op, err := s.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := op[4:s.usable]
+		if left := total - buf.Len(); left < len(chunk) {
+			chunk = chunk[:left]
+		}
+		buf.Write(chunk)
+		next = binary.BigEndian.Uint32(op)
+	}
+	return buf.Bytes(), nil
+}
+
+// record decodes the columns of a record, integers as uint64 and text or blob as []byte
+func record(payload []byte) ([]interface{}, error) {
+	hdrSize, n := varint(payload)
+	if n == 0 || hdrSize > uint64(len(payload)) {
+		return nil, ErrInvalidSqlite
+	}
+	var types []uint64
+	for off := n; off < int(hdrSize); {
+		t, n := varint(payload[off:int(hdrSize)])
+		if n == 0 {
+			return nil, ErrInvalidSqlite
+		}
+		types = append(types, t)
+		off += n
+	}
+	cols := make([]interface{}, 0, len(types))
+	data := payload[hdrSize:]
+	for _, t := range types {
+		var size int
+		switch {
+		case t == 0, t == 8, t == 9:
+		case t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t >= 12:
+			size = int((t - 12) / 2)
+		default:
+			return nil, ErrInvalidSqlite
+		}
+		if size > len(data) {
+			return nil, ErrInvalidSqlite
+		}
+		switch {
+		case t >= 12:
+			cols = append(cols, data[:size])
+		case t == 8, t == 9:
+			cols = append(cols, t-8)
+		case t >= 1 && t <= 6:
+			var v uint64
+			for _, b := range data[:size] {
+				v = v<<8 | uint64(b)
+			}
+			cols = append(cols, v)
+		default:
+			cols = append(cols, nil)
+		}
+		data = data[size:]
+	}
+	return cols, nil
+}
+
+// tableRoot finds the root page of a table in sqlite_schema
+func (s *sqliteFile) tableRoot(name string) (root uint32, err error) {
+	errFound := errors.New("found")
+	err = s.walkTable(1, func(payload []byte) error {
+		// type, name, tbl_name, rootpage, sql
+		cols, err := record(payload)
+		if err != nil || len(cols) < 4 {
+			return err
+		}
+		typ, _ := cols[0].([]byte)
+		tbl, _ := cols[1].([]byte)
+		page, _ := cols[3].(uint64)
+		if string(typ) == "table" && string(tbl) == name && page > 0 {
+			root = uint32(page)
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return root, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("table %s not found", name)
+	}
+	return 0, err
+}
+
+func (s *sqliteFile) walkPackages(f WalkFunc) error {
+	root, err := s.tableRoot(sqlitePackages)
+	if err != nil {
+		return err
+	}
+	return s.walkTable(root, func(payload []byte) error {
+		// hnum is the rowid and stored as null
+		cols, err := record(payload)
+		if err != nil {
+			return err
+		}
+		if len(cols) < 2 {
+			return nil
+		}
+		blob, ok := cols[1].([]byte)
+		if !ok {
+			return nil
+		}
+		p, err := parseHeader(bytes.NewBuffer(blob))
+		if err != nil {
+			return err
+		}
+		f(p)
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package rpm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/rpmdb.sqlite has the schema of rpm with a page size of 1024, 40
+// small packages pkg00-pkg39 spread over interior and leaf pages, and bigpkg
+// with 600 files whose header overflows to other pages.
+func TestSqliteDatabase(t *testing.T) {
+	db, err := OpenDatabaseFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	pkgs := map[string]Package{}
+	if err = db.WalkPackages(func(p Package) {
+		pkgs[p.Name] = p
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 41 {
+		t.Errorf("got %d packages, want 41", len(pkgs))
+	}
+	for i := 0; i < 40; i++ {
+		p := pkgs[fmt.Sprintf("pkg%02d", i)]
+		if p.Version != fmt.Sprintf("1.%d", i) || p.Release != "1.el9" || p.Arch != "x86_64" || p.Vendor != "Elkeid" {
+			t.Errorf("unexpected package %+v", p)
+		}
+	}
+	big := pkgs["bigpkg"]
+	if len(big.Files) != 600 || big.Files[599].Path != "/usr/bin/file0599" {
+		t.Errorf("bigpkg has %d files", len(big.Files))
+	}
+}
+
+func TestSqliteInvalid(t *testing.T) {
+	data, err := os.ReadFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, change := range map[string]func([]byte) []byte{
+		"truncated": func(b []byte) []byte { return b[:3000] },
+		"page loop": func(b []byte) []byte {
+			// the right child of an interior page points to itself
+			for i := 1024; i < len(b); i += 1024 {
+				if b[i] == sqliteInteriorTable {
+					b[i+8], b[i+9], b[i+10], b[i+11] = 0, 0, 0, byte(i/1024+1)
+				}
+			}
+			return b
+		},
+		"bad page size": func(b []byte) []byte {
+			b[16], b[17] = 0, 100
+			return b
+		},
+	} {
+		path := filepath.Join(dir, name)
+		if err = os.WriteFile(path, change(append([]byte{}, data...)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		db, err := OpenDatabaseFile(path)
+		if err != nil {
+			continue
+		}
+		if err = db.WalkPackages(func(Package) {}); err == nil {
+			t.Errorf("%s: WalkPackages() expected error", name)
+		}
+		db.Close()
+	}
+}
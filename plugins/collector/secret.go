@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/container"
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/secret"
+	plugins "github.com/bytedance/plugins"
+	"github.com/juju/ratelimit"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+)
+
+const secretConfigPath = "secret.json"
+
+const (
+	// io budget of a run shared by all files, in bytes per second
+	defaultSecretRate = 1024 * 1024
+	// files scanned in a root at most
+	defaultSecretMaxFiles = 10000
+	// depth of dirs walked under a scanned dir
+	secretMaxDepth = 4
+)
+
+var (
+	// dirs holding served files, any config-like file in them is scanned
+	secretWebRoots = []string{"/var/www", "/usr/share/nginx/html", "/srv", "/app"}
+
+	errSecretMaxFiles = errors.New("too many files")
+)
+
+// SecretConfig is pushed by the manager as the data of a 5068 task, and
+// persisted so it survives plugin restarts.
+type SecretConfig struct {
+	// extra dirs scanned like web roots
+	Paths []string `json:"paths"`
+	// scan the host only
+	DisableContainer bool  `json:"disable_container"`
+	Rate             int64 `json:"rate"`
+	MaxFiles         int   `json:"max_files"`
+}
+
+func (cfg *SecretConfig) validate() error {
+	for _, p := range cfg.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("secret path %q isn't absolute", p)
+		}
+	}
+	if cfg.Rate < 0 || cfg.MaxFiles < 0 {
+		return errors.New("negative rate or max_files")
+	}
+	return nil
+}
+
+type Secret struct {
+	Rule          string `mapstructure:"rule"`
+	Description   string `mapstructure:"description"`
+	Severity      string `mapstructure:"severity"`
+	Path          string `mapstructure:"path"`
+	Line          string `mapstructure:"line"`
+	Match         string `mapstructure:"match"`
+	ModifyTime    string `mapstructure:"modify_time"`
+	ContainerID   string `mapstructure:"container_id"`
+	ContainerName string `mapstructure:"container_name"`
+}
+
+// SecretHandler scans home dirs (shell histories, cloud cli credentials,
+// .env and .git/config files), web roots and extra paths of the host and of
+// running containers for leaked credentials. Secrets are masked before being
+// reported, and reading is limited by an io budget.
+type SecretHandler struct {
+	once sync.Once
+	mu   sync.Mutex
+	cfg  SecretConfig
+}
+
+func (h *SecretHandler) Name() string {
+	return "secret"
+}
+func (h *SecretHandler) DataType() int {
+	return 5068
+}
+
+func (h *SecretHandler) Configure(data string) (err error) {
+	cfg := SecretConfig{}
+	if err = json.Unmarshal([]byte(data), &cfg); err != nil {
+		return
+	}
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	h.load()
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+	return os.WriteFile(secretConfigPath, []byte(data), 0600)
+}
+
+func (h *SecretHandler) load() {
+	h.once.Do(func() {
+		if data, err := os.ReadFile(secretConfigPath); err == nil {
+			cfg := SecretConfig{}
+			if err = json.Unmarshal(data, &cfg); err == nil {
+				err = cfg.validate()
+			}
+			if err != nil {
+				zap.S().Warn("invalid secret config: ", err.Error())
+			} else {
+				h.cfg = cfg
+			}
+		}
+	})
+}
+
+// secretScan is a run over the roots of the host and containers
+type secretScan struct {
+	c      *plugins.Client
+	seq    string
+	f      *engine.Filter
+	scope  string
+	bucket *ratelimit.Bucket
+	max    int
+	// root of the filesystem, / or /proc/<pid>/root
+	root          string
+	containerID   string
+	containerName string
+	files         int
+}
+
+// dir checks that root+path is a dir without symlinks of any component,
+// since absolute links of a container root would resolve on the host.
+func (s *secretScan) dir(path string) error {
+	dir := s.root
+	for _, p := range strings.Split(strings.Trim(path, "/"), "/") {
+		if p == "" {
+			continue
+		}
+		dir = filepath.Join(dir, p)
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return syscall.ENOTDIR
+		}
+	}
+	return nil
+}
+
+func (s *secretScan) open(path string) (*os.File, os.FileInfo, error) {
+	if err := s.dir(filepath.Dir(path)); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.root, path), os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() > secret.MaxFileSize {
+		f.Close()
+		return nil, nil, errors.New("not a regular file or too large")
+	}
+	return f, fi, nil
+}
+
+func (s *secretScan) scan(path string) {
+	if s.f != nil && !s.f.MatchPath(path) {
+		return
+	}
+	if s.files >= s.max {
+		return
+	}
+	f, fi, err := s.open(path)
+	if err != nil {
+		return
+	}
+	s.files++
+	findings, err := secret.Scan(ratelimit.Reader(f, s.bucket))
+	f.Close()
+	if err != nil && !errors.Is(err, secret.ErrBinary) {
+		zap.S().Warnf("scan %s: %s", path, err.Error())
+	}
+	for _, finding := range findings {
+		rec := &plugins.Record{
+			DataType:  5068,
+			Timestamp: time.Now().Unix(),
+			Data: &plugins.Payload{
+				Fields: make(map[string]string, 12),
+			},
+		}
+		mapstructure.Decode(&Secret{
+			Rule:          finding.Rule.ID,
+			Description:   finding.Rule.Description,
+			Severity:      finding.Rule.Severity,
+			Path:          path,
+			Line:          strconv.Itoa(finding.Line),
+			Match:         finding.Match,
+			ModifyTime:    strconv.FormatInt(fi.ModTime().Unix(), 10),
+			ContainerID:   s.containerID,
+			ContainerName: s.containerName,
+		}, &rec.Data.Fields)
+		rec.Data.Fields["package_seq"] = s.seq
+		if s.f != nil {
+			s.f.Tag(rec.Data.Fields, s.scope)
+		}
+		s.c.SendRecord(rec)
+	}
+}
+
+// walk scans candidates under a dir, config-like files as well for web roots
+func (s *secretScan) walk(dir string, web bool) {
+	if s.f != nil && !s.f.MatchPath(dir) && !strings.HasPrefix(s.f.Path, strings.TrimSuffix(dir, "/")+"/") {
+		return
+	}
+	if s.dir(dir) != nil {
+		return
+	}
+	root := filepath.Join(s.root, dir)
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel := filepath.Join(dir, strings.TrimPrefix(p, root))
+		if d.IsDir() {
+			if p == root {
+				return nil
+			}
+			if secret.SkipDirs[d.Name()] || strings.Count(rel, "/")-strings.Count(dir, "/") >= secretMaxDepth {
+				return filepath.SkipDir
+			}
+			if s.f != nil && !s.f.MatchPath(rel) && !strings.HasPrefix(s.f.Path, rel+"/") {
+				return filepath.SkipDir
+			}
+			// only the config of a repository
+			if d.Name() == ".git" {
+				s.scan(filepath.Join(rel, "config"))
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if s.files >= s.max {
+			return errSecretMaxFiles
+		}
+		if d.Type().IsRegular() && secret.Candidate(rel, web) {
+			s.scan(rel)
+		}
+		return nil
+	})
+}
+
+func (s *secretScan) run(paths []string) {
+	homes := []string{"/root"}
+	if entries, err := os.ReadDir(filepath.Join(s.root, "home")); err == nil {
+		for _, e := range entries {
+			if e.IsDir() {
+				homes = append(homes, filepath.Join("/home", e.Name()))
+			}
+		}
+	}
+	for _, home := range homes {
+		for _, name := range secret.HomeFiles {
+			s.scan(filepath.Join(home, name))
+		}
+		s.walk(home, false)
+	}
+	for _, dir := range secretWebRoots {
+		s.walk(dir, true)
+	}
+	for _, dir := range paths {
+		s.walk(dir, true)
+	}
+}
+
+func (h *SecretHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.handle(c, seq, nil)
+}
+
+// HandleFilter supports container_id and path.
+func (h *SecretHandler) HandleFilter(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) error {
+	if err := f.Check(secretFilterScope); err != nil {
+		return err
+	}
+	h.handle(c, seq, f)
+	return nil
+}
+
+var secretFilterScope = engine.FilterScope{Container: "container_id", Path: "path"}
+
+func (h *SecretHandler) handle(c *plugins.Client, seq string, f *engine.Filter) {
+	h.load()
+	h.mu.Lock()
+	cfg := h.cfg
+	h.mu.Unlock()
+	if cfg.Rate == 0 {
+		cfg.Rate = defaultSecretRate
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = defaultSecretMaxFiles
+	}
+	s := &secretScan{
+		c:      c,
+		seq:    seq,
+		f:      f,
+		bucket: ratelimit.NewBucketWithRate(float64(cfg.Rate), cfg.Rate),
+		max:    cfg.MaxFiles,
+		root:   "/",
+	}
+	if f != nil {
+		s.scope = f.Scope(secretFilterScope)
+	}
+	if f == nil || f.ContainerID == "" {
+		s.run(cfg.Paths)
+	}
+	if cfg.DisableContainer {
+		return
+	}
+	for _, client := range container.NewClients() {
+		ctrs, err := client.ListContainers(context.Background())
+		client.Close()
+		if err != nil {
+			continue
+		}
+		for _, ctr := range ctrs {
+			if ctr.Pid == "" || ctr.Pid == "0" || (f != nil && !f.MatchContainer(ctr.ID)) {
+				continue
+			}
+			s.root = filepath.Join("/proc", ctr.Pid, "root")
+			s.containerID = ctr.ID
+			s.containerName = ctr.Name
+			s.files = 0
+			s.run(cfg.Paths)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	MaxFileSize = 4 * 1024 * 1024
+	// longer lines are mostly minified or binary data, the rest of the file is skipped
+	MaxLineSize = 64 * 1024
+	// findings of a file at most
+	MaxFindings = 20
+	// length of the reported match at most
+	MaxMatchSize = 128
+)
+
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// Rule detects a kind of secret in a line.
+type Rule struct {
+	ID          string
+	Description string
+	Severity    string
+	// lowercase substrings of which one must be in the line, a cheap prefilter
+	Keywords []string
+	Regex    *regexp.Regexp
+	// index of the submatch holding the secret, which is masked and checked
+	// against Entropy, 0 if the match isn't a secret itself, e.g. pem headers
+	Secret int
+	// minimum shannon entropy of the secret in bits per char
+	Entropy float64
+}
+
+var Rules = []*Rule{
+	{
+		ID:          "private_key",
+		Description: "Private key",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"private key"},
+		Regex:       regexp.MustCompile(`-----BEGIN (?:RSA |DSA |EC |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY(?: BLOCK)?-----`),
+	},
+	{
+		ID:          "aws_access_key_id",
+		Description: "AWS access key id",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"akia", "asia"},
+		Regex:       regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`),
+		Secret:      1,
+		Entropy:     3,
+	},
+	{
+		ID:          "aws_secret_access_key",
+		Description: "AWS secret access key",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"aws"},
+		Regex:       regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`),
+		Secret:      1,
+		Entropy:     4,
+	},
+	{
+		ID:          "gcp_api_key",
+		Description: "Google cloud api key",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"aiza"},
+		Regex:       regexp.MustCompile(`\b(AIza[0-9A-Za-z_\-]{35})\b`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "alibaba_access_key_id",
+		Description: "Alibaba cloud access key id",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"ltai"},
+		Regex:       regexp.MustCompile(`\b(LTAI[0-9A-Za-z]{12,20})\b`),
+		Secret:      1,
+		Entropy:     3,
+	},
+	{
+		ID:          "tencent_secret_id",
+		Description: "Tencent cloud secret id",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"akid"},
+		Regex:       regexp.MustCompile(`\b(AKID[0-9A-Za-z]{32})\b`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "github_token",
+		Description: "GitHub token",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"ghp_", "gho_", "ghu_", "ghs_", "ghr_", "github_pat_"},
+		Regex:       regexp.MustCompile(`\b((?:ghp|gho|ghu|ghs|ghr)_[0-9A-Za-z]{36}|github_pat_[0-9A-Za-z_]{82})\b`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "gitlab_token",
+		Description: "GitLab personal access token",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"glpat-"},
+		Regex:       regexp.MustCompile(`\b(glpat-[0-9A-Za-z_\-]{20})\b`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "slack_token",
+		Description: "Slack token",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"xox"},
+		Regex:       regexp.MustCompile(`\b(xox[baprs]-[0-9A-Za-z\-]{10,72})\b`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "kube_client_key",
+		Description: "Kubernetes client key",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"client-key-data"},
+		Regex:       regexp.MustCompile(`client-key-data:\s*["']?([A-Za-z0-9+/=]{40,})`),
+		Secret:      1,
+		Entropy:     4,
+	},
+	{
+		ID:          "docker_auth",
+		Description: "Docker registry credential",
+		Severity:    SeverityHigh,
+		Keywords:    []string{`"auth"`},
+		Regex:       regexp.MustCompile(`"auth"\s*:\s*"([A-Za-z0-9+/=]{16,})"`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "db_uri",
+		Description: "Database uri with password",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"://"},
+		Regex:       regexp.MustCompile(`\b(?:mysql|postgres(?:ql)?|mongodb(?:\+srv)?|rediss?|amqps?|mssql|sqlserver|jdbc:[a-z]+)://[^\s:@/"']*:([^\s@/"']+)@[^\s"'<>]+`),
+		Secret:      1,
+		Entropy:     2.5,
+	},
+	{
+		ID:          "cli_password",
+		Description: "Password in command line",
+		Severity:    SeverityHigh,
+		Keywords:    []string{"mysql", "sshpass", "redis-cli"},
+		Regex:       regexp.MustCompile(`\b(?:mysql\w*\s[^\n]*?\s(?:-p|--password=)|sshpass\s+-p\s*|redis-cli\s[^\n]*?\s-a\s+)['"]?([^\s'"]{6,})`),
+		Secret:      1,
+		Entropy:     2.5,
+	},
+	{
+		ID:          "jwt",
+		Description: "JSON web token",
+		Severity:    SeverityMedium,
+		Keywords:    []string{"eyj"},
+		Regex:       regexp.MustCompile(`\b(eyJ[A-Za-z0-9_\-]{10,}\.eyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,})`),
+		Secret:      1,
+		Entropy:     4,
+	},
+	{
+		ID:          "generic_api_key",
+		Description: "Generic api key or token",
+		Severity:    SeverityMedium,
+		Keywords:    []string{"secret", "key", "token"},
+		Regex:       regexp.MustCompile(`(?i)(?:secret_?key|access_?key_?secret|client_?secret|api_?key|access_?token|auth_?token)["']?\s*[:=]\s*["']?([0-9A-Za-z/+_\-=.]{16,})`),
+		Secret:      1,
+		Entropy:     3.5,
+	},
+	{
+		ID:          "password",
+		Description: "Password assignment",
+		Severity:    SeverityMedium,
+		Keywords:    []string{"pass", "pwd"},
+		Regex:       regexp.MustCompile(`(?i)(?:password|passwd|pwd)["']?\s*[:=]\s*["']?([^\s"'<>;,]{8,})`),
+		Secret:      1,
+		Entropy:     2.5,
+	},
+}
+
+// Finding is a secret found in a line.
+type Finding struct {
+	Rule *Rule
+	Line int
+	// the match with the secret masked
+	Match string
+}
+
+// Entropy returns the shannon entropy of s in bits per char.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	e := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// Mask hides a secret but a few chars of long ones, the length isn't kept.
+func Mask(s string) string {
+	if len(s) < 16 {
+		return "******"
+	}
+	return s[:4] + "******" + s[len(s)-2:]
+}
+
+// values which are placeholders, variables or code rather than secrets
+var placeholders = []string{"example", "xxxx", "****", "changeme", "placeholder", "dummy", "your", "{{", "<", "("}
+
+func isPlaceholder(s string) bool {
+	l := strings.ToLower(s)
+	if l == "password" || l == "passwd" || strings.HasPrefix(l, "$") || strings.HasPrefix(l, "%") {
+		return true
+	}
+	for _, p := range placeholders {
+		if strings.Contains(l, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanLine returns the secrets in a line along with their secret parts.
+func ScanLine(line string) (findings []Finding, secrets []string) {
+	lower := strings.ToLower(line)
+	for _, r := range Rules {
+		ok := false
+		for _, k := range r.Keywords {
+			if strings.Contains(lower, k) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		for _, m := range r.Regex.FindAllStringSubmatchIndex(line, -1) {
+			match := line[m[0]:m[1]]
+			secret := ""
+			if r.Secret != 0 && m[2*r.Secret] >= 0 {
+				secret = line[m[2*r.Secret]:m[2*r.Secret+1]]
+				if Entropy(secret) < r.Entropy || isPlaceholder(secret) {
+					continue
+				}
+				match = line[m[0]:m[2*r.Secret]] + Mask(secret) + line[m[2*r.Secret+1]:m[1]]
+			}
+			if len(match) > MaxMatchSize {
+				match = match[:MaxMatchSize]
+			}
+			findings = append(findings, Finding{Rule: r, Match: match})
+			secrets = append(secrets, secret)
+		}
+	}
+	return
+}
+
+var ErrBinary = errors.New("binary file")
+
+// Scan reads a text file for secrets, the same secret of a rule is only
+// reported once. Binary files return ErrBinary.
+func Scan(r io.Reader) ([]Finding, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(512); bytes.IndexByte(head, 0) != -1 {
+		return nil, ErrBinary
+	}
+	s := bufio.NewScanner(br)
+	s.Buffer(make([]byte, 4096), MaxLineSize)
+	seen := map[string]bool{}
+	ret := []Finding{}
+	for n := 1; s.Scan(); n++ {
+		findings, secrets := ScanLine(s.Text())
+		for i := range findings {
+			key := findings[i].Rule.ID + "\x00" + secrets[i]
+			if secrets[i] == "" {
+				key += findings[i].Match
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			findings[i].Line = n
+			ret = append(ret, findings[i])
+			if len(ret) >= MaxFindings {
+				return ret, nil
+			}
+		}
+	}
+	if err := s.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return ret, err
+	}
+	return ret, nil
+}
+
+var (
+	// files under home dirs
+	HomeFiles = []string{
+		".bash_history", ".zsh_history", ".sh_history", ".ash_history", ".history",
+		".mysql_history", ".psql_history", ".rediscli_history", ".python_history",
+		".netrc", ".pgpass", ".my.cnf", ".git-credentials", ".s3cfg", ".boto",
+		".aws/credentials", ".aws/config",
+		".config/gcloud/application_default_credentials.json",
+		".azure/accessTokens.json",
+		".aliyun/config.json",
+		".tccli/default.credential",
+		".kube/config",
+		".docker/config.json",
+	}
+	configNames = map[string]bool{
+		"wp-config.php": true, "config.php": true, "settings.py": true, "local_settings.py": true,
+		"application.properties": true, "application.yml": true, "application.yaml": true,
+		"appsettings.json": true, "database.yml": true, "credentials.json": true, "docker-compose.yml": true,
+		"docker-compose.yaml": true,
+	}
+	configExts = map[string]bool{
+		".php": true, ".inc": true, ".yml": true, ".yaml": true, ".json": true, ".ini": true, ".conf": true,
+		".properties": true, ".xml": true, ".toml": true, ".cfg": true, ".config": true,
+	}
+	// dirs of dependencies and caches, which are large and rarely hold secrets of the host
+	SkipDirs = map[string]bool{
+		"node_modules": true, ".cache": true, ".npm": true, ".m2": true, ".gradle": true, ".cargo": true,
+		".rustup": true, "site-packages": true, "dist-packages": true, ".venv": true, "vendor": true,
+	}
+)
+
+// Candidate tells whether a file found in a scanned dir may carry secrets by
+// its path: .env files, .git/config, keys and well-known config files, or
+// config-like files anywhere for web roots.
+func Candidate(path string, web bool) bool {
+	name := filepath.Base(path)
+	if name == ".env" || strings.HasPrefix(name, ".env.") || configNames[name] {
+		return true
+	}
+	if name == "config" && filepath.Base(filepath.Dir(path)) == ".git" {
+		return true
+	}
+	ext := filepath.Ext(name)
+	if ext == ".pem" || ext == ".key" {
+		return true
+	}
+	return web && configExts[ext]
+}
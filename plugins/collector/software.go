@@ -31,6 +31,8 @@ var (
 
 type SoftwareHandler struct{}
 
+var softwareFilterScope = engine.FilterScope{Pid: "pid", Container: "container_id", Package: "name"}
+
 func (h *SoftwareHandler) Name() string {
 	return "software"
 }
@@ -91,7 +93,7 @@ func parseJarFilename(fn string) (n, v string) {
 	}
 	return
 }
-func findJar(c *plugins.Client, rec *plugins.Record, r *zip.Reader, n string) {
+func findJar(send func(*plugins.Record), rec *plugins.Record, r *zip.Reader, n string) {
 	// filename
 	name, version := parseJarFilename(filepath.Base(n[:len(n)-4]))
 	r.WalkFiles(func(f *zip.File) {
@@ -99,7 +101,7 @@ func findJar(c *plugins.Client, rec *plugins.Record, r *zip.Reader, n string) {
 			rec.Data.Fields["name"], rec.Data.Fields["sversion"] = parseJarFilename(filepath.Base(f.Name[:len(f.Name)-4]))
 			rec.Data.Fields["path"] = filepath.Join(r.Name(), f.Name)
 			rec.Timestamp = time.Now().Unix()
-			c.SendRecord(rec)
+			send(rec)
 		}
 		// 补全jar包版本
 		if version == "" && f.Name == "META-INF/MANIFEST.MF" {
@@ -118,49 +120,208 @@ func findJar(c *plugins.Client, rec *plugins.Record, r *zip.Reader, n string) {
 	rec.Data.Fields["sversion"] = version
 	rec.Data.Fields["path"] = r.Name()
 	rec.Timestamp = time.Now().Unix()
-	c.SendRecord(rec)
+	send(rec)
+}
+
+// parses the paragraphs of a dpkg status file
+func walkDpkgStatus(r io.Reader, f func(s *Software)) {
+	s := bufio.NewScanner(io.LimitReader(r, 25*1024*1024))
+	s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := strings.Index(string(data), "\nPackage: "); i >= 0 {
+			return i + 1, data[0:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return
+	})
+	for s.Scan() {
+		lines := strings.Split(s.Text(), "\n")
+		sw := &Software{
+			Type: "dpkg",
+		}
+		for _, line := range lines {
+			fields := strings.SplitN(line, ": ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			switch fields[0] {
+			case "Package":
+				sw.Name = fields[1]
+			case "Version":
+				sw.Version = fields[1]
+			case "Source":
+				sw.Source = fields[1]
+			case "Status":
+				sw.Status = fields[1]
+			}
+		}
+		f(sw)
+	}
+}
+
+// parses the paragraphs of an apk installed database
+func walkApkInstalled(r io.Reader, f func(s *Software)) {
+	s := bufio.NewScanner(io.LimitReader(r, 25*1024*1024))
+	var sw *Software
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			if sw != nil && sw.Name != "" {
+				f(sw)
+			}
+			sw = nil
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		if sw == nil {
+			sw = &Software{
+				Type: "apk",
+			}
+		}
+		switch line[0] {
+		case 'P':
+			sw.Name = line[2:]
+		case 'V':
+			sw.Version = line[2:]
+		case 'o':
+			sw.Source = line[2:]
+		case 'm':
+			sw.Vendor = line[2:]
+		}
+	}
+	if sw != nil && sw.Name != "" {
+		f(sw)
+	}
 }
 
 func (h *SoftwareHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.handle(c, cache, seq, nil)
+}
+
+// HandleFilter supports package names, pids (with tree) and container_id,
+// the latter two only apply to jar packages of java processes.
+func (h *SoftwareHandler) HandleFilter(c *plugins.Client, cache *engine.Cache, seq string, f *engine.Filter) error {
+	if err := f.Check(softwareFilterScope); err != nil {
+		return err
+	}
+	h.handle(c, cache, seq, f)
+	return nil
+}
+
+func (h *SoftwareHandler) handle(c *plugins.Client, cache *engine.Cache, seq string, filter *engine.Filter) {
 	currentTime := time.Now().Unix()
         formattedCurrent := utils.FormatTimestamp(currentTime)
-	// scan dpkg
-	if f, err := os.Open("/var/lib/dpkg/status"); err == nil {
-		s := bufio.NewScanner(io.LimitReader(f, 25*1024*1024))
-		s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-			if atEOF && len(data) == 0 {
-				return 0, nil, nil
+	var scope string
+	if filter != nil {
+		scope = filter.Scope(softwareFilterScope)
+	}
+	send := func(r *plugins.Record) {
+		if filter != nil {
+			if !filter.MatchPackage(r.Data.Fields["name"]) ||
+				!filter.MatchPid(r.Data.Fields["pid"]) ||
+				!filter.MatchContainer(r.Data.Fields["container_id"]) {
+				return
 			}
-			if i := strings.Index(string(data), "\nPackage: "); i >= 0 {
-				return i + 1, data[0:i], nil
+			filter.Tag(r.Data.Fields, scope)
+		}
+		c.SendRecord(r)
+	}
+	// packages of the host don't match pids or containers
+	if filter == nil || (len(filter.Pids) == 0 && filter.ContainerID == "") {
+		h.handleHost(send, seq, formattedCurrent)
+	}
+	// scan jar
+	procs, err := process.Processes(false)
+	if err != nil {
+		return
+	}
+	if filter != nil && filter.Tree && len(filter.Pids) != 0 {
+		expanded := *filter
+		expanded.Pids = process.Descendants(procs, filter.Pids)
+		expanded.Tree = false
+		filter = &expanded
+	}
+	for _, p := range procs {
+		if filter != nil && !filter.MatchPid(p.Pid()) {
+			continue
+		}
+		time.Sleep(process.TraversalInterval)
+		if cm, err := p.Comm(); err == nil && cm == "java" {
+			var podName, psm, containerID, containerName, cmdline string
+			if pns, err := p.Namespace("pid"); err == nil && process.PnsDiffWithRpns(pns) {
+				if envs, err := p.Envs(); err == nil {
+					if p, ok := envs["POD_NAME"]; ok {
+						podName = p
+					} else if p, ok := envs["MY_POD_NAME"]; ok {
+						podName = p
+					}
+					if p, ok := envs["LOAD_SERVICE_PSM"]; ok {
+						psm = p
+					} else if p, ok := envs["TCE_PSM"]; ok {
+						psm = p
+					} else if p, ok := envs["RUNTIME_PSM"]; ok {
+						psm = p
+					}
+				}
+				if m, ok := cache.Get(5056, "pns"+pns); ok {
+					containerID = m["container_id"]
+					containerName = m["container_name"]
+				}
 			}
-			if atEOF {
-				return len(data), data, nil
+			if filter != nil && !filter.MatchContainer(containerID) {
+				continue
 			}
-			return
-		})
-		for s.Scan() {
-			lines := strings.Split(s.Text(), "\n")
-			s := &Software{
-				Seq: formattedCurrent,
-				Type: "dpkg",
+			cmdline, _ = p.Cmdline()
+			rec := &plugins.Record{
+				DataType:  int32(h.DataType()),
+				Timestamp: time.Now().Unix(),
+				Data: &plugins.Payload{
+					Fields: map[string]string{
+						"seq": formattedCurrent,
+						"type":           "jar",
+						"psm":            psm,
+						"pod_name":       podName,
+						"container_id":   containerID,
+						"container_name": containerName,
+						"cmdline":        cmdline,
+						"pid":            p.Pid(),
+						"package_seq":    seq,
+					},
+				},
 			}
-			for _, line := range lines {
-				fields := strings.SplitN(line, ": ", 2)
-				if len(fields) != 2 {
-					continue
-				}
-				switch fields[0] {
-				case "Package":
-					s.Name = fields[1]
-				case "Version":
-					s.Version = fields[1]
-				case "Source":
-					s.Source = fields[1]
-				case "Status":
-					s.Status = fields[1]
+			if fs, err := p.Fds(); err == nil {
+				set := mapset.NewSet()
+				for _, fn := range fs {
+					if filepath.Ext(fn) == ".jar" {
+						if set.Contains(fn) ||
+							(filepath.Base(fn) != "rt.jar" &&
+								(strings.Contains(fn, "jdk") || strings.Contains(fn, "jre"))) {
+							continue
+						}
+						if r, err := zip.OpenReader(filepath.Join("/proc", p.Pid(), "root", fn)); err == nil {
+							findJar(send, rec, r, fn)
+							r.Close()
+						}
+						set.Add(fn)
+					}
 				}
 			}
+		}
+	}
+}
+
+// handleHost scans dpkg, rpm and pypi packages of the host
+func (h *SoftwareHandler) handleHost(send func(*plugins.Record), seq, formattedCurrent string) {
+	// scan dpkg
+	if f, err := os.Open("/var/lib/dpkg/status"); err == nil {
+		walkDpkgStatus(f, func(s *Software) {
+			s.Seq = formattedCurrent
 			r := &plugins.Record{
 				DataType:  int32(h.DataType()),
 				Timestamp: time.Now().Unix(),
@@ -170,14 +331,14 @@ func (h *SoftwareHandler) Handle(c *plugins.Client, cache *engine.Cache, seq str
 			}
 			mapstructure.Decode(s, &r.Data.Fields)
 			r.Data.Fields["package_seq"] = seq
-			c.SendRecord(r)
-		}
+			send(r)
+		})
 		f.Close()
 	}
 	// scan rpm
 	if db, err := rpm.OpenDatabase(); err == nil {
 		db.WalkPackages(func(p rpm.Package) {
-			c.SendRecord(&plugins.Record{
+			send(&plugins.Record{
 				DataType:  int32(h.DataType()),
 				Timestamp: time.Now().Unix(),
 				Data: &plugins.Payload{
@@ -222,7 +383,7 @@ func (h *SoftwareHandler) Handle(c *plugins.Client, cache *engine.Cache, seq str
 					}
 					mapstructure.Decode(s, &r.Data.Fields)
 					r.Data.Fields["package_seq"] = seq
-					c.SendRecord(r)
+					send(r)
 				}
 			}
 			return nil
@@ -243,77 +404,11 @@ func (h *SoftwareHandler) Handle(c *plugins.Client, cache *engine.Cache, seq str
 						}
 						mapstructure.Decode(s, &r.Data.Fields)
 						r.Data.Fields["package_seq"] = seq
-						c.SendRecord(r)
+						send(r)
 					}
 				}
 				return nil
 			}})
 		return false
 	})
-	// scan jar
-	procs, err := process.Processes(false)
-	if err != nil {
-		return
-	}
-	for _, p := range procs {
-		time.Sleep(process.TraversalInterval)
-		if cm, err := p.Comm(); err == nil && cm == "java" {
-			var podName, psm, containerID, containerName, cmdline string
-			if pns, err := p.Namespace("pid"); err == nil && process.PnsDiffWithRpns(pns) {
-				if envs, err := p.Envs(); err == nil {
-					if p, ok := envs["POD_NAME"]; ok {
-						podName = p
-					} else if p, ok := envs["MY_POD_NAME"]; ok {
-						podName = p
-					}
-					if p, ok := envs["LOAD_SERVICE_PSM"]; ok {
-						psm = p
-					} else if p, ok := envs["TCE_PSM"]; ok {
-						psm = p
-					} else if p, ok := envs["RUNTIME_PSM"]; ok {
-						psm = p
-					}
-				}
-				if m, ok := cache.Get(5056, "pns"+pns); ok {
-					containerID = m["container_id"]
-					containerName = m["container_name"]
-				}
-			}
-			cmdline, _ = p.Cmdline()
-			rec := &plugins.Record{
-				DataType:  int32(h.DataType()),
-				Timestamp: time.Now().Unix(),
-				Data: &plugins.Payload{
-					Fields: map[string]string{
-						"seq": formattedCurrent,
-						"type":           "jar",
-						"psm":            psm,
-						"pod_name":       podName,
-						"container_id":   containerID,
-						"container_name": containerName,
-						"cmdline":        cmdline,
-						"pid":            p.Pid(),
-						"package_seq":    seq,
-					},
-				},
-			}
-			if fs, err := p.Fds(); err == nil {
-				set := mapset.NewSet()
-				for _, fn := range fs {
-					if filepath.Ext(fn) == ".jar" {
-						if set.Contains(fn) ||
-							(filepath.Base(fn) != "rt.jar" &&
-								(strings.Contains(fn, "jdk") || strings.Contains(fn, "jre"))) {
-							continue
-						}
-						if r, err := zip.OpenReader(filepath.Join("/proc", p.Pid(), "root", fn)); err == nil {
-							findJar(c, rec, r, fn)
-							r.Close()
-						}
-						set.Add(fn)
-					}
-				}
-			}
-		}
-	}
 }
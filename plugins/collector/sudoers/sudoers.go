@@ -0,0 +1,300 @@
+package sudoers
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	MaxIncludeDepth = 8
+	maxFileSize     = 1024 * 1024
+)
+
+var tags = map[string]bool{
+	"NOPASSWD": true, "PASSWD": true, "NOEXEC": true, "EXEC": true,
+	"SETENV": true, "NOSETENV": true, "LOG_INPUT": true, "NOLOG_INPUT": true,
+	"LOG_OUTPUT": true, "NOLOG_OUTPUT": true, "MAIL": true, "NOMAIL": true,
+	"FOLLOW": true, "NOFOLLOW": true, "INTERCEPT": true, "NOINTERCEPT": true,
+}
+
+// Rule is a single user specification entry, after alias expansion.
+type Rule struct {
+	Users    []string
+	Hosts    []string
+	RunasU   []string
+	RunasG   []string
+	Commands []string
+	Tags     []string
+	NoPasswd bool
+	Path     string
+	Line     int
+}
+
+// root privileges are granted if runas user list is omitted (defaults to root),
+// or contains ALL/root/#0
+func (r *Rule) Root() bool {
+	if len(r.RunasU) == 0 && len(r.RunasG) == 0 {
+		return true
+	}
+	for _, u := range r.RunasU {
+		if u == "ALL" || u == "root" || u == "#0" {
+			return true
+		}
+	}
+	return false
+}
+
+type Parser struct {
+	// alias type -> name -> members
+	aliases map[string]map[string][]string
+	visited map[string]bool
+	Rules   []*Rule
+}
+
+func NewParser() *Parser {
+	return &Parser{
+		aliases: map[string]map[string][]string{
+			"User_Alias":  {},
+			"Runas_Alias": {},
+			"Host_Alias":  {},
+			"Cmnd_Alias":  {},
+		},
+		visited: map[string]bool{},
+	}
+}
+
+// Parse reads path and every file it includes, collecting rules in file order.
+func Parse(path string) (ret []*Rule, err error) {
+	p := NewParser()
+	err = p.ParseFile(path, 0)
+	ret = p.Rules
+	return
+}
+
+func (p *Parser) ParseFile(path string, depth int) (err error) {
+	if depth > MaxIncludeDepth || p.visited[path] {
+		return
+	}
+	p.visited[path] = true
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(io.LimitReader(f, maxFileSize))
+	var (
+		line    string
+		lineno  int
+		startno int
+	)
+	for s.Scan() {
+		lineno++
+		text := strings.TrimRight(s.Text(), " \t")
+		if line == "" {
+			startno = lineno
+		}
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		p.parseLine(path, strings.TrimSpace(line), startno, depth)
+		line = ""
+	}
+	if line != "" {
+		p.parseLine(path, strings.TrimSpace(line), startno, depth)
+	}
+	return s.Err()
+}
+
+func (p *Parser) include(from, target string, dir bool, depth int) {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(from), target)
+	}
+	if !dir {
+		p.ParseFile(target, depth+1)
+		return
+	}
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		return
+	}
+	// sudo skips files which end in '~' or contain a '.'
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), "~") || strings.Contains(e.Name(), ".") {
+			continue
+		}
+		p.ParseFile(filepath.Join(target, e.Name()), depth+1)
+	}
+}
+
+func (p *Parser) parseLine(path, line string, lineno, depth int) {
+	if line == "" {
+		return
+	}
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "#include", "@include":
+		if len(fields) > 1 {
+			p.include(path, strings.Trim(fields[1], `"`), false, depth)
+		}
+		return
+	case "#includedir", "@includedir":
+		if len(fields) > 1 {
+			p.include(path, strings.Trim(fields[1], `"`), true, depth)
+		}
+		return
+	}
+	if i := commentIndex(line); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+		if line == "" {
+			return
+		}
+		fields = strings.Fields(line)
+	}
+	if strings.HasPrefix(fields[0], "Defaults") {
+		return
+	}
+	if members, ok := p.aliases[fields[0]]; ok {
+		// NAME = a, b : NAME2 = c
+		for _, def := range strings.Split(strings.TrimSpace(line[len(fields[0]):]), ":") {
+			kv := strings.SplitN(def, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			members[strings.TrimSpace(kv[0])] = splitList(kv[1])
+		}
+		return
+	}
+	p.parseUserSpec(path, line, lineno)
+}
+
+// '#' starts a comment unless followed by a digit (uid/gid reference)
+func commentIndex(line string) int {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i+1 >= len(line) || line[i+1] < '0' || line[i+1] > '9') {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitList(s string) (ret []string) {
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+func (p *Parser) expand(typ string, list []string, depth int) (ret []string) {
+	for _, e := range list {
+		neg := strings.HasPrefix(e, "!")
+		name := strings.TrimPrefix(e, "!")
+		if members, ok := p.aliases[typ][name]; ok && depth < MaxIncludeDepth {
+			for _, m := range p.expand(typ, members, depth+1) {
+				if neg {
+					m = "!" + m
+				}
+				ret = append(ret, m)
+			}
+		} else {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+// user_list host_list = [(runas)] [TAG:]... cmnd, ... [: host_list = ...]
+func (p *Parser) parseUserSpec(path, line string, lineno int) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return
+	}
+	users := p.expand("User_Alias", splitList(line[:i]), 0)
+	for _, spec := range splitSpecs(strings.TrimSpace(line[i:])) {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		hosts := p.expand("Host_Alias", splitList(kv[0]), 0)
+		var current *Rule
+		for _, cmnd := range splitList(kv[1]) {
+			r := &Rule{
+				Users: users,
+				Hosts: hosts,
+				Path:  path,
+				Line:  lineno,
+			}
+			// runas and tags are inherited by subsequent commands
+			if current != nil {
+				r.RunasU, r.RunasG, r.Tags, r.NoPasswd = current.RunasU, current.RunasG, current.Tags, current.NoPasswd
+			}
+			if strings.HasPrefix(cmnd, "(") {
+				if j := strings.Index(cmnd, ")"); j > 0 {
+					runas := strings.SplitN(cmnd[1:j], ":", 2)
+					r.RunasU = p.expand("Runas_Alias", splitList(runas[0]), 0)
+					r.RunasG = nil
+					if len(runas) == 2 {
+						r.RunasG = p.expand("Runas_Alias", splitList(runas[1]), 0)
+					}
+					cmnd = strings.TrimSpace(cmnd[j+1:])
+				}
+			}
+			for {
+				j := strings.Index(cmnd, ":")
+				if j < 0 || !tags[strings.TrimSpace(cmnd[:j])] {
+					break
+				}
+				tag := strings.TrimSpace(cmnd[:j])
+				r.Tags = append(append([]string{}, r.Tags...), tag)
+				switch tag {
+				case "NOPASSWD":
+					r.NoPasswd = true
+				case "PASSWD":
+					r.NoPasswd = false
+				}
+				cmnd = strings.TrimSpace(cmnd[j+1:])
+			}
+			r.Commands = p.expand("Cmnd_Alias", []string{strings.Join(strings.Fields(cmnd), " ")}, 0)
+			p.Rules = append(p.Rules, r)
+			current = r
+		}
+	}
+}
+
+// split "h1 = cmd1 : h2 = cmd2" on top-level colons, ignoring tag and runas colons
+func splitSpecs(s string) (ret []string) {
+	var (
+		start int
+		paren int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			paren++
+		case ')':
+			paren--
+		case ':':
+			if paren > 0 {
+				continue
+			}
+			rest := strings.TrimSpace(s[i+1:])
+			// a spec separator is followed by "host_list ="
+			if eq := strings.Index(rest, "="); eq > 0 && !strings.ContainsAny(rest[:eq], "(/:") {
+				prev := strings.TrimSpace(s[start:i])
+				if k := strings.LastIndexAny(prev, " ,="); k >= 0 && tags[prev[k+1:]] {
+					continue
+				}
+				ret = append(ret, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, s[start:])
+}
@@ -0,0 +1,82 @@
+package sudoers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0440); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	conf := write("sudoers", `Defaults env_reset
+User_Alias ADMINS = alice, %wheel : OPS = bob
+Runas_Alias DB = postgres, mysql
+Cmnd_Alias SHUTDOWN = /sbin/halt, /sbin/reboot
+root ALL=(ALL:ALL) ALL
+ADMINS ALL = (ALL) NOPASSWD: ALL # comment
+OPS db1, db2 = (DB) /usr/bin/psql, \
+	PASSWD: /usr/bin/mysql
+#1001 ALL = NOPASSWD: SHUTDOWN : web = (www-data) NOEXEC: /usr/bin/vim
+#include sudoers.local
+@includedir sudoers.d
+`)
+	write("sudoers.local", "carol ALL = /usr/bin/id\n#include sudoers\n")
+	write("sudoers.d/ops", "dave ALL = (root) !/bin/sh\n")
+	// skipped by sudo
+	write("sudoers.d/ops.bak", "eve ALL = ALL\n")
+	write("sudoers.d/ops~", "eve ALL = ALL\n")
+
+	rules, err := Parse(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(dir, "sudoers.local")
+	ops := filepath.Join(dir, "sudoers.d/ops")
+	want := []*Rule{
+		{Users: []string{"root"}, Hosts: []string{"ALL"}, RunasU: []string{"ALL"}, RunasG: []string{"ALL"}, Commands: []string{"ALL"}, Path: conf, Line: 5},
+		{Users: []string{"alice", "%wheel"}, Hosts: []string{"ALL"}, RunasU: []string{"ALL"}, Commands: []string{"ALL"}, Tags: []string{"NOPASSWD"}, NoPasswd: true, Path: conf, Line: 6},
+		{Users: []string{"bob"}, Hosts: []string{"db1", "db2"}, RunasU: []string{"postgres", "mysql"}, Commands: []string{"/usr/bin/psql"}, Path: conf, Line: 7},
+		{Users: []string{"bob"}, Hosts: []string{"db1", "db2"}, RunasU: []string{"postgres", "mysql"}, Commands: []string{"/usr/bin/mysql"}, Tags: []string{"PASSWD"}, Path: conf, Line: 7},
+		{Users: []string{"#1001"}, Hosts: []string{"ALL"}, Commands: []string{"/sbin/halt", "/sbin/reboot"}, Tags: []string{"NOPASSWD"}, NoPasswd: true, Path: conf, Line: 9},
+		{Users: []string{"#1001"}, Hosts: []string{"web"}, RunasU: []string{"www-data"}, Commands: []string{"/usr/bin/vim"}, Tags: []string{"NOEXEC"}, Path: conf, Line: 9},
+		{Users: []string{"carol"}, Hosts: []string{"ALL"}, Commands: []string{"/usr/bin/id"}, Path: local, Line: 1},
+		{Users: []string{"dave"}, Hosts: []string{"ALL"}, RunasU: []string{"root"}, Commands: []string{"!/bin/sh"}, Path: ops, Line: 1},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("Parse() returns %d rules, want %d", len(rules), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(rules[i], want[i]) {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want bool
+	}{
+		{Rule{}, true},
+		{Rule{RunasU: []string{"ALL"}}, true},
+		{Rule{RunasU: []string{"#0"}}, true},
+		{Rule{RunasU: []string{"postgres"}}, false},
+		{Rule{RunasG: []string{"adm"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.Root(); got != tt.want {
+			t.Errorf("%+v Root() = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
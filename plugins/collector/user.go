@@ -3,12 +3,18 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/binary"
+	"encoding/json"
 	"net"
 	"os"
 	"os/exec"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "embed"
@@ -18,12 +24,20 @@ import (
 	_ "github.com/GehirnInc/crypt/sha512_crypt"
 	"github.com/bytedance/Elkeid/plugins/collector/engine"
 	"github.com/bytedance/Elkeid/plugins/collector/utils"
+	"github.com/bytedance/Elkeid/plugins/collector/yescrypt"
 	plugins "github.com/bytedance/plugins"
 	"github.com/mitchellh/mapstructure"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sys/unix"
 )
 
-type UserHandler struct{}
+type UserHandler struct {
+	once   sync.Once
+	mu     sync.RWMutex
+	dict   []string
+	budget time.Duration
+}
 
 func (*UserHandler) Name() string {
 	return "user"
@@ -91,6 +105,7 @@ type User struct {
 	LastLoginIP         string `mapstructure:"last_login_ip"`
 	WeakPassword        string `mapstructure:"weak_password"`
 	WeakPasswordContent string `mapstructure:"weak_password_content"`
+	WeakPasswordStatus  string `mapstructure:"weak_password_status"`
 	Sudoers             string `mapstructure:"sudoers"`
 	// Shadow file fields
 	ShadowPassword      string `mapstructure:"shadow_password"`      // 密码
@@ -106,40 +121,178 @@ type User struct {
 //go:embed weak_password
 var weakPassword string
 
-func verifyWeak(hashed string) (string, string) {
+const (
+	weakPasswordConfigPath = "weak_password.json"
+	defaultCrackCPUBudget  = 5 * time.Minute
+)
+
+// WeakPasswordConfig is pushed by the manager as the data of a 5052 task,
+// and persisted so it survives plugin restarts.
+type WeakPasswordConfig struct {
+	Dictionary []string `json:"dictionary"`
+	// extend the embedded dictionary instead of replacing it
+	Append bool `json:"append"`
+	// cpu seconds a whole scan may spend on hash computation
+	CPUBudget int `json:"cpu_budget"`
+}
+
+func (h *UserHandler) Configure(data string) (err error) {
+	cfg := WeakPasswordConfig{}
+	if err = json.Unmarshal([]byte(data), &cfg); err != nil {
+		return
+	}
+	h.load()
+	h.apply(cfg)
+	return os.WriteFile(weakPasswordConfigPath, []byte(data), 0600)
+}
+
+func (h *UserHandler) load() {
+	h.once.Do(func() {
+		h.apply(WeakPasswordConfig{Append: true})
+		if data, err := os.ReadFile(weakPasswordConfigPath); err == nil {
+			cfg := WeakPasswordConfig{}
+			if err = json.Unmarshal(data, &cfg); err == nil {
+				h.apply(cfg)
+			} else {
+				zap.S().Warn("invalid weak password config: ", err.Error())
+			}
+		}
+	})
+}
+
+func (h *UserHandler) apply(cfg WeakPasswordConfig) {
+	dict := []string{}
+	set := map[string]bool{}
+	add := func(pw string) {
+		if pw != "" && !set[pw] {
+			set[pw] = true
+			dict = append(dict, pw)
+		}
+	}
+	if cfg.Append || len(cfg.Dictionary) == 0 {
+		lines := bufio.NewScanner(strings.NewReader(weakPassword))
+		for lines.Scan() {
+			add(lines.Text())
+		}
+	}
+	for _, pw := range cfg.Dictionary {
+		add(pw)
+	}
+	h.mu.Lock()
+	h.dict = dict
+	h.budget = defaultCrackCPUBudget
+	if cfg.CPUBudget > 0 {
+		h.budget = time.Duration(cfg.CPUBudget) * time.Second
+	}
+	h.mu.Unlock()
+}
+
+// crackBudget limits the cpu time of the calling (locked) os thread
+type crackBudget struct {
+	limit time.Duration
+	start time.Duration
+}
+
+func threadCPUTime() time.Duration {
+	ru := unix.Rusage{}
+	if err := unix.Getrusage(unix.RUSAGE_THREAD, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func newCrackBudget(limit time.Duration) *crackBudget {
+	return &crackBudget{limit: limit, start: threadCPUTime()}
+}
+
+func (b *crackBudget) Exceeded() bool {
+	return threadCPUTime()-b.start > b.limit
+}
+
+// NetBSD sha1crypt: $sha1$<iterations>$<salt>$<checksum>
+func sha1Crypt(password []byte, iterations uint64, salt string) string {
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	m := hmac.New(sha1.New, password)
+	m.Write([]byte(salt + "$sha1$" + strconv.FormatUint(iterations, 10)))
+	sum := m.Sum(nil)
+	for i := uint64(1); i < iterations; i++ {
+		m.Reset()
+		m.Write(sum)
+		sum = m.Sum(sum[:0])
+	}
+	var sb strings.Builder
+	sb.WriteString("$sha1$" + strconv.FormatUint(iterations, 10) + "$" + salt + "$")
+	for i := 0; i < 21; i += 3 {
+		l := uint32(sum[i%20])<<16 | uint32(sum[(i+1)%20])<<8 | uint32(sum[(i+2)%20])
+		for j := 0; j < 4; j++ {
+			sb.WriteByte(itoa64[l&0x3f])
+			l >>= 6
+		}
+	}
+	return sb.String()
+}
+
+func (h *UserHandler) verifyWeak(hashed string, budget *crackBudget) (string, string, string) {
 	fields := strings.Split(hashed, "$")
 	if len(fields) < 4 {
-		return "true", "not valid format"
+		return "true", "not valid format", "checked"
 	}
 	method := fields[1]
 	if method == "1" {
-		return "true", "weak algorithm"
+		return "true", "weak algorithm", "checked"
 	}
-	var crypter crypt.Crypter
+	var verify func(pw string) bool
 	switch method {
-	case "5":
-		crypter = crypt.SHA256.New()
-	case "6":
-		crypter = crypt.SHA512.New()
+	case "5", "6":
+		var crypter crypt.Crypter
+		if method == "5" {
+			crypter = crypt.SHA256.New()
+		} else {
+			crypter = crypt.SHA512.New()
+		}
+		verify = func(pw string) bool {
+			return crypter.Verify(hashed, []byte(pw)) == nil
+		}
+	case "y":
+		verify = func(pw string) bool {
+			return yescrypt.CompareHashAndPassword([]byte(hashed), []byte(pw)) == nil
+		}
+	case "2a", "2b", "2y":
+		verify = func(pw string) bool {
+			return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pw)) == nil
+		}
+	case "sha1":
+		iterations, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || len(fields) < 5 {
+			return "false", "", "unsupported"
+		}
+		verify = func(pw string) bool {
+			return subtle.ConstantTimeCompare([]byte(sha1Crypt([]byte(pw), iterations, fields[3])), []byte(hashed)) == 1
+		}
 	default:
-		return "false", ""
+		return "false", "", "unsupported"
 	}
-	salt := fields[2]
-	lines := bufio.NewScanner(strings.NewReader(weakPassword))
-	for lines.Scan() {
-		pw, err := crypter.Generate([]byte(lines.Text()), []byte("$"+method+"$"+salt))
-		if err != nil {
-			continue
+	h.mu.RLock()
+	dict := h.dict
+	h.mu.RUnlock()
+	for _, pw := range dict {
+		if budget.Exceeded() {
+			return "false", "", "budget_exceeded"
 		}
-		if pw == hashed {
-			return "true", maskPassword(lines.Text())
+		start := time.Now()
+		if verify(pw) {
+			return "true", maskPassword(pw), "checked"
+		}
+		// keep the duty cycle at ~50% for expensive algorithms
+		if d := time.Since(start); d > time.Millisecond*10 {
+			time.Sleep(d)
 		}
-
 	}
-	return "false", ""
+	return "false", "", "checked"
 }
 
 func (h *UserHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	h.load()
 	f, err := os.Open("/etc/passwd")
 	if err != nil {
 		zap.S().Error(err)
@@ -191,6 +344,11 @@ func (h *UserHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string)
 	}
 	f, err = os.Open("/etc/shadow")
 	if err == nil {
+		// the budget is measured with the thread cpu time
+		runtime.LockOSThread()
+		h.mu.RLock()
+		budget := newCrackBudget(h.budget)
+		h.mu.RUnlock()
 		s := bufio.NewScanner(f)
 		for s.Scan() {
 			fields := strings.Split(s.Text(), ":")
@@ -199,9 +357,9 @@ func (h *UserHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string)
 			}
 			if u, ok := m[fields[0]]; ok {
 				if strings.Contains(fields[1], "*") || strings.Contains(fields[1], "!") {
-					u.WeakPassword, u.WeakPasswordContent = "false", ""
+					u.WeakPassword, u.WeakPasswordContent, u.WeakPasswordStatus = "false", "", "locked"
 				} else {
-					u.WeakPassword, u.WeakPasswordContent = verifyWeak(fields[1])
+					u.WeakPassword, u.WeakPasswordContent, u.WeakPasswordStatus = h.verifyWeak(fields[1], budget)
 				}
 				// 按照/etc/shadow文件的字段顺序赋值
 				// 字段顺序: 用户名:加密密码:最后修改日期:最小天数:最大天数:警告天数:不活跃天数:过期日期:保留字段
@@ -229,6 +387,8 @@ func (h *UserHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string)
 				}
 			}
 		}
+		runtime.UnlockOSThread()
+		f.Close()
 	}
 	for _, u := range m {
 		cmd := exec.Command("sudo", "-l", "-U", u.Username)
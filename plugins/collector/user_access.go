@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/collector/engine"
+	"github.com/bytedance/Elkeid/plugins/collector/sudoers"
+	plugins "github.com/bytedance/plugins"
+	"github.com/mitchellh/mapstructure"
+)
+
+// UserAccessHandler reports how users can log in (ssh authorized_keys) and
+// escalate (sudoers), keyed by username so records can be joined with 5052.
+type UserAccessHandler struct{}
+
+func (*UserAccessHandler) Name() string {
+	return "user_access"
+}
+func (*UserAccessHandler) DataType() int {
+	return 5063
+}
+
+type AuthorizedKey struct {
+	Type        string `mapstructure:"type"`
+	Username    string `mapstructure:"username"`
+	Uid         string `mapstructure:"uid"`
+	Path        string `mapstructure:"path"`
+	Line        string `mapstructure:"line"`
+	KeyType     string `mapstructure:"key_type"`
+	KeyBits     string `mapstructure:"key_bits"`
+	Fingerprint string `mapstructure:"fingerprint"`
+	Comment     string `mapstructure:"comment"`
+	Options     string `mapstructure:"options"`
+	From        string `mapstructure:"from"`
+	Command     string `mapstructure:"command"`
+	Root        string `mapstructure:"root"`
+}
+
+type SudoRule struct {
+	Type      string `mapstructure:"type"`
+	Username  string `mapstructure:"username"`
+	Uid       string `mapstructure:"uid"`
+	Principal string `mapstructure:"principal"`
+	Path      string `mapstructure:"path"`
+	Line      string `mapstructure:"line"`
+	Hosts     string `mapstructure:"hosts"`
+	RunasUser string `mapstructure:"runas_user"`
+	RunasGrp  string `mapstructure:"runas_group"`
+	Commands  string `mapstructure:"commands"`
+	Tags      string `mapstructure:"tags"`
+	NoPasswd  string `mapstructure:"nopasswd"`
+	Root      string `mapstructure:"root"`
+}
+
+var keyTypes = map[string]bool{
+	"ssh-rsa": true, "ssh-dss": true, "ssh-ed25519": true,
+	"ecdsa-sha2-nistp256": true, "ecdsa-sha2-nistp384": true, "ecdsa-sha2-nistp521": true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true, "sk-ssh-ed25519@openssh.com": true,
+	"ssh-rsa-cert-v01@openssh.com": true, "ssh-ed25519-cert-v01@openssh.com": true,
+}
+
+type passwdEntry struct {
+	name string
+	uid  string
+	gid  string
+	home string
+}
+
+func readPasswd() (ret []passwdEntry) {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 6 {
+			continue
+		}
+		ret = append(ret, passwdEntry{name: fields[0], uid: fields[2], gid: fields[3], home: fields[5]})
+	}
+	return
+}
+
+// group name -> member usernames, including users whose primary group it is
+func readGroups(users []passwdEntry) map[string][]string {
+	ret := map[string][]string{}
+	f, err := os.Open("/etc/group")
+	if err != nil {
+		return ret
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		members := []string{}
+		for _, m := range strings.Split(fields[3], ",") {
+			if m != "" {
+				members = append(members, m)
+			}
+		}
+		for _, u := range users {
+			if u.gid == fields[2] {
+				members = append(members, u.name)
+			}
+		}
+		ret[fields[0]] = members
+	}
+	return ret
+}
+
+// sshd_config AuthorizedKeysFile, with %h/%u/%% tokens expanded per user
+func authorizedKeysFiles() []string {
+	files := []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+	f, err := os.Open("/etc/ssh/sshd_config")
+	if err != nil {
+		return files
+	}
+	defer f.Close()
+	s := bufio.NewScanner(io.LimitReader(f, 1024*1024))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		// the first obtained value is used
+		if len(fields) > 1 && strings.EqualFold(fields[0], "AuthorizedKeysFile") {
+			if fields[1] == "none" {
+				return nil
+			}
+			return fields[1:]
+		}
+	}
+	return files
+}
+
+// split "opt1,opt2=\"a b\" ssh-rsa AAAA comment" into options and the remaining fields
+func splitKeyLine(line string) (options string, fields []string) {
+	fields = strings.Fields(line)
+	if len(fields) == 0 || keyTypes[fields[0]] {
+		return
+	}
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ' ', '\t':
+			if !quoted {
+				return line[:i], strings.Fields(line[i:])
+			}
+		}
+	}
+	return line, nil
+}
+
+func keyOption(options, name string) string {
+	var values []string
+	for _, opt := range splitOptions(options) {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], name) {
+			values = append(values, strings.Trim(kv[1], `"`))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func splitOptions(options string) (ret []string) {
+	quoted := false
+	start := 0
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				ret = append(ret, options[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start < len(options) {
+		ret = append(ret, options[start:])
+	}
+	return
+}
+
+func readSSHString(r *bytes.Reader) ([]byte, error) {
+	var l uint32
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+	if int(l) > r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, l)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// returns key type encoded in blob, bit length where applicable and openssh style fingerprint
+func parseKeyBlob(b64 string) (typ, bits, fingerprint string, err error) {
+	var blob []byte
+	blob, err = base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(blob)
+	fingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	r := bytes.NewReader(blob)
+	var t []byte
+	t, err = readSSHString(r)
+	if err != nil {
+		return
+	}
+	typ = string(t)
+	switch {
+	case typ == "ssh-rsa":
+		// e, n
+		if _, err = readSSHString(r); err != nil {
+			return
+		}
+		var n []byte
+		if n, err = readSSHString(r); err != nil {
+			return
+		}
+		bits = strconv.Itoa(new(big.Int).SetBytes(n).BitLen())
+	case typ == "ssh-dss":
+		var p []byte
+		if p, err = readSSHString(r); err != nil {
+			return
+		}
+		bits = strconv.Itoa(new(big.Int).SetBytes(p).BitLen())
+	case strings.Contains(typ, "nistp256"), strings.Contains(typ, "ed25519"):
+		bits = "256"
+	case strings.Contains(typ, "nistp384"):
+		bits = "384"
+	case strings.Contains(typ, "nistp521"):
+		bits = "521"
+	}
+	return
+}
+
+func parseAuthorizedKeys(u passwdEntry, path string) (ret []*AuthorizedKey) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(io.LimitReader(f, 1024*1024))
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		options, fields := splitKeyLine(line)
+		if len(fields) < 2 {
+			continue
+		}
+		k := &AuthorizedKey{
+			Type:     "authorized_key",
+			Username: u.name,
+			Uid:      u.uid,
+			Path:     path,
+			Line:     strconv.Itoa(lineno),
+			KeyType:  fields[0],
+			Options:  options,
+			From:     keyOption(options, "from"),
+			Command:  keyOption(options, "command"),
+			Root:     strconv.FormatBool(u.uid == "0"),
+		}
+		if len(fields) > 2 {
+			k.Comment = strings.Join(fields[2:], " ")
+		}
+		if typ, bits, fp, err := parseKeyBlob(fields[1]); err == nil {
+			if typ != "" {
+				k.KeyType = typ
+			}
+			k.KeyBits = bits
+			k.Fingerprint = fp
+		}
+		ret = append(ret, k)
+	}
+	return
+}
+
+func (h *UserAccessHandler) Handle(c *plugins.Client, cache *engine.Cache, seq string) {
+	users := readPasswd()
+	uids := map[string]string{}
+	for _, u := range users {
+		uids[u.name] = u.uid
+	}
+	// authorized_keys
+	patterns := authorizedKeysFiles()
+	for _, u := range users {
+		if u.home == "" {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, pattern := range patterns {
+			path := strings.NewReplacer("%%", "%", "%h", u.home, "%u", u.name, "%U", u.uid).Replace(pattern)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(u.home, path)
+			}
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			for _, k := range parseAuthorizedKeys(u, path) {
+				rec := &plugins.Record{
+					DataType:  int32(h.DataType()),
+					Timestamp: time.Now().Unix(),
+					Data: &plugins.Payload{
+						Fields: make(map[string]string, 15),
+					},
+				}
+				mapstructure.Decode(k, &rec.Data.Fields)
+				rec.Data.Fields["package_seq"] = seq
+				c.SendRecord(rec)
+			}
+		}
+	}
+	// sudoers
+	rules, _ := sudoers.Parse("/etc/sudoers")
+	if len(rules) == 0 {
+		return
+	}
+	groups := readGroups(users)
+	for _, r := range rules {
+		for _, principal := range r.Users {
+			if strings.HasPrefix(principal, "!") {
+				continue
+			}
+			var names []string
+			switch {
+			case principal == "ALL":
+				names = []string{"ALL"}
+			case strings.HasPrefix(principal, "%"):
+				names = groups[strings.TrimPrefix(strings.TrimPrefix(principal, "%"), ":")]
+			case strings.HasPrefix(principal, "#"):
+				for _, u := range users {
+					if u.uid == principal[1:] {
+						names = append(names, u.name)
+					}
+				}
+			default:
+				names = []string{principal}
+			}
+			for _, name := range names {
+				sr := &SudoRule{
+					Type:      "sudoers",
+					Username:  name,
+					Uid:       uids[name],
+					Principal: principal,
+					Path:      r.Path,
+					Line:      strconv.Itoa(r.Line),
+					Hosts:     strings.Join(r.Hosts, ","),
+					RunasUser: strings.Join(r.RunasU, ","),
+					RunasGrp:  strings.Join(r.RunasG, ","),
+					Commands:  strings.Join(r.Commands, ","),
+					Tags:      strings.Join(r.Tags, ","),
+					NoPasswd:  strconv.FormatBool(r.NoPasswd),
+					Root:      strconv.FormatBool(r.Root()),
+				}
+				rec := &plugins.Record{
+					DataType:  int32(h.DataType()),
+					Timestamp: time.Now().Unix(),
+					Data: &plugins.Payload{
+						Fields: make(map[string]string, 15),
+					},
+				}
+				mapstructure.Decode(sr, &rec.Data.Fields)
+				rec.Data.Fields["package_seq"] = seq
+				c.SendRecord(rec)
+			}
+		}
+	}
+}
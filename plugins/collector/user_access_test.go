@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	testEd25519Key = "AAAAC3NzaC1lZDI1NTE5AAAAIPMjXYf6QddrctoLhQffUlHwPg6Gygd9yQNB3WRAqAxG"
+	testRSAKey     = "AAAAB3NzaC1yc2EAAAADAQABAAABAQDP6Kkhx7lEVJ+UKkX7sqVCiz5eloH2yCWmrNniKPdr6GAE8HAOiO0RQi0+VnhYNZ3iQ4L5CamPmYeTLKS3+UmgwpcdULntYb8l0Yz0hbdgUUnuhwdMmjiPFDFHDjdPkY8w3j2ovXxoMNca8CQNBUBM+RSAeI81NvYNAgSejAv4N90KZgHwDR1NE/ZvDWDpzvsRvk6/v/GWPIpUK6XGVIGIMRMRuUrbtxqrrhWDQVzDJtwfPGK6TuiWLWNPVqTdMDFQfHIHdVmeE6DAGWsRG7/dj5qKdaDE/V23YerxXqN81S7M2Q8h8tb1jl69CfZjAWVVj3EnnnkNdEvhql3QAM7/"
+)
+
+func TestSplitKeyLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		options string
+		fields  []string
+		from    string
+		command string
+	}{
+		{
+			line:   "ssh-ed25519 " + testEd25519Key + " alice@host",
+			fields: []string{"ssh-ed25519", testEd25519Key, "alice@host"},
+		},
+		{
+			line:    `from="10.0.0.0/8,192.168.1.1",no-pty ssh-rsa ` + testRSAKey + " bob",
+			options: `from="10.0.0.0/8,192.168.1.1",no-pty`,
+			fields:  []string{"ssh-rsa", testRSAKey, "bob"},
+			from:    "10.0.0.0/8,192.168.1.1",
+		},
+		{
+			line:    `command="echo \"a b\"; exit",restrict,FROM="10.0.0.1" ssh-ed25519 ` + testEd25519Key,
+			options: `command="echo \"a b\"; exit",restrict,FROM="10.0.0.1"`,
+			fields:  []string{"ssh-ed25519", testEd25519Key},
+			from:    "10.0.0.1",
+			command: `echo \"a b\"; exit`,
+		},
+		{
+			line: `command="unterminated ssh-ed25519 ` + testEd25519Key,
+			// no fields left, the line is skipped
+			options: `command="unterminated ssh-ed25519 ` + testEd25519Key,
+			command: "unterminated ssh-ed25519 " + testEd25519Key,
+		},
+	}
+	for _, tt := range tests {
+		options, fields := splitKeyLine(tt.line)
+		if options != tt.options || !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("splitKeyLine(%q) = %q, %q, want %q, %q", tt.line, options, fields, tt.options, tt.fields)
+		}
+		if from := keyOption(options, "from"); from != tt.from {
+			t.Errorf("keyOption(%q, from) = %q, want %q", options, from, tt.from)
+		}
+		if command := keyOption(options, "command"); command != tt.command {
+			t.Errorf("keyOption(%q, command) = %q, want %q", options, command, tt.command)
+		}
+	}
+}
+
+func TestParseKeyBlob(t *testing.T) {
+	// fingerprints are the ones of ssh-keygen -l
+	tests := []struct {
+		blob        string
+		typ         string
+		bits        string
+		fingerprint string
+		wantErr     bool
+	}{
+		{blob: testEd25519Key, typ: "ssh-ed25519", bits: "256", fingerprint: "SHA256:oZwimVnfJlrh89aG8W2OmJT38fFDCl+N/f5KxqxHn0A"},
+		{blob: testRSAKey, typ: "ssh-rsa", bits: "2048", fingerprint: "SHA256:jsm6+JyjWyHV++gt3MCWh/2oVfJKWXnIGn42OJ5w1uQ"},
+		{blob: "not base64!", wantErr: true},
+		// the length of the key type exceeds the blob
+		{blob: "AAAA/3NzaC1yc2E=", wantErr: true},
+	}
+	for _, tt := range tests {
+		typ, bits, fingerprint, err := parseKeyBlob(tt.blob)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseKeyBlob(%q) error %v, want error %v", tt.blob, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (typ != tt.typ || bits != tt.bits || fingerprint != tt.fingerprint) {
+			t.Errorf("parseKeyBlob(%q) = %s, %s, %s, want %s, %s, %s", tt.blob, typ, bits, fingerprint, tt.typ, tt.bits, tt.fingerprint)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSha1Crypt(t *testing.T) {
+	// known hashes of passlib's sha1_crypt tests
+	tests := []struct {
+		password string
+		hashed   string
+	}{
+		{"password", "$sha1$19703$iVdJqfSE$v4qYKl1zqYThwpjJAoKX6UvlHq/a"},
+		{"password", "$sha1$21773$uV7PTeux$I9oHnvwPZHMO0Nq6/WgyGV/tDJIH"},
+	}
+	for _, tt := range tests {
+		fields := strings.Split(tt.hashed, "$")
+		iterations, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sha1Crypt([]byte(tt.password), iterations, fields[3]); got != tt.hashed {
+			t.Errorf("sha1Crypt(%q) = %s, want %s", tt.password, got, tt.hashed)
+		}
+	}
+}
+
+func TestVerifyWeakSha1(t *testing.T) {
+	h := &UserHandler{dict: []string{"123456", "password"}}
+	tests := []struct {
+		hashed string
+		weak   string
+		status string
+	}{
+		{"$sha1$19703$iVdJqfSE$v4qYKl1zqYThwpjJAoKX6UvlHq/a", "true", "checked"},
+		{"$sha1$19703$iVdJqfSE$v4qYKl1zqYThwpjJAoKX6UvlHq/b", "false", "checked"},
+		{"$sha1$bad$iVdJqfSE$v4qYKl1zqYThwpjJAoKX6UvlHq/a", "false", "unsupported"},
+	}
+	for _, tt := range tests {
+		weak, _, status := h.verifyWeak(tt.hashed, newCrackBudget(time.Minute))
+		if weak != tt.weak || status != tt.status {
+			t.Errorf("verifyWeak(%s) = %s, %s, want %s, %s", tt.hashed, weak, status, tt.weak, tt.status)
+		}
+	}
+}
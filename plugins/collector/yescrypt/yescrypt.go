@@ -0,0 +1,530 @@
+// Package yescrypt implements verification of yescrypt ($y$) crypt(3) hashes,
+// the default password hashing method on Debian 11+, Ubuntu 22.04+ and Fedora 35+.
+// It's a port of the yescrypt reference implementation, limited to the flags
+// produced by libxcrypt (no ROM, no hash upgrades).
+package yescrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	flagRW       = 0x002
+	flagRWMask   = 0x3fc
+	flagDefaults = 0xb6
+	flagPrehash  = 0x10000000
+
+	pwxSimple = 2
+	pwxGather = 4
+	pwxRounds = 6
+	sWidth    = 8
+	pwxWords  = pwxGather * pwxSimple * 2
+	sWords    = 3 * (1 << sWidth) * pwxSimple * 2
+	sMask     = ((1 << sWidth) - 1) * pwxSimple * 8
+
+	itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// hard limits to keep a malformed setting from exhausting memory
+	maxMemory = 1 << 30
+)
+
+var (
+	ErrInvalidSetting = errors.New("yescrypt: invalid setting")
+	ErrUnsupported    = errors.New("yescrypt: unsupported parameters")
+	ErrMismatch       = errors.New("yescrypt: hashed password mismatch")
+)
+
+type params struct {
+	flags uint32
+	n     uint64
+	r     uint32
+	p     uint32
+	t     uint32
+}
+
+func atoi64(c byte) uint32 {
+	if i := strings.IndexByte(itoa64, c); i >= 0 {
+		return uint32(i)
+	}
+	return 64
+}
+
+func decode64Uint32(src string, min uint32) (uint32, string, error) {
+	if src == "" {
+		return 0, "", ErrInvalidSetting
+	}
+	var start, end, chars, bits uint32 = 0, 47, 1, 0
+	c := atoi64(src[0])
+	src = src[1:]
+	if c > 63 {
+		return 0, "", ErrInvalidSetting
+	}
+	dst := min
+	for c > end {
+		dst += (end + 1 - start) << bits
+		start = end + 1
+		end = start + (62-end)/2
+		chars++
+		bits += 6
+	}
+	dst += (c - start) << bits
+	for chars--; chars > 0; chars-- {
+		if src == "" {
+			return 0, "", ErrInvalidSetting
+		}
+		c = atoi64(src[0])
+		src = src[1:]
+		if c > 63 {
+			return 0, "", ErrInvalidSetting
+		}
+		bits -= 6
+		dst += c << bits
+	}
+	return dst, src, nil
+}
+
+// little-endian groups of up to 4 chars -> 3 bytes
+func decode64(src string) ([]byte, error) {
+	var dst []byte
+	for len(src) > 0 {
+		var value, bits uint32
+		for len(src) > 0 && bits < 24 {
+			c := atoi64(src[0])
+			if c > 63 {
+				return nil, ErrInvalidSetting
+			}
+			src = src[1:]
+			value |= c << bits
+			bits += 6
+		}
+		if bits < 12 {
+			return nil, ErrInvalidSetting
+		}
+		for bits >= 8 {
+			dst = append(dst, byte(value))
+			value >>= 8
+			bits -= 8
+		}
+		if value != 0 {
+			return nil, ErrInvalidSetting
+		}
+	}
+	return dst, nil
+}
+
+func encode64(src []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(src); {
+		var value, bits uint32
+		for bits < 24 && i < len(src) {
+			value |= uint32(src[i]) << bits
+			bits += 8
+			i++
+		}
+		for b := uint32(0); b < bits; b += 6 {
+			sb.WriteByte(itoa64[value&0x3f])
+			value >>= 6
+		}
+	}
+	return sb.String()
+}
+
+func parseSetting(setting string) (p params, salt []byte, prefix string, err error) {
+	if !strings.HasPrefix(setting, "$y$") {
+		err = ErrInvalidSetting
+		return
+	}
+	src := setting[3:]
+	var flavor, nLog2, have uint32
+	if flavor, src, err = decode64Uint32(src, 0); err != nil {
+		return
+	}
+	if flavor < flagRW {
+		p.flags = flavor
+	} else if flavor <= flagRW+(flagRWMask>>2) {
+		p.flags = flagRW + ((flavor - flagRW) << 2)
+	} else {
+		err = ErrInvalidSetting
+		return
+	}
+	if nLog2, src, err = decode64Uint32(src, 1); err != nil {
+		return
+	}
+	if nLog2 > 63 {
+		err = ErrInvalidSetting
+		return
+	}
+	p.n = 1 << nLog2
+	if p.r, src, err = decode64Uint32(src, 1); err != nil {
+		return
+	}
+	p.p = 1
+	if src != "" && src[0] != '$' {
+		if have, src, err = decode64Uint32(src, 1); err != nil {
+			return
+		}
+		if have&1 != 0 {
+			if p.p, src, err = decode64Uint32(src, 2); err != nil {
+				return
+			}
+		}
+		if have&2 != 0 {
+			if p.t, src, err = decode64Uint32(src, 1); err != nil {
+				return
+			}
+		}
+		// g (hash upgrades) and NROM are not supported
+		if have&^3 != 0 {
+			err = ErrUnsupported
+			return
+		}
+	}
+	if src == "" || src[0] != '$' {
+		err = ErrInvalidSetting
+		return
+	}
+	src = src[1:]
+	saltStr := src
+	if i := strings.IndexByte(src, '$'); i >= 0 {
+		saltStr = src[:i]
+	}
+	if salt, err = decode64(saltStr); err != nil {
+		return
+	}
+	prefix = setting[:len(setting)-len(src)] + saltStr
+	// pwxform is implemented for the default (and only libxcrypt generated) flavor
+	if p.flags != flagDefaults {
+		err = ErrUnsupported
+		return
+	}
+	if p.r == 0 || p.p == 0 || p.n < 4 || uint64(p.r)*uint64(p.p) >= 1<<30 ||
+		p.n/uint64(p.p) <= 3 || 128*uint64(p.r)*p.n > maxMemory {
+		err = ErrUnsupported
+	}
+	return
+}
+
+// Hash computes the crypt(3) string of password for a setting (or a full hash) starting with "$y$".
+func Hash(password, setting []byte) ([]byte, error) {
+	p, salt, prefix, err := parseSetting(string(setting))
+	if err != nil {
+		return nil, err
+	}
+	dk := kdf(password, salt, p)
+	return []byte(prefix + "$" + encode64(dk)), nil
+}
+
+// CompareHashAndPassword compares a yescrypt hashed password with its possible plaintext equivalent.
+func CompareHashAndPassword(hashed, password []byte) error {
+	h, err := Hash(password, hashed)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(h, hashed) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func hmacSHA256(key, msg []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(msg)
+	return m.Sum(nil)
+}
+
+func kdf(password, salt []byte, p params) []byte {
+	if p.flags&flagRW != 0 && p.p >= 1 && p.n/uint64(p.p) >= 0x100 && p.n/uint64(p.p)*uint64(p.r) >= 0x20000 {
+		password = kdfBody(password, salt, params{
+			flags: p.flags | flagPrehash,
+			n:     p.n >> 6,
+			r:     p.r,
+			p:     p.p,
+		})
+	}
+	return kdfBody(password, salt, p)
+}
+
+func kdfBody(password, salt []byte, p params) []byte {
+	key := "yescrypt"
+	if p.flags&flagPrehash != 0 {
+		key = "yescrypt-prehash"
+	}
+	passwd := hmacSHA256([]byte(key), password)
+	b := pbkdf2.Key(passwd, salt, 1, int(128*p.r*p.p), sha256.New)
+	copy(passwd, b[:32])
+	s := int(32 * p.r)
+	v := make([]uint32, uint64(s)*p.n)
+	xy := make([]uint32, 2*s)
+	passwd = smix(b, int(p.r), uint32(p.n), p.p, p.t, p.flags, v, xy, passwd)
+	dk := pbkdf2.Key(passwd, b, 1, 32, sha256.New)
+	if p.flags&flagPrehash == 0 {
+		clientKey := hmacSHA256(dk, []byte("Client Key"))
+		storedKey := sha256.Sum256(clientKey)
+		dk = storedKey[:]
+	}
+	return dk
+}
+
+type pwxformCtx struct {
+	s          []uint32
+	s0, s1, s2 int
+	w          int
+}
+
+func salsa20(b []uint32, rounds int) {
+	var x [16]uint32
+	for i := 0; i < 16; i++ {
+		x[i*5%16] = b[i]
+	}
+	for i := 0; i < rounds; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := 0; i < 16; i++ {
+		b[i] += x[i*5%16]
+	}
+}
+
+func blkxor(dst, src []uint32) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func blockmixSalsa8(b, y []uint32, r int) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		blkxor(x[:], b[i*16:(i+1)*16])
+		salsa20(x[:], 8)
+		copy(y[i*16:], x[:])
+	}
+	for i := 0; i < r; i++ {
+		copy(b[i*16:(i+1)*16], y[(i*2)*16:])
+		copy(b[(i+r)*16:(i+r+1)*16], y[(i*2+1)*16:])
+	}
+}
+
+func (ctx *pwxformCtx) pwxform(b []uint32) {
+	s := ctx.s
+	w := ctx.w
+	for i := 0; i < pwxRounds; i++ {
+		for j := 0; j < pwxGather; j++ {
+			xl := b[j*pwxSimple*2]
+			xh := b[j*pwxSimple*2+1]
+			p0 := ctx.s0 + int(xl&sMask)/4
+			p1 := ctx.s1 + int(xh&sMask)/4
+			for k := 0; k < pwxSimple; k++ {
+				s0 := uint64(s[p0+2*k+1])<<32 + uint64(s[p0+2*k])
+				s1 := uint64(s[p1+2*k+1])<<32 + uint64(s[p1+2*k])
+				xl = b[(j*pwxSimple+k)*2]
+				xh = b[(j*pwxSimple+k)*2+1]
+				x := uint64(xh) * uint64(xl)
+				x += s0
+				x ^= s1
+				b[(j*pwxSimple+k)*2] = uint32(x)
+				b[(j*pwxSimple+k)*2+1] = uint32(x >> 32)
+			}
+			if i != 0 && i != pwxRounds-1 {
+				for k := 0; k < pwxSimple; k++ {
+					s[ctx.s2+w*2] = b[(j*pwxSimple+k)*2]
+					s[ctx.s2+w*2+1] = b[(j*pwxSimple+k)*2+1]
+					w++
+				}
+			}
+		}
+	}
+	ctx.s0, ctx.s1, ctx.s2 = ctx.s2, ctx.s0, ctx.s1
+	ctx.w = w & ((1<<sWidth)*pwxSimple - 1)
+}
+
+func (ctx *pwxformCtx) blockmix(b []uint32, r int) {
+	var x [pwxWords]uint32
+	r1 := 128 * r / (pwxWords * 4)
+	copy(x[:], b[(r1-1)*pwxWords:])
+	for i := 0; i < r1; i++ {
+		if r1 > 1 {
+			blkxor(x[:], b[i*pwxWords:(i+1)*pwxWords])
+		}
+		ctx.pwxform(x[:])
+		copy(b[i*pwxWords:], x[:])
+	}
+	i := (r1 - 1) * pwxWords / 16
+	salsa20(b[i*16:(i+1)*16], 2)
+	for i++; i < 2*r; i++ {
+		blkxor(b[i*16:(i+1)*16], b[(i-1)*16:i*16])
+		salsa20(b[i*16:(i+1)*16], 2)
+	}
+}
+
+func integerify(b []uint32, r int) uint64 {
+	x := b[(2*r-1)*16:]
+	return uint64(x[13])<<32 + uint64(x[0])
+}
+
+func p2floor(x uint32) uint32 {
+	for y := x & (x - 1); y != 0; y = x & (x - 1) {
+		x = y
+	}
+	return x
+}
+
+func wrap(x uint64, i uint32) uint32 {
+	n := p2floor(i)
+	return uint32(x&uint64(n-1)) + (i - n)
+}
+
+// X <-- B with the SIMD shuffle used by the reference implementation
+func decodeBlock(x []uint32, b []byte, r int) {
+	for k := 0; k < 2*r; k++ {
+		for i := 0; i < 16; i++ {
+			x[k*16+i] = binary.LittleEndian.Uint32(b[(k*16+(i*5%16))*4:])
+		}
+	}
+}
+
+func encodeBlock(b []byte, x []uint32, r int) {
+	for k := 0; k < 2*r; k++ {
+		for i := 0; i < 16; i++ {
+			binary.LittleEndian.PutUint32(b[(k*16+(i*5%16))*4:], x[k*16+i])
+		}
+	}
+}
+
+func smix1(b []byte, r int, n uint32, flags uint32, v, xy []uint32, ctx *pwxformCtx) {
+	s := 32 * r
+	x := xy[:s]
+	y := xy[s:]
+	decodeBlock(x, b, r)
+	for i := uint32(0); i < n; i++ {
+		copy(v[int(i)*s:], x)
+		if flags&flagRW != 0 && i > 1 {
+			j := wrap(integerify(x, r), i)
+			blkxor(x, v[int(j)*s:int(j+1)*s])
+		}
+		if ctx != nil {
+			ctx.blockmix(x, r)
+		} else {
+			blockmixSalsa8(x, y, r)
+		}
+	}
+	encodeBlock(b, x, r)
+}
+
+func smix2(b []byte, r int, n, nloop uint32, flags uint32, v, xy []uint32, ctx *pwxformCtx) {
+	if nloop == 0 {
+		return
+	}
+	s := 32 * r
+	x := xy[:s]
+	y := xy[s:]
+	decodeBlock(x, b, r)
+	for i := uint32(0); i < nloop; i++ {
+		j := uint32(integerify(x, r) & uint64(n-1))
+		blkxor(x, v[int(j)*s:int(j+1)*s])
+		if flags&flagRW != 0 {
+			copy(v[int(j)*s:], x)
+		}
+		if ctx != nil {
+			ctx.blockmix(x, r)
+		} else {
+			blockmixSalsa8(x, y, r)
+		}
+	}
+	encodeBlock(b, x, r)
+}
+
+func smix(b []byte, r int, n, p, t, flags uint32, v, xy []uint32, passwd []byte) []byte {
+	s := 32 * r
+	nchunk := n / p
+	nloopAll := nchunk
+	if flags&flagRW != 0 {
+		if t <= 1 {
+			if t != 0 {
+				nloopAll *= 2
+			}
+			nloopAll = (nloopAll + 2) / 3
+		} else {
+			nloopAll *= t - 1
+		}
+	} else if t != 0 {
+		if t == 1 {
+			nloopAll += (nloopAll + 1) / 2
+		}
+		nloopAll *= t
+	}
+	var nloopRW uint32
+	if flags&flagRW != 0 {
+		nloopRW = nloopAll / p
+	}
+	nchunk &^= 1
+	nloopAll = (nloopAll + 1) &^ 1
+	nloopRW = (nloopRW + 1) &^ 1
+
+	ctxs := make([]*pwxformCtx, p)
+	for i, vchunk := uint32(0), uint32(0); i < p; i, vchunk = i+1, vchunk+nchunk {
+		np := nchunk
+		if i == p-1 {
+			np = n - vchunk
+		}
+		bp := b[128*r*int(i) : 128*r*int(i+1)]
+		vp := v[int(vchunk)*s:]
+		if flags&flagRW != 0 {
+			ctx := &pwxformCtx{s: make([]uint32, sWords)}
+			smix1(bp, 1, sWords*4/128, 0, ctx.s, xy, nil)
+			ctx.s2 = 0
+			ctx.s1 = ctx.s2 + (1<<sWidth)*pwxSimple*2
+			ctx.s0 = ctx.s1 + (1<<sWidth)*pwxSimple*2
+			ctxs[i] = ctx
+			if i == 0 {
+				passwd = hmacSHA256(bp[128*r-64:], passwd)
+			}
+		}
+		smix1(bp, r, np, flags, vp, xy, ctxs[i])
+		smix2(bp, r, p2floor(np), nloopRW, flags, vp, xy, ctxs[i])
+	}
+	for i := uint32(0); i < p; i++ {
+		bp := b[128*r*int(i) : 128*r*int(i+1)]
+		smix2(bp, r, n, nloopAll-nloopRW, flags&^flagRW, v, xy, ctxs[i])
+	}
+	return passwd
+}
@@ -0,0 +1,37 @@
+package yescrypt
+
+import "testing"
+
+func TestHash(t *testing.T) {
+	for _, c := range []struct {
+		password string
+		hashed   string
+	}{
+		{"password", "$y$j9T$abcdefgh$79JhsKZwfY/UU2qrk3QcffpHl7EyEEJK0pA5pD1wu73"},
+		{"password", "$y$j9T$F5Jx5fExrKuPp53xLKQ..1$tnSYvahCwPBHKZUspmcxMfb0.WiB9W.zEaKlOBL35rC"},
+		{"123456", "$y$j8T$SLTqW0ZZ6Y5OJ/6gD0MKH1$gG7viOCrK/JYxSDjWObmwtxmG0Gq5cSibEp/f1YN3J8"},
+	} {
+		h, err := Hash([]byte(c.password), []byte(c.hashed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(h) != c.hashed {
+			t.Errorf("Hash(%q) = %s, want %s", c.password, h, c.hashed)
+		}
+	}
+}
+
+func TestCompareHashAndPassword(t *testing.T) {
+	hashed := []byte("$y$j9T$SLTqW0ZZ6Y5OJ/6gD0MKH1$WXy5Ps1pouGTnqL7p4D0wBzSkxQ8CqyeBrSZlC38Gf5")
+	if err := CompareHashAndPassword(hashed, []byte("password")); err != nil {
+		t.Error(err)
+	}
+	if err := CompareHashAndPassword(hashed, []byte("123456")); err != ErrMismatch {
+		t.Errorf("got %v, want %v", err, ErrMismatch)
+	}
+	for _, setting := range []string{"$y$", "$y$j9T", "$y$j9T$a$", "$6$abc$def", "$y$/9T$abcdefgh$"} {
+		if err := CompareHashAndPassword([]byte(setting), []byte("password")); err == nil {
+			t.Errorf("%s: expected error", setting)
+		}
+	}
+}
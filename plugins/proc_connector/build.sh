@@ -0,0 +1,9 @@
+#!/bin/bash
+set -e
+if [ -z "${BUILD_VERSION}" ];then
+        echo 'Please set BUILD_VERSION.'
+        exit 1
+fi
+mkdir -p output
+GOARCH=amd64 CGO_ENABLED=0 go build -tags netgo,osusergo -ldflags="-w -s" -o output/proc_connector-linux-amd64-${BUILD_VERSION}.plg
+GOARCH=arm64 CGO_ENABLED=0 go build -tags netgo,osusergo -ldflags="-w -s" -o output/proc_connector-linux-arm64-${BUILD_VERSION}.plg
@@ -0,0 +1,207 @@
+// Package connector subscribes to the process events of the kernel proc
+// connector, which is available since 2.6.15 on kernels built with
+// CONFIG_PROC_EVENTS, i.e. all of the mainstream distributions.
+package connector
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ids of the proc connector, see include/uapi/linux/connector.h
+const (
+	CnIdxProc = 0x1
+	CnValProc = 0x1
+)
+
+// operations of a subscription, see include/uapi/linux/cn_proc.h
+const (
+	ProcCnMcastListen = 1
+	ProcCnMcastIgnore = 2
+)
+
+// kinds of events which are reported
+const (
+	ProcEventFork = 0x00000001
+	ProcEventExec = 0x00000002
+	ProcEventExit = 0x80000000
+)
+
+const (
+	// struct cn_msg
+	cnMsgSize = 20
+	// what, cpu and timestamp_ns of struct proc_event
+	procEventHeaderSize = 16
+)
+
+var (
+	ErrTruncated = errors.New("truncated proc event")
+	ErrMalformed = errors.New("malformed netlink message")
+)
+
+// the connector talks in the byte order of the host
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	i := uint16(1)
+	if (*[2]byte)(unsafe.Pointer(&i))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// Event is a fork, exec or exit of a process. Only the fields of the kind
+// are set: Parent* for forks, ExitCode and ExitSignal for exits.
+type Event struct {
+	What       uint32
+	Cpu        uint32
+	Timestamp  uint64
+	Pid        uint32
+	Tgid       uint32
+	ParentPid  uint32
+	ParentTgid uint32
+	ExitCode   uint32
+	ExitSignal uint32
+}
+
+// Parse decodes the payload of a connector netlink message, i.e. a cn_msg
+// followed by a proc_event. Events of other kinds return nil.
+func Parse(data []byte) (*Event, error) {
+	if len(data) < cnMsgSize {
+		return nil, ErrTruncated
+	}
+	if nativeEndian.Uint32(data[0:4]) != CnIdxProc || nativeEndian.Uint32(data[4:8]) != CnValProc {
+		return nil, nil
+	}
+	size := int(nativeEndian.Uint16(data[16:18]))
+	data = data[cnMsgSize:]
+	if len(data) < size || size < procEventHeaderSize {
+		return nil, ErrTruncated
+	}
+	data = data[:size]
+	e := &Event{
+		What:      nativeEndian.Uint32(data[0:4]),
+		Cpu:       nativeEndian.Uint32(data[4:8]),
+		Timestamp: nativeEndian.Uint64(data[8:16]),
+	}
+	body := data[procEventHeaderSize:]
+	u32 := func(i int) uint32 {
+		return nativeEndian.Uint32(body[i*4 : i*4+4])
+	}
+	switch e.What {
+	case ProcEventFork:
+		if len(body) < 16 {
+			return nil, ErrTruncated
+		}
+		e.ParentPid, e.ParentTgid, e.Pid, e.Tgid = u32(0), u32(1), u32(2), u32(3)
+	case ProcEventExec:
+		if len(body) < 8 {
+			return nil, ErrTruncated
+		}
+		e.Pid, e.Tgid = u32(0), u32(1)
+	case ProcEventExit:
+		if len(body) < 16 {
+			return nil, ErrTruncated
+		}
+		e.Pid, e.Tgid, e.ExitCode, e.ExitSignal = u32(0), u32(1), u32(2), u32(3)
+		// the parent is reported since 6.4
+		if len(body) >= 24 {
+			e.ParentPid, e.ParentTgid = u32(4), u32(5)
+		}
+	default:
+		return nil, nil
+	}
+	return e, nil
+}
+
+// Conn is a netlink socket subscribed to the proc connector.
+type Conn struct {
+	fd  int
+	buf []byte
+}
+
+// Dial opens a connector socket and subscribes to the process events, it
+// needs CAP_NET_ADMIN and the root pid namespace.
+func Dial() (*Conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	c := &Conn{fd: fd, buf: make([]byte, os.Getpagesize()*4)}
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: CnIdxProc}); err != nil {
+		c.Close()
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// a larger receive buffer survives bursts of forks
+	unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 4*1024*1024)
+	if err = c.control(ProcCnMcastListen); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) control(op uint32) error {
+	buf := make([]byte, unix.NLMSG_HDRLEN+cnMsgSize+4)
+	nativeEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	nativeEndian.PutUint16(buf[4:6], unix.NLMSG_DONE)
+	nativeEndian.PutUint32(buf[12:16], uint32(os.Getpid()))
+	msg := buf[unix.NLMSG_HDRLEN:]
+	nativeEndian.PutUint32(msg[0:4], CnIdxProc)
+	nativeEndian.PutUint32(msg[4:8], CnValProc)
+	nativeEndian.PutUint16(msg[16:18], 4)
+	nativeEndian.PutUint32(msg[cnMsgSize:], op)
+	return os.NewSyscallError("sendto", unix.Sendto(c.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}))
+}
+
+// Receive returns the events of a datagram. unix.ENOBUFS is returned if
+// events were dropped since the socket buffer overflowed, and ErrMalformed
+// or ErrTruncated along with the other events of the datagram if a message
+// can't be parsed, receiving again is fine then. Other errors are of the
+// socket.
+func (c *Conn) Receive() ([]*Event, error) {
+	n, from, err := unix.Recvfrom(c.fd, c.buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	// only the kernel is trusted
+	if sa, ok := from.(*unix.SockaddrNetlink); !ok || sa.Pid != 0 {
+		return nil, nil
+	}
+	return parseDatagram(c.buf[:n])
+}
+
+// parseDatagram skips the messages which can't be parsed, and returns the
+// first error of them.
+func parseDatagram(b []byte) (events []*Event, err error) {
+	msgs, perr := syscall.ParseNetlinkMessage(b)
+	if perr != nil {
+		return nil, ErrMalformed
+	}
+	for _, msg := range msgs {
+		if msg.Header.Type != unix.NLMSG_DONE {
+			continue
+		}
+		e, perr := Parse(msg.Data)
+		if perr != nil {
+			if err == nil {
+				err = perr
+			}
+			continue
+		}
+		if e != nil {
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// Close unsubscribes and closes the socket.
+func (c *Conn) Close() error {
+	c.control(ProcCnMcastIgnore)
+	return unix.Close(c.fd)
+}
@@ -0,0 +1,71 @@
+package connector
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func message(what uint32, body ...uint32) []byte {
+	data := make([]byte, cnMsgSize+procEventHeaderSize+len(body)*4)
+	nativeEndian.PutUint32(data[0:4], CnIdxProc)
+	nativeEndian.PutUint32(data[4:8], CnValProc)
+	nativeEndian.PutUint16(data[16:18], uint16(procEventHeaderSize+len(body)*4))
+	event := data[cnMsgSize:]
+	nativeEndian.PutUint32(event[0:4], what)
+	nativeEndian.PutUint32(event[4:8], 3)
+	nativeEndian.PutUint64(event[8:16], 42)
+	for i, v := range body {
+		nativeEndian.PutUint32(event[procEventHeaderSize+i*4:], v)
+	}
+	return data
+}
+
+func TestParse(t *testing.T) {
+	e, err := Parse(message(ProcEventFork, 100, 100, 101, 101))
+	if err != nil || e.What != ProcEventFork || e.ParentTgid != 100 || e.Pid != 101 || e.Tgid != 101 || e.Cpu != 3 || e.Timestamp != 42 {
+		t.Errorf("unexpected fork %+v %v", e, err)
+	}
+	e, err = Parse(message(ProcEventExec, 101, 101))
+	if err != nil || e.What != ProcEventExec || e.Pid != 101 {
+		t.Errorf("unexpected exec %+v %v", e, err)
+	}
+	e, err = Parse(message(ProcEventExit, 101, 101, 256, 17, 100, 100))
+	if err != nil || e.ExitCode != 256 || e.ExitSignal != 17 || e.ParentPid != 100 {
+		t.Errorf("unexpected exit %+v %v", e, err)
+	}
+	// uid change
+	if e, err = Parse(message(0x4, 101, 101, 0, 0)); e != nil || err != nil {
+		t.Errorf("unexpected event %+v %v", e, err)
+	}
+	if _, err = Parse(message(ProcEventFork, 100, 100)); err != ErrTruncated {
+		t.Errorf("expected truncated event, got %v", err)
+	}
+}
+
+func netlinkMessage(data []byte) []byte {
+	l := unix.NLMSG_HDRLEN + len(data)
+	b := make([]byte, (l+unix.NLMSG_ALIGNTO-1)&^(unix.NLMSG_ALIGNTO-1))
+	nativeEndian.PutUint32(b[0:4], uint32(l))
+	nativeEndian.PutUint16(b[4:6], unix.NLMSG_DONE)
+	copy(b[unix.NLMSG_HDRLEN:], data)
+	return b
+}
+
+func TestParseDatagram(t *testing.T) {
+	// a truncated event is skipped, the other events of the datagram are kept
+	b := append(netlinkMessage(message(ProcEventFork, 100, 100)), netlinkMessage(message(ProcEventExec, 101, 101))...)
+	events, err := parseDatagram(b)
+	if err != ErrTruncated || len(events) != 1 || events[0].Pid != 101 {
+		t.Errorf("unexpected events %+v %v", events, err)
+	}
+	// the length of the header exceeds the datagram
+	bad := netlinkMessage(message(ProcEventExec, 101, 101))
+	nativeEndian.PutUint32(bad[0:4], uint32(len(bad)+4))
+	if events, err = parseDatagram(bad); err != ErrMalformed || events != nil {
+		t.Errorf("unexpected events %+v %v", events, err)
+	}
+	if events, err = parseDatagram(netlinkMessage(message(ProcEventExit, 101, 101, 0, 17))); err != nil || len(events) != 1 {
+		t.Errorf("unexpected events %+v %v", events, err)
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/bytedance/Elkeid/plugins/proc_connector
+
+go 1.18
+
+replace github.com/bytedance/plugins => ../lib/go
+
+require (
+	github.com/bytedance/plugins v0.0.0-20220826022814-07b31790f447
+	github.com/cespare/xxhash/v2 v2.1.2
+	github.com/hashicorp/golang-lru v1.0.2
+	go.uber.org/zap v1.20.0
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f
+)
+
+require (
+	github.com/gogo/protobuf v1.3.2 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
+)
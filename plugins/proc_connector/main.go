@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/Elkeid/plugins/proc_connector/connector"
+	plugins "github.com/bytedance/plugins"
+	"github.com/bytedance/plugins/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ExecveDataType = 59
+	ExitDataType   = 60
+	// the kernel module of the driver plugin, see plugins/driver/src/config.rs
+	KmodPath = "/sys/module/hids_driver"
+)
+
+func init() {
+	runtime.GOMAXPROCS(2)
+}
+
+func main() {
+	c := plugins.New()
+	l := log.New(
+		log.Config{
+			MaxSize:     1,
+			Path:        "proc_connector.log",
+			FileLevel:   zapcore.InfoLevel,
+			RemoteLevel: zapcore.ErrorLevel,
+			MaxBackups:  10,
+			Compress:    true,
+			Client:      c,
+		},
+	)
+	defer l.Sync()
+	zap.ReplaceGlobals(l)
+	conn, err := connector.Dial()
+	if err != nil {
+		zap.S().Errorf("subscribe to proc connector failed: %v", err)
+		// wait to be stopped rather than be restarted over and over
+		for {
+			if _, err := c.ReceiveTask(); err != nil {
+				return
+			}
+		}
+	}
+	// the driver reports the same events, the fallback keeps silent while the
+	// kernel module is loaded
+	var driverLoaded int32
+	go func() {
+		for {
+			loaded := int32(0)
+			if _, err := os.Stat(KmodPath); err == nil {
+				loaded = 1
+			}
+			if atomic.SwapInt32(&driverLoaded, loaded) != loaded {
+				zap.S().Infof("kernel module loaded: %v", loaded == 1)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+	go func() {
+		for {
+			if _, err := c.ReceiveTask(); err != nil {
+				zap.S().Info("plugin stopped")
+				conn.Close()
+				return
+			}
+		}
+	}()
+	t := newTracker("/proc")
+	dropped, malformed := 0, 0
+	for {
+		events, err := conn.Receive()
+		if errors.Is(err, unix.ENOBUFS) {
+			dropped++
+			if dropped%100 == 1 {
+				zap.S().Warnf("proc events dropped %d times", dropped)
+			}
+			continue
+		}
+		if errors.Is(err, connector.ErrTruncated) || errors.Is(err, connector.ErrMalformed) {
+			// only the bad messages are skipped, the other events are handled
+			malformed++
+			if malformed%100 == 1 {
+				zap.S().Warnf("malformed proc events %d times: %v", malformed, err)
+			}
+		} else if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			// the socket is closed once the plugin is stopped
+			zap.S().Info(err)
+			break
+		}
+		if atomic.LoadInt32(&driverLoaded) == 1 {
+			continue
+		}
+		for _, e := range events {
+			// threads are reported as well
+			if e.Pid != e.Tgid {
+				continue
+			}
+			var (
+				dt     int32
+				fields map[string]string
+			)
+			switch e.What {
+			case connector.ProcEventFork:
+				t.fork(int(e.ParentTgid), int(e.Pid))
+				continue
+			case connector.ProcEventExec:
+				dt, fields = ExecveDataType, t.exec(int(e.Pid))
+			case connector.ProcEventExit:
+				dt, fields = ExitDataType, t.exit(int(e.Pid))
+			}
+			if fields == nil {
+				continue
+			}
+			c.SendRecord(&plugins.Record{
+				DataType:  dt,
+				Timestamp: time.Now().Unix(),
+				Data:      &plugins.Payload{Fields: fields},
+			})
+		}
+	}
+	c.Flush()
+}
@@ -0,0 +1,230 @@
+// Package proc reads the attributes of a process which the driver reports
+// from the task struct, from procfs instead.
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+var ErrInvalidStat = errors.New("invalid stat")
+
+// socket tables are printed in the byte order of the host
+var nativeLittleEndian = func() bool {
+	i := uint16(1)
+	return (*[2]byte)(unsafe.Pointer(&i))[0] == 1
+}()
+
+// Stat is the part of /proc/<pid>/stat which is reported.
+type Stat struct {
+	Comm  string
+	Ppid  int
+	Pgid  int
+	Sid   int
+	TtyNr int
+}
+
+// ParseStat parses /proc/<pid>/stat, comm is the one between the first '(' and the last ')'.
+func ParseStat(data []byte) (*Stat, error) {
+	start, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return nil, ErrInvalidStat
+	}
+	// state ppid pgrp session tty_nr
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 5 {
+		return nil, ErrInvalidStat
+	}
+	s := &Stat{Comm: string(data[start+1 : end])}
+	var err error
+	for i, v := range []*int{&s.Ppid, &s.Pgid, &s.Sid, &s.TtyNr} {
+		if *v, err = strconv.Atoi(fields[i+1]); err != nil {
+			return nil, ErrInvalidStat
+		}
+	}
+	return s, nil
+}
+
+// TTYName returns the name of a tty by its device number like the kernel
+// names it, e.g. pts0 or tty1, "-1" if there isn't one.
+func TTYName(nr int) string {
+	major, minor := (nr>>8)&0xfff, (nr&0xff)|((nr>>12)&0xfff00)
+	switch {
+	case nr == 0:
+		return "-1"
+	case major >= 136 && major <= 143:
+		return "pts" + strconv.Itoa((major-136)*256+minor)
+	case major == 4 && minor < 64:
+		return "tty" + strconv.Itoa(minor)
+	case major == 4:
+		return "ttyS" + strconv.Itoa(minor-64)
+	}
+	return "-1"
+}
+
+// Uid returns the real uid of /proc/<pid>/status.
+func Uid(status []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(status))
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, "Uid:") {
+			if fields := strings.Fields(line[4:]); len(fields) != 0 {
+				return fields[0]
+			}
+		}
+	}
+	return "-1"
+}
+
+// Cmdline joins the args of /proc/<pid>/cmdline by spaces, at most size bytes.
+func Cmdline(data []byte, size int) string {
+	if len(data) > size {
+		data = data[:size]
+	}
+	data = bytes.TrimRight(bytes.ReplaceAll(data, []byte{0}, []byte{' '}), " ")
+	return string(data)
+}
+
+// Env returns the values of the keys of /proc/<pid>/environ, "-1" for the missing ones.
+func Env(environ []byte, keys ...string) []string {
+	ret := make([]string, len(keys))
+	for i := range ret {
+		ret[i] = "-1"
+	}
+	for _, kv := range bytes.Split(environ, []byte{0}) {
+		for i, k := range keys {
+			if bytes.HasPrefix(kv, []byte(k+"=")) {
+				ret[i] = string(kv[len(k)+1:])
+			}
+		}
+	}
+	return ret
+}
+
+// Inode returns the inode of a namespace or socket link, e.g. pid:[4026531836].
+func Inode(link string) (uint64, bool) {
+	start, end := strings.IndexByte(link, '['), strings.LastIndexByte(link, ']')
+	if start < 0 || end < start {
+		return 0, false
+	}
+	i, err := strconv.ParseUint(link[start+1:end], 10, 64)
+	return i, err == nil
+}
+
+// Socket is an inet socket of a socket table, e.g. /proc/<pid>/net/tcp.
+type Socket struct {
+	Family int
+	SIP    net.IP
+	SPort  int
+	DIP    net.IP
+	DPort  int
+	Inode  uint64
+	State  int
+}
+
+// TCP_LISTEN of include/net/tcp_states.h
+const stateListen = 10
+
+// Connected reports whether the socket has a peer, i.e. it's connecting,
+// connected or disconnecting like the driver checks.
+func (s *Socket) Connected() bool {
+	return s.State != stateListen && s.DPort != 0
+}
+
+func parseAddr(s string) (net.IP, int, error) {
+	addr, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(addr)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	if nativeLittleEndian {
+		for i := 0; i < len(b); i += 4 {
+			b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+		}
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port %q", s)
+	}
+	return net.IP(b), int(p), nil
+}
+
+// ParseSockets parses a socket table of tcp or udp, of inodes if not nil.
+func ParseSockets(r io.Reader, inodes map[uint64]bool) ([]*Socket, error) {
+	ret := []*Socket{}
+	s := bufio.NewScanner(r)
+	// header
+	s.Scan()
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || (inodes != nil && !inodes[inode]) {
+			continue
+		}
+		sock := &Socket{Inode: inode}
+		if sock.SIP, sock.SPort, err = parseAddr(fields[1]); err != nil {
+			return ret, err
+		}
+		if sock.DIP, sock.DPort, err = parseAddr(fields[2]); err != nil {
+			return ret, err
+		}
+		state, _ := strconv.ParseUint(fields[3], 16, 8)
+		sock.State = int(state)
+		sock.Family = 2
+		if len(sock.SIP) == net.IPv6len {
+			sock.Family = 10
+		}
+		ret = append(ret, sock)
+	}
+	return ret, s.Err()
+}
+
+// FormatIP formats an ip like the driver, i.e. dotted ipv4 and all of the 8
+// groups of ipv6.
+func FormatIP(ip net.IP) string {
+	if len(ip) == net.IPv4len {
+		return ip.String()
+	}
+	groups := make([]string, 8)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%02x%02x", ip[i*2], ip[i*2+1])
+	}
+	return strings.Join(groups, ":")
+}
+
+// SocketInodes returns the inodes of the sockets among the first limit fds
+// of a process.
+func SocketInodes(root string, pid, limit int) map[uint64]bool {
+	ret := map[uint64]bool{}
+	dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+	for fd := 0; fd < limit; fd++ {
+		link, err := os.Readlink(filepath.Join(dir, strconv.Itoa(fd)))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(link, "socket:") {
+			if inode, ok := Inode(link); ok {
+				ret[inode] = true
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,50 @@
+package proc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStat(t *testing.T) {
+	s, err := ParseStat([]byte("4242 (a (b) c) S 1 4242 4242 34816 4242 4194560 153 0 0 0"))
+	if err != nil || s.Comm != "a (b) c" || s.Ppid != 1 || s.Pgid != 4242 || s.Sid != 4242 || s.TtyNr != 34816 {
+		t.Errorf("unexpected stat %+v %v", s, err)
+	}
+	if TTYName(s.TtyNr) != "pts0" || TTYName(0x401) != "tty1" || TTYName(0) != "-1" {
+		t.Errorf("unexpected tty names")
+	}
+	if _, err = ParseStat([]byte("4242 (bash")); err != ErrInvalidStat {
+		t.Errorf("expected invalid stat, got %v", err)
+	}
+}
+
+func TestParseSockets(t *testing.T) {
+	if !nativeLittleEndian {
+		t.Skip("tables below are printed by little endian hosts")
+	}
+	table := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:A2C4 0101A8C0:115C 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+`
+	socks, err := ParseSockets(strings.NewReader(table), map[uint64]bool{1001: true, 1002: true})
+	if err != nil || len(socks) != 2 {
+		t.Fatalf("unexpected sockets %+v %v", socks, err)
+	}
+	if socks[0].Connected() {
+		t.Errorf("listening socket is connected")
+	}
+	s := socks[1]
+	if !s.Connected() || FormatIP(s.SIP) != "127.0.0.1" || s.SPort != 41668 || FormatIP(s.DIP) != "192.168.1.1" || s.DPort != 4444 || s.Family != 2 {
+		t.Errorf("unexpected socket %+v", s)
+	}
+	table6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0000000000000000FFFF00000100007F:A2C4 00000000000000000000000001000000:115C 01 00000000:00000000 00:00000000 00000000     0        0 2001 1 0000000000000000 20 4 30 10 -1
+`
+	socks, err = ParseSockets(strings.NewReader(table6), nil)
+	if err != nil || len(socks) != 1 {
+		t.Fatalf("unexpected sockets %+v %v", socks, err)
+	}
+	if FormatIP(socks[0].SIP) != "0000:0000:0000:0000:0000:ffff:7f00:0001" || FormatIP(socks[0].DIP) != "0000:0000:0000:0000:0000:0000:0000:0001" || socks[0].Family != 10 {
+		t.Errorf("unexpected socket %+v", socks[0])
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bytedance/Elkeid/plugins/proc_connector/proc"
+	"github.com/cespare/xxhash/v2"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// limits of the driver, see driver/LKM/src/smith_hook.c
+	pidTreeLimit    = 12
+	pidTreeLimitLow = 8
+	sockPidLimit    = 4
+	sockFdLimit     = 12
+	// size of argv at most
+	argvSize = 1024
+	// size of cached argv of parents at most, like the driver plugin
+	cachedArgvSize = 256
+	// size of the head of an exe which is hashed
+	hashSize = 32 * 1024
+)
+
+// the fields of exits, i.e. the common fields and the ones filled by the driver plugin
+var exitKeys = []string{
+	"uid", "exe", "pid", "ppid", "pgid", "tgid", "sid", "comm", "nodename", "sessionid", "pns", "root_pns",
+	"argv", "ppid_argv", "pgid_argv", "username", "pod_name", "exe_hash", "pid_tree",
+}
+
+// tracker fills the fields of the driver from procfs. The fields of execs
+// and forks are kept by pid since procfs is gone when the process exits.
+type tracker struct {
+	procfs  string
+	rootPns string
+	// pid -> argv
+	argv *lru.Cache
+	// dev, inode and mtime of exe -> hash
+	hash *lru.Cache
+	// pid -> fields of exit
+	procs *lru.Cache
+	// uts ns -> nodename
+	nodes *lru.Cache
+	// pid ns -> pod name
+	pods  *lru.Cache
+	users map[string]string
+}
+
+func newTracker(procfs string) *tracker {
+	t := &tracker{procfs: procfs, users: map[string]string{}}
+	t.argv, _ = lru.New(8192)
+	t.hash, _ = lru.New(4096)
+	t.procs, _ = lru.New(16384)
+	t.nodes, _ = lru.New(1024)
+	t.pods, _ = lru.New(1024)
+	// the plugin runs in the root namespaces like the agent
+	t.rootPns = t.ns(os.Getpid(), "pid")
+	return t
+}
+
+func (t *tracker) path(pid int, elem ...string) string {
+	return filepath.Join(append([]string{t.procfs, strconv.Itoa(pid)}, elem...)...)
+}
+
+func (t *tracker) readlink(pid int, elem ...string) string {
+	if link, err := os.Readlink(t.path(pid, elem...)); err == nil {
+		return link
+	}
+	return "-2"
+}
+
+func (t *tracker) stat(pid int) (*proc.Stat, error) {
+	data, err := os.ReadFile(t.path(pid, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return proc.ParseStat(data)
+}
+
+func (t *tracker) ns(pid int, name string) string {
+	if inode, ok := proc.Inode(t.readlink(pid, "ns", name)); ok {
+		return strconv.FormatUint(inode, 10)
+	}
+	return "-2"
+}
+
+// argvOf returns the argv of a process, cached by pid
+func (t *tracker) argvOf(pid string) string {
+	if v, ok := t.argv.Get(pid); ok {
+		return v.(string)
+	}
+	p, err := strconv.Atoi(pid)
+	if err != nil || p <= 0 {
+		return "-3"
+	}
+	data, err := os.ReadFile(t.path(p, "cmdline"))
+	if err != nil {
+		return "-3"
+	}
+	argv := proc.Cmdline(data, cachedArgvSize)
+	t.argv.Add(pid, argv)
+	return argv
+}
+
+func (t *tracker) username(uid string) string {
+	if name, ok := t.users[uid]; ok {
+		return name
+	}
+	name := "-3"
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	t.users[uid] = name
+	return name
+}
+
+// exeHash hashes the size and the head of the exe like the driver plugin,
+// the exe is opened by the link of procfs for the ones of containers.
+func (t *tracker) exeHash(pid int) string {
+	f, err := os.Open(t.path(pid, "exe"))
+	if err != nil {
+		return "-3"
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "-3"
+	}
+	key := fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		key = fmt.Sprintf("%d-%d-%s", st.Dev, st.Ino, key)
+	}
+	if v, ok := t.hash.Get(key); ok {
+		return v.(string)
+	}
+	d := xxhash.New()
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(fi.Size()))
+	d.Write(size)
+	if _, err := io.Copy(d, io.LimitReader(f, hashSize)); err != nil {
+		return "-3"
+	}
+	hash := fmt.Sprintf("%016x", d.Sum64())
+	t.hash.Add(key, hash)
+	return hash
+}
+
+// nodename returns the hostname of the uts namespace of a process, the ones
+// of containers are read from their /etc/hostname.
+func (t *tracker) nodename(pid int) string {
+	uts := t.ns(pid, "uts")
+	if v, ok := t.nodes.Get(uts); ok {
+		return v.(string)
+	}
+	name := "-2"
+	if uts == t.ns(os.Getpid(), "uts") {
+		if h, err := os.Hostname(); err == nil {
+			name = h
+		}
+	} else if data, err := os.ReadFile(t.path(pid, "root", "etc", "hostname")); err == nil {
+		name = strings.TrimSpace(string(data))
+	}
+	t.nodes.Add(uts, name)
+	return name
+}
+
+// podName returns the pod name of the environment of a process in a container
+func (t *tracker) podName(pid int, pns string) string {
+	if pns == t.rootPns {
+		return "-3"
+	}
+	if v, ok := t.pods.Get(pns); ok {
+		return v.(string)
+	}
+	environ, err := os.ReadFile(t.path(pid, "environ"))
+	if err != nil {
+		return "-3"
+	}
+	name := ""
+	for _, v := range proc.Env(environ, "MY_POD_NAME", "POD_NAME") {
+		if v != "-1" {
+			name = v
+			break
+		}
+	}
+	t.pods.Add(pns, name)
+	return name
+}
+
+// pidTree walks up the parents, the ones which exited are taken from the
+// tree of their exec or fork.
+func (t *tracker) pidTree(pid int, comm string, ppid, limit int) string {
+	tree := []string{strconv.Itoa(pid) + "." + comm}
+	for p := ppid; p > 0 && len(tree) < limit; {
+		st, err := t.stat(p)
+		if err != nil {
+			if v, ok := t.procs.Get(strconv.Itoa(p)); ok {
+				cached := strings.Split(v.(map[string]string)["pid_tree"], "<")
+				if len(cached) > limit-len(tree) {
+					cached = cached[:limit-len(tree)]
+				}
+				tree = append(tree, cached...)
+			}
+			break
+		}
+		tree = append(tree, strconv.Itoa(p)+"."+st.Comm)
+		p = st.Ppid
+	}
+	return strings.Join(tree, "<")
+}
+
+// socket looks up the first connected inet socket among the fds of a process
+// and its parents, like the driver.
+func (t *tracker) socket(pid int, fields map[string]string) {
+	for _, k := range []string{"dip", "dport", "sip", "sport", "sa_family", "socket_pid"} {
+		fields[k] = "-1"
+	}
+	for i, p := 0, pid; i < sockPidLimit && p > 1; i++ {
+		inodes := proc.SocketInodes(t.procfs, p, sockFdLimit)
+		if len(inodes) != 0 {
+			for _, table := range []string{"tcp", "tcp6", "udp", "udp6"} {
+				f, err := os.Open(t.path(p, "net", table))
+				if err != nil {
+					continue
+				}
+				socks, _ := proc.ParseSockets(f, inodes)
+				f.Close()
+				for _, s := range socks {
+					if s.Connected() {
+						fields["dip"], fields["dport"] = proc.FormatIP(s.DIP), strconv.Itoa(s.DPort)
+						fields["sip"], fields["sport"] = proc.FormatIP(s.SIP), strconv.Itoa(s.SPort)
+						fields["sa_family"], fields["socket_pid"] = strconv.Itoa(s.Family), strconv.Itoa(p)
+						return
+					}
+				}
+			}
+		}
+		st, err := t.stat(p)
+		if err != nil {
+			return
+		}
+		p = st.Ppid
+	}
+}
+
+// exec returns the fields of an execve record, nil if the process is gone.
+func (t *tracker) exec(pid int) map[string]string {
+	st, err := t.stat(pid)
+	if err != nil {
+		return nil
+	}
+	status, err := os.ReadFile(t.path(pid, "status"))
+	if err != nil {
+		return nil
+	}
+	fields := make(map[string]string, 34)
+	fields["uid"] = proc.Uid(status)
+	fields["exe"] = t.readlink(pid, "exe")
+	fields["pid"] = strconv.Itoa(pid)
+	fields["ppid"] = strconv.Itoa(st.Ppid)
+	fields["pgid"] = strconv.Itoa(st.Pgid)
+	fields["tgid"] = fields["pid"]
+	fields["sid"] = strconv.Itoa(st.Sid)
+	fields["comm"] = st.Comm
+	fields["nodename"] = t.nodename(pid)
+	fields["sessionid"] = "-2"
+	if data, err := os.ReadFile(t.path(pid, "sessionid")); err == nil {
+		fields["sessionid"] = strings.TrimSpace(string(data))
+	}
+	fields["pns"] = t.ns(pid, "pid")
+	fields["root_pns"] = t.rootPns
+	fields["argv"] = "-2"
+	if data, err := os.ReadFile(t.path(pid, "cmdline")); err == nil {
+		fields["argv"] = proc.Cmdline(data, argvSize)
+		t.argv.Add(fields["pid"], proc.Cmdline(data, cachedArgvSize))
+	}
+	fields["run_path"] = t.readlink(pid, "cwd")
+	fields["stdin"] = t.readlink(pid, "fd", "0")
+	fields["stdout"] = t.readlink(pid, "fd", "1")
+	t.socket(pid, fields)
+	limit := pidTreeLimitLow
+	if fields["socket_pid"] != "-1" {
+		limit = pidTreeLimit
+	}
+	fields["pid_tree"] = t.pidTree(pid, st.Comm, st.Ppid, limit)
+	fields["tty"] = proc.TTYName(st.TtyNr)
+	fields["ssh"], fields["ld_preload"] = "-1", "-1"
+	if environ, err := os.ReadFile(t.path(pid, "environ")); err == nil {
+		env := proc.Env(environ, "SSH_CONNECTION", "LD_PRELOAD")
+		fields["ssh"], fields["ld_preload"] = env[0], env[1]
+	}
+	// the connector only reports the successful ones
+	fields["res"] = "0"
+	fields["socket_argv"] = t.argvOf(fields["socket_pid"])
+	fields["ppid_argv"] = t.argvOf(fields["ppid"])
+	fields["pgid_argv"] = t.argvOf(fields["pgid"])
+	fields["username"] = t.username(fields["uid"])
+	fields["pod_name"] = t.podName(pid, fields["pns"])
+	fields["exe_hash"] = t.exeHash(pid)
+	t.procs.Add(fields["pid"], pick(fields, exitKeys))
+	return fields
+}
+
+// fork inherits the fields of the parent if it was seen
+func (t *tracker) fork(ppid, pid int) {
+	v, ok := t.procs.Get(strconv.Itoa(ppid))
+	if !ok {
+		return
+	}
+	fields := pick(v.(map[string]string), exitKeys)
+	fields["pid"], fields["tgid"], fields["ppid"] = strconv.Itoa(pid), strconv.Itoa(pid), strconv.Itoa(ppid)
+	fields["ppid_argv"] = fields["argv"]
+	tree := strings.SplitN(fields["pid_tree"], "<", pidTreeLimit)
+	comm := strings.SplitN(tree[0], ".", 2)
+	if len(comm) == 2 {
+		tree = append([]string{fields["pid"] + "." + comm[1]}, tree...)
+		if len(tree) > pidTreeLimit {
+			tree = tree[:pidTreeLimit]
+		}
+		fields["pid_tree"] = strings.Join(tree, "<")
+	}
+	t.argv.Add(fields["pid"], fields["argv"])
+	t.procs.Add(fields["pid"], fields)
+}
+
+// exit returns the fields of a process exit record, nil if the process wasn't seen.
+func (t *tracker) exit(pid int) map[string]string {
+	key := strconv.Itoa(pid)
+	t.argv.Remove(key)
+	v, ok := t.procs.Get(key)
+	if !ok {
+		return nil
+	}
+	t.procs.Remove(key)
+	return v.(map[string]string)
+}
+
+func pick(m map[string]string, keys []string) map[string]string {
+	ret := make(map[string]string, len(keys))
+	for _, k := range keys {
+		ret[k] = m[k]
+	}
+	return ret
+}
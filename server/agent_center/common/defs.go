@@ -7,6 +7,16 @@ import (
 	"github.com/spf13/viper"
 )
 
+const (
+	// AgentCertModePermissive accept both the shared client certificate and the per-agent certificate.
+	AgentCertModePermissive = "permissive"
+	// AgentCertModeStrict the shared client certificate can only be used to enroll the per-agent certificate.
+	AgentCertModeStrict = "strict"
+
+	ConnAuthFailPolicyOpen   = "open"
+	ConnAuthFailPolicyClosed = "closed"
+)
+
 var (
 	Sig = make(chan os.Signal, 1)
 
@@ -19,14 +29,17 @@ var (
 	FileDir  string = "./file/"
 
 	ManageAddrs []string // addrlist of Management Center
+	ManageAK    string   // access key, which use for sign the inner request to Management Center
+	ManageSK    string   // secret key, which use for sign the inner request to Management Center
 
 	SdAddrs []string // addrlist of service discovery center
 	SvrName string   // Name registered to the service discovery center
 	SvrAK   string   // access key, which use for http sign
 	SvrSK   string   // secret key, which use for http sign
 
-	GRPCPort  int //grpc
-	ConnLimit int
+	GRPCPort      int //grpc
+	ConnLimit     int
+	AgentCertMode string // permissive or strict, see conf/svr.yml
 
 	HttpPort           int
 	HttpSSLEnable      bool
@@ -43,4 +56,14 @@ var (
 
 	RawDataPort   int
 	ManagerServer string
+
+	//connection auth of the agent, see conf/svr.yml
+	ConnAuthCacheTTL    int
+	ConnAuthNegativeTTL int
+	ConnAuthStaleTTL    int
+	ConnAuthBatchSize   int
+	ConnAuthBatchWait   int
+	ConnAuthConcurrency int
+	ConnAuthFailOpen    bool
+	ConnAuthCacheFile   string
 )
@@ -52,10 +52,24 @@ func initDefault() {
 	SvrSK = UserConfig.GetString("sd.auth.sk")
 
 	ManageAddrs = UserConfig.GetStringSlice("manage.addrs")
+	ManageAK = strings.ToLower(UserConfig.GetString("manage.auth.ak"))
+	ManageSK = UserConfig.GetString("manage.auth.sk")
 	ManagerServer = UserConfig.GetString("manager_server.addr")
+	ConnAuthCacheTTL = UserConfig.GetInt("manager_server.auth.cache_ttl")
+	ConnAuthNegativeTTL = UserConfig.GetInt("manager_server.auth.negative_ttl")
+	ConnAuthStaleTTL = UserConfig.GetInt("manager_server.auth.stale_ttl")
+	ConnAuthBatchSize = UserConfig.GetInt("manager_server.auth.batch_size")
+	ConnAuthBatchWait = UserConfig.GetInt("manager_server.auth.batch_wait")
+	ConnAuthConcurrency = UserConfig.GetInt("manager_server.auth.concurrency")
+	ConnAuthFailOpen = UserConfig.GetString("manager_server.auth.fail_policy") == ConnAuthFailPolicyOpen
+	ConnAuthCacheFile = UserConfig.GetString("manager_server.auth.cache_file")
 
 	GRPCPort = UserConfig.GetInt("server.grpc.port")
 	ConnLimit = UserConfig.GetInt("server.grpc.connlimit")
+	AgentCertMode = UserConfig.GetString("server.grpc.agent_cert_mode")
+	if AgentCertMode != AgentCertModeStrict {
+		AgentCertMode = AgentCertModePermissive
+	}
 
 	HttpPort = UserConfig.GetInt("server.http.port")
 	HttpSSLEnable = UserConfig.GetBool("server.http.ssl.enable")
@@ -0,0 +1,271 @@
+package connauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Outcome of the connection auth, used as the label of metrics.
+const (
+	OutcomeSuccess     = "success"
+	OutcomeReject      = "reject"
+	OutcomeCacheHit    = "cache_hit"
+	OutcomeNegativeHit = "negative_hit"
+	OutcomeStale       = "stale"
+	OutcomeFailOpen    = "fail_open"
+	OutcomeFailClosed  = "fail_closed"
+
+	statusSuccess = 200
+)
+
+var (
+	ErrBusy     = errors.New("too many pending auth requests")
+	ErrTimeout  = errors.New("auth request timeout")
+	ErrMismatch = errors.New("the number of auth results does not match the requests")
+)
+
+// Request the body of the connAuth request.
+type Request struct {
+	TenantAuthCode string `json:"tenantAuthCode"`
+	AgentID        string `json:"agentId"`
+	AgentVersion   string `json:"agentVersion"`
+	ExtIP          string `json:"extIp"`
+}
+
+func (r Request) key() string {
+	return strings.Join([]string{r.TenantAuthCode, r.AgentID, r.AgentVersion, r.ExtIP}, "|")
+}
+
+// Result the connection is accepted if Status is 200.
+type Result struct {
+	Status   int    `json:"status"`
+	Msg      string `json:"msg"`
+	TenantID int64  `json:"tenantId"`
+	HostID   int64  `json:"hostId"`
+}
+
+func (r Result) OK() bool {
+	return r.Status == statusSuccess
+}
+
+// FetchFunc ask the manager, the results must be in the same order as the requests.
+type FetchFunc func(reqs []Request) ([]Result, error)
+
+type cacheEntry struct {
+	result Result
+	fresh  time.Time
+}
+
+type fetched struct {
+	result Result
+	err    error
+}
+
+type pending struct {
+	req Request
+	res chan fetched
+}
+
+// Authenticator cache the auth results, and merge the requests to the manager.
+type Authenticator struct {
+	conf    *Config
+	fetch   FetchFunc
+	cache   *cache.Cache
+	reqChan chan *pending
+}
+
+func New(conf *Config, fetch FetchFunc) *Authenticator {
+	if conf.BatchSize < 1 {
+		conf.BatchSize = 1
+	}
+	if conf.Workers < 1 {
+		conf.Workers = 1
+	}
+	if conf.StaleTTL < conf.CacheTTL {
+		conf.StaleTTL = conf.CacheTTL
+	}
+	a := &Authenticator{
+		conf:    conf,
+		fetch:   fetch,
+		cache:   cache.New(conf.StaleTTL, time.Minute),
+		reqChan: make(chan *pending, conf.ChanLen),
+	}
+	for i := 0; i < conf.Workers; i++ {
+		go a.worker()
+	}
+	return a
+}
+
+// Auth returns the result and the outcome of the request, the connection should be rejected if the error is not nil.
+func (a *Authenticator) Auth(req Request) (Result, string, error) {
+	key := req.key()
+	if v, ok := a.cache.Get(key); ok {
+		entry := v.(*cacheEntry)
+		if time.Now().Before(entry.fresh) {
+			if entry.result.OK() {
+				return entry.result, OutcomeCacheHit, nil
+			}
+			return entry.result, OutcomeNegativeHit, rejectError(entry.result)
+		}
+	}
+
+	var res fetched
+	p := &pending{req: req, res: make(chan fetched, 1)}
+	timeout := time.NewTimer(a.conf.Timeout)
+	defer timeout.Stop()
+	select {
+	case a.reqChan <- p:
+		select {
+		case res = <-p.res:
+		case <-timeout.C:
+			res.err = ErrTimeout
+		}
+	default:
+		res.err = ErrBusy
+	}
+
+	if res.err == nil {
+		if res.result.OK() {
+			return res.result, OutcomeSuccess, nil
+		}
+		return res.result, OutcomeReject, rejectError(res.result)
+	}
+
+	//the manager is unreachable, use the last accepted result if any
+	if v, ok := a.cache.Get(key); ok {
+		if entry := v.(*cacheEntry); entry.result.OK() {
+			return entry.result, OutcomeStale, nil
+		}
+	}
+	if a.conf.FailOpen {
+		return Result{}, OutcomeFailOpen, nil
+	}
+	return Result{}, OutcomeFailClosed, res.err
+}
+
+func rejectError(r Result) error {
+	return fmt.Errorf("auth rejected, status %d, msg %s", r.Status, r.Msg)
+}
+
+func (a *Authenticator) worker() {
+	batch := make([]*pending, 0, a.conf.BatchSize)
+	for {
+		batch = append(batch[:0], <-a.reqChan)
+		if a.conf.BatchSize > 1 {
+			timer := time.NewTimer(a.conf.BatchWait)
+		collect:
+			for len(batch) < a.conf.BatchSize {
+				select {
+				case p := <-a.reqChan:
+					batch = append(batch, p)
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+		}
+		a.do(batch)
+	}
+}
+
+// do merge the same requests, and send them to the manager.
+func (a *Authenticator) do(batch []*pending) {
+	index := make(map[string]int, len(batch))
+	reqs := make([]Request, 0, len(batch))
+	for _, p := range batch {
+		key := p.req.key()
+		if _, ok := index[key]; !ok {
+			index[key] = len(reqs)
+			reqs = append(reqs, p.req)
+		}
+	}
+
+	start := time.Now()
+	results, err := a.fetch(reqs)
+	if err == nil && len(results) != len(reqs) {
+		err = ErrMismatch
+	}
+	if a.conf.Observer != nil {
+		a.conf.Observer(len(reqs), time.Since(start), err)
+	}
+
+	if err == nil {
+		now := time.Now()
+		for i, r := range results {
+			if r.OK() {
+				a.cache.Set(reqs[i].key(), &cacheEntry{result: r, fresh: now.Add(a.conf.CacheTTL)}, a.conf.StaleTTL)
+			} else {
+				a.cache.Set(reqs[i].key(), &cacheEntry{result: r, fresh: now.Add(a.conf.NegativeTTL)}, a.conf.NegativeTTL)
+			}
+		}
+	}
+	for _, p := range batch {
+		res := fetched{err: err}
+		if err == nil {
+			res.result = results[index[p.req.key()]]
+		}
+		p.res <- res
+	}
+}
+
+type savedEntry struct {
+	Key    string    `json:"key"`
+	Result Result    `json:"result"`
+	Fresh  time.Time `json:"fresh"`
+	Expire time.Time `json:"expire"`
+}
+
+// Save writes the accepted results which haven't expired to the file.
+func (a *Authenticator) Save(path string) error {
+	items := a.cache.Items()
+	entries := make([]savedEntry, 0, len(items))
+	for k, v := range items {
+		entry := v.Object.(*cacheEntry)
+		if !entry.result.OK() || v.Expired() {
+			continue
+		}
+		entries = append(entries, savedEntry{Key: k, Result: entry.result, Fresh: entry.fresh, Expire: time.Unix(0, v.Expiration)})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads the results saved by Save, those which have expired are dropped. The results in the cache are kept.
+func (a *Authenticator) Load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var entries []savedEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	n := 0
+	for _, e := range entries {
+		if !e.Result.OK() || !now.Before(e.Expire) {
+			continue
+		}
+		if e.Expire.Sub(now) > a.conf.StaleTTL {
+			e.Expire = now.Add(a.conf.StaleTTL)
+		}
+		if a.cache.Add(e.Key, &cacheEntry{result: e.Result, fresh: e.Fresh}, e.Expire.Sub(now)) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
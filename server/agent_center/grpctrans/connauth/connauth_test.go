@@ -0,0 +1,184 @@
+package connauth
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeManager struct {
+	calls   int32
+	reqs    int32
+	down    atomic.Value //bool
+	release chan struct{}
+}
+
+func (m *fakeManager) fetch(reqs []Request) ([]Result, error) {
+	atomic.AddInt32(&m.calls, 1)
+	atomic.AddInt32(&m.reqs, int32(len(reqs)))
+	if m.release != nil {
+		<-m.release
+	}
+	if down, _ := m.down.Load().(bool); down {
+		return nil, errors.New("connection refused")
+	}
+	res := make([]Result, 0, len(reqs))
+	for _, r := range reqs {
+		if r.TenantAuthCode == "bad" {
+			res = append(res, Result{Status: 403, Msg: "invalid tenant"})
+		} else {
+			res = append(res, Result{Status: 200, TenantID: 1, HostID: 2})
+		}
+	}
+	return res, nil
+}
+
+func TestAuthCache(t *testing.T) {
+	m := &fakeManager{}
+	conf := NewConfig()
+	conf.CacheTTL = 50 * time.Millisecond
+	a := New(conf, m.fetch)
+
+	good := Request{TenantAuthCode: "good", AgentID: "a1"}
+	if r, outcome, err := a.Auth(good); err != nil || outcome != OutcomeSuccess || r.HostID != 2 {
+		t.Fatalf("unexpected result %v %s %v", r, outcome, err)
+	}
+	if _, outcome, err := a.Auth(good); err != nil || outcome != OutcomeCacheHit {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+	bad := Request{TenantAuthCode: "bad", AgentID: "a2"}
+	if _, outcome, err := a.Auth(bad); err == nil || outcome != OutcomeReject {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+	if _, outcome, err := a.Auth(bad); err == nil || outcome != OutcomeNegativeHit {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+	if calls := atomic.LoadInt32(&m.calls); calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+
+	// the expired accepted result is used when the manager is down
+	time.Sleep(60 * time.Millisecond)
+	m.down.Store(true)
+	if r, outcome, err := a.Auth(good); err != nil || outcome != OutcomeStale || r.TenantID != 1 {
+		t.Fatalf("unexpected result %v %s %v", r, outcome, err)
+	}
+	if _, outcome, err := a.Auth(Request{AgentID: "a3"}); err == nil || outcome != OutcomeFailClosed {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+	a.conf.FailOpen = true
+	if _, outcome, err := a.Auth(Request{AgentID: "a3"}); err != nil || outcome != OutcomeFailOpen {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+}
+
+func TestAuthBatch(t *testing.T) {
+	m := &fakeManager{release: make(chan struct{})}
+	conf := NewConfig()
+	conf.BatchSize = 100
+	conf.BatchWait = 20 * time.Millisecond
+	conf.Workers = 1
+	var batches []int
+	conf.Observer = func(size int, cost time.Duration, err error) {
+		batches = append(batches, size)
+	}
+	a := New(conf, m.fetch)
+	close(m.release)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every agent reconnects twice
+			req := Request{TenantAuthCode: "good", AgentID: string(rune('a' + i%25))}
+			if _, _, err := a.Auth(req); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if calls := atomic.LoadInt32(&m.calls); calls > 3 {
+		t.Errorf("expected requests to be batched, got %d calls %v", calls, batches)
+	}
+	if reqs := atomic.LoadInt32(&m.reqs); reqs > 25 {
+		t.Errorf("expected requests to be merged, got %d", reqs)
+	}
+}
+
+func TestAuthBusy(t *testing.T) {
+	m := &fakeManager{release: make(chan struct{})}
+	conf := NewConfig()
+	conf.Workers = 1
+	conf.ChanLen = 1
+	conf.BatchSize = 1
+	conf.Timeout = 20 * time.Millisecond
+	a := New(conf, m.fetch)
+	defer close(m.release)
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			_, _, err := a.Auth(Request{AgentID: string(rune('a' + i))})
+			errs <- err
+		}(i)
+	}
+	var busy, timeout int
+	for i := 0; i < 3; i++ {
+		switch <-errs {
+		case ErrBusy:
+			busy++
+		case ErrTimeout:
+			timeout++
+		}
+	}
+	if busy != 1 || timeout != 2 {
+		t.Errorf("expected 1 busy and 2 timeout, got %d %d", busy, timeout)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	m := &fakeManager{}
+	conf := NewConfig()
+	conf.BatchSize = 1
+	a := New(conf, m.fetch)
+	good := Request{TenantAuthCode: "good", AgentID: "a1"}
+	bad := Request{TenantAuthCode: "bad", AgentID: "a2"}
+	_, _, _ = a.Auth(good)
+	_, _, _ = a.Auth(bad)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := a.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted agent_center uses the saved result when the manager is down
+	down := &fakeManager{}
+	down.down.Store(true)
+	b := New(NewConfig(), down.fetch)
+	if n, err := b.Load(path); err != nil || n != 1 {
+		t.Fatalf("Load() = %d, %v, want 1 result", n, err)
+	}
+	if r, outcome, err := b.Auth(good); err != nil || outcome != OutcomeCacheHit || r.HostID != 2 {
+		t.Fatalf("unexpected result %v %s %v", r, outcome, err)
+	}
+	if _, outcome, err := b.Auth(bad); err == nil || outcome != OutcomeFailClosed {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+
+	// the stale ttl of the new config applies to the loaded results
+	conf = NewConfig()
+	conf.CacheTTL = time.Millisecond
+	conf.StaleTTL = time.Millisecond
+	c := New(conf, down.fetch)
+	if _, err := c.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, outcome, err := c.Auth(good); err == nil || outcome != OutcomeFailClosed {
+		t.Fatalf("unexpected outcome %s %v", outcome, err)
+	}
+}
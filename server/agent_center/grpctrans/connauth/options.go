@@ -0,0 +1,50 @@
+package connauth
+
+import "time"
+
+const (
+	defaultCacheTTL    = 10 * time.Minute
+	defaultNegativeTTL = time.Minute
+	defaultStaleTTL    = 24 * time.Hour
+	defaultBatchSize   = 32
+	defaultBatchWait   = 50 * time.Millisecond
+	defaultWorkers     = 8
+	defaultChanLength  = 1024
+	defaultTimeout     = 10 * time.Second
+)
+
+type Config struct {
+	//CacheTTL is how long the accepted result is used without asking the manager.
+	CacheTTL time.Duration
+	//NegativeTTL is how long the rejected result is used without asking the manager.
+	NegativeTTL time.Duration
+	//StaleTTL is how long the accepted result is kept, which is used when the manager is unreachable.
+	StaleTTL time.Duration
+	//BatchSize is the max number of requests merged into one request, 1 means no batch.
+	BatchSize int
+	//BatchWait is the max time to wait for merging requests.
+	BatchWait time.Duration
+	//Workers is the max number of concurrent requests to the manager.
+	Workers int
+	ChanLen int
+	//Timeout is the max time to wait for the result, including the time in queue.
+	Timeout time.Duration
+	//FailOpen accept the connection when the manager is unreachable and there is no stale result.
+	FailOpen bool
+	//Observer is called after each request to the manager, size is the number of merged requests.
+	Observer func(size int, cost time.Duration, err error)
+}
+
+func NewConfig() *Config {
+	c := &Config{
+		CacheTTL:    defaultCacheTTL,
+		NegativeTTL: defaultNegativeTTL,
+		StaleTTL:    defaultStaleTTL,
+		BatchSize:   defaultBatchSize,
+		BatchWait:   defaultBatchWait,
+		Workers:     defaultWorkers,
+		ChanLen:     defaultChanLength,
+		Timeout:     defaultTimeout,
+	}
+	return c
+}
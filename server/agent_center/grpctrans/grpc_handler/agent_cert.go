@@ -0,0 +1,295 @@
+package grpc_handler
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/agent_center/common"
+	"github.com/bytedance/Elkeid/server/agent_center/common/ylog"
+	"github.com/bytedance/Elkeid/server/agent_center/grpctrans/pool"
+	pb "github.com/bytedance/Elkeid/server/agent_center/grpctrans/proto"
+	"github.com/bytedance/Elkeid/server/agent_center/httptrans/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// the agent sends the csr with this data type, and receives the signed certificate with the same data type.
+	agentCertDataType = 1070
+	// the OU of the per-agent certificate signed by the manager, the CN is the AgentID.
+	agentCertOU = "elkeid-agent"
+
+	crlRefreshInterval = time.Minute
+	// the retry interval before the first crl is loaded, the client certificates are denied meanwhile.
+	crlRetryInterval = 5 * time.Second
+	// the connection using the shared certificate in strict mode must enroll within this time.
+	enrollOnlyTimeout = time.Minute
+)
+
+var (
+	errNoPeerCert       = errors.New("no client certificate")
+	errAgentIDMismatch  = errors.New("the AgentID does not match the client certificate")
+	errCertRevoked      = errors.New("the client certificate has been revoked")
+	errSharedCertDenied = errors.New("the shared certificate can only be used to enroll")
+	errAgentEnrolled    = errors.New("the AgentID holds a per-agent certificate, the shared certificate is denied")
+	errCrlNotLoaded     = errors.New("the crl has not been loaded")
+
+	//revokedSerials is the hex serial set of the revoked per-agent certificates, map[string]bool.
+	revokedSerials atomic.Value
+	//enrolledAgents is the set of the AgentIDs which hold an active per-agent certificate, map[string]bool.
+	enrolledAgents atomic.Value
+	//crlLoaded is 1 once the crl and the enrolled AgentIDs are loaded.
+	crlLoaded int32
+)
+
+// InitAgentCert load the crl and the enrolled AgentIDs before the server starts,
+// if it fails, the client certificates are denied until they are loaded by refreshCrlLoop.
+func InitAgentCert() {
+	revokedSerials.Store(map[string]bool{})
+	enrolledAgents.Store(map[string]bool{})
+	if err := refreshCrl(); err != nil {
+		ylog.Errorf("InitAgentCert", "load crl error %s, client certificates are denied until it is loaded", err.Error())
+	}
+	go refreshCrlLoop(crlRefreshInterval)
+}
+
+// getPeerCert get the verified client certificate of the connection.
+func getPeerCert(p *peer.Peer) *x509.Certificate {
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+func isAgentCert(cert *x509.Certificate) bool {
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == agentCertOU {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAgentCert check whether the certificate can be used by the agentID.
+// returns the serial of the per-agent certificate, or empty if it is the shared certificate.
+// the shared certificate is denied for the agentID which holds an active per-agent certificate in both modes.
+func checkAgentCert(cert *x509.Certificate, agentID string) (string, error) {
+	if cert == nil {
+		return "", errNoPeerCert
+	}
+	//fail closed, the revoked certificates and the enrolled AgentIDs are unknown yet
+	if atomic.LoadInt32(&crlLoaded) == 0 {
+		certRejectCounter.With(prometheus.Labels{"reason": "crl"}).Inc()
+		return "", errCrlNotLoaded
+	}
+	if !isAgentCert(cert) {
+		if isEnrolled(agentID) {
+			certRejectCounter.With(prometheus.Labels{"reason": "enrolled"}).Inc()
+			return "", errAgentEnrolled
+		}
+		return "", nil
+	}
+	if cert.Subject.CommonName != agentID {
+		certRejectCounter.With(prometheus.Labels{"reason": "mismatch"}).Inc()
+		return "", errAgentIDMismatch
+	}
+	serial := cert.SerialNumber.Text(16)
+	if isRevoked(serial) {
+		certRejectCounter.With(prometheus.Labels{"reason": "revoked"}).Inc()
+		return "", errCertRevoked
+	}
+	return serial, nil
+}
+
+func isRevoked(serial string) bool {
+	serials, ok := revokedSerials.Load().(map[string]bool)
+	return ok && serials[serial]
+}
+
+func isEnrolled(agentID string) bool {
+	agents, ok := enrolledAgents.Load().(map[string]bool)
+	return ok && agents[agentID]
+}
+
+// enrollAgentCert forward the csr to the manager, and return the command with the signed certificate.
+func enrollAgentCert(record *pb.Record, agentID, product, peerSerial string) (*pb.Command, error) {
+	item, err := parseRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := client.EnrollAgentCert(agentID, item["csr"], peerSerial)
+	if err != nil {
+		return nil, err
+	}
+	ylog.Infof("enrollAgentCert", "agent %s enrolled, peer serial %s", agentID, peerSerial)
+	return &pb.Command{
+		Task: &pb.PluginTask{
+			DataType: agentCertDataType,
+			Name:     product,
+			Data:     cert,
+		},
+	}, nil
+}
+
+// handleCertRequest handle the csr of the agent which has been admitted.
+func handleCertRequest(record *pb.Record, req *pb.RawData, conn *pool.Connection) {
+	cmd, err := enrollAgentCert(record, conn.AgentID, req.Product, conn.CertSerial)
+	if err != nil {
+		ylog.Errorf("handleCertRequest", "agent %s enroll error %s", conn.AgentID, err.Error())
+		return
+	}
+	err = GlobalGRPCPool.PostCommand(conn.AgentID, cmd)
+	if err != nil {
+		ylog.Errorf("handleCertRequest", "agent %s post cert error %s", conn.AgentID, err.Error())
+	}
+}
+
+// handleEnrollOnly in strict mode, the connection using the shared certificate is not admitted,
+// it can only send the csr and receive the per-agent certificate, then the connection is closed.
+// it is called after the connection passes connAuth, so an unknown agent can't enroll.
+func handleEnrollOnly(stream pb.Transfer_TransferServer, data *pb.RawData, agentID string) error {
+	dataChan := make(chan *pb.RawData)
+	go func() {
+		defer close(dataChan)
+		for {
+			d, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case dataChan <- d:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(enrollOnlyTimeout)
+	for {
+		//only the AgentID which passes connAuth can be enrolled
+		if data.AgentID != agentID {
+			certRejectCounter.With(prometheus.Labels{"reason": "mismatch"}).Inc()
+			return errAgentIDMismatch
+		}
+		for _, record := range data.GetData() {
+			if record.DataType != agentCertDataType {
+				continue
+			}
+			cmd, err := enrollAgentCert(record, agentID, data.Product, "")
+			if err != nil {
+				ylog.Errorf("handleEnrollOnly", "agent %s enroll error %s", agentID, err.Error())
+				return err
+			}
+			return stream.Send(cmd)
+		}
+
+		var ok bool
+		select {
+		case data, ok = <-dataChan:
+			if !ok {
+				return nil
+			}
+		case <-timeout:
+			certRejectCounter.With(prometheus.Labels{"reason": "shared"}).Inc()
+			ylog.Errorf("handleEnrollOnly", "agent %s did not enroll in %s", agentID, enrollOnlyTimeout.String())
+			return errSharedCertDenied
+		}
+	}
+}
+
+func refreshCrlLoop(interval time.Duration) {
+	for {
+		wait := interval
+		if atomic.LoadInt32(&crlLoaded) == 0 {
+			wait = crlRetryInterval
+		}
+		time.Sleep(wait + time.Duration(rand.Intn(10))*time.Second)
+		//keep the last crl if failed, and retry on the next tick
+		if err := refreshCrl(); err != nil {
+			ylog.Errorf("refreshCrl", "load crl error %s", err.Error())
+			continue
+		}
+		closeRevokedConn()
+	}
+}
+
+func refreshCrl() error {
+	caCert, err := loadCaCert()
+	if err != nil {
+		return err
+	}
+	if err = loadCrl(caCert); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&crlLoaded, 1)
+	return nil
+}
+
+func loadCaCert() (*x509.Certificate, error) {
+	caBytes, err := ioutil.ReadFile(common.SSLCaFile)
+	if err != nil {
+		return nil, fmt.Errorf("READ_CAFILE_ERROR:%s caFile:%s", err.Error(), common.SSLCaFile)
+	}
+	block, _ := pem.Decode(caBytes)
+	if block == nil {
+		return nil, fmt.Errorf("invalid caFile:%s", common.SSLCaFile)
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("PARSE_CAFILE_ERROR:%s caFile:%s", err.Error(), common.SSLCaFile)
+	}
+	return caCert, nil
+}
+
+func loadCrl(caCert *x509.Certificate) error {
+	crlBytes, enrolled, err := client.GetCrlFromRemote()
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(crlBytes)
+	if block == nil {
+		return errors.New("invalid crl")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if err = crl.CheckSignatureFrom(caCert); err != nil {
+		return err
+	}
+
+	serials := make(map[string]bool, len(crl.RevokedCertificates))
+	for _, v := range crl.RevokedCertificates {
+		serials[v.SerialNumber.Text(16)] = true
+	}
+	revokedSerials.Store(serials)
+
+	agents := make(map[string]bool, len(enrolled))
+	for _, v := range enrolled {
+		agents[v] = true
+	}
+	enrolledAgents.Store(agents)
+	return nil
+}
+
+// closeRevokedConn close the connections which use the revoked certificate.
+func closeRevokedConn() {
+	for _, conn := range GlobalGRPCPool.GetList() {
+		if conn.CertSerial == "" || !isRevoked(conn.CertSerial) {
+			continue
+		}
+		certRejectCounter.With(prometheus.Labels{"reason": "revoked"}).Inc()
+		ylog.Infof("closeRevokedConn", "the certificate %s of %s has been revoked, now close the connection", conn.CertSerial, conn.AgentID)
+		if err := GlobalGRPCPool.Close(conn.AgentID); err != nil {
+			ylog.Errorf("closeRevokedConn", "close %s error %s", conn.AgentID, err.Error())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package grpc_handler
+
+import (
+	"os"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/agent_center/common"
+	"github.com/bytedance/Elkeid/server/agent_center/common/ylog"
+	"github.com/bytedance/Elkeid/server/agent_center/grpctrans/connauth"
+	"github.com/bytedance/Elkeid/server/agent_center/httptrans/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const connAuthSaveInterval = time.Minute
+
+var connAuthenticator *connauth.Authenticator
+
+func InitConnAuth() {
+	option := connauth.NewConfig()
+	if common.ConnAuthCacheTTL > 0 {
+		option.CacheTTL = time.Duration(common.ConnAuthCacheTTL) * time.Second
+	}
+	if common.ConnAuthNegativeTTL > 0 {
+		option.NegativeTTL = time.Duration(common.ConnAuthNegativeTTL) * time.Second
+	}
+	if common.ConnAuthStaleTTL > 0 {
+		option.StaleTTL = time.Duration(common.ConnAuthStaleTTL) * time.Second
+	}
+	if common.ConnAuthBatchSize > 0 {
+		option.BatchSize = common.ConnAuthBatchSize
+	}
+	if common.ConnAuthBatchWait > 0 {
+		option.BatchWait = time.Duration(common.ConnAuthBatchWait) * time.Millisecond
+	}
+	if common.ConnAuthConcurrency > 0 {
+		option.Workers = common.ConnAuthConcurrency
+	}
+	if common.ConnLimit > option.ChanLen {
+		option.ChanLen = common.ConnLimit
+	}
+	option.FailOpen = common.ConnAuthFailOpen
+	option.Observer = func(size int, cost time.Duration, err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		connAuthRequestTime.With(prometheus.Labels{"result": result}).Observe(cost.Seconds())
+		connAuthBatchSize.Observe(float64(size))
+	}
+	connAuthenticator = connauth.New(option, client.ConnAuth)
+	if common.ConnAuthCacheFile != "" {
+		//warm the cache with the results saved before the restart, so they can be used as the stale results
+		n, err := connAuthenticator.Load(common.ConnAuthCacheFile)
+		if err != nil && !os.IsNotExist(err) {
+			ylog.Errorf("InitConnAuth", "load %s error %s", common.ConnAuthCacheFile, err.Error())
+		}
+		ylog.Infof("InitConnAuth", "load %d auth results from %s", n, common.ConnAuthCacheFile)
+		go saveConnAuth()
+	}
+}
+
+func saveConnAuth() {
+	ticker := time.NewTicker(connAuthSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := connAuthenticator.Save(common.ConnAuthCacheFile); err != nil {
+			ylog.Errorf("saveConnAuth", "save %s error %s", common.ConnAuthCacheFile, err.Error())
+		}
+	}
+}
+
+// connAuth ask the manager server whether the connection is allowed, the error is not nil if rejected.
+func connAuth(req connauth.Request) (connauth.Result, string, error) {
+	start := time.Now()
+	res, outcome, err := connAuthenticator.Auth(req)
+	connAuthCounter.With(prometheus.Labels{"outcome": outcome}).Inc()
+	connAuthLatency.With(prometheus.Labels{"outcome": outcome}).Observe(time.Since(start).Seconds())
+	return res, outcome, err
+}
@@ -9,6 +9,11 @@ var (
 	sendCounter           = initPrometheusGrpcSendCounter()
 	outputDataTypeCounter = initPrometheusOutputDataTypeCounter()
 	outputAgentIDCounter  = initPrometheusOutputAgentIDCounter()
+	certRejectCounter     = initPrometheusCertRejectCounter()
+	connAuthCounter       = initPrometheusConnAuthCounter()
+	connAuthLatency       = initPrometheusConnAuthLatency()
+	connAuthRequestTime   = initPrometheusConnAuthRequestTime()
+	connAuthBatchSize     = initPrometheusConnAuthBatchSize()
 )
 
 var agentGauge = map[string]*prometheus.GaugeVec{
@@ -72,6 +77,59 @@ func initPrometheusOutputAgentIDCounter() *prometheus.CounterVec {
 	return vec
 }
 
+func initPrometheusCertRejectCounter() *prometheus.CounterVec {
+	prometheusOpts := prometheus.CounterOpts{
+		Name: "elkeid_ac_agent_cert_reject_count",
+		Help: "Elkeid AC rejected connection count for client certificate",
+	}
+	vec := prometheus.NewCounterVec(prometheusOpts, []string{"reason"})
+	prometheus.MustRegister(vec)
+	return vec
+}
+
+func initPrometheusConnAuthCounter() *prometheus.CounterVec {
+	prometheusOpts := prometheus.CounterOpts{
+		Name: "elkeid_ac_conn_auth_count",
+		Help: "Elkeid AC connection auth count for outcome",
+	}
+	vec := prometheus.NewCounterVec(prometheusOpts, []string{"outcome"})
+	prometheus.MustRegister(vec)
+	return vec
+}
+
+func initPrometheusConnAuthLatency() *prometheus.HistogramVec {
+	prometheusOpts := prometheus.HistogramOpts{
+		Name:    "elkeid_ac_conn_auth_latency_seconds",
+		Help:    "Elkeid AC connection auth latency for outcome",
+		Buckets: []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}
+	vec := prometheus.NewHistogramVec(prometheusOpts, []string{"outcome"})
+	prometheus.MustRegister(vec)
+	return vec
+}
+
+func initPrometheusConnAuthRequestTime() *prometheus.HistogramVec {
+	prometheusOpts := prometheus.HistogramOpts{
+		Name:    "elkeid_ac_conn_auth_request_seconds",
+		Help:    "Elkeid AC connection auth request time of the manager server",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}
+	vec := prometheus.NewHistogramVec(prometheusOpts, []string{"result"})
+	prometheus.MustRegister(vec)
+	return vec
+}
+
+func initPrometheusConnAuthBatchSize() prometheus.Histogram {
+	prometheusOpts := prometheus.HistogramOpts{
+		Name:    "elkeid_ac_conn_auth_batch_size",
+		Help:    "Elkeid AC connection auth request count in one batch",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500},
+	}
+	histogram := prometheus.NewHistogram(prometheusOpts)
+	prometheus.MustRegister(histogram)
+	return histogram
+}
+
 func initPrometheusAgentCpuGauge() *prometheus.GaugeVec {
 	prometheusOpts := prometheus.GaugeOpts{
 		Name: "elkeid_ac_agent_cpu",
@@ -87,6 +87,9 @@ func handleRawData(req *pb.RawData, conn *pool.Connection) (agentID string) {
 			if err != nil {
 				ylog.Errorf("handleRawData", "PushTask2Manager error %s", err.Error())
 			}
+		case agentCertDataType:
+			//csr of the per-agent certificate, enroll asynchronously.
+			go handleCertRequest(req.GetData()[k], req, conn)
 		case 1010, 1011:
 			//agent or plugin error log
 			item, err := parseRecord(req.GetData()[k])
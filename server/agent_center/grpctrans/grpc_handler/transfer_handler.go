@@ -4,15 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bytedance/Elkeid/server/agent_center/common"
 	"github.com/bytedance/Elkeid/server/agent_center/common/ylog"
+	"github.com/bytedance/Elkeid/server/agent_center/grpctrans/connauth"
 	"github.com/bytedance/Elkeid/server/agent_center/grpctrans/pool"
 	pb "github.com/bytedance/Elkeid/server/agent_center/grpctrans/proto"
-	"github.com/levigross/grequests"
 	"google.golang.org/grpc/peer"
 )
 
@@ -48,15 +47,6 @@ func (h *TransferHandler) Transfer(stream pb.Transfer_TransferServer) error {
 	}
 	agentID := data.AgentID
 	tenantAuthCode := data.TenantAuthCode
-	// TODO: /agent/host/connAuth
-	/*
-		{
-		  "tenantAuthCode" : "11ea5588-1813-41c5-b2b4-398c14233f66",
-		  "agentId" : "11ea5588-1813-41c5-b2b4-398c14233f66",
-		  "agentVersion" : "1.1.3",
-		  "extIp": "210.158.1.69"
-		}
-	*/
 
 	//Get the client address
 	var tenantID int64
@@ -68,42 +58,32 @@ func (h *TransferHandler) Transfer(stream pb.Transfer_TransferServer) error {
 	}
 	addr := p.Addr.String()
 	ylog.Infof("Transfer", ">>>>connection addr: %s", addr)
-	authData := make(map[string]string, 1)
-	authData["tenantAuthCode"] = tenantAuthCode
-	authData["agentId"] = agentID
-	authData["agentVersion"] = data.Version
+
+	//The per-agent certificate must match the AgentID
+	certSerial, err := checkAgentCert(getPeerCert(p), agentID)
+	if err != nil {
+		ylog.Errorf("Transfer", ">>>>cert check fail %s %s %s", agentID, addr, err.Error())
+		return err
+	}
 	extIP := strings.Split(addr, ":")
-	authData["extIp"] = extIP[0]
-	resp, err := grequests.Post(common.ManagerServer+"/agent/report/connAuth", &grequests.RequestOptions{
-		JSON:           authData,
-		RequestTimeout: 5 * time.Second,
+	res, outcome, err := connAuth(connauth.Request{
+		TenantAuthCode: tenantAuthCode,
+		AgentID:        agentID,
+		AgentVersion:   data.Version,
+		ExtIP:          extIP[0],
 	})
-	if err == nil {
-		ylog.Infof("Auth Resp:", string(resp.Bytes()))
-		respAuthData := &struct {
-			Status int    `json:"status"`
-			Msg    string `json:"msg"`
-			Data   struct {
-				TenantID int64 `json:"tenantId"`
-				HostID   int64 `json:"hostId"`
-			} `json:"data"`
-		}{}
-		if err = json.Unmarshal(resp.Bytes(), respAuthData); err == nil {
-			if respAuthData.Status == 200 {
-				tenantID = respAuthData.Data.TenantID
-				hostID = respAuthData.Data.HostID
-				ylog.Infof("Transfer", ">>>>auth succ %s %s %s %s", agentID, tenantAuthCode, strconv.Itoa(int(tenantID)), strconv.Itoa(int(hostID)))
-			} else {
-				ylog.Errorf("Transfer", ">>>>auth fail %s %s", agentID, tenantAuthCode)
-				return err
-			}
-		} else {
-			ylog.Errorf("Umarshal fail %s", err.Error())
-		}
-	} else {
-		ylog.Errorf("Transfer", ">>>>auth fail %s", err.Error())
+	if err != nil {
+		ylog.Errorf("Transfer", ">>>>auth fail %s %s %s %s", agentID, tenantAuthCode, outcome, err.Error())
 		return err
 	}
+	tenantID = res.TenantID
+	hostID = res.HostID
+	ylog.Infof("Transfer", ">>>>auth succ %s %s %d %d %s", agentID, tenantAuthCode, tenantID, hostID, outcome)
+
+	//In strict mode, the shared certificate can only be used to enroll after the connection passes auth
+	if certSerial == "" && common.AgentCertMode == common.AgentCertModeStrict {
+		return handleEnrollOnly(stream, data, agentID)
+	}
 
 	//add connection info to the GlobalGRPCPool
 	ctx, cancelButton := context.WithCancel(context.Background())
@@ -115,6 +95,7 @@ func (h *TransferHandler) Transfer(stream pb.Transfer_TransferServer) error {
 		HostID:         hostID,
 		SourceAddr:     addr,
 		CreateAt:       createAt,
+		CertSerial:     certSerial,
 		CommandChan:    make(chan *pool.Command),
 		Ctx:            ctx,
 		CancelFuc:      cancelButton,
@@ -161,6 +142,11 @@ func recvData(stream pb.Transfer_TransferServer, conn *pool.Connection) {
 				ylog.Errorf("recvData", "Transfer Recv Error %s, now close the recv direction of the tcp, %s ", err.Error(), conn.AgentID)
 				return
 			}
+			//The AgentID can not be changed in the connection
+			if data.AgentID != conn.AgentID {
+				ylog.Errorf("recvData", "AgentID changed from %s to %s, now close the recv direction of the tcp", conn.AgentID, data.AgentID)
+				return
+			}
 			recvCounter.Inc()
 			handleRawData(data, conn)
 		}
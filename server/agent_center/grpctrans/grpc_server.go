@@ -43,6 +43,8 @@ const (
 
 func Run() {
 	grpc_handler.InitGlobalGRPCPool()
+	grpc_handler.InitAgentCert()
+	grpc_handler.InitConnAuth()
 	runServer(true, common.GRPCPort, common.SSLCertFile, common.SSLKeyFile, common.SSLCaFile)
 }
 
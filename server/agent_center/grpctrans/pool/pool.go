@@ -50,6 +50,7 @@ type Connection struct {
 	HostID         int64  `json:"host_id"`
 	SourceAddr     string `json:"addr"`
 	CreateAt       int64  `json:"create_at"`
+	CertSerial     string `json:"cert_serial"` //serial of the per-agent certificate, empty if the shared certificate is used
 
 	agentDetailLock   sync.RWMutex
 	agentDetail       map[string]interface{} `json:"agent_detail"`
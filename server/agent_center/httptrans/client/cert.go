@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/agent_center/common"
+	"github.com/bytedance/Elkeid/server/agent_center/common/ylog"
+	"github.com/levigross/grequests"
+)
+
+const (
+	EnrollCertUrl = `http://%s/api/v0/inner/agent/cert/enroll`
+	CrlUrl        = `http://%s/api/v0/inner/agent/cert/crl`
+)
+
+// ResInner data is the error message if code is not 0
+type ResInner struct {
+	Code    int             `json:"code"`
+	Message string          `json:"msg"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// the inner api of manager need aksk auth.
+func innerAuthOption() *grequests.RequestOptions {
+	return &grequests.RequestOptions{
+		RequestTimeout: 10 * time.Second,
+		BeforeRequest: func(req *http.Request) error {
+			return common.SignRequest(req, common.ManageAK, common.ManageSK)
+		},
+	}
+}
+
+// EnrollAgentCert ask the manager to sign the csr of the agent, peerSerial is the serial of the certificate used by the current connection.
+func EnrollAgentCert(agentID, csr, peerSerial string) (string, error) {
+	option := innerAuthOption()
+	option.JSON = map[string]string{"agent_id": agentID, "csr": csr, "peer_serial": peerSerial}
+	resp, err := grequests.Post(fmt.Sprintf(EnrollCertUrl, common.GetRandomManageAddr()), option)
+	if err != nil {
+		ylog.Errorf("EnrollAgentCert", "error %s %s", agentID, err.Error())
+		return "", err
+	}
+	if !resp.Ok {
+		ylog.Errorf("EnrollAgentCert", "response code is not 200, AgentID: %s, StatusCode: %d, String: %s", agentID, resp.StatusCode, resp.String())
+		return "", errors.New("status code is not ok")
+	}
+	var response ResInner
+	err = json.Unmarshal(resp.Bytes(), &response)
+	if err != nil {
+		ylog.Errorf("EnrollAgentCert", "agentID: %s, error: %s, resp: %s", agentID, err.Error(), resp.String())
+		return "", err
+	}
+	if response.Code != 0 {
+		ylog.Errorf("EnrollAgentCert", "response code is not 0, agentID: %s, resp: %s", agentID, resp.String())
+		return "", fmt.Errorf("enroll failed: %s", string(response.Data))
+	}
+	var data struct {
+		Cert string `json:"cert"`
+	}
+	err = json.Unmarshal(response.Data, &data)
+	if err != nil {
+		ylog.Errorf("EnrollAgentCert", "agentID: %s, error: %s, resp: %s", agentID, err.Error(), resp.String())
+		return "", err
+	}
+	return data.Cert, nil
+}
+
+// GetCrlFromRemote get the pem encoded crl of the agent certificates, and the AgentIDs which hold an active certificate.
+func GetCrlFromRemote() ([]byte, []string, error) {
+	resp, err := grequests.Get(fmt.Sprintf(CrlUrl, common.GetRandomManageAddr()), innerAuthOption())
+	if err != nil {
+		ylog.Errorf("GetCrlFromRemote", "error %s", err.Error())
+		return nil, nil, err
+	}
+	if !resp.Ok {
+		ylog.Errorf("GetCrlFromRemote", "response code is not 200, StatusCode: %d, String: %s", resp.StatusCode, resp.String())
+		return nil, nil, errors.New("status code is not ok")
+	}
+	var response ResInner
+	err = json.Unmarshal(resp.Bytes(), &response)
+	if err != nil {
+		ylog.Errorf("GetCrlFromRemote", "error: %s, resp: %s", err.Error(), resp.String())
+		return nil, nil, err
+	}
+	if response.Code != 0 {
+		ylog.Errorf("GetCrlFromRemote", "response code is not 0, resp: %s", resp.String())
+		return nil, nil, errors.New("response code is not 0")
+	}
+	var data struct {
+		Crl      string   `json:"crl"`
+		Enrolled []string `json:"enrolled"`
+	}
+	err = json.Unmarshal(response.Data, &data)
+	if err != nil {
+		ylog.Errorf("GetCrlFromRemote", "error: %s, resp: %s", err.Error(), resp.String())
+		return nil, nil, err
+	}
+	return []byte(data.Crl), data.Enrolled, nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/agent_center/common"
+	"github.com/bytedance/Elkeid/server/agent_center/common/ylog"
+	"github.com/bytedance/Elkeid/server/agent_center/grpctrans/connauth"
+	"github.com/levigross/grequests"
+)
+
+const (
+	ConnAuthUrl      = `/agent/report/connAuth`
+	ConnAuthBatchUrl = `/agent/report/connAuth/batch`
+	// the inner api of the manager is used if manager_server.addr is empty
+	InnerConnAuthBase = `http://%s/api/v0/inner`
+)
+
+type ResConnAuth struct {
+	Status int    `json:"status"`
+	Msg    string `json:"msg"`
+	Data   struct {
+		TenantID int64 `json:"tenantId"`
+		HostID   int64 `json:"hostId"`
+	} `json:"data"`
+}
+
+type ResConnAuthBatch struct {
+	Status int               `json:"status"`
+	Msg    string            `json:"msg"`
+	Data   []connauth.Result `json:"data"`
+}
+
+// connAuthBase the manager_server.addr, or the inner api of a random manager.
+func connAuthBase() string {
+	if common.ManagerServer != "" {
+		return common.ManagerServer
+	}
+	return fmt.Sprintf(InnerConnAuthBase, common.GetRandomManageAddr())
+}
+
+// ConnAuth ask the manager server whether the connections are allowed, the batch api is used if there are more than one request.
+func ConnAuth(reqs []connauth.Request) ([]connauth.Result, error) {
+	if len(reqs) == 1 {
+		return connAuthSingle(reqs[0])
+	}
+	option := innerAuthOption()
+	option.JSON = reqs
+	option.RequestTimeout = 5 * time.Second
+	resp, err := grequests.Post(connAuthBase()+ConnAuthBatchUrl, option)
+	if err != nil {
+		ylog.Errorf("ConnAuth", "batch error %s", err.Error())
+		return nil, err
+	}
+	if !resp.Ok {
+		ylog.Errorf("ConnAuth", "batch response code is not 200 but %d", resp.StatusCode)
+		return nil, fmt.Errorf("status code is %d", resp.StatusCode)
+	}
+	var response ResConnAuthBatch
+	err = json.Unmarshal(resp.Bytes(), &response)
+	if err != nil {
+		ylog.Errorf("ConnAuth", "batch error %s, resp: %s", err.Error(), resp.String())
+		return nil, err
+	}
+	if response.Status != 200 {
+		ylog.Errorf("ConnAuth", "batch status is not 200, resp: %s", resp.String())
+		return nil, fmt.Errorf("batch status is %d", response.Status)
+	}
+	return response.Data, nil
+}
+
+func connAuthSingle(req connauth.Request) ([]connauth.Result, error) {
+	option := innerAuthOption()
+	option.JSON = req
+	option.RequestTimeout = 5 * time.Second
+	resp, err := grequests.Post(connAuthBase()+ConnAuthUrl, option)
+	if err != nil {
+		ylog.Errorf("ConnAuth", "error %s %s", req.AgentID, err.Error())
+		return nil, err
+	}
+	if !resp.Ok {
+		ylog.Errorf("ConnAuth", "response code is not 200 but %d, AgentID: %s", resp.StatusCode, req.AgentID)
+		return nil, fmt.Errorf("status code is %d", resp.StatusCode)
+	}
+	ylog.Infof("ConnAuth", "Auth Resp: %s", resp.String())
+	var response ResConnAuth
+	err = json.Unmarshal(resp.Bytes(), &response)
+	if err != nil {
+		ylog.Errorf("ConnAuth", "AgentID: %s, error: %s, resp: %s", req.AgentID, err.Error(), resp.String())
+		return nil, err
+	}
+	return []connauth.Result{{
+		Status:   response.Status,
+		Msg:      response.Msg,
+		TenantID: response.Data.TenantID,
+		HostID:   response.Data.HostID,
+	}}, nil
+}
@@ -2,9 +2,6 @@ package midware
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"github.com/bytedance/Elkeid/server/agent_center/common"
 	"github.com/bytedance/Elkeid/server/agent_center/httptrans/http_handler"
@@ -13,7 +10,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -30,56 +26,8 @@ func getSecKec(ak string) string {
 	return sk
 }
 
-func sha256byteArr(in []byte) string {
-	if in == nil || len(in) == 0 {
-		return ""
-	}
-	h := sha256.New()
-	h.Write(in)
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-func generateSign(method, url, query, ak, timestamp, sk string, requestBody []byte) string {
-	return hmacSha256(fmt.Sprintf(`%s\n%s\n%s\n%s\n%s\n%s`, method, url, query, ak, timestamp, sha256byteArr(requestBody)), sk)
-}
-
-func hmacSha256(data string, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(data))
-	return hex.EncodeToString(h.Sum(nil))
-}
-
 func beforeRequestFunc(req *http.Request) error {
-	var (
-		timestamp   = fmt.Sprintf(`%d`, time.Now().Unix())
-		err         error
-		requestBody []byte
-	)
-
-	if req.Body != nil {
-		requestBody, err = ioutil.ReadAll(req.Body)
-		if err != nil {
-			return err
-		}
-		//Reset after reading
-		req.Body.Close()
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(requestBody))
-	} else {
-		requestBody = []byte{}
-	}
-	sign := generateSign(req.Method, formatURLPath(req.URL.Path), req.URL.RawQuery, ak, timestamp, sk, requestBody)
-	req.Header.Add("AccessKey", ak)
-	req.Header.Add("Signature", sign)
-	req.Header.Add("TimeStamp", timestamp)
-	return nil
-}
-
-func formatURLPath(in string) string {
-	in = strings.TrimSpace(in)
-	if strings.HasSuffix(in, "/") {
-		return in[:len(in)-1]
-	}
-	return in
+	return common.SignRequest(req, ak, sk)
 }
 
 func AuthRequestOption() *grequests.RequestOptions {
@@ -133,7 +81,7 @@ func AKSKAuth() gin.HandlerFunc {
 		c.Request.Body.Close()
 		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(requestBody))
 
-		serverSign = generateSign(c.Request.Method, formatURLPath(c.Request.URL.Path), c.Request.URL.RawQuery, ak, timeStamp, sk, requestBody)
+		serverSign = common.GenerateSign(c.Request.Method, common.FormatURLPath(c.Request.URL.Path), c.Request.URL.RawQuery, ak, timeStamp, sk, requestBody)
 		if serverSign != sign {
 			abort(c, "signature error")
 			return
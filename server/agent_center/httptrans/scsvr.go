@@ -18,7 +18,10 @@ import (
 
 func Run() {
 	go runAPIServer(common.HttpPort, common.HttpSSLEnable, common.HttpAuthEnable, common.SSLCertFile, common.SSLKeyFile)
-	go http_handler.ReportAgentInfo()
+	//the basic info is only reported to an external manager server
+	if common.ManagerServer != "" {
+		go http_handler.ReportAgentInfo()
+	}
 	// runRawDataServer(common.RawDataPort, common.SSLCaFile, common.SSLRawDataCertFile, common.SSLRawDataKeyFile)
 }
 
@@ -0,0 +1,49 @@
+package v0
+
+import (
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/agent_cert"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// EnrollAgentCert agent_center转发的证书申请，peer_serial为agent当前连接的证书序列号
+func EnrollAgentCert(c *gin.Context) {
+	type Request struct {
+		AgentId    string `json:"agent_id" binding:"required"`
+		Csr        string `json:"csr" binding:"required"`
+		PeerSerial string `json:"peer_serial"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("EnrollAgentCert", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	cert, err := agent_cert.Enroll(request.AgentId, request.Csr, request.PeerSerial)
+	if err != nil {
+		ylog.Errorf("EnrollAgentCert", "agent %s: %s", request.AgentId, err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, bson.M{"cert": cert})
+}
+
+// GetAgentCrl 获取agent证书的吊销列表，及持有有效证书的agent id
+func GetAgentCrl(c *gin.Context) {
+	crl, err := agent_cert.GetCrl()
+	if err != nil {
+		ylog.Errorf("GetAgentCrl", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	enrolled, err := agent_cert.GetEnrolledAgents()
+	if err != nil {
+		ylog.Errorf("GetAgentCrl", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, bson.M{"crl": string(crl), "enrolled": enrolled})
+}
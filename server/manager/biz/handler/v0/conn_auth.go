@@ -0,0 +1,83 @@
+package v0
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/gin-gonic/gin"
+)
+
+// agent_center的连接鉴权接口，响应格式为{status, msg, data}，status为200时允许连接
+const (
+	connAuthSuccess    = 200
+	connAuthBadRequest = 400
+	connAuthReject     = 403
+
+	// 单次批量鉴权的最大请求数
+	connAuthBatchLimit = 1000
+)
+
+// agent id为uuid，兼容旧版本放宽为字母数字及-_
+var agentIdPattern = regexp.MustCompile(`^[0-9a-zA-Z_-]{1,64}$`)
+
+type ConnAuthRequest struct {
+	TenantAuthCode string `json:"tenantAuthCode"`
+	AgentId        string `json:"agentId"`
+	AgentVersion   string `json:"agentVersion"`
+	ExtIp          string `json:"extIp"`
+}
+
+type ConnAuthResult struct {
+	Status   int    `json:"status"`
+	Msg      string `json:"msg"`
+	TenantId int64  `json:"tenantId"`
+	HostId   int64  `json:"hostId"`
+}
+
+// connAuth 开源版本没有租户，证书已由agent_center校验，这里只拒绝非法的agent id
+func connAuth(req ConnAuthRequest) ConnAuthResult {
+	if !agentIdPattern.MatchString(req.AgentId) {
+		return ConnAuthResult{Status: connAuthReject, Msg: "invalid agent id"}
+	}
+	return ConnAuthResult{Status: connAuthSuccess, Msg: "success"}
+}
+
+// ConnAuth agent连接agent_center时的鉴权
+func ConnAuth(c *gin.Context) {
+	var request ConnAuthRequest
+	if err := c.BindJSON(&request); err != nil {
+		ylog.Errorf("ConnAuth", err.Error())
+		c.JSON(http.StatusOK, gin.H{"status": connAuthBadRequest, "msg": err.Error()})
+		return
+	}
+	res := connAuth(request)
+	if res.Status != connAuthSuccess {
+		ylog.Infof("ConnAuth", "reject %s %s: %s", request.AgentId, request.ExtIp, res.Msg)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": res.Status, "msg": res.Msg,
+		"data": gin.H{"tenantId": res.TenantId, "hostId": res.HostId}})
+}
+
+// ConnAuthBatch 批量鉴权，结果与请求的顺序一致
+func ConnAuthBatch(c *gin.Context) {
+	var request []ConnAuthRequest
+	if err := c.BindJSON(&request); err != nil {
+		ylog.Errorf("ConnAuthBatch", err.Error())
+		c.JSON(http.StatusOK, gin.H{"status": connAuthBadRequest, "msg": err.Error()})
+		return
+	}
+	if len(request) > connAuthBatchLimit {
+		c.JSON(http.StatusOK, gin.H{"status": connAuthBadRequest, "msg": "too many requests in a batch"})
+		return
+	}
+	results := make([]ConnAuthResult, 0, len(request))
+	for _, req := range request {
+		res := connAuth(req)
+		if res.Status != connAuthSuccess {
+			ylog.Infof("ConnAuthBatch", "reject %s %s: %s", req.AgentId, req.ExtIp, res.Msg)
+		}
+		results = append(results, res)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": connAuthSuccess, "msg": "success", "data": results})
+}
@@ -0,0 +1,91 @@
+package v6
+
+import (
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/agent_cert"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 获取签发给agent的证书列表
+func GetAgentCertList(c *gin.Context) {
+	type Request struct {
+		AgentId string `json:"agent_id"`
+		Status  string `json:"status"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("GetAgentCertList", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	var pageRequest common.PageRequest
+	err = c.BindQuery(&pageRequest)
+	if err != nil {
+		ylog.Errorf("GetAgentCertList", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+
+	searchFilter := bson.M{}
+	if request.AgentId != "" {
+		searchFilter["agent_id"] = request.AgentId
+	}
+	if request.Status != "" {
+		searchFilter["status"] = request.Status
+	}
+	dataResponse := make([]agent_cert.AgentCert, 0)
+	pageSearch := common.PageSearch{Page: pageRequest.Page, PageSize: pageRequest.PageSize,
+		Filter: searchFilter, Sorter: bson.M{"create_time": -1}}
+	certCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentCertCollection)
+	pageResponse, err := common.DBSearchPaginate(
+		certCol,
+		pageSearch,
+		func(cursor *mongo.Cursor) error {
+			var cert agent_cert.AgentCert
+			err := cursor.Decode(&cert)
+			if err != nil {
+				ylog.Errorf("GetAgentCertList", err.Error())
+				return err
+			}
+			dataResponse = append(dataResponse, cert)
+			return nil
+		},
+	)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	CreatePageResponse(c, common.SuccessCode, dataResponse, *pageResponse)
+}
+
+// 吊销agent的证书，serial为空时吊销全部有效证书，agent_center更新吊销列表后断开对应连接
+func RevokeAgentCert(c *gin.Context) {
+	type Request struct {
+		AgentId string `json:"agent_id" binding:"required"`
+		Serial  string `json:"serial"`
+		Reason  string `json:"reason"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("RevokeAgentCert", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	userName, ok := getUserName(c)
+	if !ok {
+		return
+	}
+	err = agent_cert.Revoke(request.AgentId, request.Serial, request.Reason, userName)
+	if err != nil {
+		ylog.Errorf("RevokeAgentCert", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, nil)
+}
@@ -3,6 +3,7 @@ package v6
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"time"
 
@@ -44,6 +45,8 @@ type BaselineCheckInfo struct {
 	UpdateTime    int64  `json:"update_time" bson:"update_time"`
 	PassRate      int    `json:"pass_rate" bson:"pass_rate"`
 	Status        string `json:"status" bson:"status"`
+
+	Remediation []baseline.RemediationAction `json:"remediation" bson:"remediation"`
 }
 
 var (
@@ -1453,6 +1456,9 @@ func GetChecklistDetail(c *gin.Context) {
 		DescriptionCn string `json:"description_cn" bson:"description_cn"`
 		Resolve       string `json:"resolve" bson:"solution"`
 		ResolveCn     string `json:"resolve_cn" bson:"solution_cn"`
+
+		Evidence     []baseline.CheckEvidence `json:"evidence" bson:"evidence"`
+		EvidenceDesc []string                 `json:"evidence_desc" bson:"-"`
 	}
 	var response Response
 
@@ -1482,9 +1488,33 @@ func GetChecklistDetail(c *gin.Context) {
 		response.Description = response.DescriptionCn
 		response.Resolve = response.ResolveCn
 	}
+
+	// 检查证据
+	if response.Evidence == nil {
+		response.Evidence = make([]baseline.CheckEvidence, 0)
+	}
+	response.EvidenceDesc = make([]string, 0, len(response.Evidence))
+	for _, evidence := range response.Evidence {
+		response.EvidenceDesc = append(response.EvidenceDesc, evidenceDesc(evidence, c.Request.Header.Get(HeaderLang) == LangCN))
+	}
 	CreateResponse(c, common.SuccessCode, response)
 }
 
+// 将检查证据格式化为可读的描述，例如 /etc/login.defs: PASS_MAX_DAYS 99999, value 99999, expect $(<=)90
+func evidenceDesc(evidence baseline.CheckEvidence, cn bool) string {
+	var desc string
+	if evidence.File != "" {
+		desc = evidence.File + ": "
+	}
+	if evidence.Line != "" && evidence.Line != fmt.Sprint(evidence.Value) {
+		desc += evidence.Line + ", "
+	}
+	if cn {
+		return desc + fmt.Sprintf("实际值 %v，期望 %s", evidence.Value, evidence.Expect)
+	}
+	return desc + fmt.Sprintf("value %v, expect %s", evidence.Value, evidence.Expect)
+}
+
 // 获取白名单弹框主机数
 func GetWhiteHostNum(c *gin.Context) {
 	type Request struct {
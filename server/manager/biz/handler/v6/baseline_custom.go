@@ -0,0 +1,197 @@
+package v6
+
+import (
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/baseline"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type CustomBaselineRequest struct {
+	BaselineId int `json:"baseline_id"`
+	Revision   int `json:"revision"`
+}
+
+// 当前用户是否为管理员
+func isAdminUser(c *gin.Context) bool {
+	user, ok := c.Get("user")
+	if !ok {
+		return false
+	}
+	var userInfo UserInfo
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.UserCollection)
+	if err := collection.FindOne(c, bson.M{"username": user}).Decode(&userInfo); err != nil {
+		return false
+	}
+	return userInfo.Level == 0
+}
+
+// 校验自定义基线
+func ValidateCustomBaseline(c *gin.Context) {
+	var request baseline.CustomBaseline
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("ValidateCustomBaseline", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	errList := baseline.ValidateCustomBaseline(&request)
+	if errList == nil {
+		errList = make([]baseline.ValidateError, 0)
+	}
+	common.CreateResponse(c, common.SuccessCode, errList)
+}
+
+// 保存自定义基线，每次保存生成新的revision
+func SaveCustomBaseline(c *gin.Context) {
+	var request baseline.CustomBaseline
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("SaveCustomBaseline", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+
+	// 获取用户
+	user, userOk := c.Get("user")
+	if !userOk {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "cannot get user info")
+		return
+	}
+	userName, unOk := user.(string)
+	if !unOk {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "cannot get user name")
+		return
+	}
+
+	res, errList, err := baseline.SaveCustomBaseline(request, userName)
+	if err != nil {
+		ylog.Errorf("SaveCustomBaseline", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	if len(errList) != 0 {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, errList)
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 获取自定义基线列表
+func GetCustomBaselineList(c *gin.Context) {
+	baselineList, err := baseline.GetCustomBaselineList()
+	if err != nil {
+		ylog.Errorf("GetCustomBaselineList", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, baselineList)
+}
+
+// 获取自定义基线详情，revision为0时返回最新revision
+func GetCustomBaselineDetail(c *gin.Context) {
+	var request CustomBaselineRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("GetCustomBaselineDetail", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	res, err := baseline.GetCustomBaseline(request.BaselineId, request.Revision)
+	if err != nil {
+		ylog.Errorf("GetCustomBaselineDetail", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 获取自定义基线的revision列表
+func GetCustomBaselineRevisions(c *gin.Context) {
+	var request CustomBaselineRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("GetCustomBaselineRevisions", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	res, err := baseline.GetCustomBaselineRevisions(request.BaselineId)
+	if err != nil {
+		ylog.Errorf("GetCustomBaselineRevisions", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 发布自定义基线
+func PublishCustomBaseline(c *gin.Context) {
+	var request CustomBaselineRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("PublishCustomBaseline", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	res, err := baseline.PublishCustomBaseline(request.BaselineId, request.Revision, isAdminUser(c))
+	if err == baseline.ErrPrivilegedRule {
+		common.CreateResponse(c, common.AuthFailedErrorCode, err.Error())
+		return
+	}
+	if err != nil {
+		ylog.Errorf("PublishCustomBaseline", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 在单台主机上试运行自定义基线，返回任务id
+func DryRunCustomBaseline(c *gin.Context) {
+	type Request struct {
+		AgentId     string `json:"agent_id"`
+		BaselineId  int    `json:"baseline_id"`
+		Revision    int    `json:"revision"`
+		CheckIdList []int  `json:"check_id_list"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil || request.AgentId == "" {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "need agent_id and baseline_id")
+		return
+	}
+	taskId, err := baseline.DryRunCustomBaseline(request.AgentId, request.BaselineId, request.Revision, request.CheckIdList, isAdminUser(c))
+	if err == baseline.ErrPrivilegedRule {
+		common.CreateResponse(c, common.AuthFailedErrorCode, err.Error())
+		return
+	}
+	if err != nil {
+		ylog.Errorf("DryRunCustomBaseline", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, map[string]string{"task_id": taskId})
+}
+
+// 获取试运行结果，包括每条规则获取的原始值
+func GetDryRunResult(c *gin.Context) {
+	type Request struct {
+		TaskId string `json:"task_id"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("GetDryRunResult", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	res, err := baseline.GetDryRunResult(request.TaskId)
+	if err != nil {
+		ylog.Errorf("GetDryRunResult", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
@@ -0,0 +1,222 @@
+package v6
+
+import (
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/baseline"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 获取当前用户名
+func getUserName(c *gin.Context) (string, bool) {
+	user, userOk := c.Get("user")
+	if !userOk {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "cannot get user info")
+		return "", false
+	}
+	userName, unOk := user.(string)
+	if !unOk {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "cannot get user name")
+		return "", false
+	}
+	return userName, true
+}
+
+// 申请修复主机上未通过的检查项，rollback为true时申请回滚remediation_id对应的修复
+func RequestRemediation(c *gin.Context) {
+	type Request struct {
+		AgentId       string `json:"agent_id"`
+		BaselineId    int    `json:"baseline_id"`
+		CheckIdList   []int  `json:"check_id_list"`
+		Reason        string `json:"reason"`
+		Rollback      bool   `json:"rollback"`
+		RemediationId string `json:"remediation_id"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("RequestRemediation", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	userName, ok := getUserName(c)
+	if !ok {
+		return
+	}
+
+	var res *baseline.Remediation
+	if request.Rollback {
+		res, err = baseline.CreateRollback(request.RemediationId, userName, request.Reason)
+	} else {
+		res, err = baseline.CreateRemediation(request.AgentId, request.BaselineId, request.CheckIdList, userName, request.Reason)
+	}
+	if err != nil {
+		ylog.Errorf("RequestRemediation", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 审批修复申请，通过后下发修复任务
+func ApproveRemediation(c *gin.Context) {
+	type Request struct {
+		RemediationId string `json:"remediation_id"`
+		Approve       bool   `json:"approve"`
+		Comment       string `json:"comment"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil || request.RemediationId == "" {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "need remediation_id")
+		return
+	}
+	userName, ok := getUserName(c)
+	if !ok {
+		return
+	}
+	res, err := baseline.ApproveRemediation(request.RemediationId, userName, request.Approve, request.Comment)
+	if err != nil {
+		ylog.Errorf("ApproveRemediation", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 获取修复申请列表
+func GetRemediationList(c *gin.Context) {
+	type Request struct {
+		AgentId    string `json:"agent_id"`
+		BaselineId int    `json:"baseline_id"`
+		Status     string `json:"status"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("GetRemediationList", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	var pageRequest common.PageRequest
+	err = c.BindQuery(&pageRequest)
+	if err != nil {
+		ylog.Errorf("GetRemediationList", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+
+	searchFilter := make(map[string]interface{})
+	if request.AgentId != "" {
+		searchFilter["agent_id"] = request.AgentId
+	}
+	if request.BaselineId != 0 {
+		searchFilter["baseline_id"] = request.BaselineId
+	}
+	if request.Status != "" {
+		searchFilter["status"] = request.Status
+	}
+	pageSearch := common.PageSearch{Page: pageRequest.Page, PageSize: pageRequest.PageSize,
+		Filter: searchFilter, Sorter: bson.M{"create_time": -1}}
+
+	dataResponse := make([]baseline.Remediation, 0)
+	remediationCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineRemediationColl)
+	pageResponse, err := common.DBSearchPaginate(
+		remediationCol,
+		pageSearch,
+		func(cursor *mongo.Cursor) error {
+			var remediation baseline.Remediation
+			err := cursor.Decode(&remediation)
+			if err != nil {
+				ylog.Errorf("GetRemediationList", err.Error())
+				return err
+			}
+			// 列表中不返回修复结果
+			remediation.Result = nil
+			dataResponse = append(dataResponse, remediation)
+			return nil
+		},
+	)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	CreatePageResponse(c, common.SuccessCode, dataResponse, *pageResponse)
+}
+
+// 获取修复申请详情，包括每个检查项修复前后的结果
+func GetRemediationDetail(c *gin.Context) {
+	type Request struct {
+		RemediationId string `json:"remediation_id"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("GetRemediationDetail", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	res, err := baseline.GetRemediation(request.RemediationId)
+	if err != nil {
+		ylog.Errorf("GetRemediationDetail", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 获取主机的修复审计记录
+func GetRemediationAudit(c *gin.Context) {
+	type Request struct {
+		AgentId       string `json:"agent_id"`
+		RemediationId string `json:"remediation_id"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil || (request.AgentId == "" && request.RemediationId == "") {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "need agent_id or remediation_id")
+		return
+	}
+	var pageRequest common.PageRequest
+	err = c.BindQuery(&pageRequest)
+	if err != nil {
+		ylog.Errorf("GetRemediationAudit", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+
+	searchFilter := make(map[string]interface{})
+	if request.AgentId != "" {
+		searchFilter["agent_id"] = request.AgentId
+	}
+	if request.RemediationId != "" {
+		searchFilter["remediation_id"] = request.RemediationId
+	}
+	pageSearch := common.PageSearch{Page: pageRequest.Page, PageSize: pageRequest.PageSize,
+		Filter: searchFilter, Sorter: bson.M{"time": -1}}
+
+	dataResponse := make([]baseline.RemediationAudit, 0)
+	auditCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineRemediationAuditColl)
+	pageResponse, err := common.DBSearchPaginate(
+		auditCol,
+		pageSearch,
+		func(cursor *mongo.Cursor) error {
+			var audit baseline.RemediationAudit
+			err := cursor.Decode(&audit)
+			if err != nil {
+				ylog.Errorf("GetRemediationAudit", err.Error())
+				return err
+			}
+			dataResponse = append(dataResponse, audit)
+			return nil
+		},
+	)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	CreatePageResponse(c, common.SuccessCode, dataResponse, *pageResponse)
+}
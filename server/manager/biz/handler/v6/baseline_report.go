@@ -0,0 +1,161 @@
+package v6
+
+import (
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/baseline"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var complianceScopeList = map[string]bool{baseline.ScopeHost: true, baseline.ScopeTag: true, baseline.ScopeFleet: true}
+
+// 获取支持的合规框架
+func GetComplianceFrameworkList(c *gin.Context) {
+	common.CreateResponse(c, common.SuccessCode, baseline.FrameworkList)
+}
+
+// 获取主机、主机标签或全部主机最新的合规得分，framework为空时为全部检查项
+func GetComplianceScore(c *gin.Context) {
+	type Request struct {
+		Scope     string `json:"scope"`
+		ScopeId   string `json:"scope_id"`
+		Framework string `json:"framework"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil || !complianceScopeList[request.Scope] {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "scope can only be host, tag or fleet")
+		return
+	}
+	res, err := baseline.GetScore(request.Scope, request.ScopeId, request.Framework)
+	if err != nil {
+		ylog.Errorf("GetComplianceScore", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 获取主机或主机标签最新的合规得分列表，按得分升序
+func GetComplianceScoreList(c *gin.Context) {
+	type Request struct {
+		Scope     string `json:"scope"`
+		Framework string `json:"framework"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil || (request.Scope != baseline.ScopeHost && request.Scope != baseline.ScopeTag) {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "scope can only be host or tag")
+		return
+	}
+	var pageRequest common.PageRequest
+	err = c.BindQuery(&pageRequest)
+	if err != nil {
+		ylog.Errorf("GetComplianceScoreList", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+
+	dataResponse := make([]baseline.ComplianceScore, 0)
+	searchFilter, err := baseline.ScoreFilter(request.Scope, "", request.Framework)
+	if err == mongo.ErrNoDocuments {
+		CreatePageResponse(c, common.SuccessCode, dataResponse, common.PageResponse{Page: pageRequest.Page, PageSize: pageRequest.PageSize})
+		return
+	} else if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	pageSearch := common.PageSearch{Page: pageRequest.Page, PageSize: pageRequest.PageSize,
+		Filter: searchFilter, Sorter: bson.M{"score": 1}}
+	scoreCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineScoreColl)
+	pageResponse, err := common.DBSearchPaginate(
+		scoreCol,
+		pageSearch,
+		func(cursor *mongo.Cursor) error {
+			var score baseline.ComplianceScore
+			err := cursor.Decode(&score)
+			if err != nil {
+				ylog.Errorf("GetComplianceScoreList", err.Error())
+				return err
+			}
+			dataResponse = append(dataResponse, score)
+			return nil
+		},
+	)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	CreatePageResponse(c, common.SuccessCode, dataResponse, *pageResponse)
+}
+
+// 获取合规得分趋势，默认最近30天
+func GetComplianceScoreTrend(c *gin.Context) {
+	type Request struct {
+		Scope     string `json:"scope"`
+		ScopeId   string `json:"scope_id"`
+		Framework string `json:"framework"`
+		Days      int    `json:"days"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil || !complianceScopeList[request.Scope] {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "scope can only be host, tag or fleet")
+		return
+	}
+	if request.Days <= 0 || request.Days > 365 {
+		request.Days = 30
+	}
+	res, err := baseline.GetScoreTrend(request.Scope, request.ScopeId, request.Framework, request.Days)
+	if err != nil {
+		ylog.Errorf("GetComplianceScoreTrend", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, res)
+}
+
+// 导出合规报告，返回文件名，通过/shared/Download下载
+func ExportComplianceReport(c *gin.Context) {
+	type Request struct {
+		Framework   string   `json:"framework"`
+		Format      string   `json:"format"`
+		AgentIdList []string `json:"agent_id_list"`
+		Tag         string   `json:"tag"`
+		Lang        string   `json:"lang"`
+	}
+	var request Request
+	err := c.BindJSON(&request)
+	if err != nil {
+		ylog.Errorf("ExportComplianceReport", err.Error())
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	switch request.Format {
+	case baseline.ReportFormatPdf, baseline.ReportFormatHtml, baseline.ReportFormatCsv:
+	default:
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "format can only be pdf, html or csv")
+		return
+	}
+	if _, ok := baseline.GetFramework(request.Framework); !ok {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "unsupported compliance framework")
+		return
+	}
+
+	report, err := baseline.BuildComplianceReport(request.Framework, request.AgentIdList, request.Tag, request.Lang)
+	if err != nil {
+		ylog.Errorf("ExportComplianceReport", err.Error())
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	fileName, err := baseline.SaveComplianceReport(report, request.Format)
+	if err != nil {
+		ylog.Errorf("ExportComplianceReport", err.Error())
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, bson.M{"file_name": fileName})
+}
@@ -0,0 +1,394 @@
+package v6
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/def"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/asset_center"
+	"github.com/bytedance/Elkeid/server/manager/internal/atask"
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collector task data type which hot-reloads the schedule policy
+const collectorPolicyDataType = 5101
+
+// handler names of the collector plugin
+var collectorHandlers = map[string]bool{
+	"process": true, "port": true, "user": true, "cron": true, "service": true,
+	"software": true, "container": true, "integrity": true, "volume": true,
+	"net_interface": true, "app": true, "kmod": true, "user_access": true,
+	"image_software": true, "connection": true,
+	"hidden_process": true, "fim": true, "secret": true, "persistence": true,
+}
+
+// options accepted by each handler of the collector plugin, and their validators
+var collectorHandlerOptions = map[string]map[string]func(string) error{
+	"app": {
+		"mysql_login_probe": func(v string) error {
+			_, err := strconv.ParseBool(v)
+			return err
+		},
+	},
+	"connection": {
+		"sample_interval": func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err == nil && d < time.Second {
+				err = fmt.Errorf("%s is less than 1s", d)
+			}
+			return err
+		},
+	},
+	"hidden_process": {
+		"max_pid": func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err == nil && n < 1 {
+				err = fmt.Errorf("%d is less than 1", n)
+			}
+			return err
+		},
+		"scan_rate": func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err == nil && n < 1024 {
+				err = fmt.Errorf("%d is less than 1024", n)
+			}
+			return err
+		},
+	},
+}
+
+type CollectorHandlerPolicy struct {
+	Enabled    *bool  `json:"enabled,omitempty" bson:"enabled,omitempty"`
+	Interval   string `json:"interval,omitempty" bson:"interval,omitempty"`
+	Cron       string `json:"cron,omitempty" bson:"cron,omitempty"`
+	Jitter     string `json:"jitter,omitempty" bson:"jitter,omitempty"`
+	QuietHours string `json:"quiet_hours,omitempty" bson:"quiet_hours,omitempty"`
+	// handler specific options, e.g. {"mysql_login_probe": "true"} of app
+	Options map[string]string `json:"options,omitempty" bson:"options,omitempty"`
+}
+
+func (p *CollectorHandlerPolicy) validateOptions(name string) error {
+	for k, v := range p.Options {
+		check, ok := collectorHandlerOptions[name][k]
+		if !ok {
+			return fmt.Errorf("unknown option %s", k)
+		}
+		if err := check(v); err != nil {
+			return fmt.Errorf("option %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (p *CollectorHandlerPolicy) validate() error {
+	if p.Cron != "" {
+		if _, err := cron.ParseStandard(p.Cron); err != nil {
+			return err
+		}
+	}
+	if p.Interval != "" {
+		d, err := time.ParseDuration(p.Interval)
+		if err != nil {
+			return err
+		}
+		if d < time.Minute {
+			return fmt.Errorf("interval %s is less than 1m", d)
+		}
+	}
+	if p.Jitter != "" {
+		if _, err := time.ParseDuration(p.Jitter); err != nil {
+			return err
+		}
+	}
+	for _, w := range strings.Split(p.QuietHours, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		var h1, m1, h2, m2 int
+		if _, err := fmt.Sscanf(w, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil ||
+			h1 < 0 || h1 > 24 || h2 < 0 || h2 > 24 || m1 < 0 || m1 > 59 || m2 < 0 || m2 > 59 {
+			return fmt.Errorf("invalid quiet hours %q", w)
+		}
+	}
+	return nil
+}
+
+type CollectorPolicy struct {
+	Default  CollectorHandlerPolicy            `json:"default" bson:"default"`
+	Handlers map[string]CollectorHandlerPolicy `json:"handlers" bson:"handlers"`
+	// set when the policy is sent to the agents, the plugin keeps the newest
+	// one of DETAIL and the pushed policies
+	UpdateTime int64 `json:"update_time,omitempty" bson:"-"`
+}
+
+// CollectorPolicyItem is the schedule policy of a host group, i.e. an agent tag.
+// An agent with several tags gets the policy of the highest priority, then the
+// latest updated one.
+type CollectorPolicyItem struct {
+	Tag        string          `json:"tag" bson:"tag"`
+	Priority   int             `json:"priority" bson:"priority"`
+	Policy     CollectorPolicy `json:"policy" bson:"policy"`
+	TaskID     string          `json:"task_id" bson:"task_id"`
+	User       string          `json:"user" bson:"user"`
+	UpdateTime int64           `json:"update_time" bson:"update_time"`
+}
+
+// collectorPolicies returns the policies of the tags in the order of precedence
+func collectorPolicies(ctx context.Context, tags []string) ([]CollectorPolicyItem, error) {
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.CollectorPolicyCollection)
+	cur, err := collection.Find(ctx, bson.M{"tag": bson.M{"$in": tags}},
+		options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "update_time", Value: -1}, {Key: "tag", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	items := []CollectorPolicyItem{}
+	err = cur.All(ctx, &items)
+	return items, err
+}
+
+// effectiveCollectorPolicy picks the policy of an agent from the sorted policies, nil if none of its tags has one
+func effectiveCollectorPolicy(items []CollectorPolicyItem, tags []string) *CollectorPolicyItem {
+	own := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		own[t] = true
+	}
+	for i := range items {
+		if own[items[i].Tag] {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+// CollectorPolicyDetail is the DETAIL of the collector plugin of an agent, so the
+// agents which are offline or newly installed when a policy is pushed get it at startup.
+// The built-in schedule is sent as an empty policy, which replaces the stale pushed ones.
+func CollectorPolicyDetail(ctx context.Context, agentID string) (string, error) {
+	hb := struct {
+		Tags []string `bson:"tags"`
+	}{}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentHeartBeatCollection)
+	err := collection.FindOne(ctx, bson.M{"agent_id": agentID}, options.FindOne().SetProjection(bson.M{"tags": 1})).Decode(&hb)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	policy := CollectorPolicy{}
+	if len(hb.Tags) > 0 {
+		items, err := collectorPolicies(ctx, hb.Tags)
+		if err != nil {
+			return "", err
+		}
+		if item := effectiveCollectorPolicy(items, hb.Tags); item != nil {
+			policy = item.Policy
+		}
+	}
+	policy.UpdateTime = time.Now().Unix()
+	data, err := json.Marshal(policy)
+	return string(data), err
+}
+
+func DescribeCollectorPolicy(c *gin.Context) {
+	filter := bson.M{}
+	if tag, ok := c.GetQuery("tag"); ok {
+		filter["tag"] = tag
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.CollectorPolicyCollection)
+	cur, err := collection.Find(c, filter)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	data := []CollectorPolicyItem{}
+	if err = cur.All(c, &data); err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, data)
+}
+
+type UpdateCollectorPolicyReqBody struct {
+	Tag      string          `json:"tag" binding:"required"`
+	Priority int             `json:"priority"`
+	Policy   CollectorPolicy `json:"policy"`
+}
+
+// UpdateCollectorPolicy saves the policy of a host group and pushes the resulting policies
+// to the online agents of the group, the others get it from DETAIL when they connect.
+func UpdateCollectorPolicy(c *gin.Context) {
+	rb := &UpdateCollectorPolicyReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	if err = rb.Policy.Default.validate(); err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "default: "+err.Error())
+		return
+	}
+	if len(rb.Policy.Default.Options) > 0 {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "default: options are only accepted by handlers")
+		return
+	}
+	for name, p := range rb.Policy.Handlers {
+		if !collectorHandlers[name] {
+			common.CreateResponse(c, common.ParamInvalidErrorCode, "unknown handler "+name)
+			return
+		}
+		if err = p.validate(); err == nil {
+			err = p.validateOptions(name)
+		}
+		if err != nil {
+			common.CreateResponse(c, common.ParamInvalidErrorCode, name+": "+err.Error())
+			return
+		}
+	}
+	userName := c.GetString("user")
+	item := CollectorPolicyItem{
+		Tag:        rb.Tag,
+		Priority:   rb.Priority,
+		Policy:     rb.Policy,
+		User:       userName,
+		UpdateTime: time.Now().Unix(),
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.CollectorPolicyCollection)
+	_, err = collection.UpdateOne(c, bson.M{"tag": rb.Tag}, bson.M{"$set": item}, (&options.UpdateOptions{}).SetUpsert(true))
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	taskIDs, err := pushCollectorPolicy(c, rb.Tag, userName)
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	if len(taskIDs) > 0 {
+		_, _ = collection.UpdateOne(c, bson.M{"tag": rb.Tag}, bson.M{"$set": bson.M{"task_id": strings.Join(taskIDs, ",")}})
+	}
+	common.CreateResponse(c, common.SuccessCode, taskIDs)
+}
+
+type DeleteCollectorPolicyReqBody struct {
+	Tag string `json:"tag" binding:"required"`
+}
+
+// DeleteCollectorPolicy removes the policy of a host group, agents of the group fall back to the built-in schedule.
+func DeleteCollectorPolicy(c *gin.Context) {
+	rb := &DeleteCollectorPolicyReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.CollectorPolicyCollection)
+	_, err = collection.DeleteOne(c, bson.M{"tag": rb.Tag})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	taskIDs, err := pushCollectorPolicy(c, rb.Tag, c.GetString("user"))
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, taskIDs)
+}
+
+// pushCollectorPolicy pushes the policy of each online agent of a tag, which may come from
+// another tag of the agent, the agents are grouped by the policy they get.
+func pushCollectorPolicy(ctx context.Context, tag, user string) ([]string, error) {
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentHeartBeatCollection)
+	cur, err := collection.Find(ctx, bson.M{"tags": tag, "last_heartbeat_time": bson.M{"$gte": time.Now().Unix() - asset_center.DEFAULT_OFFLINE_DURATION}},
+		options.Find().SetProjection(bson.M{"agent_id": 1, "tags": 1}))
+	if err != nil {
+		return nil, err
+	}
+	agents := []struct {
+		AgentID string   `bson:"agent_id"`
+		Tags    []string `bson:"tags"`
+	}{}
+	if err = cur.All(ctx, &agents); err != nil {
+		return nil, err
+	}
+	tags := map[string]bool{}
+	for _, a := range agents {
+		for _, t := range a.Tags {
+			tags[t] = true
+		}
+	}
+	tagList := make([]string, 0, len(tags))
+	for t := range tags {
+		tagList = append(tagList, t)
+	}
+	items, err := collectorPolicies(ctx, tagList)
+	if err != nil {
+		return nil, err
+	}
+	// the tag of the policy -> agents, "" for the built-in schedule
+	groups := map[string][]string{}
+	policies := map[string]CollectorPolicy{"": {}}
+	for _, a := range agents {
+		key := ""
+		if item := effectiveCollectorPolicy(items, a.Tags); item != nil {
+			key = item.Tag
+			policies[key] = item.Policy
+		}
+		groups[key] = append(groups[key], a.AgentID)
+	}
+	taskIDs := make([]string, 0, len(groups))
+	now := time.Now().Unix()
+	for key, ids := range groups {
+		policy := policies[key]
+		policy.UpdateTime = now
+		taskID, err := pushCollectorTaskToAgents(ids, user, collectorPolicyDataType, &policy)
+		if err != nil {
+			return taskIDs, err
+		}
+		taskIDs = append(taskIDs, taskID)
+	}
+	return taskIDs, nil
+}
+
+// pushCollectorTask sends v as the data of a collector task to the online agents of a tag.
+func pushCollectorTask(tag, user string, dataType int32, v interface{}) (string, error) {
+	return runCollectorTask(atask.AgentTask{Tag: tag}, user, dataType, v)
+}
+
+// pushCollectorTaskToAgents sends v as the data of a collector task to the agents.
+func pushCollectorTaskToAgents(ids []string, user string, dataType int32, v interface{}) (string, error) {
+	return runCollectorTask(atask.AgentTask{IDList: ids}, user, dataType, v)
+}
+
+func runCollectorTask(taskParam atask.AgentTask, user string, dataType int32, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	taskParam.TaskName = "collector"
+	taskParam.TaskUser = user
+	taskParam.Data = def.ConfigRequest{
+		Task: def.AgentTaskMsg{
+			Name:     "collector",
+			Data:     string(data),
+			DataType: dataType,
+		},
+	}
+	taskParam.SubTaskRunningTimeout = timeoutSeconds
+	taskID, _, err := atask.CreateTaskAndRun(&taskParam, atask.TypeAgentTask, 5)
+	if err != nil {
+		ylog.Errorf("pushCollectorTask", "tag %s agents %d data_type %d error %s", taskParam.Tag, len(taskParam.IDList), dataType, err.Error())
+		return "", err
+	}
+	return taskID, nil
+}
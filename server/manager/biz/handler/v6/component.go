@@ -146,6 +146,7 @@ type ComponentInstance struct {
 	DownloadURL []string `json:"download_url" bson:"download_url"`
 	Signature   string   `json:"signature" bson:"signature"`
 	Type        string   `json:"type" bson:"type"`
+	Detail      string   `json:"detail,omitempty" bson:"-"`
 }
 
 func (p *Policy) GetIntance(info *ContextInfo) (*ComponentInstance, error) {
@@ -660,6 +661,15 @@ func GetComponentInstances(c *gin.Context) {
 			instances = append(instances, i)
 		}
 	}
+	for _, i := range instances {
+		if i.Name != "collector" || info.AgentID == "" {
+			continue
+		}
+		// the collector policy is sent as DETAIL, so agents offline or newly installed at the push get it at startup
+		if i.Detail, err = CollectorPolicyDetail(c, info.AgentID); err != nil {
+			ylog.Errorf("[Component]", "get collector policy of %s failed: %v", info.AgentID, err.Error())
+		}
+	}
 	common.CreateResponse(c, common.SuccessCode, instances)
 }
 
@@ -0,0 +1,152 @@
+package v6
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collector task data type which configures the fim handler
+const fimDataType = 5067
+
+// FimRule monitors the files under paths, include and exclude are glob
+// patterns matched against both the full path and the base name.
+type FimRule struct {
+	Name    string   `json:"name" bson:"name"`
+	Paths   []string `json:"paths" bson:"paths"`
+	Include []string `json:"include,omitempty" bson:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty" bson:"exclude,omitempty"`
+	Diff    bool     `json:"diff,omitempty" bson:"diff,omitempty"`
+}
+
+type FimConfig struct {
+	Rules        []FimRule `json:"rules" bson:"rules"`
+	DisableWatch bool      `json:"disable_watch" bson:"disable_watch"`
+}
+
+func (cfg *FimConfig) validate() error {
+	names := map[string]bool{}
+	for _, r := range cfg.Rules {
+		if r.Name == "" {
+			return errors.New("empty rule name")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicated rule %s", r.Name)
+		}
+		names[r.Name] = true
+		if len(r.Paths) == 0 {
+			return fmt.Errorf("rule %s: empty paths", r.Name)
+		}
+		for _, p := range r.Paths {
+			if !filepath.IsAbs(p) {
+				return fmt.Errorf("rule %s: path %q isn't absolute", r.Name, p)
+			}
+		}
+		for _, p := range append(append([]string{}, r.Include...), r.Exclude...) {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("rule %s: invalid pattern %q", r.Name, p)
+			}
+		}
+	}
+	return nil
+}
+
+// FimRuleItem is the fim config of a host group, i.e. an agent tag
+type FimRuleItem struct {
+	Tag        string    `json:"tag" bson:"tag"`
+	Config     FimConfig `json:"config" bson:"config"`
+	TaskID     string    `json:"task_id" bson:"task_id"`
+	User       string    `json:"user" bson:"user"`
+	UpdateTime int64     `json:"update_time" bson:"update_time"`
+}
+
+func DescribeFimRule(c *gin.Context) {
+	filter := bson.M{}
+	if tag, ok := c.GetQuery("tag"); ok {
+		filter["tag"] = tag
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FimRuleCollection)
+	cur, err := collection.Find(c, filter)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	data := []FimRuleItem{}
+	if err = cur.All(c, &data); err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, data)
+}
+
+type UpdateFimRuleReqBody struct {
+	Tag    string    `json:"tag" binding:"required"`
+	Config FimConfig `json:"config"`
+}
+
+// UpdateFimRule saves the fim rules of a host group and pushes them to the online agents of the group.
+func UpdateFimRule(c *gin.Context) {
+	rb := &UpdateFimRuleReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	if err = rb.Config.validate(); err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	userName := c.GetString("user")
+	taskID, err := pushCollectorTask(rb.Tag, userName, fimDataType, &rb.Config)
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	item := FimRuleItem{
+		Tag:        rb.Tag,
+		Config:     rb.Config,
+		TaskID:     taskID,
+		User:       userName,
+		UpdateTime: time.Now().Unix(),
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FimRuleCollection)
+	_, err = collection.UpdateOne(c, bson.M{"tag": rb.Tag}, bson.M{"$set": item}, (&options.UpdateOptions{}).SetUpsert(true))
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, taskID)
+}
+
+type DeleteFimRuleReqBody struct {
+	Tag string `json:"tag" binding:"required"`
+}
+
+// DeleteFimRule removes the fim rules of a host group, agents of the group fall back to the built-in rules.
+func DeleteFimRule(c *gin.Context) {
+	rb := &DeleteFimRuleReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	taskID, err := pushCollectorTask(rb.Tag, c.GetString("user"), fimDataType, &FimConfig{})
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FimRuleCollection)
+	_, err = collection.DeleteOne(c, bson.M{"tag": rb.Tag})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, taskID)
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,7 +33,7 @@ const (
 	timeoutSeconds  = 15 * 60
 )
 
-var FPType = map[string]int32{"port": 5051, "process": 5050, "user": 5052, "cron": 5053, "service": 5054, "software": 5055, "container": 5056, "integrity": 5057, "app": 5060, "kmod": 5062}
+var FPType = map[string]int32{"port": 5051, "process": 5050, "user": 5052, "cron": 5053, "service": 5054, "software": 5055, "container": 5056, "integrity": 5057, "app": 5060, "kmod": 5062, "user_access": 5063, "image_software": 5064, "connection": 5065, "hidden_process": 5066, "fim": 5067, "secret": 5068, "persistence": 5069}
 
 type FPTaskItem struct {
 	DataType   int32  `json:"data_type" bson:"data_type"`
@@ -41,6 +43,40 @@ type FPTaskItem struct {
 type RefreshDataReqBody struct {
 	FingerprintType string `json:"fingerprint_type" binding:"required"`
 	AgentID         string `json:"agent_id"`
+	// rescan a part of the data only, which isn't limited by the cooldown
+	Filter *RefreshFilter `json:"filter"`
+}
+
+// RefreshFilter narrows a refresh down to processes, a container, a path
+// prefix or a package, records in its scope are replaced by the results.
+type RefreshFilter struct {
+	Pids []string `json:"pids,omitempty"`
+	// include descendants of pids
+	Tree        bool   `json:"tree,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Package     string `json:"package,omitempty"`
+}
+
+// fingerprint types whose collector handlers support filters
+var filterFPType = map[string]bool{"port": true, "process": true, "software": true, "integrity": true, "image_software": true, "secret": true}
+
+func (f *RefreshFilter) validate(fingerprintType string) error {
+	if !filterFPType[fingerprintType] {
+		return errors.New("fingerprint_type doesn't support filter")
+	}
+	if len(f.Pids) == 0 && f.ContainerID == "" && f.Path == "" && f.Package == "" {
+		return errors.New("empty filter")
+	}
+	for _, pid := range f.Pids {
+		if _, err := strconv.ParseUint(pid, 10, 32); err != nil {
+			return fmt.Errorf("invalid pid %q", pid)
+		}
+	}
+	if f.Path != "" && !strings.HasPrefix(f.Path, "/") {
+		return errors.New("filter path isn't absolute")
+	}
+	return nil
 }
 
 func RefreshData(c *gin.Context) {
@@ -55,6 +91,20 @@ func RefreshData(c *gin.Context) {
 		common.CreateResponse(c, common.ParamInvalidErrorCode, "fingerprint_type not support")
 		return
 	}
+	var data string
+	if rb.Filter != nil {
+		// filtered refreshes skip the cooldown, so they are limited to one host
+		if rb.AgentID == "" {
+			common.CreateResponse(c, common.ParamInvalidErrorCode, "agent_id is required with filter")
+			return
+		}
+		if err = rb.Filter.validate(rb.FingerprintType); err != nil {
+			common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+			return
+		}
+		b, _ := json.Marshal(bson.M{"filter": rb.Filter})
+		data = string(b)
+	}
 
 	filter := bson.M{"data_type": dataType}
 	LockKey := fmt.Sprintf("FingerPrint-RefreshData-%d", dataType)
@@ -93,7 +143,7 @@ func RefreshData(c *gin.Context) {
 		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
 		return
 	}
-	if time.Now().Unix()-fpItem.UpdateTime < cooldownSeconds {
+	if rb.Filter == nil && time.Now().Unix()-fpItem.UpdateTime < cooldownSeconds {
 		common.CreateResponse(c, common.ExceedLimitErrorCode, "Exceed the frequency limit")
 		return
 	}
@@ -102,7 +152,7 @@ func RefreshData(c *gin.Context) {
 	if rb.AgentID != "" {
 		taskLinux := &def.AgentTaskMsg{
 			Name:     "collector",
-			Data:     "",
+			Data:     data,
 			DataType: dataType,
 		}
 		taskID, err = atask.SendFastTask(rb.AgentID, taskLinux, true, timeoutSeconds, nil)
@@ -122,7 +172,7 @@ func RefreshData(c *gin.Context) {
 			Data: def.ConfigRequest{
 				Task: def.AgentTaskMsg{
 					Name:     "collector",
-					Data:     "",
+					Data:     data,
 					DataType: dataType,
 				},
 			},
@@ -135,6 +185,12 @@ func RefreshData(c *gin.Context) {
 		}
 	}
 
+	// filtered refreshes don't count for the cooldown
+	if rb.Filter != nil {
+		common.CreateResponse(c, common.SuccessCode, taskID)
+		return
+	}
+
 	fpItem = FPTaskItem{
 		DataType:   dataType,
 		TaskID:     taskID,
@@ -833,7 +889,7 @@ func DescribeSoftware(c *gin.Context) {
 }
 
 type ExportDataReqBody struct {
-	FingerprintType string          `json:"fingerprint_type" binding:"oneof=process port user cron service software container integrity app kmod"`
+	FingerprintType string          `json:"fingerprint_type" binding:"oneof=process port user cron service software container container_risk integrity app app_risk kmod user_access image_software connection hidden_process fim secret persistence"`
 	IdList          []string        `json:"id_list" binding:"required_without=Conditions"`
 	Conditions      json.RawMessage `json:"conditions" binding:"required_without=IdList"`
 }
@@ -1005,6 +1061,26 @@ func ExportData(c *gin.Context) {
 			{"state", "State"},
 			{"create_time", "CreateTime"},
 		}...)
+	case "container_risk":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeContainerRiskReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintContainerRiskCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"check_id", "CheckID"},
+			{"check_name", "CheckName"},
+			{"security", "Security"},
+			{"evidence", "Evidence"},
+			{"container_id", "ContainerID"},
+			{"container_name", "ContainerName"},
+			{"image_name", "ImageName"},
+		}...)
 	case "integrity":
 		if len(rb.IdList) == 0 {
 			cond := &DescribeIntegrityReqBody{}
@@ -1023,6 +1099,12 @@ func ExportData(c *gin.Context) {
 			{"origin_digest", "OriginDigest"},
 			{"digest", "Digest"},
 			{"modify_time", "ModifyTime"},
+			{"origin_mode", "OriginMode"},
+			{"mode", "Mode"},
+			{"origin_owner", "OriginOwner"},
+			{"owner", "Owner"},
+			{"config", "Config"},
+			{"drift_type", "DriftType"},
 		}...)
 	case "app":
 		if len(rb.IdList) == 0 {
@@ -1045,6 +1127,27 @@ func ExportData(c *gin.Context) {
 			{"exe", "Exe"},
 			{"start_time", "StartTime"},
 		}...)
+	case "app_risk":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeAppRiskReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintAppRiskCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"name", "Name"},
+			{"check_id", "CheckID"},
+			{"check_name", "CheckName"},
+			{"security", "Security"},
+			{"evidence", "Evidence"},
+			{"conf", "Conf"},
+			{"container_id", "ContainerID"},
+			{"pid", "Pid"},
+		}...)
 	case "kmod":
 		if len(rb.IdList) == 0 {
 			cond := &DescribeKmodReq{}
@@ -1063,6 +1166,171 @@ func ExportData(c *gin.Context) {
 			{"used_by", "UsedBy"},
 			{"state", "State"},
 			{"addr", "Addr"},
+			{"path", "Path"},
+			{"vermagic", "Vermagic"},
+			{"signature", "Signature"},
+			{"signer", "Signer"},
+			{"package_name", "PackageName"},
+			{"package_version", "PackageVersion"},
+			{"hidden", "Hidden"},
+			{"risk_reason", "RiskReason"},
+		}...)
+	case "user_access":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeUserAccessReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintUserAccessCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"type", "Type"},
+			{"username", "Username"},
+			{"uid", "Uid"},
+			{"root", "Root"},
+			{"path", "Path"},
+			{"key_type", "KeyType"},
+			{"fingerprint", "Fingerprint"},
+			{"from", "From"},
+			{"principal", "Principal"},
+			{"runas_user", "RunasUser"},
+			{"commands", "Commands"},
+			{"nopasswd", "NoPasswd"},
+		}...)
+	case "image_software":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeImageSoftwareReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintImageSoftwareCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"image_id", "ImageID"},
+			{"image_name", "ImageName"},
+			{"name", "Name"},
+			{"type", "Type"},
+			{"sversion", "Version"},
+			{"source", "Source"},
+			{"vendor", "Vendor"},
+		}...)
+	case "connection":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeConnectionReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintConnectionCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"direction", "Direction"},
+			{"protocol", "Protocol"},
+			{"local_ip", "LocalIP"},
+			{"local_port", "LocalPort"},
+			{"remote_ip", "RemoteIP"},
+			{"remote_port", "RemotePort"},
+			{"remote_scope", "RemoteScope"},
+			{"path", "Path"},
+			{"peer_exe", "PeerExe"},
+			{"exe", "Exe"},
+			{"container_name", "ContainerName"},
+			{"conn_count", "ConnCount"},
+			{"max_concurrent", "MaxConcurrent"},
+		}...)
+	case "hidden_process":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeHiddenProcessReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintHiddenProcessCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"type", "Type"},
+			{"severity", "Severity"},
+			{"pid", "Pid"},
+			{"exe", "Exe"},
+			{"cmdline", "Cmdline"},
+			{"found_in", "FoundIn"},
+			{"missing", "Missing"},
+			{"library", "Library"},
+			{"checksum", "Checksum"},
+		}...)
+	case "fim":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeFimEventReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintFimCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"event", "Event"},
+			{"rule", "Rule"},
+			{"path", "Path"},
+			{"source", "Source"},
+			{"origin_digest", "OriginDigest"},
+			{"digest", "Digest"},
+			{"origin_mode", "OriginMode"},
+			{"mode", "Mode"},
+			{"origin_owner", "OriginOwner"},
+			{"owner", "Owner"},
+			{"diff", "Diff"},
+		}...)
+	case "secret":
+		if len(rb.IdList) == 0 {
+			cond := &DescribeSecretReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintSecretCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"rule", "Rule"},
+			{"severity", "Severity"},
+			{"path", "Path"},
+			{"line", "Line"},
+			{"match", "Match"},
+			{"container_id", "ContainerID"},
+			{"container_name", "ContainerName"},
+		}...)
+	case "persistence":
+		if len(rb.IdList) == 0 {
+			cond := &DescribePersistenceReq{}
+			err = json.Unmarshal(rb.Conditions, cond)
+			if err != nil {
+				common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+				return
+			}
+			cond.MarshalToBson(m)
+		}
+		collection = infra.FingerprintPersistenceCollection
+		defs = append(defs, common.MongoDBDefs{
+			{"mechanism", "Mechanism"},
+			{"name", "Name"},
+			{"trigger", "Trigger"},
+			{"command", "Command"},
+			{"path", "Path"},
+			{"owner", "Owner"},
+			{"checksum", "Checksum"},
 		}...)
 	}
 	defs = append(defs, struct {
@@ -1208,6 +1476,9 @@ type DescribeIntegrityReqBody struct {
 	Exe             string `json:"exe"`
 	ModifyTimeStart *int   `json:"modify_time_start"`
 	ModifyTimeEnd   *int   `json:"modify_time_end"`
+	// digest, mode or owner
+	DriftType []string `json:"drift_type"`
+	Config    *bool    `json:"config"`
 }
 
 func (q *DescribeIntegrityReqBody) MarshalToBson(m bson.M) {
@@ -1227,6 +1498,21 @@ func (q *DescribeIntegrityReqBody) MarshalToBson(m bson.M) {
 			"$lt":  *q.ModifyTimeEnd,
 		}
 	}
+	if len(q.DriftType) != 0 {
+		m["drift_type"] = bson.M{"$in": driftTypeRegexes(q.DriftType)}
+	}
+	if q.Config != nil {
+		m["config"] = strconv.FormatBool(*q.Config)
+	}
+}
+
+// drift_type is comma separated, e.g. "digest,mode"
+func driftTypeRegexes(types []string) []primitive.Regex {
+	ret := make([]primitive.Regex, 0, len(types))
+	for _, t := range types {
+		ret = append(ret, primitive.Regex{Pattern: "(^|,)" + regexp.QuoteMeta(t) + "(,|$)"})
+	}
+	return ret
 }
 
 type DescribeIntegrityRespItem struct {
@@ -1238,6 +1524,12 @@ type DescribeIntegrityRespItem struct {
 	Digest               string `json:"digest" bson:"digest"`
 	Exe                  string `json:"exe" bson:"exe"`
 	ModifyTime           int    `json:"modify_time" bson:"modify_time"`
+	OriginMode           string `json:"origin_mode" bson:"origin_mode"`
+	Mode                 string `json:"mode" bson:"mode"`
+	OriginOwner          string `json:"origin_owner" bson:"origin_owner"`
+	Owner                string `json:"owner" bson:"owner"`
+	Config               string `json:"config" bson:"config"`
+	DriftType            string `json:"drift_type" bson:"drift_type"`
 }
 
 func DescribeIntegrity(c *gin.Context) {
@@ -1327,6 +1619,7 @@ type DescribeContainerRespItem struct {
 	ImageID              string `json:"image_id" bson:"image_id"`
 	ImageName            string `json:"image_name" bson:"image_name"`
 	CreateTime           int    `json:"create_time" bson:"create_time"`
+	RiskNum              int64  `json:"risk_num" bson:"-"`
 }
 
 func DescribeContainer(c *gin.Context) {
@@ -1362,6 +1655,129 @@ func DescribeContainer(c *gin.Context) {
 		}
 		return
 	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		fillContainerRiskNum(c, data)
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+// 统计每个容器的权限风险数量
+func fillContainerRiskNum(ctx context.Context, data []DescribeContainerRespItem) {
+	if len(data) == 0 {
+		return
+	}
+	cond := bson.A{}
+	for _, item := range data {
+		cond = append(cond, bson.M{"agent_id": item.AgentID, "container_id": item.ContainerID})
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintContainerRiskCollection)
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"$or": cond}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"agent_id": "$agent_id", "container_id": "$container_id"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		ylog.Errorf("DescribeContainer", "aggregate container risk error %s", err.Error())
+		return
+	}
+	var res []struct {
+		ID struct {
+			AgentID     string `bson:"agent_id"`
+			ContainerID string `bson:"container_id"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	err = cursor.All(ctx, &res)
+	if err != nil {
+		ylog.Errorf("DescribeContainer", "decode container risk error %s", err.Error())
+		return
+	}
+	count := make(map[string]int64, len(res))
+	for _, r := range res {
+		count[r.ID.AgentID+r.ID.ContainerID] = r.Count
+	}
+	for i := range data {
+		data[i].RiskNum = count[data[i].AgentID+data[i].ContainerID]
+	}
+}
+
+// DescribeContainerRisk defs
+type DescribeContainerRiskReq struct {
+	BasicHostQuery
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	CheckID       string `json:"check_id"`
+	Security      string `json:"security" binding:"omitempty,oneof=high mid low"`
+}
+
+func (q *DescribeContainerRiskReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if q.ContainerID != "" {
+		m["container_id"] = q.ContainerID
+	}
+	if q.ContainerName != "" {
+		m["container_name"] = utils.TransBackwardsRegex(q.ContainerName)
+	}
+	if q.CheckID != "" {
+		m["check_id"] = q.CheckID
+	}
+	if q.Security != "" {
+		m["security"] = q.Security
+	}
+}
+
+type DescribeContainerRiskItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	CheckID              string `json:"check_id" bson:"check_id"`
+	CheckName            string `json:"check_name" bson:"check_name"`
+	Security             string `json:"security" bson:"security"`
+	Description          string `json:"description" bson:"description"`
+	Solution             string `json:"solution" bson:"solution"`
+	Evidence             string `json:"evidence" bson:"evidence"`
+	ContainerID          string `json:"container_id" bson:"container_id"`
+	ContainerName        string `json:"container_name" bson:"container_name"`
+	ImageName            string `json:"image_name" bson:"image_name"`
+	Runtime              string `json:"runtime" bson:"runtime"`
+}
+
+func DescribeContainerRisk(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeContainerRiskReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintContainerRiskCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeContainerRiskItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeContainerRiskItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
 	if err != nil {
 		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
 	} else {
@@ -1582,8 +1998,12 @@ func DescribeNetInterface(c *gin.Context) {
 
 type DescribeKmodReq struct {
 	BasicHostQuery
-	State []string `json:"state" binding:"omitempty,dive,oneof=Live Loading Unloading"`
-	Name  string   `json:"name"`
+	State       []string `json:"state" binding:"omitempty,dive,oneof=Live Loading Unloading"`
+	Name        string   `json:"name"`
+	Signature   []string `json:"signature" binding:"omitempty,dive,oneof=signed unsigned unknown"`
+	PackageName string   `json:"package_name"`
+	Hidden      *bool    `json:"hidden"`
+	Risk        *bool    `json:"risk"`
 }
 
 func (q *DescribeKmodReq) MarshalToBson(m bson.M) {
@@ -1596,6 +2016,24 @@ func (q *DescribeKmodReq) MarshalToBson(m bson.M) {
 	if q.Name != "" {
 		m["name"] = utils.TransBackwardsRegex(q.Name)
 	}
+	if len(q.Signature) > 0 {
+		m["signature"] = bson.M{
+			"$in": q.Signature,
+		}
+	}
+	if q.PackageName != "" {
+		m["package_name"] = utils.TransBackwardsRegex(q.PackageName)
+	}
+	if q.Hidden != nil {
+		if *q.Hidden {
+			m["hidden"] = bson.M{"$nin": bson.A{"", nil}}
+		} else {
+			m["hidden"] = bson.M{"$in": bson.A{"", nil}}
+		}
+	}
+	if q.Risk != nil {
+		m["risk"] = strconv.FormatBool(*q.Risk)
+	}
 }
 
 type DescribeKmodItem struct {
@@ -1607,6 +2045,20 @@ type DescribeKmodItem struct {
 	UsedBy               string `json:"used_by" bson:"used_by"`
 	State                string `json:"state" bson:"state"`
 	Addr                 string `json:"addr" bson:"addr"`
+	Path                 string `json:"path" bson:"path"`
+	Vermagic             string `json:"vermagic" bson:"vermagic"`
+	SrcVersion           string `json:"srcversion" bson:"srcversion"`
+	Version              string `json:"version" bson:"version"`
+	Taint                string `json:"taint" bson:"taint"`
+	Signature            string `json:"signature" bson:"signature"`
+	Signer               string `json:"signer" bson:"signer"`
+	SigKey               string `json:"sig_key" bson:"sig_key"`
+	SigHashAlgo          string `json:"sig_hashalgo" bson:"sig_hashalgo"`
+	PackageName          string `json:"package_name" bson:"package_name"`
+	PackageVersion       string `json:"package_version" bson:"package_version"`
+	Hidden               string `json:"hidden" bson:"hidden"`
+	Risk                 string `json:"risk" bson:"risk"`
+	RiskReason           string `json:"risk_reason" bson:"risk_reason"`
 }
 
 func DescribeKmod(c *gin.Context) {
@@ -1720,6 +2172,7 @@ type DescribeAppRespItem struct {
 	Exe                  string `json:"exe" bson:"exe"`
 	Conf                 string `json:"conf" bson:"conf"`
 	StartTime            int64  `json:"start_time" bson:"start_time"`
+	RiskNum              int64  `json:"risk_num" bson:"-"`
 }
 
 func DescribeApp(c *gin.Context) {
@@ -1755,6 +2208,762 @@ func DescribeApp(c *gin.Context) {
 		}
 		return
 	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		fillAppRiskNum(c, data)
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+// 统计每个应用的配置风险数量
+func fillAppRiskNum(ctx context.Context, data []DescribeAppRespItem) {
+	if len(data) == 0 {
+		return
+	}
+	cond := bson.A{}
+	for _, item := range data {
+		cond = append(cond, bson.M{"agent_id": item.AgentID, "pid": item.PID})
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintAppRiskCollection)
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"$or": cond}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"agent_id": "$agent_id", "pid": "$pid"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		ylog.Errorf("DescribeApp", "aggregate app risk error %s", err.Error())
+		return
+	}
+	var res []struct {
+		ID struct {
+			AgentID string `bson:"agent_id"`
+			PID     string `bson:"pid"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	err = cursor.All(ctx, &res)
+	if err != nil {
+		ylog.Errorf("DescribeApp", "decode app risk error %s", err.Error())
+		return
+	}
+	count := make(map[string]int64, len(res))
+	for _, r := range res {
+		count[r.ID.AgentID+r.ID.PID] = r.Count
+	}
+	for i := range data {
+		data[i].RiskNum = count[data[i].AgentID+data[i].PID]
+	}
+}
+
+// DescribeAppRisk defs
+type DescribeAppRiskReq struct {
+	BasicHostQuery
+	Name        string `json:"name"`
+	PID         string `json:"pid"`
+	ContainerID string `json:"container_id"`
+	CheckID     string `json:"check_id"`
+	Security    string `json:"security" binding:"omitempty,oneof=high mid low"`
+}
+
+func (q *DescribeAppRiskReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if q.Name != "" {
+		m["name"] = utils.TransBackwardsRegex(q.Name)
+	}
+	if q.PID != "" {
+		m["pid"] = q.PID
+	}
+	if q.ContainerID != "" {
+		m["container_id"] = q.ContainerID
+	}
+	if q.CheckID != "" {
+		m["check_id"] = q.CheckID
+	}
+	if q.Security != "" {
+		m["security"] = q.Security
+	}
+}
+
+type DescribeAppRiskItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Name                 string `json:"name" bson:"name"`
+	Type                 string `json:"type" bson:"type"`
+	CheckID              string `json:"check_id" bson:"check_id"`
+	CheckName            string `json:"check_name" bson:"check_name"`
+	Security             string `json:"security" bson:"security"`
+	Description          string `json:"description" bson:"description"`
+	Solution             string `json:"solution" bson:"solution"`
+	Evidence             string `json:"evidence" bson:"evidence"`
+	Conf                 string `json:"conf" bson:"conf"`
+	ContainerID          string `json:"container_id" bson:"container_id"`
+	ContainerName        string `json:"container_name" bson:"container_name"`
+	PID                  string `json:"pid" bson:"pid"`
+	Exe                  string `json:"exe" bson:"exe"`
+}
+
+func DescribeAppRisk(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeAppRiskReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintAppRiskCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeAppRiskItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeAppRiskItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+// DescribeUserAccess defs
+type DescribeUserAccessReq struct {
+	BasicHostQuery
+	Type        string `json:"type" binding:"omitempty,oneof=authorized_key sudoers"`
+	Username    string `json:"username"`
+	Fingerprint string `json:"fingerprint"`
+	NoPasswd    *bool  `json:"nopasswd"`
+	Root        *bool  `json:"root"`
+}
+
+func (q *DescribeUserAccessReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if q.Type != "" {
+		m["type"] = q.Type
+	}
+	if q.Username != "" {
+		m["username"] = utils.TransBackwardsRegex(q.Username)
+	}
+	if q.Fingerprint != "" {
+		m["fingerprint"] = q.Fingerprint
+	}
+	if q.NoPasswd != nil {
+		m["nopasswd"] = strconv.FormatBool(*q.NoPasswd)
+	}
+	if q.Root != nil {
+		m["root"] = strconv.FormatBool(*q.Root)
+	}
+}
+
+type DescribeUserAccessItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Type                 string `json:"type" bson:"type"`
+	Username             string `json:"username" bson:"username"`
+	Uid                  string `json:"uid" bson:"uid"`
+	Path                 string `json:"path" bson:"path"`
+	Line                 string `json:"line" bson:"line"`
+	Root                 string `json:"root" bson:"root"`
+	// authorized_key
+	KeyType     string `json:"key_type" bson:"key_type"`
+	KeyBits     string `json:"key_bits" bson:"key_bits"`
+	Fingerprint string `json:"fingerprint" bson:"fingerprint"`
+	Comment     string `json:"comment" bson:"comment"`
+	Options     string `json:"options" bson:"options"`
+	From        string `json:"from" bson:"from"`
+	Command     string `json:"command" bson:"command"`
+	// sudoers
+	Principal  string `json:"principal" bson:"principal"`
+	Hosts      string `json:"hosts" bson:"hosts"`
+	RunasUser  string `json:"runas_user" bson:"runas_user"`
+	RunasGroup string `json:"runas_group" bson:"runas_group"`
+	Commands   string `json:"commands" bson:"commands"`
+	Tags       string `json:"tags" bson:"tags"`
+	NoPasswd   string `json:"nopasswd" bson:"nopasswd"`
+}
+
+func DescribeUserAccess(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeUserAccessReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintUserAccessCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeUserAccessItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeUserAccessItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+// DescribeImageSoftware defs
+type DescribeImageSoftwareReq struct {
+	BasicHostQuery
+	ImageID   string   `json:"image_id"`
+	ImageName string   `json:"image_name"`
+	Name      string   `json:"name"`
+	Type      []string `json:"type" binding:"omitempty,dive,oneof=dpkg rpm apk"`
+	Version   string   `json:"version"`
+}
+
+func (q *DescribeImageSoftwareReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if q.ImageID != "" {
+		m["image_id"] = q.ImageID
+	}
+	if q.ImageName != "" {
+		m["image_name"] = utils.TransBackwardsRegex(q.ImageName)
+	}
+	if q.Name != "" {
+		m["name"] = utils.TransBackwardsRegex(q.Name)
+	}
+	if len(q.Type) != 0 {
+		m["type"] = bson.M{"$in": q.Type}
+	}
+	if q.Version != "" {
+		m["sversion"] = utils.TransBackwardsRegex(q.Version)
+	}
+}
+
+type DescribeImageSoftwareItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	ImageID              string `json:"image_id" bson:"image_id"`
+	ImageName            string `json:"image_name" bson:"image_name"`
+	Runtime              string `json:"runtime" bson:"runtime"`
+	Name                 string `json:"name" bson:"name"`
+	Type                 string `json:"type" bson:"type"`
+	Version              string `json:"version" bson:"sversion"`
+	Source               string `json:"source" bson:"source"`
+	Vendor               string `json:"vendor" bson:"vendor"`
+	Status               string `json:"status" bson:"status"`
+}
+
+func DescribeImageSoftware(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeImageSoftwareReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintImageSoftwareCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeImageSoftwareItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeImageSoftwareItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+type DescribeConnectionReq struct {
+	BasicHostQuery
+	Direction   []string `json:"direction" binding:"omitempty,dive,oneof=inbound outbound"`
+	Family      []string `json:"family" binding:"omitempty,dive,oneof=1 2 10"`
+	Protocol    []string `json:"protocol" binding:"omitempty,dive,oneof=6 17"`
+	RemoteIP    string   `json:"remote_ip"`
+	RemotePort  string   `json:"remote_port"`
+	RemoteScope []string `json:"remote_scope" binding:"omitempty,dive,oneof=loopback private public"`
+	LocalPort   string   `json:"local_port"`
+	Exe         string   `json:"exe"`
+	Path        string   `json:"path"`
+	ContainerID string   `json:"container_id"`
+}
+
+func (q *DescribeConnectionReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if len(q.Direction) != 0 {
+		m["direction"] = bson.M{"$in": q.Direction}
+	}
+	if len(q.Family) != 0 {
+		m["family"] = bson.M{"$in": q.Family}
+	}
+	if len(q.Protocol) != 0 {
+		m["protocol"] = bson.M{"$in": q.Protocol}
+	}
+	if q.RemoteIP != "" {
+		m["remote_ip"] = utils.TransBackwardsRegex(q.RemoteIP)
+	}
+	if q.RemotePort != "" {
+		m["remote_port"] = q.RemotePort
+	}
+	if len(q.RemoteScope) != 0 {
+		m["remote_scope"] = bson.M{"$in": q.RemoteScope}
+	}
+	if q.LocalPort != "" {
+		m["local_port"] = q.LocalPort
+	}
+	if q.Exe != "" {
+		m["exe"] = utils.TransBackwardsRegex(q.Exe)
+	}
+	if q.Path != "" {
+		m["path"] = utils.TransBackwardsRegex(q.Path)
+	}
+	if q.ContainerID != "" {
+		m["container_id"] = q.ContainerID
+	}
+}
+
+type DescribeConnectionItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Direction            string `json:"direction" bson:"direction"`
+	Family               string `json:"family" bson:"family"`
+	Protocol             string `json:"protocol" bson:"protocol"`
+	Type                 string `json:"type" bson:"type"`
+	LocalIP              string `json:"local_ip" bson:"local_ip"`
+	LocalPort            string `json:"local_port" bson:"local_port"`
+	RemoteIP             string `json:"remote_ip" bson:"remote_ip"`
+	RemotePort           string `json:"remote_port" bson:"remote_port"`
+	RemoteScope          string `json:"remote_scope" bson:"remote_scope"`
+	Path                 string `json:"path" bson:"path"`
+	PeerPid              string `json:"peer_pid" bson:"peer_pid"`
+	PeerExe              string `json:"peer_exe" bson:"peer_exe"`
+	Pid                  string `json:"pid" bson:"pid"`
+	Exe                  string `json:"exe" bson:"exe"`
+	Comm                 string `json:"comm" bson:"comm"`
+	Username             string `json:"username" bson:"username"`
+	ContainerID          string `json:"container_id" bson:"container_id"`
+	ContainerName        string `json:"container_name" bson:"container_name"`
+	ConnCount            string `json:"conn_count" bson:"conn_count"`
+	MaxConcurrent        string `json:"max_concurrent" bson:"max_concurrent"`
+	Samples              string `json:"samples" bson:"samples"`
+	FirstSeen            string `json:"first_seen" bson:"first_seen"`
+	LastSeen             string `json:"last_seen" bson:"last_seen"`
+}
+
+func DescribeConnection(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeConnectionReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintConnectionCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeConnectionItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeConnectionItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+type DescribeHiddenProcessReq struct {
+	BasicHostQuery
+	Type    []string `json:"type" binding:"omitempty,dive,oneof=hidden_process ld_so_preload ld_preload_env"`
+	Exe     string   `json:"exe"`
+	Library string   `json:"library"`
+}
+
+func (q *DescribeHiddenProcessReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if len(q.Type) != 0 {
+		m["type"] = bson.M{"$in": q.Type}
+	}
+	if q.Exe != "" {
+		m["exe"] = utils.TransBackwardsRegex(q.Exe)
+	}
+	if q.Library != "" {
+		m["library"] = utils.TransBackwardsRegex(q.Library)
+	}
+}
+
+type DescribeHiddenProcessItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Type                 string `json:"type" bson:"type"`
+	Severity             string `json:"severity" bson:"severity"`
+	Pid                  string `json:"pid" bson:"pid"`
+	Exe                  string `json:"exe" bson:"exe"`
+	Comm                 string `json:"comm" bson:"comm"`
+	Cmdline              string `json:"cmdline" bson:"cmdline"`
+	FoundIn              string `json:"found_in" bson:"found_in"`
+	Missing              string `json:"missing" bson:"missing"`
+	Library              string `json:"library" bson:"library"`
+	Checksum             string `json:"checksum" bson:"checksum"`
+	ProcessCount         string `json:"process_count" bson:"process_count"`
+}
+
+func DescribeHiddenProcess(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeHiddenProcessReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintHiddenProcessCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeHiddenProcessItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeHiddenProcessItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+type DescribeFimEventReq struct {
+	BasicHostQuery
+	Event           []string `json:"event" binding:"omitempty,dive,oneof=create modify delete attrib"`
+	Rule            string   `json:"rule"`
+	Path            string   `json:"path"`
+	UpdateTimeStart *int64   `json:"update_time_start"`
+	UpdateTimeEnd   *int64   `json:"update_time_end"`
+}
+
+func (q *DescribeFimEventReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if len(q.Event) != 0 {
+		m["event"] = bson.M{"$in": q.Event}
+	}
+	if q.Rule != "" {
+		m["rule"] = q.Rule
+	}
+	if q.Path != "" {
+		m["path"] = utils.TransBackwardsRegex(q.Path)
+	}
+	if q.UpdateTimeStart != nil && q.UpdateTimeEnd != nil {
+		m["update_time"] = bson.M{
+			"$gte": *q.UpdateTimeStart,
+			"$lt":  *q.UpdateTimeEnd,
+		}
+	}
+}
+
+type DescribeFimEventItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Event                string `json:"event" bson:"event"`
+	Rule                 string `json:"rule" bson:"rule"`
+	Path                 string `json:"path" bson:"path"`
+	Source               string `json:"source" bson:"source"`
+	Size                 string `json:"size" bson:"size"`
+	OriginSize           string `json:"origin_size" bson:"origin_size"`
+	Digest               string `json:"digest" bson:"digest"`
+	OriginDigest         string `json:"origin_digest" bson:"origin_digest"`
+	Mode                 string `json:"mode" bson:"mode"`
+	OriginMode           string `json:"origin_mode" bson:"origin_mode"`
+	Owner                string `json:"owner" bson:"owner"`
+	OriginOwner          string `json:"origin_owner" bson:"origin_owner"`
+	ModifyTime           int64  `json:"modify_time" bson:"modify_time"`
+	Diff                 string `json:"diff" bson:"diff"`
+	DiffTruncated        string `json:"diff_truncated" bson:"diff_truncated"`
+}
+
+// DescribeFimEvent lists the file change history, the latest first by default.
+func DescribeFimEvent(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeFimEventReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintFimCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "update_time", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, -1, pq.OrderValue),
+		},
+	}
+	var data []DescribeFimEventItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeFimEventItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+type DescribeSecretReq struct {
+	BasicHostQuery
+	Rule        []string `json:"rule"`
+	Severity    []string `json:"severity" binding:"omitempty,dive,oneof=high medium low"`
+	Path        string   `json:"path"`
+	ContainerID string   `json:"container_id"`
+}
+
+func (q *DescribeSecretReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if len(q.Rule) != 0 {
+		m["rule"] = bson.M{"$in": q.Rule}
+	}
+	if len(q.Severity) != 0 {
+		m["severity"] = bson.M{"$in": q.Severity}
+	}
+	if q.Path != "" {
+		m["path"] = utils.TransBackwardsRegex(q.Path)
+	}
+	if q.ContainerID != "" {
+		m["container_id"] = q.ContainerID
+	}
+}
+
+type DescribeSecretItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Rule                 string `json:"rule" bson:"rule"`
+	Description          string `json:"description" bson:"description"`
+	Severity             string `json:"severity" bson:"severity"`
+	Path                 string `json:"path" bson:"path"`
+	Line                 string `json:"line" bson:"line"`
+	Match                string `json:"match" bson:"match"`
+	ModifyTime           int64  `json:"modify_time" bson:"modify_time"`
+	ContainerID          string `json:"container_id" bson:"container_id"`
+	ContainerName        string `json:"container_name" bson:"container_name"`
+}
+
+// DescribeSecret lists the masked secrets found in files of hosts and containers.
+func DescribeSecret(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribeSecretReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintSecretCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribeSecretItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribeSecretItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+	} else {
+		CreatePageResponse(c, common.SuccessCode, data, *resp)
+	}
+}
+
+type DescribePersistenceReq struct {
+	BasicHostQuery
+	Mechanism []string `json:"mechanism" binding:"omitempty,dive,oneof=systemd_timer at_job shell_profile rc_local udev_rule xdg_autostart"`
+	Command   string   `json:"command"`
+	Path      string   `json:"path"`
+	Owner     string   `json:"owner"`
+	Checksum  string   `json:"checksum"`
+	// only entries with commands, e.g. hooks of shell profiles
+	HasCommand bool `json:"has_command"`
+}
+
+func (q *DescribePersistenceReq) MarshalToBson(m bson.M) {
+	q.BasicHostQuery.MarshalToBson(m)
+	if len(q.Mechanism) != 0 {
+		m["mechanism"] = bson.M{"$in": q.Mechanism}
+	}
+	if q.Command != "" {
+		m["command"] = primitive.Regex{Pattern: regexp.QuoteMeta(q.Command), Options: "i"}
+	} else if q.HasCommand {
+		m["command"] = bson.M{"$nin": bson.A{"", nil}}
+	}
+	if q.Path != "" {
+		m["path"] = utils.TransBackwardsRegex(q.Path)
+	}
+	if q.Owner != "" {
+		m["owner"] = q.Owner
+	}
+	if q.Checksum != "" {
+		m["checksum"] = q.Checksum
+	}
+}
+
+type DescribePersistenceItem struct {
+	BasicHostInfo        `bson:",inline"`
+	BasicFingerprintInfo `bson:",inline"`
+	Mechanism            string `json:"mechanism" bson:"mechanism"`
+	Name                 string `json:"name" bson:"name"`
+	Trigger              string `json:"trigger" bson:"trigger"`
+	Command              string `json:"command" bson:"command"`
+	Path                 string `json:"path" bson:"path"`
+	Owner                string `json:"owner" bson:"owner"`
+	Checksum             string `json:"checksum" bson:"checksum"`
+	ModifyTime           int64  `json:"modify_time" bson:"modify_time"`
+}
+
+// DescribePersistence lists the persistence entries of all mechanisms, which
+// are systemd timers, at jobs, shell profiles, rc.local, udev rules and xdg autostart.
+func DescribePersistence(c *gin.Context) {
+	pq := &common.PageRequest{}
+	err := c.BindQuery(pq)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	qb := DescribePersistenceReq{}
+	err = c.Bind(&qb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	f := bson.M{}
+	qb.MarshalToBson(f)
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintPersistenceCollection)
+	preq := common.PageSearch{
+		Page:     utils.Ternary(pq.Page == 0, common.DefaultPage, pq.Page),
+		PageSize: utils.Ternary(pq.PageSize == 0, common.DefaultPageSize, pq.PageSize),
+		Filter:   f,
+		Sorter: bson.M{
+			utils.Ternary(pq.OrderKey == "", "_id", pq.OrderKey): utils.Ternary(pq.OrderValue == 0, 1, pq.OrderValue),
+		},
+	}
+	var data []DescribePersistenceItem
+	resp, err := common.DBSearchPaginate(collection, preq, func(c *mongo.Cursor) (err error) {
+		p := DescribePersistenceItem{}
+		err = c.Decode(&p)
+		if err == nil {
+			data = append(data, p)
+		}
+		return
+	})
 	if err != nil {
 		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
 	} else {
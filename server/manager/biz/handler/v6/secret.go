@@ -0,0 +1,131 @@
+package v6
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collector task data type which configures the secret handler
+const secretDataType = 5068
+
+// SecretConfig adds dirs to the built-in ones (home dirs and web roots), and
+// sets the io budget of a scan in bytes per second.
+type SecretConfig struct {
+	Paths            []string `json:"paths" bson:"paths"`
+	DisableContainer bool     `json:"disable_container" bson:"disable_container"`
+	Rate             int64    `json:"rate,omitempty" bson:"rate,omitempty"`
+	MaxFiles         int      `json:"max_files,omitempty" bson:"max_files,omitempty"`
+}
+
+func (cfg *SecretConfig) validate() error {
+	for _, p := range cfg.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("path %q isn't absolute", p)
+		}
+	}
+	if cfg.Rate < 0 || cfg.MaxFiles < 0 {
+		return errors.New("negative rate or max_files")
+	}
+	return nil
+}
+
+// SecretConfigItem is the secret config of a host group, i.e. an agent tag
+type SecretConfigItem struct {
+	Tag        string       `json:"tag" bson:"tag"`
+	Config     SecretConfig `json:"config" bson:"config"`
+	TaskID     string       `json:"task_id" bson:"task_id"`
+	User       string       `json:"user" bson:"user"`
+	UpdateTime int64        `json:"update_time" bson:"update_time"`
+}
+
+func DescribeSecretConfig(c *gin.Context) {
+	filter := bson.M{}
+	if tag, ok := c.GetQuery("tag"); ok {
+		filter["tag"] = tag
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.SecretConfigCollection)
+	cur, err := collection.Find(c, filter)
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	data := []SecretConfigItem{}
+	if err = cur.All(c, &data); err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, data)
+}
+
+type UpdateSecretConfigReqBody struct {
+	Tag    string       `json:"tag" binding:"required"`
+	Config SecretConfig `json:"config"`
+}
+
+// UpdateSecretConfig saves the secret config of a host group and pushes it to the online agents of the group.
+func UpdateSecretConfig(c *gin.Context) {
+	rb := &UpdateSecretConfigReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	if err = rb.Config.validate(); err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	userName := c.GetString("user")
+	taskID, err := pushCollectorTask(rb.Tag, userName, secretDataType, &rb.Config)
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	item := SecretConfigItem{
+		Tag:        rb.Tag,
+		Config:     rb.Config,
+		TaskID:     taskID,
+		User:       userName,
+		UpdateTime: time.Now().Unix(),
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.SecretConfigCollection)
+	_, err = collection.UpdateOne(c, bson.M{"tag": rb.Tag}, bson.M{"$set": item}, (&options.UpdateOptions{}).SetUpsert(true))
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, taskID)
+}
+
+type DeleteSecretConfigReqBody struct {
+	Tag string `json:"tag" binding:"required"`
+}
+
+// DeleteSecretConfig removes the secret config of a host group, agents of the group fall back to the defaults.
+func DeleteSecretConfig(c *gin.Context) {
+	rb := &DeleteSecretConfigReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	taskID, err := pushCollectorTask(rb.Tag, c.GetString("user"), secretDataType, &SecretConfig{})
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.SecretConfigCollection)
+	_, err = collection.DeleteOne(c, bson.M{"tag": rb.Tag})
+	if err != nil {
+		common.CreateResponse(c, common.DBOperateErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, taskID)
+}
@@ -0,0 +1,89 @@
+package v6
+
+import (
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/biz/common"
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/internal/asset_center"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collector task data type of the user handler, the data sets its weak password dictionary
+const weakPassDictDataType = 5052
+
+// WeakPassDictConfig is the same as WeakPasswordConfig of the user handler.
+type WeakPassDictConfig struct {
+	Dictionary []string `json:"dictionary"`
+	Append     bool     `json:"append"`
+	CPUBudget  int      `json:"cpu_budget"`
+}
+
+type PushWeakPassDictReqBody struct {
+	Tag       string   `json:"tag"`
+	IDList    []string `json:"id_list"`
+	IfAllHost bool     `json:"if_all_host"`
+	WeakPassDictConfig
+}
+
+// PushWeakPassDict pushes the weak password dictionary to the agents of a host group,
+// the listed agents or all online agents.
+func PushWeakPassDict(c *gin.Context) {
+	rb := &PushWeakPassDictReqBody{}
+	err := c.BindJSON(rb)
+	if err != nil {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, err.Error())
+		return
+	}
+	if len(rb.Dictionary) == 0 {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "dictionary is empty")
+		return
+	}
+	if rb.CPUBudget < 0 {
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "negative cpu_budget")
+		return
+	}
+	userName := c.GetString("user")
+	var taskID string
+	switch {
+	case rb.Tag != "":
+		taskID, err = pushCollectorTask(rb.Tag, userName, weakPassDictDataType, &rb.WeakPassDictConfig)
+	case rb.IfAllHost:
+		var ids []string
+		if ids, err = onlineAgents(c); err == nil {
+			taskID, err = pushCollectorTaskToAgents(ids, userName, weakPassDictDataType, &rb.WeakPassDictConfig)
+		}
+	case len(rb.IDList) != 0:
+		taskID, err = pushCollectorTaskToAgents(rb.IDList, userName, weakPassDictDataType, &rb.WeakPassDictConfig)
+	default:
+		common.CreateResponse(c, common.ParamInvalidErrorCode, "tag, id_list or if_all_host is required")
+		return
+	}
+	if err != nil {
+		common.CreateResponse(c, common.UnknownErrorCode, err.Error())
+		return
+	}
+	common.CreateResponse(c, common.SuccessCode, taskID)
+}
+
+func onlineAgents(c *gin.Context) ([]string, error) {
+	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentHeartBeatCollection)
+	cur, err := collection.Find(c, bson.M{"last_heartbeat_time": bson.M{"$gte": time.Now().Unix() - asset_center.DEFAULT_OFFLINE_DURATION}},
+		options.Find().SetProjection(bson.M{"agent_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	agents := []struct {
+		AgentID string `bson:"agent_id"`
+	}{}
+	if err = cur.All(c, &agents); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(agents))
+	for _, a := range agents {
+		ids = append(ids, a.AgentID)
+	}
+	return ids, nil
+}
@@ -166,6 +166,10 @@ func RegisterRouter(r *gin.Engine) {
 		innerGroup.Use(midware.AKSKAuth())
 		{
 			innerGroup.POST("/sync", v0.Sync)
+			innerGroup.POST("/agent/cert/enroll", v0.EnrollAgentCert)
+			innerGroup.GET("/agent/cert/crl", v0.GetAgentCrl)
+			innerGroup.POST("/agent/report/connAuth", v0.ConnAuth)
+			innerGroup.POST("/agent/report/connAuth/batch", v0.ConnAuthBatch)
 		}
 
 		//for task check
@@ -268,6 +272,8 @@ func RegisterRouter(r *gin.Engine) {
 			agentRouter.POST("/getTaskList", v6.GetTaskList)
 			agentRouter.POST("/getSubTaskList", v6.GetSubTaskList)
 			agentRouter.POST("/GetErrorHostNum", v6.GetErrorHostNum)
+			agentRouter.POST("/cert/List", v6.GetAgentCertList)
+			agentRouter.POST("/cert/Revoke", v6.RevokeAgentCert)
 			//agentRouter.POST("/PushAntiRansomStat", v6.PushAntiRansomStat)
 		}
 		assetCenter := apiv6Group.Group("/asset-center")
@@ -298,6 +304,13 @@ func RegisterRouter(r *gin.Engine) {
 				fingerprint.POST("/DescribeVolume", v6.DescribeVolume)
 				fingerprint.POST("/DescribeNetInterface", v6.DescribeNetInterface)
 				fingerprint.POST("/DescribeKmod", v6.DescribeKmod)
+				fingerprint.POST("/DescribeUserAccess", v6.DescribeUserAccess)
+				fingerprint.POST("/DescribeImageSoftware", v6.DescribeImageSoftware)
+				fingerprint.POST("/DescribeConnection", v6.DescribeConnection)
+				fingerprint.POST("/DescribeHiddenProcess", v6.DescribeHiddenProcess)
+				fingerprint.POST("/DescribeFimEvent", v6.DescribeFimEvent)
+				fingerprint.POST("/DescribeSecret", v6.DescribeSecret)
+				fingerprint.POST("/DescribePersistence", v6.DescribePersistence)
 				fingerprint.POST("/ExportData", v6.ExportData)
 				fingerprint.POST("/RefreshData", v6.RefreshData)
 				fingerprint.GET("/DescribeRefreshStatus", v6.DescribeRefreshStatus)
@@ -306,8 +319,20 @@ func RegisterRouter(r *gin.Engine) {
 				fingerprint.GET("/DescribeContainerStateStatistics", v6.DescribeContainerStateStatistics)
 				fingerprint.POST("/DescribeContainer", v6.DescribeContainer)
 				fingerprint.GET("/DescribeContainerDetail", v6.DescribeContainerDetail)
+				fingerprint.POST("/DescribeContainerRisk", v6.DescribeContainerRisk)
 				fingerprint.GET("/DescribeAppGroup", v6.DescribeAppGroup)
 				fingerprint.POST("/DescribeApp", v6.DescribeApp)
+				fingerprint.POST("/DescribeAppRisk", v6.DescribeAppRisk)
+				fingerprint.GET("/DescribeCollectorPolicy", v6.DescribeCollectorPolicy)
+				fingerprint.POST("/UpdateCollectorPolicy", v6.UpdateCollectorPolicy)
+				fingerprint.POST("/DeleteCollectorPolicy", v6.DeleteCollectorPolicy)
+				fingerprint.GET("/DescribeFimRule", v6.DescribeFimRule)
+				fingerprint.POST("/UpdateFimRule", v6.UpdateFimRule)
+				fingerprint.POST("/DeleteFimRule", v6.DeleteFimRule)
+				fingerprint.GET("/DescribeSecretConfig", v6.DescribeSecretConfig)
+				fingerprint.POST("/UpdateSecretConfig", v6.UpdateSecretConfig)
+				fingerprint.POST("/DeleteSecretConfig", v6.DeleteSecretConfig)
+				fingerprint.POST("/PushWeakPassDict", v6.PushWeakPassDict)
 			}
 		}
 
@@ -397,6 +422,26 @@ func RegisterRouter(r *gin.Engine) {
 			baselineRouter.POST("/GetBaselineCheckList", v6.GetBaselineCheckList)
 			baselineRouter.POST("/GetCheckHostList", v6.GetCheckHostList)
 
+			// 自定义基线
+			baselineRouter.POST("/CustomBaseline/Validate", v6.ValidateCustomBaseline)
+			baselineRouter.POST("/CustomBaseline/Save", v6.SaveCustomBaseline)
+			baselineRouter.GET("/CustomBaseline/List", v6.GetCustomBaselineList)
+			baselineRouter.POST("/CustomBaseline/Detail", v6.GetCustomBaselineDetail)
+			baselineRouter.POST("/CustomBaseline/Revisions", v6.GetCustomBaselineRevisions)
+			baselineRouter.POST("/CustomBaseline/Publish", v6.PublishCustomBaseline)
+			baselineRouter.POST("/CustomBaseline/DryRun", v6.DryRunCustomBaseline)
+			baselineRouter.POST("/CustomBaseline/DryRunResult", v6.GetDryRunResult)
+			baselineRouter.POST("/Remediation/Request", v6.RequestRemediation)
+			baselineRouter.POST("/Remediation/Approve", v6.ApproveRemediation)
+			baselineRouter.POST("/Remediation/List", v6.GetRemediationList)
+			baselineRouter.POST("/Remediation/Detail", v6.GetRemediationDetail)
+			baselineRouter.POST("/Remediation/Audit", v6.GetRemediationAudit)
+			baselineRouter.GET("/Compliance/FrameworkList", v6.GetComplianceFrameworkList)
+			baselineRouter.POST("/Compliance/Score", v6.GetComplianceScore)
+			baselineRouter.POST("/Compliance/ScoreList", v6.GetComplianceScoreList)
+			baselineRouter.POST("/Compliance/ScoreTrend", v6.GetComplianceScoreTrend)
+			baselineRouter.POST("/Compliance/Report", v6.ExportComplianceReport)
+
 		}
 		// 系统告警
 		systemRouter := apiv6Group.Group("/systemRouter")
@@ -18,6 +18,7 @@ const (
 	AgentHeartBeatCollection = "agent_heartbeat"
 	AgentTaskCollection      = "agent_task"
 	AgentSubTaskCollection   = "agent_subtask"
+	AgentCertCollection      = "agent_cert"
 	FileInfoCollection       = "file_upload"
 
 	AgentConfigTemplate       = "agent_config_template"
@@ -46,6 +47,12 @@ const (
 	BaselineTaskStatus    = "baseline_task_status"
 	BaselineCheckInfoColl = "baseline_check_info"
 	BaselineGroupInfo     = "baseline_group_info"
+	BaselinePackColl      = "baseline_pack"
+	BaselineCustomColl    = "baseline_custom"
+
+	BaselineRemediationColl      = "baseline_remediation"
+	BaselineRemediationAuditColl = "baseline_remediation_audit"
+	BaselineScoreColl            = "baseline_score_snapshot"
 
 	FingerprintRaspCollection = "agent_asset_2997"
 
@@ -60,8 +67,17 @@ const (
 	FingerprintVolumeCollection       = "agent_asset_5058"
 	FingerprintNetInterfaceCollection = "agent_asset_5059"
 	FingerprintAppCollection          = "agent_asset_5060"
-
-	FingerprintKmodCollection = "agent_asset_5062"
+	FingerprintAppRiskCollection      = "agent_asset_5061"
+
+	FingerprintKmodCollection          = "agent_asset_5062"
+	FingerprintUserAccessCollection    = "agent_asset_5063"
+	FingerprintImageSoftwareCollection = "agent_asset_5064"
+	FingerprintConnectionCollection    = "agent_asset_5065"
+	FingerprintHiddenProcessCollection = "agent_asset_5066"
+	FingerprintFimCollection           = "agent_asset_5067"
+	FingerprintSecretCollection        = "agent_asset_5068"
+	FingerprintPersistenceCollection   = "agent_asset_5069"
+	FingerprintContainerRiskCollection = "agent_asset_5070"
 
 	CronjobCollection = "cronjob"
 
@@ -110,6 +126,9 @@ const (
 
 	AgentContainerInfoCollection     = "agent_asset_5056"
 	FingerPrintRefreshTaskCollection = "fp_refresh_task"
+	CollectorPolicyCollection        = "collector_policy"
+	FimRuleCollection                = "fim_rule"
+	SecretConfigCollection           = "secret_config"
 )
 
 var (
@@ -66,7 +66,10 @@ func Initialize() error {
 	metrics.Init()
 
 	login.Init()
-	baseline.InitBaseline()
+	if err = baseline.InitBaseline(); err != nil {
+		fmt.Println("INIT_BASELINE_ERROR", err.Error())
+		return err
+	}
 	vuln.InitVuln()
 	rasp.RaspInit()
 	container.ContainerInit()
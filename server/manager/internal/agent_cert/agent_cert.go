@@ -0,0 +1,241 @@
+package agent_cert
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/kube"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// 与agent_center校验的OU一致，带此OU的证书CN必须与上报的AgentID相同
+	AgentCertOU = "elkeid-agent"
+	// 证书有效期，agent在剩余1/3有效期时轮换
+	agentCertDuration = 90 * 24 * time.Hour
+	// 吊销列表缓存及有效时间
+	crlCacheTime  = time.Minute
+	crlNextUpdate = time.Hour
+
+	CertStatusActive  = "active"
+	CertStatusRevoked = "revoked"
+
+	RevokeReasonSuperseded = "superseded"
+)
+
+// 签发给agent的证书
+type AgentCert struct {
+	AgentId      string `json:"agent_id" bson:"agent_id"`
+	Serial       string `json:"serial" bson:"serial"` // 十六进制序列号
+	Cert         string `json:"cert" bson:"cert"`
+	Status       string `json:"status" bson:"status"`
+	NotBefore    int64  `json:"not_before" bson:"not_before"`
+	NotAfter     int64  `json:"not_after" bson:"not_after"`
+	RevokeReason string `json:"revoke_reason" bson:"revoke_reason"`
+	RevokeTime   int64  `json:"revoke_time" bson:"revoke_time"`
+	Operator     string `json:"operator" bson:"operator"`
+	CreateTime   int64  `json:"create_time" bson:"create_time"`
+	UpdateTime   int64  `json:"update_time" bson:"update_time"`
+}
+
+var (
+	ErrEnrollConflict = errors.New("agent already has an active certificate, revoke it first")
+	ErrCertNotFind    = errors.New("certificate not find")
+
+	crlLock    sync.Mutex
+	crlCache   []byte
+	crlCacheAt time.Time
+
+	enrolledLock    sync.Mutex
+	enrolledCache   []string
+	enrolledCacheAt time.Time
+)
+
+func certCol() *mongo.Collection {
+	return infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentCertCollection)
+}
+
+// 获取agent当前有效的证书，过期的证书视为无效
+func getActiveCert(ctx context.Context, agentId string) (*AgentCert, error) {
+	var cert AgentCert
+	err := certCol().FindOne(ctx, bson.M{"agent_id": agentId, "status": CertStatusActive,
+		"not_after": bson.M{"$gt": time.Now().Unix()}}).Decode(&cert)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// Enroll 为agent签发证书，peerSerial为agent当前连接使用的证书序列号
+// agent已有有效证书时，只允许持有该证书的连接轮换，否则需要先吊销
+func Enroll(agentId, csr, peerSerial string) (string, error) {
+	if agentId == "" {
+		return "", errors.New("agent id is empty")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// 同一agent的签发串行执行
+	lock := "agent_cert_enroll_" + agentId
+	ok, err := infra.Grds.SetNX(ctx, lock, 1, time.Minute).Result()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errors.New("enrollment of the agent is in progress")
+	}
+	defer infra.Grds.Del(context.Background(), lock)
+
+	old, err := getActiveCert(ctx, agentId)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	if old != nil && old.Serial != peerSerial {
+		return "", ErrEnrollConflict
+	}
+
+	subject := pkix.Name{
+		Organization:       []string{"Elkeid"},
+		OrganizationalUnit: []string{AgentCertOU},
+		CommonName:         agentId,
+	}
+	certPem, serial, err := kube.SignCertRequest([]byte(csr), subject, agentCertDuration)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	cert := AgentCert{
+		AgentId:    agentId,
+		Serial:     serial.Text(16),
+		Cert:       string(certPem),
+		Status:     CertStatusActive,
+		NotBefore:  now.Add(-time.Hour).Unix(),
+		NotAfter:   now.Add(-time.Hour).Add(agentCertDuration).Unix(),
+		CreateTime: now.Unix(),
+		UpdateTime: now.Unix(),
+	}
+	if _, err = certCol().InsertOne(ctx, cert); err != nil {
+		return "", err
+	}
+	resetEnrolledCache()
+
+	// 轮换后旧证书作废
+	if old != nil {
+		if err = revoke(ctx, bson.M{"agent_id": agentId, "serial": old.Serial}, RevokeReasonSuperseded, agentId); err != nil {
+			ylog.Errorf("Enroll", "revoke superseded cert %s of %s error %s", old.Serial, agentId, err.Error())
+		}
+	}
+	ylog.Infof("Enroll", "issue cert %s to %s", cert.Serial, agentId)
+	return cert.Cert, nil
+}
+
+// Revoke 吊销agent的证书，serial为空时吊销该agent全部有效证书
+func Revoke(agentId, serial, reason, operator string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{"agent_id": agentId, "status": CertStatusActive}
+	if serial != "" {
+		filter["serial"] = serial
+	}
+	return revoke(ctx, filter, reason, operator)
+}
+
+func revoke(ctx context.Context, filter bson.M, reason, operator string) error {
+	now := time.Now().Unix()
+	res, err := certCol().UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"status": CertStatusRevoked, "revoke_reason": reason, "revoke_time": now,
+		"operator": operator, "update_time": now}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrCertNotFind
+	}
+	// 本机缓存立即失效，其他实例在缓存过期后更新
+	crlLock.Lock()
+	crlCache = nil
+	crlLock.Unlock()
+	resetEnrolledCache()
+	return nil
+}
+
+func resetEnrolledCache() {
+	enrolledLock.Lock()
+	enrolledCache = nil
+	enrolledLock.Unlock()
+}
+
+// GetEnrolledAgents 获取持有有效证书的agent id，agent_center拒绝这些agent使用共享证书连接
+func GetEnrolledAgents() ([]string, error) {
+	enrolledLock.Lock()
+	defer enrolledLock.Unlock()
+	if enrolledCache != nil && time.Since(enrolledCacheAt) < crlCacheTime {
+		return enrolledCache, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := certCol().Distinct(ctx, "agent_id", bson.M{"status": CertStatusActive, "not_after": bson.M{"$gt": time.Now().Unix()}})
+	if err != nil {
+		return nil, err
+	}
+	agentList := make([]string, 0, len(res))
+	for _, v := range res {
+		if agentId, ok := v.(string); ok {
+			agentList = append(agentList, agentId)
+		}
+	}
+	enrolledCache, enrolledCacheAt = agentList, time.Now()
+	return agentList, nil
+}
+
+// GetCrl 获取吊销列表，只包含未过期的吊销证书
+func GetCrl() ([]byte, error) {
+	crlLock.Lock()
+	defer crlLock.Unlock()
+	if crlCache != nil && time.Since(crlCacheAt) < crlCacheTime {
+		return crlCache, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cur, err := certCol().Find(ctx, bson.M{"status": CertStatusRevoked, "not_after": bson.M{"$gt": time.Now().Unix()}},
+		options.Find().SetProjection(bson.M{"serial": 1, "revoke_time": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	revoked := make([]pkix.RevokedCertificate, 0)
+	for cur.Next(ctx) {
+		var cert AgentCert
+		if err := cur.Decode(&cert); err != nil {
+			ylog.Errorf("GetCrl", err.Error())
+			continue
+		}
+		serial, ok := new(big.Int).SetString(cert.Serial, 16)
+		if !ok {
+			ylog.Errorf("GetCrl", "invalid serial %s", cert.Serial)
+			continue
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: time.Unix(cert.RevokeTime, 0)})
+	}
+
+	now := time.Now()
+	crl, err := kube.CreateRevocationList(revoked, now.Unix(), crlNextUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("create crl error %s", err.Error())
+	}
+	crlCache, crlCacheAt = crl, now
+	return crl, nil
+}
@@ -36,13 +36,27 @@ type CheckInfo struct {
 	SolutionCn    string `yaml:"solution_cn" bson:"solution_cn" json:"solution_cn"`
 	UpdateTime    int64  `yaml:"update_time" bson:"update_time" json:"update_time"`
 
-	Result int    `json:"result" bson:"result"`
-	Msg    string `json:"msg" bson:"msg"`
+	// 检查项对应的合规框架条款，如cis_l1、pci_dss:8.3.6、mlps_2.0:8.1.4.1
+	Compliance []string `yaml:"compliance" bson:"compliance" json:"compliance"`
+
+	Result   int             `json:"result" bson:"result"`
+	Msg      string          `json:"msg" bson:"msg"`
+	Evidence []CheckEvidence `json:"evidence" bson:"evidence"`
 
 	PassRate int    `json:"pass_rate" bson:"pass_rate"`
 	Status   string `json:"status" bson:"status"`
 }
 
+// 检查项证据，插件记录的每条规则的实际值
+type CheckEvidence struct {
+	Type   string      `json:"type" bson:"type"`
+	File   string      `json:"file" bson:"file"`
+	Line   string      `json:"line" bson:"line"`
+	Value  interface{} `json:"value" bson:"value"`
+	Expect string      `json:"expect" bson:"expect"`
+	Pass   bool        `json:"pass" bson:"pass"`
+}
+
 // 基线策略组状态
 type BaselineGroupStatus struct {
 	GroupId       int    `json:"group_id" bson:"group_id"`
@@ -104,6 +118,8 @@ type AgentBaselineInfo struct {
 	Tags            []string `json:"tags" bson:"tags"`
 	ExtranetIpv4    []string `json:"extranet_ipv4" bson:"extranet_ipv4"`
 	IntranetIpv4    []string `json:"intranet_ipv4" bson:"intranet_ipv4"`
+
+	Evidence []CheckEvidence `json:"evidence" bson:"evidence"`
 }
 
 const (
@@ -122,5 +138,5 @@ const (
 )
 
 var (
-	BaselineAllIdList = []int{1200, 1300, 1400, 2200, 2300, 2400, 3200, 3300, 3400, 5000, 6000}
+	BaselineAllIdList = []int{1200, 1300, 1400, 1500, 1600, 1700, 1800, 2200, 2300, 2400, 3200, 3300, 3400, 5000, 6000, 6100}
 )
@@ -9,12 +9,18 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/yaml.v2"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 )
 
 const (
-	baselineVersion    = "2.0.0.7"
+	baselineVersion    = "2.0.0.13"
 	BaselineTypeConfig = "baseline_config"
+
+	// 容器基线id起始值，容器基线单独成组
+	ContainerBaselineIdStart = 6000
+	ContainerGroupId         = 3
 )
 
 // 单个检查项信息
@@ -31,6 +37,9 @@ type CheckInfo_config struct {
 	DescriptionCn string `yaml:"description_cn" bson:"description_cn"`
 	SolutionCn    string `yaml:"solution_cn" bson:"solution_cn"`
 	UpdateTime    int64  `yaml:"update_time" bson:"update_time"`
+
+	Compliance  []string            `yaml:"compliance" bson:"compliance"`
+	Remediation []RemediationAction `yaml:"remediation" bson:"remediation"`
 }
 
 // 基线配置文件结构
@@ -73,26 +82,37 @@ func bindYaml(filePath string, yamlMap interface{}) error {
 	return err
 }
 
-// 将yaml文件信息入到mongo baseline_info表, check_info表
-func yaml2Mongo(yamlPath string) {
-	c := context.Background()
-	time64 := time.Now().Unix()
-
+// 将yaml文件信息入到mongo baseline_info表, check_info表，返回基线id，失败返回0
+func yaml2Mongo(yamlPath string) int {
 	// 读取并绑定配置文件
+	content, err := os.ReadFile(yamlPath)
+	if err != nil {
+		fmt.Println("读取yaml失败")
+		fmt.Println(err)
+		return 0
+	}
 	baselineInfo := new(BaselineInfo_config)
-	err := bindYaml(yamlPath, baselineInfo)
+	err = yaml.Unmarshal(content, baselineInfo)
 	if err != nil {
 		fmt.Println("绑定yaml失败")
 		fmt.Println(err)
-		return
+		return 0
 	}
+	baseline2Mongo(baselineInfo, content)
+	return baselineInfo.BaselineId
+}
+
+// 将基线信息入到mongo baseline_info表, check_info表，yaml内容保存为规则包
+func baseline2Mongo(baselineInfo *BaselineInfo_config, content []byte) {
+	c := context.Background()
+	time64 := time.Now().Unix()
 
 	// 删除历史数据
 	baselineInfoCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaseLineInfoColl)
 	baselineCheckCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineCheckInfoColl)
 	baselineStatusCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineStatus)
 
-	_, err = baselineInfoCol.DeleteMany(c, bson.M{"baseline_id": baselineInfo.BaselineId})
+	_, err := baselineInfoCol.DeleteMany(c, bson.M{"baseline_id": baselineInfo.BaselineId})
 	if err != nil {
 		ylog.Errorf("Delete error", err.Error())
 	}
@@ -145,7 +165,12 @@ func yaml2Mongo(yamlPath string) {
 	option = &options.UpdateOptions{}
 	option.SetUpsert(true)
 	_, err = baselineStatusCol.UpdateOne(c, filter, bson.M{"$set": baselineStatus}, option)
+	if err != nil {
+		ylog.Errorf("Update error", err.Error())
+	}
 
+	// 规则包随任务下发给插件
+	savePack(baselineInfo.BaselineId, baselineInfo.BaselineVersion, content)
 }
 
 // 新建默认策略组
@@ -168,6 +193,11 @@ func newGroup(baselineGroupMongo BaselineGroupMongo, baselineIdList []int) {
 		baselineGroupMongo.BaselineList = append(baselineGroupMongo.BaselineList, groupBaselineInfo)
 	}
 
+	saveGroup(baselineGroupMongo, baselineIdList)
+}
+
+// 保存策略组及其状态信息
+func saveGroup(baselineGroupMongo BaselineGroupMongo, baselineIdList []int) {
 	// 存入mongo
 	c := context.Background()
 	collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineGroupInfo)
@@ -243,11 +273,22 @@ func ChangeBaselineDB() {
 	}
 
 	fmt.Println("开始基线表初始化")
-	// toB
-	yaml2Mongo("conf/baseline_config/1200.yaml")
-	yaml2Mongo("conf/baseline_config/1300.yaml")
-	yaml2Mongo("conf/baseline_config/1400.yaml")
-	yaml2Mongo("conf/baseline_config/5000.yaml")
+	// toB，加载配置目录下的全部基线
+	yamlList, _ := filepath.Glob("conf/baseline_config/*.yaml")
+	sort.Strings(yamlList)
+	baselineIdList1 := make([]int, 0, len(yamlList))
+	containerIdList := make([]int, 0)
+	for _, yamlPath := range yamlList {
+		baselineId := yaml2Mongo(yamlPath)
+		if baselineId == 0 {
+			continue
+		}
+		if baselineId >= ContainerBaselineIdStart {
+			containerIdList = append(containerIdList, baselineId)
+		} else {
+			baselineIdList1 = append(baselineIdList1, baselineId)
+		}
+	}
 
 	fmt.Println("开始初始化策略组")
 
@@ -261,8 +302,15 @@ func ChangeBaselineDB() {
 	baselineGroupMongo.GroupId = 1
 	baselineGroupMongo.GroupName = "linux字节跳动最佳实践扫描策略"
 	baselineGroupMongo.GroupNameEn = "default linux policy"
-	baselineIdList1 := [...]int{1200, 1300, 1400, 5000}
-	newGroup(baselineGroupMongo, baselineIdList1[:])
+	newGroup(baselineGroupMongo, baselineIdList1)
+
+	if len(containerIdList) != 0 {
+		var containerGroupMongo BaselineGroupMongo
+		containerGroupMongo.GroupId = ContainerGroupId
+		containerGroupMongo.GroupName = "容器安全基线扫描策略"
+		containerGroupMongo.GroupNameEn = "default container policy"
+		newGroup(containerGroupMongo, containerIdList)
+	}
 
 	// 将基线版本写入数据库
 	vulnConfCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.VulnConfig)
@@ -1,9 +1,15 @@
 package baseline
 
-func InitBaseline() {
+func InitBaseline() error {
+	if err := InitPackKey(); err != nil {
+		return err
+	}
 	go SetBaselineCheckTask(0, "crontab")
 	go judgeTaskTimeout("crontab_back")
 	go judgeTaskTimeout("once")
 	go calcuBaselineStatistic()
+	go SetScoreSnapshot("crontab")
+	go SetScoreSnapshot("once")
 	go ChangeBaselineDB()
+	return nil
 }
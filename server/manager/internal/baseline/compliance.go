@@ -0,0 +1,494 @@
+package baseline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	FrameworkCisL1  = "cis_l1"
+	FrameworkCisL2  = "cis_l2"
+	FrameworkPciDss = "pci_dss"
+	FrameworkMlps   = "mlps_2.0"
+
+	// 得分范围，主机、主机标签及全部主机
+	ScopeHost  = "host"
+	ScopeTag   = "tag"
+	ScopeFleet = "fleet"
+
+	// 每日快照保留天数
+	scoreSnapshotRetainDays = 365
+	scoreSnapshotDateLayout = "2006-01-02"
+)
+
+// 合规框架
+type Framework struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	NameEn string `json:"name_en"`
+	// 同时包含其他框架的检查项，如CIS level 2包含level 1
+	Includes []string `json:"includes"`
+}
+
+var FrameworkList = []Framework{
+	{Id: FrameworkCisL1, Name: "CIS基准 Level 1", NameEn: "CIS Benchmark Level 1"},
+	{Id: FrameworkCisL2, Name: "CIS基准 Level 2", NameEn: "CIS Benchmark Level 2", Includes: []string{FrameworkCisL1}},
+	{Id: FrameworkPciDss, Name: "PCI-DSS v4.0", NameEn: "PCI-DSS v4.0"},
+	{Id: FrameworkMlps, Name: "网络安全等级保护2.0 第三级", NameEn: "MLPS 2.0 Level 3"},
+}
+
+// 检查项等级对应的权重
+var securityWeight = map[string]float64{BaselineCheckHigh: 10, BaselineCheckMid: 5, BaselineCheckLow: 1}
+
+var complianceControlReg = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.\-]*$`)
+
+// GetFramework 根据id获取合规框架
+func GetFramework(id string) (Framework, bool) {
+	for _, framework := range FrameworkList {
+		if framework.Id == id {
+			return framework, true
+		}
+	}
+	return Framework{}, false
+}
+
+// 解析合规条款，格式为framework或framework:control
+func parseCompliance(item string) (framework, control string) {
+	framework, control, _ = strings.Cut(item, ":")
+	return
+}
+
+// 校验合规条款，返回错误信息
+func validateCompliance(item string) string {
+	framework, control := parseCompliance(item)
+	if _, ok := GetFramework(framework); !ok {
+		return fmt.Sprintf("unsupported compliance framework %s", framework)
+	}
+	if strings.Contains(item, ":") && !complianceControlReg.MatchString(control) {
+		return fmt.Sprintf("invalid compliance control %s", item)
+	}
+	return ""
+}
+
+// 检查项所属的框架及条款，包含其他框架的框架沿用被包含框架的条款
+func checkFrameworks(compliance []string) map[string]string {
+	res := make(map[string]string)
+	for _, item := range compliance {
+		framework, control := parseCompliance(item)
+		if _, ok := res[framework]; !ok {
+			res[framework] = control
+		}
+	}
+	for _, framework := range FrameworkList {
+		if _, ok := res[framework.Id]; ok {
+			continue
+		}
+		for _, include := range framework.Includes {
+			if control, ok := res[include]; ok {
+				res[framework.Id] = control
+				break
+			}
+		}
+	}
+	return res
+}
+
+// 加权得分，加白的未通过项按通过计算，检查出错的不计入
+type scoreCounter struct {
+	Weight     float64
+	PassWeight float64
+	PassNum    int64
+	RiskNum    int64
+	WhiteNum   int64
+}
+
+func (s *scoreCounter) add(security, status string, ifWhite bool) {
+	weight, ok := securityWeight[security]
+	if !ok {
+		weight = securityWeight[BaselineCheckLow]
+	}
+	switch {
+	case status == StatusSuccess:
+		s.PassNum++
+		s.PassWeight += weight
+	case status == StatusFailed && ifWhite:
+		s.WhiteNum++
+		s.PassWeight += weight
+	case status == StatusFailed:
+		s.RiskNum++
+	default:
+		return
+	}
+	s.Weight += weight
+}
+
+func (s *scoreCounter) score() float64 {
+	if s.Weight == 0 {
+		return 100
+	}
+	return math.Round(s.PassWeight*1000/s.Weight) / 10
+}
+
+// 合规得分
+type ComplianceScore struct {
+	Date       string  `json:"date" bson:"date"`
+	Scope      string  `json:"scope" bson:"scope"`
+	ScopeId    string  `json:"scope_id" bson:"scope_id"`
+	Hostname   string  `json:"hostname" bson:"hostname"`
+	Framework  string  `json:"framework" bson:"framework"`
+	Score      float64 `json:"score" bson:"score"`
+	HostNum    int64   `json:"host_num" bson:"host_num"`
+	PassNum    int64   `json:"pass_num" bson:"pass_num"`
+	RiskNum    int64   `json:"risk_num" bson:"risk_num"`
+	WhiteNum   int64   `json:"white_num" bson:"white_num"`
+	CreateTime int64   `json:"create_time" bson:"create_time"`
+}
+
+type checkKey struct {
+	BaselineId int64
+	CheckId    int64
+}
+
+type hostCounter struct {
+	AgentId  string
+	Hostname string
+	Tags     []string
+	// framework为空时为全部检查项
+	Counters map[string]*scoreCounter
+}
+
+// 检查项在全部主机上的结果
+type checkCounter struct {
+	PassNum  int64
+	RiskNum  int64
+	WhiteNum int64
+}
+
+// 合规得分计算，主机得分为检查项的加权通过率，标签及全部主机得分为主机得分的平均值
+type complianceCalc struct {
+	checks     map[checkKey]CheckInfo
+	frameworks map[checkKey]map[string]string
+	hosts      map[string]*hostCounter
+	checkRes   map[checkKey]*checkCounter
+	exceptions []AgentBaselineInfo
+}
+
+func newComplianceCalc(checks map[checkKey]CheckInfo) *complianceCalc {
+	calc := &complianceCalc{
+		checks:     checks,
+		frameworks: make(map[checkKey]map[string]string, len(checks)),
+		hosts:      make(map[string]*hostCounter),
+		checkRes:   make(map[checkKey]*checkCounter),
+	}
+	for key, checkInfo := range checks {
+		calc.frameworks[key] = checkFrameworks(checkInfo.Compliance)
+	}
+	return calc
+}
+
+func (calc *complianceCalc) add(item *AgentBaselineInfo) {
+	key := checkKey{BaselineId: item.BaselineId, CheckId: item.CheckId}
+	host, ok := calc.hosts[item.AgentId]
+	if !ok {
+		host = &hostCounter{AgentId: item.AgentId, Counters: make(map[string]*scoreCounter)}
+		calc.hosts[item.AgentId] = host
+	}
+	host.Hostname = item.Hostname
+	host.Tags = item.Tags
+
+	security := item.CheckLevel
+	if checkInfo, ok := calc.checks[key]; ok && checkInfo.Security != "" {
+		security = checkInfo.Security
+	}
+	frameworkList := []string{""}
+	for framework := range calc.frameworks[key] {
+		frameworkList = append(frameworkList, framework)
+	}
+	for _, framework := range frameworkList {
+		counter, ok := host.Counters[framework]
+		if !ok {
+			counter = &scoreCounter{}
+			host.Counters[framework] = counter
+		}
+		counter.add(security, item.Status, item.IfWhite)
+	}
+
+	res, ok := calc.checkRes[key]
+	if !ok {
+		res = &checkCounter{}
+		calc.checkRes[key] = res
+	}
+	switch {
+	case item.Status == StatusSuccess:
+		res.PassNum++
+	case item.Status == StatusFailed && item.IfWhite:
+		res.WhiteNum++
+		calc.exceptions = append(calc.exceptions, *item)
+	case item.Status == StatusFailed:
+		res.RiskNum++
+	}
+}
+
+// 主机得分，主机没有该框架的检查结果时返回false
+func (calc *complianceCalc) hostScore(host *hostCounter, framework string) (ComplianceScore, bool) {
+	counter, ok := host.Counters[framework]
+	if !ok || counter.Weight == 0 {
+		return ComplianceScore{}, false
+	}
+	return ComplianceScore{
+		Scope:     ScopeHost,
+		ScopeId:   host.AgentId,
+		Hostname:  host.Hostname,
+		Framework: framework,
+		Score:     counter.score(),
+		HostNum:   1,
+		PassNum:   counter.PassNum,
+		RiskNum:   counter.RiskNum,
+		WhiteNum:  counter.WhiteNum,
+	}, true
+}
+
+// 多台主机的平均得分
+func meanScore(scope, scopeId, framework string, hostScores []ComplianceScore) ComplianceScore {
+	res := ComplianceScore{Scope: scope, ScopeId: scopeId, Framework: framework, Score: 100}
+	if len(hostScores) == 0 {
+		return res
+	}
+	var total float64
+	for _, hostScore := range hostScores {
+		total += hostScore.Score
+		res.PassNum += hostScore.PassNum
+		res.RiskNum += hostScore.RiskNum
+		res.WhiteNum += hostScore.WhiteNum
+	}
+	res.HostNum = int64(len(hostScores))
+	res.Score = math.Round(total*10/float64(len(hostScores))) / 10
+	return res
+}
+
+// 计算全部范围的得分，framework为空时为全部检查项
+func (calc *complianceCalc) scores() []ComplianceScore {
+	frameworkList := []string{""}
+	for _, framework := range FrameworkList {
+		frameworkList = append(frameworkList, framework.Id)
+	}
+	agentIdList := make([]string, 0, len(calc.hosts))
+	for agentId := range calc.hosts {
+		agentIdList = append(agentIdList, agentId)
+	}
+	sort.Strings(agentIdList)
+
+	res := make([]ComplianceScore, 0)
+	for _, framework := range frameworkList {
+		fleet := make([]ComplianceScore, 0, len(agentIdList))
+		tagScores := make(map[string][]ComplianceScore)
+		for _, agentId := range agentIdList {
+			host := calc.hosts[agentId]
+			hostScore, ok := calc.hostScore(host, framework)
+			if !ok {
+				continue
+			}
+			res = append(res, hostScore)
+			fleet = append(fleet, hostScore)
+			for _, tag := range host.Tags {
+				tagScores[tag] = append(tagScores[tag], hostScore)
+			}
+		}
+		tagList := make([]string, 0, len(tagScores))
+		for tag := range tagScores {
+			tagList = append(tagList, tag)
+		}
+		sort.Strings(tagList)
+		for _, tag := range tagList {
+			res = append(res, meanScore(ScopeTag, tag, framework, tagScores[tag]))
+		}
+		if len(fleet) != 0 {
+			res = append(res, meanScore(ScopeFleet, "", framework, fleet))
+		}
+	}
+	return res
+}
+
+// 读取检查项信息及合规条款
+func loadComplianceChecks(c context.Context) (map[checkKey]CheckInfo, error) {
+	checkInfoCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineCheckInfoColl)
+	findOption := options.Find().SetProjection(bson.M{
+		"baseline_id": 1, "check_id": 1, "title": 1, "title_cn": 1, "security": 1, "compliance": 1,
+	})
+	cur, err := checkInfoCol.Find(c, bson.M{}, findOption)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c)
+	checks := make(map[checkKey]CheckInfo)
+	for cur.Next(c) {
+		var checkInfo CheckInfo
+		if err := cur.Decode(&checkInfo); err != nil {
+			ylog.Errorf("loadComplianceChecks", err.Error())
+			continue
+		}
+		checks[checkKey{BaselineId: int64(checkInfo.BaselineId), CheckId: int64(checkInfo.CheckId)}] = checkInfo
+	}
+	return checks, nil
+}
+
+// 按筛选条件计算基线检查结果的合规得分
+func calcuCompliance(c context.Context, filter bson.M) (*complianceCalc, error) {
+	checks, err := loadComplianceChecks(c)
+	if err != nil {
+		return nil, err
+	}
+	calc := newComplianceCalc(checks)
+
+	agentBaseCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentBaselineColl)
+	findOption := options.Find().SetProjection(bson.M{
+		"agent_id": 1, "hostname": 1, "tags": 1, "baseline_id": 1, "check_id": 1,
+		"check_name": 1, "check_name_cn": 1, "check_level": 1, "status": 1, "if_white": 1, "white_reason": 1,
+	})
+	cur, err := agentBaseCol.Find(c, filter, findOption)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c)
+	for cur.Next(c) {
+		var item AgentBaselineInfo
+		if err := cur.Decode(&item); err != nil {
+			ylog.Errorf("calcuCompliance", err.Error())
+			continue
+		}
+		calc.add(&item)
+	}
+	return calc, nil
+}
+
+// 保存当天的合规得分快照，并删除过期的快照
+func saveScoreSnapshot() {
+	c := context.Background()
+	calc, err := calcuCompliance(c, bson.M{})
+	if err != nil {
+		ylog.Errorf("saveScoreSnapshot", err.Error())
+		return
+	}
+	now := time.Now()
+	date := now.Format(scoreSnapshotDateLayout)
+	scores := calc.scores()
+	scoreCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineScoreColl)
+
+	writes := make([]mongo.WriteModel, 0, len(scores))
+	for _, score := range scores {
+		score.Date = date
+		score.CreateTime = now.Unix()
+		model := mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"date": date, "scope": score.Scope, "scope_id": score.ScopeId, "framework": score.Framework}).
+			SetUpdate(bson.M{"$set": score}).
+			SetUpsert(true)
+		writes = append(writes, model)
+	}
+	if len(writes) != 0 {
+		writeOption := &options.BulkWriteOptions{}
+		writeOption.SetOrdered(false)
+		if _, err := scoreCol.BulkWrite(c, writes, writeOption); err != nil {
+			ylog.Errorf("saveScoreSnapshot", err.Error())
+		}
+	}
+
+	expire := now.AddDate(0, 0, -scoreSnapshotRetainDays).Format(scoreSnapshotDateLayout)
+	if _, err := scoreCol.DeleteMany(c, bson.M{"date": bson.M{"$lt": expire}}); err != nil {
+		ylog.Errorf("saveScoreSnapshot", err.Error())
+	}
+}
+
+// 定时保存合规得分快照，同一天内多次执行时覆盖当天的快照
+const setScoreSnapshotLock = "setScoreSnapshotLock"
+
+func SetScoreSnapshot(calcuType string) {
+	if calcuType == "crontab" {
+		timer := time.NewTicker(time.Hour * time.Duration(2))
+		for {
+			select {
+			case <-timer.C:
+				lockSuccess, err := infra.Grds.SetNX(context.Background(), setScoreSnapshotLock, 1, time.Minute*time.Duration(5)).Result()
+				if err != nil || !lockSuccess {
+					continue
+				}
+				saveScoreSnapshot()
+				_, _ = infra.Grds.Del(context.Background(), setScoreSnapshotLock).Result()
+			}
+		}
+	} else if calcuType == "once" {
+		lockSuccess, err := infra.Grds.SetNX(context.Background(), setScoreSnapshotLock, 1, time.Minute*time.Duration(5)).Result()
+		if err != nil || !lockSuccess {
+			return
+		}
+		saveScoreSnapshot()
+		_, _ = infra.Grds.Del(context.Background(), setScoreSnapshotLock).Result()
+	}
+}
+
+// 最新的快照日期
+func latestScoreDate(c context.Context) (string, error) {
+	scoreCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineScoreColl)
+	var score ComplianceScore
+	err := scoreCol.FindOne(c, bson.M{}, options.FindOne().SetSort(bson.M{"date": -1})).Decode(&score)
+	if err != nil {
+		return "", err
+	}
+	return score.Date, nil
+}
+
+// ScoreFilter 最新快照中指定范围得分的筛选条件
+func ScoreFilter(scope, scopeId, framework string) (bson.M, error) {
+	date, err := latestScoreDate(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"date": date, "scope": scope, "framework": framework}
+	if scopeId != "" {
+		filter["scope_id"] = scopeId
+	}
+	return filter, nil
+}
+
+// GetScore 获取最新的合规得分
+func GetScore(scope, scopeId, framework string) (*ComplianceScore, error) {
+	c := context.Background()
+	filter, err := ScoreFilter(scope, scopeId, framework)
+	if err != nil {
+		return nil, err
+	}
+	filter["scope_id"] = scopeId
+	var score ComplianceScore
+	scoreCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineScoreColl)
+	if err := scoreCol.FindOne(c, filter).Decode(&score); err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
+
+// GetScoreTrend 获取最近days天的每日得分
+func GetScoreTrend(scope, scopeId, framework string, days int) ([]ComplianceScore, error) {
+	c := context.Background()
+	since := time.Now().AddDate(0, 0, -days).Format(scoreSnapshotDateLayout)
+	filter := bson.M{"scope": scope, "scope_id": scopeId, "framework": framework, "date": bson.M{"$gt": since}}
+	scoreCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineScoreColl)
+	cur, err := scoreCol.Find(c, filter, options.Find().SetSort(bson.M{"date": 1}))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ComplianceScore, 0)
+	if err := cur.All(c, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,206 @@
+package baseline
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidateCompliance(t *testing.T) {
+	tests := []struct {
+		item string
+		ok   bool
+	}{
+		{"cis_l1", true},
+		{"cis_l2:5.2.8", true},
+		{"pci_dss:8.3.6", true},
+		{"mlps_2.0:8.1.4.1", true},
+		{"iso27001:A.9", false},
+		{"pci_dss:", false},
+		{"pci_dss:8.3 6", false},
+	}
+	for _, tt := range tests {
+		if msg := validateCompliance(tt.item); tt.ok != (msg == "") {
+			t.Errorf("%s: unexpected result %q", tt.item, msg)
+		}
+	}
+
+	// 配置目录下的基线都需要有合规条款
+	yamlList, err := filepath.Glob("../../conf/baseline_config/*.yaml")
+	if err != nil || len(yamlList) == 0 {
+		t.Fatalf("no baseline config found: %v", err)
+	}
+	for _, yamlPath := range yamlList {
+		content, err := os.ReadFile(yamlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var baselineInfo BaselineInfo_config
+		if err := yaml.Unmarshal(content, &baselineInfo); err != nil {
+			t.Fatal(err)
+		}
+		for _, checkInfo := range baselineInfo.CheckList {
+			if len(checkInfo.Compliance) == 0 {
+				t.Errorf("%s check %d: no compliance", yamlPath, checkInfo.CheckId)
+			}
+			for _, item := range checkInfo.Compliance {
+				if msg := validateCompliance(item); msg != "" {
+					t.Errorf("%s check %d: %s", yamlPath, checkInfo.CheckId, msg)
+				}
+			}
+		}
+	}
+}
+
+func TestCheckFrameworks(t *testing.T) {
+	got := checkFrameworks([]string{"cis_l1", "pci_dss:8.3.6", "mlps_2.0:8.1.4.1"})
+	want := map[string]string{FrameworkCisL1: "", FrameworkCisL2: "", FrameworkPciDss: "8.3.6", FrameworkMlps: "8.1.4.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// level 2的检查项不属于level 1
+	got = checkFrameworks([]string{"cis_l2"})
+	if _, ok := got[FrameworkCisL1]; ok || len(got) != 1 {
+		t.Errorf("unexpected frameworks %v", got)
+	}
+}
+
+func testComplianceCalc() *complianceCalc {
+	calc := newComplianceCalc(map[checkKey]CheckInfo{
+		{1200, 1}: {Title: "password expiration", TitleCn: "密码失效时间", Security: BaselineCheckHigh, Compliance: []string{"cis_l1", "pci_dss:8.3.9"}},
+		{1200, 2}: {Title: "auditd", Security: BaselineCheckMid, Compliance: []string{"cis_l2", "pci_dss:10.2.1"}},
+		{1200, 3}: {Title: "ctrl-alt-del", Security: BaselineCheckLow, Compliance: []string{"cis_l1"}},
+	})
+	items := []AgentBaselineInfo{
+		{AgentId: "a1", Hostname: "web-1", Tags: []string{"web"}, BaselineId: 1200, CheckId: 1, Status: StatusSuccess},
+		{AgentId: "a1", Hostname: "web-1", Tags: []string{"web"}, BaselineId: 1200, CheckId: 2, Status: StatusFailed},
+		{AgentId: "a1", Hostname: "web-1", Tags: []string{"web"}, BaselineId: 1200, CheckId: 3, Status: StatusFailed},
+		{AgentId: "a2", Hostname: "db-1", Tags: []string{"db"}, BaselineId: 1200, CheckId: 1, Status: StatusFailed, IfWhite: true, WhiteReason: "sso only"},
+		{AgentId: "a2", Hostname: "db-1", Tags: []string{"db"}, BaselineId: 1200, CheckId: 2, Status: StatusFailed},
+		{AgentId: "a2", Hostname: "db-1", Tags: []string{"db"}, BaselineId: 1200, CheckId: 3, Status: "error"},
+	}
+	for i := range items {
+		calc.add(&items[i])
+	}
+	return calc
+}
+
+func TestComplianceScores(t *testing.T) {
+	scores := testComplianceCalc().scores()
+	get := func(scope, scopeId, framework string) ComplianceScore {
+		for _, s := range scores {
+			if s.Scope == scope && s.ScopeId == scopeId && s.Framework == framework {
+				return s
+			}
+		}
+		t.Fatalf("score of %s %s %s not found", scope, scopeId, framework)
+		return ComplianceScore{}
+	}
+
+	// a1: 10/(10+5+1)，a2: 加白算通过，出错不计入，10/(10+5)
+	if s := get(ScopeHost, "a1", ""); s.Score != 62.5 || s.PassNum != 1 || s.RiskNum != 2 || s.Hostname != "web-1" {
+		t.Errorf("unexpected host score %+v", s)
+	}
+	if s := get(ScopeHost, "a2", ""); s.Score != 66.7 || s.WhiteNum != 1 || s.RiskNum != 1 {
+		t.Errorf("unexpected host score %+v", s)
+	}
+	if s := get(ScopeFleet, "", ""); s.Score != 64.6 || s.HostNum != 2 {
+		t.Errorf("unexpected fleet score %+v", s)
+	}
+	if s := get(ScopeTag, "db", ""); s.Score != 66.7 || s.HostNum != 1 {
+		t.Errorf("unexpected tag score %+v", s)
+	}
+	// cis_l1只有检查项1、3，a2的检查项3出错
+	if s := get(ScopeHost, "a1", FrameworkCisL1); s.Score != 90.9 {
+		t.Errorf("unexpected cis_l1 score %+v", s)
+	}
+	if s := get(ScopeHost, "a2", FrameworkCisL1); s.Score != 100 {
+		t.Errorf("unexpected cis_l1 score %+v", s)
+	}
+	if s := get(ScopeHost, "a1", FrameworkCisL2); s.Score != 62.5 {
+		t.Errorf("unexpected cis_l2 score %+v", s)
+	}
+	for _, s := range scores {
+		if s.Framework == FrameworkMlps {
+			t.Errorf("unexpected mlps score %+v", s)
+		}
+	}
+}
+
+func TestComplianceReport(t *testing.T) {
+	framework, _ := GetFramework(FrameworkPciDss)
+	report := testComplianceCalc().report(framework, "zh")
+	if report.Score.Score != 66.7 || len(report.HostScores) != 2 {
+		t.Errorf("unexpected score %+v", report.Score)
+	}
+	wantControls := []ReportControl{
+		{Control: "8.3.9", BaselineId: 1200, CheckId: 1, Title: "密码失效时间", Security: BaselineCheckHigh, PassNum: 1, WhiteNum: 1},
+		{Control: "10.2.1", BaselineId: 1200, CheckId: 2, Title: "auditd", Security: BaselineCheckMid, RiskNum: 2},
+	}
+	if !reflect.DeepEqual(report.Controls, wantControls) {
+		t.Errorf("got controls %+v, want %+v", report.Controls, wantControls)
+	}
+	wantExceptions := []ReportException{{AgentId: "a2", Hostname: "db-1", BaselineId: 1200, CheckId: 1, Title: "密码失效时间", Reason: "sso only"}}
+	if !reflect.DeepEqual(report.Exceptions, wantExceptions) {
+		t.Errorf("got exceptions %+v, want %+v", report.Exceptions, wantExceptions)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderComplianceReport(&buf, report, ReportFormatCsv); err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(buf.Bytes(), []byte{0xEF, 0xBB, 0xBF})))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := records[len(records)-1]
+	if !reflect.DeepEqual(last, []string{"a2", "db-1", "1200", "1", "密码失效时间", "sso only"}) {
+		t.Errorf("unexpected csv exception %v", last)
+	}
+
+	buf.Reset()
+	report.Exceptions[0].Reason = "<script>"
+	if err := RenderComplianceReport(&buf, report, ReportFormatHtml); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "&lt;script&gt;") || !strings.Contains(buf.String(), "PCI-DSS v4.0") {
+		t.Errorf("unexpected html %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := RenderComplianceReport(&buf, report, ReportFormatPdf); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+	// 中文以UCS-2编码输出
+	if !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.HasSuffix(pdf, "%%EOF\n") || !strings.Contains(pdf, pdfHexText("密码失效时间")) {
+		t.Errorf("unexpected pdf %s", pdf)
+	}
+
+	if err := RenderComplianceReport(&buf, report, "docx"); err == nil {
+		t.Errorf("expected error of unsupported format")
+	}
+}
+
+func TestPdfWrap(t *testing.T) {
+	if lines := pdfWrap("abcdefgh", 10, 20); !reflect.DeepEqual(lines, []string{"abcd", "efgh"}) {
+		t.Errorf("unexpected lines %v", lines)
+	}
+	if lines := pdfWrap("合规报告", 10, 20); !reflect.DeepEqual(lines, []string{"合规", "报告"}) {
+		t.Errorf("unexpected lines %v", lines)
+	}
+	if s := pdfTruncate("abcdefgh", 10, 30); s != "abc..." {
+		t.Errorf("unexpected truncate %s", s)
+	}
+	if !lessControl("8.3.9", "8.3.10") || !lessControl("2.2.6", "10.2.1") || lessControl("8.1.4.2", "8.1.4") {
+		t.Errorf("unexpected control order")
+	}
+}
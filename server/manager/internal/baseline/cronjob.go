@@ -234,7 +234,7 @@ func judgeTaskTimeout(calcuType string) {
 		}
 
 		// 计算检查通过率
-		for _, baseline_id := range BaselineAllIdList {
+		for _, baseline_id := range allBaselineIdList() {
 			go SetBaselineCheckTask(baseline_id, "once")
 		}
 		return
@@ -427,7 +427,7 @@ func SetBaselineCheckTask(baselineId int, calcuType string) {
 				if err != nil || !lockSuccess {
 					return
 				} else {
-					for _, baseline_id := range BaselineAllIdList {
+					for _, baseline_id := range allBaselineIdList() {
 						baselineId = baseline_id
 						myFunc()
 					}
@@ -0,0 +1,629 @@
+package baseline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/def"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/atask"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// 自定义基线id起始值，与内置基线区分
+	CustomBaselineIdStart = 10000
+	// 自定义基线策略组
+	CustomGroupId = 2
+
+	CustomStatusDraft     = "draft"
+	CustomStatusPublished = "published"
+	CustomStatusArchived  = "archived"
+
+	// 试运行超时时间
+	dryRunTimeout = 600
+)
+
+// 检查规则，与baseline插件的RuleStruct一致
+type CustomRule struct {
+	Type    string      `json:"type" yaml:"type" bson:"type"`
+	Param   []string    `json:"param" yaml:"param" bson:"param"`
+	Filter  string      `json:"filter" yaml:"filter,omitempty" bson:"filter"`
+	Require string      `json:"require" yaml:"require,omitempty" bson:"require"`
+	Result  interface{} `json:"result" yaml:"result,omitempty" bson:"result"`
+}
+
+type CustomCheck struct {
+	Condition string       `json:"condition" yaml:"condition,omitempty" bson:"condition"`
+	Rules     []CustomRule `json:"rules" yaml:"rules" bson:"rules"`
+}
+
+// 自定义检查项，与baseline插件的CheckInfo一致
+type CustomCheckInfo struct {
+	CheckId       int         `json:"check_id" yaml:"check_id" bson:"check_id"`
+	Type          string      `json:"type" yaml:"type" bson:"type"`
+	Title         string      `json:"title" yaml:"title" bson:"title"`
+	Description   string      `json:"description" yaml:"description" bson:"description"`
+	Solution      string      `json:"solution" yaml:"solution" bson:"solution"`
+	Security      string      `json:"security" yaml:"security" bson:"security"`
+	TypeCn        string      `json:"type_cn" yaml:"type_cn" bson:"type_cn"`
+	TitleCn       string      `json:"title_cn" yaml:"title_cn" bson:"title_cn"`
+	DescriptionCn string      `json:"description_cn" yaml:"description_cn" bson:"description_cn"`
+	SolutionCn    string      `json:"solution_cn" yaml:"solution_cn" bson:"solution_cn"`
+	Check         CustomCheck `json:"check" yaml:"check" bson:"check"`
+
+	Compliance  []string            `json:"compliance" yaml:"compliance,omitempty" bson:"compliance"`
+	Remediation []RemediationAction `json:"remediation" yaml:"remediation,omitempty" bson:"remediation"`
+}
+
+// 自定义基线，每次编辑保存为一个新的revision
+type CustomBaseline struct {
+	BaselineId      int               `json:"baseline_id" yaml:"baseline_id" bson:"baseline_id"`
+	BaselineVersion string            `json:"baseline_version" yaml:"baseline_version" bson:"baseline_version"`
+	BaselineName    string            `json:"baseline_name" yaml:"baseline_name" bson:"baseline_name"`
+	BaselineNameEn  string            `json:"baseline_name_en" yaml:"baseline_name_en" bson:"baseline_name_en"`
+	SystemList      []string          `json:"system_list" yaml:"system" bson:"system_list"`
+	CheckList       []CustomCheckInfo `json:"check_list" yaml:"check_list" bson:"check_list"`
+
+	Revision   int    `json:"revision" yaml:"-" bson:"revision"`
+	Status     string `json:"status" yaml:"-" bson:"status"`
+	User       string `json:"user" yaml:"-" bson:"user"`
+	UpdateTime int64  `json:"update_time" yaml:"-" bson:"update_time"`
+}
+
+// 校验错误，rule_index为-1时为检查项本身的错误，check_id为0时为基线本身的错误
+type ValidateError struct {
+	CheckId   int    `json:"check_id"`
+	RuleIndex int    `json:"rule_index"`
+	Field     string `json:"field"`
+	Msg       string `json:"msg"`
+}
+
+// 规则类型：参数个数及规则返回值类型
+type ruleSpec struct {
+	minParam int
+	maxParam int
+	// string: 返回命令输出或文件行，result为表达式；bool: 返回是否通过
+	valueType string
+}
+
+var ruleSpecs = map[string]ruleSpec{
+	"command_check":   {1, 2, "string"},
+	"if_file_exist":   {1, 1, "bool"},
+	"file_permission": {2, 2, "bool"},
+	"file_user_group": {2, 2, "bool"},
+	"file_line_check": {1, 3, "string"},
+	"func_check":      {1, 1, "bool"},
+	"file_md5_check":  {2, 2, "bool"},
+	// 原生规则
+	"sysctl_check":       {1, 2, "string"},
+	"systemd_unit_check": {2, 2, "string"},
+	"package_check":      {1, 1, "bool"},
+	"mount_option_check": {2, 3, "bool"},
+	"pam_check":          {2, 3, "string"},
+	"sshd_config_check":  {1, 2, "string"},
+	"config_key_check":   {2, 3, "string"},
+	"file_mode_check":    {2, 3, "bool"},
+}
+
+var (
+	funcCheckList      = map[string]bool{"Ensure no duplicate user names exist": true}
+	conditionList      = map[string]bool{"": true, "all": true, "any": true, "none": true}
+	requireList        = map[string]bool{"": true, "allow_ssh_passwd": true}
+	securityList       = map[string]bool{BaselineCheckHigh: true, BaselineCheckMid: true, BaselineCheckLow: true}
+	mathComputeReg     = regexp.MustCompile(`^\$\((<|<=|>|>=)\)-?\d+$`)
+	filePermissionReg  = regexp.MustCompile(`^[0-7]{3,4}$`)
+	fileModeReg        = regexp.MustCompile(`^0?[0-7]{3}$`)
+	fileUserGroupReg   = regexp.MustCompile(`^\d+:\d+$`)
+	md5Reg             = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	systemdStateList   = map[string]bool{"enabled": true, "active": true}
+	pamTypeList        = map[string]bool{"auth": true, "account": true, "password": true, "session": true}
+	sshdConnSpecReg    = regexp.MustCompile(`^(user|host|addr|laddr|lport|rdomain)=[^,=\s]+(,(user|host|addr|laddr|lport|rdomain)=[^,=\s]+)*$`)
+	ErrBaselineNotFind = errors.New("custom baseline not find")
+	ErrPrivilegedRule  = errors.New("custom baseline with command_check or func_check can only be published or dry-run by admin")
+
+	// 插件以root执行命令或内置函数的规则，只有管理员可以发布及试运行
+	privilegedRuleList = map[string]bool{"command_check": true, "func_check": true}
+)
+
+// 校验result表达式，例如$(<=)90、$(not)^root$、$(>)0$(&&)$(<=)900
+func validateResultExpr(expr string, filter *regexp.Regexp) string {
+	for _, sub := range strings.Split(expr, "$(&&)") {
+		sub = strings.TrimPrefix(sub, "$(not)")
+		if sub == "" {
+			return "empty expression in " + expr
+		}
+		if strings.HasPrefix(sub, "$(") {
+			if !mathComputeReg.MatchString(sub) {
+				return fmt.Sprintf("invalid relational expression %s, need $(<)N, $(<=)N, $(>)N or $(>=)N", sub)
+			}
+			// 数值比较需要filter分组提取数值
+			if filter == nil || filter.NumSubexp() < 1 {
+				return fmt.Sprintf("relational expression %s needs a filter with a capture group", sub)
+			}
+			continue
+		}
+		if _, err := regexp.Compile(sub); err != nil {
+			return fmt.Sprintf("invalid regex %s: %s", sub, err.Error())
+		}
+	}
+	return ""
+}
+
+// 校验单条规则
+func validateRule(checkId, ruleIndex int, rule *CustomRule) (errList []ValidateError) {
+	newErr := func(field, msg string) {
+		errList = append(errList, ValidateError{CheckId: checkId, RuleIndex: ruleIndex, Field: field, Msg: msg})
+	}
+	spec, ok := ruleSpecs[rule.Type]
+	if !ok {
+		newErr("type", "unknown rule type "+rule.Type)
+		return
+	}
+	if len(rule.Param) < spec.minParam || len(rule.Param) > spec.maxParam {
+		newErr("param", fmt.Sprintf("%s needs %d to %d params, get %d", rule.Type, spec.minParam, spec.maxParam, len(rule.Param)))
+		return
+	}
+	if rule.Param[0] == "" {
+		newErr("param", "empty param")
+	}
+	switch rule.Type {
+	case "command_check":
+		if len(rule.Param) == 2 && rule.Param[1] != "ignore_exit" {
+			newErr("param", "the second param of command_check can only be ignore_exit")
+		}
+	case "file_permission":
+		if !filePermissionReg.MatchString(rule.Param[1]) {
+			newErr("param", "file permission needs an octal mode, e.g. 644")
+		}
+	case "file_user_group":
+		if !fileUserGroupReg.MatchString(rule.Param[1]) {
+			newErr("param", "file user group needs uid:gid, e.g. 0:0")
+		}
+	case "func_check":
+		if !funcCheckList[rule.Param[0]] {
+			newErr("param", "unknown func "+rule.Param[0])
+		}
+	case "file_md5_check":
+		if !md5Reg.MatchString(rule.Param[1]) {
+			newErr("param", "file md5 needs 32 lowercase hex characters")
+		}
+	case "sysctl_check":
+		if strings.Contains(rule.Param[0], "..") {
+			newErr("param", "invalid sysctl key "+rule.Param[0])
+		}
+		if len(rule.Param) == 2 && rule.Param[1] != "ignore_missing" {
+			newErr("param", "the second param of sysctl_check can only be ignore_missing")
+		}
+	case "systemd_unit_check":
+		if !systemdStateList[rule.Param[1]] {
+			newErr("param", "the second param of systemd_unit_check can only be enabled or active")
+		}
+	case "mount_option_check":
+		if !strings.HasPrefix(rule.Param[0], "/") {
+			newErr("param", "mountpoint needs an absolute path")
+		}
+		if rule.Param[1] == "" {
+			newErr("param", "empty mount options")
+		}
+		if len(rule.Param) == 3 && rule.Param[2] != "ignore_missing" {
+			newErr("param", "the third param of mount_option_check can only be ignore_missing")
+		}
+	case "pam_check":
+		if !strings.HasPrefix(rule.Param[0], "/") {
+			newErr("param", "pam file needs an absolute path")
+		}
+		if !pamTypeList[rule.Param[1]] {
+			newErr("param", "pam type can only be auth, account, password or session")
+		}
+	case "config_key_check":
+		if !strings.HasPrefix(rule.Param[0], "/") {
+			newErr("param", "config file needs an absolute path")
+		}
+		if rule.Param[1] == "" || strings.HasPrefix(rule.Param[1], ".") || strings.HasSuffix(rule.Param[1], ".") {
+			newErr("param", "invalid config key "+rule.Param[1])
+		}
+		if len(rule.Param) == 3 && rule.Param[2] != "ignore_missing" {
+			newErr("param", "the third param of config_key_check can only be ignore_missing")
+		}
+	case "file_mode_check":
+		if !strings.HasPrefix(rule.Param[0], "/") {
+			newErr("param", "file mode check needs an absolute path")
+		}
+		if !fileModeReg.MatchString(rule.Param[1]) {
+			newErr("param", "file mode needs an octal mode no more than 777, e.g. 600")
+		}
+		if len(rule.Param) == 3 && rule.Param[2] != "ignore_missing" {
+			newErr("param", "the third param of file_mode_check can only be ignore_missing")
+		}
+	case "sshd_config_check":
+		if len(rule.Param) == 2 && rule.Param[1] != "" && !sshdConnSpecReg.MatchString(rule.Param[1]) {
+			newErr("param", "connection spec of sshd -C needs key=value separated by commas, e.g. user=root,host=localhost")
+		}
+	}
+	if !requireList[rule.Require] {
+		newErr("require", "unknown require "+rule.Require)
+	}
+
+	var filter *regexp.Regexp
+	if rule.Filter != "" {
+		var err error
+		if filter, err = regexp.Compile(rule.Filter); err != nil {
+			newErr("filter", "invalid regex: "+err.Error())
+			return
+		}
+		if spec.valueType != "string" {
+			newErr("filter", rule.Type+" doesn't support filter")
+		}
+	}
+
+	// json中的数字为float64
+	if f, ok := rule.Result.(float64); ok && f == math.Trunc(f) {
+		rule.Result = int(f)
+	}
+	switch result := rule.Result.(type) {
+	case nil:
+		if spec.valueType == "string" {
+			newErr("result", rule.Type+" needs a result expression")
+		}
+	case bool:
+		if spec.valueType != "bool" {
+			newErr("result", rule.Type+" needs a result expression rather than bool")
+		}
+	case string:
+		if spec.valueType != "string" {
+			newErr("result", rule.Type+" returns bool, result can only be true or false")
+			return
+		}
+		if msg := validateResultExpr(result, filter); msg != "" {
+			newErr("result", msg)
+		}
+	case int:
+		if spec.valueType != "string" {
+			newErr("result", rule.Type+" returns bool, result can only be true or false")
+		}
+	default:
+		newErr("result", fmt.Sprintf("unsupported result %v", result))
+	}
+	return
+}
+
+// ValidateCustomBaseline 校验自定义基线，检查正则、result表达式与规则类型
+func ValidateCustomBaseline(baseline *CustomBaseline) (errList []ValidateError) {
+	newErr := func(checkId int, field, msg string) {
+		errList = append(errList, ValidateError{CheckId: checkId, RuleIndex: -1, Field: field, Msg: msg})
+	}
+	if baseline.BaselineName == "" && baseline.BaselineNameEn == "" {
+		newErr(0, "baseline_name", "empty baseline name")
+	}
+	if len(baseline.SystemList) == 0 {
+		newErr(0, "system_list", "empty system list")
+	}
+	if len(baseline.CheckList) == 0 {
+		newErr(0, "check_list", "empty check list")
+	}
+	checkIdSet := make(map[int]bool)
+	for i := range baseline.CheckList {
+		checkInfo := &baseline.CheckList[i]
+		if checkInfo.CheckId <= 0 {
+			newErr(checkInfo.CheckId, "check_id", "check id needs to be positive")
+		} else if checkIdSet[checkInfo.CheckId] {
+			newErr(checkInfo.CheckId, "check_id", "duplicate check id")
+		}
+		checkIdSet[checkInfo.CheckId] = true
+		if checkInfo.Title == "" && checkInfo.TitleCn == "" {
+			newErr(checkInfo.CheckId, "title", "empty title")
+		}
+		if !securityList[checkInfo.Security] {
+			newErr(checkInfo.CheckId, "security", "security can only be high, mid or low")
+		}
+		if !conditionList[checkInfo.Check.Condition] {
+			newErr(checkInfo.CheckId, "condition", "condition can only be all, any or none")
+		}
+		if len(checkInfo.Check.Rules) == 0 {
+			newErr(checkInfo.CheckId, "rules", "empty rules")
+		}
+		for j := range checkInfo.Check.Rules {
+			errList = append(errList, validateRule(checkInfo.CheckId, j, &checkInfo.Check.Rules[j])...)
+		}
+		for _, item := range checkInfo.Compliance {
+			if msg := validateCompliance(item); msg != "" {
+				newErr(checkInfo.CheckId, "compliance", msg)
+			}
+		}
+		for _, action := range checkInfo.Remediation {
+			if msg := validateRemediation(action); msg != "" {
+				newErr(checkInfo.CheckId, "remediation", msg)
+			}
+		}
+	}
+	return errList
+}
+
+// 是否包含需要管理员发布的规则
+func hasPrivilegedRule(baseline *CustomBaseline) bool {
+	for _, checkInfo := range baseline.CheckList {
+		for _, rule := range checkInfo.Check.Rules {
+			if privilegedRuleList[rule.Type] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 生成规则包yaml
+func customBaselineYaml(baseline *CustomBaseline) ([]byte, error) {
+	return yaml.Marshal(baseline)
+}
+
+func customCol() *mongo.Collection {
+	return infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineCustomColl)
+}
+
+// SaveCustomBaseline 保存自定义基线的新revision，baseline_id为0时新建基线
+func SaveCustomBaseline(baseline CustomBaseline, user string) (CustomBaseline, []ValidateError, error) {
+	c := context.Background()
+	if errList := ValidateCustomBaseline(&baseline); len(errList) != 0 {
+		return baseline, errList, nil
+	}
+	col := customCol()
+
+	// 新建时分配基线id，编辑时revision递增
+	var last CustomBaseline
+	if baseline.BaselineId == 0 {
+		err := col.FindOne(c, bson.M{}, options.FindOne().SetSort(bson.M{"baseline_id": -1})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return baseline, nil, err
+		}
+		baseline.BaselineId = CustomBaselineIdStart
+		if last.BaselineId >= CustomBaselineIdStart {
+			baseline.BaselineId = last.BaselineId + 1
+		}
+		baseline.Revision = 1
+	} else {
+		err := col.FindOne(c, bson.M{"baseline_id": baseline.BaselineId}, options.FindOne().SetSort(bson.M{"revision": -1})).Decode(&last)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return baseline, nil, ErrBaselineNotFind
+			}
+			return baseline, nil, err
+		}
+		baseline.Revision = last.Revision + 1
+	}
+	baseline.BaselineVersion = fmt.Sprintf("1.%d", baseline.Revision)
+	baseline.Status = CustomStatusDraft
+	baseline.User = user
+	baseline.UpdateTime = time.Now().Unix()
+
+	_, err := col.InsertOne(c, baseline)
+	return baseline, nil, err
+}
+
+// GetCustomBaseline 获取自定义基线，revision为0时返回最新revision
+func GetCustomBaseline(baselineId, revision int) (*CustomBaseline, error) {
+	filter := bson.M{"baseline_id": baselineId}
+	if revision != 0 {
+		filter["revision"] = revision
+	}
+	baseline := new(CustomBaseline)
+	err := customCol().FindOne(context.Background(), filter, options.FindOne().SetSort(bson.M{"revision": -1})).Decode(baseline)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrBaselineNotFind
+	}
+	return baseline, err
+}
+
+// GetCustomBaselineRevisions 获取自定义基线的revision列表，不含检查项
+func GetCustomBaselineRevisions(baselineId int) ([]CustomBaseline, error) {
+	c := context.Background()
+	findOption := options.Find().SetSort(bson.M{"revision": -1}).SetProjection(bson.M{"check_list": 0})
+	cur, err := customCol().Find(c, bson.M{"baseline_id": baselineId}, findOption)
+	if err != nil {
+		return nil, err
+	}
+	revisionList := make([]CustomBaseline, 0)
+	err = cur.All(c, &revisionList)
+	return revisionList, err
+}
+
+// GetCustomBaselineList 获取自定义基线列表，每个基线返回最新revision
+func GetCustomBaselineList() ([]CustomBaseline, error) {
+	c := context.Background()
+	findOption := options.Find().SetSort(bson.D{{Key: "baseline_id", Value: 1}, {Key: "revision", Value: -1}}).SetProjection(bson.M{"check_list": 0})
+	cur, err := customCol().Find(c, bson.M{}, findOption)
+	if err != nil {
+		return nil, err
+	}
+	baselineList := make([]CustomBaseline, 0)
+	for cur.Next(c) {
+		var baseline CustomBaseline
+		if err := cur.Decode(&baseline); err != nil {
+			continue
+		}
+		if len(baselineList) == 0 || baselineList[len(baselineList)-1].BaselineId != baseline.BaselineId {
+			baselineList = append(baselineList, baseline)
+		}
+	}
+	return baselineList, cur.Err()
+}
+
+// 已发布的自定义基线id
+func publishedCustomIdList() []int {
+	c := context.Background()
+	idList := make([]int, 0)
+	res, err := customCol().Distinct(c, "baseline_id", bson.M{"status": CustomStatusPublished})
+	if err != nil {
+		ylog.Errorf("Distinct error", err.Error())
+		return idList
+	}
+	for _, id := range res {
+		switch v := id.(type) {
+		case int32:
+			idList = append(idList, int(v))
+		case int64:
+			idList = append(idList, int(v))
+		}
+	}
+	return idList
+}
+
+// 全部基线id，包括已发布的自定义基线
+func allBaselineIdList() []int {
+	return append(append([]int{}, BaselineAllIdList...), publishedCustomIdList()...)
+}
+
+// PublishCustomBaseline 发布自定义基线的revision，与内置基线一同检查，包含command_check、func_check时需要管理员发布
+func PublishCustomBaseline(baselineId, revision int, admin bool) (*CustomBaseline, error) {
+	c := context.Background()
+	baseline, err := GetCustomBaseline(baselineId, revision)
+	if err != nil {
+		return nil, err
+	}
+	if !admin && hasPrivilegedRule(baseline) {
+		return nil, ErrPrivilegedRule
+	}
+	if errList := ValidateCustomBaseline(baseline); len(errList) != 0 {
+		return nil, fmt.Errorf("custom baseline %d revision %d is invalid: %s", baselineId, baseline.Revision, errList[0].Msg)
+	}
+	content, err := customBaselineYaml(baseline)
+	if err != nil {
+		return nil, err
+	}
+
+	// 存入基线信息表及规则包
+	var baselineInfo BaselineInfo_config
+	if err = yaml.Unmarshal(content, &baselineInfo); err != nil {
+		return nil, err
+	}
+	baseline2Mongo(&baselineInfo, content)
+
+	// 更新revision状态
+	col := customCol()
+	_, err = col.UpdateMany(c, bson.M{"baseline_id": baselineId, "status": CustomStatusPublished},
+		bson.M{"$set": bson.M{"status": CustomStatusArchived}})
+	if err != nil {
+		return nil, err
+	}
+	_, err = col.UpdateOne(c, bson.M{"baseline_id": baselineId, "revision": baseline.Revision},
+		bson.M{"$set": bson.M{"status": CustomStatusPublished}})
+	if err != nil {
+		return nil, err
+	}
+	baseline.Status = CustomStatusPublished
+
+	// 更新自定义策略组
+	groupIdList := publishedCustomIdList()
+	group := BaselineGroupMongo{
+		GroupId:     CustomGroupId,
+		GroupName:   "自定义基线扫描策略",
+		GroupNameEn: "custom policy",
+	}
+	infoCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaseLineInfoColl)
+	for _, id := range groupIdList {
+		var info BaselineInfo_config
+		if err := infoCol.FindOne(c, bson.M{"baseline_id": id}).Decode(&info); err != nil {
+			continue
+		}
+		group.BaselineList = append(group.BaselineList, BaselineInfo_config{
+			BaselineId:      info.BaselineId,
+			BaselineVersion: info.BaselineVersion,
+			BaselineName:    info.BaselineName,
+			BaselineNameEn:  info.BaselineNameEn,
+		})
+	}
+	saveGroup(group, groupIdList)
+	return baseline, nil
+}
+
+// DryRunCustomBaseline 在单台主机上试运行自定义基线的revision，返回任务id，包含command_check、func_check时需要管理员试运行
+func DryRunCustomBaseline(agentId string, baselineId, revision int, checkIdList []int, admin bool) (string, error) {
+	baseline, err := GetCustomBaseline(baselineId, revision)
+	if err != nil {
+		return "", err
+	}
+	if !admin && hasPrivilegedRule(baseline) {
+		return "", ErrPrivilegedRule
+	}
+	if errList := ValidateCustomBaseline(baseline); len(errList) != 0 {
+		return "", fmt.Errorf("custom baseline %d revision %d is invalid: %s", baselineId, baseline.Revision, errList[0].Msg)
+	}
+	content, err := customBaselineYaml(baseline)
+	if err != nil {
+		return "", err
+	}
+
+	// 试运行的规则包不会被插件缓存
+	pack := &BaselinePack{
+		BaselineId: baselineId,
+		Version:    packVersion(fmt.Sprintf("draft.%d", baseline.Revision), content),
+		Content:    string(content),
+		UpdateTime: time.Now().Unix(),
+	}
+	if err = signPack(pack); err != nil {
+		return "", err
+	}
+	taskData, err := json.Marshal(struct {
+		BaselineID      int           `json:"baseline_id"`
+		BaseLineVersion string        `json:"baseline_version"`
+		CheckIdList     []int         `json:"check_id_list"`
+		Pack            *BaselinePack `json:"pack"`
+		DryRun          bool          `json:"dry_run"`
+	}{
+		BaselineID:      baselineId,
+		BaseLineVersion: pack.Version,
+		CheckIdList:     checkIdList,
+		Pack:            pack,
+		DryRun:          true,
+	})
+	if err != nil {
+		return "", err
+	}
+	taskMsg := def.AgentTaskMsg{
+		Name:     "baseline",
+		Data:     string(taskData),
+		DataType: BaselineDataType,
+	}
+	return atask.SendFastTask(agentId, &taskMsg, true, dryRunTimeout,
+		map[string]interface{}{"baseline_id": baselineId, "revision": baseline.Revision})
+}
+
+// 试运行结果
+type DryRunResult struct {
+	Status    string      `json:"status"`
+	StatusMsg string      `json:"status_msg"`
+	Data      interface{} `json:"data"`
+}
+
+// GetDryRunResult 获取试运行结果，插件通过8010任务状态回传每条规则的原始值
+func GetDryRunResult(taskId string) (*DryRunResult, error) {
+	c := context.Background()
+	subTaskCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.AgentSubTaskCollection)
+	var subTask atask.AgentSubTask
+	err := subTaskCol.FindOne(c, bson.M{"task_id": taskId}).Decode(&subTask)
+	if err != nil {
+		return nil, err
+	}
+	res := &DryRunResult{Status: subTask.Status, StatusMsg: subTask.StatusMsg}
+	if taskResult, ok := subTask.TaskResult.(bson.D); ok {
+		for _, e := range taskResult {
+			if data, ok := e.Value.(string); ok && e.Key == "data" {
+				var dryRunInfo interface{}
+				if err := json.Unmarshal([]byte(data), &dryRunInfo); err == nil {
+					res.Data = dryRunInfo
+				}
+			}
+		}
+	}
+	return res, nil
+}
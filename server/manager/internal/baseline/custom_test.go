@@ -0,0 +1,135 @@
+package baseline
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateCustomBaseline(t *testing.T) {
+	newBaseline := func(rules ...CustomRule) *CustomBaseline {
+		return &CustomBaseline{
+			BaselineName: "custom",
+			SystemList:   []string{"centos"},
+			CheckList: []CustomCheckInfo{{
+				CheckId:  1,
+				Title:    "check",
+				Security: BaselineCheckHigh,
+				Check:    CustomCheck{Condition: "all", Rules: rules},
+			}},
+		}
+	}
+	tests := []struct {
+		name  string
+		rule  CustomRule
+		field string
+	}{
+		{"command", CustomRule{Type: "command_check", Param: []string{"umask"}, Filter: `(\d+)`, Result: "$(<=)27"}, ""},
+		{"range", CustomRule{Type: "file_line_check", Param: []string{"/etc/login.defs"}, Filter: `^PASS_MAX_DAYS\s+(\d+)`, Result: "$(>)0$(&&)$(<=)90"}, ""},
+		{"not", CustomRule{Type: "file_line_check", Param: []string{"/etc/passwd"}, Result: "$(not)^root:"}, ""},
+		{"bool", CustomRule{Type: "if_file_exist", Param: []string{"/etc/hosts.equiv"}, Result: false}, ""},
+		{"nil", CustomRule{Type: "file_permission", Param: []string{"/etc/passwd", "644"}}, ""},
+		{"unknown type", CustomRule{Type: "registry_check", Param: []string{"x"}}, "type"},
+		{"param count", CustomRule{Type: "file_user_group", Param: []string{"/etc/passwd"}}, "param"},
+		{"bad filter", CustomRule{Type: "command_check", Param: []string{"umask"}, Filter: `(\d+`, Result: "0027"}, "filter"},
+		{"bad regex", CustomRule{Type: "command_check", Param: []string{"umask"}, Result: "[0-7"}, "result"},
+		{"bad operator", CustomRule{Type: "command_check", Param: []string{"umask"}, Filter: `(\d+)`, Result: "$(==)27"}, "result"},
+		{"no group", CustomRule{Type: "command_check", Param: []string{"umask"}, Result: "$(<=)27"}, "result"},
+		{"string for bool", CustomRule{Type: "if_file_exist", Param: []string{"/etc/hosts.equiv"}, Result: "true"}, "result"},
+		{"bool for string", CustomRule{Type: "command_check", Param: []string{"umask"}, Result: true}, "result"},
+		{"require", CustomRule{Type: "command_check", Param: []string{"umask"}, Result: "0027", Require: "root"}, "require"},
+		{"sysctl", CustomRule{Type: "sysctl_check", Param: []string{"net.ipv4.ip_forward", "ignore_missing"}, Result: 0}, ""},
+		{"systemd", CustomRule{Type: "systemd_unit_check", Param: []string{"auditd", "enabled"}, Result: "^enabled$"}, ""},
+		{"package", CustomRule{Type: "package_check", Param: []string{"telnet-server"}, Result: false}, ""},
+		{"mount", CustomRule{Type: "mount_option_check", Param: []string{"/dev/shm", "nodev,nosuid"}}, ""},
+		{"pam", CustomRule{Type: "pam_check", Param: []string{"/etc/pam.d/system-auth", "password", "pam_pwhistory.so|pam_unix.so"}, Filter: `remember=(\d+)`, Result: "$(>=)5"}, ""},
+		{"sshd", CustomRule{Type: "sshd_config_check", Param: []string{"PermitRootLogin", "user=root,host=localhost"}, Result: "^no$"}, ""},
+		{"config key", CustomRule{Type: "config_key_check", Param: []string{"/var/lib/kubelet/config.yaml", "authentication.anonymous.enabled"}, Result: "^false$"}, ""},
+		{"file mode", CustomRule{Type: "file_mode_check", Param: []string{"/etc/kubernetes/pki/*.key", "600", "ignore_missing"}}, ""},
+		{"sysctl key", CustomRule{Type: "sysctl_check", Param: []string{"../../etc/passwd"}, Result: "x"}, "param"},
+		{"systemd state", CustomRule{Type: "systemd_unit_check", Param: []string{"auditd", "running"}, Result: "^yes$"}, "param"},
+		{"mount relative", CustomRule{Type: "mount_option_check", Param: []string{"tmp", "noexec"}}, "param"},
+		{"pam type", CustomRule{Type: "pam_check", Param: []string{"/etc/pam.d/sshd", "login"}, Result: "pam_unix"}, "param"},
+		{"sshd spec", CustomRule{Type: "sshd_config_check", Param: []string{"PermitRootLogin", "root@localhost"}, Result: "^no$"}, "param"},
+		{"config key dot", CustomRule{Type: "config_key_check", Param: []string{"/etc/docker/daemon.json", "icc."}, Result: "^false$"}, "param"},
+		{"file mode setuid", CustomRule{Type: "file_mode_check", Param: []string{"/usr/bin/kubelet", "4755"}}, "param"},
+		{"package result", CustomRule{Type: "package_check", Param: []string{"telnet"}, Result: "^1.0"}, "result"},
+	}
+	for _, tt := range tests {
+		errList := ValidateCustomBaseline(newBaseline(tt.rule))
+		if tt.field == "" {
+			if len(errList) != 0 {
+				t.Errorf("%s: unexpected errors %v", tt.name, errList)
+			}
+			continue
+		}
+		if len(errList) != 1 || errList[0].Field != tt.field || errList[0].RuleIndex != 0 {
+			t.Errorf("%s: want an error of %s, get %v", tt.name, tt.field, errList)
+		}
+	}
+
+	b := newBaseline(CustomRule{Type: "if_file_exist", Param: []string{"/etc/hosts.equiv"}})
+	b.CheckList = append(b.CheckList, b.CheckList[0])
+	b.CheckList[1].Check.Condition = "some"
+	b.CheckList[1].Security = "critical"
+	if errList := ValidateCustomBaseline(b); len(errList) != 3 {
+		t.Errorf("want errors of check_id, security and condition, get %v", errList)
+	}
+}
+
+func TestValidateCustomBaselineRemediation(t *testing.T) {
+	tests := []struct {
+		name   string
+		action RemediationAction
+		ok     bool
+	}{
+		{"set_key", RemediationAction{Type: "set_key", Param: []string{"/etc/login.defs", "PASS_MAX_DAYS", "90"}}, true},
+		{"set_key sep", RemediationAction{Type: "set_key", Param: []string{"/etc/security/pwquality.conf", "minlen", "14", " = "}}, true},
+		{"chmod", RemediationAction{Type: "chmod", Param: []string{"/etc/shadow", "0600"}}, true},
+		{"chown", RemediationAction{Type: "chown", Param: []string{"/etc/shadow", "0:0"}}, true},
+		{"sysctl", RemediationAction{Type: "sysctl", Param: []string{"kernel.randomize_va_space", "2"}}, true},
+		{"service", RemediationAction{Type: "service_disable", Param: []string{"telnet.socket"}}, true},
+		{"unknown type", RemediationAction{Type: "exec", Param: []string{"rm"}}, false},
+		{"relative path", RemediationAction{Type: "set_key", Param: []string{"login.defs", "PASS_MAX_DAYS", "90"}}, false},
+		{"param count", RemediationAction{Type: "chmod", Param: []string{"/etc/shadow"}}, false},
+		{"bad mode", RemediationAction{Type: "chmod", Param: []string{"/etc/shadow", "rw"}}, false},
+		{"bad owner", RemediationAction{Type: "chown", Param: []string{"/etc/shadow", "root:root"}}, false},
+		{"bad sysctl", RemediationAction{Type: "sysctl", Param: []string{"../../etc/passwd", "x"}}, false},
+		{"bad service", RemediationAction{Type: "service_disable", Param: []string{"../sshd"}}, false},
+	}
+	for _, tt := range tests {
+		b := &CustomBaseline{
+			BaselineName: "custom",
+			SystemList:   []string{"centos"},
+			CheckList: []CustomCheckInfo{{
+				CheckId:     1,
+				Title:       "check",
+				Security:    BaselineCheckHigh,
+				Check:       CustomCheck{Rules: []CustomRule{{Type: "if_file_exist", Param: []string{"/etc/hosts.equiv"}}}},
+				Remediation: []RemediationAction{tt.action},
+			}},
+		}
+		errList := ValidateCustomBaseline(b)
+		if tt.ok != (len(errList) == 0) {
+			t.Errorf("%s: unexpected errors %v", tt.name, errList)
+		}
+		if !tt.ok && (len(errList) != 1 || errList[0].Field != "remediation") {
+			t.Errorf("%s: want an error of remediation, get %v", tt.name, errList)
+		}
+	}
+}
+
+func TestValidateCustomBaselineJson(t *testing.T) {
+	// json的数字为float64，需要当作int处理
+	var b CustomBaseline
+	err := json.Unmarshal([]byte(`{"baseline_name":"custom","system_list":["debian"],"check_list":[{"check_id":1,"title":"umask","security":"mid",
+		"check":{"rules":[{"type":"command_check","param":["umask"],"result":27}]}}]}`), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errList := ValidateCustomBaseline(&b); len(errList) != 0 {
+		t.Fatalf("unexpected errors %v", errList)
+	}
+	if _, ok := b.CheckList[0].Check.Rules[0].Result.(int); !ok {
+		t.Errorf("result isn't normalized to int: %T", b.CheckList[0].Check.Rules[0].Result)
+	}
+}
@@ -0,0 +1,31 @@
+package baseline
+
+// 基线system字段为发行版族，下发时映射为agent心跳上报的platform
+var distroFamilyPlatforms = map[string][]string{
+	"centos":    {"centos"},
+	"debian":    {"debian", "raspbian"},
+	"ubuntu":    {"ubuntu", "linuxmint"},
+	"rhel":      {"redhat", "rhel", "rocky", "almalinux", "oracle", "ol"},
+	"amzn":      {"amazon", "amzn"},
+	"suse":      {"suse", "sles", "sled", "opensuse", "opensuse-leap", "opensuse-tumbleweed"},
+	"openeuler": {"openeuler"},
+}
+
+// 将基线的发行版族展开为platform列表，未知的按platform原样保留
+func familyPlatforms(systemList []string) []string {
+	platforms := make([]string, 0, len(systemList))
+	seen := make(map[string]bool)
+	for _, system := range systemList {
+		list, ok := distroFamilyPlatforms[system]
+		if !ok {
+			list = []string{system}
+		}
+		for _, platform := range list {
+			if !seen[platform] {
+				seen[platform] = true
+				platforms = append(platforms, platform)
+			}
+		}
+	}
+	return platforms
+}
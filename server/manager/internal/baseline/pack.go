@@ -0,0 +1,159 @@
+package baseline
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// 基线规则包签名私钥，对应公钥需随baseline插件下发到config/pack.pub，多个manager实例需使用同一密钥
+	defaultPackKeyPath = "conf/baseline_pack.key"
+)
+
+// 基线规则包，由manager签名后随任务下发，插件按版本缓存，update_time较旧的规则包不会覆盖插件缓存的版本
+type BaselinePack struct {
+	BaselineId int    `json:"baseline_id" bson:"baseline_id"`
+	Version    string `json:"version" bson:"version"`
+	Content    string `json:"content" bson:"content"`
+	Signature  string `json:"signature" bson:"-"`
+	UpdateTime int64  `json:"update_time" bson:"update_time"`
+}
+
+var packKey ed25519.PrivateKey
+
+var errNoPackKey = errors.New("baseline pack key isn't loaded")
+
+// 规则包版本：基线版本+内容摘要，内容不变则版本不变
+func packVersion(baselineVersion string, content []byte) string {
+	sum := sha256.Sum256(content)
+	return baselineVersion + "+" + hex.EncodeToString(sum[:6])
+}
+
+// 签名内容，与plugins/baseline/src/pack/pack.go一致
+func (p *BaselinePack) message() []byte {
+	return []byte(fmt.Sprintf("elkeid baseline pack\n%d\n%s\n%d\n%s", p.BaselineId, p.Version, p.UpdateTime, p.Content))
+}
+
+// 加载签名私钥，不自动生成，避免多个实例各自生成不同的密钥
+func loadPackKey(keyPath string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read baseline pack key %s error %w, generate it by "+
+			"`openssl genpkey -algorithm ed25519 -out %s` and deploy the public key to the baseline plugin as config/pack.pub", keyPath, err, keyPath)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid baseline pack key " + keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("baseline pack key isn't ed25519 " + keyPath)
+	}
+	return ret, nil
+}
+
+// InitPackKey 加载规则包签名私钥，私钥不存在时不下发规则包，插件使用自带的基线配置；私钥无效时manager不启动
+func InitPackKey() error {
+	keyPath := infra.Conf.GetString("baseline.pack_key")
+	if keyPath == "" {
+		keyPath = defaultPackKeyPath
+	}
+	key, err := loadPackKey(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		ylog.Errorf("baseline pack", "%s, baseline packs aren't pushed and custom baselines and remediations are disabled", err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(pubDer)
+	ylog.Infof("baseline pack", "load baseline pack key %s, sha256 of the public key %s", keyPath, hex.EncodeToString(sum[:]))
+	packKey = key
+	return nil
+}
+
+// 对规则包签名
+func signPack(pack *BaselinePack) error {
+	if packKey == nil {
+		return errNoPackKey
+	}
+	pack.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(packKey, pack.message()))
+	return nil
+}
+
+// 保存基线yaml为规则包
+func savePack(baselineId int, baselineVersion string, content []byte) {
+	c := context.Background()
+	pack := BaselinePack{
+		BaselineId: baselineId,
+		Version:    packVersion(baselineVersion, content),
+		Content:    string(content),
+		UpdateTime: time.Now().Unix(),
+	}
+	packCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselinePackColl)
+	option := &options.UpdateOptions{}
+	option.SetUpsert(true)
+	_, err := packCol.UpdateOne(c, bson.M{"baseline_id": baselineId, "version": pack.Version}, bson.M{"$set": pack}, option)
+	if err != nil {
+		ylog.Errorf("Update error", err.Error())
+	}
+}
+
+// 查找规则包，多个版本时返回最新的
+func findPack(filter bson.M) (*BaselinePack, error) {
+	c := context.Background()
+	packCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselinePackColl)
+	findOption := options.FindOne().SetSort(bson.M{"update_time": -1})
+	pack := new(BaselinePack)
+	err := packCol.FindOne(c, filter, findOption).Decode(pack)
+	if err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// 获取基线最新的规则包并签名
+func getPack(baselineId int) (*BaselinePack, error) {
+	pack, err := findPack(bson.M{"baseline_id": baselineId})
+	if err != nil {
+		return nil, err
+	}
+	if err = signPack(pack); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// 获取基线指定版本的规则包并签名
+func getPackVersion(baselineId int, version string) (*BaselinePack, error) {
+	pack, err := findPack(bson.M{"baseline_id": baselineId, "version": version})
+	if err != nil {
+		return nil, err
+	}
+	if err = signPack(pack); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
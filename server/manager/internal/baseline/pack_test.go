@@ -0,0 +1,57 @@
+package baseline
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPackKey(t *testing.T) {
+	dir := t.TempDir()
+	// a missing key disables packs instead of failing the start
+	if _, err := loadPackKey(filepath.Join(dir, "missing.key")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loadPackKey() of a missing key error %v, want ErrNotExist", err)
+	}
+	if err := signPack(&BaselinePack{}); !errors.Is(err, errNoPackKey) {
+		t.Errorf("signPack() without key error %v, want errNoPackKey", err)
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "baseline_pack.key")
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadPackKey(keyPath)
+	if err != nil {
+		t.Fatalf("loadPackKey() error %v", err)
+	}
+
+	packKey = loaded
+	defer func() { packKey = nil }()
+	pack := &BaselinePack{BaselineId: 1200, Version: "1.0+3fa2b1c9d0e4", Content: "check_list: []\n", UpdateTime: 1700000000}
+	if err = signPack(pack); err != nil {
+		t.Fatalf("signPack() error %v", err)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(pack.Signature)
+	if !ed25519.Verify(pub, pack.message(), sig) {
+		t.Error("signature doesn't match")
+	}
+	// update_time is signed, so an old pack can't be replayed as a new one
+	pack.UpdateTime++
+	if ed25519.Verify(pub, pack.message(), sig) {
+		t.Error("signature matches a pack with another update_time")
+	}
+}
@@ -0,0 +1,172 @@
+package baseline
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// 简单的pdf文档，只支持文本，使用pdf阅读器内置的中文字体STSong-Light，
+// 不需要嵌入字体文件
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+	pdfLineGap    = 1.5
+)
+
+type pdfDoc struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func newPdfDoc() *pdfDoc {
+	d := &pdfDoc{}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDoc) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// 字符宽度，单位为字号，ascii为半角，其他为全角
+func pdfRuneWidth(r rune) float64 {
+	if r < 0x80 {
+		return 0.5
+	}
+	return 1
+}
+
+func pdfTextWidth(s string, size float64) float64 {
+	var width float64
+	for _, r := range s {
+		width += pdfRuneWidth(r)
+	}
+	return width * size
+}
+
+// 截断到指定宽度，末尾加省略号
+func pdfTruncate(s string, size, width float64) string {
+	if pdfTextWidth(s, size) <= width {
+		return s
+	}
+	ellipsis := pdfTextWidth("...", size)
+	var w float64
+	for i, r := range s {
+		w += pdfRuneWidth(r) * size
+		if w > width-ellipsis {
+			return s[:i] + "..."
+		}
+	}
+	return s
+}
+
+// 按宽度折行
+func pdfWrap(s string, size, width float64) []string {
+	lines := make([]string, 0)
+	start := 0
+	var w float64
+	for i, r := range s {
+		if r == '\n' {
+			lines = append(lines, s[start:i])
+			start, w = i+1, 0
+			continue
+		}
+		rw := pdfRuneWidth(r) * size
+		if w+rw > width && i > start {
+			lines = append(lines, s[start:i])
+			start, w = i, 0
+		}
+		w += rw
+	}
+	return append(lines, s[start:])
+}
+
+// 文本编码为UCS-2，超出BMP的字符替换为?
+func pdfHexText(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('<')
+	for _, r := range s {
+		if r > 0xFFFF || r == utf8.RuneError {
+			r = '?'
+		}
+		fmt.Fprintf(&buf, "%04X", r)
+	}
+	buf.WriteByte('>')
+	return buf.String()
+}
+
+func (d *pdfDoc) lineBreak(size float64) {
+	d.y -= size * pdfLineGap
+	if d.y < pdfMargin {
+		d.newPage()
+		d.y -= size * pdfLineGap
+	}
+}
+
+func (d *pdfDoc) draw(s string, size, x float64) {
+	fmt.Fprintf(d.pages[len(d.pages)-1], "BT /F1 %.1f Tf %.1f %.1f Td %s Tj ET\n", size, x, d.y, pdfHexText(s))
+}
+
+// Text 输出一段文本，超出页宽时折行
+func (d *pdfDoc) Text(s string, size float64) {
+	for _, line := range pdfWrap(s, size, pdfPageWidth-2*pdfMargin) {
+		d.lineBreak(size)
+		d.draw(line, size, pdfMargin)
+	}
+}
+
+// Row 输出表格的一行，widths为各列宽度占页宽的比例，超出列宽的内容被截断
+func (d *pdfDoc) Row(cols []string, widths []float64, size float64) {
+	d.lineBreak(size)
+	x := pdfMargin
+	for i, col := range cols {
+		width := widths[i] * (pdfPageWidth - 2*pdfMargin)
+		d.draw(pdfTruncate(col, size, width), size, x)
+		x += width
+	}
+}
+
+// Gap 空行
+func (d *pdfDoc) Gap(size float64) {
+	d.lineBreak(size)
+}
+
+// Bytes 生成pdf文件
+func (d *pdfDoc) Bytes() []byte {
+	var buf bytes.Buffer
+	offsets := make([]int, 0)
+	writeObj := func(obj string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), obj)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	// 1: catalog, 2: pages, 3-5: 字体, 之后每页依次为page及content
+	kids := new(bytes.Buffer)
+	for i := range d.pages {
+		fmt.Fprintf(kids, "%d 0 R ", 6+2*i)
+	}
+	writeObj("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(d.pages)))
+	writeObj("<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>")
+	writeObj("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>")
+	writeObj("<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+		"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>")
+	for i, page := range d.pages {
+		writeObj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 7+2*i))
+		writeObj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
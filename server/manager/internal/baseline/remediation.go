@@ -0,0 +1,514 @@
+package baseline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/def"
+	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"github.com/bytedance/Elkeid/server/manager/internal/atask"
+	"github.com/bytedance/Elkeid/server/manager/internal/dbtask"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	BaselineRemediationDataType = 8020
+	// 修复超时时间
+	remediationTimeout = 600
+	// sysctl修复持久化的文件，与插件一致
+	remediationSysctlConf = "/etc/sysctl.d/99-elkeid-baseline.conf"
+
+	RemediationStatusPending  = "pending"
+	RemediationStatusRejected = "rejected"
+	RemediationStatusRunning  = "running"
+	RemediationStatusSuccess  = "success"
+	RemediationStatusFailed   = "failed"
+
+	// 审计操作
+	RemediationAuditRequest = "request"
+	RemediationAuditApprove = "approve"
+	RemediationAuditReject  = "reject"
+	RemediationAuditFinish  = "finish"
+)
+
+// 修复动作，与baseline插件的remediate.Action一致
+type RemediationAction struct {
+	Type  string   `json:"type" yaml:"type" bson:"type"`
+	Param []string `json:"param" yaml:"param" bson:"param"`
+}
+
+// 检查项的修复动作
+type RemediationCheck struct {
+	CheckId int                 `json:"check_id" bson:"check_id"`
+	Title   string              `json:"title" bson:"title"`
+	TitleCn string              `json:"title_cn" bson:"title_cn"`
+	Actions []RemediationAction `json:"actions" bson:"actions"`
+}
+
+// 修复申请，审批通过后下发给主机执行
+type Remediation struct {
+	RemediationId string `json:"remediation_id" bson:"remediation_id"`
+	AgentId       string `json:"agent_id" bson:"agent_id"`
+	Hostname      string `json:"hostname" bson:"hostname"`
+	BaselineId    int    `json:"baseline_id" bson:"baseline_id"`
+	CheckIdList   []int  `json:"check_id_list" bson:"check_id_list"`
+	// 申请时的规则包版本及修复动作，审批通过后下发同一版本的规则包
+	PackVersion string             `json:"pack_version" bson:"pack_version"`
+	CheckList   []RemediationCheck `json:"check_list" bson:"check_list"`
+	// 回滚申请，rollback_of为被回滚的修复申请
+	Rollback   bool   `json:"rollback" bson:"rollback"`
+	RollbackOf string `json:"rollback_of" bson:"rollback_of"`
+
+	Status     string      `json:"status" bson:"status"`
+	StatusMsg  string      `json:"status_msg" bson:"status_msg"`
+	Applicant  string      `json:"applicant" bson:"applicant"`
+	Reason     string      `json:"reason" bson:"reason"`
+	Approver   string      `json:"approver" bson:"approver"`
+	Comment    string      `json:"comment" bson:"comment"`
+	TaskId     string      `json:"task_id" bson:"task_id"`
+	Result     interface{} `json:"result" bson:"result"`
+	CreateTime int64       `json:"create_time" bson:"create_time"`
+	UpdateTime int64       `json:"update_time" bson:"update_time"`
+}
+
+// 主机修复审计记录
+type RemediationAudit struct {
+	AgentId       string `json:"agent_id" bson:"agent_id"`
+	RemediationId string `json:"remediation_id" bson:"remediation_id"`
+	BaselineId    int    `json:"baseline_id" bson:"baseline_id"`
+	CheckIdList   []int  `json:"check_id_list" bson:"check_id_list"`
+	PackVersion   string `json:"pack_version" bson:"pack_version"`
+	Rollback      bool   `json:"rollback" bson:"rollback"`
+	Action        string `json:"action" bson:"action"`
+	User          string `json:"user" bson:"user"`
+	Status        string `json:"status" bson:"status"`
+	Msg           string `json:"msg" bson:"msg"`
+	Time          int64  `json:"time" bson:"time"`
+}
+
+var (
+	remediationParamNum = map[string][2]int{
+		"set_key":         {3, 4},
+		"chmod":           {2, 2},
+		"chown":           {2, 2},
+		"sysctl":          {2, 2},
+		"service_disable": {1, 1},
+	}
+	chmodReg  = regexp.MustCompile(`^[0-7]{3,4}$`)
+	sysctlReg = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+
+	ErrRemediationNotFind = errors.New("remediation not find")
+)
+
+func init() {
+	// 8010任务状态中携带修复结果时更新修复申请
+	atask.RegistryResFunc("8010", remediationResFunc)
+}
+
+// validateRemediation 校验修复动作，规则与插件一致
+func validateRemediation(action RemediationAction) string {
+	num, ok := remediationParamNum[action.Type]
+	if !ok {
+		return fmt.Sprintf("unknown remediation type %s", action.Type)
+	}
+	if len(action.Param) < num[0] || len(action.Param) > num[1] {
+		return fmt.Sprintf("%s needs %d to %d params", action.Type, num[0], num[1])
+	}
+	switch action.Type {
+	case "set_key", "chmod", "chown":
+		if !filepath.IsAbs(action.Param[0]) {
+			return fmt.Sprintf("%s isn't an absolute path", action.Param[0])
+		}
+	}
+	switch action.Type {
+	case "set_key":
+		if action.Param[1] == "" || strings.ContainsAny(action.Param[1]+action.Param[2], "\n") {
+			return "set_key needs a key and a value in one line"
+		}
+	case "chmod":
+		if !chmodReg.MatchString(action.Param[1]) {
+			return fmt.Sprintf("%s isn't an octal mode", action.Param[1])
+		}
+	case "chown":
+		if !fileUserGroupReg.MatchString(action.Param[1]) {
+			return fmt.Sprintf("%s isn't uid:gid", action.Param[1])
+		}
+	case "sysctl":
+		if !sysctlReg.MatchString(action.Param[0]) || strings.Contains(action.Param[0], "..") {
+			return fmt.Sprintf("%s isn't a sysctl key", action.Param[0])
+		}
+	case "service_disable":
+		if action.Param[0] == "" || strings.Contains(action.Param[0], "/") {
+			return fmt.Sprintf("%s isn't a service", action.Param[0])
+		}
+	}
+	return ""
+}
+
+func remediationCol() *mongo.Collection {
+	return infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineRemediationColl)
+}
+
+// 写入审计记录
+func auditRemediation(remediation *Remediation, action, user, msg string) {
+	audit := RemediationAudit{
+		AgentId:       remediation.AgentId,
+		RemediationId: remediation.RemediationId,
+		BaselineId:    remediation.BaselineId,
+		CheckIdList:   remediation.CheckIdList,
+		PackVersion:   remediation.PackVersion,
+		Rollback:      remediation.Rollback,
+		Action:        action,
+		User:          user,
+		Status:        remediation.Status,
+		Msg:           msg,
+		Time:          time.Now().Unix(),
+	}
+	auditCol := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.BaselineRemediationAuditColl)
+	_, err := auditCol.InsertOne(context.Background(), audit)
+	if err != nil {
+		ylog.Errorf("auditRemediation", err.Error())
+	}
+}
+
+// 从基线最新的规则包中获取检查项的修复动作，检查项均需配置修复动作
+func resolveRemediation(baselineId int, checkIdList []int) (string, []RemediationCheck, error) {
+	pack, err := findPack(bson.M{"baseline_id": baselineId})
+	if err != nil {
+		return "", nil, fmt.Errorf("get pack of baseline %d: %w", baselineId, err)
+	}
+	var baselineInfo BaselineInfo_config
+	if err = yaml.Unmarshal([]byte(pack.Content), &baselineInfo); err != nil {
+		return "", nil, err
+	}
+	checkMap := make(map[int]CheckInfo_config, len(baselineInfo.CheckList))
+	for _, checkInfo := range baselineInfo.CheckList {
+		checkMap[checkInfo.CheckId] = checkInfo
+	}
+	checkList := make([]RemediationCheck, 0, len(checkIdList))
+	for _, checkId := range checkIdList {
+		checkInfo, ok := checkMap[checkId]
+		if !ok || len(checkInfo.Remediation) == 0 {
+			return "", nil, fmt.Errorf("check %d of baseline %d has no remediation", checkId, baselineId)
+		}
+		checkList = append(checkList, RemediationCheck{
+			CheckId: checkId,
+			Title:   checkInfo.Title,
+			TitleCn: checkInfo.TitleCn,
+			Actions: checkInfo.Remediation,
+		})
+	}
+	return pack.Version, checkList, nil
+}
+
+// CreateRemediation 申请修复主机上的检查项，需要其他用户审批
+func CreateRemediation(agentId string, baselineId int, checkIdList []int, applicant, reason string) (*Remediation, error) {
+	if agentId == "" || len(checkIdList) == 0 {
+		return nil, errors.New("remediation needs agent_id and check_id_list")
+	}
+	packVersion, checkList, err := resolveRemediation(baselineId, checkIdList)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	remediation := &Remediation{
+		RemediationId: uuid.NewString(),
+		AgentId:       agentId,
+		Hostname:      dbtask.AgentInfoSearch(agentId).Hostname,
+		BaselineId:    baselineId,
+		CheckIdList:   checkIdList,
+		PackVersion:   packVersion,
+		CheckList:     checkList,
+		Status:        RemediationStatusPending,
+		Applicant:     applicant,
+		Reason:        reason,
+		CreateTime:    now,
+		UpdateTime:    now,
+	}
+	_, err = remediationCol().InsertOne(context.Background(), remediation)
+	if err != nil {
+		return nil, err
+	}
+	auditRemediation(remediation, RemediationAuditRequest, applicant, reason)
+	return remediation, nil
+}
+
+// 修复动作修改的对象：文件、sysctl或服务
+func remediationTargets(checkList []RemediationCheck) map[string]bool {
+	targets := make(map[string]bool)
+	for _, check := range checkList {
+		for _, action := range check.Actions {
+			if len(action.Param) == 0 {
+				continue
+			}
+			switch action.Type {
+			case "sysctl":
+				targets["sysctl:"+action.Param[0]] = true
+				targets[remediationSysctlConf] = true
+			case "service_disable":
+				targets["service:"+action.Param[0]] = true
+			default:
+				targets[action.Param[0]] = true
+			}
+		}
+	}
+	return targets
+}
+
+// 回滚会恢复整个文件，之后执行过且修改相同对象的修复需要先回滚
+func checkLaterRemediation(origin *Remediation) error {
+	c := context.Background()
+	cur, err := remediationCol().Find(c, bson.M{
+		"agent_id":       origin.AgentId,
+		"rollback":       false,
+		"remediation_id": bson.M{"$ne": origin.RemediationId},
+		"create_time":    bson.M{"$gte": origin.CreateTime},
+		"status":         bson.M{"$in": []string{RemediationStatusRunning, RemediationStatusSuccess, RemediationStatusFailed}},
+	})
+	if err != nil {
+		return err
+	}
+	var laterList []Remediation
+	if err = cur.All(c, &laterList); err != nil {
+		return err
+	}
+	targets := remediationTargets(origin.CheckList)
+	for _, later := range laterList {
+		conflict := len(origin.CheckList) == 0 || len(later.CheckList) == 0
+		for target := range remediationTargets(later.CheckList) {
+			if targets[target] {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			continue
+		}
+		num, err := remediationCol().CountDocuments(c, bson.M{"rollback_of": later.RemediationId, "status": RemediationStatusSuccess})
+		if err != nil {
+			return err
+		}
+		if num == 0 {
+			return fmt.Errorf("remediation %s changes the same targets later, roll it back first", later.RemediationId)
+		}
+	}
+	return nil
+}
+
+// CreateRollback 申请回滚一次已执行的修复，同样需要审批
+func CreateRollback(remediationId, applicant, reason string) (*Remediation, error) {
+	c := context.Background()
+	origin, err := GetRemediation(remediationId)
+	if err != nil {
+		return nil, err
+	}
+	if origin.Rollback || (origin.Status != RemediationStatusSuccess && origin.Status != RemediationStatusFailed) {
+		return nil, fmt.Errorf("remediation %s can't be rolled back in status %s", remediationId, origin.Status)
+	}
+	num, err := remediationCol().CountDocuments(c, bson.M{"rollback_of": remediationId,
+		"status": bson.M{"$in": []string{RemediationStatusPending, RemediationStatusRunning, RemediationStatusSuccess}}})
+	if err != nil {
+		return nil, err
+	}
+	if num != 0 {
+		return nil, fmt.Errorf("remediation %s is being rolled back", remediationId)
+	}
+	if err = checkLaterRemediation(origin); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	remediation := &Remediation{
+		RemediationId: uuid.NewString(),
+		AgentId:       origin.AgentId,
+		Hostname:      origin.Hostname,
+		BaselineId:    origin.BaselineId,
+		CheckIdList:   origin.CheckIdList,
+		PackVersion:   origin.PackVersion,
+		CheckList:     origin.CheckList,
+		Rollback:      true,
+		RollbackOf:    remediationId,
+		Status:        RemediationStatusPending,
+		Applicant:     applicant,
+		Reason:        reason,
+		CreateTime:    now,
+		UpdateTime:    now,
+	}
+	_, err = remediationCol().InsertOne(c, remediation)
+	if err != nil {
+		return nil, err
+	}
+	auditRemediation(remediation, RemediationAuditRequest, applicant, reason)
+	return remediation, nil
+}
+
+// GetRemediation 获取修复申请，超时的修复置为失败
+func GetRemediation(remediationId string) (*Remediation, error) {
+	c := context.Background()
+	remediation := new(Remediation)
+	err := remediationCol().FindOne(c, bson.M{"remediation_id": remediationId}).Decode(remediation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRemediationNotFind
+		}
+		return nil, err
+	}
+	if remediation.Status == RemediationStatusRunning && time.Now().Unix()-remediation.UpdateTime > remediationTimeout {
+		finishRemediation(remediation, RemediationStatusFailed, "remediation timeout", nil)
+	}
+	return remediation, nil
+}
+
+// ApproveRemediation 审批修复申请，通过后下发修复任务，申请人不能审批自己的申请
+func ApproveRemediation(remediationId, approver string, approve bool, comment string) (*Remediation, error) {
+	c := context.Background()
+	remediation, err := GetRemediation(remediationId)
+	if err != nil {
+		return nil, err
+	}
+	if remediation.Status != RemediationStatusPending {
+		return nil, fmt.Errorf("remediation %s is %s", remediationId, remediation.Status)
+	}
+	if remediation.Applicant == approver {
+		return nil, errors.New("the applicant can't approve the remediation")
+	}
+
+	remediation.Approver = approver
+	remediation.Comment = comment
+	remediation.UpdateTime = time.Now().Unix()
+	if !approve {
+		remediation.Status = RemediationStatusRejected
+		_, err = remediationCol().UpdateOne(c, bson.M{"remediation_id": remediationId, "status": RemediationStatusPending},
+			bson.M{"$set": bson.M{"status": remediation.Status, "approver": approver, "comment": comment, "update_time": remediation.UpdateTime}})
+		if err != nil {
+			return nil, err
+		}
+		auditRemediation(remediation, RemediationAuditReject, approver, comment)
+		return remediation, nil
+	}
+
+	// 先置为执行中，避免重复审批下发
+	res, err := remediationCol().UpdateOne(c, bson.M{"remediation_id": remediationId, "status": RemediationStatusPending},
+		bson.M{"$set": bson.M{"status": RemediationStatusRunning, "approver": approver, "comment": comment, "update_time": remediation.UpdateTime}})
+	if err != nil {
+		return nil, err
+	}
+	if res.ModifiedCount == 0 {
+		return nil, fmt.Errorf("remediation %s isn't pending", remediationId)
+	}
+	remediation.Status = RemediationStatusRunning
+	auditRemediation(remediation, RemediationAuditApprove, approver, comment)
+
+	taskId, err := sendRemediationTask(remediation)
+	if err != nil {
+		finishRemediation(remediation, RemediationStatusFailed, err.Error(), nil)
+		return remediation, nil
+	}
+	remediation.TaskId = taskId
+	_, err = remediationCol().UpdateOne(c, bson.M{"remediation_id": remediationId}, bson.M{"$set": bson.M{"task_id": taskId}})
+	if err != nil {
+		ylog.Errorf("ApproveRemediation", err.Error())
+	}
+	return remediation, nil
+}
+
+// 下发申请时版本的规则包及修复任务，回滚时使用被回滚的修复id，插件按id查找备份
+func sendRemediationTask(remediation *Remediation) (string, error) {
+	pack, err := getPackVersion(remediation.BaselineId, remediation.PackVersion)
+	if err != nil {
+		return "", fmt.Errorf("get pack %s of baseline %d: %w", remediation.PackVersion, remediation.BaselineId, err)
+	}
+	remediationId := remediation.RemediationId
+	if remediation.Rollback {
+		remediationId = remediation.RollbackOf
+	}
+	taskData, err := json.Marshal(struct {
+		BaselineID      int           `json:"baseline_id"`
+		BaseLineVersion string        `json:"baseline_version"`
+		CheckIdList     []int         `json:"check_id_list"`
+		Pack            *BaselinePack `json:"pack"`
+		RemediationId   string        `json:"remediation_id"`
+		Rollback        bool          `json:"rollback"`
+	}{
+		BaselineID:      remediation.BaselineId,
+		BaseLineVersion: pack.Version,
+		CheckIdList:     remediation.CheckIdList,
+		Pack:            pack,
+		RemediationId:   remediationId,
+		Rollback:        remediation.Rollback,
+	})
+	if err != nil {
+		return "", err
+	}
+	taskMsg := def.AgentTaskMsg{
+		Name:     "baseline",
+		Data:     string(taskData),
+		DataType: BaselineRemediationDataType,
+	}
+	return atask.SendFastTask(remediation.AgentId, &taskMsg, true, remediationTimeout,
+		map[string]interface{}{"remediation_id": remediation.RemediationId})
+}
+
+// 更新修复结果并写入审计记录
+func finishRemediation(remediation *Remediation, status, msg string, result interface{}) {
+	remediation.Status = status
+	remediation.StatusMsg = msg
+	remediation.Result = result
+	remediation.UpdateTime = time.Now().Unix()
+	res, err := remediationCol().UpdateOne(context.Background(),
+		bson.M{"remediation_id": remediation.RemediationId, "status": RemediationStatusRunning},
+		bson.M{"$set": bson.M{"status": status, "status_msg": msg, "result": result, "update_time": remediation.UpdateTime}})
+	if err != nil {
+		ylog.Errorf("finishRemediation", err.Error())
+		return
+	}
+	if res.ModifiedCount != 0 {
+		auditRemediation(remediation, RemediationAuditFinish, "", msg)
+	}
+}
+
+// 插件通过8010任务状态回传修复前后的检查结果
+func remediationResFunc(data map[string]interface{}) {
+	atask.DefaultResFunc(data)
+
+	dataStr, ok := data["data"].(string)
+	if !ok || !strings.Contains(dataStr, "remediation_id") {
+		return
+	}
+	var result struct {
+		RemediationId string `json:"remediation_id"`
+		Rollback      bool   `json:"rollback"`
+	}
+	var resultData interface{}
+	if json.Unmarshal([]byte(dataStr), &result) != nil || result.RemediationId == "" {
+		return
+	}
+	_ = json.Unmarshal([]byte(dataStr), &resultData)
+
+	filter := bson.M{"remediation_id": result.RemediationId, "status": RemediationStatusRunning}
+	if result.Rollback {
+		filter = bson.M{"rollback_of": result.RemediationId, "status": RemediationStatusRunning}
+	}
+	remediation := new(Remediation)
+	err := remediationCol().FindOne(context.Background(), filter).Decode(remediation)
+	if err != nil {
+		ylog.Errorf("remediationResFunc", err.Error())
+		return
+	}
+	status := RemediationStatusSuccess
+	if s, _ := data["status"].(string); s != "succeed" {
+		status = RemediationStatusFailed
+	}
+	msg, _ := data["msg"].(string)
+	finishRemediation(remediation, status, msg, resultData)
+}
@@ -0,0 +1,385 @@
+package baseline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/Elkeid/server/manager/infra"
+	"github.com/bytedance/Elkeid/server/manager/infra/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+const (
+	ReportFormatPdf  = "pdf"
+	ReportFormatHtml = "html"
+	ReportFormatCsv  = "csv"
+)
+
+// 合规报告中的检查项及其主机数
+type ReportControl struct {
+	Control    string `json:"control"`
+	BaselineId int64  `json:"baseline_id"`
+	CheckId    int64  `json:"check_id"`
+	Title      string `json:"title"`
+	Security   string `json:"security"`
+	PassNum    int64  `json:"pass_num"`
+	RiskNum    int64  `json:"risk_num"`
+	WhiteNum   int64  `json:"white_num"`
+}
+
+// 合规报告中的例外，即加白的未通过项及加白原因
+type ReportException struct {
+	AgentId    string `json:"agent_id"`
+	Hostname   string `json:"hostname"`
+	BaselineId int64  `json:"baseline_id"`
+	CheckId    int64  `json:"check_id"`
+	Title      string `json:"title"`
+	Reason     string `json:"reason"`
+}
+
+// 单个框架的合规报告
+type ComplianceReport struct {
+	Framework  Framework         `json:"framework"`
+	Lang       string            `json:"lang"`
+	CreateTime int64             `json:"create_time"`
+	Score      ComplianceScore   `json:"score"`
+	HostScores []ComplianceScore `json:"host_scores"`
+	Controls   []ReportControl   `json:"controls"`
+	Exceptions []ReportException `json:"exceptions"`
+}
+
+// 报告文字，lang为en时使用英文
+var reportLabels = map[string]map[string]string{
+	"zh": {
+		"title": "合规报告", "create_time": "生成时间", "score": "合规得分", "host_num": "主机数",
+		"pass_num": "通过", "risk_num": "未通过", "white_num": "例外", "hosts": "主机得分",
+		"controls": "条款及检查项", "exceptions": "例外", "control": "条款", "check": "检查项",
+		"security": "等级", "host": "主机", "agent_id": "Agent ID", "reason": "加白原因",
+	},
+	"en": {
+		"title": "Compliance Report", "create_time": "Created at", "score": "Score", "host_num": "Hosts",
+		"pass_num": "Passed", "risk_num": "Failed", "white_num": "Exceptions", "hosts": "Host scores",
+		"controls": "Controls and checks", "exceptions": "Exceptions", "control": "Control", "check": "Check",
+		"security": "Level", "host": "Host", "agent_id": "Agent ID", "reason": "Justification",
+	},
+}
+
+func (r *ComplianceReport) label(key string) string {
+	if labels, ok := reportLabels[r.Lang]; ok {
+		return labels[key]
+	}
+	return reportLabels["zh"][key]
+}
+
+func (r *ComplianceReport) frameworkName() string {
+	if r.Lang == "en" {
+		return r.Framework.NameEn
+	}
+	return r.Framework.Name
+}
+
+// 条款排序，按点分隔的数字比较，如8.3.6 < 8.3.10
+func lessControl(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			if an != bn {
+				return an < bn
+			}
+		} else if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func checkTitle(checkInfo CheckInfo, lang string) string {
+	if lang == "en" && checkInfo.Title != "" {
+		return checkInfo.Title
+	}
+	if checkInfo.TitleCn != "" {
+		return checkInfo.TitleCn
+	}
+	return checkInfo.Title
+}
+
+// 生成单个框架的报告
+func (calc *complianceCalc) report(framework Framework, lang string) *ComplianceReport {
+	report := &ComplianceReport{
+		Framework:  framework,
+		Lang:       lang,
+		HostScores: make([]ComplianceScore, 0),
+		Controls:   make([]ReportControl, 0),
+		Exceptions: make([]ReportException, 0),
+	}
+	agentIdList := make([]string, 0, len(calc.hosts))
+	for agentId := range calc.hosts {
+		agentIdList = append(agentIdList, agentId)
+	}
+	sort.Strings(agentIdList)
+	for _, agentId := range agentIdList {
+		if hostScore, ok := calc.hostScore(calc.hosts[agentId], framework.Id); ok {
+			report.HostScores = append(report.HostScores, hostScore)
+		}
+	}
+	report.Score = meanScore(ScopeFleet, "", framework.Id, report.HostScores)
+
+	for key, res := range calc.checkRes {
+		control, ok := calc.frameworks[key][framework.Id]
+		if !ok {
+			continue
+		}
+		checkInfo := calc.checks[key]
+		report.Controls = append(report.Controls, ReportControl{
+			Control:    control,
+			BaselineId: key.BaselineId,
+			CheckId:    key.CheckId,
+			Title:      checkTitle(checkInfo, lang),
+			Security:   checkInfo.Security,
+			PassNum:    res.PassNum,
+			RiskNum:    res.RiskNum,
+			WhiteNum:   res.WhiteNum,
+		})
+	}
+	sort.Slice(report.Controls, func(i, j int) bool {
+		a, b := report.Controls[i], report.Controls[j]
+		if a.Control != b.Control {
+			return lessControl(a.Control, b.Control)
+		}
+		if a.BaselineId != b.BaselineId {
+			return a.BaselineId < b.BaselineId
+		}
+		return a.CheckId < b.CheckId
+	})
+
+	for _, item := range calc.exceptions {
+		key := checkKey{BaselineId: item.BaselineId, CheckId: item.CheckId}
+		if _, ok := calc.frameworks[key][framework.Id]; !ok {
+			continue
+		}
+		report.Exceptions = append(report.Exceptions, ReportException{
+			AgentId:    item.AgentId,
+			Hostname:   item.Hostname,
+			BaselineId: item.BaselineId,
+			CheckId:    item.CheckId,
+			Title:      checkTitle(calc.checks[key], lang),
+			Reason:     item.WhiteReason,
+		})
+	}
+	sort.Slice(report.Exceptions, func(i, j int) bool {
+		a, b := report.Exceptions[i], report.Exceptions[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		if a.BaselineId != b.BaselineId {
+			return a.BaselineId < b.BaselineId
+		}
+		return a.CheckId < b.CheckId
+	})
+	return report
+}
+
+// BuildComplianceReport 根据当前的基线检查结果生成合规报告，可按主机或主机标签筛选
+func BuildComplianceReport(frameworkId string, agentIdList []string, tag string, lang string) (*ComplianceReport, error) {
+	framework, ok := GetFramework(frameworkId)
+	if !ok {
+		return nil, fmt.Errorf("unsupported compliance framework %s", frameworkId)
+	}
+	filter := bson.M{}
+	if len(agentIdList) != 0 {
+		filter["agent_id"] = bson.M{"$in": agentIdList}
+	}
+	if tag != "" {
+		filter["tags"] = tag
+	}
+	calc, err := calcuCompliance(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	report := calc.report(framework, lang)
+	report.CreateTime = time.Now().Unix()
+	return report, nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', 1, 64)
+}
+
+func (r *ComplianceReport) createTime() string {
+	return time.Unix(r.CreateTime, 0).Format("2006-01-02 15:04:05")
+}
+
+// 导出csv，依次为得分、主机得分、条款及例外
+func renderReportCsv(w io.Writer, r *ComplianceReport) error {
+	_, _ = w.Write([]byte{0xEF, 0xBB, 0xBF})
+	csvWriter := csv.NewWriter(w)
+	records := [][]string{
+		{r.label("title"), r.frameworkName()},
+		{r.label("create_time"), r.createTime()},
+		{r.label("score"), formatScore(r.Score.Score)},
+		{r.label("host_num"), strconv.FormatInt(r.Score.HostNum, 10)},
+		{},
+		{r.label("hosts")},
+		{r.label("agent_id"), r.label("host"), r.label("score"), r.label("pass_num"), r.label("risk_num"), r.label("white_num")},
+	}
+	for _, s := range r.HostScores {
+		records = append(records, []string{s.ScopeId, s.Hostname, formatScore(s.Score),
+			strconv.FormatInt(s.PassNum, 10), strconv.FormatInt(s.RiskNum, 10), strconv.FormatInt(s.WhiteNum, 10)})
+	}
+	records = append(records, []string{}, []string{r.label("controls")},
+		[]string{r.label("control"), "baseline_id", "check_id", r.label("check"), r.label("security"),
+			r.label("pass_num"), r.label("risk_num"), r.label("white_num")})
+	for _, ctl := range r.Controls {
+		records = append(records, []string{ctl.Control, strconv.FormatInt(ctl.BaselineId, 10), strconv.FormatInt(ctl.CheckId, 10),
+			ctl.Title, ctl.Security, strconv.FormatInt(ctl.PassNum, 10), strconv.FormatInt(ctl.RiskNum, 10), strconv.FormatInt(ctl.WhiteNum, 10)})
+	}
+	records = append(records, []string{}, []string{r.label("exceptions")},
+		[]string{r.label("agent_id"), r.label("host"), "baseline_id", "check_id", r.label("check"), r.label("reason")})
+	for _, e := range r.Exceptions {
+		records = append(records, []string{e.AgentId, e.Hostname, strconv.FormatInt(e.BaselineId, 10),
+			strconv.FormatInt(e.CheckId, 10), e.Title, e.Reason})
+	}
+	if err := csvWriter.WriteAll(records); err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"score": formatScore,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} {{.Label.title}}</title>
+<style>
+body { font-family: sans-serif; margin: 32px; color: #1d2129; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 24px; }
+th, td { border: 1px solid #e5e6eb; padding: 6px 8px; text-align: left; font-size: 13px; }
+th { background: #f2f3f5; }
+.score { font-size: 32px; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.Name}} {{.Label.title}}</h1>
+<p>{{.Label.create_time}}: {{.CreateTime}}</p>
+<p>{{.Label.score}}: <span class="score">{{score .Report.Score.Score}}</span></p>
+<p>{{.Label.host_num}}: {{.Report.Score.HostNum}}, {{.Label.pass_num}}: {{.Report.Score.PassNum}}, {{.Label.risk_num}}: {{.Report.Score.RiskNum}}, {{.Label.white_num}}: {{.Report.Score.WhiteNum}}</p>
+<h2>{{.Label.hosts}}</h2>
+<table>
+<tr><th>{{.Label.agent_id}}</th><th>{{.Label.host}}</th><th>{{.Label.score}}</th><th>{{.Label.pass_num}}</th><th>{{.Label.risk_num}}</th><th>{{.Label.white_num}}</th></tr>
+{{- range .Report.HostScores}}
+<tr><td>{{.ScopeId}}</td><td>{{.Hostname}}</td><td>{{score .Score}}</td><td>{{.PassNum}}</td><td>{{.RiskNum}}</td><td>{{.WhiteNum}}</td></tr>
+{{- end}}
+</table>
+<h2>{{.Label.controls}}</h2>
+<table>
+<tr><th>{{.Label.control}}</th><th>{{.Label.check}}</th><th>{{.Label.security}}</th><th>{{.Label.pass_num}}</th><th>{{.Label.risk_num}}</th><th>{{.Label.white_num}}</th></tr>
+{{- range .Report.Controls}}
+<tr><td>{{.Control}}</td><td>{{.Title}}</td><td>{{.Security}}</td><td>{{.PassNum}}</td><td>{{.RiskNum}}</td><td>{{.WhiteNum}}</td></tr>
+{{- end}}
+</table>
+<h2>{{.Label.exceptions}}</h2>
+<table>
+<tr><th>{{.Label.host}}</th><th>{{.Label.check}}</th><th>{{.Label.reason}}</th></tr>
+{{- range .Report.Exceptions}}
+<tr><td>{{.Hostname}}</td><td>{{.Title}}</td><td>{{.Reason}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func renderReportHtml(w io.Writer, r *ComplianceReport) error {
+	labels, ok := reportLabels[r.Lang]
+	if !ok {
+		labels = reportLabels["zh"]
+	}
+	return reportTemplate.Execute(w, map[string]interface{}{
+		"Name":       r.frameworkName(),
+		"Label":      labels,
+		"CreateTime": r.createTime(),
+		"Report":     r,
+	})
+}
+
+func renderReportPdf(w io.Writer, r *ComplianceReport) error {
+	doc := newPdfDoc()
+	doc.Text(r.frameworkName()+" "+r.label("title"), 18)
+	doc.Gap(10)
+	doc.Text(r.label("create_time")+": "+r.createTime(), 10)
+	doc.Text(fmt.Sprintf("%s: %s    %s: %d    %s: %d    %s: %d    %s: %d", r.label("score"), formatScore(r.Score.Score),
+		r.label("host_num"), r.Score.HostNum, r.label("pass_num"), r.Score.PassNum,
+		r.label("risk_num"), r.Score.RiskNum, r.label("white_num"), r.Score.WhiteNum), 10)
+
+	doc.Gap(10)
+	doc.Text(r.label("hosts"), 14)
+	widths := []float64{0.45, 0.15, 0.13, 0.13, 0.14}
+	doc.Row([]string{r.label("host"), r.label("score"), r.label("pass_num"), r.label("risk_num"), r.label("white_num")}, widths, 9)
+	for _, s := range r.HostScores {
+		doc.Row([]string{s.Hostname, formatScore(s.Score), strconv.FormatInt(s.PassNum, 10),
+			strconv.FormatInt(s.RiskNum, 10), strconv.FormatInt(s.WhiteNum, 10)}, widths, 9)
+	}
+
+	doc.Gap(10)
+	doc.Text(r.label("controls"), 14)
+	widths = []float64{0.1, 0.5, 0.08, 0.1, 0.11, 0.11}
+	doc.Row([]string{r.label("control"), r.label("check"), r.label("security"),
+		r.label("pass_num"), r.label("risk_num"), r.label("white_num")}, widths, 9)
+	for _, ctl := range r.Controls {
+		doc.Row([]string{ctl.Control, ctl.Title, ctl.Security, strconv.FormatInt(ctl.PassNum, 10),
+			strconv.FormatInt(ctl.RiskNum, 10), strconv.FormatInt(ctl.WhiteNum, 10)}, widths, 9)
+	}
+
+	// 例外的加白原因可能较长，不截断
+	doc.Gap(10)
+	doc.Text(r.label("exceptions"), 14)
+	for _, e := range r.Exceptions {
+		doc.Text(fmt.Sprintf("%s - %s", e.Hostname, e.Title), 9)
+		doc.Text(r.label("reason")+": "+e.Reason, 9)
+	}
+	_, err := w.Write(doc.Bytes())
+	return err
+}
+
+// RenderComplianceReport 按格式生成报告文件
+func RenderComplianceReport(w io.Writer, r *ComplianceReport, format string) error {
+	switch format {
+	case ReportFormatPdf:
+		return renderReportPdf(w, r)
+	case ReportFormatHtml:
+		return renderReportHtml(w, r)
+	case ReportFormatCsv:
+		return renderReportCsv(w, r)
+	}
+	return fmt.Errorf("unsupported report format %s", format)
+}
+
+// SaveComplianceReport 生成报告文件并保存到gridfs，返回文件名，通过/shared/Download下载
+func SaveComplianceReport(r *ComplianceReport, format string) (string, error) {
+	var buf bytes.Buffer
+	if err := RenderComplianceReport(&buf, r, format); err != nil {
+		return "", err
+	}
+	bucket, err := gridfs.NewBucket(infra.MongoClient.Database(infra.MongoDatabase))
+	if err != nil {
+		return "", err
+	}
+	fileName := fmt.Sprintf("compliance-report-%s-%d-%s.%s",
+		r.Framework.Id, time.Now().UnixNano(), utils.GenerateRandomString(8), format)
+	if _, err = bucket.UploadFromStream(fileName, &buf); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
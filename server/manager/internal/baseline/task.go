@@ -3,6 +3,7 @@ package baseline
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -217,14 +218,27 @@ func StartCheck(checkRequest CheckRequest) (err error) {
 				ylog.Errorf("BulkWrite error", err.Error())
 			}
 		} else {
+			// 下发签名的规则包，获取失败时插件使用自带的配置
+			baselineVersion := DefaultBaseLineVersion
+			pack, err := getPack(baselineId)
+			if err != nil {
+				if !errors.Is(err, errNoPackKey) {
+					ylog.Errorf("getPack error", err.Error())
+				}
+				pack = nil
+			} else {
+				baselineVersion = pack.Version
+			}
 			taskData, _ := json.Marshal(struct {
-				BaselineID      int    `json:"baseline_id"`
-				BaseLineVersion string `json:"baseline_version"`
-				CheckIdList     []int  `json:"check_id_list"`
+				BaselineID      int           `json:"baseline_id"`
+				BaseLineVersion string        `json:"baseline_version"`
+				CheckIdList     []int         `json:"check_id_list"`
+				Pack            *BaselinePack `json:"pack,omitempty"`
 			}{
 				BaselineID:      baselineId,
-				BaseLineVersion: DefaultBaseLineVersion,
+				BaseLineVersion: baselineVersion,
 				CheckIdList:     baselineCheckList,
+				Pack:            pack,
 			})
 
 			taskMsg := def.AgentTaskMsg{
@@ -333,6 +347,12 @@ func StartCheck(checkRequest CheckRequest) (err error) {
 	return
 }
 
+// 容器基线及其对应的容器组件，与collector插件的应用识别规则名称一致
+var containerBaselineApps = map[int]string{
+	6000: "kubelet",
+	6100: "docker",
+}
+
 // 获取符合该baseline下发条件的agent列表
 func getBaselineAgentList(beforeAgentList []string, baselineInfo BaselineInfo) (agentList []string) {
 	c := context.Background()
@@ -343,14 +363,15 @@ func getBaselineAgentList(beforeAgentList []string, baselineInfo BaselineInfo) (
 		AgentId string `json:"agent_id" bson:"agent_id"`
 	}
 
-	if baselineInfo.BaselineId != 6000 {
+	appName, isContainer := containerBaselineApps[baselineInfo.BaselineId]
+	if !isContainer {
 		// 获取符合条件的agent列表
 		searchFilter := make(map[string]interface{})
 		if len(beforeAgentList) != 0 {
 			searchFilter["agent_id"] = common.MongoInside{Inside: beforeAgentList}
 		}
 		searchFilter["last_heartbeat_time"] = bson.M{"$gte": time.Now().Unix() - asset_center.DEFAULT_OFFLINE_DURATION}
-		searchFilter["platform"] = common.MongoInside{Inside: baselineInfo.SystemList}
+		searchFilter["platform"] = common.MongoInside{Inside: familyPlatforms(baselineInfo.SystemList)}
 		findOption := options.Find().SetProjection(bson.M{"agent_id": 1})
 		cursor, _ := hbCol.Find(c, searchFilter, findOption)
 
@@ -364,15 +385,26 @@ func getBaselineAgentList(beforeAgentList []string, baselineInfo BaselineInfo) (
 		}
 		return
 	} else {
-		// 容器基线下发
-		cur, _ := appCol.Find(c, bson.M{"name": "kubelet"})
+		// 容器基线下发至运行对应容器组件的主机，同一主机可能有多个进程
+		searchFilter := bson.M{"name": appName}
+		if len(beforeAgentList) != 0 {
+			searchFilter["agent_id"] = common.MongoInside{Inside: beforeAgentList}
+		}
+		cur, err := appCol.Find(c, searchFilter, options.Find().SetProjection(bson.M{"agent_id": 1}))
+		if err != nil {
+			return
+		}
+		agentMap := make(map[string]bool)
 		for cur.Next(c) {
 			var agentStruct AgentStruct
 			err := cur.Decode(&agentStruct)
 			if err != nil {
 				return nil
 			}
-			agentList = append(agentList, agentStruct.AgentId)
+			if !agentMap[agentStruct.AgentId] {
+				agentMap[agentStruct.AgentId] = true
+				agentList = append(agentList, agentStruct.AgentId)
+			}
 		}
 		return
 	}
@@ -11,6 +11,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+const defaultFimRetentionDays = 30
+
 func initFingerPrint() {
 	err := AddJob("/api/v6/fingerprint/DescribeTop5", time.Minute*time.Duration(30), func() bson.M {
 		res := bson.M{}
@@ -94,4 +96,22 @@ func initFingerPrint() {
 	if err != nil {
 		ylog.Errorf("initFingerPrint", "AddJob error %s", err.Error())
 	}
+	err = AddJob("/api/v6/asset-center/fingerprint/FimRetention", time.Hour*24, func() bson.M {
+		// fim events are inserted as the change history, those older than the retention are removed.
+		// update_time is a unix timestamp, which a ttl index doesn't apply to.
+		days := infra.Conf.GetInt("fim.retention_days")
+		if days <= 0 {
+			days = defaultFimRetentionDays
+		}
+		collection := infra.MongoClient.Database(infra.MongoDatabase).Collection(infra.FingerprintFimCollection)
+		res, err := collection.DeleteMany(context.Background(), bson.M{"update_time": bson.M{"$lt": time.Now().Unix() - int64(days)*86400}})
+		if err != nil {
+			ylog.Errorf("Cronjob:/api/v6/asset-center/fingerprint/FimRetention", "DeleteMany error %s", err.Error())
+			return bson.M{"error": err.Error()}
+		}
+		return bson.M{"retention_days": days, "deleted": res.DeletedCount}
+	})
+	if err != nil {
+		ylog.Errorf("initFingerPrint", "AddJob error %s", err.Error())
+	}
 }
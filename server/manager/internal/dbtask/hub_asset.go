@@ -2,8 +2,11 @@ package dbtask
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bytedance/Elkeid/server/manager/infra/ylog"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,15 +19,30 @@ import (
 
 const (
 	fieldSeq = "package_seq"
-	dbName   = "agent_asset_%s"
+	// 采集插件每次全量采集结束时发送只包含package_seq及package_end的记录
+	fieldSeqEnd = "package_end"
+	dbName      = "agent_asset_%s"
+
+	integrityDataType = "5057"
+	integrityRestored = "restored"
+	fimDataType       = "5067"
+
+	// set by records of a filtered collector task
+	fieldTaskToken = "task_token"
+	fieldTaskScope = "task_scope"
+	maxTaskTokens  = 10000
 )
 
-var dtList = []string{"5050", "5051", "5052", "5053", "5054", "5055", "5056", "5057", "5058", "5059", "5060", "5061", "5062", "5063", "5064", "5065"}
+// record fields which a task scope may be made of
+var taskScopeFields = map[string]bool{"pid": true, "container_id": true, "exe": true, "name": true, "image_id": true, "path": true}
+
+var dtList = []string{"5050", "5051", "5052", "5053", "5054", "5055", "5056", "5057", "5058", "5059", "5060", "5061", "5062", "5063", "5064", "5065", "5066", "5067", "5068", "5069", "5070"}
 
 type hubAssetWriter struct {
 	queue       chan interface{}
 	workerQueue map[string]chan interface{}  //datatype --> chan
 	seqCache    map[string]map[string]string //datatype --> agentID --> fieldSeq
+	taskCache   map[string]map[string]bool   //datatype --> agentID+taskToken+taskScope
 
 	commCache *map[string]bool //map指针
 }
@@ -48,6 +66,11 @@ func (w *hubAssetWriter) Init() {
 		w.seqCache[v] = make(map[string]string, 200)
 	}
 
+	w.taskCache = make(map[string]map[string]bool, len(dtList))
+	for _, v := range dtList {
+		w.taskCache[v] = make(map[string]bool, 200)
+	}
+
 	//常见不常见进程
 	w.updateCommCache()
 	tk := time.NewTicker(time.Hour)
@@ -151,6 +174,22 @@ func (w *hubAssetWriter) WriterRun(dt string) {
 			if !ok {
 				continue
 			}
+			// 全量采集结束，没有上报记录(如主机已无风险)时也清空之前的数据；
+			// 完整性及FIM数据为增量上报，不清空
+			if end, _ := item[fieldSeqEnd].(string); end == "true" {
+				// 带过滤条件的采集结束时清空其范围，即使没有采集到记录
+				if token, _ := item[fieldTaskToken].(string); token != "" {
+					scope, _ := item[fieldTaskScope].(string)
+					w.clearTaskScope(col, dt, agentID, token, scope)
+				} else if dt != integrityDataType && dt != fimDataType && w.seqCache[dt][agentID] != seq {
+					_, err := col.DeleteMany(context.Background(), bson.M{"agent_id": agentID, fieldSeq: bson.M{"$ne": seq}})
+					if err != nil {
+						ylog.Errorf("hubAssetWriter", "%s, %s, %s, DeleteMany %s", agentID, seq, dbName, err.Error())
+					}
+					w.seqCache[dt][agentID] = seq
+				}
+				continue
+			}
 			if tmp, ok := item["in_ipv4_list"].(string); ok && tmp != "" {
 				item["intranet_ipv4"] = strings.Split(tmp, ",")
 			} else {
@@ -208,6 +247,42 @@ func (w *hubAssetWriter) WriterRun(dt string) {
 				}
 			}
 
+			// integrity records are incremental, only newly drifted or
+			// restored files are reported instead of a full snapshot
+			if dt == integrityDataType {
+				filter := bson.M{"agent_id": agentID, "exe": item["exe"]}
+				if item["status"] == integrityRestored {
+					writes = append(writes, mongo.NewDeleteOneModel().SetFilter(filter))
+				} else {
+					item["update_time"] = time.Now().Unix()
+					writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(item).SetUpsert(true))
+				}
+				count++
+				break
+			}
+			// fim events are kept as the change history
+			if dt == fimDataType {
+				item["update_time"] = time.Now().Unix()
+				writes = append(writes, mongo.NewInsertOneModel().SetDocument(item))
+				count++
+				break
+			}
+
+			// records of a filtered task replace the ones in its scope, they
+			// join the current snapshot so that the next full run cleans them up
+			if token, ok := item[fieldTaskToken].(string); ok && token != "" {
+				scope, _ := item[fieldTaskScope].(string)
+				delete(item, fieldTaskScope)
+				w.clearTaskScope(col, dt, agentID, token, scope)
+				if s, ok := w.seqCache[dt][agentID]; ok {
+					item[fieldSeq] = s
+				}
+				item["update_time"] = time.Now().Unix()
+				writes = append(writes, mongo.NewInsertOneModel().SetDocument(item))
+				count++
+				break
+			}
+
 			if w.seqCache[dt][agentID] != seq {
 				//清空旧数据(所有seq不相等的)
 				_, err := col.DeleteMany(context.Background(), bson.M{"agent_id": agentID, fieldSeq: bson.M{"$ne": seq}})
@@ -252,6 +327,64 @@ func (w *hubAssetWriter) WriterRun(dt string) {
 	}
 }
 
+// clearTaskScope removes the records in the scope of a filtered task once,
+// before its first record or on its end.
+func (w *hubAssetWriter) clearTaskScope(col *mongo.Collection, dt, agentID, token, scope string) {
+	key := agentID + token + scope
+	if w.taskCache[dt][key] {
+		return
+	}
+	if len(w.taskCache[dt]) >= maxTaskTokens {
+		w.taskCache[dt] = make(map[string]bool, 200)
+	}
+	w.taskCache[dt][key] = true
+	filter, err := taskScopeFilter(scope)
+	if err != nil {
+		ylog.Errorf("hubAssetWriter", "%s, %s, %s, invalid task scope %s", agentID, token, col.Name(), err.Error())
+		return
+	}
+	filter["agent_id"] = agentID
+	if _, err = col.DeleteMany(context.Background(), filter); err != nil {
+		ylog.Errorf("hubAssetWriter", "%s, %s, %s, DeleteMany %s", agentID, token, col.Name(), err.Error())
+	}
+}
+
+// taskScopeFilter converts a task scope, {"<field>": {"in"|"prefix"|"dir"|"eq": ...}},
+// to the filter of the records it covers.
+func taskScopeFilter(scope string) (bson.M, error) {
+	m := map[string]struct {
+		In     []string `json:"in"`
+		Prefix string   `json:"prefix"`
+		Dir    string   `json:"dir"`
+		Eq     string   `json:"eq"`
+	}{}
+	if err := json.Unmarshal([]byte(scope), &m); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, errors.New("empty scope")
+	}
+	filter := bson.M{}
+	for k, v := range m {
+		if !taskScopeFields[k] {
+			return nil, fmt.Errorf("unknown field %s", k)
+		}
+		switch {
+		case len(v.In) != 0:
+			filter[k] = bson.M{"$in": v.In}
+		case v.Prefix != "":
+			filter[k] = bson.M{"$regex": "^" + regexp.QuoteMeta(v.Prefix)}
+		case v.Dir != "":
+			filter[k] = bson.M{"$regex": "^" + regexp.QuoteMeta(strings.TrimSuffix(v.Dir, "/")) + "(/|$)"}
+		case v.Eq != "":
+			filter[k] = v.Eq
+		default:
+			return nil, fmt.Errorf("empty condition of %s", k)
+		}
+	}
+	return filter, nil
+}
+
 func (w *hubAssetWriter) Add(v interface{}) {
 	select {
 	case w.queue <- v:
@@ -42,8 +42,19 @@ type CheckInfo struct {
 	SolutionCn    string `yaml:"solution_cn" bson:"solution_cn" json:"solution_cn"`
 	UpdateTime    int64  `yaml:"update_time" bson:"update_time" json:"update_time"`
 
-	Result int    `json:"result" bson:"result"`
-	Msg    string `json:"msg" bson:"msg"`
+	Result   int             `json:"result" bson:"result"`
+	Msg      string          `json:"msg" bson:"msg"`
+	Evidence []CheckEvidence `json:"evidence" bson:"evidence"`
+}
+
+// 检查项证据，插件记录的每条规则的实际值
+type CheckEvidence struct {
+	Type   string      `json:"type" bson:"type"`
+	File   string      `json:"file" bson:"file"`
+	Line   string      `json:"line" bson:"line"`
+	Value  interface{} `json:"value" bson:"value"`
+	Expect string      `json:"expect" bson:"expect"`
+	Pass   bool        `json:"pass" bson:"pass"`
 }
 
 type BaselineCheckInfo struct {
@@ -85,6 +96,8 @@ type AgentBaselineInfo struct {
 	Tags         []string `json:"tags" bson:"tags"`
 	ExtranetIpv4 []string `json:"extranet_ipv4" bson:"extranet_ipv4"`
 	IntranetIpv4 []string `json:"intranet_ipv4" bson:"intranet_ipv4"`
+
+	Evidence []CheckEvidence `json:"evidence" bson:"evidence"`
 }
 
 // 基线策略组状态
@@ -195,6 +208,7 @@ func DealBaselineList(agentBaseline AgentBaseline) []mongo.WriteModel {
 			DescriptionCn:   checkInfo.DescriptionCn,
 			SolutionCn:      checkInfo.SolutionCn,
 			Type:            checkInfo.Type,
+			Evidence:        checkInfo.Evidence,
 		}
 
 		switch checkInfo.Result {
@@ -5,6 +5,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
@@ -80,6 +81,84 @@ func CreateCert(commonName string, duration time.Duration) (key, cert []byte, er
 	return key, cert, nil
 }
 
+// SignCertRequest 使用CA签发证书请求，证书的subject由调用方指定，忽略csr中的subject
+func SignCertRequest(csrPem []byte, subject pkix.Name, duration time.Duration) (cert []byte, serial *big.Int, err error) {
+	if caCert == nil || caKey == nil {
+		return nil, nil, errors.New("ca is not set")
+	}
+
+	block, _ := pem.Decode(csrPem)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, errors.New("invalid certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, nil, err
+	}
+	switch pub := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, nil, errors.New("rsa key is too short")
+		}
+	default:
+		return nil, nil, errors.New("unsupported public key type")
+	}
+
+	serial, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	// 允许一定的时钟偏差
+	notBefore := time.Now().Add(-time.Hour)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(duration),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeCert(derBytes), serial, nil
+}
+
+// CreateRevocationList 生成CA签名的证书吊销列表
+func CreateRevocationList(revoked []pkix.RevokedCertificate, number int64, nextUpdate time.Duration) ([]byte, error) {
+	if caCert == nil || caKey == nil {
+		return nil, errors.New("ca is not set")
+	}
+
+	// 没有扩展的CA(如v1证书)允许所有用途，标准库要求issuer显式包含CRLSign及SubjectKeyId，
+	// SubjectKeyId按RFC 5280 4.2.1.2的方法由公钥计算
+	issuer := *caCert
+	issuer.KeyUsage |= x509.KeyUsageCRLSign
+	if len(issuer.SubjectKeyId) == 0 {
+		ski := sha1.Sum(x509.MarshalPKCS1PublicKey(&caKey.PublicKey))
+		issuer.SubjectKeyId = ski[:]
+	}
+	now := time.Now()
+	template := x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              big.NewInt(number),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(nextUpdate),
+	}
+	derBytes, err := x509.CreateRevocationList(rand.Reader, &template, &issuer, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: derBytes}), nil
+}
+
 func loadX509KeyPair(certFile, keyFile string) (*x509.Certificate, *rsa.PrivateKey, error) {
 	cf, err := os.ReadFile(certFile)
 	if err != nil {